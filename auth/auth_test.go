@@ -0,0 +1,394 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/bsm/redeo/redeotest"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+func TestHashPassword(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		prefix    string
+		wantErr   bool
+	}{
+		{algorithm: Bcrypt, prefix: "$2a$"},
+		{algorithm: Argon2id, prefix: "$argon2id$v=19$m=65536,t=1,p=4$"},
+		{algorithm: "md5", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing HashPassword: "+tt.algorithm, func(t *testing.T) {
+			hash, err := HashPassword("secret", tt.algorithm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("HashPassword() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !strings.HasPrefix(hash, tt.prefix) || !isHash(hash) {
+				t.Errorf("HashPassword() = %q, want prefix %q", hash, tt.prefix)
+			}
+			if !CheckPassword(hash, "secret") {
+				t.Error("CheckPassword() rejected the right password")
+			}
+			if CheckPassword(hash, "secret2") {
+				t.Error("CheckPassword() accepted a wrong password")
+			}
+		})
+	}
+}
+
+func TestUser_CanAccess(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		want    bool
+	}{
+		{pattern: "*", key: "a/b:c", want: true},
+		{pattern: "*", key: "", want: true},
+		{pattern: "cache:*", key: "cache:", want: true},
+		{pattern: "cache:*", key: "cache:a/b", want: true},
+		{pattern: "cache:*", key: "session:1", want: false},
+		{pattern: "cache:*", key: "", want: false},
+		{pattern: "user:?", key: "user:1", want: true},
+		{pattern: "user:?", key: "user:12", want: false},
+		{pattern: "user:[0-9]", key: "user:7", want: true},
+		{pattern: "user:[!0-9]", key: "user:7", want: false},
+		{pattern: `a\*`, key: "a*", want: true},
+		{pattern: `a\*`, key: "ab", want: false},
+		{pattern: "a.b", key: "axb", want: false},
+		{pattern: "ключ:*", key: "ключ:1", want: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing CanAccess("+tt.pattern+", "+tt.key+")", func(t *testing.T) {
+			u := User{Name: DefaultUser, Keys: []string{tt.pattern}}
+			if err := u.compile(); err != nil {
+				t.Fatal(err)
+			}
+			if got := u.CanAccess(tt.key); got != tt.want {
+				t.Errorf("CanAccess() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// testUsers администратор admin, читатель reader ключей cache:* и пользователь default без прав
+func testUsers(t *testing.T) []User {
+	hash, err := HashPassword("secret", Argon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return []User{
+		{Name: "admin", Password: hash, Categories: Categories, Keys: []string{"*"}},
+		{Name: "reader", Password: hash, Categories: []Category{Read}, Keys: []string{"cache:*"}},
+		{Name: DefaultUser, Categories: []Category{}, Keys: []string{}},
+	}
+}
+
+// writeUsers файл пользователей во временном каталоге
+func writeUsers(t *testing.T, users []User) string {
+	dir, err := ioutil.TempDir("", "auth")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "users.yaml")
+	if err := writeFile(path, file{Users: users}); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStore(t *testing.T) {
+	path := writeUsers(t, testUsers(t))
+	s, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("Testing Authenticate", func(t *testing.T) {
+		tests := []struct {
+			user, password string
+			want           error
+		}{
+			{user: "admin", password: "secret"},
+			// Повторная проверка из кеша
+			{user: "admin", password: "secret"},
+			{user: "admin", password: "wrong", want: structs.ErrWrongPass},
+			{user: "nobody", password: "secret", want: structs.ErrWrongPass},
+			{user: DefaultUser, password: "", want: structs.ErrWrongPass},
+		}
+		for _, tt := range tests {
+			if err := s.Authenticate(tt.user, tt.password); err != tt.want {
+				t.Errorf("Authenticate(%s, %s) = %v, want %v", tt.user, tt.password, err, tt.want)
+			}
+		}
+	})
+
+	t.Run("Testing Authorize", func(t *testing.T) {
+		tests := []struct {
+			user     string
+			category Category
+			key      string
+			want     error
+		}{
+			{user: "admin", category: Admin},
+			{user: "admin", category: Write, key: "any"},
+			{user: "reader", category: Read, key: "cache:1"},
+			{user: "reader", category: Read, key: "session:1", want: structs.ErrNoPerm},
+			{user: "reader", category: Read, key: "", want: structs.ErrNoPerm},
+			{user: "reader", category: Write, key: "cache:1", want: structs.ErrNoPerm},
+			{user: DefaultUser, category: Read, key: "cache:1", want: structs.ErrNoPerm},
+			{user: "nobody", category: Read, key: "cache:1", want: structs.ErrNoAuth},
+		}
+		for _, tt := range tests {
+			if err := s.Authorize(tt.user, tt.category, tt.key); err != tt.want {
+				t.Errorf("Authorize(%s, %s, %q) = %v, want %v", tt.user, tt.category, tt.key, err, tt.want)
+			}
+		}
+	})
+
+	t.Run("Testing Put and Remove persist", func(t *testing.T) {
+		hash, err := HashPassword("other", Bcrypt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Put(User{Name: "writer", Password: hash, Categories: []Category{Write}, Keys: []string{"*"}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Remove("reader"); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Remove("reader"); !errors.Is(err, structs.ErrNoUser) {
+			t.Errorf("Remove() of a missing user error = %v, want %v", err, structs.ErrNoUser)
+		}
+
+		loaded, err := Load(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(names(loaded.Users()), []string{"admin", DefaultUser, "writer"}) {
+			t.Errorf("saved users = %v", names(loaded.Users()))
+		}
+		if err := loaded.Authenticate("writer", "other"); err != nil {
+			t.Errorf("Authenticate() of a saved user error = %v", err)
+		}
+	})
+
+	t.Run("Testing invalid users are rejected", func(t *testing.T) {
+		tests := []User{
+			{Name: "plain", Password: "secret", Categories: []Category{Read}},
+			{Name: "nopass", Categories: []Category{Read}},
+			{Name: "bad:name", Password: testUsers(t)[0].Password},
+			{Name: "cat", Password: testUsers(t)[0].Password, Categories: []Category{"delete"}},
+			{Name: "glob", Password: testUsers(t)[0].Password, Keys: []string{"[a-"}},
+		}
+		for _, u := range tests {
+			if err := s.Put(u); err == nil {
+				t.Errorf("Put(%s) succeeded", u.Name)
+			}
+		}
+	})
+
+	t.Run("Testing Reload", func(t *testing.T) {
+		if err := ioutil.WriteFile(path, []byte("users:\n  - name: default\n    categories: [read]\n    keys: ['*']\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Authenticate("admin", "secret"); err != structs.ErrWrongPass {
+			t.Errorf("removed user authenticated: %v", err)
+		}
+		if err := ioutil.WriteFile(path, []byte("users:\n  - nme: default\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Reload(); err == nil {
+			t.Error("Reload() of an invalid file succeeded")
+		}
+		if err := s.Authorize(DefaultUser, Read, "key"); err != nil {
+			t.Errorf("users changed after a failed reload: %v", err)
+		}
+	})
+}
+
+func TestFromAccounts(t *testing.T) {
+	hash, err := HashPassword("hashed", Bcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := FromAccounts(map[string]string{"plain": "secret", "hashed": hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Authenticate("plain", "secret"); err != nil {
+		t.Errorf("Authenticate(plain) error = %v", err)
+	}
+	if err := s.Authenticate("hashed", "hashed"); err != nil {
+		t.Errorf("Authenticate(hashed) error = %v", err)
+	}
+	for _, name := range []string{"plain", "hashed", DefaultUser} {
+		if err := s.Authorize(name, Admin, ""); err != nil {
+			t.Errorf("Authorize(%s) error = %v", name, err)
+		}
+	}
+}
+
+func TestStore_AuthenticateConcurrent(t *testing.T) {
+	hash, err := HashPassword("secret", Bcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := FromAccounts(map[string]string{"admin": hash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Одновременные проверки одного пароля ждут одну проверку по хешу
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 20; i++ {
+		for _, tt := range []struct {
+			password string
+			want     error
+		}{{password: "secret"}, {password: "wrong", want: structs.ErrWrongPass}} {
+			wg.Add(1)
+			go func(password string, want error) {
+				defer wg.Done()
+				if err := s.Authenticate("admin", password); err != want {
+					errs <- fmt.Errorf("Authenticate(admin, %s) = %v, want %v", password, err, want)
+				}
+			}(tt.password, tt.want)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if len(s.checks) != 0 {
+		t.Errorf("checks = %d after Authenticate(), want 0", len(s.checks))
+	}
+}
+
+func TestStore_ServeRedeo(t *testing.T) {
+	s, err := NewStore(testUsers(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		user    string
+		command []string
+		want    interface{}
+	}{
+		{
+			name:    "whoami",
+			user:    "reader",
+			command: []string{"whoami"},
+			want:    "reader",
+		},
+		{
+			name:    "whoami without auth",
+			command: []string{"whoami"},
+			want:    DefaultUser,
+		},
+		{
+			name:    "list requires admin",
+			user:    "reader",
+			command: []string{"list"},
+			want:    redeotest.ErrorResponse(structs.ErrNoPerm.Error()),
+		},
+		{
+			name:    "setuser",
+			user:    "admin",
+			command: []string{"setuser", "writer", "pass", "read,write", "cache:*", "session:*"},
+			want:    "OK",
+		},
+		{
+			name:    "setuser keeps password",
+			user:    "admin",
+			command: []string{"setuser", "writer", "-", "write", "cache:*"},
+			want:    "OK",
+		},
+		{
+			name:    "setuser unknown category",
+			user:    "admin",
+			command: []string{"setuser", "writer", "pass", "read,delete"},
+			want:    redeotest.ErrorResponse(`unknown category "delete", want read, write or admin`),
+		},
+		{
+			name:    "list",
+			user:    "admin",
+			command: []string{"list"},
+			want: []interface{}{
+				"user admin categories=read,write,admin keys=*",
+				"user default categories= keys=",
+				"user reader categories=read keys=cache:*",
+				"user writer categories=write keys=cache:*",
+			},
+		},
+		{
+			name:    "deluser",
+			user:    "admin",
+			command: []string{"deluser", "reader"},
+			want:    "OK",
+		},
+		{
+			name:    "deluser missing",
+			user:    "admin",
+			command: []string{"deluser", "reader"},
+			want:    redeotest.ErrorResponse("no such user: reader"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing acl "+tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.user != "" {
+				ctx = WithUser(ctx, tt.user)
+			}
+			cmd := resp.NewCommand("acl", stringArgs(tt.command)...)
+			cmd.SetContext(ctx)
+			w := redeotest.NewRecorder()
+			s.ServeRedeo(w, cmd)
+			got, err := w.Response()
+			if err != nil {
+				got = err
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	if err := s.Authenticate("writer", "pass"); err != nil {
+		t.Errorf("setuser with - changed the password: %v", err)
+	}
+}
+
+func names(users []User) []string {
+	var result []string
+	for _, u := range users {
+		result = append(result, u.Name)
+	}
+	return result
+}
+
+func stringArgs(args []string) []resp.CommandArgument {
+	result := make([]resp.CommandArgument, len(args))
+	for i, arg := range args {
+		result[i] = resp.CommandArgument(arg)
+	}
+	return result
+}
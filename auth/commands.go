@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"strings"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+)
+
+const errACLMsg = `Users
+Examples:
+  acl whoami
+  acl list
+  acl setuser alice secret read,write cache:* session:*
+  acl setuser alice - read cache:*
+  acl deluser alice
+`
+
+// ServeRedeo обработка команды acl. Изменение пользователей требует категории admin,
+// в acl setuser пароль - заменяет только права
+func (s *Store) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		w.AppendError(errACLMsg)
+		return
+	}
+	caller := UserFromContext(c.Context())
+
+	sub := strings.ToLower(c.Arg(0).String())
+	if sub == "whoami" {
+		w.AppendBulkString(caller)
+		return
+	}
+	if err := s.Can(caller, Admin); err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+
+	switch {
+	case sub == "list" && c.ArgN() == 1:
+		users := s.Users()
+		w.AppendArrayLen(len(users))
+		for _, u := range users {
+			w.AppendBulkString(u.String())
+		}
+	case sub == "setuser" && c.ArgN() >= 4:
+		if err := s.setUser(c.Arg(1).String(), c.Arg(2).String(), c.Arg(3).String(), c.Args[4:]); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	case sub == "deluser" && c.ArgN() == 2:
+		if err := s.Remove(c.Arg(1).String()); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	case sub == "list" || sub == "setuser" || sub == "deluser":
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name + " " + sub))
+		w.AppendError(errACLMsg)
+	default:
+		w.AppendError(redeo.UnknownCommand(c.Name + " " + sub))
+	}
+}
+
+// setUser acl setuser <name> <password|-> <categories> [pattern ...]
+func (s *Store) setUser(name, password, categories string, patterns []resp.CommandArgument) error {
+	u := User{Name: name, Keys: []string{}}
+	var err error
+	if u.Categories, err = ParseCategories(categories); err != nil {
+		return err
+	}
+	for _, p := range patterns {
+		u.Keys = append(u.Keys, p.String())
+	}
+
+	if password == "-" {
+		current, _ := s.User(name)
+		u.Password = current.Password
+	} else if u.Password, err = HashPassword(password, Bcrypt); err != nil {
+		return err
+	}
+	return s.Put(u)
+}
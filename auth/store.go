@@ -0,0 +1,334 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/geraev/gokvserver/structs"
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v2"
+)
+
+// file формат файла пользователей
+type file struct {
+	Users []User `yaml:"users"`
+}
+
+// Store пользователи сервера. Изменения, сделанные во время работы, сохраняются в файл, из которого
+// пользователи загружены
+type Store struct {
+	path string
+	// secret ключ HMAC для кеша проверенных паролей
+	secret []byte
+
+	mu    sync.RWMutex
+	users map[string]*User
+	// verified HMAC паролей, успешно проверенных по хешу: bcrypt и argon2 намеренно медленные,
+	// а HTTP клиент передаёт пароль в каждом запросе
+	verified map[string][]byte
+	// checks выполняемые проверки по имени пользователя и HMAC пароля: одновременные запросы
+	// с одним паролем ждут одну проверку по хешу
+	checks map[string]*passwordCheck
+}
+
+// passwordCheck проверка пароля по хешу, результат которой доступен после закрытия done
+type passwordCheck struct {
+	done chan struct{}
+	ok   bool
+}
+
+// NewStore хранилище пользователей users без файла
+func NewStore(users []User) (*Store, error) {
+	secret := make([]byte, sha256.Size)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	s := &Store{secret: secret}
+	if err := s.Replace(users); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Load хранилище пользователей из YAML файла path
+func Load(path string) (*Store, error) {
+	users, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := NewStore(users)
+	if err != nil {
+		return nil, fmt.Errorf("users %s: %w", path, err)
+	}
+	s.path = path
+	return s, nil
+}
+
+// FromAccounts хранилище учётных записей вида имя - пароль (или хеш пароля) со всеми правами
+// и пользователем default, также со всеми правами: так ведёт себя сервер без файла пользователей
+func FromAccounts(accounts map[string]string) (*Store, error) {
+	users, err := AccountUsers(accounts)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(users)
+}
+
+// AccountUsers пользователи со всеми правами из учётных записей вида имя - пароль (или хеш пароля)
+// и пользователь default
+func AccountUsers(accounts map[string]string) ([]User, error) {
+	users := []User{{Name: DefaultUser, Categories: Categories, Keys: []string{"*"}}}
+	for name, password := range accounts {
+		hash := password
+		if !isHash(password) {
+			// Хеш не держит открытый пароль в памяти. Проверка пароля кешируется,
+			// поэтому стоимость по умолчанию не замедляет запросы
+			h, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+			if err != nil {
+				return nil, err
+			}
+			hash = string(h)
+		}
+		users = append(users, User{Name: name, Password: hash, Categories: Categories, Keys: []string{"*"}})
+	}
+	return users, nil
+}
+
+func readFile(path string) ([]User, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f file
+	if err := yaml.UnmarshalStrict(data, &f); err != nil {
+		return nil, fmt.Errorf("users %s: %w", path, err)
+	}
+	return f.Users, nil
+}
+
+// Reload повторное чтение файла пользователей. Хранилище без файла не изменяется
+func (s *Store) Reload() error {
+	if s.path == "" {
+		return nil
+	}
+	users, err := readFile(s.path)
+	if err != nil {
+		return err
+	}
+	return s.Replace(users)
+}
+
+// Replace замена всех пользователей. Файл не изменяется
+func (s *Store) Replace(users []User) error {
+	byName := make(map[string]*User, len(users))
+	for _, u := range users {
+		u := u
+		if err := u.compile(); err != nil {
+			return err
+		}
+		if _, ok := byName[u.Name]; ok {
+			return fmt.Errorf("duplicate user %s", u.Name)
+		}
+		byName[u.Name] = &u
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users = byName
+	s.verified = make(map[string][]byte)
+	return nil
+}
+
+// Users пользователи, упорядоченные по имени
+func (s *Store) Users() []User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, *u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// User пользователь по имени
+func (s *Store) User(name string) (User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	if !ok {
+		return User{}, false
+	}
+	return *u, true
+}
+
+// Put добавление или изменение пользователя с сохранением в файл
+func (s *Store) Put(u User) error {
+	if err := u.compile(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	users := s.copyUsers()
+	users[u.Name] = &u
+	return s.commit(users)
+}
+
+// Remove удаление пользователя с сохранением в файл
+func (s *Store) Remove(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[name]; !ok {
+		return fmt.Errorf("%w: %s", structs.ErrNoUser, name)
+	}
+	users := s.copyUsers()
+	delete(users, name)
+	return s.commit(users)
+}
+
+func (s *Store) copyUsers() map[string]*User {
+	users := make(map[string]*User, len(s.users))
+	for name, u := range s.users {
+		users[name] = u
+	}
+	return users
+}
+
+// commit сохранение пользователей в файл и замена действующих. Вызывается под блокировкой
+func (s *Store) commit(users map[string]*User) error {
+	if s.path != "" {
+		var f file
+		for _, u := range users {
+			f.Users = append(f.Users, *u)
+		}
+		sort.Slice(f.Users, func(i, j int) bool { return f.Users[i].Name < f.Users[j].Name })
+		if err := writeFile(s.path, f); err != nil {
+			return err
+		}
+	}
+	s.users = users
+	s.verified = make(map[string][]byte)
+	return nil
+}
+
+// writeFile атомарная запись файла пользователей: файл не остаётся записанным наполовину
+func writeFile(path string, f file) error {
+	data, err := yaml.Marshal(f)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Authenticate проверка пароля пользователя
+func (s *Store) Authenticate(name, password string) error {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(password))
+	sum := mac.Sum(nil)
+
+	s.mu.RLock()
+	u, ok := s.users[name]
+	cached, verified := s.verified[name]
+	s.mu.RUnlock()
+	if !ok || u.Password == "" {
+		return structs.ErrWrongPass
+	}
+	if verified && hmac.Equal(cached, sum) {
+		return nil
+	}
+
+	key := name + "\x00" + string(sum)
+	s.mu.Lock()
+	c, running := s.checks[key]
+	if !running {
+		c = &passwordCheck{done: make(chan struct{})}
+		if s.checks == nil {
+			s.checks = make(map[string]*passwordCheck)
+		}
+		s.checks[key] = c
+	}
+	s.mu.Unlock()
+
+	if !running {
+		c.ok = CheckPassword(u.Password, password)
+		s.mu.Lock()
+		delete(s.checks, key)
+		// Пользователь мог измениться, пока проверялся пароль
+		if c.ok && s.users[name] == u {
+			s.verified[name] = sum
+		}
+		s.mu.Unlock()
+		close(c.done)
+	}
+	<-c.done
+	if !c.ok {
+		return structs.ErrWrongPass
+	}
+	return nil
+}
+
+// Can разрешена ли пользователю категория команд
+func (s *Store) Can(name string, category Category) error {
+	s.mu.RLock()
+	u, ok := s.users[name]
+	s.mu.RUnlock()
+	if !ok {
+		return structs.ErrNoAuth
+	}
+	if !u.Can(category) {
+		return structs.ErrNoPerm
+	}
+	return nil
+}
+
+// Authorize разрешена ли пользователю категория команд над ключом. Пустой ключ - операция
+// над всем пространством ключей
+func (s *Store) Authorize(name string, category Category, key string) error {
+	s.mu.RLock()
+	u, ok := s.users[name]
+	s.mu.RUnlock()
+	if !ok {
+		return structs.ErrNoAuth
+	}
+	return u.Authorize(category, key)
+}
+
+type userKey struct{}
+
+// WithUser контекст запроса пользователя name
+func WithUser(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, userKey{}, name)
+}
+
+// UserFromContext имя пользователя, выполняющего запрос. Запрос без пользователя выполняется от имени DefaultUser
+func UserFromContext(ctx context.Context) string {
+	if name, ok := ctx.Value(userKey{}).(string); ok {
+		return name
+	}
+	return DefaultUser
+}
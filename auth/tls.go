@@ -0,0 +1,146 @@
+package auth
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"sync"
+)
+
+// TLSVersions минимальные версии TLS по названию
+var TLSVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSVersionNames названия версий TLS по возрастанию
+func TLSVersionNames() []string {
+	names := make([]string, 0, len(TLSVersions))
+	for name := range TLSVersions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Проверка сертификатов клиентов
+const (
+	// ClientCertNone сертификат клиента не запрашивается
+	ClientCertNone = "none"
+	// ClientCertOptional предъявленный сертификат проверяется, клиент без сертификата входит паролем
+	ClientCertOptional = "optional"
+	// ClientCertRequire соединения без действительного сертификата отклоняются
+	ClientCertRequire = "require"
+)
+
+// ClientAuthType режим проверки сертификатов клиентов по названию
+func ClientAuthType(name string) (tls.ClientAuthType, error) {
+	switch name {
+	case ClientCertNone:
+		return tls.NoClientCert, nil
+	case ClientCertOptional:
+		return tls.VerifyClientCertIfGiven, nil
+	case ClientCertRequire:
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown client certificate mode %q, want none, optional or require", name)
+}
+
+// Certificates сертификат сервера и удостоверяющие центры сертификатов клиентов. Файлы перечитываются
+// Reload без перезапуска: новые соединения используют новые сертификаты
+type Certificates struct {
+	certFile, keyFile string
+	// caFile сертификаты удостоверяющих центров клиентов в PEM, пустое значение - клиенты не проверяются
+	caFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	pool *x509.CertPool
+}
+
+// LoadCertificates сертификат и закрытый ключ сервера из файлов PEM, caFile - сертификаты
+// удостоверяющих центров клиентов (может быть пустым)
+func LoadCertificates(certFile, keyFile, caFile string) (*Certificates, error) {
+	c := &Certificates{certFile: certFile, keyFile: keyFile, caFile: caFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload повторное чтение файлов. При ошибке остаются прежние сертификаты
+func (c *Certificates) Reload() error {
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("tls certificate %s: %w", c.certFile, err)
+	}
+	var pool *x509.CertPool
+	if c.caFile != "" {
+		data, err := ioutil.ReadFile(c.caFile)
+		if err != nil {
+			return err
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("tls client ca %s: no certificates found", c.caFile)
+		}
+	}
+
+	c.mu.Lock()
+	c.cert, c.pool = &cert, pool
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *Certificates) current() (*tls.Certificate, *x509.CertPool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.cert, c.pool
+}
+
+// ServerConfig настройки TLS сервера. Без удостоверяющих центров клиентов clientAuth не действует
+func (c *Certificates) ServerConfig(minVersion uint16, clientAuth tls.ClientAuthType) *tls.Config {
+	return &tls.Config{
+		MinVersion: minVersion,
+		// Настройки собираются для каждого соединения, чтобы учесть перечитанные файлы
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cert, pool := c.current()
+			cfg := &tls.Config{
+				MinVersion:   minVersion,
+				Certificates: []tls.Certificate{*cert},
+			}
+			if pool != nil {
+				cfg.ClientCAs, cfg.ClientAuth = pool, clientAuth
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig настройки TLS соединений с другими узлами: сертификат сервера предъявляется
+// как сертификат клиента, сертификаты узлов проверяются удостоверяющими центрами клиентов
+// (без них - системными)
+func (c *Certificates) ClientConfig(minVersion uint16) *tls.Config {
+	_, pool := c.current()
+	return &tls.Config{
+		MinVersion: minVersion,
+		RootCAs:    pool,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, _ := c.current()
+			return cert, nil
+		},
+	}
+}
+
+// CertificateUser имя пользователя из проверенного сертификата клиента: CommonName субъекта
+func CertificateUser(state *tls.ConnectionState) (string, bool) {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	name := state.VerifiedChains[0][0].Subject.CommonName
+	return name, name != ""
+}
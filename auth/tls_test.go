@@ -0,0 +1,251 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCA удостоверяющий центр, выпускающий сертификаты во временном каталоге
+type testCA struct {
+	dir    string
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	file   string
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ca := &testCA{dir: dir, serial: 1}
+	ca.cert, ca.key, ca.file, _ = ca.issue(t, "test ca", true)
+	return ca
+}
+
+// issue сертификат с CommonName name, подписанный центром (или самоподписанный для самого центра)
+func (ca *testCA) issue(t *testing.T, name string, isCA bool) (*x509.Certificate, *ecdsa.PrivateKey, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, parentKey := ca.cert, ca.key
+	if isCA {
+		template.IsCA, template.BasicConstraintsValid = true, true
+		template.KeyUsage |= x509.KeyUsageCertSign
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(ca.dir, name+".pem")
+	keyFile := filepath.Join(ca.dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, key, certFile, keyFile
+}
+
+// handshake установка соединения TLS через loopback. Возвращает состояние соединения на стороне сервера
+func handshake(server, client *tls.Config) (tls.ConnectionState, error) {
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", server)
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	defer lis.Close()
+
+	type result struct {
+		state tls.ConnectionState
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		cn, err := lis.Accept()
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer cn.Close()
+		tc := cn.(*tls.Conn)
+		tc.SetDeadline(time.Now().Add(5 * time.Second))
+		err = tc.Handshake()
+		results <- result{state: tc.ConnectionState(), err: err}
+	}()
+
+	cn, err := tls.DialWithDialer(&net.Dialer{Timeout: 5 * time.Second}, "tcp", lis.Addr().String(), client)
+	if err == nil {
+		// В TLS 1.3 сервер проверяет сертификат клиента после завершения установки на стороне клиента
+		cn.SetDeadline(time.Now().Add(5 * time.Second))
+		_, err = cn.Read(make([]byte, 1))
+		if err == io.EOF {
+			err = nil
+		}
+		cn.Close()
+	}
+	r := <-results
+	if r.err != nil {
+		return tls.ConnectionState{}, r.err
+	}
+	return r.state, err
+}
+
+func TestCertificates(t *testing.T) {
+	ca := newTestCA(t)
+	_, _, serverCert, serverKey := ca.issue(t, "server", false)
+	_, _, readerCert, readerKey := ca.issue(t, "reader", false)
+	other := newTestCA(t)
+	_, _, strangerCert, strangerKey := other.issue(t, "stranger", false)
+
+	certs, err := LoadCertificates(serverCert, serverKey, ca.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	clientConfig := func(certFile, keyFile string, maxVersion uint16) *tls.Config {
+		cfg := &tls.Config{RootCAs: roots, ServerName: "localhost", MaxVersion: maxVersion}
+		if certFile != "" {
+			cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cfg.Certificates = []tls.Certificate{cert}
+		}
+		return cfg
+	}
+
+	peer := certs.ClientConfig(tls.VersionTLS12)
+	peer.ServerName = "localhost"
+
+	tests := []struct {
+		name       string
+		clientAuth tls.ClientAuthType
+		minVersion uint16
+		client     *tls.Config
+		wantUser   string
+		wantErr    bool
+	}{
+		{
+			name:       "client certificate",
+			clientAuth: tls.VerifyClientCertIfGiven,
+			client:     clientConfig(readerCert, readerKey, 0),
+			wantUser:   "reader",
+		},
+		{
+			name:       "optional certificate",
+			clientAuth: tls.VerifyClientCertIfGiven,
+			client:     clientConfig("", "", 0),
+		},
+		{
+			name:       "required certificate",
+			clientAuth: tls.RequireAndVerifyClientCert,
+			client:     clientConfig("", "", 0),
+			wantErr:    true,
+		},
+		{
+			name:       "unknown certificate authority",
+			clientAuth: tls.VerifyClientCertIfGiven,
+			client:     clientConfig(strangerCert, strangerKey, 0),
+			wantErr:    true,
+		},
+		{
+			name:       "old version",
+			minVersion: tls.VersionTLS13,
+			client:     clientConfig("", "", tls.VersionTLS12),
+			wantErr:    true,
+		},
+		{
+			name:       "peer",
+			clientAuth: tls.RequireAndVerifyClientCert,
+			client:     peer,
+			wantUser:   "server",
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing handshake: "+tt.name, func(t *testing.T) {
+			state, err := handshake(certs.ServerConfig(tt.minVersion, tt.clientAuth), tt.client)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("handshake error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			user, ok := CertificateUser(&state)
+			if user != tt.wantUser || ok != (tt.wantUser != "") {
+				t.Errorf("CertificateUser() = %q, %v, want %q", user, ok, tt.wantUser)
+			}
+		})
+	}
+
+	t.Run("Testing Reload", func(t *testing.T) {
+		renewed, _, renewedCert, renewedKey := ca.issue(t, "renewed", false)
+		if err := os.Rename(renewedCert, serverCert); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(renewedKey, serverKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := certs.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		client := clientConfig("", "", 0)
+		client.VerifyPeerCertificate = func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if string(raw[0]) != string(renewed.Raw) {
+				t.Error("server presents the old certificate after reload")
+			}
+			return nil
+		}
+		if _, err := handshake(certs.ServerConfig(0, tls.NoClientCert), client); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(serverKey, []byte("broken"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := certs.Reload(); err == nil {
+			t.Error("Reload() with a broken key succeeded")
+		}
+		if _, err := handshake(certs.ServerConfig(0, tls.NoClientCert), clientConfig("", "", 0)); err != nil {
+			t.Errorf("handshake after a failed reload: %v", err)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/geraev/gokvserver/structs"
+	"github.com/golang-jwt/jwt"
+)
+
+// Алгоритмы подписи токенов
+var TokenAlgorithms = []string{"HS256", "HS384", "HS512", "RS256", "RS384", "RS512"}
+
+// TokenClaims поля токена: sub - имя пользователя, scope - категории команд через пробел,
+// keys - префиксы доступных ключей (пустой префикс - все ключи). Срок действия exp обязателен
+type TokenClaims struct {
+	Scope string   `json:"scope,omitempty"`
+	Keys  []string `json:"keys,omitempty"`
+	jwt.StandardClaims
+}
+
+// TokenVerifier проверка подписанных токенов JWT. Права задаются самим токеном,
+// пользователь может отсутствовать в Store
+type TokenVerifier struct {
+	algorithm string
+	// path файл ключа: секрет HMAC или открытый ключ RSA в PEM
+	path string
+
+	mu  sync.RWMutex
+	key interface{}
+}
+
+// LoadTokenVerifier проверка токенов алгоритмом algorithm (HS256, RS256, ...) с ключом из файла path.
+// Для HMAC файл содержит секрет (концевые пробелы и переводы строк отбрасываются), для RSA - открытый ключ в PEM
+func LoadTokenVerifier(algorithm, path string) (*TokenVerifier, error) {
+	if jwt.GetSigningMethod(algorithm) == nil || !validAlgorithm(algorithm) {
+		return nil, fmt.Errorf("unknown token algorithm %q, want one of %s", algorithm, strings.Join(TokenAlgorithms, ", "))
+	}
+	v := &TokenVerifier{algorithm: algorithm, path: path}
+	if err := v.Reload(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+func validAlgorithm(algorithm string) bool {
+	for _, known := range TokenAlgorithms {
+		if algorithm == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Reload повторное чтение файла ключа, например после его замены
+func (v *TokenVerifier) Reload() error {
+	data, err := ioutil.ReadFile(v.path)
+	if err != nil {
+		return err
+	}
+
+	var key interface{}
+	if strings.HasPrefix(v.algorithm, "HS") {
+		secret := bytes.TrimRight(data, " \r\n\t")
+		if len(secret) < 32 {
+			return fmt.Errorf("token key %s: the HMAC secret must be at least 32 bytes", v.path)
+		}
+		key = secret
+	} else if key, err = jwt.ParseRSAPublicKeyFromPEM(data); err != nil {
+		return fmt.Errorf("token key %s: %w", v.path, err)
+	}
+
+	v.mu.Lock()
+	v.key = key
+	v.mu.Unlock()
+	return nil
+}
+
+// Verify проверка подписи и срока действия токена. Возвращает пользователя с правами из токена
+func (v *TokenVerifier) Verify(token string) (*User, error) {
+	v.mu.RLock()
+	key := v.key
+	v.mu.RUnlock()
+
+	claims := &TokenClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		// Алгоритм задаётся сервером: иначе открытый ключ RSA можно было бы выдать за секрет HMAC
+		if t.Method.Alg() != v.algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+		}
+		return key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", structs.ErrBadToken, err)
+	}
+	if claims.ExpiresAt == 0 {
+		return nil, fmt.Errorf("%w: token has no expiry", structs.ErrBadToken)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: token has no subject", structs.ErrBadToken)
+	}
+	return claims.User()
+}
+
+// User пользователь с правами из токена. Неизвестные категории в scope пропускаются:
+// токен может быть выдан и для других сервисов
+func (c *TokenClaims) User() (*User, error) {
+	u := &User{Name: c.Subject, Keys: make([]string, len(c.Keys))}
+	for _, scope := range strings.Fields(c.Scope) {
+		if category := Category(scope); validCategory(category) {
+			u.Categories = append(u.Categories, category)
+		}
+	}
+	for i, prefix := range c.Keys {
+		u.Keys[i] = escapeGlob(prefix) + "*"
+	}
+	if err := u.compileKeys(); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// escapeGlob экранирование специальных символов шаблона ключей
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '*', '?', '[', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+type principalKey struct{}
+
+// WithPrincipal контекст запроса пользователя с собственными правами, например из токена
+func WithPrincipal(ctx context.Context, u *User) context.Context {
+	return context.WithValue(WithUser(ctx, u.Name), principalKey{}, u)
+}
+
+// PrincipalFromContext пользователь с собственными правами, выполняющий запрос
+func PrincipalFromContext(ctx context.Context) (*User, bool) {
+	u, ok := ctx.Value(principalKey{}).(*User)
+	return u, ok
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+	"github.com/golang-jwt/jwt"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+// writeKeys секрет HMAC и открытый ключ RSA во временном каталоге
+func writeKeys(t *testing.T) (secretPath, publicPath string, private *rsa.PrivateKey) {
+	dir, err := ioutil.TempDir("", "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	secretPath = filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(secretPath, []byte(testSecret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if private, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPath = filepath.Join(dir, "public.pem")
+	if err := ioutil.WriteFile(publicPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return secretPath, publicPath, private
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, key interface{}, claims TokenClaims) string {
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenVerifier(t *testing.T) {
+	secretPath, publicPath, private := writeKeys(t)
+	hmacVerifier, err := LoadTokenVerifier("HS256", secretPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaVerifier, err := LoadTokenVerifier("RS256", publicPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM, _ := ioutil.ReadFile(publicPath)
+
+	valid := TokenClaims{
+		Scope:          "read write openid",
+		Keys:           []string{"cache:", "a*b"},
+		StandardClaims: jwt.StandardClaims{Subject: "alice", ExpiresAt: time.Now().Add(time.Hour).Unix()},
+	}
+	expired := valid
+	expired.ExpiresAt = time.Now().Add(-time.Minute).Unix()
+	noExpiry := valid
+	noExpiry.ExpiresAt = 0
+	noSubject := valid
+	noSubject.Subject = ""
+
+	tests := []struct {
+		name     string
+		verifier *TokenVerifier
+		token    string
+		wantErr  bool
+	}{
+		{name: "hmac", verifier: hmacVerifier, token: sign(t, jwt.SigningMethodHS256, []byte(testSecret), valid)},
+		{name: "rsa", verifier: rsaVerifier, token: sign(t, jwt.SigningMethodRS256, private, valid)},
+		{name: "expired", verifier: hmacVerifier, token: sign(t, jwt.SigningMethodHS256, []byte(testSecret), expired), wantErr: true},
+		{name: "no expiry", verifier: hmacVerifier, token: sign(t, jwt.SigningMethodHS256, []byte(testSecret), noExpiry), wantErr: true},
+		{name: "no subject", verifier: hmacVerifier, token: sign(t, jwt.SigningMethodHS256, []byte(testSecret), noSubject), wantErr: true},
+		{name: "wrong secret", verifier: hmacVerifier, token: sign(t, jwt.SigningMethodHS256, []byte(testSecret+"!"), valid), wantErr: true},
+		{name: "other algorithm", verifier: hmacVerifier, token: sign(t, jwt.SigningMethodHS512, []byte(testSecret), valid), wantErr: true},
+		// Подмена алгоритма: токен подписан HMAC с открытым ключом RSA в качестве секрета
+		{name: "algorithm confusion", verifier: rsaVerifier, token: sign(t, jwt.SigningMethodHS256, publicPEM, valid), wantErr: true},
+		{name: "garbage", verifier: rsaVerifier, token: "a.b.c", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing Verify: "+tt.name, func(t *testing.T) {
+			u, err := tt.verifier.Verify(tt.token)
+			if tt.wantErr {
+				if !errors.Is(err, structs.ErrBadToken) {
+					t.Errorf("Verify() error = %v, want %v", err, structs.ErrBadToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			if u.Name != "alice" || !reflect.DeepEqual(u.Categories, []Category{Read, Write}) {
+				t.Errorf("Verify() = %+v", u)
+			}
+			access := map[string]bool{"cache:1": true, "a*b1": true, "axb1": false, "session:1": false, "": false}
+			for key, want := range access {
+				if got := u.CanAccess(key); got != want {
+					t.Errorf("CanAccess(%q) = %v, want %v", key, got, want)
+				}
+			}
+		})
+	}
+
+	t.Run("Testing Reload", func(t *testing.T) {
+		token := sign(t, jwt.SigningMethodHS256, []byte(testSecret), valid)
+		if err := ioutil.WriteFile(secretPath, []byte("another secret of at least 32 bytes"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := hmacVerifier.Reload(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := hmacVerifier.Verify(token); err == nil {
+			t.Error("token signed with the old secret is accepted after reload")
+		}
+	})
+
+	t.Run("Testing LoadTokenVerifier errors", func(t *testing.T) {
+		if _, err := LoadTokenVerifier("none", secretPath); err == nil {
+			t.Error("LoadTokenVerifier(none) succeeded")
+		}
+		if _, err := LoadTokenVerifier("RS256", secretPath); err == nil {
+			t.Error("LoadTokenVerifier(RS256) with an HMAC secret succeeded")
+		}
+		if err := ioutil.WriteFile(secretPath, []byte("short"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadTokenVerifier("HS256", secretPath); err == nil {
+			t.Error("LoadTokenVerifier(HS256) with a short secret succeeded")
+		}
+	})
+}
@@ -0,0 +1,176 @@
+// Package auth пользователи сервера: хеши паролей, категории разрешённых команд и шаблоны доступных ключей
+package auth
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Category категория команд
+type Category string
+
+const (
+	// Read чтение ключей
+	Read Category = "read"
+	// Write изменение ключей
+	Write Category = "write"
+	// Admin управление сервером: настройки, пользователи, репликация, кластер
+	Admin Category = "admin"
+)
+
+// Categories все категории
+var Categories = []Category{Read, Write, Admin}
+
+// DefaultUser пользователь соединений TCP до команды AUTH. Если он не задан, до AUTH команды отклоняются
+const DefaultUser = "default"
+
+// User пользователь сервера
+type User struct {
+	Name string `yaml:"name" json:"name"`
+	// Password хеш пароля bcrypt или argon2id. Пустой хеш запрещает вход по паролю
+	Password   string     `yaml:"password,omitempty" json:"-"`
+	Categories []Category `yaml:"categories" json:"categories"`
+	// Keys шаблоны доступных ключей: * - любая последовательность символов, ? - один символ, [a-z] - класс
+	Keys []string `yaml:"keys" json:"keys"`
+
+	keys []*regexp.Regexp
+}
+
+// Can разрешена ли пользователю категория команд
+func (u *User) Can(category Category) bool {
+	for _, c := range u.Categories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess доступен ли пользователю ключ. Пустой ключ (операция над всем пространством ключей)
+// доступен только пользователю с шаблоном *
+func (u *User) CanAccess(key string) bool {
+	for i, re := range u.keys {
+		if key == "" {
+			if u.Keys[i] == "*" {
+				return true
+			}
+			continue
+		}
+		if re.MatchString(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authorize разрешена ли пользователю категория команд над ключом. Пустой ключ - операция
+// над всем пространством ключей
+func (u *User) Authorize(category Category, key string) error {
+	if !u.Can(category) || !u.CanAccess(key) {
+		return structs.ErrNoPerm
+	}
+	return nil
+}
+
+// compile проверка пользователя и подготовка шаблонов ключей
+func (u *User) compile() error {
+	if u.Name == "" || strings.ContainsAny(u.Name, " :,") {
+		return fmt.Errorf("invalid user name %q", u.Name)
+	}
+	if u.Password != "" && !isHash(u.Password) {
+		return fmt.Errorf("user %s: the password must be a bcrypt or argon2id hash", u.Name)
+	}
+	if u.Password == "" && u.Name != DefaultUser {
+		return fmt.Errorf("user %s: password hash is required", u.Name)
+	}
+	for _, c := range u.Categories {
+		if !validCategory(c) {
+			return fmt.Errorf("user %s: unknown category %q, want read, write or admin", u.Name, c)
+		}
+	}
+	return u.compileKeys()
+}
+
+// compileKeys подготовка шаблонов ключей
+func (u *User) compileKeys() error {
+	u.keys = make([]*regexp.Regexp, len(u.Keys))
+	for i, pattern := range u.Keys {
+		re, err := globRegexp(pattern)
+		if err != nil {
+			return fmt.Errorf("user %s: invalid key pattern %q", u.Name, pattern)
+		}
+		u.keys[i] = re
+	}
+	return nil
+}
+
+// String описание прав пользователя в одну строку, как в ответе acl list
+func (u *User) String() string {
+	categories := make([]string, len(u.Categories))
+	for i, c := range u.Categories {
+		categories[i] = string(c)
+	}
+	return fmt.Sprintf("user %s categories=%s keys=%s", u.Name, strings.Join(categories, ","), strings.Join(u.Keys, ","))
+}
+
+func validCategory(c Category) bool {
+	for _, known := range Categories {
+		if c == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseCategories список категорий через запятую
+func ParseCategories(s string) ([]Category, error) {
+	var categories []Category
+	for _, name := range strings.Split(s, ",") {
+		c := Category(strings.ToLower(strings.TrimSpace(name)))
+		if c == "" {
+			continue
+		}
+		if !validCategory(c) {
+			return nil, fmt.Errorf("unknown category %q, want read, write or admin", name)
+		}
+		categories = append(categories, c)
+	}
+	return categories, nil
+}
+
+// globRegexp регулярное выражение шаблона ключей. В отличие от path.Match символ / не особый
+func globRegexp(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*':
+			b.WriteString("(?s:.*)")
+		case '?':
+			b.WriteString("(?s:.)")
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated class")
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteByte('$')
+	return regexp.Compile(b.String())
+}
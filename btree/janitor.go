@@ -0,0 +1,8 @@
+package btree
+
+import "time"
+
+// SetCleanupInterval изменение периода фонового удаления просроченных ключей
+func (s *Storage) SetCleanupInterval(d time.Duration) {
+	s.janitor.SetInterval(d)
+}
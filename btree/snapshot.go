@@ -0,0 +1,121 @@
+package btree
+
+import (
+	"context"
+	"log"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// loadChunk количество записей, загружаемых под одной блокировкой
+const loadChunk = 1024
+
+// Dump полный снимок хранилища, упорядоченный по ключам. Списки и словари снимка общие с хранилищем
+// и только читаются: хранилище не изменяет сохранённые значения на месте
+func (s *Storage) Dump() []structs.Entry {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+
+	result := make([]structs.Entry, 0, s.tree.Len())
+	s.tree.Ascend("", func(key string, val interface{}) bool {
+		if entry, ok := s.entry(key, val); ok {
+			result = append(result, entry)
+		}
+		return true
+	})
+	return result
+}
+
+// DumpKey снимок одного ключа с копией значения
+func (s *Storage) DumpKey(key string) (structs.Entry, error) {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+
+	val, ok := s.tree.Get(key)
+	if !ok {
+		return structs.Entry{}, structs.ErrKeyNotFound
+	}
+	entry, ok := s.entry(key, val)
+	if !ok {
+		return structs.Entry{}, structs.ErrType
+	}
+	switch v := entry.Value.(type) {
+	case []string:
+		entry.Value = copyList(v)
+	case map[string]string:
+		entry.Value = copyDictionary(v)
+	}
+	return entry, nil
+}
+
+// entry запись снимка для значения val. Вызывается под блокировкой
+func (s *Storage) entry(key string, val interface{}) (structs.Entry, bool) {
+	entry := structs.Entry{Key: key, Expired: s.expired[key], Value: val}
+	switch val.(type) {
+	case string:
+		entry.Type = structs.String
+	case []string:
+		entry.Type = structs.List
+	case map[string]string:
+		entry.Type = structs.Dictionary
+	default:
+		return entry, false
+	}
+	return entry, true
+}
+
+// Load загрузка записей снимка. Существующие ключи перезаписываются, значения записей переходят
+// хранилищу и не должны изменяться после загрузки
+func (s *Storage) Load(entries []structs.Entry) {
+	s.LoadContext(context.Background(), entries)
+}
+
+// LoadContext загрузка записей снимка частями по loadChunk. Между частями блокировка освобождается,
+// а загрузка прерывается отменой ctx; уже загруженные записи остаются в хранилище.
+// Потоки не поддерживаются и пропускаются с записью в журнал
+func (s *Storage) LoadContext(ctx context.Context, entries []structs.Entry) error {
+	for len(entries) > 0 {
+		n := loadChunk
+		if n > len(entries) {
+			n = len(entries)
+		}
+		if err := s.lockContext(ctx, true); err != nil {
+			return err
+		}
+		for _, entry := range entries[:n] {
+			switch v := entry.Value.(type) {
+			case string, []string, map[string]string:
+				s.tree.Set(entry.Key, v)
+			default:
+				log.Printf("btree: key %q of type %v skipped: not supported", entry.Key, entry.Type)
+				continue
+			}
+			if entry.Expired != 0 {
+				s.expired[entry.Key] = entry.Expired
+			} else {
+				delete(s.expired, entry.Key)
+			}
+		}
+		s.mu.Unlock()
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// Flush удаление всех ключей
+func (s *Storage) Flush() {
+	s.lockContext(context.Background(), true)
+	s.tree.Clear()
+	s.expired = make(map[string]uint64)
+	s.mu.Unlock()
+}
+
+// ExpireAt установка момента истечения существующего ключа (UnixNano)
+func (s *Storage) ExpireAt(key string, deadline uint64) {
+	if deadline == 0 {
+		return
+	}
+	s.lockContext(context.Background(), true)
+	s.expireAt(key, deadline)
+	s.mu.Unlock()
+}
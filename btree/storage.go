@@ -0,0 +1,405 @@
+// Package btree хранилище на B-дереве: ключи упорядочены, поэтому выборка по префиксу и диапазону
+// не требует обхода всех ключей. Потоки не поддерживаются
+package btree
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/geraev/gokvserver/internal/backend"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// scanCheckInterval количество ключей между проверками отмены при обходе хранилища
+const scanCheckInterval = 1024
+
+// Storage хранилище строк, списков и словарей в B-дереве
+type Storage struct {
+	mu      sync.RWMutex
+	tree    tree
+	expired map[string]uint64
+	janitor *backend.Janitor
+
+	// observer observerBox, заменяется во время работы
+	observer atomic.Value
+	// expiredKeys ключи, удалённые по истечении срока жизни
+	expiredKeys uint64
+	// hits, misses чтения существующих и отсутствующих ключей
+	hits, misses uint64
+}
+
+// observerBox обёртка для atomic.Value, которому нужен один конкретный тип
+type observerBox struct {
+	structs.StorageObserver
+}
+
+// NewStorage пустое хранилище с фоновым удалением просроченных ключей
+func NewStorage() *Storage {
+	s := &Storage{expired: make(map[string]uint64)}
+	s.janitor = backend.RunJanitor(20*time.Millisecond, s.DeleteExpired)
+	return s
+}
+
+// SetObserver получатель событий хранилища (ожидание блокировки, работа janitor), nil - без событий.
+// Может вызываться во время работы
+func (s *Storage) SetObserver(o structs.StorageObserver) {
+	s.observer.Store(observerBox{o})
+}
+
+func (s *Storage) loadObserver() structs.StorageObserver {
+	box, _ := s.observer.Load().(observerBox)
+	return box.StorageObserver
+}
+
+// lockContext блокировка на запись (write) или чтение, ожидание которой прерывается отменой ctx
+func (s *Storage) lockContext(ctx context.Context, write bool) error {
+	return backend.LockContext(ctx, &s.mu, write, s.loadObserver())
+}
+
+// lookup учёт чтения ключа: found - ключ существует
+func (s *Storage) lookup(found bool) {
+	if found {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+}
+
+// Len количество ключей
+func (s *Storage) Len() int {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+	return s.tree.Len()
+}
+
+// Stats статистика хранилища. Оценка памяти требует обхода всех значений
+func (s *Storage) Stats() structs.Stats {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+
+	stats := structs.Stats{
+		Keys:           make(map[structs.ValueType]int),
+		KeysWithTTL:    len(s.expired),
+		ExpiredKeys:    atomic.LoadUint64(&s.expiredKeys),
+		KeyspaceHits:   atomic.LoadUint64(&s.hits),
+		KeyspaceMisses: atomic.LoadUint64(&s.misses),
+	}
+	s.tree.Ascend("", func(key string, val interface{}) bool {
+		size := len(key)
+		switch v := val.(type) {
+		case string:
+			stats.Keys[structs.String]++
+			size += len(v)
+		case []string:
+			stats.Keys[structs.List]++
+			for _, item := range v {
+				size += len(item)
+			}
+		case map[string]string:
+			stats.Keys[structs.Dictionary]++
+			for k, item := range v {
+				size += len(k) + len(item)
+			}
+		}
+		stats.MemoryBytes += int64(size)
+		return true
+	})
+	return stats
+}
+
+func (s *Storage) GetKeys() []string {
+	keys, _ := s.GetKeysContext(context.Background())
+	return keys
+}
+
+// GetKeysContext все ключи по возрастанию. Обход прерывается отменой ctx
+func (s *Storage) GetKeysContext(ctx context.Context) ([]string, error) {
+	return s.KeysRangeContext(ctx, structs.KeyRange{})
+}
+
+// KeysRangeContext ключи диапазона r по возрастанию. Обходятся только ключи диапазона
+func (s *Storage) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	result := make([]string, 0)
+	var err error
+	s.tree.Ascend(r.From(), func(key string, _ interface{}) bool {
+		if len(result)%scanCheckInterval == 0 {
+			if err = ctx.Err(); err != nil {
+				return false
+			}
+		}
+		if r.Done(key) {
+			return false
+		}
+		if r.Contains(key) {
+			result = append(result, key)
+		}
+		return r.Limit <= 0 || len(result) < r.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetElement получение элемента по ключу. Списки и словари возвращаются копиями
+func (s *Storage) GetElement(key string) (interface{}, error) {
+	return s.GetElementContext(context.Background(), key)
+}
+
+func (s *Storage) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	val, ok := s.tree.Get(key)
+	s.lookup(ok)
+	if !ok {
+		return nil, structs.ErrKeyNotFound
+	}
+	switch v := val.(type) {
+	case string:
+		return v, nil
+	case []string:
+		return copyList(v), nil
+	case map[string]string:
+		return copyDictionary(v), nil
+	default:
+		return "", structs.ErrType
+	}
+}
+
+// GetListElement получение по индексу одного элемента из списка
+func (s *Storage) GetListElement(key string, index int) (string, error) {
+	return s.GetListElementContext(context.Background(), key, index)
+}
+
+func (s *Storage) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return "", err
+	}
+	defer s.mu.RUnlock()
+
+	if index < 0 {
+		return "", structs.ErrIndexOutOfRange
+	}
+	val, ok := s.tree.Get(key)
+	s.lookup(ok)
+	if !ok {
+		return "", structs.ErrKeyNotFound
+	}
+	v, ok := val.([]string)
+	if !ok {
+		return "", structs.ErrType
+	}
+	if index >= len(v) {
+		return "", structs.ErrIndexOutOfRange
+	}
+	return v[index], nil
+}
+
+// GetDictionaryElement получение по ключу одного элемента из словаря
+func (s *Storage) GetDictionaryElement(key, internalKey string) (string, error) {
+	return s.GetDictionaryElementContext(context.Background(), key, internalKey)
+}
+
+func (s *Storage) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return "", err
+	}
+	defer s.mu.RUnlock()
+
+	val, ok := s.tree.Get(key)
+	s.lookup(ok)
+	if !ok {
+		return "", structs.ErrKeyNotFound
+	}
+	v, ok := val.(map[string]string)
+	if !ok {
+		return "", structs.ErrType
+	}
+	item, ok := v[internalKey]
+	if !ok {
+		return "", structs.ErrKeyNotFound
+	}
+	return item, nil
+}
+
+// PutOrUpdateString добавление либо обновление значения ключа. Для существующего ключа возвращается
+// предыдущее значение и true
+func (s *Storage) PutOrUpdateString(key, value string) (string, bool) {
+	previousVal, isUpdated, _ := s.PutOrUpdateStringContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	if err := s.lockContext(ctx, true); err != nil {
+		return "", false, err
+	}
+	defer s.mu.Unlock()
+
+	// Значение другого типа заменяется, предыдущим значением возвращается нулевое
+	old, isUpdated := s.tree.Set(key, value)
+	previousVal, _ := old.(string)
+	return previousVal, isUpdated, nil
+}
+
+// PutOrUpdateList добавление либо обновление списка. Хранится копия value
+func (s *Storage) PutOrUpdateList(key string, value []string) ([]string, bool) {
+	previousVal, isUpdated, _ := s.PutOrUpdateListContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	if err := s.lockContext(ctx, true); err != nil {
+		return nil, false, err
+	}
+	defer s.mu.Unlock()
+
+	old, isUpdated := s.tree.Set(key, copyList(value))
+	previousVal, _ := old.([]string)
+	return previousVal, isUpdated, nil
+}
+
+// PutOrUpdateDictionary добавление либо обновление словаря. Хранится копия value
+func (s *Storage) PutOrUpdateDictionary(key string, value map[string]string) (map[string]string, bool) {
+	previousVal, isUpdated, _ := s.PutOrUpdateDictionaryContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	if err := s.lockContext(ctx, true); err != nil {
+		return nil, false, err
+	}
+	defer s.mu.Unlock()
+
+	old, isUpdated := s.tree.Set(key, copyDictionary(value))
+	previousVal, _ := old.(map[string]string)
+	return previousVal, isUpdated, nil
+}
+
+// RemoveElement удаление элемента по ключу
+func (s *Storage) RemoveElement(key string) {
+	s.RemoveElementContext(context.Background(), key)
+}
+
+func (s *Storage) RemoveElementContext(ctx context.Context, key string) error {
+	if err := s.lockContext(ctx, true); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	s.tree.Delete(key)
+	delete(s.expired, key)
+	return nil
+}
+
+// SetTTL установка TTL в миллисекундах
+// Deprecated: используйте SetExpired
+func (s *Storage) SetTTL(key string, keyTTL uint64) {
+	s.SetExpired(key, keyTTL)
+}
+
+// SetExpired установка TTL для ключа в миллисекундах. Для отсутствующего ключа ничего не делает
+func (s *Storage) SetExpired(key string, expired uint64) {
+	s.SetExpiredContext(context.Background(), key, expired)
+}
+
+func (s *Storage) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	if expired == 0 {
+		return nil
+	}
+	if err := s.lockContext(ctx, true); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	s.expireAt(key, uint64(time.Now().Add(time.Millisecond*time.Duration(expired)).UnixNano()))
+	return nil
+}
+
+// expireAt установка момента истечения существующего ключа. Вызывается под блокировкой на запись
+func (s *Storage) expireAt(key string, deadline uint64) {
+	if _, ok := s.tree.Get(key); ok {
+		s.expired[key] = deadline
+	}
+}
+
+func (s *Storage) GetType(key string) (structs.ValueType, error) {
+	return s.GetTypeContext(context.Background(), key)
+}
+
+func (s *Storage) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return 0, err
+	}
+	defer s.mu.RUnlock()
+
+	val, ok := s.tree.Get(key)
+	if !ok {
+		return 0, structs.ErrKeyNotFound
+	}
+	switch val.(type) {
+	case string:
+		return structs.String, nil
+	case []string:
+		return structs.List, nil
+	case map[string]string:
+		return structs.Dictionary, nil
+	default:
+		return 0, structs.ErrType
+	}
+}
+
+// DeleteExpired удаление просроченных ключей
+func (s *Storage) DeleteExpired() {
+	start := time.Now()
+	s.lockContext(context.Background(), true)
+	now := uint64(time.Now().UnixNano())
+	var n uint64
+	for key, deadline := range s.expired {
+		if now >= deadline {
+			s.tree.Delete(key)
+			delete(s.expired, key)
+			n++
+		}
+	}
+	s.mu.Unlock()
+	atomic.AddUint64(&s.expiredKeys, n)
+	if o := s.loadObserver(); o != nil {
+		o.JanitorRun(time.Since(start))
+	}
+}
+
+// Close остановка фонового удаления просроченных ключей. Данные остаются доступными
+func (s *Storage) Close() error {
+	s.janitor.Stop()
+	return nil
+}
+
+// copyList копия списка: хранилище не делит значения с вызывающим кодом
+func copyList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(list)), list...)
+}
+
+// copyDictionary копия словаря
+func copyDictionary(dict map[string]string) map[string]string {
+	if dict == nil {
+		return nil
+	}
+	result := make(map[string]string, len(dict))
+	for k, v := range dict {
+		result[k] = v
+	}
+	return result
+}
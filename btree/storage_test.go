@@ -0,0 +1,98 @@
+package btree_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/geraev/gokvserver/btree"
+	"github.com/geraev/gokvserver/storagetest"
+	"github.com/geraev/gokvserver/structs"
+)
+
+func newStorage(*testing.T) structs.Storage {
+	return btree.NewStorage()
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	storagetest.Run(t, newStorage)
+}
+
+func TestStorage_Stress(t *testing.T) {
+	storagetest.Stress(t, newStorage)
+}
+
+func TestStorage_KeysRange(t *testing.T) {
+	s := btree.NewStorage()
+	defer s.Close()
+	for _, key := range []string{"user:1", "user:1:session", "user:2", "user:2:session", "user:10", "users", "video:1"} {
+		s.PutOrUpdateString(key, "value")
+	}
+
+	tests := []struct {
+		name string
+		r    structs.KeyRange
+		want []string
+	}{
+		// Ключи упорядочены побайтово: "0" меньше ":"
+		{name: "all keys", want: []string{"user:1", "user:10", "user:1:session", "user:2", "user:2:session", "users", "video:1"}},
+		{name: "prefix", r: structs.KeyRange{Prefix: "user:"}, want: []string{"user:1", "user:10", "user:1:session", "user:2", "user:2:session"}},
+		{name: "prefix with limit", r: structs.KeyRange{Prefix: "user:", Limit: 2}, want: []string{"user:1", "user:10"}},
+		{name: "start and end", r: structs.KeyRange{Start: "user:1:session", End: "user:2:session"}, want: []string{"user:1:session", "user:2"}},
+		{name: "prefix and start", r: structs.KeyRange{Prefix: "user:", Start: "user:2"}, want: []string{"user:2", "user:2:session"}},
+		{name: "start before prefix", r: structs.KeyRange{Prefix: "video:", Start: "a"}, want: []string{"video:1"}},
+		{name: "end before start", r: structs.KeyRange{Start: "video", End: "user"}, want: []string{}},
+		{name: "missing prefix", r: structs.KeyRange{Prefix: "order:"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			got, err := s.KeysRangeContext(context.Background(), tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("KeysRangeContext() = %q, want %q", got, tt.want)
+			}
+			// Выборка из всех ключей для хранилища без RangeStorage совпадает с обходом дерева
+			fallback, err := structs.KeysRange(context.Background(), structs.WithContext(plain{s}), tt.r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(fallback, tt.want) {
+				t.Errorf("KeysRange() without RangeStorage = %q, want %q", fallback, tt.want)
+			}
+		})
+	}
+}
+
+// plain хранилище только с методами structs.Storage
+type plain struct {
+	structs.Storage
+}
+
+func TestStorage_Snapshot(t *testing.T) {
+	s := btree.NewStorage()
+	defer s.Close()
+	for i := 0; i < 3000; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("key:%04d", i), "value")
+	}
+	s.PutOrUpdateList("list", []string{"a", "b"})
+	s.SetExpired("list", 60000)
+
+	entries := s.Dump()
+	restored := btree.NewStorage()
+	defer restored.Close()
+	restored.Load(append(entries, structs.Entry{Key: "stream", Type: structs.Stream, Value: []structs.StreamEntry{}}))
+
+	if !reflect.DeepEqual(restored.Dump(), entries) {
+		t.Errorf("Dump() after Load() differs from the source")
+	}
+	if got := restored.Stats().KeysWithTTL; got != 1 {
+		t.Errorf("KeysWithTTL = %d, want 1", got)
+	}
+	restored.Flush()
+	if n := restored.Len(); n != 0 {
+		t.Errorf("Len() after Flush() = %d, want 0", n)
+	}
+}
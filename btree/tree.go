@@ -0,0 +1,282 @@
+package btree
+
+import "sort"
+
+// degree степень дерева: узел, кроме корня, содержит от degree-1 до 2*degree-1 записей
+const degree = 32
+
+const (
+	maxItems = 2*degree - 1
+	minItems = degree - 1
+)
+
+// item запись дерева
+type item struct {
+	key   string
+	value interface{}
+}
+
+// node узел B-дерева. У внутреннего узла на один потомок больше, чем записей: потомок i
+// содержит ключи меньше items[i], последний потомок - больше всех записей узла
+type node struct {
+	items    []item
+	children []*node
+}
+
+// tree B-дерево записей, упорядоченных по ключу
+type tree struct {
+	root   *node
+	length int
+}
+
+// Len количество записей
+func (t *tree) Len() int {
+	return t.length
+}
+
+// Get значение ключа
+func (t *tree) Get(key string) (interface{}, bool) {
+	for n := t.root; n != nil; {
+		i, found := n.find(key)
+		if found {
+			return n.items[i].value, true
+		}
+		if len(n.children) == 0 {
+			break
+		}
+		n = n.children[i]
+	}
+	return nil, false
+}
+
+// Set запись значения ключа. Возвращает предыдущее значение, если ключ уже был в дереве
+func (t *tree) Set(key string, value interface{}) (interface{}, bool) {
+	if t.root == nil {
+		t.root = &node{items: []item{{key: key, value: value}}}
+		t.length++
+		return nil, false
+	}
+	if len(t.root.items) >= maxItems {
+		middle, second := t.root.split(maxItems / 2)
+		t.root = &node{items: []item{middle}, children: []*node{t.root, second}}
+	}
+	old, replaced := t.root.insert(key, value)
+	if !replaced {
+		t.length++
+	}
+	return old, replaced
+}
+
+// Delete удаление ключа. Возвращает удалённое значение
+func (t *tree) Delete(key string) (interface{}, bool) {
+	if t.root == nil || len(t.root.items) == 0 {
+		return nil, false
+	}
+	out, ok := t.root.remove(key, false)
+	if len(t.root.items) == 0 && len(t.root.children) > 0 {
+		t.root = t.root.children[0]
+	}
+	if !ok {
+		return nil, false
+	}
+	t.length--
+	return out.value, true
+}
+
+// Ascend обход записей с ключами не меньше from по возрастанию, пока fn возвращает true
+func (t *tree) Ascend(from string, fn func(key string, value interface{}) bool) {
+	if t.root != nil {
+		t.root.ascend(from, fn)
+	}
+}
+
+// Clear удаление всех записей
+func (t *tree) Clear() {
+	t.root, t.length = nil, 0
+}
+
+// find индекс первой записи с ключом не меньше key и равен ли её ключ key
+func (n *node) find(key string) (int, bool) {
+	i := sort.Search(len(n.items), func(i int) bool {
+		return n.items[i].key >= key
+	})
+	return i, i < len(n.items) && n.items[i].key == key
+}
+
+// split разделение узла по записи i: запись поднимается в родителя, записи после неё
+// переходят в новый узел
+func (n *node) split(i int) (item, *node) {
+	middle := n.items[i]
+	next := &node{items: append([]item(nil), n.items[i+1:]...)}
+	n.items = truncateItems(n.items, i)
+	if len(n.children) > 0 {
+		next.children = append([]*node(nil), n.children[i+1:]...)
+		n.children = truncateChildren(n.children, i+1)
+	}
+	return middle, next
+}
+
+// splitChild разделение заполненного потомка i перед спуском в него
+func (n *node) splitChild(i int) bool {
+	if len(n.children[i].items) < maxItems {
+		return false
+	}
+	middle, second := n.children[i].split(maxItems / 2)
+	n.items = insertItem(n.items, i, middle)
+	n.children = insertChild(n.children, i+1, second)
+	return true
+}
+
+func (n *node) insert(key string, value interface{}) (interface{}, bool) {
+	i, found := n.find(key)
+	if found {
+		old := n.items[i].value
+		n.items[i].value = value
+		return old, true
+	}
+	if len(n.children) == 0 {
+		n.items = insertItem(n.items, i, item{key: key, value: value})
+		return nil, false
+	}
+	if n.splitChild(i) {
+		switch middle := n.items[i].key; {
+		case key > middle:
+			i++
+		case key == middle:
+			old := n.items[i].value
+			n.items[i].value = value
+			return old, true
+		}
+	}
+	return n.children[i].insert(key, value)
+}
+
+// remove удаление ключа key или, при max, наибольшей записи поддерева. Перед спуском
+// в потомка с минимальным количеством записей он пополняется, чтобы удаление не требовало
+// возврата вверх по дереву
+func (n *node) remove(key string, max bool) (item, bool) {
+	var (
+		i     int
+		found bool
+	)
+	if max {
+		i = len(n.items)
+		if len(n.children) == 0 {
+			out := n.items[i-1]
+			n.items = truncateItems(n.items, i-1)
+			return out, true
+		}
+	} else {
+		i, found = n.find(key)
+		if len(n.children) == 0 {
+			if !found {
+				return item{}, false
+			}
+			out := n.items[i]
+			n.items = removeItem(n.items, i)
+			return out, true
+		}
+	}
+	if len(n.children[i].items) <= minItems {
+		n.growChild(i)
+		return n.remove(key, max)
+	}
+	if found {
+		// Запись внутреннего узла заменяется наибольшей записью левого поддерева
+		out := n.items[i]
+		n.items[i], _ = n.children[i].remove("", true)
+		return out, true
+	}
+	return n.children[i].remove(key, max)
+}
+
+// growChild пополнение потомка i записью соседа или слияние с соседом
+func (n *node) growChild(i int) {
+	switch {
+	case i > 0 && len(n.children[i-1].items) > minItems:
+		child, left := n.children[i], n.children[i-1]
+		child.items = insertItem(child.items, 0, n.items[i-1])
+		n.items[i-1] = left.items[len(left.items)-1]
+		left.items = truncateItems(left.items, len(left.items)-1)
+		if len(left.children) > 0 {
+			child.children = insertChild(child.children, 0, left.children[len(left.children)-1])
+			left.children = truncateChildren(left.children, len(left.children)-1)
+		}
+	case i < len(n.items) && len(n.children[i+1].items) > minItems:
+		child, right := n.children[i], n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		n.items[i] = right.items[0]
+		right.items = removeItem(right.items, 0)
+		if len(right.children) > 0 {
+			child.children = append(child.children, right.children[0])
+			right.children = removeChild(right.children, 0)
+		}
+	default:
+		if i >= len(n.items) {
+			i--
+		}
+		child, right := n.children[i], n.children[i+1]
+		child.items = append(child.items, n.items[i])
+		child.items = append(child.items, right.items...)
+		child.children = append(child.children, right.children...)
+		n.items = removeItem(n.items, i)
+		n.children = removeChild(n.children, i+1)
+	}
+}
+
+// ascend обход поддерева с ключа from. Возвращает false, если fn остановил обход
+func (n *node) ascend(from string, fn func(key string, value interface{}) bool) bool {
+	i, _ := n.find(from)
+	for ; i < len(n.items); i++ {
+		if len(n.children) > 0 && !n.children[i].ascend(from, fn) {
+			return false
+		}
+		if !fn(n.items[i].key, n.items[i].value) {
+			return false
+		}
+	}
+	if len(n.children) > 0 {
+		return n.children[len(n.children)-1].ascend(from, fn)
+	}
+	return true
+}
+
+func insertItem(items []item, i int, it item) []item {
+	items = append(items, item{})
+	copy(items[i+1:], items[i:])
+	items[i] = it
+	return items
+}
+
+func removeItem(items []item, i int) []item {
+	copy(items[i:], items[i+1:])
+	return truncateItems(items, len(items)-1)
+}
+
+// truncateItems укорачивание до n записей с очисткой хвоста, чтобы удалённые значения
+// не удерживались в памяти
+func truncateItems(items []item, n int) []item {
+	for i := n; i < len(items); i++ {
+		items[i] = item{}
+	}
+	return items[:n]
+}
+
+func insertChild(children []*node, i int, child *node) []*node {
+	children = append(children, nil)
+	copy(children[i+1:], children[i:])
+	children[i] = child
+	return children
+}
+
+func removeChild(children []*node, i int) []*node {
+	copy(children[i:], children[i+1:])
+	return truncateChildren(children, len(children)-1)
+}
+
+func truncateChildren(children []*node, n int) []*node {
+	for i := n; i < len(children); i++ {
+		children[i] = nil
+	}
+	return children[:n]
+}
@@ -0,0 +1,161 @@
+package btree
+
+import (
+	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// check проверка инвариантов дерева: порядок ключей, заполненность узлов и одинаковая глубина листьев
+func check(t *testing.T, tr *tree) {
+	t.Helper()
+	if tr.root == nil {
+		return
+	}
+	var (
+		count, leafDepth = 0, -1
+		prev             *string
+		walk             func(n *node, depth int, root bool)
+	)
+	walk = func(n *node, depth int, root bool) {
+		if len(n.items) > maxItems || (!root && len(n.items) < minItems) {
+			t.Fatalf("node with %d items at depth %d", len(n.items), depth)
+		}
+		if len(n.children) == 0 {
+			if leafDepth == -1 {
+				leafDepth = depth
+			} else if leafDepth != depth {
+				t.Fatalf("leaves at depths %d and %d", leafDepth, depth)
+			}
+		} else if len(n.children) != len(n.items)+1 {
+			t.Fatalf("node with %d items and %d children", len(n.items), len(n.children))
+		}
+		for i := range n.items {
+			if len(n.children) > 0 {
+				walk(n.children[i], depth+1, false)
+			}
+			key := n.items[i].key
+			if prev != nil && *prev >= key {
+				t.Fatalf("key %q after %q", key, *prev)
+			}
+			prev = &key
+			count++
+		}
+		if len(n.children) > 0 {
+			walk(n.children[len(n.children)-1], depth+1, false)
+		}
+	}
+	walk(tr.root, 0, true)
+	if count != tr.Len() {
+		t.Fatalf("tree has %d items, Len() = %d", count, tr.Len())
+	}
+}
+
+// keys ключи дерева, начиная с from
+func keys(tr *tree, from string) []string {
+	result := []string{}
+	tr.Ascend(from, func(key string, _ interface{}) bool {
+		result = append(result, key)
+		return true
+	})
+	return result
+}
+
+func TestTree(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		ops  int
+	}{
+		{name: "small", size: 10, ops: 1000},
+		{name: "one level split", size: 200, ops: 5000},
+		{name: "deep", size: 20000, ops: 100000},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			rnd := rand.New(rand.NewSource(1))
+			tr := &tree{}
+			want := make(map[string]int)
+			for i := 0; i < tt.ops; i++ {
+				key := fmt.Sprintf("key:%06d", rnd.Intn(tt.size))
+				if rnd.Intn(3) == 0 {
+					_, ok := tr.Delete(key)
+					if _, exists := want[key]; ok != exists {
+						t.Fatalf("Delete(%q) = %v, want %v", key, ok, exists)
+					}
+					delete(want, key)
+					continue
+				}
+				old, replaced := tr.Set(key, i)
+				if prev, exists := want[key]; replaced != exists || (exists && old != prev) {
+					t.Fatalf("Set(%q) = %v, %v, want %v, %v", key, old, replaced, prev, exists)
+				}
+				want[key] = i
+			}
+			check(t, tr)
+
+			wantKeys := make([]string, 0, len(want))
+			for key, val := range want {
+				wantKeys = append(wantKeys, key)
+				if got, ok := tr.Get(key); !ok || got != val {
+					t.Fatalf("Get(%q) = %v, %v, want %v", key, got, ok, val)
+				}
+			}
+			sort.Strings(wantKeys)
+			if got := keys(tr, ""); !reflect.DeepEqual(got, wantKeys) {
+				t.Fatalf("Ascend() returned %d keys, want %d", len(got), len(wantKeys))
+			}
+			if len(wantKeys) > 1 {
+				from := wantKeys[len(wantKeys)/2]
+				if got := keys(tr, from); !reflect.DeepEqual(got, wantKeys[len(wantKeys)/2:]) {
+					t.Fatalf("Ascend(%q) returned %d keys, want %d", from, len(got), len(wantKeys)-len(wantKeys)/2)
+				}
+			}
+
+			for _, key := range wantKeys {
+				if _, ok := tr.Delete(key); !ok {
+					t.Fatalf("Delete(%q) = false", key)
+				}
+			}
+			check(t, tr)
+			if tr.Len() != 0 || len(keys(tr, "")) != 0 {
+				t.Fatalf("Len() = %d after deleting all keys", tr.Len())
+			}
+		})
+	}
+}
+
+func TestTree_AscendStop(t *testing.T) {
+	tr := &tree{}
+	for i := 0; i < 1000; i++ {
+		tr.Set(fmt.Sprintf("%04d", i), i)
+	}
+	var got []string
+	tr.Ascend("0500", func(key string, _ interface{}) bool {
+		got = append(got, key)
+		return len(got) < 3
+	})
+	if want := []string{"0500", "0501", "0502"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Ascend() = %q, want %q", got, want)
+	}
+}
+
+func BenchmarkTree_Set(b *testing.B) {
+	tr := &tree{}
+	for i := 0; i < b.N; i++ {
+		tr.Set(fmt.Sprintf("user:%d:session", i), i)
+	}
+}
+
+func BenchmarkTree_Get(b *testing.B) {
+	tr := &tree{}
+	for i := 0; i < 100000; i++ {
+		tr.Set(fmt.Sprintf("user:%d:session", i), i)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		tr.Get(fmt.Sprintf("user:%d:session", i%100000))
+	}
+}
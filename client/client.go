@@ -0,0 +1,274 @@
+// Package client клиент gokvserver для HTTP и TCP протоколов
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+const (
+	DefaultPoolSize        = 10
+	DefaultMaxRetries      = 3
+	DefaultMinRetryBackoff = 50 * time.Millisecond
+	DefaultMaxRetryBackoff = time.Second
+	DefaultTimeout         = 5 * time.Second
+)
+
+// Options параметры клиента. Нулевые значения заменяются значениями по умолчанию
+type Options struct {
+	// Username, Password учётная запись: базовая аутентификация HTTP, команда AUTH в каждом новом соединении TCP
+	Username string
+	Password string
+	// TLSConfig соединение с сервером по TLS: сертификат клиента, удостоверяющие центры сервера.
+	// Адрес HTTP клиента без схемы получает схему https
+	TLSConfig *tls.Config
+
+	// PoolSize количество соединений, которые держатся открытыми для повторного использования
+	PoolSize int
+	// MaxRetries количество повторов запроса при сетевых ошибках. -1 отключает повторы
+	MaxRetries int
+	// MinRetryBackoff, MaxRetryBackoff границы экспоненциальной задержки между повторами
+	MinRetryBackoff time.Duration
+	MaxRetryBackoff time.Duration
+	// Timeout ограничение времени запроса, если контекст не задаёт собственное
+	Timeout time.Duration
+}
+
+func (o *Options) init() {
+	if o.PoolSize <= 0 {
+		o.PoolSize = DefaultPoolSize
+	}
+	if o.MaxRetries == 0 {
+		o.MaxRetries = DefaultMaxRetries
+	} else if o.MaxRetries < 0 {
+		o.MaxRetries = 0
+	}
+	if o.MinRetryBackoff <= 0 {
+		o.MinRetryBackoff = DefaultMinRetryBackoff
+	}
+	if o.MaxRetryBackoff <= 0 {
+		o.MaxRetryBackoff = DefaultMaxRetryBackoff
+	}
+	if o.Timeout <= 0 {
+		o.Timeout = DefaultTimeout
+	}
+}
+
+// transport протокол обмена с сервером
+type transport interface {
+	keys(ctx context.Context) ([]string, error)
+	// get чтение значения типа vartype в value (*string, *[]string либо *map[string]string)
+	get(ctx context.Context, key string, vartype structs.ValueType, value interface{}) error
+	// value значение ключа любого типа
+	value(ctx context.Context, key string) (interface{}, error)
+	// field элемент списка по индексу либо словаря по ключу
+	field(ctx context.Context, key, field string) (string, error)
+	set(ctx context.Context, key string, vartype structs.ValueType, value interface{}, ttl time.Duration) error
+	expire(ctx context.Context, key string, ttl time.Duration) error
+	remove(ctx context.Context, key string) error
+	close() error
+}
+
+// Client клиент сервера. Безопасен для использования из нескольких горутин
+type Client struct {
+	transport transport
+	opts      Options
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// NewHTTPClient клиент HTTP API по адресу addr (http://host:port либо host:port)
+func NewHTTPClient(addr string, opts Options) *Client {
+	opts.init()
+	return newClient(newHTTPTransport(addr, opts), opts)
+}
+
+// NewTCPClient клиент TCP API по адресу addr (host:port)
+func NewTCPClient(addr string, opts Options) *Client {
+	opts.init()
+	return newClient(newTCPTransport(addr, opts), opts)
+}
+
+func newClient(t transport, opts Options) *Client {
+	return &Client{
+		transport: t,
+		opts:      opts,
+		rnd:       rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Close закрытие соединений
+func (c *Client) Close() error {
+	return c.transport.close()
+}
+
+// Keys список ключей
+func (c *Client) Keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		keys, err = c.transport.keys(ctx)
+		return err
+	})
+	return keys, err
+}
+
+// Get значение ключа любого типа: string, []string либо map[string]string
+func (c *Client) Get(ctx context.Context, key string) (interface{}, error) {
+	var value interface{}
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		value, err = c.transport.value(ctx, key)
+		return err
+	})
+	return value, err
+}
+
+// GetField элемент списка по индексу либо элемент словаря по ключу
+func (c *Client) GetField(ctx context.Context, key, field string) (string, error) {
+	var value string
+	err := c.do(ctx, func(ctx context.Context) (err error) {
+		value, err = c.transport.field(ctx, key, field)
+		return err
+	})
+	return value, err
+}
+
+// GetString значение строкового ключа. Для ключа другого типа возвращается ErrType
+func (c *Client) GetString(ctx context.Context, key string) (string, error) {
+	var value string
+	err := c.do(ctx, func(ctx context.Context) error {
+		return c.transport.get(ctx, key, structs.String, &value)
+	})
+	return value, err
+}
+
+// GetList значение ключа списка
+func (c *Client) GetList(ctx context.Context, key string) ([]string, error) {
+	var value []string
+	err := c.do(ctx, func(ctx context.Context) error {
+		return c.transport.get(ctx, key, structs.List, &value)
+	})
+	return value, err
+}
+
+// GetDictionary значение ключа словаря
+func (c *Client) GetDictionary(ctx context.Context, key string) (map[string]string, error) {
+	var value map[string]string
+	err := c.do(ctx, func(ctx context.Context) error {
+		return c.transport.get(ctx, key, structs.Dictionary, &value)
+	})
+	return value, err
+}
+
+// Set запись значения: string, []string либо map[string]string
+func (c *Client) Set(ctx context.Context, key string, value interface{}) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL запись значения со временем жизни ttl (0 - без ограничения)
+func (c *Client) SetWithTTL(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
+	var vartype structs.ValueType
+	switch value.(type) {
+	case string:
+		vartype = structs.String
+	case []string:
+		vartype = structs.List
+	case map[string]string:
+		vartype = structs.Dictionary
+	default:
+		return fmt.Errorf("unsupported value type %T: %w", value, ErrType)
+	}
+	return c.do(ctx, func(ctx context.Context) error {
+		return c.transport.set(ctx, key, vartype, value, ttl)
+	})
+}
+
+// Expire установка времени жизни ключа
+func (c *Client) Expire(ctx context.Context, key string, ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("invalid ttl %s", ttl)
+	}
+	return c.do(ctx, func(ctx context.Context) error {
+		return c.transport.expire(ctx, key, ttl)
+	})
+}
+
+// Delete удаление ключа
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.do(ctx, func(ctx context.Context) error {
+		return c.transport.remove(ctx, key)
+	})
+}
+
+// do выполнение запроса с повторами при сетевых ошибках. Все операции клиента идемпотентны
+func (c *Client) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	for attempt := 0; ; attempt++ {
+		err := c.attempt(ctx, fn)
+		if err == nil || attempt >= c.opts.MaxRetries || !retryable(err) {
+			return err
+		}
+
+		timer := time.NewTimer(c.backoff(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func (c *Client) attempt(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+	if err := fn(ctx); err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	return nil
+}
+
+// backoff задержка перед повтором: экспоненциальный рост со случайной составляющей
+func (c *Client) backoff(attempt int) time.Duration {
+	d := c.opts.MinRetryBackoff << uint(attempt)
+	if d > c.opts.MaxRetryBackoff || d <= 0 {
+		d = c.opts.MaxRetryBackoff
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return d/2 + time.Duration(c.rnd.Int63n(int64(d/2)+1))
+}
+
+// retryable сетевые ошибки и ошибки сервера 5xx
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var (
+		netErr    net.Error
+		statusErr *statusError
+	)
+	switch {
+	case errors.As(err, &statusErr):
+		return statusErr.code >= 500
+	case errors.As(err, &netErr):
+		return true
+	default:
+		return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,747 @@
+package client
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+)
+
+var accounts = map[string]string{"user": "pass"}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// readOnlyKey запись ключа запрещена, как на реплике
+const readOnlyKey = "readonly"
+
+func guard(_ context.Context, key string, write bool) error {
+	if write && key == readOnlyKey {
+		return structs.ErrReadOnly
+	}
+	return nil
+}
+
+// newClients клиенты обоих протоколов, у каждого собственный сервер и хранилище
+func newClients(t *testing.T) map[string]*Client {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	tcp := tcpserver.NewServer("", mapbased.NewStorage())
+	tcp.SetGuard(guard)
+	go tcp.Serve(lis)
+
+	srv := httpserver.NewServer("", accounts, mapbased.NewStorage())
+	srv.SetGuard(guard)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	clients := map[string]*Client{
+		"http": NewHTTPClient(ts.URL, Options{Username: "user", Password: "pass"}),
+		"tcp":  NewTCPClient(lis.Addr().String(), Options{}),
+	}
+	for _, c := range clients {
+		c := c
+		t.Cleanup(func() { c.Close() })
+	}
+	return clients
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// binaryValue строка с разделителями протокола и некорректным UTF-8
+const binaryValue = " lead\x00\r\nbinary\xff trail "
+
+func TestClient_GetSet(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range newClients(t) {
+		t.Run(name, func(t *testing.T) {
+			key := func(k string) string { return "key:" + k }
+			if err := c.Set(ctx, key("str"), "hello world"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := c.Set(ctx, key("str"), "hello, world"); err != nil {
+				t.Fatalf("Set() update error = %v", err)
+			}
+			if err := c.Set(ctx, key("list"), []string{"a", "b c"}); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := c.Set(ctx, key("dict"), map[string]string{"k": "v"}); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			// Строки передаются побайтово
+			if err := c.Set(ctx, key("binary"), binaryValue); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := c.Set(ctx, key("binary list"), []string{binaryValue, ""}); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if err := c.Set(ctx, key("binary dict"), map[string]string{binaryValue: binaryValue}); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+
+			tests := []struct {
+				name    string
+				get     func() (interface{}, error)
+				want    interface{}
+				wantErr error
+			}{
+				{
+					name: "GetString",
+					get:  func() (interface{}, error) { return c.GetString(ctx, key("str")) },
+					want: "hello, world",
+				},
+				{
+					name: "GetList",
+					get:  func() (interface{}, error) { return c.GetList(ctx, key("list")) },
+					want: []string{"a", "b c"},
+				},
+				{
+					name: "GetDictionary",
+					get:  func() (interface{}, error) { return c.GetDictionary(ctx, key("dict")) },
+					want: map[string]string{"k": "v"},
+				},
+				{
+					name: "GetString of binary data",
+					get:  func() (interface{}, error) { return c.GetString(ctx, key("binary")) },
+					want: binaryValue,
+				},
+				{
+					name: "GetList of binary data",
+					get:  func() (interface{}, error) { return c.GetList(ctx, key("binary list")) },
+					want: []string{binaryValue, ""},
+				},
+				{
+					name: "Get of a binary dictionary",
+					get:  func() (interface{}, error) { return c.Get(ctx, key("binary dict")) },
+					want: map[string]string{binaryValue: binaryValue},
+				},
+				{
+					name:    "GetString of a list",
+					get:     func() (interface{}, error) { return c.GetString(ctx, key("list")) },
+					want:    "",
+					wantErr: ErrType,
+				},
+				{
+					name:    "GetList of a dictionary",
+					get:     func() (interface{}, error) { return c.GetList(ctx, key("dict")) },
+					want:    []string(nil),
+					wantErr: ErrType,
+				},
+				{
+					name: "Get of a string",
+					get:  func() (interface{}, error) { return c.Get(ctx, key("str")) },
+					want: "hello, world",
+				},
+				{
+					name: "Get of a list",
+					get:  func() (interface{}, error) { return c.Get(ctx, key("list")) },
+					want: []string{"a", "b c"},
+				},
+				{
+					name: "Get of a dictionary",
+					get:  func() (interface{}, error) { return c.Get(ctx, key("dict")) },
+					want: map[string]string{"k": "v"},
+				},
+				{
+					name:    "Get of a missing key",
+					get:     func() (interface{}, error) { return c.Get(ctx, key("missing")) },
+					want:    nil,
+					wantErr: ErrKeyNotFound,
+				},
+				{
+					name: "GetField of a list",
+					get:  func() (interface{}, error) { return c.GetField(ctx, key("list"), "1") },
+					want: "b c",
+				},
+				{
+					name: "GetField of a dictionary",
+					get:  func() (interface{}, error) { return c.GetField(ctx, key("dict"), "k") },
+					want: "v",
+				},
+				{
+					name:    "GetField out of range",
+					get:     func() (interface{}, error) { return c.GetField(ctx, key("list"), "5") },
+					want:    "",
+					wantErr: ErrIndexOutOfRange,
+				},
+				{
+					name:    "GetString of a missing key",
+					get:     func() (interface{}, error) { return c.GetString(ctx, key("missing")) },
+					want:    "",
+					wantErr: ErrKeyNotFound,
+				},
+			}
+			for _, tt := range tests {
+				t.Run("Testing "+tt.name, func(t *testing.T) {
+					got, err := tt.get()
+					if !errors.Is(err, tt.wantErr) {
+						t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+					}
+					if !reflect.DeepEqual(got, tt.want) {
+						t.Errorf("got %#v, want %#v", got, tt.want)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestClient_KeysAndDelete(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range newClients(t) {
+		t.Run(name, func(t *testing.T) {
+			if keys, err := c.Keys(ctx); err != nil || len(keys) != 0 {
+				t.Fatalf("Keys() = %v, %v, want empty", keys, err)
+			}
+			for _, key := range []string{"a", "b"} {
+				if err := c.Set(ctx, key, "value"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if keys, err := c.Keys(ctx); err != nil || !reflect.DeepEqual(keys, []string{"a", "b"}) {
+				t.Errorf("Keys() = %v, %v, want [a b]", keys, err)
+			}
+
+			if err := c.Delete(ctx, "a"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if err := c.Delete(ctx, "b"); err != nil {
+				t.Fatalf("Delete() error = %v", err)
+			}
+			if _, err := c.GetString(ctx, "a"); !errors.Is(err, ErrKeyNotFound) {
+				t.Errorf("GetString() error = %v, wantErr %v", err, ErrKeyNotFound)
+			}
+		})
+	}
+}
+
+func TestClient_TTL(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range newClients(t) {
+		t.Run(name, func(t *testing.T) {
+			if err := c.SetWithTTL(ctx, "ttl", []string{"a"}, 50*time.Millisecond); err != nil {
+				t.Fatalf("SetWithTTL() error = %v", err)
+			}
+			if err := c.Set(ctx, "expire", "value"); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Expire(ctx, "expire", 50*time.Millisecond); err != nil {
+				t.Fatalf("Expire() error = %v", err)
+			}
+			if _, err := c.GetList(ctx, "ttl"); err != nil {
+				t.Errorf("GetList() before expiration error = %v", err)
+			}
+
+			eventually(t, "expiration", func() bool {
+				_, listErr := c.GetList(ctx, "ttl")
+				_, strErr := c.GetString(ctx, "expire")
+				return errors.Is(listErr, ErrKeyNotFound) && errors.Is(strErr, ErrKeyNotFound)
+			})
+		})
+	}
+}
+
+func TestClient_Errors(t *testing.T) {
+	ctx := context.Background()
+	clients := newClients(t)
+	for name, c := range clients {
+		t.Run(name, func(t *testing.T) {
+			if err := c.Set(ctx, readOnlyKey, "value"); !errors.Is(err, ErrReadOnly) {
+				t.Errorf("Set() error = %v, wantErr %v", err, ErrReadOnly)
+			}
+			if err := c.Set(ctx, "key", 42); !errors.Is(err, ErrType) {
+				t.Errorf("Set(int) error = %v, wantErr %v", err, ErrType)
+			}
+
+			cancelled, cancel := context.WithCancel(ctx)
+			cancel()
+			if err := c.Set(cancelled, "key", "value"); !errors.Is(err, context.Canceled) {
+				t.Errorf("Set() with cancelled context error = %v", err)
+			}
+		})
+	}
+
+	t.Run("http: wrong password", func(t *testing.T) {
+		c := NewHTTPClient(clients["http"].transport.(*httpTransport).baseURL, Options{Username: "user"})
+		defer c.Close()
+		if _, err := c.Keys(ctx); err != ErrUnauthorized {
+			t.Errorf("Keys() error = %v, wantErr %v", err, ErrUnauthorized)
+		}
+	})
+
+	t.Run("tcp: closed client", func(t *testing.T) {
+		c := NewTCPClient(clients["tcp"].transport.(*tcpTransport).addr, Options{})
+		c.Close()
+		if _, err := c.Keys(ctx); err != ErrClosed {
+			t.Errorf("Keys() error = %v, wantErr %v", err, ErrClosed)
+		}
+	})
+}
+
+// newUsersClients клиенты обоих протоколов к серверам с общими пользователями. Имя клиента: протокол/пользователь
+func newUsersClients(t *testing.T) map[string]*Client {
+	hash, err := auth.HashPassword("secret", auth.Bcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := auth.NewStore([]auth.User{
+		{Name: "admin", Password: hash, Categories: auth.Categories, Keys: []string{"*"}},
+		{Name: "reader", Password: hash, Categories: []auth.Category{auth.Read}, Keys: []string{"cache:*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := mapbased.NewStorage()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetUsers(users)
+	go tcp.Serve(lis)
+
+	srv := httpserver.NewServer("", nil, storage)
+	srv.SetUsers(users)
+	ts := httptest.NewServer(srv.Handler())
+	t.Cleanup(ts.Close)
+
+	clients := make(map[string]*Client)
+	for _, user := range []string{"admin", "reader", "anonymous"} {
+		opts := Options{MaxRetries: -1}
+		if user != "anonymous" {
+			opts.Username, opts.Password = user, "secret"
+		}
+		clients["http/"+user] = NewHTTPClient(ts.URL, opts)
+		clients["tcp/"+user] = NewTCPClient(lis.Addr().String(), opts)
+	}
+	clients["http/wrong"] = NewHTTPClient(ts.URL, Options{Username: "reader", Password: "wrong", MaxRetries: -1})
+	clients["tcp/wrong"] = NewTCPClient(lis.Addr().String(), Options{Username: "reader", Password: "wrong", MaxRetries: -1})
+	for _, c := range clients {
+		c := c
+		t.Cleanup(func() { c.Close() })
+	}
+	return clients
+}
+
+func TestClient_Permissions(t *testing.T) {
+	ctx := context.Background()
+	clients := newUsersClients(t)
+	for _, key := range []string{"cache:1", "session:1"} {
+		if err := clients["tcp/admin"].Set(ctx, key, "value"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, proto := range []string{"http", "tcp"} {
+		anonymousErr := ErrNoAuth
+		if proto == "http" {
+			anonymousErr = ErrUnauthorized
+		}
+		tests := []struct {
+			name    string
+			user    string
+			do      func(c *Client) error
+			wantErr error
+		}{
+			{
+				name: "admin writes any key",
+				user: "admin",
+				do:   func(c *Client) error { return c.Set(ctx, "session:2", "value") },
+			},
+			{
+				name: "admin lists keys",
+				user: "admin",
+				do: func(c *Client) error {
+					_, err := c.Keys(ctx)
+					return err
+				},
+			},
+			{
+				name: "reader reads allowed key",
+				user: "reader",
+				do: func(c *Client) error {
+					_, err := c.GetString(ctx, "cache:1")
+					return err
+				},
+			},
+			{
+				name: "reader reads other key",
+				user: "reader",
+				do: func(c *Client) error {
+					_, err := c.GetString(ctx, "session:1")
+					return err
+				},
+				wantErr: ErrNoPerm,
+			},
+			{
+				name:    "reader writes",
+				user:    "reader",
+				do:      func(c *Client) error { return c.Set(ctx, "cache:1", "new") },
+				wantErr: ErrNoPerm,
+			},
+			{
+				name: "reader lists keys",
+				user: "reader",
+				do: func(c *Client) error {
+					_, err := c.Keys(ctx)
+					return err
+				},
+				wantErr: ErrNoPerm,
+			},
+			{
+				name: "wrong password",
+				user: "wrong",
+				do: func(c *Client) error {
+					_, err := c.GetString(ctx, "cache:1")
+					return err
+				},
+				wantErr: ErrUnauthorized,
+			},
+			{
+				name: "anonymous",
+				user: "anonymous",
+				do: func(c *Client) error {
+					_, err := c.GetString(ctx, "cache:1")
+					return err
+				},
+				wantErr: anonymousErr,
+			},
+		}
+		for _, tt := range tests {
+			t.Run("Testing "+proto+": "+tt.name, func(t *testing.T) {
+				if err := tt.do(clients[proto+"/"+tt.user]); err != tt.wantErr {
+					t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+}
+
+func TestClient_Retry(t *testing.T) {
+	srv := httpserver.NewServer("", accounts, mapbased.NewStorage())
+	handler := srv.Handler()
+
+	var requests int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Первые два запроса завершаются ошибкой сервера
+		if atomic.AddInt32(&requests, 1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		maxRetries   int
+		wantErr      bool
+		wantRequests int32
+	}{
+		{name: "Testing retry: retries are disabled", maxRetries: -1, wantErr: true, wantRequests: 1},
+		{name: "Testing retry: request succeeds after retries", maxRetries: 3, wantErr: false, wantRequests: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			atomic.StoreInt32(&requests, 0)
+			c := NewHTTPClient(ts.URL, Options{
+				Username:        "user",
+				Password:        "pass",
+				MaxRetries:      tt.maxRetries,
+				MinRetryBackoff: time.Millisecond,
+			})
+			defer c.Close()
+
+			_, err := c.Keys(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Keys() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := atomic.LoadInt32(&requests); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+		})
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: io.EOF, want: true},
+		{err: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), want: true},
+		{err: &net.OpError{Op: "dial", Err: errors.New("connection refused")}, want: true},
+		{err: &statusError{code: http.StatusBadGateway}, want: true},
+		{err: &statusError{code: http.StatusBadRequest}, want: false},
+		{err: ErrKeyNotFound, want: false},
+		{err: &ServerError{Message: "boom"}, want: false},
+		{err: context.DeadlineExceeded, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("Testing retryable(%v)", tt.err), func(t *testing.T) {
+			if got := retryable(tt.err); got != tt.want {
+				t.Errorf("retryable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want error
+	}{
+		{msg: structs.ErrKeyNotFound.Error(), want: ErrKeyNotFound},
+		{msg: structs.ErrReadOnly.Error(), want: ErrReadOnly},
+		{msg: "MOVED 3999 127.0.0.1:6381", want: &RedirectError{Slot: 3999, Addr: "127.0.0.1:6381"}},
+		{msg: "ASK 3999 127.0.0.1:6381", want: &RedirectError{Ask: true, Slot: 3999, Addr: "127.0.0.1:6381"}},
+		{msg: "something else", want: &ServerError{Message: "something else"}},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("Testing parseError(%q)", tt.msg), func(t *testing.T) {
+			if got := parseError(tt.msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseError() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	for name, c := range newClients(t) {
+		t.Run(name, func(t *testing.T) {
+			var wg sync.WaitGroup
+			errs := make(chan error, 100)
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					key := fmt.Sprint(name, i)
+					for j := 0; j < 5; j++ {
+						value := fmt.Sprint(j)
+						if err := c.Set(ctx, key, value); err != nil {
+							errs <- err
+							return
+						}
+						if got, err := c.GetString(ctx, key); err != nil || got != value {
+							errs <- fmt.Errorf("GetString(%s) = %q, %v, want %q", key, got, err, value)
+							return
+						}
+					}
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// writeCertificate сертификат с CommonName name и его ключ в dir, подписанный parent
+// (самоподписанный, если parent nil)
+func writeCertificate(t *testing.T, dir, name string, parent *tls.Certificate) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	issuer, issuerKey := template, interface{}(key)
+	if parent == nil {
+		template.IsCA, template.BasicConstraintsValid = true, true
+	} else {
+		issuer, issuerKey = parent.Leaf, parent.PrivateKey
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".pem"), certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, name+".key"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert.Leaf, _ = x509.ParseCertificate(der)
+	return cert
+}
+
+// newTLSClients клиенты обоих протоколов с сертификатом пользователя reader и без сертификата
+func newTLSClients(t *testing.T) map[string]*Client {
+	dir, err := ioutil.TempDir("", "client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	ca := writeCertificate(t, dir, "ca", nil)
+	writeCertificate(t, dir, "server", &ca)
+	reader := writeCertificate(t, dir, "reader", &ca)
+	certs, err := auth.LoadCertificates(filepath.Join(dir, "server.pem"), filepath.Join(dir, "server.key"), filepath.Join(dir, "ca.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := certs.ServerConfig(tls.VersionTLS12, tls.VerifyClientCertIfGiven)
+
+	hash, err := auth.HashPassword("secret", auth.Bcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := auth.NewStore([]auth.User{
+		{Name: "admin", Password: hash, Categories: auth.Categories, Keys: []string{"*"}},
+		{Name: "reader", Password: hash, Categories: []auth.Category{auth.Read}, Keys: []string{"cache:*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage := mapbased.NewStorage()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetUsers(users)
+	tcp.SetTLSConfig(serverConfig)
+	go tcp.Serve(lis)
+
+	srv := httpserver.NewServer("", nil, storage)
+	srv.SetUsers(users)
+	srv.SetAuthenticators(httpserver.GroupCache, srv.CertAuth(), srv.BasicAuth())
+	ts := httptest.NewUnstartedServer(srv.Handler())
+	ts.TLS = serverConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	withCert := &tls.Config{RootCAs: roots, Certificates: []tls.Certificate{reader}}
+	withoutCert := &tls.Config{RootCAs: roots}
+	clients := map[string]*Client{
+		"http/cert":      NewHTTPClient(ts.URL, Options{TLSConfig: withCert, MaxRetries: -1}),
+		"tcp/cert":       NewTCPClient(lis.Addr().String(), Options{TLSConfig: withCert, MaxRetries: -1}),
+		"http/password":  NewHTTPClient(ts.URL, Options{TLSConfig: withoutCert, Username: "admin", Password: "secret", MaxRetries: -1}),
+		"tcp/password":   NewTCPClient(lis.Addr().String(), Options{TLSConfig: withoutCert, Username: "admin", Password: "secret", MaxRetries: -1}),
+		"http/plaintext": NewHTTPClient("http://"+strings.TrimPrefix(ts.URL, "https://"), Options{Username: "admin", Password: "secret", MaxRetries: -1}),
+		"tcp/plaintext":  NewTCPClient(lis.Addr().String(), Options{Username: "admin", Password: "secret", MaxRetries: -1, Timeout: time.Second}),
+	}
+	for _, c := range clients {
+		c := c
+		t.Cleanup(func() { c.Close() })
+	}
+	return clients
+}
+
+func TestClient_TLS(t *testing.T) {
+	ctx := context.Background()
+	clients := newTLSClients(t)
+
+	for _, proto := range []string{"http", "tcp"} {
+		tests := []struct {
+			name    string
+			client  string
+			do      func(c *Client) error
+			wantErr bool
+		}{
+			{
+				name:   "password over tls",
+				client: "password",
+				do:     func(c *Client) error { return c.Set(ctx, "cache:"+proto, "value") },
+			},
+			{
+				name:   "certificate user reads allowed key",
+				client: "cert",
+				do: func(c *Client) error {
+					_, err := c.GetString(ctx, "cache:"+proto)
+					return err
+				},
+			},
+			{
+				name:    "certificate user writes",
+				client:  "cert",
+				do:      func(c *Client) error { return c.Set(ctx, "cache:"+proto, "new") },
+				wantErr: true,
+			},
+			{
+				name:   "plaintext",
+				client: "plaintext",
+				do: func(c *Client) error {
+					_, err := c.GetString(ctx, "cache:"+proto)
+					return err
+				},
+				wantErr: true,
+			},
+		}
+		for _, tt := range tests {
+			t.Run("Testing "+proto+": "+tt.name, func(t *testing.T) {
+				if err := tt.do(clients[proto+"/"+tt.client]); (err != nil) != tt.wantErr {
+					t.Errorf("error = %v, wantErr %v", err, tt.wantErr)
+				}
+			})
+		}
+	}
+	if err := clients["tcp/cert"].Set(ctx, "cache:tcp", "new"); err != ErrNoPerm {
+		t.Errorf("certificate user write error = %v, want %v", err, ErrNoPerm)
+	}
+}
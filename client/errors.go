@@ -0,0 +1,79 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Ошибки сервера. Совпадают с ошибками пакета structs, поэтому их можно сравнивать через errors.Is
+var (
+	ErrKeyNotFound     = structs.ErrKeyNotFound
+	ErrIndexOutOfRange = structs.ErrIndexOutOfRange
+	ErrType            = structs.ErrType
+	ErrNotSupported    = structs.ErrNotSupported
+	ErrReadOnly        = structs.ErrReadOnly
+	ErrClusterDown     = structs.ErrClusterDown
+	ErrNoAuth          = structs.ErrNoAuth
+	ErrNoPerm          = structs.ErrNoPerm
+)
+
+var (
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrClosed       = errors.New("client is closed")
+)
+
+// RedirectError ключ обслуживается другим узлом кластера
+type RedirectError = structs.RedirectError
+
+// ServerError прочие ошибки, которыми ответил сервер
+type ServerError struct {
+	Message string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+var serverErrors = []error{
+	ErrKeyNotFound,
+	ErrIndexOutOfRange,
+	ErrType,
+	ErrNotSupported,
+	ErrReadOnly,
+	ErrClusterDown,
+	ErrNoAuth,
+	ErrNoPerm,
+}
+
+// parseError ошибка по тексту ответа сервера
+func parseError(msg string) error {
+	if msg == structs.ErrWrongPass.Error() {
+		return ErrUnauthorized
+	}
+	for _, err := range serverErrors {
+		if msg == err.Error() {
+			return err
+		}
+	}
+
+	// MOVED <slot> <addr> либо ASK <slot> <addr>
+	if fields := strings.Fields(msg); len(fields) == 3 && (fields[0] == "MOVED" || fields[0] == "ASK") {
+		if slot, err := strconv.Atoi(fields[1]); err == nil {
+			return &RedirectError{Ask: fields[0] == "ASK", Slot: slot, Addr: fields[2]}
+		}
+	}
+	return &ServerError{Message: msg}
+}
+
+// statusError неожиданный код ответа HTTP сервера
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("unexpected status code %d", e.code)
+}
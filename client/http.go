@@ -0,0 +1,254 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+var httpTypes = map[structs.ValueType]string{
+	structs.String:     "string",
+	structs.List:       "list",
+	structs.Dictionary: "dictionary",
+}
+
+type httpTransport struct {
+	baseURL string
+	opts    Options
+	client  *http.Client
+}
+
+func newHTTPTransport(addr string, opts Options) *httpTransport {
+	if !strings.Contains(addr, "://") {
+		if opts.TLSConfig != nil {
+			addr = "https://" + addr
+		} else {
+			addr = "http://" + addr
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = opts.TLSConfig
+	transport.MaxIdleConns = opts.PoolSize
+	transport.MaxIdleConnsPerHost = opts.PoolSize
+	return &httpTransport{
+		baseURL: strings.TrimRight(addr, "/"),
+		opts:    opts,
+		client:  &http.Client{Transport: transport},
+	}
+}
+
+func (t *httpTransport) keys(ctx context.Context) ([]string, error) {
+	var body struct {
+		Keys []string `json:"keys"`
+	}
+	if err := t.do(ctx, http.MethodGet, "/cache/keys", nil, &body); err != nil {
+		return nil, err
+	}
+	return body.Keys, nil
+}
+
+func (t *httpTransport) get(ctx context.Context, key string, vartype structs.ValueType, value interface{}) error {
+	var body struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := t.do(ctx, http.MethodGet, valuePath(key), nil, &body); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body.Value, value); err != nil {
+		// Значение ключа другого типа
+		return ErrType
+	}
+	return decodeBase64(value)
+}
+
+func (t *httpTransport) value(ctx context.Context, key string) (interface{}, error) {
+	var body struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := t.do(ctx, http.MethodGet, valuePath(key), nil, &body); err != nil {
+		return nil, err
+	}
+	for _, value := range []interface{}{new(string), new([]string), new(map[string]string)} {
+		if json.Unmarshal(body.Value, value) == nil {
+			if err := decodeBase64(value); err != nil {
+				return nil, err
+			}
+			return reflect.ValueOf(value).Elem().Interface(), nil
+		}
+	}
+	return nil, ErrType
+}
+
+func (t *httpTransport) field(ctx context.Context, key, field string) (string, error) {
+	var body struct {
+		Value string `json:"value"`
+	}
+	path := "/cache/key/" + url.PathEscape(key) + "/" + url.PathEscape(field) + "?encoding=base64"
+	if err := t.do(ctx, http.MethodGet, path, nil, &body); err != nil {
+		return "", err
+	}
+	err := decodeBase64(&body.Value)
+	return body.Value, err
+}
+
+func (t *httpTransport) set(ctx context.Context, key string, vartype structs.ValueType, value interface{}, ttl time.Duration) error {
+	path := "/cache/set/" + httpTypes[vartype] + "/" + url.PathEscape(key)
+	body := map[string]interface{}{"value": encodeBase64(value), "encoding": "base64"}
+	if err := t.do(ctx, http.MethodPut, path, body, nil); err != nil {
+		return err
+	}
+	if ttl > 0 {
+		return t.expire(ctx, key, ttl)
+	}
+	return nil
+}
+
+func (t *httpTransport) expire(ctx context.Context, key string, ttl time.Duration) error {
+	body := map[string]interface{}{"value": milliseconds(ttl)}
+	return t.do(ctx, http.MethodPost, "/cache/set/ttl/"+url.PathEscape(key), body, nil)
+}
+
+func (t *httpTransport) remove(ctx context.Context, key string) error {
+	return t.do(ctx, http.MethodDelete, "/cache/remove/"+url.PathEscape(key), nil, nil)
+}
+
+func (t *httpTransport) close() error {
+	t.client.CloseIdleConnections()
+	return nil
+}
+
+// do запрос к серверу. Тело запроса и ответа кодируются в JSON
+func (t *httpTransport) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, t.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.opts.Username != "" {
+		req.SetBasicAuth(t.opts.Username, t.opts.Password)
+	}
+
+	res, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case res.StatusCode == http.StatusUnauthorized:
+		return ErrUnauthorized
+	case res.StatusCode >= 500:
+		return &statusError{code: res.StatusCode}
+	case res.StatusCode != http.StatusOK:
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(data, &e) != nil || e.Error == "" {
+			return &statusError{code: res.StatusCode}
+		}
+		return parseError(e.Error)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
+
+// valuePath путь значения ключа. Строки значения передаются в base64: JSON не переносит
+// произвольные байты
+func valuePath(key string) string {
+	return "/cache/key/" + url.PathEscape(key) + "?encoding=base64"
+}
+
+// encodeBase64 значение со строками в base64, у словаря кодируются и ключи
+func encodeBase64(value interface{}) interface{} {
+	enc := base64.StdEncoding.EncodeToString
+	switch v := value.(type) {
+	case string:
+		return enc([]byte(v))
+	case []string:
+		list := make([]string, len(v))
+		for i, item := range v {
+			list[i] = enc([]byte(item))
+		}
+		return list
+	case map[string]string:
+		dict := make(map[string]string, len(v))
+		for k, item := range v {
+			dict[enc([]byte(k))] = enc([]byte(item))
+		}
+		return dict
+	default:
+		return value
+	}
+}
+
+// decodeBase64 декодирование строк значения, полученного с encoding=base64, на месте
+func decodeBase64(value interface{}) error {
+	dec := func(s string) (string, error) {
+		data, err := base64.StdEncoding.DecodeString(s)
+		return string(data), err
+	}
+	var err error
+	switch v := value.(type) {
+	case *string:
+		*v, err = dec(*v)
+	case *[]string:
+		for i := range *v {
+			if (*v)[i], err = dec((*v)[i]); err != nil {
+				return err
+			}
+		}
+	case *map[string]string:
+		dict := make(map[string]string, len(*v))
+		for k, item := range *v {
+			key, err := dec(k)
+			if err != nil {
+				return err
+			}
+			if dict[key], err = dec(item); err != nil {
+				return err
+			}
+		}
+		if *v != nil {
+			*v = dict
+		}
+	}
+	return err
+}
+
+// milliseconds время жизни в миллисекундах, не меньше одной
+func milliseconds(ttl time.Duration) int64 {
+	ms := int64(ttl / time.Millisecond)
+	if ms < 1 {
+		ms = 1
+	}
+	return ms
+}
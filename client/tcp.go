@@ -0,0 +1,457 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+var tcpTypes = map[structs.ValueType]string{
+	structs.String:     "string",
+	structs.List:       "list",
+	structs.Dictionary: "dictionary",
+}
+
+type tcpTransport struct {
+	addr string
+	opts Options
+
+	mu     sync.Mutex
+	idle   []*tcpConn
+	closed bool
+}
+
+// tcpConn соединение с сервером. Команды отправляются конвейером, ответы читаются по порядку
+type tcpConn struct {
+	conn   net.Conn
+	w      *resp.RequestWriter
+	r      resp.ResponseReader
+	broken bool
+}
+
+func newTCPTransport(addr string, opts Options) *tcpTransport {
+	return &tcpTransport{addr: addr, opts: opts}
+}
+
+func (t *tcpTransport) keys(ctx context.Context) ([]string, error) {
+	var keys []string
+	err := t.exec(ctx, func(cn *tcpConn) (err error) {
+		cn.w.WriteCmdString("keys")
+		if err := cn.flush(); err != nil {
+			return err
+		}
+		keys, err = cn.readList()
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (t *tcpTransport) get(ctx context.Context, key string, vartype structs.ValueType, value interface{}) error {
+	return t.exec(ctx, func(cn *tcpConn) error {
+		val, err := cn.value(key)
+		if err != nil {
+			return err
+		}
+		return assignValue(val, value)
+	})
+}
+
+func (t *tcpTransport) value(ctx context.Context, key string) (interface{}, error) {
+	var value interface{}
+	err := t.exec(ctx, func(cn *tcpConn) (err error) {
+		value, err = cn.value(key)
+		return err
+	})
+	return value, err
+}
+
+func (t *tcpTransport) field(ctx context.Context, key, field string) (string, error) {
+	var value string
+	err := t.exec(ctx, func(cn *tcpConn) (err error) {
+		cn.w.WriteCmdString("ikey", key, field)
+		if err := cn.flush(); err != nil {
+			return err
+		}
+		value, err = cn.readInline()
+		return err
+	})
+	return value, err
+}
+
+func (t *tcpTransport) set(ctx context.Context, key string, vartype structs.ValueType, value interface{}, ttl time.Duration) error {
+	arg, ok := value.(string)
+	if !ok {
+		// Элементы списка и словаря передаются в base64, JSON допускает только корректный UTF-8
+		data, err := json.Marshal(map[string]interface{}{"value": encodeBase64(value), "encoding": "base64"})
+		if err != nil {
+			return err
+		}
+		arg = string(data)
+	}
+
+	return t.exec(ctx, func(cn *tcpConn) error {
+		cn.w.WriteCmdString("set", tcpTypes[vartype], key, arg)
+		if ttl > 0 {
+			cn.w.WriteCmdString("expire", key, strconv.FormatInt(milliseconds(ttl), 10))
+		}
+		if err := cn.flush(); err != nil {
+			return err
+		}
+		_, err := cn.readInline()
+		if ttl > 0 && !cn.broken {
+			_, expireErr := cn.readInline()
+			err = firstError(err, expireErr)
+		}
+		return err
+	})
+}
+
+func (t *tcpTransport) expire(ctx context.Context, key string, ttl time.Duration) error {
+	return t.command(ctx, "expire", key, strconv.FormatInt(milliseconds(ttl), 10))
+}
+
+func (t *tcpTransport) remove(ctx context.Context, key string) error {
+	return t.command(ctx, "remove", key)
+}
+
+// command выполнение команды, ответ на которую - строка состояния
+func (t *tcpTransport) command(ctx context.Context, name string, args ...string) error {
+	return t.exec(ctx, func(cn *tcpConn) error {
+		cn.w.WriteCmdString(name, args...)
+		if err := cn.flush(); err != nil {
+			return err
+		}
+		_, err := cn.readInline()
+		return err
+	})
+}
+
+func (t *tcpTransport) close() error {
+	t.mu.Lock()
+	idle := t.idle
+	t.idle, t.closed = nil, true
+	t.mu.Unlock()
+
+	for _, cn := range idle {
+		cn.conn.Close()
+	}
+	return nil
+}
+
+// exec выполнение fn на соединении из пула. Отмена контекста прерывает ожидание ответа
+func (t *tcpTransport) exec(ctx context.Context, fn func(cn *tcpConn) error) error {
+	cn, err := t.conn(ctx)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := cn.conn.SetDeadline(deadline); err != nil {
+			cn.conn.Close()
+			return err
+		}
+	}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			cn.conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+
+	err = fn(cn)
+	close(done)
+	t.put(cn)
+	return err
+}
+
+func (t *tcpTransport) conn(ctx context.Context) (*tcpConn, error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(t.idle); n > 0 {
+		cn := t.idle[n-1]
+		t.idle = t.idle[:n-1]
+		t.mu.Unlock()
+		return cn, nil
+	}
+	t.mu.Unlock()
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", t.addr)
+	if err != nil {
+		return nil, err
+	}
+	if t.opts.TLSConfig != nil {
+		if conn, err = t.handshake(ctx, conn); err != nil {
+			return nil, err
+		}
+	}
+	cn := &tcpConn{conn: conn, w: resp.NewRequestWriter(conn), r: resp.NewResponseReader(conn)}
+	if t.opts.Username != "" || t.opts.Password != "" {
+		if err := cn.auth(ctx, t.opts.Username, t.opts.Password); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return cn, nil
+}
+
+// handshake установка соединения TLS. Имя сервера для проверки сертификата по умолчанию берётся из адреса
+func (t *tcpTransport) handshake(ctx context.Context, conn net.Conn) (net.Conn, error) {
+	cfg := t.opts.TLSConfig
+	if cfg.ServerName == "" {
+		host, _, err := net.SplitHostPort(t.addr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		cfg = cfg.Clone()
+		cfg.ServerName = host
+	}
+	tc := tls.Client(conn, cfg)
+	if deadline, ok := ctx.Deadline(); ok {
+		tc.SetDeadline(deadline)
+		defer tc.SetDeadline(time.Time{})
+	}
+	if err := tc.Handshake(); err != nil {
+		tc.Close()
+		return nil, err
+	}
+	return tc, nil
+}
+
+// auth вход пользователя в новом соединении
+func (cn *tcpConn) auth(ctx context.Context, username, password string) error {
+	if deadline, ok := ctx.Deadline(); ok {
+		cn.conn.SetDeadline(deadline)
+		defer cn.conn.SetDeadline(time.Time{})
+	}
+	if username != "" {
+		cn.w.WriteCmdString("auth", username, password)
+	} else {
+		cn.w.WriteCmdString("auth", password)
+	}
+	if err := cn.flush(); err != nil {
+		return err
+	}
+	_, err := cn.readInline()
+	return err
+}
+
+// put возврат соединения в пул. Соединение с ошибкой чтения закрывается вместе с простаивающими
+func (t *tcpTransport) put(cn *tcpConn) {
+	if !cn.broken {
+		cn.broken = cn.conn.SetDeadline(time.Time{}) != nil
+	}
+
+	var stale []*tcpConn
+	t.mu.Lock()
+	switch {
+	case cn.broken:
+		// Скорее всего сервер перезапущен, и остальные соединения тоже разорваны
+		stale, t.idle = t.idle, nil
+		stale = append(stale, cn)
+	case t.closed || len(t.idle) >= t.opts.PoolSize:
+		stale = append(stale, cn)
+	default:
+		t.idle = append(t.idle, cn)
+	}
+	t.mu.Unlock()
+
+	for _, cn := range stale {
+		cn.conn.Close()
+	}
+}
+
+func (cn *tcpConn) flush() error {
+	err := cn.w.Flush()
+	if err != nil {
+		cn.broken = true
+	}
+	return err
+}
+
+// readInline чтение строки состояния или bulk строки значения. Ошибка сервера возвращается
+// как ошибка клиента, сбой чтения помечает соединение испорченным
+func (cn *tcpConn) readInline() (string, error) {
+	if cn.broken {
+		return "", fmt.Errorf("connection is broken")
+	}
+
+	t, err := cn.r.PeekType()
+	if err != nil {
+		cn.broken = true
+		return "", err
+	}
+	switch t {
+	case resp.TypeInline:
+		s, err := cn.r.ReadInlineString()
+		cn.broken = err != nil
+		return s, err
+	case resp.TypeBulk:
+		s, err := cn.r.ReadBulkString()
+		cn.broken = err != nil
+		return s, err
+	case resp.TypeError:
+		msg, err := cn.r.ReadError()
+		if err != nil {
+			cn.broken = true
+			return "", err
+		}
+		return "", parseError(msg)
+	default:
+		cn.broken = true
+		return "", fmt.Errorf("unexpected response type %s", t)
+	}
+}
+
+// value значение ключа. Тип определяется по виду ответа: bulk строка - строка, массив строк - список,
+// массив пар - словарь. Пустой массив возвращается пустым списком
+func (cn *tcpConn) value(key string) (interface{}, error) {
+	cn.w.WriteCmdString("key", key)
+	if err := cn.flush(); err != nil {
+		return nil, err
+	}
+	if cn.broken {
+		return nil, fmt.Errorf("connection is broken")
+	}
+
+	t, err := cn.r.PeekType()
+	if err != nil {
+		cn.broken = true
+		return nil, err
+	}
+	if t != resp.TypeArray {
+		s, err := cn.readInline()
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	}
+	n, err := cn.r.ReadArrayLen()
+	if err != nil {
+		cn.broken = true
+		return nil, err
+	}
+	if n == 0 {
+		return []string{}, nil
+	}
+	if t, err = cn.r.PeekType(); err != nil {
+		cn.broken = true
+		return nil, err
+	}
+	if t != resp.TypeArray {
+		list := make([]string, n)
+		for i := range list {
+			if list[i], err = cn.readBulk(); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	dict := make(map[string]string, n)
+	for i := 0; i < n; i++ {
+		pair, err := cn.readList()
+		if err != nil {
+			return nil, err
+		}
+		if len(pair) != 2 {
+			cn.broken = true
+			return nil, fmt.Errorf("unexpected dictionary pair of %d items", len(pair))
+		}
+		dict[pair[0]] = pair[1]
+	}
+	return dict, nil
+}
+
+// readList чтение массива bulk строк
+func (cn *tcpConn) readList() ([]string, error) {
+	if cn.broken {
+		return nil, fmt.Errorf("connection is broken")
+	}
+
+	t, err := cn.r.PeekType()
+	if err != nil {
+		cn.broken = true
+		return nil, err
+	}
+	if t != resp.TypeArray {
+		// Ошибка сервера или неожиданный ответ
+		_, err := cn.readInline()
+		if err == nil {
+			cn.broken = true
+			err = fmt.Errorf("unexpected response type %s", t)
+		}
+		return nil, err
+	}
+	n, err := cn.r.ReadArrayLen()
+	if err != nil {
+		cn.broken = true
+		return nil, err
+	}
+	list := make([]string, n)
+	for i := range list {
+		if list[i], err = cn.readBulk(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+// readBulk чтение элемента массива - bulk строки
+func (cn *tcpConn) readBulk() (string, error) {
+	s, err := cn.r.ReadBulkString()
+	cn.broken = err != nil
+	return s, err
+}
+
+// assignValue запись значения val в value по указателю того же типа, иначе ErrType.
+// Пустой список подходит и для словаря: по ответу их не отличить
+func assignValue(val interface{}, value interface{}) error {
+	switch v := val.(type) {
+	case string:
+		if p, ok := value.(*string); ok {
+			*p = v
+			return nil
+		}
+	case []string:
+		if p, ok := value.(*[]string); ok {
+			*p = v
+			return nil
+		}
+		if p, ok := value.(*map[string]string); ok && len(v) == 0 {
+			*p = map[string]string{}
+			return nil
+		}
+	case map[string]string:
+		if p, ok := value.(*map[string]string); ok {
+			*p = v
+			return nil
+		}
+	}
+	return ErrType
+}
+
+func firstError(errs ...error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
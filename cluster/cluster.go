@@ -0,0 +1,277 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+var ErrUnknownNode = errors.New("unknown cluster node")
+
+// Node узел кластера
+type Node struct {
+	ID string `json:"id"`
+	// Addr TCP адрес узла
+	Addr string `json:"addr"`
+	// HTTPAddr HTTP адрес узла, может быть пустым
+	HTTPAddr string `json:"http_addr,omitempty"`
+}
+
+// Storage хранилище узла. Снимки ключей используются для переноса ключей между узлами
+type Storage interface {
+	structs.Storage
+	structs.Snapshotter
+}
+
+// SlotAssignment диапазон слотов, обслуживаемый узлом
+type SlotAssignment struct {
+	Range SlotRange
+	Node  Node
+}
+
+// Cluster состояние кластера с точки зрения одного узла: владельцы слотов и слоты, переносимые
+// в данный момент. Изменения владельцев рассылаются остальным узлам при миграции слотов
+type Cluster struct {
+	self    string
+	storage Storage
+
+	mu        sync.RWMutex
+	nodes     map[string]Node
+	slots     [SlotCount]string
+	migrating map[int]string
+	importing map[int]string
+
+	moveMu  sync.Mutex
+	peersMu sync.Mutex
+	peers   map[string]*peer
+	// tlsConfig соединения с другими узлами по TLS, nil - без шифрования
+	tlsConfig *tls.Config
+}
+
+// New создание узла self кластера nodes. Слоты делятся между узлами поровну непрерывными диапазонами
+// в порядке идентификаторов, поэтому все узлы, запущенные с одним списком, получают одинаковое распределение
+func New(self string, nodes []Node, storage Storage) (*Cluster, error) {
+	c := &Cluster{
+		self:      self,
+		storage:   storage,
+		nodes:     make(map[string]Node),
+		migrating: make(map[int]string),
+		importing: make(map[int]string),
+		peers:     make(map[string]*peer),
+	}
+	for _, node := range nodes {
+		c.nodes[node.ID] = node
+	}
+	if _, ok := c.nodes[self]; !ok {
+		return nil, fmt.Errorf("node %q is not in the cluster: %w", self, ErrUnknownNode)
+	}
+
+	ids := c.sortedIDs()
+	for i, id := range ids {
+		start, end := i*SlotCount/len(ids), (i+1)*SlotCount/len(ids)
+		for slot := start; slot < end; slot++ {
+			c.slots[slot] = id
+		}
+	}
+	return c, nil
+}
+
+// ParseNodes разбор списка узлов вида id=host:tcp_port/host:http_port,...
+func ParseNodes(s string) ([]Node, error) {
+	var nodes []Node
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return nil, fmt.Errorf("invalid cluster node %q, want id=host:port[/host:http_port]", item)
+		}
+		addrs := strings.SplitN(kv[1], "/", 2)
+		node := Node{ID: kv[0], Addr: addrs[0]}
+		if len(addrs) == 2 {
+			node.HTTPAddr = addrs[1]
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// Self идентификатор узла
+func (c *Cluster) Self() string {
+	return c.self
+}
+
+// Nodes узлы кластера по возрастанию идентификаторов
+func (c *Cluster) Nodes() []Node {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	result := make([]Node, 0, len(c.nodes))
+	for _, id := range c.sortedIDs() {
+		result = append(result, c.nodes[id])
+	}
+	return result
+}
+
+// Slots распределение слотов по узлам. Неназначенные слоты не входят в результат
+func (c *Cluster) Slots() []SlotAssignment {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var result []SlotAssignment
+	for slot := 0; slot < SlotCount; slot++ {
+		owner := c.slots[slot]
+		if owner == "" {
+			continue
+		}
+		if n := len(result); n > 0 && result[n-1].Node.ID == owner && result[n-1].Range.End == slot-1 {
+			result[n-1].Range.End = slot
+			continue
+		}
+		result = append(result, SlotAssignment{Range: SlotRange{Start: slot, End: slot}, Node: c.nodes[owner]})
+	}
+	return result
+}
+
+// Owner узел, обслуживающий слот
+func (c *Cluster) Owner(slot int) (Node, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	node, ok := c.nodes[c.slots[slot]]
+	return node, ok
+}
+
+// AddNode добавление узла в кластер либо изменение его адресов. Слоты узлу не назначаются
+func (c *Cluster) AddNode(node Node) {
+	c.mu.Lock()
+	c.nodes[node.ID] = node
+	c.mu.Unlock()
+}
+
+// SetSlot назначение владельца слота. Миграция слота при этом считается завершённой
+func (c *Cluster) SetSlot(slot int, id string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.nodes[id]; !ok {
+		return ErrUnknownNode
+	}
+	c.slots[slot] = id
+	delete(c.migrating, slot)
+	delete(c.importing, slot)
+	return nil
+}
+
+// SetMigrating слот этого узла переносится на узел target
+func (c *Cluster) SetMigrating(slot int, target string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.nodes[target]; !ok {
+		return ErrUnknownNode
+	}
+	if c.slots[slot] != c.self {
+		return fmt.Errorf("slot %d is not served by %s", slot, c.self)
+	}
+	c.migrating[slot] = target
+	return nil
+}
+
+// SetImporting слот переносится на этот узел с узла source
+func (c *Cluster) SetImporting(slot int, source string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.nodes[source]; !ok {
+		return ErrUnknownNode
+	}
+	c.importing[slot] = source
+	return nil
+}
+
+// SetStable отмена миграции слота
+func (c *Cluster) SetStable(slot int) {
+	c.mu.Lock()
+	delete(c.migrating, slot)
+	delete(c.importing, slot)
+	c.mu.Unlock()
+}
+
+// Guard проверка, обслуживает ли узел ключ. Ключи чужих слотов перенаправляются ошибкой MOVED.
+// Во время миграции слота на этом узле остаются только ещё не перенесённые ключи: чтение отсутствующего
+// ключа и любая запись (ключ предварительно переносится) перенаправляются на новый узел ошибкой ASK
+func (c *Cluster) Guard(ctx context.Context, key string, write bool) error {
+	if key == "" {
+		// Операции над всем пространством ключей выполняются над ключами этого узла
+		return nil
+	}
+
+	slot := KeySlot(key)
+	c.mu.RLock()
+	owner, migrating, importing := c.slots[slot], c.migrating[slot], c.importing[slot]
+	c.mu.RUnlock()
+
+	switch {
+	case owner == c.self && migrating == "":
+		return nil
+	case owner == c.self:
+		if !write {
+			if _, err := c.storage.GetType(key); err == nil {
+				return nil
+			}
+		} else if err := c.moveKey(ctx, key, migrating); err != nil {
+			return err
+		}
+		return c.redirect(true, slot, migrating)
+	case importing != "" && structs.IsAsking(ctx):
+		return nil
+	case owner == "":
+		return structs.ErrClusterDown
+	default:
+		return c.redirect(false, slot, owner)
+	}
+}
+
+func (c *Cluster) redirect(ask bool, slot int, id string) error {
+	c.mu.RLock()
+	node := c.nodes[id]
+	c.mu.RUnlock()
+	return &structs.RedirectError{Ask: ask, Slot: slot, Addr: node.Addr, HTTPAddr: node.HTTPAddr}
+}
+
+// keysInSlots ключи этого узла, сгруппированные по слотам диапазона r
+func (c *Cluster) keysInSlots(r SlotRange) map[int][]string {
+	result := make(map[int][]string)
+	for _, key := range c.storage.GetKeys() {
+		if slot := KeySlot(key); slot >= r.Start && slot <= r.End {
+			result[slot] = append(result[slot], key)
+		}
+	}
+	return result
+}
+
+func (c *Cluster) node(id string) (Node, error) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	node, ok := c.nodes[id]
+	if !ok {
+		return Node{}, ErrUnknownNode
+	}
+	return node, nil
+}
+
+func (c *Cluster) sortedIDs() []string {
+	ids := make([]string, 0, len(c.nodes))
+	for id := range c.nodes {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,342 @@
+package cluster
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+)
+
+var accounts = map[string]string{"user": "pass"}
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+type testNode struct {
+	cluster *Cluster
+	storage *mapbased.Storage
+	http    *httptest.Server
+}
+
+// startCluster запуск узлов n1..n<size> на случайных портах localhost
+func startCluster(t *testing.T, size int) map[string]*testNode {
+	var (
+		nodes     []Node
+		listeners = make(map[string]net.Listener)
+		servers   = make(map[string]*httptest.Server)
+	)
+	for i := 1; i <= size; i++ {
+		id := fmt.Sprint("n", i)
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { lis.Close() })
+		ts := httptest.NewUnstartedServer(nil)
+		t.Cleanup(ts.Close)
+
+		listeners[id], servers[id] = lis, ts
+		nodes = append(nodes, Node{ID: id, Addr: lis.Addr().String(), HTTPAddr: ts.Listener.Addr().String()})
+	}
+
+	result := make(map[string]*testNode)
+	for _, node := range nodes {
+		storage := mapbased.NewStorage()
+		c, err := New(node.ID, nodes, storage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tcp := tcpserver.NewServer("", storage)
+		tcp.SetGuard(c.Guard)
+		tcp.Handle("cluster", c)
+		go tcp.Serve(listeners[node.ID])
+
+		srv := httpserver.NewServer("", accounts, storage)
+		srv.SetGuard(c.Guard)
+		ts := servers[node.ID]
+		ts.Config.Handler = srv.Handler()
+		ts.Start()
+
+		result[node.ID] = &testNode{cluster: c, storage: storage, http: ts}
+	}
+	return result
+}
+
+// keyFor ключ, слот которого обслуживает узел id
+func keyFor(c *Cluster, id string) string {
+	for i := 0; ; i++ {
+		key := fmt.Sprint("key", i)
+		if owner, _ := c.Owner(KeySlot(key)); owner.ID == id {
+			return key
+		}
+	}
+}
+
+// tcpConn соединение с TCP портом узла
+type tcpConn struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, node *testNode) *tcpConn {
+	owner, _ := node.cluster.node(node.cluster.Self())
+	conn, err := net.Dial("tcp", owner.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &tcpConn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// do выполнение inline команды, возвращает первую строку ответа
+func (c *tcpConn) do(command string) string {
+	c.t.Helper()
+	fmt.Fprintf(c.conn, "%s\r\n", command)
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	// Значение ключа приходит bulk строкой
+	if strings.HasPrefix(line, "$") && strings.TrimSpace(line) != "$-1" {
+		if line, err = c.r.ReadString('\n'); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return strings.TrimSpace(line)
+}
+
+func TestKeySlot(t *testing.T) {
+	tests := []struct {
+		key  string
+		want int
+	}{
+		{key: "123456789", want: 12739},
+		{key: "foo", want: 12182},
+		{key: "bar", want: 5061},
+		{key: "{user1000}.following", want: KeySlot("user1000")},
+		{key: "{user1000}.followers", want: KeySlot("user1000")},
+		{key: "foo{}{bar}", want: int(crc16("foo{}{bar}")) % SlotCount},
+		{key: "foo{{bar}}zap", want: KeySlot("{bar")},
+		{key: "foo{bar}{zap}", want: KeySlot("bar")},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("Testing KeySlot(%q)", tt.key), func(t *testing.T) {
+			if got := KeySlot(tt.key); got != tt.want {
+				t.Errorf("KeySlot() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew_Slots(t *testing.T) {
+	storage := mapbased.NewStorage()
+	c, err := New("b", []Node{{ID: "c"}, {ID: "a"}, {ID: "b"}}, storage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []SlotAssignment{
+		{Range: SlotRange{Start: 0, End: 5460}, Node: Node{ID: "a"}},
+		{Range: SlotRange{Start: 5461, End: 10921}, Node: Node{ID: "b"}},
+		{Range: SlotRange{Start: 10922, End: 16383}, Node: Node{ID: "c"}},
+	}
+	if got := c.Slots(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Slots() = %+v, want %+v", got, want)
+	}
+
+	if _, err := New("d", []Node{{ID: "a"}}, storage); err == nil {
+		t.Error("New() with unknown self node succeeded")
+	}
+}
+
+func TestCluster_Redirect(t *testing.T) {
+	nodes := startCluster(t, 2)
+	key := keyFor(nodes["n1"].cluster, "n2")
+	n2, _ := nodes["n1"].cluster.node("n2")
+	moved := fmt.Sprintf("-MOVED %d %s", KeySlot(key), n2.Addr)
+
+	conn1, conn2 := dial(t, nodes["n1"]), dial(t, nodes["n2"])
+	tests := []struct {
+		conn    *tcpConn
+		command string
+		want    string
+	}{
+		{conn: conn1, command: "set string " + key + " value", want: moved},
+		{conn: conn2, command: "set string " + key + " value", want: "+OK"},
+		{conn: conn1, command: "key " + key, want: moved},
+		{conn: conn1, command: "cluster keyslot " + key, want: fmt.Sprint(":", KeySlot(key))},
+	}
+	for _, tt := range tests {
+		if got := tt.conn.do(tt.command); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.command, got, tt.want)
+		}
+	}
+	if _, err := nodes["n1"].storage.GetElement(key); err != structs.ErrKeyNotFound {
+		t.Errorf("n1 GetElement() error = %v, want %v", err, structs.ErrKeyNotFound)
+	}
+	if _, err := nodes["n2"].storage.GetElement(key); err != nil {
+		t.Errorf("n2 GetElement() error = %v", err)
+	}
+}
+
+func TestCluster_Migrate(t *testing.T) {
+	nodes := startCluster(t, 3)
+	source, target := nodes["n1"], nodes["n2"]
+	key := keyFor(source.cluster, "n1")
+	slot := KeySlot(key)
+
+	source.storage.PutOrUpdateString(key, "value")
+	source.storage.SetExpired(key, 60000)
+	tagged := fmt.Sprintf("{%s}.list", key)
+	source.storage.PutOrUpdateList(tagged, []string{"a", "b"})
+	before, _ := source.storage.DumpKey(key)
+
+	if got := dial(t, source).do(fmt.Sprintf("cluster migrate %d n2", slot)); got != "+OK" {
+		t.Fatalf("cluster migrate: got %q", got)
+	}
+
+	if after, err := target.storage.DumpKey(key); err != nil || !reflect.DeepEqual(after, before) {
+		t.Errorf("target DumpKey() = %+v, %v, want %+v", after, err, before)
+	}
+	if val, _ := target.storage.GetElement(tagged); !reflect.DeepEqual(val, []string{"a", "b"}) {
+		t.Errorf("target GetElement(%q) = %v", tagged, val)
+	}
+	if keys := source.storage.GetKeys(); len(keys) != 0 {
+		t.Errorf("source keys = %v, want none", keys)
+	}
+	for id, node := range nodes {
+		if owner, _ := node.cluster.Owner(slot); owner.ID != "n2" {
+			t.Errorf("%s: Owner(%d) = %s, want n2", id, slot, owner.ID)
+		}
+	}
+
+	n2, _ := source.cluster.node("n2")
+	if got, want := dial(t, source).do("key "+key), fmt.Sprintf("-MOVED %d %s", slot, n2.Addr); got != want {
+		t.Errorf("key on source: got %q, want %q", got, want)
+	}
+}
+
+func TestCluster_MigratingSlot(t *testing.T) {
+	nodes := startCluster(t, 2)
+	source, target := nodes["n1"], nodes["n2"]
+	n2, _ := source.cluster.node("n2")
+
+	key := keyFor(source.cluster, "n1")
+	slot := KeySlot(key)
+	other := fmt.Sprintf("{%s}.other", key)
+	source.storage.PutOrUpdateString(key, "value")
+	source.storage.PutOrUpdateString(other, "value")
+
+	if err := target.cluster.SetImporting(slot, "n1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := source.cluster.SetMigrating(slot, "n2"); err != nil {
+		t.Fatal(err)
+	}
+	ask := &structs.RedirectError{Ask: true, Slot: slot, Addr: n2.Addr, HTTPAddr: n2.HTTPAddr}
+	n1, _ := source.cluster.node("n1")
+	moved := &structs.RedirectError{Slot: slot, Addr: n1.Addr, HTTPAddr: n1.HTTPAddr}
+
+	tests := []struct {
+		name    string
+		node    *testNode
+		ctx     context.Context
+		key     string
+		write   bool
+		wantErr error
+	}{
+		{name: "read of existing key is served by source", node: source, key: key},
+		{name: "read of missing key is redirected", node: source, key: "{" + key + "}.missing", wantErr: ask},
+		{name: "write moves the key and is redirected", node: source, key: other, write: true, wantErr: ask},
+		{name: "target redirects without ASKING", node: target, key: other, write: true, wantErr: moved},
+		{name: "target accepts ASKING", node: target, ctx: structs.WithAsking(context.Background()), key: other, write: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing migrating slot: "+tt.name, func(t *testing.T) {
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			if err := tt.node.cluster.Guard(ctx, tt.key, tt.write); !reflect.DeepEqual(err, tt.wantErr) {
+				t.Errorf("Guard() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := source.storage.GetElement(other); err != structs.ErrKeyNotFound {
+		t.Errorf("source GetElement(%q) error = %v, want moved key", other, err)
+	}
+	if val, _ := target.storage.GetElement(other); val != "value" {
+		t.Errorf("target GetElement(%q) = %v, want value", other, val)
+	}
+
+	// ASKING действует только на следующую команду
+	conn := dial(t, target)
+	for _, step := range []struct{ command, want string }{
+		{command: "asking", want: "+OK"},
+		{command: "key " + other, want: "value"},
+		{command: "key " + other, want: "-" + moved.Error()},
+	} {
+		if got := conn.do(step.command); got != step.want {
+			t.Errorf("%s: got %q, want %q", step.command, got, step.want)
+		}
+	}
+}
+
+func TestCluster_HTTPRedirect(t *testing.T) {
+	nodes := startCluster(t, 2)
+	key := keyFor(nodes["n1"].cluster, "n2")
+	nodes["n2"].storage.PutOrUpdateString(key, "value")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	req, _ := http.NewRequest(http.MethodGet, nodes["n1"].http.URL+"/cache/key/"+key, nil)
+	req.SetBasicAuth("user", "pass")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	want := nodes["n2"].http.URL + "/cache/key/" + key
+	if res.StatusCode != http.StatusTemporaryRedirect || res.Header.Get("Location") != want {
+		t.Fatalf("GET = %d %q, want %d %q", res.StatusCode, res.Header.Get("Location"), http.StatusTemporaryRedirect, want)
+	}
+
+	// Во время миграции запрос проксируется на новый узел
+	slot := KeySlot(key)
+	if err := nodes["n1"].cluster.SetImporting(slot, "n2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodes["n2"].cluster.SetMigrating(slot, "n1"); err != nil {
+		t.Fatal(err)
+	}
+	nodes["n2"].storage.RemoveElement(key)
+	nodes["n1"].storage.PutOrUpdateString(key, "migrated")
+
+	req, _ = http.NewRequest(http.MethodGet, nodes["n2"].http.URL+"/cache/key/"+key, nil)
+	req.SetBasicAuth("user", "pass")
+	res, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), "migrated") {
+		t.Errorf("GET = %d %s, want proxied value", res.StatusCode, body)
+	}
+}
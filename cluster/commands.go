@@ -0,0 +1,144 @@
+package cluster
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+)
+
+const errClusterMsg = `Cluster management
+Examples:
+  cluster nodes
+  cluster slots
+  cluster keyslot <key>
+  cluster countkeysinslot <slot>
+  cluster meet <id> <host:port> [<host:http_port>]
+  cluster setslot <slot> node|importing|migrating <id>
+  cluster setslot <slot> stable
+  cluster migrate <slot>|<start-end> <id>
+`
+
+// ServeRedeo команда cluster
+func (c *Cluster) ServeRedeo(w resp.ResponseWriter, cmd *resp.Command) {
+	if cmd.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(cmd.Name))
+		w.AppendError(errClusterMsg)
+		return
+	}
+
+	args := make([]string, cmd.ArgN())
+	for i := range args {
+		args[i] = cmd.Arg(i).String()
+	}
+
+	var err error
+	switch sub := strings.ToLower(args[0]); {
+	case sub == "nodes" && len(args) == 1:
+		w.AppendBulk(c.describeNodes())
+		return
+	case sub == "slots" && len(args) == 1:
+		c.appendSlots(w)
+		return
+	case sub == "keyslot" && len(args) == 2:
+		w.AppendInt(int64(KeySlot(args[1])))
+		return
+	case sub == "countkeysinslot" && len(args) == 2:
+		var slot int
+		if slot, err = parseSlot(args[1]); err == nil {
+			w.AppendInt(int64(len(c.keysInSlots(SlotRange{Start: slot, End: slot})[slot])))
+			return
+		}
+	case sub == "meet" && (len(args) == 3 || len(args) == 4):
+		node := Node{ID: args[1], Addr: args[2]}
+		if len(args) == 4 {
+			node.HTTPAddr = args[3]
+		}
+		c.AddNode(node)
+	case sub == "setslot" && (len(args) == 3 || len(args) == 4):
+		err = c.setSlot(args[1:])
+	case sub == "restore" && len(args) == 3:
+		err = c.restore(args[1], cmd.Arg(2))
+	case sub == "migrate" && len(args) == 3:
+		var r SlotRange
+		if r, err = ParseSlotRange(args[1]); err == nil {
+			err = c.Migrate(cmd.Context(), r, args[2])
+		}
+	default:
+		w.AppendError(redeo.UnknownCommand(fmt.Sprintf("%s %s", cmd.Name, sub)))
+		w.AppendError(errClusterMsg)
+		return
+	}
+
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendOK()
+}
+
+// setSlot <slot> node|importing|migrating <id> либо <slot> stable
+func (c *Cluster) setSlot(args []string) error {
+	slot, err := parseSlot(args[0])
+	if err != nil {
+		return err
+	}
+
+	action := strings.ToLower(args[1])
+	if action == "stable" && len(args) == 2 {
+		c.SetStable(slot)
+		return nil
+	}
+	if len(args) != 3 {
+		return fmt.Errorf("invalid setslot arguments")
+	}
+	switch action {
+	case "node":
+		return c.SetSlot(slot, args[2])
+	case "importing":
+		return c.SetImporting(slot, args[2])
+	case "migrating":
+		return c.SetMigrating(slot, args[2])
+	default:
+		return fmt.Errorf("invalid setslot action %q", action)
+	}
+}
+
+// describeNodes по строке на узел: <id> <addr> <http_addr> [myself] <slots>...
+func (c *Cluster) describeNodes() []byte {
+	slots := make(map[string][]string)
+	for _, a := range c.Slots() {
+		slots[a.Node.ID] = append(slots[a.Node.ID], a.Range.String())
+	}
+
+	var buf bytes.Buffer
+	for _, node := range c.Nodes() {
+		httpAddr := node.HTTPAddr
+		if httpAddr == "" {
+			httpAddr = "-"
+		}
+		fields := []string{node.ID, node.Addr, httpAddr}
+		if node.ID == c.self {
+			fields = append(fields, "myself")
+		}
+		fields = append(fields, slots[node.ID]...)
+		buf.WriteString(strings.Join(fields, " "))
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// appendSlots массив диапазонов: [start, end, addr, id]
+func (c *Cluster) appendSlots(w resp.ResponseWriter) {
+	slots := c.Slots()
+	w.AppendArrayLen(len(slots))
+	for _, a := range slots {
+		w.AppendArrayLen(4)
+		w.AppendInt(int64(a.Range.Start))
+		w.AppendInt(int64(a.Range.End))
+		w.AppendBulkString(a.Node.Addr)
+		w.AppendBulkString(a.Node.ID)
+	}
+}
@@ -0,0 +1,223 @@
+package cluster
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+const callTimeout = 5 * time.Second
+
+// Migrate перенос слотов r этого узла на узел target без остановки обслуживания.
+// Для каждого слота: узел target начинает принимать ключи слота (importing), ключи переносятся по одному,
+// после чего владелец слота меняется на всех узлах кластера. Группы потребителей потоков не переносятся
+func (c *Cluster) Migrate(ctx context.Context, r SlotRange, target string) error {
+	node, err := c.node(target)
+	if err != nil {
+		return err
+	}
+	if target == c.self {
+		return fmt.Errorf("can't migrate slots to the node itself")
+	}
+
+	keys := c.keysInSlots(r)
+	for slot := r.Start; slot <= r.End; slot++ {
+		if err := c.migrateSlot(ctx, slot, node, keys[slot]); err != nil {
+			return fmt.Errorf("slot %d: %w", slot, err)
+		}
+	}
+
+	// Ключи, записанные в момент начала миграции слота, переносятся повторно
+	for _, keys := range c.keysInSlots(r) {
+		for _, key := range keys {
+			if err := c.moveKey(ctx, key, target); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Cluster) migrateSlot(ctx context.Context, slot int, target Node, keys []string) error {
+	if err := c.call(ctx, target, "cluster", "setslot", strconv.Itoa(slot), "importing", c.self); err != nil {
+		return err
+	}
+	if err := c.SetMigrating(slot, target.ID); err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := c.moveKey(ctx, key, target.ID); err != nil {
+			return err
+		}
+	}
+
+	// Сначала слот получает новый узел, затем этот, после чего остальные узлы
+	if err := c.call(ctx, target, "cluster", "setslot", strconv.Itoa(slot), "node", target.ID); err != nil {
+		return err
+	}
+	if err := c.SetSlot(slot, target.ID); err != nil {
+		return err
+	}
+	for _, node := range c.Nodes() {
+		if node.ID == c.self || node.ID == target.ID {
+			continue
+		}
+		if err := c.call(ctx, node, "cluster", "setslot", strconv.Itoa(slot), "node", target.ID); err != nil {
+			// Узел с устаревшими сведениями перенаправит клиента на прежнего владельца, а тот - на нового
+			log.Printf("cluster: can't update slot %d owner on %s: %v", slot, node.ID, err)
+		}
+	}
+	return nil
+}
+
+// moveKey перенос ключа на узел target. Если ключ уже записан на новом узле, его значение новее
+func (c *Cluster) moveKey(ctx context.Context, key, target string) error {
+	c.moveMu.Lock()
+	defer c.moveMu.Unlock()
+
+	entry, err := c.storage.DumpKey(key)
+	if err == structs.ErrKeyNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	node, err := c.node(target)
+	if err != nil {
+		return err
+	}
+	if err := c.call(ctx, node, "cluster", "restore", key, string(data)); err != nil && err.Error() != structs.ErrBusyKey.Error() {
+		return err
+	}
+	c.storage.RemoveElement(key)
+	return nil
+}
+
+// restore загрузка перенесённого ключа
+func (c *Cluster) restore(key string, data []byte) error {
+	slot := KeySlot(key)
+	c.mu.RLock()
+	accept := c.slots[slot] == c.self || c.importing[slot] != ""
+	c.mu.RUnlock()
+	if !accept {
+		return structs.ErrClusterDown
+	}
+
+	var entry structs.Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	if _, err := c.storage.GetType(key); err == nil {
+		return structs.ErrBusyKey
+	}
+	entry.Key = key
+	c.storage.Load([]structs.Entry{entry})
+	return nil
+}
+
+// peer соединение с другим узлом кластера
+type peer struct {
+	mu   sync.Mutex
+	conn net.Conn
+	w    *resp.RequestWriter
+	r    resp.ResponseReader
+}
+
+// SetTLSConfig соединения с другими узлами по TLS. Вызывается до переноса слотов
+func (c *Cluster) SetTLSConfig(cfg *tls.Config) {
+	c.tlsConfig = cfg
+}
+
+// call выполнение команды на узле node, ожидается ответ OK
+func (c *Cluster) call(ctx context.Context, node Node, cmd string, args ...string) error {
+	c.peersMu.Lock()
+	p, ok := c.peers[node.Addr]
+	if !ok {
+		p = new(peer)
+		c.peers[node.Addr] = p
+	}
+	c.peersMu.Unlock()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(callTimeout)
+	}
+	if p.conn == nil {
+		var (
+			conn net.Conn
+			err  error
+		)
+		d := net.Dialer{Deadline: deadline}
+		if c.tlsConfig != nil {
+			conn, err = tls.DialWithDialer(&d, "tcp", node.Addr, c.tlsConfig)
+		} else {
+			conn, err = d.DialContext(ctx, "tcp", node.Addr)
+		}
+		if err != nil {
+			return err
+		}
+		p.conn, p.w, p.r = conn, resp.NewRequestWriter(conn), resp.NewResponseReader(conn)
+	}
+
+	err := p.roundTrip(deadline, cmd, args)
+	var reply replyError
+	if err != nil && !errors.As(err, &reply) {
+		// Состояние соединения неизвестно: следующий вызов установит новое
+		p.conn.Close()
+		p.conn = nil
+	}
+	return err
+}
+
+func (p *peer) roundTrip(deadline time.Time, cmd string, args []string) error {
+	if err := p.conn.SetDeadline(deadline); err != nil {
+		return err
+	}
+	p.w.WriteCmdString(cmd, args...)
+	if err := p.w.Flush(); err != nil {
+		return err
+	}
+
+	t, err := p.r.PeekType()
+	if err != nil {
+		return err
+	}
+	switch t {
+	case resp.TypeInline:
+		_, err = p.r.ReadInlineString()
+		return err
+	case resp.TypeError:
+		msg, err := p.r.ReadError()
+		if err != nil {
+			return err
+		}
+		return replyError(msg)
+	default:
+		return fmt.Errorf("unexpected response type %s", t)
+	}
+}
+
+// replyError ошибка, которой ответил узел
+type replyError string
+
+func (e replyError) Error() string {
+	return string(e)
+}
@@ -0,0 +1,77 @@
+package cluster
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SlotCount количество слотов, на которые делится пространство ключей
+const SlotCount = 16384
+
+// KeySlot слот ключа: CRC16 ключа по модулю SlotCount. Если ключ содержит непустую подстроку в фигурных
+// скобках ({user1}.followers), хешируется только она: так связанные ключи попадают в один слот
+func KeySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key)) % SlotCount
+}
+
+// crc16 CRC-16/XMODEM
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// SlotRange диапазон слотов [Start, End]
+type SlotRange struct {
+	Start int
+	End   int
+}
+
+func (r SlotRange) String() string {
+	if r.Start == r.End {
+		return strconv.Itoa(r.Start)
+	}
+	return fmt.Sprintf("%d-%d", r.Start, r.End)
+}
+
+// ParseSlotRange разбор слота (100) либо диапазона слотов (100-200)
+func ParseSlotRange(s string) (SlotRange, error) {
+	parts := strings.SplitN(s, "-", 2)
+	start, err := parseSlot(parts[0])
+	if err != nil {
+		return SlotRange{}, err
+	}
+	end := start
+	if len(parts) == 2 {
+		if end, err = parseSlot(parts[1]); err != nil {
+			return SlotRange{}, err
+		}
+	}
+	if end < start {
+		return SlotRange{}, fmt.Errorf("invalid slot range %q", s)
+	}
+	return SlotRange{Start: start, End: end}, nil
+}
+
+func parseSlot(s string) (int, error) {
+	slot, err := strconv.Atoi(s)
+	if err != nil || slot < 0 || slot >= SlotCount {
+		return 0, fmt.Errorf("invalid slot %q", s)
+	}
+	return slot, nil
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geraev/gokvserver/client"
+)
+
+var (
+	errQuit    = errors.New("quit")
+	errUnknown = errors.New("unknown command")
+)
+
+// keysResult список ключей. Выводится иначе, чем значение-список
+type keysResult []string
+
+// command команда клиента
+type command struct {
+	name    string
+	usage   string
+	summary string
+	// minArgs, maxArgs допустимое число аргументов. maxArgs -1 - без ограничения
+	minArgs int
+	maxArgs int
+	// keyArg первый аргумент - ключ, он дополняется по Tab
+	keyArg bool
+	run    func(ctx context.Context, c *client.Client, args []string) (interface{}, error)
+}
+
+var commands = []*command{
+	{
+		name:    "keys",
+		usage:   "keys [pattern]",
+		summary: "list keys, optionally matching a glob pattern",
+		maxArgs: 1,
+		run:     keys,
+	},
+	{
+		name:    "get",
+		usage:   "get <key> [index|field]",
+		summary: "get a value, or an element of a list or a dictionary",
+		minArgs: 1,
+		maxArgs: 2,
+		keyArg:  true,
+		run:     get,
+	},
+	{
+		name:    "set",
+		usage:   "set <key> <value>",
+		summary: "set a string value",
+		minArgs: 2,
+		maxArgs: 2,
+		keyArg:  true,
+		run: func(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+			return nil, c.Set(ctx, args[0], args[1])
+		},
+	},
+	{
+		name:    "setlist",
+		usage:   "setlist <key> <item>...",
+		summary: "set a list value",
+		minArgs: 2,
+		maxArgs: -1,
+		keyArg:  true,
+		run: func(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+			return nil, c.Set(ctx, args[0], args[1:])
+		},
+	},
+	{
+		name:    "setdict",
+		usage:   "setdict <key> <field>=<value>...",
+		summary: "set a dictionary value",
+		minArgs: 2,
+		maxArgs: -1,
+		keyArg:  true,
+		run:     setDictionary,
+	},
+	{
+		name:    "expire",
+		usage:   "expire <key> <ttl>",
+		summary: "set a key time to live: a duration (10s, 1m30s) or milliseconds",
+		minArgs: 2,
+		maxArgs: 2,
+		keyArg:  true,
+		run:     expire,
+	},
+	{
+		name:    "del",
+		usage:   "del <key>...",
+		summary: "delete keys",
+		minArgs: 1,
+		maxArgs: -1,
+		keyArg:  true,
+		run: func(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+			for _, key := range args {
+				if err := c.Delete(ctx, key); err != nil {
+					return nil, err
+				}
+			}
+			return nil, nil
+		},
+	},
+	{
+		name:    "help",
+		usage:   "help [command]",
+		summary: "show commands",
+		maxArgs: 1,
+	},
+	{
+		name:    "format",
+		usage:   "format <table|json|raw>",
+		summary: "change the output format",
+		minArgs: 1,
+		maxArgs: 1,
+	},
+	{
+		name:    "quit",
+		usage:   "quit",
+		summary: "exit the client (also exit or Ctrl-D)",
+	},
+}
+
+// lookup поиск команды по имени без учёта регистра
+func lookup(name string) *command {
+	name = strings.ToLower(name)
+	if name == "exit" {
+		name = "quit"
+	}
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func keys(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	all, err := c.Keys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := keysResult{}
+	for _, key := range all {
+		if len(args) > 0 {
+			if ok, err := path.Match(args[0], key); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
+			}
+		}
+		result = append(result, key)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+func get(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	if len(args) == 2 {
+		return c.GetField(ctx, args[0], args[1])
+	}
+	return c.Get(ctx, args[0])
+}
+
+func setDictionary(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	dict := make(map[string]string, len(args)-1)
+	for _, arg := range args[1:] {
+		i := strings.IndexByte(arg, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("invalid dictionary element %q: expected field=value", arg)
+		}
+		dict[arg[:i]] = arg[i+1:]
+	}
+	return nil, c.Set(ctx, args[0], dict)
+}
+
+func expire(ctx context.Context, c *client.Client, args []string) (interface{}, error) {
+	ttl, err := parseTTL(args[1])
+	if err != nil {
+		return nil, err
+	}
+	return nil, c.Expire(ctx, args[0], ttl)
+}
+
+// parseTTL время жизни: продолжительность Go либо целое число миллисекунд
+func parseTTL(s string) (time.Duration, error) {
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.Duration(ms) * time.Millisecond, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ttl %q", s)
+	}
+	return ttl, nil
+}
+
+// splitArgs разбор строки на аргументы. Аргументы с пробелами заключаются в кавычки:
+// в двойных кавычках действуют escape-последовательности \" \\ \n \t, одинарные кавычки - без них
+func splitArgs(line string) ([]string, error) {
+	var (
+		args  []string
+		arg   strings.Builder
+		inArg bool
+		quote rune
+		esc   bool
+	)
+	for _, r := range line {
+		switch {
+		case esc:
+			switch r {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			}
+			arg.WriteRune(r)
+			esc = false
+		case quote == '"' && r == '\\':
+			esc = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quote != 0 || esc {
+		return nil, errors.New("unterminated quoted string")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
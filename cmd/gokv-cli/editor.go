@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// errInterrupted ввод строки прерван Ctrl-C
+var errInterrupted = errors.New("interrupted")
+
+// Управляющие клавиши
+const (
+	keyCtrlA     = 1
+	keyCtrlC     = 3
+	keyCtrlD     = 4
+	keyCtrlE     = 5
+	keyCtrlH     = 8
+	keyTab       = 9
+	keyLF        = 10
+	keyCtrlK     = 11
+	keyCtrlL     = 12
+	keyCR        = 13
+	keyCtrlN     = 14
+	keyCtrlP     = 16
+	keyCtrlU     = 21
+	keyCtrlW     = 23
+	keyEscape    = 27
+	keyBackspace = 127
+)
+
+// editor редактор строки для терминала в неканоническом режиме: перемещение курсора,
+// история команд и дополнение по Tab
+type editor struct {
+	in  *bufio.Reader
+	out io.Writer
+
+	// history введённые строки, последняя - в конце
+	history    []string
+	maxHistory int
+	// complete варианты дополнения последнего слова строки
+	complete func(line string) []string
+}
+
+func newEditor(in io.Reader, out io.Writer) *editor {
+	return &editor{
+		in:         bufio.NewReader(in),
+		out:        out,
+		maxHistory: 1000,
+	}
+}
+
+// addHistory добавление строки в историю. Повтор предыдущей строки не сохраняется
+func (e *editor) addHistory(line string) bool {
+	if line == "" || (len(e.history) > 0 && e.history[len(e.history)-1] == line) {
+		return false
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > e.maxHistory {
+		e.history = e.history[len(e.history)-e.maxHistory:]
+	}
+	return true
+}
+
+// lineState редактируемая строка
+type lineState struct {
+	prompt string
+	buf    []rune
+	pos    int
+	// histPos позиция в истории, len(history) - новая строка
+	histPos int
+	// saved новая строка, сохранённая при переходе к истории
+	saved []rune
+	// tabs количество нажатий Tab подряд
+	tabs int
+}
+
+// readLine чтение строки. Ctrl-D на пустой строке возвращает io.EOF, Ctrl-C - errInterrupted
+func (e *editor) readLine(prompt string) (string, error) {
+	s := &lineState{prompt: prompt, histPos: len(e.history)}
+	e.refresh(s)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			if err == io.EOF && len(s.buf) > 0 {
+				break
+			}
+			return "", err
+		}
+		if r != keyTab {
+			s.tabs = 0
+		}
+
+		switch r {
+		case keyCR, keyLF:
+			fmt.Fprint(e.out, "\r\n")
+			return string(s.buf), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", errInterrupted
+		case keyCtrlD:
+			if len(s.buf) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+			e.delete(s)
+		case keyBackspace, keyCtrlH:
+			if s.pos > 0 {
+				s.pos--
+				e.delete(s)
+			}
+		case keyTab:
+			s.tabs++
+			e.completeLine(s)
+		case keyCtrlA:
+			s.pos = 0
+		case keyCtrlE:
+			s.pos = len(s.buf)
+		case keyCtrlK:
+			s.buf = s.buf[:s.pos]
+		case keyCtrlU:
+			s.buf = append(s.buf[:0], s.buf[s.pos:]...)
+			s.pos = 0
+		case keyCtrlW:
+			start := s.pos
+			for start > 0 && s.buf[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && s.buf[start-1] != ' ' {
+				start--
+			}
+			s.buf = append(s.buf[:start], s.buf[s.pos:]...)
+			s.pos = start
+		case keyCtrlL:
+			fmt.Fprint(e.out, "\x1b[H\x1b[2J")
+		case keyCtrlP:
+			e.historyMove(s, -1)
+		case keyCtrlN:
+			e.historyMove(s, 1)
+		case keyEscape:
+			e.escape(s)
+		default:
+			if r >= ' ' {
+				s.buf = append(s.buf, 0)
+				copy(s.buf[s.pos+1:], s.buf[s.pos:])
+				s.buf[s.pos] = r
+				s.pos++
+			}
+		}
+		e.refresh(s)
+	}
+	fmt.Fprint(e.out, "\r\n")
+	return string(s.buf), nil
+}
+
+// escape последовательности стрелок, Home, End и Delete: ESC [ A, ESC [ 3 ~, ESC O H
+func (e *editor) escape(s *lineState) {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return
+	}
+	r, _, err = e.in.ReadRune()
+	if err != nil {
+		return
+	}
+	if r >= '0' && r <= '9' {
+		// ESC [ n ~
+		if next, _, err := e.in.ReadRune(); err != nil || next != '~' {
+			return
+		}
+		switch r {
+		case '1', '7':
+			s.pos = 0
+		case '4', '8':
+			s.pos = len(s.buf)
+		case '3':
+			e.delete(s)
+		}
+		return
+	}
+	switch r {
+	case 'A':
+		e.historyMove(s, -1)
+	case 'B':
+		e.historyMove(s, 1)
+	case 'C':
+		if s.pos < len(s.buf) {
+			s.pos++
+		}
+	case 'D':
+		if s.pos > 0 {
+			s.pos--
+		}
+	case 'H':
+		s.pos = 0
+	case 'F':
+		s.pos = len(s.buf)
+	}
+}
+
+// delete удаление символа под курсором
+func (e *editor) delete(s *lineState) {
+	if s.pos < len(s.buf) {
+		s.buf = append(s.buf[:s.pos], s.buf[s.pos+1:]...)
+	}
+}
+
+// historyMove переход к предыдущей (-1) или следующей (1) строке истории
+func (e *editor) historyMove(s *lineState, delta int) {
+	next := s.histPos + delta
+	if next < 0 || next > len(e.history) {
+		return
+	}
+	if s.histPos == len(e.history) {
+		s.saved = append(s.saved[:0], s.buf...)
+	}
+	s.histPos = next
+	if next == len(e.history) {
+		s.buf = append([]rune(nil), s.saved...)
+	} else {
+		s.buf = []rune(e.history[next])
+	}
+	s.pos = len(s.buf)
+}
+
+// completeLine дополнение слова перед курсором. Единственный вариант подставляется целиком,
+// при нескольких подставляется общее начало, повторный Tab выводит список вариантов
+func (e *editor) completeLine(s *lineState) {
+	if e.complete == nil {
+		return
+	}
+	head := string(s.buf[:s.pos])
+	start := strings.LastIndexAny(head, " \t") + 1
+	word := head[start:]
+	candidates := e.complete(head)
+	if len(candidates) == 0 {
+		return
+	}
+
+	replace := func(with string) {
+		tail := s.buf[s.pos:]
+		line := []rune(head[:start] + with)
+		s.pos = len(line)
+		s.buf = append(line, tail...)
+	}
+	if len(candidates) == 1 {
+		replace(candidates[0] + " ")
+		return
+	}
+
+	prefix := commonPrefix(candidates)
+	if len(prefix) > len(word) {
+		replace(prefix)
+		return
+	}
+	if s.tabs > 1 {
+		sort.Strings(candidates)
+		fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	}
+}
+
+// refresh перерисовка строки и установка курсора
+func (e *editor) refresh(s *lineState) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", s.prompt, string(s.buf))
+	if back := len(s.buf) - s.pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+func commonPrefix(words []string) string {
+	prefix := words[0]
+	for _, w := range words[1:] {
+		for !strings.HasPrefix(w, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return prefix
+}
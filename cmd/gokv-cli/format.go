@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Форматы вывода
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatRaw   = "raw"
+)
+
+var formats = []string{formatTable, formatJSON, formatRaw}
+
+// printResult вывод результата команды. nil - успешное выполнение без значения
+func printResult(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case formatJSON:
+		if v == nil {
+			v = "OK"
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", data)
+		return err
+	case formatRaw:
+		return printRaw(w, v)
+	default:
+		return printTable(w, v)
+	}
+}
+
+// printRaw значения без оформления: элементы по одному в строке, элементы словаря через табуляцию
+func printRaw(w io.Writer, v interface{}) error {
+	var lines []string
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case string:
+		lines = []string{v}
+	case keysResult:
+		lines = v
+	case []string:
+		lines = v
+	case map[string]string:
+		for _, field := range sortedFields(v) {
+			lines = append(lines, field+"\t"+v[field])
+		}
+	default:
+		lines = []string{fmt.Sprint(v)}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printTable значения в виде таблицы с заголовком
+func printTable(w io.Writer, v interface{}) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	switch v := v.(type) {
+	case nil:
+		fmt.Fprintln(tw, "OK")
+	case string:
+		fmt.Fprintf(tw, "%q\n", v)
+	case keysResult:
+		if len(v) == 0 {
+			fmt.Fprintln(tw, "(empty)")
+			break
+		}
+		fmt.Fprintln(tw, "#\tKEY")
+		for i, key := range v {
+			fmt.Fprintf(tw, "%d\t%s\n", i+1, key)
+		}
+	case []string:
+		if len(v) == 0 {
+			fmt.Fprintln(tw, "(empty list)")
+			break
+		}
+		fmt.Fprintln(tw, "INDEX\tVALUE")
+		for i, item := range v {
+			fmt.Fprintf(tw, "%d\t%q\n", i, item)
+		}
+	case map[string]string:
+		if len(v) == 0 {
+			fmt.Fprintln(tw, "(empty dictionary)")
+			break
+		}
+		fmt.Fprintln(tw, "FIELD\tVALUE")
+		for _, field := range sortedFields(v) {
+			fmt.Fprintf(tw, "%s\t%q\n", field, v[field])
+		}
+	default:
+		fmt.Fprintln(tw, v)
+	}
+	return tw.Flush()
+}
+
+// printHelp список команд либо описание одной команды
+func printHelp(w io.Writer, name string) error {
+	if name != "" {
+		cmd := lookup(name)
+		if cmd == nil {
+			return fmt.Errorf("%w %q", errUnknown, name)
+		}
+		_, err := fmt.Fprintf(w, "%s\n  %s\n", cmd.usage, cmd.summary)
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range commands {
+		fmt.Fprintf(tw, "%s\t%s\n", cmd.usage, cmd.summary)
+	}
+	return tw.Flush()
+}
+
+func sortedFields(dict map[string]string) []string {
+	fields := make([]string, 0, len(dict))
+	for field := range dict {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func validFormat(format string) bool {
+	for _, f := range formats {
+		if f == format {
+			return true
+		}
+	}
+	return false
+}
+
+// quote аргумент в виде, пригодном для повторного ввода
+func quote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"'\\\n") {
+		return arg
+	}
+	return fmt.Sprintf("%q", arg)
+}
@@ -0,0 +1,368 @@
+// Command gokv-cli интерактивный клиент gokvserver для HTTP и TCP протоколов.
+//
+// Без аргументов запускается командная строка с историей и дополнением по Tab,
+// иначе выполняется одна команда:
+//
+//	gokv-cli -proto http -user admin -password secret get mykey
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/geraev/gokvserver/client"
+)
+
+var flags struct {
+	proto    string
+	addr     string
+	user     string
+	password string
+	format   string
+	timeout  time.Duration
+	history  string
+
+	tls    bool
+	caCert string
+	cert   string
+	key    string
+}
+
+func init() {
+	flag.StringVar(&flags.proto, "proto", "tcp", "The server protocol: tcp or http")
+	flag.StringVar(&flags.addr, "addr", "", "The server address (default localhost:9736 for tcp, localhost:8081 for http)")
+	flag.StringVar(&flags.user, "user", "", "The user name (HTTP basic auth or TCP AUTH)")
+	flag.StringVar(&flags.password, "password", "", "The user password")
+	flag.StringVar(&flags.format, "format", formatTable, "The output format: table, json or raw")
+	flag.DurationVar(&flags.timeout, "timeout", client.DefaultTimeout, "The request timeout")
+	flag.BoolVar(&flags.tls, "tls", false, "Connect over TLS, implied by -cacert and -cert")
+	flag.StringVar(&flags.caCert, "cacert", "", "The CA certificates file to verify the server with (default system roots)")
+	flag.StringVar(&flags.cert, "cert", "", "The client certificate file, logs in as the user named by its common name")
+	flag.StringVar(&flags.key, "key", "", "The client certificate key file")
+	flag.StringVar(&flags.history, "history", defaultHistoryPath(), "The command history file, empty to disable")
+
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [command [args...]]\n\nFlags:\n", os.Args[0])
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nCommands:\n")
+		printHelp(flag.CommandLine.Output(), "")
+	}
+}
+
+func main() {
+	flag.Parse()
+	if !validFormat(flags.format) {
+		fmt.Fprintf(os.Stderr, "unknown output format %q\n", flags.format)
+		os.Exit(2)
+	}
+
+	opts := client.Options{Username: flags.user, Password: flags.password, Timeout: flags.timeout}
+	var err error
+	if opts.TLSConfig, err = tlsConfig(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	var c *cli
+	switch flags.proto {
+	case "tcp":
+		c = newCLI(client.NewTCPClient(address("localhost:9736"), opts))
+	case "http":
+		c = newCLI(client.NewHTTPClient(address("localhost:8081"), opts))
+	default:
+		fmt.Fprintf(os.Stderr, "unknown protocol %q\n", flags.proto)
+		os.Exit(2)
+	}
+	defer c.client.Close()
+
+	if flag.NArg() > 0 {
+		if err := c.run(flag.Args()); err != nil && err != errQuit {
+			c.printError(err)
+			os.Exit(1)
+		}
+		return
+	}
+	if isTerminal(int(os.Stdin.Fd())) {
+		c.repl(os.Stdin, fmt.Sprintf("%s://%s> ", flags.proto, address("")), flags.history)
+		return
+	}
+	if !c.script(os.Stdin) {
+		os.Exit(1)
+	}
+}
+
+func address(def string) string {
+	if flags.addr != "" {
+		return flags.addr
+	}
+	return def
+}
+
+// tlsConfig настройки TLS по флагам, nil - без шифрования
+func tlsConfig() (*tls.Config, error) {
+	if !flags.tls && flags.caCert == "" && flags.cert == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{}
+	if flags.caCert != "" {
+		data, err := ioutil.ReadFile(flags.caCert)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", flags.caCert)
+		}
+	}
+	if flags.cert != "" {
+		cert, err := tls.LoadX509KeyPair(flags.cert, flags.key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".gokv_cli_history")
+}
+
+// cli выполнение команд и вывод результатов
+type cli struct {
+	client *client.Client
+	format string
+	out    io.Writer
+	errOut io.Writer
+}
+
+func newCLI(c *client.Client) *cli {
+	return &cli{
+		client: c,
+		format: flags.format,
+		out:    os.Stdout,
+		errOut: os.Stderr,
+	}
+}
+
+// run выполнение команды. Ctrl-C отменяет запрос к серверу
+func (c *cli) run(args []string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return c.exec(ctx, args)
+}
+
+// exec выполнение команды args[0] с аргументами args[1:]
+func (c *cli) exec(ctx context.Context, args []string) error {
+	cmd := lookup(args[0])
+	if cmd == nil {
+		return fmt.Errorf("%w %q, try help", errUnknown, args[0])
+	}
+	argc := len(args) - 1
+	if argc < cmd.minArgs || (cmd.maxArgs >= 0 && argc > cmd.maxArgs) {
+		return fmt.Errorf("wrong number of arguments, usage: %s", cmd.usage)
+	}
+
+	switch cmd.name {
+	case "help":
+		return printHelp(c.out, strings.Join(args[1:], ""))
+	case "format":
+		if !validFormat(args[1]) {
+			return fmt.Errorf("unknown output format %q", args[1])
+		}
+		c.format = args[1]
+		return nil
+	case "quit":
+		return errQuit
+	}
+
+	v, err := cmd.run(ctx, c.client, args[1:])
+	if err != nil {
+		return err
+	}
+	return printResult(c.out, c.format, v)
+}
+
+func (c *cli) printError(err error) {
+	fmt.Fprintf(c.errOut, "(error) %v\n", err)
+}
+
+// execLine разбор и выполнение строки. ok - команда выполнена без ошибки, quit - команда выхода
+func (c *cli) execLine(line string) (ok bool, quit bool) {
+	args, err := splitArgs(line)
+	if err != nil {
+		c.printError(err)
+		return false, false
+	}
+	if len(args) == 0 {
+		return true, false
+	}
+	switch err := c.run(args); {
+	case err == errQuit:
+		return true, true
+	case err != nil:
+		c.printError(err)
+		return false, false
+	}
+	return true, false
+}
+
+// script выполнение команд из не-терминального ввода, по одной в строке. Пустые строки
+// и строки, начинающиеся с #, пропускаются. Возвращает false, если хотя бы одна команда завершилась ошибкой
+func (c *cli) script(in io.Reader) bool {
+	success := true
+	scanner := bufio.NewScanner(in)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		ok, quit := c.execLine(line)
+		success = success && ok
+		if quit {
+			break
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		c.printError(err)
+		return false
+	}
+	return success
+}
+
+// repl интерактивный режим
+func (c *cli) repl(in *os.File, prompt, historyPath string) {
+	ed := newEditor(in, c.out)
+	ed.complete = c.complete
+	history := loadHistory(ed, historyPath)
+	if history != nil {
+		defer history.Close()
+	}
+
+	fd := int(in.Fd())
+	for {
+		restore, err := makeRaw(fd)
+		if err != nil {
+			c.printError(err)
+			return
+		}
+		line, err := ed.readLine(prompt)
+		restore()
+
+		switch {
+		case errors.Is(err, errInterrupted):
+			continue
+		case err != nil:
+			if err != io.EOF {
+				c.printError(err)
+			}
+			return
+		}
+
+		line = strings.TrimSpace(line)
+		if ed.addHistory(line) && history != nil {
+			fmt.Fprintln(history, line)
+		}
+		if _, quit := c.execLine(line); quit {
+			return
+		}
+	}
+}
+
+// loadHistory чтение истории команд. Возвращает файл для дозаписи новых команд
+func loadHistory(ed *editor, path string) *os.File {
+	if path == "" {
+		return nil
+	}
+	if data, err := ioutil.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			ed.addHistory(line)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil
+	}
+	return f
+}
+
+// complete варианты дополнения последнего слова: имена команд, форматы вывода и ключи
+func (c *cli) complete(head string) []string {
+	args, err := splitArgs(head)
+	if err != nil {
+		return nil
+	}
+	word := ""
+	if !strings.HasSuffix(head, " ") && len(args) > 0 {
+		word, args = args[len(args)-1], args[:len(args)-1]
+	}
+
+	var candidates []string
+	if len(args) == 0 {
+		for _, cmd := range commands {
+			candidates = append(candidates, cmd.name)
+		}
+		return withPrefix(candidates, word)
+	}
+
+	cmd := lookup(args[0])
+	switch {
+	case cmd == nil:
+		return nil
+	case cmd.name == "help" && len(args) == 1:
+		for _, cmd := range commands {
+			candidates = append(candidates, cmd.name)
+		}
+	case cmd.name == "format" && len(args) == 1:
+		candidates = formats
+	case cmd.keyArg && (len(args) == 1 || cmd.name == "del"):
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		keys, err := c.client.Keys(ctx)
+		if err != nil {
+			return nil
+		}
+		for _, key := range withPrefix(keys, word) {
+			candidates = append(candidates, quote(key))
+		}
+		return candidates
+	}
+	return withPrefix(candidates, word)
+}
+
+func withPrefix(words []string, prefix string) []string {
+	var result []string
+	for _, w := range words {
+		if strings.HasPrefix(w, prefix) {
+			result = append(result, w)
+		}
+	}
+	return result
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/client"
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		line    string
+		want    []string
+		wantErr bool
+	}{
+		{line: "", want: nil},
+		{line: "  get   mykey ", want: []string{"get", "mykey"}},
+		{line: `set key "hello world"`, want: []string{"set", "key", "hello world"}},
+		{line: `set key 'a "b" \n'`, want: []string{"set", "key", `a "b" \n`}},
+		{line: `set key "a\"b\\c\n"`, want: []string{"set", "key", "a\"b\\c\n"}},
+		{line: `set key ""`, want: []string{"set", "key", ""}},
+		{line: `set k"ey" v`, want: []string{"set", "key", "v"}},
+		{line: `set key "open`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing splitArgs("+tt.line+")", func(t *testing.T) {
+			got, err := splitArgs(tt.line)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrintResult(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		value  interface{}
+		want   string
+	}{
+		{"table OK", formatTable, nil, "OK\n"},
+		{"table string", formatTable, "hello", "\"hello\"\n"},
+		{"table keys", formatTable, keysResult{"a", "bb"}, "#  KEY\n1  a\n2  bb\n"},
+		{"table empty keys", formatTable, keysResult{}, "(empty)\n"},
+		{"table list", formatTable, []string{"x", "y z"}, "INDEX  VALUE\n0      \"x\"\n1      \"y z\"\n"},
+		{"table dictionary", formatTable, map[string]string{"b": "2", "a": "1"}, "FIELD  VALUE\na      \"1\"\nb      \"2\"\n"},
+		{"json OK", formatJSON, nil, "\"OK\"\n"},
+		{"json list", formatJSON, []string{"x"}, "[\"x\"]\n"},
+		{"json dictionary", formatJSON, map[string]string{"a": "1"}, "{\"a\":\"1\"}\n"},
+		{"raw OK", formatRaw, nil, ""},
+		{"raw string", formatRaw, "hello", "hello\n"},
+		{"raw list", formatRaw, []string{"x", "y"}, "x\ny\n"},
+		{"raw dictionary", formatRaw, map[string]string{"b": "2", "a": "1"}, "a\t1\nb\t2\n"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := printResult(&buf, tt.format, tt.value); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEditor_ReadLine(t *testing.T) {
+	complete := func(head string) []string {
+		return withPrefix([]string{"get", "getall", "keys"}, head[strings.LastIndex(head, " ")+1:])
+	}
+	tests := []struct {
+		name    string
+		history []string
+		input   string
+		want    string
+		wantErr error
+	}{
+		{name: "plain", input: "get key\r", want: "get key"},
+		{name: "backspace", input: "get kez\x7fy\r", want: "get key"},
+		{name: "cursor movement", input: "et key\x01g\x05!\r", want: "get key!"},
+		{name: "arrows and delete", input: "get kXey\x1b[D\x1b[D\x1b[D\x1b[3~\r", want: "get key"},
+		{name: "kill line", input: "garbage\x15get\r", want: "get"},
+		{name: "delete word", input: "get one two\x17key\r", want: "get one key"},
+		{name: "history up", history: []string{"keys", "get a"}, input: "\x1b[A\x1b[A\r", want: "keys"},
+		{name: "history down restores input", history: []string{"keys"}, input: "ge\x1b[A\x1b[Bt\r", want: "get"},
+		{name: "complete unique", input: "ke\tx\r", want: "keys x"},
+		{name: "complete common prefix", input: "g\t\r", want: "get"},
+		{name: "ctrl-c", input: "get\x03", wantErr: errInterrupted},
+		{name: "ctrl-d on empty line", input: "\x04", wantErr: io.EOF},
+		{name: "ctrl-d deletes", input: "gxet\x01\x1b[C\x04\r", want: "get"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			ed := newEditor(strings.NewReader(tt.input), ioutil.Discard)
+			ed.complete = complete
+			for _, line := range tt.history {
+				ed.addHistory(line)
+			}
+			got, err := ed.readLine("> ")
+			if err != tt.wantErr {
+				t.Fatalf("error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// newCLIs клиенты обоих протоколов, у каждого собственный сервер и хранилище
+func newCLIs(t *testing.T) map[string]*cli {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	go tcpserver.NewServer("", mapbased.NewStorage()).Serve(lis)
+
+	accounts := map[string]string{"user": "pass"}
+	ts := httptest.NewServer(httpserver.NewServer("", accounts, mapbased.NewStorage()).Handler())
+	t.Cleanup(ts.Close)
+
+	clients := map[string]*client.Client{
+		"http": client.NewHTTPClient(ts.URL, client.Options{Username: "user", Password: "pass"}),
+		"tcp":  client.NewTCPClient(lis.Addr().String(), client.Options{}),
+	}
+	clis := make(map[string]*cli)
+	for name, c := range clients {
+		c := c
+		t.Cleanup(func() { c.Close() })
+		clis[name] = &cli{client: c, format: formatRaw}
+	}
+	return clis
+}
+
+func TestCLI_Script(t *testing.T) {
+	script := `
+# строки с # пропускаются
+set greeting "hello world"
+setlist planets earth mars
+setdict user name=john "city=new york"
+expire greeting 10s
+keys
+get greeting
+get planets 1
+get user city
+format json
+get planets
+del planets
+get planets
+unknown
+quit
+get greeting
+`
+	want := strings.Join([]string{
+		"greeting",
+		"planets",
+		"user",
+		"hello world",
+		"mars",
+		"new york",
+		`["earth","mars"]`,
+		`"OK"`,
+		"",
+	}, "\n")
+	wantErr := "(error) key not found\n(error) unknown command \"unknown\", try help\n"
+
+	for name, c := range newCLIs(t) {
+		t.Run(name, func(t *testing.T) {
+			var out, errOut bytes.Buffer
+			c.out, c.errOut = &out, &errOut
+			if c.script(strings.NewReader(script)) {
+				t.Error("script() = true, want false after a failed command")
+			}
+			if out.String() != want {
+				t.Errorf("output = %q, want %q", out.String(), want)
+			}
+			if errOut.String() != wantErr {
+				t.Errorf("errors = %q, want %q", errOut.String(), wantErr)
+			}
+		})
+	}
+}
+
+func TestCLI_Complete(t *testing.T) {
+	c := newCLIs(t)["tcp"]
+	c.out = ioutil.Discard
+	if !c.script(strings.NewReader("set alpha 1\nset beta 2\nset \"al pha\" 3\n")) {
+		t.Fatal("script() failed")
+	}
+
+	tests := []struct {
+		head string
+		want []string
+	}{
+		{head: "", want: []string{"keys", "get", "set", "setlist", "setdict", "expire", "del", "help", "format", "quit"}},
+		{head: "se", want: []string{"set", "setlist", "setdict"}},
+		{head: "get a", want: []string{`"al pha"`, "alpha"}},
+		{head: "get alpha ", want: nil},
+		{head: "del alpha b", want: []string{"beta"}},
+		{head: "help ex", want: []string{"expire"}},
+		{head: "format j", want: []string{"json"}},
+		{head: "nope ", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run("Testing complete("+tt.head+")", func(t *testing.T) {
+			got := c.complete(tt.head)
+			sort.Strings(got)
+			sort.Strings(tt.want)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
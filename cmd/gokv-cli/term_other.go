@@ -0,0 +1,16 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// isTerminal редактирование строки поддерживается только в Linux,
+// на остальных системах команды читаются построчно
+func isTerminal(fd int) bool {
+	return false
+}
+
+func makeRaw(fd int) (func() error, error) {
+	return nil, errors.New("raw terminal mode is not supported")
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/btree"
+	"github.com/geraev/gokvserver/config"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/lsm"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/middleware"
+	"github.com/geraev/gokvserver/replication"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tracing"
+)
+
+var (
+	// maxKeys ограничение количества ключей, 0 - без ограничения
+	maxKeys int64
+
+	logMu   sync.Mutex
+	logFile *os.File
+	logPath string
+)
+
+// overrideFlags значения флагов, заданных явно, имеют приоритет над файлом и переменными окружения
+func overrideFlags(cfg *config.Config) {
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "tcp-port":
+			cfg.Listeners.TCP = flags.tcpAddr
+		case "http-port":
+			cfg.Listeners.HTTP = flags.httpAddr
+		case "pprof-addr":
+			cfg.Listeners.Pprof = flags.pprofAddr
+		case "shutdown-timeout":
+			cfg.Listeners.ShutdownTimeout = flags.shutdownTimeout
+		case "snapshot":
+			cfg.Persistence.Snapshot = flags.snapshot
+		}
+	})
+}
+
+// applyConfig применение параметров, изменяемых без перезапуска
+func applyConfig(cfg *config.Config) error {
+	if err := setLogFile(cfg.Log.File); err != nil {
+		return err
+	}
+	// Уровень проверен config.Validate
+	level, _ := logging.ParseLevel(cfg.Log.Level)
+	logger.SetLevel(level)
+	logger.SetFormat(cfg.Log.Format)
+	if cfg.Auth.UsersFile == "" {
+		accounts, err := auth.AccountUsers(cfg.Auth.Accounts)
+		if err != nil {
+			return err
+		}
+		if err := users.Replace(accounts); err != nil {
+			return err
+		}
+	}
+	storage.SetCleanupInterval(cfg.Expiry.Interval)
+	atomic.StoreInt64(&maxKeys, int64(cfg.Storage.MaxKeys))
+	if m, ok := storage.(*mapbased.Storage); ok {
+		m.SetCompression(cfg.Storage.CompressThreshold)
+	}
+	slow.SetThreshold(cfg.Slowlog.Threshold)
+	slow.SetMaxLen(cfg.Slowlog.MaxLen)
+
+	if tcp != nil {
+		tcp.SetMaxValueSize(cfg.Storage.MaxValueSize)
+		tcp.SetRequestTimeout(cfg.Listeners.RequestTimeout)
+	}
+	if web != nil {
+		web.SetMaxValueSize(cfg.Storage.MaxValueSize)
+		web.SetRequestTimeout(cfg.Listeners.RequestTimeout)
+		web.SetRequestLogging(cfg.Log.Level == config.LevelDebug || cfg.Log.Level == config.LevelInfo)
+	}
+	return nil
+}
+
+// reload повторное чтение настроек, файла пользователей, ключа токенов и сертификатов TLS по SIGHUP
+func reload() {
+	if err := settings.Reload(); err != nil {
+		log.Printf("config: reload failed: %v", err)
+	} else {
+		log.Println("config: reloaded")
+	}
+	if settings.Config().Auth.UsersFile != "" {
+		if err := users.Reload(); err != nil {
+			log.Printf("users: reload failed: %v", err)
+		} else {
+			log.Println("users: reloaded")
+		}
+	}
+	if tokens != nil {
+		if err := tokens.Reload(); err != nil {
+			log.Printf("tokens: key reload failed: %v", err)
+		}
+	}
+	if certs != nil {
+		if err := certs.Reload(); err != nil {
+			log.Printf("tls: certificate reload failed: %v", err)
+		} else {
+			log.Println("tls: certificates reloaded")
+		}
+	}
+}
+
+// serverTLSConfig настройки TLS серверов. Параметры проверены config.Validate
+func serverTLSConfig(cfg config.TLS) *tls.Config {
+	clientAuth, _ := auth.ClientAuthType(cfg.ClientAuth)
+	return certs.ServerConfig(auth.TLSVersions[cfg.MinVersion], clientAuth)
+}
+
+// peerTLSConfig настройки TLS соединений реплики, узла кластера и HTTP прокси с другими узлами
+func peerTLSConfig(cfg config.TLS) *tls.Config {
+	return certs.ClientConfig(auth.TLSVersions[cfg.MinVersion])
+}
+
+// openAudits открытие журналов аудита серверов. Серверы с одинаковым файлом пишут в общий журнал
+func openAudits(cfg config.Audit) error {
+	opened := make(map[string]*logging.Audit)
+	open := func(path string) (*logging.Audit, error) {
+		if path == "" {
+			return nil, nil
+		}
+		if a, ok := opened[path]; ok {
+			return a, nil
+		}
+		f, err := logging.OpenRotatingFile(path, cfg.MaxSize, cfg.MaxBackups)
+		if err != nil {
+			return nil, fmt.Errorf("audit: %w", err)
+		}
+		audits = append(audits, f)
+		opened[path] = logging.NewAudit(f)
+		return opened[path], nil
+	}
+	var err error
+	if tcpAudit, err = open(cfg.TCPFile); err != nil {
+		return err
+	}
+	httpAudit, err = open(cfg.HTTPFile)
+	return err
+}
+
+// newTracer трассировщик с получателем спанов из настроек, nil - трассировка отключена
+func newTracer(cfg config.Tracing) (*tracing.Tracer, error) {
+	var exporter tracing.Exporter
+	switch cfg.Exporter {
+	case "":
+		return nil, nil
+	case config.ExporterFile:
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		exporter = tracing.NewFileExporter(f, cfg.ServiceName)
+	default:
+		exporter = tracing.NewOTLPExporter(cfg.Endpoint, cfg.ServiceName)
+	}
+	return tracing.New(exporter, cfg.SampleRatio), nil
+}
+
+// backend хранилище узла
+type backend interface {
+	replication.Storage
+	SetObserver(o structs.StorageObserver)
+	SetCleanupInterval(d time.Duration)
+	Len() int
+	Close() error
+}
+
+// newBackend хранилище, выбранное в настройках
+func newBackend(cfg config.Storage) (backend, error) {
+	switch cfg.Backend {
+	case config.BackendBTree:
+		return btree.NewStorage(), nil
+	case config.BackendLSM:
+		return lsm.Open(cfg.Dir, lsm.Options{CacheSize: cfg.CacheSize})
+	default:
+		s := mapbased.NewStorage()
+		// Сжатие задаётся до загрузки снимка, затем обновляется applyConfig
+		s.SetCompression(cfg.CompressThreshold)
+		if cfg.TierDir != "" {
+			if err := s.SetTier(cfg.TierDir, cfg.TierMaxMemory); err != nil {
+				s.Close()
+				return nil, err
+			}
+		}
+		return s, nil
+	}
+}
+
+// inspector сведения о представлении значений для команд серверов, nil - хранилище их не даёт.
+// Команды выполняются через обёртки хранилища
+func inspector() structs.InspectStorage {
+	i, ok := storage.(structs.InspectStorage)
+	if !ok {
+		return nil
+	}
+	return middleware.Inspector(cache, i)
+}
+
+// tiered дисковый уровень хранилища для команд серверов, nil - все значения в памяти.
+// Команды выполняются через обёртки хранилища
+func tiered() structs.TieredStorage {
+	t, ok := storage.(structs.TieredStorage)
+	if !ok {
+		return nil
+	}
+	if _, enabled := t.TierStats(); !enabled {
+		return nil
+	}
+	return middleware.Tiered(cache, t)
+}
+
+// storageMiddlewares обёртки хранилища из настроек, первая внешняя
+func storageMiddlewares(cfg config.Storage) []middleware.Middleware {
+	var middlewares []middleware.Middleware
+	for _, name := range config.Middlewares(cfg.Middleware) {
+		switch name {
+		case config.MiddlewareMetrics:
+			middlewares = append(middlewares, middleware.Metrics(stats))
+		case config.MiddlewareLogging:
+			middlewares = append(middlewares, middleware.Logging(logger))
+		case config.MiddlewareACL:
+			middlewares = append(middlewares, middleware.ACL(users))
+		case config.MiddlewareReadOnly:
+			middlewares = append(middlewares, middleware.ReadOnly())
+		case config.MiddlewarePrefix:
+			middlewares = append(middlewares, middleware.Prefix(cfg.KeyPrefix))
+		}
+	}
+	return middlewares
+}
+
+func hasMiddleware(cfg config.Storage, name string) bool {
+	for _, n := range config.Middlewares(cfg.Middleware) {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// loadUsers пользователи из файла или, если он не задан, из учётных записей настроек
+func loadUsers(cfg config.Auth) (*auth.Store, error) {
+	if cfg.UsersFile != "" {
+		return auth.Load(cfg.UsersFile)
+	}
+	return auth.FromAccounts(cfg.Accounts)
+}
+
+// printPasswordHash вывод хеша пароля, прочитанного из stdin, для файла пользователей
+func printPasswordHash(algorithm string) error {
+	password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && password == "" {
+		return err
+	}
+	hash, err := auth.HashPassword(strings.TrimRight(password, "\r\n"), algorithm)
+	if err != nil {
+		return err
+	}
+	fmt.Println(hash)
+	return nil
+}
+
+// setLogFile переключение журнала на файл path, пустой путь - stderr
+func setLogFile(path string) error {
+	logMu.Lock()
+	defer logMu.Unlock()
+	if path == logPath {
+		return nil
+	}
+
+	var f *os.File
+	if path != "" {
+		var err error
+		if f, err = os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return err
+		}
+		logger.SetOutput(f)
+	} else {
+		logger.SetOutput(os.Stderr)
+	}
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile, logPath = f, path
+	return nil
+}
+
+// keyLimit запрет создания новых ключей сверх storage.max_keys. Перезапись существующих ключей разрешена
+func keyLimit(_ context.Context, key string, write bool) error {
+	max := atomic.LoadInt64(&maxKeys)
+	if !write || key == "" || max <= 0 || int64(storage.Len()) < max {
+		return nil
+	}
+	if _, err := storage.GetType(key); err == nil {
+		return nil
+	}
+	return structs.ErrStorageFull
+}
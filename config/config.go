@@ -0,0 +1,550 @@
+// Package config настройки сервера. Значения по умолчанию переопределяются YAML файлом,
+// затем переменными окружения GOKV_<РАЗДЕЛ>_<ПАРАМЕТР>, например GOKV_STORAGE_MAX_KEYS
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/replication"
+	"github.com/geraev/gokvserver/slowlog"
+	"gopkg.in/yaml.v2"
+)
+
+// EnvPrefix префикс переменных окружения
+const EnvPrefix = "GOKV_"
+
+// Уровни журнала
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Форматы журнала
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Получатели трассировок
+const (
+	ExporterOTLP = "otlp"
+	ExporterFile = "file"
+)
+
+// Хранилища
+const (
+	BackendMap   = "mapbased"
+	BackendBTree = "btree"
+	BackendLSM   = "lsm"
+)
+
+// Обёртки хранилища
+const (
+	MiddlewareMetrics  = "metrics"
+	MiddlewareLogging  = "logging"
+	MiddlewareACL      = "acl"
+	MiddlewareReadOnly = "readonly"
+	MiddlewarePrefix   = "prefix"
+)
+
+// Способы аутентификации HTTP
+const (
+	MethodBasic = "basic"
+	MethodToken = "token"
+	MethodCert  = "cert"
+)
+
+var (
+	ErrUnknownParameter = errors.New("unknown config parameter")
+	ErrRestartRequired  = errors.New("config parameter can't be changed at runtime")
+)
+
+// Config настройки сервера. Параметры с тегом live применяются без перезапуска
+type Config struct {
+	Listeners   Listeners   `yaml:"listeners"`
+	Auth        Auth        `yaml:"auth"`
+	TLS         TLS         `yaml:"tls"`
+	Storage     Storage     `yaml:"storage"`
+	Expiry      Expiry      `yaml:"expiry"`
+	Log         Log         `yaml:"log"`
+	Persistence Persistence `yaml:"persistence"`
+	Replication Replication `yaml:"replication"`
+	Slowlog     Slowlog     `yaml:"slowlog"`
+	Audit       Audit       `yaml:"audit"`
+	Tracing     Tracing     `yaml:"tracing"`
+}
+
+// Listeners порты серверов. Пустое значение отключает сервер
+type Listeners struct {
+	TCP   string `yaml:"tcp"`
+	HTTP  string `yaml:"http"`
+	Pprof string `yaml:"pprof"`
+	// ShutdownTimeout ожидание выполняемых запросов при остановке
+	ShutdownTimeout time.Duration `yaml:"shutdown_timeout"`
+	// RequestTimeout ограничение времени операций с хранилищем для запроса HTTP или команды TCP,
+	// 0 - без ограничения
+	RequestTimeout time.Duration `yaml:"request_timeout" live:"true"`
+}
+
+// Auth пользователи серверов
+type Auth struct {
+	// Accounts учётные записи со всеми правами: имя пользователя - пароль или его хеш bcrypt/argon2id.
+	// Не используются, если задан UsersFile. Учётных записей по умолчанию нет
+	Accounts map[string]string `yaml:"accounts" live:"true"`
+	// UsersFile файл пользователей с хешами паролей и правами, перечитывается по SIGHUP
+	UsersFile string `yaml:"users_file"`
+	// TokenAlgorithm алгоритм подписи токенов JWT: HS256, HS384, HS512, RS256, RS384, RS512
+	TokenAlgorithm string `yaml:"token_algorithm"`
+	// TokenKeyFile секрет HMAC или открытый ключ RSA в PEM, перечитывается по SIGHUP
+	TokenKeyFile string `yaml:"token_key_file"`
+	// CacheMethods, AdminMethods способы аутентификации маршрутов /cache и /admin через запятую: basic, token, cert
+	CacheMethods string `yaml:"cache_methods"`
+	AdminMethods string `yaml:"admin_methods"`
+}
+
+// TLS шифрование соединений TCP и HTTP серверов. Пустой CertFile - без шифрования
+type TLS struct {
+	// CertFile, KeyFile сертификат и закрытый ключ сервера в PEM, перечитываются по SIGHUP
+	CertFile string `yaml:"cert_file"`
+	KeyFile  string `yaml:"key_file"`
+	// ClientCAFile удостоверяющие центры сертификатов клиентов, перечитываются по SIGHUP.
+	// CommonName проверенного сертификата - имя пользователя
+	ClientCAFile string `yaml:"client_ca_file"`
+	// ClientAuth проверка сертификатов клиентов: none, optional, require
+	ClientAuth string `yaml:"client_auth"`
+	// MinVersion минимальная версия TLS: 1.0, 1.1, 1.2, 1.3
+	MinVersion string `yaml:"min_version"`
+}
+
+// Storage хранилище и его ограничения. 0 - без ограничения
+type Storage struct {
+	// Backend хранилище: mapbased, btree (упорядоченные ключи, быстрая выборка диапазона)
+	// либо lsm (данные на диске в каталоге Dir)
+	Backend string `yaml:"backend"`
+	Dir     string `yaml:"dir"`
+	// CacheSize количество ключей в кеше lsm для значений, прочитанных с диска
+	CacheSize int `yaml:"cache_size"`
+	// TierDir каталог, в который mapbased выносит давно не читанные значения, когда их размер
+	// в памяти превышает TierMaxMemory байт. Пустое значение - все значения в памяти
+	TierDir       string `yaml:"tier_dir"`
+	TierMaxMemory int64  `yaml:"tier_max_memory"`
+	// CompressThreshold размер строк и списков mapbased в байтах, с которого они хранятся сжатыми.
+	// 0 - без сжатия, изменение действует на последующие записи
+	CompressThreshold int64 `yaml:"compress_threshold" live:"true"`
+	MaxKeys           int   `yaml:"max_keys" live:"true"`
+	// MaxValueSize размер значения в байтах
+	MaxValueSize int64 `yaml:"max_value_size" live:"true"`
+	// Middleware обёртки хранилища серверов через запятую, первая внешняя: metrics, logging, acl, readonly, prefix
+	Middleware string `yaml:"middleware"`
+	// KeyPrefix префикс ключей обёртки prefix
+	KeyPrefix string `yaml:"key_prefix"`
+}
+
+// Expiry удаление просроченных ключей
+type Expiry struct {
+	// Interval период фоновой проверки
+	Interval time.Duration `yaml:"interval" live:"true"`
+}
+
+// Log журнал сервера
+type Log struct {
+	Level string `yaml:"level" live:"true"`
+	// Format формат записей: json или text
+	Format string `yaml:"format" live:"true"`
+	// File файл журнала, пустое значение - stderr
+	File string `yaml:"file" live:"true"`
+}
+
+// Persistence сохранение данных между перезапусками
+type Persistence struct {
+	// Snapshot файл снимка, загружаемый при запуске и сохраняемый при остановке
+	Snapshot string `yaml:"snapshot"`
+}
+
+// Replication ведущий узел репликации
+type Replication struct {
+	// Backlog размер журнала изменений в байтах, из которого отключившаяся реплика продолжает синхронизацию.
+	// 0 - узел не принимает реплики и не ведёт журнал
+	Backlog int `yaml:"backlog"`
+}
+
+// Slowlog журнал медленных команд TCP и запросов HTTP
+type Slowlog struct {
+	// Threshold длительность, начиная с которой команда попадает в журнал. Отрицательное значение
+	// отключает журнал, 0 - записываются все команды
+	Threshold time.Duration `yaml:"threshold" live:"true"`
+	// MaxLen количество хранимых записей
+	MaxLen int `yaml:"max_len" live:"true"`
+}
+
+// Audit журналы аудита изменяющих операций отдельно для каждого сервера. Пустой файл отключает журнал,
+// серверы с одинаковым файлом пишут в общий журнал
+type Audit struct {
+	TCPFile  string `yaml:"tcp_file"`
+	HTTPFile string `yaml:"http_file"`
+	// MaxSize размер файла в байтах, после которого он переименовывается в <файл>.1
+	MaxSize int64 `yaml:"max_size"`
+	// MaxBackups количество хранимых старых файлов
+	MaxBackups int `yaml:"max_backups"`
+}
+
+// Tracing трассировка запросов HTTP, команд TCP и вызовов хранилища
+type Tracing struct {
+	// Exporter получатель спанов: otlp или file, пустое значение отключает трассировку
+	Exporter string `yaml:"exporter"`
+	// Endpoint адрес приёмника OTLP/HTTP
+	Endpoint string `yaml:"endpoint"`
+	// File файл спанов в формате OTLP JSON для exporter file
+	File string `yaml:"file"`
+	// SampleRatio доля записываемых трассировок, начатых сервером, от 0 до 1. Трассировки из
+	// заголовка traceparent записываются по решению вызывающего сервиса
+	SampleRatio float64 `yaml:"sample_ratio"`
+	// ServiceName имя сервиса в трассировках
+	ServiceName string `yaml:"service_name"`
+}
+
+// Default настройки по умолчанию
+func Default() *Config {
+	return &Config{
+		Listeners: Listeners{
+			TCP:             "9736",
+			HTTP:            "8081",
+			Pprof:           "localhost:6060",
+			ShutdownTimeout: 10 * time.Second,
+		},
+		Auth: Auth{
+			CacheMethods: MethodBasic,
+			AdminMethods: MethodBasic,
+		},
+		TLS: TLS{
+			ClientAuth: auth.ClientCertOptional,
+			MinVersion: "1.2",
+		},
+		Storage: Storage{Backend: BackendMap},
+		Expiry:  Expiry{Interval: 20 * time.Millisecond},
+		Log:     Log{Level: LevelInfo, Format: FormatJSON},
+		Replication: Replication{
+			Backlog: replication.DefaultBacklogSize,
+		},
+		Slowlog: Slowlog{
+			Threshold: slowlog.DefaultThreshold,
+			MaxLen:    slowlog.DefaultMaxLen,
+		},
+		Audit: Audit{
+			MaxSize:    100 << 20,
+			MaxBackups: 5,
+		},
+		Tracing: Tracing{
+			Endpoint:    "http://localhost:4318/v1/traces",
+			SampleRatio: 1,
+			ServiceName: "gokv",
+		},
+	}
+}
+
+// Load настройки по умолчанию, переопределённые файлом path (если задан), переменными окружения
+// и функцией override (например, явно заданными флагами командной строки). Результат проверяется Validate
+func Load(path string, override func(cfg *Config)) (*Config, error) {
+	cfg := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("config %s: %w", path, err)
+		}
+	}
+	if err := cfg.applyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	if override != nil {
+		override(cfg)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv переопределение параметров переменными окружения GOKV_*
+func (c *Config) applyEnv(env []string) error {
+	for _, kv := range env {
+		i := strings.IndexByte(kv, '=')
+		if i < 0 || !strings.HasPrefix(kv, EnvPrefix) {
+			continue
+		}
+		name, value := kv[:i], kv[i+1:]
+		p, ok := c.lookupEnv(name)
+		if !ok {
+			return fmt.Errorf("%w: environment variable %s", ErrUnknownParameter, name)
+		}
+		if err := p.set(value); err != nil {
+			return fmt.Errorf("environment variable %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// Validate проверка всех параметров. Ошибка перечисляет все найденные проблемы
+func (c *Config) Validate() error {
+	var problems []string
+	add := func(name, format string, args ...interface{}) {
+		problems = append(problems, name+": "+fmt.Sprintf(format, args...))
+	}
+
+	for name, port := range map[string]string{"listeners.tcp": c.Listeners.TCP, "listeners.http": c.Listeners.HTTP} {
+		if port == "" {
+			continue
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			add(name, "invalid port %q", port)
+		}
+	}
+	if c.Listeners.TCP == "" && c.Listeners.HTTP == "" {
+		add("listeners", "both the TCP and the HTTP servers are disabled")
+	}
+	if c.Listeners.Pprof != "" {
+		if _, _, err := net.SplitHostPort(c.Listeners.Pprof); err != nil {
+			add("listeners.pprof", "invalid address %q", c.Listeners.Pprof)
+		}
+	}
+	if c.Listeners.ShutdownTimeout <= 0 {
+		add("listeners.shutdown_timeout", "must be positive")
+	}
+	if c.Listeners.RequestTimeout < 0 {
+		add("listeners.request_timeout", "must not be negative")
+	}
+	// Учётных записей по умолчанию нет: сервер без пользователей не запускается
+	if c.Auth.UsersFile == "" && len(c.Auth.Accounts) == 0 {
+		add("auth.accounts", "at least one account or auth.users_file is required")
+	}
+	token, cert := false, false
+	for name, methods := range map[string]string{"auth.cache_methods": c.Auth.CacheMethods, "auth.admin_methods": c.Auth.AdminMethods} {
+		list := Methods(methods)
+		if len(list) == 0 {
+			add(name, "at least one method is required")
+		}
+		for _, method := range list {
+			switch method {
+			case MethodBasic:
+			case MethodToken:
+				token = true
+			case MethodCert:
+				cert = true
+			default:
+				add(name, "unknown method %q, want basic, token or cert", method)
+			}
+		}
+	}
+	if token && c.Auth.TokenKeyFile == "" {
+		add("auth.token_key_file", "required for the token method")
+	}
+	if token || c.Auth.TokenAlgorithm != "" {
+		valid := false
+		for _, algorithm := range auth.TokenAlgorithms {
+			valid = valid || c.Auth.TokenAlgorithm == algorithm
+		}
+		if !valid {
+			add("auth.token_algorithm", "unknown algorithm %q, want one of %s", c.Auth.TokenAlgorithm, strings.Join(auth.TokenAlgorithms, ", "))
+		}
+	}
+	switch c.Storage.Backend {
+	case BackendMap, BackendBTree:
+	case BackendLSM:
+		if c.Storage.Dir == "" {
+			add("storage.dir", "required for the lsm backend")
+		}
+	default:
+		add("storage.backend", "unknown backend %q, want mapbased, btree or lsm", c.Storage.Backend)
+	}
+	if c.Storage.CacheSize < 0 {
+		add("storage.cache_size", "must not be negative")
+	}
+	if c.Storage.TierMaxMemory < 0 {
+		add("storage.tier_max_memory", "must not be negative")
+	}
+	if c.Storage.CompressThreshold < 0 {
+		add("storage.compress_threshold", "must not be negative")
+	} else if c.Storage.CompressThreshold > 0 && c.Storage.Backend != BackendMap {
+		add("storage.compress_threshold", "supported only by the mapbased backend")
+	}
+	if c.Storage.TierDir != "" {
+		if c.Storage.Backend != BackendMap {
+			add("storage.tier_dir", "supported only by the mapbased backend")
+		}
+		if c.Storage.TierMaxMemory == 0 {
+			add("storage.tier_max_memory", "required with storage.tier_dir")
+		}
+	}
+	prefix := false
+	for _, name := range Middlewares(c.Storage.Middleware) {
+		switch name {
+		case MiddlewareMetrics, MiddlewareLogging, MiddlewareACL, MiddlewareReadOnly:
+		case MiddlewarePrefix:
+			prefix = true
+		default:
+			add("storage.middleware", "unknown middleware %q, want metrics, logging, acl, readonly or prefix", name)
+		}
+	}
+	if prefix && c.Storage.KeyPrefix == "" {
+		add("storage.key_prefix", "required for the prefix middleware")
+	}
+	if cert && c.TLS.ClientCAFile == "" {
+		add("tls.client_ca_file", "required for the cert method")
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		add("tls.cert_file", "must be set together with key_file")
+	}
+	if c.TLS.ClientCAFile != "" && c.TLS.CertFile == "" {
+		add("tls.client_ca_file", "requires cert_file")
+	}
+	if _, err := auth.ClientAuthType(c.TLS.ClientAuth); err != nil {
+		add("tls.client_auth", "%v", err)
+	} else if c.TLS.ClientAuth == auth.ClientCertRequire && c.TLS.ClientCAFile == "" {
+		add("tls.client_auth", "require needs client_ca_file")
+	}
+	if _, ok := auth.TLSVersions[c.TLS.MinVersion]; !ok {
+		add("tls.min_version", "unknown version %q, want one of %s", c.TLS.MinVersion, strings.Join(auth.TLSVersionNames(), ", "))
+	}
+	for user := range c.Auth.Accounts {
+		if user == "" || strings.ContainsAny(user, ":,") {
+			add("auth.accounts", "invalid user name %q", user)
+		}
+	}
+	if c.Storage.MaxKeys < 0 {
+		add("storage.max_keys", "must not be negative")
+	}
+	if c.Storage.MaxValueSize < 0 {
+		add("storage.max_value_size", "must not be negative")
+	}
+	if c.Replication.Backlog < 0 {
+		add("replication.backlog", "must not be negative")
+	}
+	if c.Expiry.Interval < time.Millisecond {
+		add("expiry.interval", "must be at least 1ms")
+	}
+	switch c.Log.Format {
+	case FormatJSON, FormatText:
+	default:
+		add("log.format", "unknown format %q, want json or text", c.Log.Format)
+	}
+	if c.Audit.MaxSize <= 0 {
+		add("audit.max_size", "must be positive")
+	}
+	if c.Audit.MaxBackups < 0 {
+		add("audit.max_backups", "must not be negative")
+	}
+	switch c.Tracing.Exporter {
+	case "", ExporterOTLP:
+	case ExporterFile:
+		if c.Tracing.File == "" {
+			add("tracing.file", "required for the file exporter")
+		}
+	default:
+		add("tracing.exporter", "unknown exporter %q, want otlp or file", c.Tracing.Exporter)
+	}
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		add("tracing.sample_ratio", "must be between 0 and 1")
+	}
+	if c.Slowlog.MaxLen < 1 {
+		add("slowlog.max_len", "must be at least 1")
+	}
+	switch c.Log.Level {
+	case LevelDebug, LevelInfo, LevelWarn, LevelError:
+	default:
+		add("log.level", "unknown level %q, want debug, info, warn or error", c.Log.Level)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("invalid config: %s", strings.Join(problems, "; "))
+}
+
+// Methods список способов аутентификации через запятую
+func Methods(s string) []string {
+	var methods []string
+	for _, method := range strings.Split(s, ",") {
+		if method = strings.TrimSpace(method); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// Middlewares обёртки хранилища из списка через запятую
+func Middlewares(s string) []string {
+	return Methods(s)
+}
+
+// Clone копия настроек
+func (c *Config) Clone() *Config {
+	clone := *c
+	clone.Auth.Accounts = make(map[string]string, len(c.Auth.Accounts))
+	for user, password := range c.Auth.Accounts {
+		clone.Auth.Accounts[user] = password
+	}
+	return &clone
+}
+
+// Get значения параметров, имена которых соответствуют шаблону (например, storage.*),
+// в порядке объявления. Пароли не выводятся
+func (c *Config) Get(pattern string) [][2]string {
+	var result [][2]string
+	for _, p := range c.params() {
+		if match(pattern, p.name) {
+			result = append(result, [2]string{p.name, p.String()})
+		}
+	}
+	return result
+}
+
+// Set установка параметра по имени, например storage.max_keys. Значение проверяется только на формат
+func (c *Config) Set(name, value string) error {
+	p, ok := c.lookup(name)
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownParameter, name)
+	}
+	return p.set(value)
+}
+
+// Live можно ли изменить параметр без перезапуска
+func Live(name string) bool {
+	p, ok := Default().lookup(name)
+	return ok && p.live
+}
+
+// Changed имена параметров, значения которых в other отличаются
+func (c *Config) Changed(other *Config) []string {
+	theirs := other.params()
+	var names []string
+	for i, p := range c.params() {
+		if !reflect.DeepEqual(p.value.Interface(), theirs[i].value.Interface()) {
+			names = append(names, p.name)
+		}
+	}
+	return names
+}
+
+// match соответствие имени шаблону path.Match. Имя раздела соответствует всем его параметрам
+func match(pattern, name string) bool {
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	return strings.HasPrefix(name, pattern+".")
+}
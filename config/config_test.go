@@ -0,0 +1,378 @@
+package config
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo/redeotest"
+	"github.com/bsm/redeo/resp"
+)
+
+// writeConfig файл настроек во временном каталоге
+func writeConfig(t *testing.T, content string) string {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "gokv.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// setenv установка переменных окружения на время теста
+func setenv(t *testing.T, env map[string]string) {
+	for name, value := range env {
+		name := name
+		if err := os.Setenv(name, value); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Unsetenv(name) })
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string
+		env      map[string]string
+		override func(cfg *Config)
+		check    func(cfg *Config) bool
+		wantErr  string
+	}{
+		{
+			name: "defaults",
+			check: func(cfg *Config) bool {
+				want := Default()
+				want.Auth.Accounts = map[string]string{"admin": "secret"}
+				return reflect.DeepEqual(cfg, want)
+			},
+		},
+		{
+			name: "file",
+			file: `
+listeners:
+  tcp: "7000"
+  shutdown_timeout: 3s
+  request_timeout: 250ms
+auth:
+  accounts:
+    admin: secret
+storage:
+  max_keys: 100
+expiry:
+  interval: 1s
+`,
+			check: func(cfg *Config) bool {
+				return cfg.Listeners.TCP == "7000" && cfg.Listeners.HTTP == "8081" &&
+					cfg.Listeners.ShutdownTimeout == 3*time.Second && cfg.Listeners.RequestTimeout == 250*time.Millisecond &&
+					reflect.DeepEqual(cfg.Auth.Accounts, map[string]string{"admin": "secret"}) &&
+					cfg.Storage.MaxKeys == 100 && cfg.Expiry.Interval == time.Second
+			},
+		},
+		{
+			name: "environment overrides file",
+			file: "storage:\n  max_keys: 100\n",
+			env: map[string]string{
+				"GOKV_STORAGE_MAX_KEYS":     "5",
+				"GOKV_AUTH_ACCOUNTS":        "a:1,b:2",
+				"GOKV_LOG_LEVEL":            "debug",
+				"GOKV_LISTENERS_PPROF":      "",
+				"GOKV_EXPIRY_INTERVAL":      "100ms",
+				"GOKV_PERSISTENCE_SNAPSHOT": "/tmp/dump.json",
+			},
+			check: func(cfg *Config) bool {
+				return cfg.Storage.MaxKeys == 5 && cfg.Log.Level == LevelDebug && cfg.Listeners.Pprof == "" &&
+					reflect.DeepEqual(cfg.Auth.Accounts, map[string]string{"a": "1", "b": "2"}) &&
+					cfg.Expiry.Interval == 100*time.Millisecond && cfg.Persistence.Snapshot == "/tmp/dump.json"
+			},
+		},
+		{
+			name:     "override wins",
+			env:      map[string]string{"GOKV_LISTENERS_TCP": "7000"},
+			override: func(cfg *Config) { cfg.Listeners.TCP = "7001" },
+			check:    func(cfg *Config) bool { return cfg.Listeners.TCP == "7001" },
+		},
+		{
+			name:    "unknown field",
+			file:    "storage:\n  max_kes: 1\n",
+			wantErr: "field max_kes not found",
+		},
+		{
+			name:    "unknown environment variable",
+			env:     map[string]string{"GOKV_STORAGE_MAX": "1"},
+			wantErr: "GOKV_STORAGE_MAX",
+		},
+		{
+			name:    "invalid environment value",
+			env:     map[string]string{"GOKV_STORAGE_MAX_KEYS": "many"},
+			wantErr: `storage.max_keys: invalid number "many"`,
+		},
+		{
+			name:    "validation",
+			file:    "listeners:\n  tcp: \"99999\"\nlog:\n  level: verbose\nexpiry:\n  interval: 0s\n",
+			wantErr: `expiry.interval: must be at least 1ms; listeners.tcp: invalid port "99999"; log.level: unknown level "verbose"`,
+		},
+		{
+			name:    "unknown log format",
+			env:     map[string]string{"GOKV_LOG_FORMAT": "xml"},
+			wantErr: `log.format: unknown format "xml", want json or text`,
+		},
+		{
+			name:  "tracing",
+			env:   map[string]string{"GOKV_TRACING_EXPORTER": "otlp", "GOKV_TRACING_SAMPLE_RATIO": "0.25"},
+			check: func(cfg *Config) bool { return cfg.Tracing.Exporter == ExporterOTLP && cfg.Tracing.SampleRatio == 0.25 },
+		},
+		{
+			name:    "tracing file without path",
+			env:     map[string]string{"GOKV_TRACING_EXPORTER": "file", "GOKV_TRACING_SAMPLE_RATIO": "2"},
+			wantErr: "tracing.file: required for the file exporter; tracing.sample_ratio: must be between 0 and 1",
+		},
+		{
+			name:    "storage middleware",
+			env:     map[string]string{"GOKV_STORAGE_MIDDLEWARE": "metrics, prefix,cache"},
+			wantErr: `storage.key_prefix: required for the prefix middleware; storage.middleware: unknown middleware "cache", want metrics, logging, acl, readonly or prefix`,
+		},
+		{
+			name:    "negative replication backlog",
+			env:     map[string]string{"GOKV_REPLICATION_BACKLOG": "-1"},
+			wantErr: "replication.backlog: must not be negative",
+		},
+		{
+			name:    "storage backend",
+			env:     map[string]string{"GOKV_STORAGE_BACKEND": "hash"},
+			wantErr: `storage.backend: unknown backend "hash", want mapbased, btree or lsm`,
+		},
+		{
+			name:    "lsm without dir",
+			env:     map[string]string{"GOKV_STORAGE_BACKEND": "lsm", "GOKV_STORAGE_CACHE_SIZE": "-1"},
+			wantErr: "storage.cache_size: must not be negative; storage.dir: required for the lsm backend",
+		},
+		{
+			name:    "compression of lsm",
+			env:     map[string]string{"GOKV_STORAGE_BACKEND": "lsm", "GOKV_STORAGE_DIR": "/tmp/lsm", "GOKV_STORAGE_COMPRESS_THRESHOLD": "1024"},
+			wantErr: "storage.compress_threshold: supported only by the mapbased backend",
+		},
+		{
+			name:    "tier without max memory",
+			env:     map[string]string{"GOKV_STORAGE_TIER_DIR": "/tmp/tier"},
+			wantErr: "storage.tier_max_memory: required with storage.tier_dir",
+		},
+		{
+			name:    "tier of btree",
+			env:     map[string]string{"GOKV_STORAGE_BACKEND": "btree", "GOKV_STORAGE_TIER_DIR": "/tmp/tier", "GOKV_STORAGE_TIER_MAX_MEMORY": "1024"},
+			wantErr: "storage.tier_dir: supported only by the mapbased backend",
+		},
+		{
+			name:    "empty slowlog",
+			env:     map[string]string{"GOKV_SLOWLOG_MAX_LEN": "0"},
+			wantErr: "slowlog.max_len: must be at least 1",
+		},
+		{
+			name:    "no listeners",
+			env:     map[string]string{"GOKV_LISTENERS_TCP": "", "GOKV_LISTENERS_HTTP": ""},
+			wantErr: "both the TCP and the HTTP servers are disabled",
+		},
+		{
+			name:    "token without key",
+			env:     map[string]string{"GOKV_AUTH_CACHE_METHODS": "basic,token", "GOKV_AUTH_ADMIN_METHODS": "password"},
+			wantErr: `auth.admin_methods: unknown method "password", want basic, token or cert; auth.token_algorithm: unknown algorithm ""`,
+		},
+		{
+			name: "token",
+			env:  map[string]string{"GOKV_AUTH_CACHE_METHODS": "token, basic", "GOKV_AUTH_TOKEN_ALGORITHM": "RS256", "GOKV_AUTH_TOKEN_KEY_FILE": "key.pem"},
+			check: func(cfg *Config) bool {
+				return reflect.DeepEqual(Methods(cfg.Auth.CacheMethods), []string{"token", "basic"})
+			},
+		},
+		{
+			name:    "tls",
+			env:     map[string]string{"GOKV_TLS_KEY_FILE": "key.pem", "GOKV_TLS_CLIENT_AUTH": "require", "GOKV_TLS_MIN_VERSION": "1.4", "GOKV_AUTH_ADMIN_METHODS": "cert"},
+			wantErr: `tls.cert_file: must be set together with key_file; tls.client_auth: require needs client_ca_file; tls.client_ca_file: required for the cert method; tls.min_version: unknown version "1.4", want one of 1.0, 1.1, 1.2, 1.3`,
+		},
+		{
+			name: "mutual tls",
+			env: map[string]string{
+				"GOKV_TLS_CERT_FILE": "cert.pem", "GOKV_TLS_KEY_FILE": "key.pem", "GOKV_TLS_CLIENT_CA_FILE": "ca.pem",
+				"GOKV_TLS_CLIENT_AUTH": "require", "GOKV_TLS_MIN_VERSION": "1.3", "GOKV_AUTH_ADMIN_METHODS": "cert",
+			},
+			check: func(cfg *Config) bool { return cfg.TLS.ClientAuth == "require" && cfg.TLS.MinVersion == "1.3" },
+		},
+		{
+			name:    "no accounts",
+			env:     map[string]string{"GOKV_AUTH_ACCOUNTS": "", "GOKV_LISTENERS_HTTP": ""},
+			wantErr: "auth.accounts: at least one account or auth.users_file is required",
+		},
+		{
+			name:  "users file without accounts",
+			env:   map[string]string{"GOKV_AUTH_ACCOUNTS": "", "GOKV_AUTH_USERS_FILE": "users.yaml"},
+			check: func(cfg *Config) bool { return len(cfg.Auth.Accounts) == 0 && cfg.Auth.UsersFile == "users.yaml" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing Load: "+tt.name, func(t *testing.T) {
+			path := ""
+			if tt.file != "" {
+				path = writeConfig(t, tt.file)
+			} else {
+				// Учётных записей по умолчанию нет
+				setenv(t, map[string]string{"GOKV_AUTH_ACCOUNTS": "admin:secret"})
+			}
+			setenv(t, tt.env)
+			cfg, err := Load(path, tt.override)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !tt.check(cfg) {
+				t.Errorf("Load() = %+v", cfg)
+			}
+		})
+	}
+}
+
+func TestConfig_GetSet(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.Accounts = map[string]string{"root": "1", "admin": "2"}
+	if err := cfg.Set("storage.max_keys", "10"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Set("storage.nothing", "10"); !errors.Is(err, ErrUnknownParameter) {
+		t.Errorf("Set() error = %v, want %v", err, ErrUnknownParameter)
+	}
+
+	tests := []struct {
+		pattern string
+		want    [][2]string
+	}{
+		{pattern: "storage", want: [][2]string{{"storage.backend", "mapbased"}, {"storage.dir", ""}, {"storage.cache_size", "0"}, {"storage.tier_dir", ""}, {"storage.tier_max_memory", "0"}, {"storage.compress_threshold", "0"}, {"storage.max_keys", "10"}, {"storage.max_value_size", "0"}, {"storage.middleware", ""}, {"storage.key_prefix", ""}}},
+		{pattern: "storage.*", want: [][2]string{{"storage.backend", "mapbased"}, {"storage.dir", ""}, {"storage.cache_size", "0"}, {"storage.tier_dir", ""}, {"storage.tier_max_memory", "0"}, {"storage.compress_threshold", "0"}, {"storage.max_keys", "10"}, {"storage.max_value_size", "0"}, {"storage.middleware", ""}, {"storage.key_prefix", ""}}},
+		{pattern: "*.interval", want: [][2]string{{"expiry.interval", "20ms"}}},
+		{pattern: "auth.accounts", want: [][2]string{{"auth.accounts", "admin,root"}}},
+		{pattern: "nothing", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run("Testing Get("+tt.pattern+")", func(t *testing.T) {
+			if got := cfg.Get(tt.pattern); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Get() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if n := len(cfg.Get("*")); n != 43 {
+		t.Errorf("Get(*) returned %d parameters, want 43", n)
+	}
+}
+
+func TestStore(t *testing.T) {
+	path := writeConfig(t, "auth:\n  accounts:\n    admin: secret\nstorage:\n  max_keys: 10\n")
+	cfg, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var applied []*Config
+	s := NewStore(path, cfg, nil, func(cfg *Config) error {
+		applied = append(applied, cfg)
+		return nil
+	})
+
+	tests := []struct {
+		name    string
+		command []string
+		want    interface{}
+	}{
+		{
+			name:    "get",
+			command: []string{"get", "storage.max_keys"},
+			want:    []interface{}{"storage.max_keys", "10"},
+		},
+		{
+			name:    "set live parameter",
+			command: []string{"set", "storage.max_keys", "20"},
+			want:    "OK",
+		},
+		{
+			name:    "get after set",
+			command: []string{"GET", "storage.max_keys"},
+			want:    []interface{}{"storage.max_keys", "20"},
+		},
+		{
+			name:    "set restart only parameter",
+			command: []string{"set", "listeners.tcp", "7000"},
+			want:    redeotest.ErrorResponse("config parameter can't be changed at runtime: listeners.tcp"),
+		},
+		{
+			name:    "set invalid value",
+			command: []string{"set", "log.level", "verbose"},
+			want:    redeotest.ErrorResponse(`invalid config: log.level: unknown level "verbose", want debug, info, warn or error`),
+		},
+		{
+			name:    "set unknown parameter",
+			command: []string{"set", "storage.nothing", "1"},
+			want:    redeotest.ErrorResponse(`unknown config parameter "storage.nothing"`),
+		},
+		{
+			name:    "unknown subcommand",
+			command: []string{"rewrite"},
+			want:    redeotest.ErrorResponse("ERR unknown command 'config rewrite'"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing config "+strings.Join(tt.command, " "), func(t *testing.T) {
+			w := redeotest.NewRecorder()
+			s.ServeRedeo(w, resp.NewCommand("config", stringArgs(tt.command)...))
+			got, err := w.Response()
+			if err != nil {
+				got = err
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+	if len(applied) != 1 || applied[0].Storage.MaxKeys != 20 {
+		t.Fatalf("applied %d configs, want one with max_keys 20", len(applied))
+	}
+
+	// Перезагрузка: изменение порта требует перезапуска, остальные параметры применяются
+	content := "listeners:\n  tcp: \"7000\"\nauth:\n  accounts:\n    admin: secret\nstorage:\n  max_keys: 30\n"
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if got := s.Config(); got.Storage.MaxKeys != 30 || got.Listeners.TCP != "9736" {
+		t.Errorf("after reload max_keys = %d, tcp = %q, want 30 and the running 9736", got.Storage.MaxKeys, got.Listeners.TCP)
+	}
+
+	if err := ioutil.WriteFile(path, []byte("storage:\n  max_keys: -1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Reload(); err == nil {
+		t.Error("Reload() of an invalid config succeeded")
+	}
+	if got := s.Config().Storage.MaxKeys; got != 30 {
+		t.Errorf("max_keys = %d after a failed reload, want 30", got)
+	}
+}
+
+func stringArgs(args []string) []resp.CommandArgument {
+	result := make([]resp.CommandArgument, len(args))
+	for i, arg := range args {
+		result[i] = resp.CommandArgument(arg)
+	}
+	return result
+}
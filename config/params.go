@@ -0,0 +1,125 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// param параметр настроек: поле раздела, адресуемое именем <раздел>.<параметр> по тегам yaml
+type param struct {
+	name  string
+	live  bool
+	value reflect.Value
+}
+
+// params все параметры в порядке объявления
+func (c *Config) params() []param {
+	var result []param
+	sections := reflect.ValueOf(c).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		prefix := sections.Type().Field(i).Tag.Get("yaml")
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			result = append(result, param{
+				name:  prefix + "." + field.Tag.Get("yaml"),
+				live:  field.Tag.Get("live") == "true",
+				value: section.Field(j),
+			})
+		}
+	}
+	return result
+}
+
+func (c *Config) lookup(name string) (param, bool) {
+	for _, p := range c.params() {
+		if p.name == strings.ToLower(name) {
+			return p, true
+		}
+	}
+	return param{}, false
+}
+
+// lookupEnv параметр по имени переменной окружения: GOKV_STORAGE_MAX_KEYS - storage.max_keys
+func (c *Config) lookupEnv(env string) (param, bool) {
+	for _, p := range c.params() {
+		if EnvPrefix+strings.ToUpper(strings.Replace(p.name, ".", "_", -1)) == env {
+			return p, true
+		}
+	}
+	return param{}, false
+}
+
+// set разбор значения. Учётные записи задаются списком user:password,user2:password2
+func (p param) set(value string) error {
+	v := p.value
+	switch {
+	case v.Type() == durationType:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", p.name, value)
+		}
+		v.SetInt(int64(d))
+	case v.Kind() == reflect.String:
+		v.SetString(value)
+	case v.Kind() == reflect.Int || v.Kind() == reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", p.name, value)
+		}
+		v.SetInt(n)
+	case v.Kind() == reflect.Float64:
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("%s: invalid number %q", p.name, value)
+		}
+		v.SetFloat(f)
+	case v.Kind() == reflect.Map:
+		accounts := make(map[string]string)
+		for _, pair := range strings.Split(value, ",") {
+			if pair == "" {
+				continue
+			}
+			i := strings.IndexByte(pair, ':')
+			if i < 0 {
+				return fmt.Errorf("%s: invalid pair %q, want user:password", p.name, pair)
+			}
+			accounts[pair[:i]] = pair[i+1:]
+		}
+		v.Set(reflect.ValueOf(accounts))
+	default:
+		return fmt.Errorf("%s: unsupported type %s", p.name, v.Type())
+	}
+	return nil
+}
+
+// String значение параметра. Для учётных записей выводятся только имена пользователей
+func (p param) String() string {
+	v := p.value
+	switch {
+	case v.Type() == durationType:
+		return time.Duration(v.Int()).String()
+	case v.Kind() == reflect.Map:
+		users := make([]string, 0, v.Len())
+		for _, key := range v.MapKeys() {
+			users = append(users, key.String())
+		}
+		sort.Strings(users)
+		return strings.Join(users, ",")
+	default:
+		return fmt.Sprint(v.Interface())
+	}
+}
+
+// copyParam установка значения параметра name из from
+func (c *Config) copyParam(from *Config, name string) {
+	to, _ := c.lookup(name)
+	p, _ := from.lookup(name)
+	to.value.Set(p.value)
+}
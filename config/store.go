@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+)
+
+// Store действующие настройки сервера. Изменения проверяются и передаются функции apply,
+// которая применяет параметры, изменяемые без перезапуска
+type Store struct {
+	path     string
+	override func(cfg *Config)
+	apply    func(cfg *Config) error
+
+	mu  sync.Mutex
+	cfg *Config
+}
+
+// NewStore хранилище настроек cfg, загруженных из path с переопределением override.
+// Начальные настройки apply не передаются
+func NewStore(path string, cfg *Config, override func(cfg *Config), apply func(cfg *Config) error) *Store {
+	return &Store{
+		path:     path,
+		override: override,
+		apply:    apply,
+		cfg:      cfg.Clone(),
+	}
+}
+
+// Config копия действующих настроек
+func (s *Store) Config() *Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg.Clone()
+}
+
+// Set изменение параметра без перезапуска. Изменение действует до перезагрузки настроек
+func (s *Store) Set(name, value string) error {
+	if !Live(name) {
+		if _, ok := Default().lookup(name); !ok {
+			return fmt.Errorf("%w %q", ErrUnknownParameter, name)
+		}
+		return fmt.Errorf("%w: %s", ErrRestartRequired, name)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cfg := s.cfg.Clone()
+	if err := cfg.Set(name, value); err != nil {
+		return err
+	}
+	return s.update(cfg)
+}
+
+// Reload повторное чтение файла и переменных окружения. Параметры, требующие перезапуска,
+// сохраняют действующие значения, их изменение записывается в журнал
+func (s *Store) Reload() error {
+	cfg, err := Load(s.path, s.override)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var restart []string
+	for _, name := range s.cfg.Changed(cfg) {
+		if !Live(name) {
+			restart = append(restart, name)
+			cfg.copyParam(s.cfg, name)
+		}
+	}
+	if len(restart) > 0 {
+		log.Printf("config: restart required to apply %s", strings.Join(restart, ", "))
+	}
+	return s.update(cfg)
+}
+
+// update проверка и применение настроек. Вызывается под блокировкой
+func (s *Store) update(cfg *Config) error {
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	if err := s.apply(cfg); err != nil {
+		return err
+	}
+	s.cfg = cfg
+	return nil
+}
+
+// ServeRedeo обработка команды config get <pattern> | config set <name> <value>
+func (s *Store) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	switch sub := strings.ToLower(c.Arg(0).String()); {
+	case sub == "get" && c.ArgN() == 2:
+		params := s.Config().Get(strings.ToLower(c.Arg(1).String()))
+		w.AppendArrayLen(2 * len(params))
+		for _, p := range params {
+			w.AppendBulkString(p[0])
+			w.AppendBulkString(p[1])
+		}
+	case sub == "set" && c.ArgN() == 3:
+		if err := s.Set(c.Arg(1).String(), c.Arg(2).String()); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	case sub == "get" || sub == "set":
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name + " " + sub))
+	default:
+		w.AppendError(redeo.UnknownCommand(c.Name + " " + sub))
+	}
+}
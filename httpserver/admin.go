@@ -0,0 +1,184 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/info"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/gin-gonic/gin"
+)
+
+type SetUserBody struct {
+	// Password пароль, пустой - сохранить пароль существующего пользователя
+	Password   string          `json:"password"`
+	Algorithm  string          `json:"algorithm"`
+	Categories []auth.Category `json:"categories"`
+	Keys       []string        `json:"keys"`
+}
+
+// requireAdmin доступ только пользователям категории admin
+func (s *Server) requireAdmin(c *gin.Context) {
+	if err := s.can(c.Request.Context(), auth.Admin); err != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	}
+}
+
+// getInfo сведения о сервере: все разделы или раздел из параметра section
+// curl -u admin:pass http://localhost:8081/cache/info?section=keyspace
+func (s *Server) getInfo(c *gin.Context) {
+	c.JSON(
+		http.StatusOK,
+		info.Map(s.info.Sections(c.Query("section"))),
+	)
+}
+
+// getSlowlog последние медленные запросы и команды, по умолчанию 10, count=-1 - все
+// curl -u admin:pass http://localhost:8081/admin/slowlog?count=20
+func (s *Server) getSlowlog(c *gin.Context) {
+	count, err := strconv.Atoi(c.DefaultQuery("count", "10"))
+	if err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": "count: " + err.Error()},
+		)
+		return
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{"len": s.slowlog.Len(), "entries": s.slowlog.Get(count)},
+	)
+}
+
+// resetSlowlog очистка журнала медленных запросов
+// curl -u admin:pass -X DELETE http://localhost:8081/admin/slowlog
+func (s *Server) resetSlowlog(c *gin.Context) {
+	s.slowlog.Reset()
+}
+
+// getTier статистика дискового уровня хранилища
+// curl -u admin:pass http://localhost:8081/admin/tier
+func (s *Server) getTier(c *gin.Context) {
+	stats, _ := s.tiered.TierStats()
+	c.JSON(
+		http.StatusOK,
+		gin.H{"tier": stats},
+	)
+}
+
+// pinKey закрепление значения ключа в памяти
+// curl -u admin:pass -X PUT http://localhost:8081/admin/tier/pin/<key>
+func (s *Server) pinKey(c *gin.Context) {
+	s.tierResult(c, structs.Pin(c.Request.Context(), s.tiered, c.Param("key")))
+}
+
+// unpinKey снятие закрепления ключа
+// curl -u admin:pass -X DELETE http://localhost:8081/admin/tier/pin/<key>
+func (s *Server) unpinKey(c *gin.Context) {
+	s.tierResult(c, structs.Unpin(c.Request.Context(), s.tiered, c.Param("key")))
+}
+
+// tierResult ответ на закрепление ключа, отсутствующий ключ - 404
+func (s *Server) tierResult(c *gin.Context, err error) {
+	if err == nil || statusError(c, err) {
+		return
+	}
+	status := http.StatusInternalServerError
+	if errors.Is(err, structs.ErrKeyNotFound) {
+		status = http.StatusNotFound
+	}
+	c.JSON(
+		status,
+		gin.H{"error": err.Error()},
+	)
+}
+
+// listUsers список пользователей
+// curl -u admin:pass http://localhost:8081/admin/users
+func (s *Server) listUsers(c *gin.Context) {
+	c.JSON(
+		http.StatusOK,
+		gin.H{"users": s.usersStore().Users()},
+	)
+}
+
+// getUser права пользователя
+// curl -u admin:pass http://localhost:8081/admin/users/<name>
+func (s *Server) getUser(c *gin.Context) {
+	u, ok := s.usersStore().User(c.Param("name"))
+	if !ok {
+		c.JSON(
+			http.StatusNotFound,
+			gin.H{"error": structs.ErrNoUser.Error()},
+		)
+		return
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{"user": u},
+	)
+}
+
+// putUser добавление или изменение пользователя. Пароль хешируется на сервере
+// curl -H 'content-type: application/json' -u admin:pass -d '{"password": "secret", "categories": ["read"], "keys": ["cache:*"]}' -X PUT http://localhost:8081/admin/users/<name>
+func (s *Server) putUser(c *gin.Context) {
+	var body SetUserBody
+	if err := c.ShouldBindJSON(&body); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+
+	users := s.usersStore()
+	u := auth.User{Name: c.Param("name"), Categories: body.Categories, Keys: body.Keys}
+	if u.Keys == nil {
+		u.Keys = []string{}
+	}
+	if body.Password == "" {
+		current, _ := users.User(u.Name)
+		u.Password = current.Password
+	} else {
+		hash, err := auth.HashPassword(body.Password, body.Algorithm)
+		if err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{"error": err.Error()},
+			)
+			return
+		}
+		u.Password = hash
+	}
+
+	if err := users.Put(u); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+	u, _ = users.User(u.Name)
+	c.JSON(
+		http.StatusOK,
+		gin.H{"user": u},
+	)
+}
+
+// deleteUser удаление пользователя
+// curl -u admin:pass -X DELETE http://localhost:8081/admin/users/<name>
+func (s *Server) deleteUser(c *gin.Context) {
+	if err := s.usersStore().Remove(c.Param("name")); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, structs.ErrNoUser) {
+			status = http.StatusNotFound
+		}
+		c.JSON(
+			status,
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+}
@@ -0,0 +1,167 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestServer_AdminUsers(t *testing.T) {
+	srv := NewServer("", map[string]string{"admin": "secret"}, mapbased.NewStorage())
+	hash, err := auth.HashPassword("secret", auth.Bcrypt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := auth.NewStore([]auth.User{
+		{Name: "admin", Password: hash, Categories: auth.Categories, Keys: []string{"*"}},
+		{Name: "reader", Password: hash, Categories: []auth.Category{auth.Read}, Keys: []string{"*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.SetUsers(users)
+	handler := srv.Handler()
+
+	tests := []struct {
+		name       string
+		user       string
+		password   string
+		method     string
+		path       string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "list", user: "admin", password: "secret", method: http.MethodGet, path: "/admin/users",
+			wantStatus: http.StatusOK, wantBody: `{"name":"reader","categories":["read"],"keys":["*"]}`,
+		},
+		{
+			name: "reader is not admin", user: "reader", password: "secret", method: http.MethodGet, path: "/admin/users",
+			wantStatus: http.StatusForbidden, wantBody: "NOPERM",
+		},
+		{
+			name: "wrong password", user: "admin", password: "wrong", method: http.MethodGet, path: "/admin/users",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "create", user: "admin", password: "secret", method: http.MethodPut, path: "/admin/users/writer",
+			body:       `{"password": "pass", "algorithm": "argon2id", "categories": ["write"], "keys": ["cache:*"]}`,
+			wantStatus: http.StatusOK, wantBody: `{"user":{"name":"writer","categories":["write"],"keys":["cache:*"]}}`,
+		},
+		{
+			name: "new user writes", user: "writer", password: "pass", method: http.MethodPut, path: "/cache/set/string/cache:1",
+			body: `{"value": "v"}`, wantStatus: http.StatusOK,
+		},
+		{
+			name: "new user writes other key", user: "writer", password: "pass", method: http.MethodPut, path: "/cache/set/string/key",
+			body: `{"value": "v"}`, wantStatus: http.StatusForbidden, wantBody: "NOPERM",
+		},
+		{
+			name: "update keeps password", user: "admin", password: "secret", method: http.MethodPut, path: "/admin/users/writer",
+			body: `{"categories": ["read", "write"], "keys": ["*"]}`, wantStatus: http.StatusOK,
+		},
+		{
+			name: "get", user: "writer", password: "pass", method: http.MethodGet, path: "/cache/key/cache:1",
+			wantStatus: http.StatusOK, wantBody: `{"value":"v"}`,
+		},
+		{
+			name: "invalid category", user: "admin", password: "secret", method: http.MethodPut, path: "/admin/users/writer",
+			body: `{"categories": ["delete"]}`, wantStatus: http.StatusBadRequest, wantBody: "unknown category",
+		},
+		{
+			name: "new user without password", user: "admin", password: "secret", method: http.MethodPut, path: "/admin/users/nobody",
+			body: `{"categories": ["read"]}`, wantStatus: http.StatusBadRequest, wantBody: "password hash is required",
+		},
+		{
+			name: "delete", user: "admin", password: "secret", method: http.MethodDelete, path: "/admin/users/writer",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "deleted user", user: "writer", password: "pass", method: http.MethodGet, path: "/cache/key/cache:1",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "get missing", user: "admin", password: "secret", method: http.MethodGet, path: "/admin/users/writer",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name: "delete missing", user: "admin", password: "secret", method: http.MethodDelete, path: "/admin/users/writer",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing admin: "+tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			req.SetBasicAuth(tt.user, tt.password)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestServer_AdminTier(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	if err := storage.SetTier(t.TempDir(), 10); err != nil {
+		t.Fatal(err)
+	}
+	storage.PutOrUpdateString("a", "12345")
+	storage.PutOrUpdateString("b", "12345")
+	srv := NewServer("", map[string]string{"admin": "secret"}, storage)
+	srv.SetTier(storage)
+	handler := srv.Handler()
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name: "stats", method: http.MethodGet, path: "/admin/tier",
+			wantStatus: http.StatusOK, wantBody: `"memory_keys":1,"disk_keys":1`,
+		},
+		{name: "pin", method: http.MethodPut, path: "/admin/tier/pin/a", wantStatus: http.StatusOK},
+		{
+			name: "pinned stats", method: http.MethodGet, path: "/admin/tier",
+			wantStatus: http.StatusOK, wantBody: `"pinned_keys":1,"spills":2,"faults":1`,
+		},
+		{name: "unpin", method: http.MethodDelete, path: "/admin/tier/pin/a", wantStatus: http.StatusOK},
+		{
+			name: "pin missing key", method: http.MethodPut, path: "/admin/tier/pin/missing",
+			wantStatus: http.StatusNotFound, wantBody: "key not found",
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing admin: "+tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.SetBasicAuth("admin", "secret")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/gin-gonic/gin"
+)
+
+// Группы маршрутов, для которых задаются способы аутентификации
+const (
+	GroupCache = "/cache"
+	GroupAdmin = "/admin"
+)
+
+// ErrNoCredentials запрос не содержит учётных данных, которые проверяет Authenticator
+var ErrNoCredentials = errors.New("no credentials")
+
+// Authenticator способ аутентификации запросов
+type Authenticator interface {
+	// Authenticate контекст запроса с пользователем (auth.WithUser или auth.WithPrincipal).
+	// Если учётных данных этого вида в запросе нет, возвращает ErrNoCredentials
+	Authenticate(r *http.Request) (context.Context, error)
+	// Challenge значение заголовка WWW-Authenticate ответа 401, пустое значение не передаётся
+	Challenge() string
+}
+
+// SetAuthenticators способы аутентификации группы маршрутов, проверяемые по порядку до первого,
+// учётные данные которого есть в запросе. По умолчанию - BasicAuth. Вызывается до Handler
+func (s *Server) SetAuthenticators(group string, authenticators ...Authenticator) {
+	if s.authenticators == nil {
+		s.authenticators = make(map[string][]Authenticator)
+	}
+	s.authenticators[group] = authenticators
+}
+
+// authenticate аутентификация запросов группы маршрутов
+func (s *Server) authenticate(group string) gin.HandlerFunc {
+	authenticators := s.authenticators[group]
+	if len(authenticators) == 0 {
+		authenticators = []Authenticator{s.BasicAuth()}
+	}
+	return func(c *gin.Context) {
+		var err error
+		for _, a := range authenticators {
+			var ctx context.Context
+			if ctx, err = a.Authenticate(c.Request); err == ErrNoCredentials {
+				continue
+			}
+			if err == nil {
+				c.Set(gin.AuthUserKey, auth.UserFromContext(ctx))
+				c.Request = c.Request.WithContext(ctx)
+				return
+			}
+			break
+		}
+
+		for _, a := range authenticators {
+			if challenge := a.Challenge(); challenge != "" {
+				c.Writer.Header().Add("WWW-Authenticate", challenge)
+			}
+		}
+		if err != nil && err != ErrNoCredentials {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.AbortWithStatus(http.StatusUnauthorized)
+	}
+}
+
+// can разрешена ли пользователю запроса категория команд
+func (s *Server) can(ctx context.Context, category auth.Category) error {
+	if u, ok := auth.PrincipalFromContext(ctx); ok {
+		if !u.Can(category) {
+			return structs.ErrNoPerm
+		}
+		return nil
+	}
+	return s.usersStore().Can(auth.UserFromContext(ctx), category)
+}
+
+// authorize разрешена ли пользователю запроса категория команд над ключом
+func (s *Server) authorize(ctx context.Context, category auth.Category, key string) error {
+	if u, ok := auth.PrincipalFromContext(ctx); ok {
+		return u.Authorize(category, key)
+	}
+	return s.usersStore().Authorize(auth.UserFromContext(ctx), category, key)
+}
+
+// BasicAuth базовая аутентификация действующих пользователей сервера
+func (s *Server) BasicAuth() Authenticator {
+	return basicAuth{s: s}
+}
+
+type basicAuth struct {
+	s *Server
+}
+
+func (a basicAuth) Authenticate(r *http.Request) (context.Context, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if err := a.s.usersStore().Authenticate(user, password); err != nil {
+		return nil, err
+	}
+	return auth.WithUser(r.Context(), user), nil
+}
+
+func (basicAuth) Challenge() string {
+	return `Basic realm="Authorization Required"`
+}
+
+// TokenAuth аутентификация подписанными токенами из заголовка Authorization: Bearer <token>.
+// Права пользователя задаёт токен
+func TokenAuth(verifier *auth.TokenVerifier) Authenticator {
+	return tokenAuth{verifier: verifier}
+}
+
+type tokenAuth struct {
+	verifier *auth.TokenVerifier
+}
+
+func (a tokenAuth) Authenticate(r *http.Request) (context.Context, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return nil, ErrNoCredentials
+	}
+	u, err := a.verifier.Verify(strings.TrimSpace(header[7:]))
+	if err != nil {
+		return nil, err
+	}
+	return auth.WithPrincipal(r.Context(), u), nil
+}
+
+func (tokenAuth) Challenge() string {
+	return `Bearer realm="Authorization Required"`
+}
+
+// CertAuth аутентификация проверенным сертификатом клиента TLS: CommonName субъекта - имя
+// пользователя сервера
+func (s *Server) CertAuth() Authenticator {
+	return certAuth{s: s}
+}
+
+type certAuth struct {
+	s *Server
+}
+
+func (a certAuth) Authenticate(r *http.Request) (context.Context, error) {
+	user, ok := auth.CertificateUser(r.TLS)
+	if !ok {
+		return nil, ErrNoCredentials
+	}
+	if _, ok := a.s.usersStore().User(user); !ok {
+		return nil, structs.ErrNoUser
+	}
+	return auth.WithUser(r.Context(), user), nil
+}
+
+// Challenge сертификат передаётся при установке соединения, схемы HTTP для него нет
+func (certAuth) Challenge() string {
+	return ""
+}
@@ -0,0 +1,129 @@
+package httpserver
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/golang-jwt/jwt"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func TestServer_Authenticators(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpserver")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "secret")
+	if err := ioutil.WriteFile(keyFile, []byte(testSecret), 0600); err != nil {
+		t.Fatal(err)
+	}
+	verifier, err := auth.LoadTokenVerifier("HS256", keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := NewServer("", map[string]string{"admin": "secret"}, mapbased.NewStorage())
+	srv.SetAuthenticators(GroupCache, TokenAuth(verifier), srv.BasicAuth())
+	handler := srv.Handler()
+
+	token := func(scope string, keys []string, ttl time.Duration) string {
+		claims := auth.TokenClaims{
+			Scope:          scope,
+			Keys:           keys,
+			StandardClaims: jwt.StandardClaims{Subject: "service", ExpiresAt: time.Now().Add(ttl).Unix()},
+		}
+		signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(testSecret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return "Bearer " + signed
+	}
+
+	tests := []struct {
+		name          string
+		authorization string
+		basic         bool
+		method        string
+		path          string
+		body          string
+		wantStatus    int
+		wantBody      string
+	}{
+		{
+			name: "token writes allowed key", authorization: token("read write", []string{"svc:"}, time.Hour),
+			method: http.MethodPut, path: "/cache/set/string/svc:1", body: `{"value": "v"}`, wantStatus: http.StatusOK,
+		},
+		{
+			name: "token reads allowed key", authorization: token("read", []string{"svc:"}, time.Hour),
+			method: http.MethodGet, path: "/cache/key/svc:1", wantStatus: http.StatusOK, wantBody: `{"value":"v"}`,
+		},
+		{
+			name: "token without write scope", authorization: token("read", []string{"svc:"}, time.Hour),
+			method: http.MethodPut, path: "/cache/set/string/svc:1", body: `{"value": "v"}`, wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "token reads other key", authorization: token("read", []string{"svc:"}, time.Hour),
+			method: http.MethodGet, path: "/cache/key/other", wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "token lists keys with empty prefix", authorization: token("read", []string{""}, time.Hour),
+			method: http.MethodGet, path: "/cache/keys", wantStatus: http.StatusOK, wantBody: `"svc:1"`,
+		},
+		{
+			name: "expired token", authorization: token("read", []string{""}, -time.Minute),
+			method: http.MethodGet, path: "/cache/keys", wantStatus: http.StatusUnauthorized, wantBody: "invalid token",
+		},
+		{
+			name: "basic auth on the same group", basic: true,
+			method: http.MethodGet, path: "/cache/key/svc:1", wantStatus: http.StatusOK,
+		},
+		{
+			name: "token on the basic only group", authorization: token("admin", []string{""}, time.Hour),
+			method: http.MethodGet, path: "/admin/users", wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "basic auth on the admin group", basic: true,
+			method: http.MethodGet, path: "/admin/users", wantStatus: http.StatusOK,
+		},
+		{
+			name: "no credentials", method: http.MethodGet, path: "/cache/keys", wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing authenticators: "+tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.basic {
+				req.SetBasicAuth("admin", "secret")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+			}
+		})
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/cache/keys", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	if got := w.Header()["Www-Authenticate"]; len(got) != 2 || !strings.HasPrefix(got[0], "Bearer") || !strings.HasPrefix(got[1], "Basic") {
+		t.Errorf("WWW-Authenticate = %q, want Bearer and Basic challenges", got)
+	}
+}
@@ -0,0 +1,60 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+func TestServer_RequestTimeout(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	storage.PutOrUpdateString("a", "value")
+	srv := NewServer("", map[string]string{"admin": "secret"}, storage)
+	srv.SetRequestTimeout(20 * time.Millisecond)
+	handler := srv.Handler()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		cancel bool
+		want   int
+	}{
+		{name: "keys", method: http.MethodGet, path: "/cache/keys", want: http.StatusServiceUnavailable},
+		{name: "get", method: http.MethodGet, path: "/cache/key/a", want: http.StatusServiceUnavailable},
+		{name: "internal element", method: http.MethodGet, path: "/cache/key/a/0", want: http.StatusServiceUnavailable},
+		{name: "set", method: http.MethodPut, path: "/cache/set/string/a", body: `{"value": "new"}`, want: http.StatusServiceUnavailable},
+		{name: "ttl", method: http.MethodPost, path: "/cache/set/ttl/a", body: `{"value": 1}`, want: http.StatusServiceUnavailable},
+		{name: "remove", method: http.MethodDelete, path: "/cache/remove/a", want: http.StatusServiceUnavailable},
+		{name: "client gone", method: http.MethodGet, path: "/cache/keys", cancel: true, want: statusClientClosed},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			// Хранилище занято дольше времени запроса
+			storage.Lock()
+			defer storage.Unlock()
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.SetBasicAuth("admin", "secret")
+			if tt.cancel {
+				ctx, cancel := context.WithCancel(req.Context())
+				cancel()
+				req = req.WithContext(ctx)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", rec.Code, tt.want, rec.Body.String())
+			}
+		})
+	}
+	if val, err := storage.GetElement("a"); err != nil || val != "value" {
+		t.Errorf("GetElement() = %v, %v, want the value before the timed out requests", val, err)
+	}
+}
@@ -0,0 +1,166 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/geraev/gokvserver/structs"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// mimeOctetStream значение в теле запроса или ответа без обёртки JSON, побайтово
+	mimeOctetStream = "application/octet-stream"
+	// encodingBase64 строки значения в JSON закодированы base64. JSON передаёт только
+	// корректный UTF-8, произвольные байты кодируются
+	encodingBase64 = "base64"
+)
+
+var errNotString = errors.New("value is not a string")
+
+// checkEncoding допустимая кодировка строк в JSON: пустая или base64
+func checkEncoding(encoding string) error {
+	if encoding != "" && encoding != encodingBase64 {
+		return fmt.Errorf("encoding: unknown encoding %q", encoding)
+	}
+	return nil
+}
+
+// responseEncoding кодировка значения в ответе из параметра encoding. При ошибке записывает её в ответ
+func responseEncoding(c *gin.Context) (string, bool) {
+	encoding := c.Query("encoding")
+	if err := checkEncoding(encoding); err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": err.Error()},
+		)
+		return "", false
+	}
+	return encoding, true
+}
+
+// wantRaw клиент ждёт значение без обёртки JSON (Accept: application/octet-stream)
+func wantRaw(c *gin.Context) bool {
+	return c.NegotiateFormat(gin.MIMEJSON, mimeOctetStream) == mimeOctetStream
+}
+
+// writeValue ответ со значением: строка побайтово, если клиент её ждёт, иначе JSON в кодировке encoding
+func writeValue(c *gin.Context, val interface{}, encoding string) {
+	if wantRaw(c) {
+		v, ok := val.(string)
+		if !ok {
+			c.JSON(
+				http.StatusNotAcceptable,
+				gin.H{"error": errNotString.Error()},
+			)
+			return
+		}
+		c.Data(http.StatusOK, mimeOctetStream, []byte(v))
+		return
+	}
+	if encoding == "" {
+		c.JSON(
+			http.StatusOK,
+			gin.H{"value": val},
+		)
+		return
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{"value": encodeValue(val), "encoding": encoding},
+	)
+}
+
+// encodeValue значение со строками в base64, у словаря кодируются и ключи
+func encodeValue(val interface{}) interface{} {
+	enc := base64.StdEncoding.EncodeToString
+	switch v := val.(type) {
+	case string:
+		return enc([]byte(v))
+	case []string:
+		list := make([]string, len(v))
+		for i, item := range v {
+			list[i] = enc([]byte(item))
+		}
+		return list
+	case map[string]string:
+		dict := make(map[string]string, len(v))
+		for k, item := range v {
+			dict[enc([]byte(k))] = enc([]byte(item))
+		}
+		return dict
+	default:
+		return val
+	}
+}
+
+// decodeString строка тела запроса в кодировке encoding
+func decodeString(encoding, s string) (string, error) {
+	if err := checkEncoding(encoding); err != nil || encoding == "" {
+		return s, err
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("value: %v", err)
+	}
+	return string(data), nil
+}
+
+// decodeList элементы списка в кодировке encoding
+func decodeList(encoding string, list []string) ([]string, error) {
+	if err := checkEncoding(encoding); err != nil || encoding == "" {
+		return list, err
+	}
+	decoded := make([]string, len(list))
+	for i, item := range list {
+		val, err := decodeString(encoding, item)
+		if err != nil {
+			return nil, err
+		}
+		decoded[i] = val
+	}
+	return decoded, nil
+}
+
+// decodeDictionary ключи и значения словаря в кодировке encoding
+func decodeDictionary(encoding string, dict map[string]string) (map[string]string, error) {
+	if err := checkEncoding(encoding); err != nil || encoding == "" {
+		return dict, err
+	}
+	decoded := make(map[string]string, len(dict))
+	for k, item := range dict {
+		key, err := decodeString(encoding, k)
+		if err != nil {
+			return nil, err
+		}
+		if decoded[key], err = decodeString(encoding, item); err != nil {
+			return nil, err
+		}
+	}
+	return decoded, nil
+}
+
+// readRaw тело запроса побайтово
+func readRaw(c *gin.Context) (string, error) {
+	data, err := ioutil.ReadAll(c.Request.Body)
+	return string(data), err
+}
+
+// bodyError ответ на ошибку чтения тела запроса: превышение max_value_size - 413, иначе 400
+func bodyError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(
+			http.StatusRequestEntityTooLarge,
+			gin.H{"error": structs.ErrValueTooLarge.Error()},
+		)
+		return
+	}
+	c.JSON(
+		http.StatusBadRequest,
+		gin.H{"error": err.Error()},
+	)
+}
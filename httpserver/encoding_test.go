@@ -0,0 +1,119 @@
+package httpserver
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+func TestServer_BinaryValues(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	srv := NewServer("", map[string]string{"admin": "secret"}, storage)
+	srv.SetMaxValueSize(128)
+	handler := srv.Handler()
+
+	binary := " lead\x00\r\nbinary\xff trail "
+	encoded := base64.StdEncoding.EncodeToString([]byte(binary))
+	storage.PutOrUpdateList("list", []string{"a", binary})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		contentType string
+		accept      string
+		body        string
+		wantStatus  int
+		wantBody    string
+	}{
+		{
+			name: "octet-stream put", method: http.MethodPut, path: "/cache/set/string/raw",
+			contentType: mimeOctetStream, body: binary, wantStatus: http.StatusOK,
+		},
+		{
+			name: "octet-stream get", method: http.MethodGet, path: "/cache/key/raw",
+			accept: mimeOctetStream, wantStatus: http.StatusOK, wantBody: binary,
+		},
+		{
+			name: "base64 get", method: http.MethodGet, path: "/cache/key/raw?encoding=base64",
+			wantStatus: http.StatusOK, wantBody: `{"encoding":"base64","value":"` + encoded + `"}`,
+		},
+		{
+			name: "base64 put", method: http.MethodPut, path: "/cache/set/string/encoded",
+			contentType: "application/json", body: `{"value":"` + encoded + `","encoding":"base64"}`, wantStatus: http.StatusOK,
+		},
+		{
+			name: "base64 list put", method: http.MethodPut, path: "/cache/set/list/encoded:list",
+			contentType: "application/json", body: `{"value":["YQ==","` + encoded + `"],"encoding":"base64"}`, wantStatus: http.StatusOK,
+		},
+		{
+			name: "base64 dictionary put", method: http.MethodPut, path: "/cache/set/dictionary/encoded:dict",
+			contentType: "application/json", body: `{"value":{"YQ==":"` + encoded + `"},"encoding":"base64"}`, wantStatus: http.StatusOK,
+		},
+		{
+			name: "list base64 get", method: http.MethodGet, path: "/cache/key/list?encoding=base64",
+			wantStatus: http.StatusOK, wantBody: `{"encoding":"base64","value":["YQ==","` + encoded + `"]}`,
+		},
+		{
+			name: "list element octet-stream get", method: http.MethodGet, path: "/cache/key/list/1",
+			accept: mimeOctetStream, wantStatus: http.StatusOK, wantBody: binary,
+		},
+		{
+			name: "json get", method: http.MethodGet, path: "/cache/key/list/0",
+			accept: "application/json, application/octet-stream", wantStatus: http.StatusOK, wantBody: `{"value":"a"}`,
+		},
+		{
+			name: "list octet-stream get", method: http.MethodGet, path: "/cache/key/list",
+			accept: mimeOctetStream, wantStatus: http.StatusNotAcceptable, wantBody: errNotString.Error(),
+		},
+		{
+			name: "unknown encoding", method: http.MethodGet, path: "/cache/key/raw?encoding=hex",
+			wantStatus: http.StatusBadRequest, wantBody: "unknown encoding",
+		},
+		{
+			name: "bad base64", method: http.MethodPut, path: "/cache/set/string/bad",
+			contentType: "application/json", body: `{"value":"%%%","encoding":"base64"}`, wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "octet-stream too large", method: http.MethodPut, path: "/cache/set/string/large",
+			contentType: mimeOctetStream, body: strings.Repeat("x", 129), wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.SetBasicAuth("admin", "secret")
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %q, want %q", w.Body, tt.wantBody)
+			}
+		})
+	}
+
+	for key, want := range map[string]interface{}{
+		"raw":          binary,
+		"encoded":      binary,
+		"encoded:list": []string{"a", binary},
+		"encoded:dict": map[string]string{"a": binary},
+	} {
+		if val, err := storage.GetElement(key); err != nil || !reflect.DeepEqual(val, want) {
+			t.Errorf("GetElement(%q) = %q, %v, want %q", key, val, err, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/btree"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/structs"
+)
+
+func TestServer_KeysRange(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		wantStatus int
+		want       []string
+	}{
+		{name: "all keys", query: "", wantStatus: http.StatusOK, want: []string{"order:1", "user:1", "user:1:session", "user:2", "user:3"}},
+		{name: "prefix", query: "?prefix=user:1", wantStatus: http.StatusOK, want: []string{"user:1", "user:1:session"}},
+		{name: "range", query: "?prefix=user:&start=user:2&end=user:3", wantStatus: http.StatusOK, want: []string{"user:2"}},
+		{name: "limit", query: "?prefix=user:&limit=2", wantStatus: http.StatusOK, want: []string{"user:1", "user:1:session"}},
+		{name: "empty range", query: "?prefix=session:", wantStatus: http.StatusOK, want: []string{}},
+		{name: "bad limit", query: "?limit=ten", wantStatus: http.StatusBadRequest},
+		{name: "negative limit", query: "?limit=-1", wantStatus: http.StatusBadRequest},
+	}
+	for name, storage := range map[string]structs.Storage{"btree": btree.NewStorage(), "mapbased": mapbased.NewStorage()} {
+		defer storage.(io.Closer).Close()
+		for _, key := range []string{"user:2", "user:1", "order:1", "user:3", "user:1:session"} {
+			storage.PutOrUpdateString(key, "1")
+		}
+		handler := NewServer("", map[string]string{"admin": "secret"}, storage).Handler()
+		for _, tt := range tests {
+			t.Run("Testing "+name+" "+tt.name, func(t *testing.T) {
+				req := httptest.NewRequest(http.MethodGet, "/cache/keys"+tt.query, nil)
+				req.SetBasicAuth("admin", "secret")
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, req)
+				if rec.Code != tt.wantStatus {
+					t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+				}
+				if tt.wantStatus != http.StatusOK {
+					return
+				}
+				var body struct {
+					Keys []string `json:"keys"`
+				}
+				if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if name == "mapbased" && tt.query == "" {
+					// Без параметров порядок ключей не гарантирован
+					return
+				}
+				if !reflect.DeepEqual(body.Keys, tt.want) {
+					t.Errorf("keys = %q, want %q", body.Keys, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestServer_Inspect(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	storage.SetCompression(100)
+	storage.PutOrUpdateList("list", []string{strings.Repeat("item", 50), strings.Repeat("item", 50)})
+	storage.PutOrUpdateString("small", "value")
+	srv := NewServer("", map[string]string{"admin": "secret"}, storage)
+	srv.SetInspector(storage)
+	handler := srv.Handler()
+
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "compressed", key: "list", wantStatus: http.StatusOK, wantBody: `"encoding":"deflate","pinned":false`},
+		{name: "raw", key: "small", wantStatus: http.StatusOK, wantBody: `"encoding":"raw","pinned":false,"ratio":1,"size":5,"stored_size":5,"tier":"memory","type":"string"`},
+		{name: "missing key", key: "missing", wantStatus: http.StatusNotFound, wantBody: "key not found"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/cache/inspect/"+tt.key, nil)
+			req.SetBasicAuth("admin", "secret")
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package httpserver
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/info"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+// SetAccounts замена пользователей учётными записями вида имя - пароль (или хеш пароля) со всеми правами.
+// Может вызываться во время работы сервера
+func (s *Server) SetAccounts(accounts map[string]string) error {
+	users, err := auth.FromAccounts(accounts)
+	if err != nil {
+		return err
+	}
+	s.SetUsers(users)
+	return nil
+}
+
+// SetUsers замена пользователей. Может вызываться во время работы сервера
+func (s *Server) SetUsers(users *auth.Store) {
+	s.users.Store(users)
+}
+
+// usersStore действующие пользователи
+func (s *Server) usersStore() *auth.Store {
+	return s.users.Load().(*auth.Store)
+}
+
+// SetMaxValueSize ограничение размера тела запроса в байтах, 0 - без ограничения.
+// Может вызываться во время работы сервера
+func (s *Server) SetMaxValueSize(n int64) {
+	atomic.StoreInt64(&s.maxValueSize, n)
+}
+
+// SetRequestLogging включение журнала запросов. Может вызываться во время работы сервера
+func (s *Server) SetRequestLogging(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&s.logRequests, v)
+}
+
+// SetRequestTimeout ограничение времени операций с хранилищем для запроса, 0 - без ограничения.
+// Может вызываться во время работы сервера
+func (s *Server) SetRequestTimeout(d time.Duration) {
+	atomic.StoreInt64(&s.requestTimeout, int64(d))
+}
+
+// deadline ограничение контекста запроса временем SetRequestTimeout
+func (s *Server) deadline(c *gin.Context) {
+	timeout := time.Duration(atomic.LoadInt64(&s.requestTimeout))
+	if timeout <= 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+	defer cancel()
+	c.Request = c.Request.WithContext(ctx)
+	c.Next()
+}
+
+// limitBody ограничение размера тела запроса
+func (s *Server) limitBody(c *gin.Context) {
+	max := atomic.LoadInt64(&s.maxValueSize)
+	if max <= 0 || c.Request.Body == nil {
+		return
+	}
+	if c.Request.ContentLength > max {
+		c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": structs.ErrValueTooLarge.Error()})
+		return
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, max)
+}
+
+// requestID идентификатор запроса из заголовка X-Request-ID или новый. Идентификатор возвращается
+// в ответе и передаётся дальше в контексте и заголовке запроса, например при проксировании
+func (s *Server) requestID(c *gin.Context) {
+	id := c.GetHeader(logging.HeaderRequestID)
+	if !logging.ValidRequestID(id) {
+		id = logging.NewRequestID()
+		c.Request.Header.Set(logging.HeaderRequestID, id)
+	}
+	c.Header(logging.HeaderRequestID, id)
+	c.Request = c.Request.WithContext(logging.WithRequestID(c.Request.Context(), id))
+}
+
+// trace спан запроса, продолжающий трассировку из заголовка traceparent
+func (s *Server) trace(routes map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.tracer == nil {
+			return
+		}
+		ctx := c.Request.Context()
+		if parent, err := tracing.ParseTraceparent(c.GetHeader(tracing.HeaderTraceparent)); err == nil {
+			ctx = tracing.ContextWithRemote(ctx, parent)
+		}
+		route := route(routes, c)
+		ctx, span := s.tracer.Start(ctx, c.Request.Method+" "+route, tracing.KindServer)
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+		if span == nil {
+			return
+		}
+
+		ctx = c.Request.Context()
+		status := c.Writer.Status()
+		span.SetAttribute("http.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("http.target", c.Request.URL.Path)
+		span.SetAttribute("http.status_code", status)
+		span.SetAttribute("net.peer.ip", c.ClientIP())
+		span.SetAttribute("enduser.id", auth.UserFromContext(ctx))
+		span.SetAttribute("request_id", logging.RequestID(ctx))
+		if status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(status)))
+		}
+		if err := c.Errors.Last(); err != nil && !errors.Is(err.Err, structs.ErrKeyNotFound) {
+			span.SetError(err)
+		}
+		span.End()
+	}
+}
+
+// logger журнал запросов, если он включён: структурированный, если задан SetLogger, иначе текстовый
+// журнал gin в стандартный журнал
+func (s *Server) logger(routes map[string]string) gin.HandlerFunc {
+	text := gin.LoggerWithWriter(logWriter{})
+	return func(c *gin.Context) {
+		if atomic.LoadInt32(&s.logRequests) != 1 {
+			return
+		}
+		if s.log == nil {
+			text(c)
+			return
+		}
+		if !s.log.Enabled(logging.LevelInfo) {
+			return
+		}
+		start := time.Now()
+		c.Next()
+		ctx := c.Request.Context()
+		var errMsg string
+		if err := c.Errors.Last(); err != nil {
+			errMsg = err.Error()
+		}
+		s.log.Info(ctx, "request",
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"route", route(routes, c),
+			"status", c.Writer.Status(),
+			"duration", time.Since(start),
+			"size", c.Writer.Size(),
+			"client", c.ClientIP(),
+			"user", auth.UserFromContext(ctx),
+			"error", errMsg,
+		)
+	}
+}
+
+// route шаблон маршрута запроса, для несуществующих маршрутов - unmatched
+func route(routes map[string]string, c *gin.Context) string {
+	if route, ok := routes[c.Request.Method+" "+c.HandlerName()]; ok {
+		return route
+	}
+	return "unmatched"
+}
+
+// auditTrail запись изменяющих запросов в журнал аудита. Ключ операции записывает проверка доступа,
+// для запросов без неё (например, к пользователям) - первый параметр маршрута
+func (s *Server) auditTrail(routes map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.audit == nil {
+			return
+		}
+		switch c.Request.Method {
+		case http.MethodPut, http.MethodPost, http.MethodPatch, http.MethodDelete:
+		default:
+			return
+		}
+		c.Request = c.Request.WithContext(logging.WithAuditKey(c.Request.Context()))
+		c.Next()
+
+		ctx := c.Request.Context()
+		key := logging.AuditKey(ctx)
+		if key == "" && len(c.Params) > 0 {
+			key = c.Params[0].Value
+		}
+		status := c.Writer.Status()
+		record := logging.Record{
+			RequestID: logging.RequestID(ctx),
+			Proto:     logging.ProtoHTTP,
+			User:      auth.UserFromContext(ctx),
+			Client:    c.ClientIP(),
+			Op:        c.Request.Method + " " + route(routes, c),
+			Key:       key,
+			OK:        status < http.StatusBadRequest,
+		}
+		if !record.OK {
+			if err := c.Errors.Last(); err != nil {
+				record.Error = err.Error()
+			} else {
+				record.Error = strconv.Itoa(status) + " " + http.StatusText(status)
+			}
+		}
+		if err := s.audit.Log(record); err != nil {
+			s.log.Error(ctx, "audit log write failed", "error", err)
+		}
+	}
+}
+
+// observe учёт запроса в метриках, статистике команд и журнале медленных запросов. routes - шаблоны маршрутов по методу и имени
+// обработчика, запросы к несуществующим маршрутам учитываются вместе
+func (s *Server) observe(routes map[string]string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.metrics == nil && s.info == nil && s.slowlog == nil {
+			return
+		}
+		start := time.Now()
+		c.Next()
+		d := time.Since(start)
+		route := route(routes, c)
+		s.metrics.ObserveHTTP(c.Request.Method, route, c.Writer.Status(), d)
+		if s.info != nil {
+			s.info.Record(commandName(c.Request.Method, route), result(c), d)
+		}
+		if s.slowlog.Slow(d) {
+			user := auth.UserFromContext(c.Request.Context())
+			s.slowlog.Add(d, c.Request.RemoteAddr, user, []string{c.Request.Method, c.Request.URL.Path})
+		}
+	}
+}
+
+// commandName имя запроса в статистике команд, например http_get_cache_key_key для GET /cache/key/:key
+func commandName(method, route string) string {
+	route = strings.NewReplacer("/", "_", ":", "").Replace(strings.TrimPrefix(route, "/"))
+	return strings.ToLower("http_" + method + "_" + route)
+}
+
+// result результат запроса. Обработчики отмечают обращение к отсутствующему ключу ошибкой контекста
+func result(c *gin.Context) info.Result {
+	for _, err := range c.Errors {
+		if errors.Is(err.Err, structs.ErrKeyNotFound) {
+			return info.ResultMiss
+		}
+	}
+	if c.Writer.Status() >= http.StatusBadRequest {
+		return info.ResultError
+	}
+	return info.ResultOK
+}
+
+// connState учёт открытых соединений
+func (s *Server) connState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		atomic.AddInt64(&s.clients, 1)
+		s.metrics.ClientConnected(metrics.ProtoHTTP)
+	case http.StateClosed, http.StateHijacked:
+		atomic.AddInt64(&s.clients, -1)
+		s.metrics.ClientDisconnected(metrics.ProtoHTTP)
+	}
+}
+
+// logWriter запись в текущий вывод пакета log
+type logWriter struct{}
+
+func (logWriter) Write(p []byte) (int, error) {
+	return log.Writer().Write(p)
+}
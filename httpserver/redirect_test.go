@@ -0,0 +1,69 @@
+package httpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/structs"
+)
+
+func TestServer_RedirectTLS(t *testing.T) {
+	// Узел, которому принадлежит слот ключа
+	node := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Header.Get(headerAsking)))
+	}))
+	defer node.Close()
+	nodeURL, _ := url.Parse(node.URL)
+
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	srv := NewServer("", map[string]string{"admin": "secret"}, storage)
+	srv.SetTLSConfig(&tls.Config{})
+	srv.SetPeerTLSConfig(node.Client().Transport.(*http.Transport).TLSClientConfig)
+	front := httptest.NewServer(srv.Handler())
+	defer front.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+
+	tests := []struct {
+		name         string
+		ask          bool
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{name: "moved", wantCode: http.StatusTemporaryRedirect, wantLocation: node.URL + "/cache/key/a"},
+		{name: "ask", ask: true, wantCode: http.StatusOK, wantBody: "1"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			srv.SetGuard(func(context.Context, string, bool) error {
+				return &structs.RedirectError{Ask: tt.ask, HTTPAddr: nodeURL.Host}
+			})
+			req, _ := http.NewRequest(http.MethodGet, front.URL+"/cache/key/a", nil)
+			req.SetBasicAuth("admin", "secret")
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if resp.StatusCode != tt.wantCode {
+				t.Fatalf("status = %d, want %d: %s", resp.StatusCode, tt.wantCode, body)
+			}
+			if got := resp.Header.Get("Location"); got != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", got, tt.wantLocation)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("body = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
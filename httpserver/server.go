@@ -1,16 +1,34 @@
 package httpserver
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/info"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/slowlog"
 	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tracing"
+	"log"
 	"net/http"
+	"net/http/httputil"
+	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/gin-gonic/gin"
 )
 
+const headerAsking = "X-Asking"
+
 type SetStringBody struct {
 	Value string `json:"value" binding:"required"`
+	// Encoding кодировка значения: пустая или base64
+	Encoding string `json:"encoding"`
 }
 
 type SetTTLBody struct {
@@ -18,38 +36,228 @@ type SetTTLBody struct {
 }
 
 type SetListBody struct {
-	Value []string `json:"value" binding:"required"`
+	Value    []string `json:"value" binding:"required"`
+	Encoding string   `json:"encoding"`
 }
 
 type SetDictionaryBody struct {
-	Value map[string]string `json:"value" binding:"required"`
+	Value    map[string]string `json:"value" binding:"required"`
+	Encoding string            `json:"encoding"`
 }
 
 type Server struct {
-	port     string
-	accounts gin.Accounts
-	storage  structs.Storage
+	port string
+	// users *auth.Store, заменяется во время работы
+	users   atomic.Value
+	storage structs.Storage
+	guard   structs.Guard
+	// authenticators способы аутентификации групп маршрутов
+	authenticators map[string][]Authenticator
+	tlsConfig      *tls.Config
+	peerTransport  http.RoundTripper
+	metrics        *metrics.Metrics
+	info           *info.Info
+	slowlog        *slowlog.Log
+	tiered         structs.TieredStorage
+	inspector      structs.InspectStorage
+	log            *logging.Logger
+	audit          *logging.Audit
+	tracer         *tracing.Tracer
+
+	maxValueSize   int64
+	requestTimeout int64
+	logRequests    int32
+	// clients открытые соединения
+	clients int64
+
+	mu      sync.Mutex
+	srv     *http.Server
+	closing bool
 }
 
-//TODO Вынести таблицу аккаунтов из обьекта Server
 func NewServer(port string, accounts map[string]string, storage structs.Storage) *Server {
-	return &Server{
-		port:     port,
-		accounts: accounts,
-		storage:  storage,
+	s := &Server{
+		port:        port,
+		storage:     storage,
+		logRequests: 1,
+	}
+	if err := s.SetAccounts(accounts); err != nil {
+		// Без пользователей все запросы отклоняются
+		log.Printf("httpserver: %v", err)
+		empty, _ := auth.NewStore(nil)
+		s.SetUsers(empty)
+	}
+	return s
+}
+
+// SetGuard установка проверки, выполняемой перед каждой операцией над ключами
+func (s *Server) SetGuard(guard structs.Guard) {
+	s.guard = guard
+}
+
+// SetMetrics учёт запросов и соединений в метриках и маршрут /metrics. Вызывается до Handler
+func (s *Server) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetInfo маршрут /cache/info со сведениями info и учёт выполненных запросов. Вызывается до Handler
+func (s *Server) SetInfo(i *info.Info) {
+	s.info = i
+}
+
+// SetSlowlog запись медленных запросов в журнал и маршрут /admin/slowlog. Вызывается до Handler
+func (s *Server) SetSlowlog(l *slowlog.Log) {
+	s.slowlog = l
+}
+
+// SetTier маршруты /admin/tier для статистики и закрепления ключей дискового уровня хранилища.
+// Вызывается до Handler
+func (s *Server) SetTier(t structs.TieredStorage) {
+	s.tiered = t
+}
+
+// SetInspector маршрут /cache/inspect/:key со сведениями о представлении значения ключа (кодировка,
+// сжатие, место хранения). Ключи передаются хранилищу как есть, без обёрток. Вызывается до Handler
+func (s *Server) SetInspector(i structs.InspectStorage) {
+	s.inspector = i
+}
+
+// SetLogger журнал запросов в структурированном виде вместо текстового журнала gin. Вызывается до Handler
+func (s *Server) SetLogger(l *logging.Logger) {
+	s.log = l
+}
+
+// SetAudit запись изменяющих запросов (PUT, POST, PATCH, DELETE) в журнал аудита. Вызывается до Handler
+func (s *Server) SetAudit(a *logging.Audit) {
+	s.audit = a
+}
+
+// SetTracer спаны запросов, продолжающие трассировку из заголовка traceparent, и спаны вызовов хранилища.
+// Вызывается до Handler
+func (s *Server) SetTracer(t *tracing.Tracer) {
+	s.tracer = t
+}
+
+// store хранилище для вызовов обработчиков с контекстом запроса
+func (s *Server) store() structs.ContextStorage {
+	storage := structs.WithContext(s.storage)
+	if s.tracer != nil {
+		storage = tracing.Storage(storage)
 	}
+	return storage
+}
+
+// statusClientClosed запрос, клиент которого закрыл соединение до ответа
+const statusClientClosed = 499
+
+// statusError ответ на операцию, прерванную отменой или истечением контекста запроса
+// или запрещённую обёрткой хранилища. Для других ошибок возвращает false
+func statusError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, structs.ErrNoPerm), errors.Is(err, structs.ErrNoAuth),
+		errors.Is(err, structs.ErrWriteDisabled), errors.Is(err, structs.ErrReadOnly):
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": err.Error()},
+		)
+	case errors.Is(err, context.DeadlineExceeded):
+		c.Error(err)
+		c.JSON(
+			http.StatusServiceUnavailable,
+			gin.H{"error": err.Error()},
+		)
+	case errors.Is(err, context.Canceled):
+		c.Error(err)
+		c.AbortWithStatus(statusClientClosed)
+	default:
+		return false
+	}
+	return true
+}
+
+// writeError ответ на ошибку изменяющей операции
+func writeError(c *gin.Context, err error) {
+	if statusError(c, err) {
+		return
+	}
+	c.Error(err)
+	c.JSON(
+		http.StatusInternalServerError,
+		gin.H{"error": err.Error()},
+	)
+}
+
+// Clients количество открытых соединений
+func (s *Server) Clients() int {
+	return int(atomic.LoadInt64(&s.clients))
+}
+
+// SetTLSConfig приём соединений по TLS. Вызывается до Run
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
 }
 
+// SetPeerTLSConfig соединения с другими узлами кластера по TLS при проксировании запросов. Вызывается до Run
+func (s *Server) SetPeerTLSConfig(cfg *tls.Config) {
+	s.peerTransport = &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: cfg}
+}
+
+// Run запуск сервера. После Shutdown возвращает nil
 func (s *Server) Run() error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return nil
+	}
+	srv := &http.Server{Addr: ":" + s.port, Handler: s.Handler(), TLSConfig: s.tlsConfig, ConnState: s.connState}
+	s.srv = srv
+	s.mu.Unlock()
+
+	var err error
+	if s.tlsConfig != nil {
+		log.Printf("listening and serving HTTPS on %s", srv.Addr)
+		// Сертификаты задаёт TLSConfig
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		log.Printf("listening and serving HTTP on %s", srv.Addr)
+		err = srv.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Shutdown остановка сервера: прекращается приём соединений, выполняемые запросы
+// завершаются до истечения ctx
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	srv := s.srv
+	s.mu.Unlock()
+
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
 
-	r := gin.Default()
+// Handler маршруты сервера
+func (s *Server) Handler() *gin.Engine {
+	r := gin.New()
+	// Шаблоны маршрутов для метрик и журналов известны после регистрации всех маршрутов
+	routes := make(map[string]string)
+	r.Use(s.requestID, s.trace(routes), s.logger(routes), s.observe(routes), s.auditTrail(routes), gin.RecoveryWithWriter(logWriter{}))
 
-	// Базовая аутентификация. Можно заменить на OAuth
-	authorized := r.Group("/cache", gin.BasicAuth(s.accounts))
+	// Способы аутентификации задаются SetAuthenticators, по умолчанию - базовая аутентификация
+	authorized := r.Group(GroupCache, s.authenticate(GroupCache), s.limitBody, s.deadline)
 
 	authorized.GET("/keys", s.getKeys)
 	authorized.GET("/key/:key", s.getElement)
 	authorized.GET("/key/:key/:internalKey", s.getInternalElement)
+	if s.inspector != nil {
+		authorized.GET("/inspect/:key", s.inspect)
+	}
 
 	authorized.POST("/set/ttl/:key", s.setTTL)
 
@@ -59,25 +267,167 @@ func (s *Server) Run() error {
 
 	authorized.DELETE("/remove/:key", s.deleteKey)
 
-	return r.Run(":" + s.port)
+	if s.info != nil {
+		authorized.GET("/info", s.requireAdmin, s.getInfo)
+	}
+
+	admin := r.Group(GroupAdmin, s.authenticate(GroupAdmin), s.requireAdmin)
+
+	admin.GET("/users", s.listUsers)
+	admin.GET("/users/:name", s.getUser)
+	admin.PUT("/users/:name", s.putUser)
+	admin.DELETE("/users/:name", s.deleteUser)
+	if s.slowlog != nil {
+		admin.GET("/slowlog", s.getSlowlog)
+		admin.DELETE("/slowlog", s.resetSlowlog)
+	}
+	if s.tiered != nil {
+		admin.GET("/tier", s.getTier)
+		admin.PUT("/tier/pin/:key", s.pinKey)
+		admin.DELETE("/tier/pin/:key", s.unpinKey)
+	}
+
+	if s.metrics != nil {
+		r.GET("/metrics", gin.WrapH(s.metrics.Handler()))
+	}
+	for _, route := range r.Routes() {
+		routes[route.Method+" "+route.Handler] = route.Path
+	}
+	return r
 }
 
-// getKeys получение списка ключей из кеша
+// check проверка возможности выполнить операцию над ключом. При отказе записывает ошибку в ответ
+func (s *Server) check(c *gin.Context, key string, write bool) bool {
+	ctx := c.Request.Context()
+	if write {
+		logging.SetAuditKey(ctx, key)
+	}
+	category := auth.Read
+	if write {
+		category = auth.Write
+	}
+	if err := s.authorize(ctx, category, key); err != nil {
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": err.Error()},
+		)
+		return false
+	}
+	if s.guard == nil {
+		return true
+	}
+	if c.GetHeader(headerAsking) != "" {
+		ctx = structs.WithAsking(ctx)
+	}
+	if err := s.guard(ctx, key, write); err != nil {
+		var redirect *structs.RedirectError
+		if errors.As(err, &redirect) && redirect.HTTPAddr != "" {
+			s.redirect(c, redirect)
+			return false
+		}
+		c.JSON(
+			http.StatusForbidden,
+			gin.H{"error": err.Error()},
+		)
+		return false
+	}
+	return true
+}
+
+// redirect запрос к ключу, который обслуживается другим узлом кластера. При переносе слота
+// клиент перенаправляется, во время миграции запрос проксируется с заголовком X-Asking.
+// Узлы кластера принимают запросы по TLS, если его использует этот сервер
+func (s *Server) redirect(c *gin.Context, redirect *structs.RedirectError) {
+	target := &url.URL{Scheme: "http", Host: redirect.HTTPAddr}
+	if s.tlsConfig != nil {
+		target.Scheme = "https"
+	}
+	if !redirect.Ask {
+		c.Redirect(http.StatusTemporaryRedirect, target.String()+c.Request.URL.RequestURI())
+		return
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	if s.peerTransport != nil {
+		proxy.Transport = s.peerTransport
+	}
+	c.Request.Header.Set(headerAsking, "1")
+	proxy.ServeHTTP(c.Writer, c.Request)
+}
+
+// getKeys получение списка ключей из кеша. С параметрами prefix, start, end и limit - ключи
+// диапазона по возрастанию
 // curl -k -u user:pass http://localhost:8081/cache/keys
+// curl -k -u user:pass "http://localhost:8081/cache/keys?prefix=user:&start=user:100&limit=50"
 func (s *Server) getKeys(c *gin.Context) {
+	if !s.check(c, "", false) {
+		return
+	}
+	r, ranged, err := keyRange(c)
+	if err != nil {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+	var keys []string
+	if ranged {
+		keys, err = structs.KeysRange(c.Request.Context(), s.store(), r)
+	} else {
+		keys, err = s.store().GetKeysContext(c.Request.Context())
+	}
+	if err != nil {
+		writeError(c, err)
+		return
+	}
 	c.JSON(
 		http.StatusOK,
-		gin.H{"keys": s.storage.GetKeys()},
+		gin.H{"keys": keys},
 	)
 }
 
-// getKeys получение элемента из кеша по ключу
+// keyRange диапазон ключей из параметров запроса и задан ли он
+func keyRange(c *gin.Context) (structs.KeyRange, bool, error) {
+	var (
+		r      structs.KeyRange
+		ranged bool
+	)
+	for name, val := range map[string]*string{"prefix": &r.Prefix, "start": &r.Start, "end": &r.End} {
+		if v, ok := c.GetQuery(name); ok {
+			*val, ranged = v, true
+		}
+	}
+	if v, ok := c.GetQuery("limit"); ok {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 0 {
+			return r, false, errors.New("limit: invalid value " + strconv.Quote(v))
+		}
+		r.Limit, ranged = limit, true
+	}
+	return r, ranged, nil
+}
+
+// getKeys получение элемента из кеша по ключу. Строка с Accept: application/octet-stream
+// возвращается побайтово, с encoding=base64 - строки значения в base64
 // curl -k -u user:pass http://localhost:8081/cache/key/<key>
+// curl -k -u user:pass -H 'accept: application/octet-stream' -o value.bin http://localhost:8081/cache/key/<key>
 func (s *Server) getElement(c *gin.Context) {
 	key := c.Param("key")
+	if !s.check(c, key, false) {
+		return
+	}
+	encoding, ok := responseEncoding(c)
+	if !ok {
+		return
+	}
 
-	val, err := s.storage.GetElement(key)
+	val, err := s.store().GetElementContext(c.Request.Context(), key)
 	if err != nil {
+		if statusError(c, err) {
+			return
+		}
+		c.Error(err)
 		c.JSON(
 			http.StatusBadRequest,
 			gin.H{"error": err.Error()}, //TODO Нельзя возвращать внутренние ошибки. Исправить в следующей реализации
@@ -87,10 +437,7 @@ func (s *Server) getElement(c *gin.Context) {
 
 	switch v := val.(type) {
 	case string, []string, map[string]string:
-		c.JSON(
-			http.StatusOK,
-			gin.H{"value": v},
-		)
+		writeValue(c, v, encoding)
 	default:
 		c.JSON(
 			http.StatusBadRequest,
@@ -99,14 +446,67 @@ func (s *Server) getElement(c *gin.Context) {
 	}
 }
 
+// inspect представление значения ключа: тип, кодировка, размер значения и хранимого представления,
+// степень сжатия, место хранения (memory, disk) и закрепление в памяти
+// curl -k -u user:pass http://localhost:8081/cache/inspect/<key>
+func (s *Server) inspect(c *gin.Context) {
+	key := c.Param("key")
+	if !s.check(c, key, false) {
+		return
+	}
+
+	info, err := structs.Inspect(c.Request.Context(), s.inspector, key)
+	if statusError(c, err) {
+		return
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, structs.ErrKeyNotFound) {
+			status = http.StatusNotFound
+		}
+		c.JSON(
+			status,
+			gin.H{"error": err.Error()},
+		)
+		return
+	}
+	tier := "memory"
+	if info.OnDisk {
+		tier = "disk"
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{
+			"type":        strings.ToLower(info.Type.String()),
+			"encoding":    info.Encoding,
+			"size":        info.Size,
+			"stored_size": info.StoredSize,
+			"ratio":       info.Ratio(),
+			"tier":        tier,
+			"pinned":      info.Pinned,
+		},
+	)
+}
+
 // getInternalElement получение внутреннего элемента из списка (по индексу) или словаря (по ключу)
 // curl -k -u user:pass http://localhost:8081/cache/key/<key>/<internal key or index>
 func (s *Server) getInternalElement(c *gin.Context) {
 	key := c.Param("key")
 	internalKey := c.Param("internalKey")
+	if !s.check(c, key, false) {
+		return
+	}
+	encoding, ok := responseEncoding(c)
+	if !ok {
+		return
+	}
 
-	vartype, err := s.storage.GetType(key)
+	vartype, err := s.store().GetTypeContext(c.Request.Context(), key)
 	if err != nil {
+		if statusError(c, err) {
+			return
+		}
+		c.Error(err)
 		c.JSON(
 			http.StatusBadRequest,
 			gin.H{"error": err.Error()},
@@ -124,8 +524,12 @@ func (s *Server) getInternalElement(c *gin.Context) {
 			)
 			return
 		}
-		val, err = s.storage.GetListElement(key, int(index))
+		val, err = s.store().GetListElementContext(c.Request.Context(), key, int(index))
 		if err != nil {
+			if statusError(c, err) {
+				return
+			}
+			c.Error(err)
 			c.JSON(
 				http.StatusBadRequest,
 				gin.H{"error": err.Error()},
@@ -133,8 +537,12 @@ func (s *Server) getInternalElement(c *gin.Context) {
 			return
 		}
 	case structs.Dictionary:
-		val, err = s.storage.GetDictionaryElement(key, internalKey)
+		val, err = s.store().GetDictionaryElementContext(c.Request.Context(), key, internalKey)
 		if err != nil {
+			if statusError(c, err) {
+				return
+			}
+			c.Error(err)
 			c.JSON(
 				http.StatusBadRequest,
 				gin.H{"error": err.Error()},
@@ -149,17 +557,16 @@ func (s *Server) getInternalElement(c *gin.Context) {
 		return
 	}
 
-	c.JSON(
-		http.StatusOK,
-		gin.H{"value": val},
-	)
-	return
+	writeValue(c, val, encoding)
 }
 
 // setTTL установка времени жизни ключа
 // curl -H 'content-type: application/json' -k -u user:pass -d '{ "value": 3000 }' -X PUT http://localhost:8081/cache/set/ttl/<key>
 func (s *Server) setTTL(c *gin.Context) {
 	key := c.Param("key")
+	if !s.check(c, key, true) {
+		return
+	}
 	var value SetTTLBody
 	if err := c.ShouldBindJSON(&value); err != nil {
 		c.JSON(
@@ -168,58 +575,92 @@ func (s *Server) setTTL(c *gin.Context) {
 		)
 		return
 	}
-	s.storage.SetTTL(key, value.Value)
-	return
+	if err := s.store().SetExpiredContext(c.Request.Context(), key, value.Value); err != nil {
+		writeError(c, err)
+	}
 }
 
-// setSting добавление или обновление ключа строки в кеше
+// setSting добавление или обновление ключа строки в кеше. Тело application/octet-stream
+// сохраняется побайтово, в JSON значение может быть закодировано base64
 // curl -H 'content-type: application/json' -k -u user:pass -d '{ "value": "manu" }' -X PUT http://localhost:8081/cache/set/string/<key>
+// curl -H 'content-type: application/json' -k -u user:pass -d '{ "value": "bWFudQ==", "encoding": "base64" }' -X PUT http://localhost:8081/cache/set/string/<key>
+// curl -H 'content-type: application/octet-stream' -k -u user:pass --data-binary @value.bin -X PUT http://localhost:8081/cache/set/string/<key>
 func (s *Server) setString(c *gin.Context) {
 	key := c.Param("key")
-	var value SetStringBody
-	if err := c.ShouldBindJSON(&value); err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{"error": err.Error()},
-		)
+	if !s.check(c, key, true) {
 		return
 	}
-	s.storage.PutOrUpdateString(key, value.Value)
+	var (
+		val string
+		err error
+	)
+	if c.ContentType() == mimeOctetStream {
+		val, err = readRaw(c)
+	} else {
+		var value SetStringBody
+		if err = c.ShouldBindJSON(&value); err == nil {
+			val, err = decodeString(value.Encoding, value.Value)
+		}
+	}
+	if err != nil {
+		bodyError(c, err)
+		return
+	}
+	if _, _, err := s.store().PutOrUpdateStringContext(c.Request.Context(), key, val); err != nil {
+		writeError(c, err)
+	}
 }
 
 // setList добавление или обновление ключа списка в кеше
 // curl -H 'content-type: application/json' -k -u user:pass -d '{ "value": ["manu","suro","jonk"] }' -X PUT http://localhost:8081/cache/set/list/<key>
 func (s *Server) setList(c *gin.Context) {
 	key := c.Param("key")
+	if !s.check(c, key, true) {
+		return
+	}
 	var value SetListBody
-	if err := c.ShouldBindJSON(&value); err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{"error": err.Error()},
-		)
+	err := c.ShouldBindJSON(&value)
+	if err == nil {
+		value.Value, err = decodeList(value.Encoding, value.Value)
+	}
+	if err != nil {
+		bodyError(c, err)
 		return
 	}
-	s.storage.PutOrUpdateList(key, value.Value)
+	if _, _, err := s.store().PutOrUpdateListContext(c.Request.Context(), key, value.Value); err != nil {
+		writeError(c, err)
+	}
 }
 
 // setDictionary добавление или обновление ключа словаря в кеше
 // curl -H 'content-type: application/json' -k -u user:pass -d '{"value": {"k1":"manu","k2":"sol","k3":"vano"} }' -X PUT http://localhost:8081/cache/set/dictionary/<key>
 func (s *Server) setDictionary(c *gin.Context) {
 	key := c.Param("key")
+	if !s.check(c, key, true) {
+		return
+	}
 	var value SetDictionaryBody
-	if err := c.ShouldBindJSON(&value); err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{"error": err.Error()},
-		)
+	err := c.ShouldBindJSON(&value)
+	if err == nil {
+		value.Value, err = decodeDictionary(value.Encoding, value.Value)
+	}
+	if err != nil {
+		bodyError(c, err)
 		return
 	}
-	s.storage.PutOrUpdateDictionary(key, value.Value)
+	if _, _, err := s.store().PutOrUpdateDictionaryContext(c.Request.Context(), key, value.Value); err != nil {
+		writeError(c, err)
+	}
 }
 
 // deleteKey удаление ключа из кеша
 // curl -k -u user:pass -X DELETE http://localhost:8081/cache/remove/<key>
 func (s *Server) deleteKey(c *gin.Context) {
 	key := c.Param("key")
-	s.storage.RemoveElement(key)
+	if !s.check(c, key, true) {
+		return
+	}
+	if err := s.store().RemoveElementContext(c.Request.Context(), key); err != nil {
+		writeError(c, err)
+	}
 }
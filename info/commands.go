@@ -0,0 +1,133 @@
+package info
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Result результат выполнения команды
+type Result int
+
+const (
+	// ResultOK команда выполнена
+	ResultOK Result = iota
+	// ResultMiss команда обратилась к отсутствующему ключу
+	ResultMiss
+	// ResultError команда завершилась ошибкой
+	ResultError
+)
+
+// opsWindow количество секунд, по которым считается среднее число команд в секунду
+const opsWindow = 5
+
+// CommandStat статистика команды с момента запуска
+type CommandStat struct {
+	Calls  uint64 `json:"calls"`
+	Usec   uint64 `json:"usec"`
+	Hits   uint64 `json:"hits"`
+	Misses uint64 `json:"misses"`
+	Failed uint64 `json:"failed"`
+}
+
+// UsecPerCall среднее время выполнения в микросекундах
+func (c CommandStat) UsecPerCall() float64 {
+	if c.Calls == 0 {
+		return 0
+	}
+	return float64(c.Usec) / float64(c.Calls)
+}
+
+// String строка в формате cmdstat_* команды INFO
+func (c CommandStat) String() string {
+	return fmt.Sprintf("calls=%d,usec=%d,usec_per_call=%.2f,hits=%d,misses=%d,failed=%d",
+		c.Calls, c.Usec, c.UsecPerCall(), c.Hits, c.Misses, c.Failed)
+}
+
+// commandCounters счётчики команды, изменяемые без блокировки
+type commandCounters struct {
+	calls, usec, misses, failed uint64
+}
+
+// commands учёт выполненных команд
+type commands struct {
+	// counters *commandCounters по имени команды
+	counters sync.Map
+	ops      [opsWindow + 2]opsBucket
+}
+
+// opsBucket количество команд за секунду sec
+type opsBucket struct {
+	sec int64
+	n   uint64
+}
+
+func (c *commands) record(name string, result Result, d time.Duration, now time.Time) {
+	v, ok := c.counters.Load(name)
+	if !ok {
+		v, _ = c.counters.LoadOrStore(name, new(commandCounters))
+	}
+	counters := v.(*commandCounters)
+	atomic.AddUint64(&counters.calls, 1)
+	atomic.AddUint64(&counters.usec, uint64(d/time.Microsecond))
+	switch result {
+	case ResultMiss:
+		atomic.AddUint64(&counters.misses, 1)
+	case ResultError:
+		atomic.AddUint64(&counters.failed, 1)
+	}
+
+	sec := now.Unix()
+	b := &c.ops[sec%int64(len(c.ops))]
+	if old := atomic.LoadInt64(&b.sec); old != sec && atomic.CompareAndSwapInt64(&b.sec, old, sec) {
+		// Корзина осталась от прошлого круга. Команды, учтённые другими горутинами между
+		// сменой секунды и сбросом, теряются: для оценки нагрузки это допустимо
+		atomic.StoreUint64(&b.n, 0)
+	}
+	atomic.AddUint64(&b.n, 1)
+}
+
+// stats статистика команд по имени
+func (c *commands) stats() map[string]CommandStat {
+	result := make(map[string]CommandStat)
+	c.counters.Range(func(key, value interface{}) bool {
+		counters := value.(*commandCounters)
+		stat := CommandStat{
+			Calls:  atomic.LoadUint64(&counters.calls),
+			Usec:   atomic.LoadUint64(&counters.usec),
+			Misses: atomic.LoadUint64(&counters.misses),
+			Failed: atomic.LoadUint64(&counters.failed),
+		}
+		if stat.Misses+stat.Failed <= stat.Calls {
+			stat.Hits = stat.Calls - stat.Misses - stat.Failed
+		}
+		result[key.(string)] = stat
+		return true
+	})
+	return result
+}
+
+// names имена выполнявшихся команд по алфавиту
+func names(stats map[string]CommandStat) []string {
+	result := make([]string, 0, len(stats))
+	for name := range stats {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// opsPerSec среднее число команд в секунду за opsWindow завершившихся секунд до now
+func (c *commands) opsPerSec(now time.Time) float64 {
+	sec := now.Unix()
+	var total uint64
+	for i := range c.ops {
+		b := &c.ops[i]
+		if s := atomic.LoadInt64(&b.sec); s < sec && s >= sec-opsWindow {
+			total += atomic.LoadUint64(&b.n)
+		}
+	}
+	return float64(total) / opsWindow
+}
@@ -0,0 +1,283 @@
+// Package info сведения о сервере для команды INFO и запроса GET /cache/info
+package info
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Разделы сведений в порядке вывода
+const (
+	SectionServer       = "server"
+	SectionClients      = "clients"
+	SectionMemory       = "memory"
+	SectionPersistence  = "persistence"
+	SectionStats        = "stats"
+	SectionReplication  = "replication"
+	SectionCommandStats = "commandstats"
+	SectionKeyspace     = "keyspace"
+)
+
+var sectionOrder = []string{
+	SectionServer, SectionClients, SectionMemory, SectionPersistence,
+	SectionStats, SectionReplication, SectionCommandStats, SectionKeyspace,
+}
+
+// Field значение в разделе сведений
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Section раздел сведений
+type Section struct {
+	Name   string
+	Fields []Field
+}
+
+// SectionFunc поля раздела на момент запроса
+type SectionFunc func() []Field
+
+// Info сведения о сервере. Разделы собираются из источников, зарегистрированных Register,
+// статистика команд - из Record. Record для nil *Info ничего не делает
+type Info struct {
+	start time.Time
+	now   func() time.Time
+
+	commands commands
+
+	mu      sync.RWMutex
+	sources map[string][]SectionFunc
+}
+
+// New сведения о процессе, памяти Go и выполненных командах. Сведения хранилища добавляет SetStorage,
+// остальные разделы - Register
+func New() *Info {
+	i := &Info{
+		start:   time.Now(),
+		now:     time.Now,
+		sources: make(map[string][]SectionFunc),
+	}
+	i.Register(SectionServer, i.server)
+	i.Register(SectionMemory, memory)
+	i.Register(SectionStats, i.stats)
+	i.Register(SectionCommandStats, i.commandStats)
+	return i
+}
+
+// Register добавление полей раздела section. Поля нескольких источников раздела выводятся
+// в порядке регистрации. Вызывается до запуска серверов
+func (i *Info) Register(section string, fn SectionFunc) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.sources[section] = append(i.sources[section], fn)
+}
+
+// SetStorage статистика хранилища в разделах memory, stats и keyspace, если оно реализует
+// structs.StatsStorage. Статистика дискового уровня structs.TieredStorage выводится в разделах
+// memory и stats, пока уровень используется
+func (i *Info) SetStorage(storage structs.Storage) {
+	if t, ok := storage.(structs.TieredStorage); ok {
+		i.setTier(t)
+	}
+	s, ok := storage.(structs.StatsStorage)
+	if !ok {
+		return
+	}
+	i.Register(SectionMemory, func() []Field {
+		return []Field{{"used_memory_dataset", s.Stats().MemoryBytes}}
+	})
+	i.Register(SectionStats, func() []Field {
+		stats := s.Stats()
+		return []Field{
+			{"keyspace_hits", stats.KeyspaceHits},
+			{"keyspace_misses", stats.KeyspaceMisses},
+			{"expired_keys", stats.ExpiredKeys},
+			{"evicted_keys", stats.EvictedKeys},
+		}
+	})
+	i.Register(SectionKeyspace, func() []Field {
+		stats := s.Stats()
+		var keys int
+		for _, n := range stats.Keys {
+			keys += n
+		}
+		fields := []Field{{"keys", keys}, {"expires", stats.KeysWithTTL}}
+		for _, t := range []structs.ValueType{structs.String, structs.List, structs.Dictionary, structs.Stream} {
+			fields = append(fields, Field{"keys_" + strings.ToLower(t.String()), stats.Keys[t]})
+		}
+		return fields
+	})
+}
+
+func (i *Info) setTier(t structs.TieredStorage) {
+	i.Register(SectionMemory, func() []Field {
+		stats, ok := t.TierStats()
+		if !ok {
+			return nil
+		}
+		return []Field{
+			{"tier_memory_keys", stats.MemoryKeys},
+			{"tier_memory_bytes", stats.MemoryBytes},
+			{"tier_max_memory", stats.MaxMemory},
+			{"tier_disk_keys", stats.DiskKeys},
+			{"tier_disk_bytes", stats.DiskBytes},
+			{"tier_pinned_keys", stats.PinnedKeys},
+		}
+	})
+	i.Register(SectionStats, func() []Field {
+		stats, ok := t.TierStats()
+		if !ok {
+			return nil
+		}
+		return []Field{{"tier_spills", stats.Spills}, {"tier_faults", stats.Faults}}
+	})
+}
+
+// Record учёт выполненной команды: имя, результат и длительность
+func (i *Info) Record(command string, result Result, d time.Duration) {
+	if i == nil {
+		return
+	}
+	i.commands.record(command, result, d, i.now())
+}
+
+// Sections разделы с именем name без учёта регистра. Пустое имя, default, all и everything - все разделы.
+// Для неизвестного раздела возвращает пустой список
+func (i *Info) Sections(name string) []Section {
+	name = strings.ToLower(name)
+	all := name == "" || name == "all" || name == "everything" || name == "default"
+
+	i.mu.RLock()
+	var names []string
+	for _, section := range sectionOrder {
+		if _, ok := i.sources[section]; ok && (all || section == name) {
+			names = append(names, section)
+		}
+	}
+	var other []string
+	for section := range i.sources {
+		if !known(section) && (all || section == name) {
+			other = append(other, section)
+		}
+	}
+	sort.Strings(other)
+	names = append(names, other...)
+	sources := make([][]SectionFunc, len(names))
+	for n, section := range names {
+		sources[n] = i.sources[section]
+	}
+	i.mu.RUnlock()
+
+	sections := make([]Section, 0, len(names))
+	for n, section := range names {
+		var fields []Field
+		for _, fn := range sources[n] {
+			fields = append(fields, fn()...)
+		}
+		sections = append(sections, Section{Name: section, Fields: fields})
+	}
+	return sections
+}
+
+func known(section string) bool {
+	for _, name := range sectionOrder {
+		if name == section {
+			return true
+		}
+	}
+	return false
+}
+
+// Text разделы в текстовом формате команды INFO Redis
+func Text(sections []Section) string {
+	var b strings.Builder
+	for n, section := range sections {
+		if n > 0 {
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "# %s\r\n", strings.Title(section.Name))
+		for _, field := range section.Fields {
+			fmt.Fprintf(&b, "%s:%v\r\n", field.Name, field.Value)
+		}
+	}
+	return b.String()
+}
+
+// Map разделы в виде вложенных словарей для ответа в формате JSON
+func Map(sections []Section) map[string]map[string]interface{} {
+	result := make(map[string]map[string]interface{}, len(sections))
+	for _, section := range sections {
+		fields := make(map[string]interface{}, len(section.Fields))
+		for _, field := range section.Fields {
+			fields[field.Name] = field.Value
+		}
+		result[section.Name] = fields
+	}
+	return result
+}
+
+// ServeRedeo обработка команды info [section]
+func (i *Info) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() > 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	var name string
+	if c.ArgN() == 1 {
+		name = c.Arg(0).String()
+	}
+	w.AppendBulkString(Text(i.Sections(name)))
+}
+
+func (i *Info) server() []Field {
+	uptime := i.now().Sub(i.start)
+	return []Field{
+		{"go_version", runtime.Version()},
+		{"os", runtime.GOOS},
+		{"arch", runtime.GOARCH},
+		{"process_id", os.Getpid()},
+		{"uptime_in_seconds", int64(uptime / time.Second)},
+		{"uptime_in_days", int64(uptime / (24 * time.Hour))},
+	}
+}
+
+func memory() []Field {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return []Field{
+		{"used_memory", m.HeapAlloc},
+		{"used_memory_sys", m.Sys},
+		{"heap_objects", m.HeapObjects},
+		{"gc_runs", m.NumGC},
+	}
+}
+
+func (i *Info) stats() []Field {
+	var total uint64
+	for _, stat := range i.commands.stats() {
+		total += stat.Calls
+	}
+	return []Field{
+		{"total_commands_processed", total},
+		{"instantaneous_ops_per_sec", i.commands.opsPerSec(i.now())},
+	}
+}
+
+func (i *Info) commandStats() []Field {
+	stats := i.commands.stats()
+	fields := make([]Field, 0, len(stats))
+	for _, name := range names(stats) {
+		fields = append(fields, Field{"cmdstat_" + name, stats[name]})
+	}
+	return fields
+}
@@ -0,0 +1,166 @@
+package info
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo/redeotest"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+// field значение поля name раздела section, nil - поля нет
+func field(sections []Section, section, name string) interface{} {
+	for _, s := range sections {
+		if s.Name != section {
+			continue
+		}
+		for _, f := range s.Fields {
+			if f.Name == name {
+				return f.Value
+			}
+		}
+	}
+	return nil
+}
+
+func TestInfo_Commands(t *testing.T) {
+	now := time.Unix(1000, 0)
+	i := New()
+	i.now = func() time.Time { return now }
+
+	// 10 команд в секунду 995, 5 - в 999 и ещё 3 в текущей секунде, которая в среднее не входит
+	for n := 0; n < 10; n++ {
+		i.commands.record("set", ResultOK, 3*time.Microsecond, time.Unix(995, 0))
+	}
+	now = time.Unix(999, 0)
+	i.Record("key", ResultOK, time.Microsecond)
+	i.Record("key", ResultMiss, time.Microsecond)
+	i.Record("key", ResultMiss, time.Microsecond)
+	i.Record("key", ResultError, time.Microsecond)
+	i.Record("type", ResultOK, time.Microsecond)
+	now = time.Unix(1000, 0)
+	for n := 0; n < 3; n++ {
+		i.Record("ping", ResultOK, 0)
+	}
+
+	sections := i.Sections("")
+	tests := []struct {
+		section string
+		name    string
+		want    interface{}
+	}{
+		{section: SectionStats, name: "total_commands_processed", want: uint64(18)},
+		{section: SectionStats, name: "instantaneous_ops_per_sec", want: float64(15) / 5},
+		{section: SectionCommandStats, name: "cmdstat_key", want: CommandStat{Calls: 4, Usec: 4, Hits: 1, Misses: 2, Failed: 1}},
+		{section: SectionCommandStats, name: "cmdstat_set", want: CommandStat{Calls: 10, Usec: 30, Hits: 10}},
+		{section: SectionCommandStats, name: "cmdstat_ping", want: CommandStat{Calls: 3, Hits: 3}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.section+" "+tt.name, func(t *testing.T) {
+			if got := field(sections, tt.section, tt.name); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	// Через opsWindow секунд без команд среднее обнуляется
+	now = time.Unix(1010, 0)
+	if got := field(i.Sections(SectionStats), SectionStats, "instantaneous_ops_per_sec"); got != float64(0) {
+		t.Errorf("instantaneous_ops_per_sec = %v after a pause, want 0", got)
+	}
+	if got := (CommandStat{Calls: 4, Usec: 10, Hits: 4}).String(); got != "calls=4,usec=10,usec_per_call=2.50,hits=4,misses=0,failed=0" {
+		t.Errorf("CommandStat.String() = %q", got)
+	}
+}
+
+func TestInfo_Sections(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	storage.PutOrUpdateString("a", "1")
+	storage.PutOrUpdateList("list", []string{"x"})
+	storage.SetExpired("list", 60000)
+	storage.GetElement("a")
+	storage.GetElement("missing")
+
+	i := New()
+	i.SetStorage(storage)
+	i.Register(SectionReplication, func() []Field {
+		return []Field{{"role", "master"}}
+	})
+	i.Register("custom", func() []Field {
+		return []Field{{"answer", 42}}
+	})
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{name: "", want: []string{"server", "memory", "stats", "replication", "commandstats", "keyspace", "custom"}},
+		{name: "everything", want: []string{"server", "memory", "stats", "replication", "commandstats", "keyspace", "custom"}},
+		{name: "KeySpace", want: []string{"keyspace"}},
+		{name: "custom", want: []string{"custom"}},
+		{name: "unknown", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing Sections("+tt.name+")", func(t *testing.T) {
+			got := []string{}
+			for _, section := range i.Sections(tt.name) {
+				got = append(got, section.Name)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Sections() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	sections := i.Sections("")
+	fields := map[string]interface{}{
+		"keys":            2,
+		"expires":         1,
+		"keys_string":     1,
+		"keys_list":       1,
+		"keys_dictionary": 0,
+		"keyspace_hits":   uint64(1),
+		"keyspace_misses": uint64(1),
+	}
+	for name, want := range fields {
+		section := SectionKeyspace
+		if strings.HasPrefix(name, "keyspace_") {
+			section = SectionStats
+		}
+		if got := field(sections, section, name); got != want {
+			t.Errorf("%s = %#v, want %#v", name, got, want)
+		}
+	}
+
+	t.Run("Testing info command", func(t *testing.T) {
+		w := redeotest.NewRecorder()
+		i.ServeRedeo(w, resp.NewCommand("info", resp.CommandArgument("keyspace")))
+		got, err := w.Response()
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "# Keyspace\r\nkeys:2\r\nexpires:1\r\nkeys_string:1\r\nkeys_list:1\r\nkeys_dictionary:0\r\nkeys_stream:0\r\n"
+		if got != want {
+			t.Errorf("info keyspace = %q, want %q", got, want)
+		}
+
+		w = redeotest.NewRecorder()
+		i.ServeRedeo(w, resp.NewCommand("info", resp.CommandArgument("a"), resp.CommandArgument("b")))
+		if got, _ := w.Response(); !reflect.DeepEqual(got, redeotest.ErrorResponse("ERR wrong number of arguments for 'info' command")) {
+			t.Errorf("info with two arguments = %#v", got)
+		}
+	})
+
+	if got := Map(i.Sections("custom")); !reflect.DeepEqual(got, map[string]map[string]interface{}{"custom": {"answer": 42}}) {
+		t.Errorf("Map() = %v", got)
+	}
+}
+
+func TestInfo_RecordNil(t *testing.T) {
+	var i *Info
+	i.Record("ping", ResultOK, time.Millisecond)
+}
@@ -0,0 +1,144 @@
+package info_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/info"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestInfo_Servers(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	i := info.New()
+	i.SetStorage(storage)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetInfo(i)
+	i.Register(info.SectionClients, func() []info.Field {
+		return []info.Field{{Name: "tcp_clients", Value: tcp.Clients()}}
+	})
+	go tcp.Serve(lis)
+	cn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	r := bufio.NewReader(cn)
+	for _, cmd := range []string{"set string a \"1\"", "key a", "key missing", "key", "info commandstats"} {
+		fmt.Fprintf(cn, "%s\r\n", cmd)
+	}
+	// Ответ на info - вторая bulk строка после значения ключа a, ответы остальных команд пропускаются
+	var header string
+	for bulks := 0; bulks < 2; {
+		if header, err = r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(header, "$") {
+			bulks++
+		}
+	}
+	var size int
+	if _, err := fmt.Sscanf(header, "$%d\r\n", &size); err != nil {
+		t.Fatalf("info response %q: %v", header, err)
+	}
+	body := make([]byte, size+2)
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"# Commandstats\r\n",
+		"cmdstat_key:calls=3,",
+		"hits=1,misses=1,failed=1\r\n",
+		"cmdstat_set:calls=1,",
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("info commandstats has no %q:\n%s", want, body)
+		}
+	}
+
+	srv := httpserver.NewServer("", map[string]string{"user": "pass"}, storage)
+	srv.SetInfo(i)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	get := func(path string) *http.Response {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.SetBasicAuth("user", "pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for _, path := range []string{"/cache/key/a", "/cache/key/b", "/cache/key/a/0"} {
+		get(path).Body.Close()
+	}
+
+	resp := get("/cache/info")
+	defer resp.Body.Close()
+	var got struct {
+		Clients      map[string]int              `json:"clients"`
+		Stats        map[string]float64          `json:"stats"`
+		CommandStats map[string]info.CommandStat `json:"commandstats"`
+		Keyspace     map[string]int              `json:"keyspace"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{name: "tcp clients", got: got.Clients["tcp_clients"], want: 1},
+		{name: "string keys", got: got.Keyspace["keys_string"], want: 1},
+		// key a, key missing, GET /cache/key/a и GET /cache/key/b. Проверка типа в GET /cache/key/a/0 не учитывается
+		{name: "keyspace hits", got: got.Stats["keyspace_hits"], want: float64(2)},
+		{name: "keyspace misses", got: got.Stats["keyspace_misses"], want: float64(2)},
+		{name: "total commands", got: got.Stats["total_commands_processed"], want: float64(8)},
+		{name: "http miss", got: got.CommandStats["cmdstat_http_get_cache_key_key"], want: info.CommandStat{Calls: 2, Hits: 1, Misses: 1}},
+		{name: "http error", got: got.CommandStats["cmdstat_http_get_cache_key_key_internalkey"].Failed, want: uint64(1)},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			if stat, ok := tt.got.(info.CommandStat); ok {
+				stat.Usec = 0
+				tt.got = stat
+			}
+			if tt.got != tt.want {
+				t.Errorf("got %#v, want %#v", tt.got, tt.want)
+			}
+		})
+	}
+
+	resp = get("/cache/info?section=keyspace")
+	defer resp.Body.Close()
+	var keyspace map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&keyspace); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := keyspace["keyspace"]; !ok || len(keyspace) != 1 {
+		t.Errorf("GET /cache/info?section=keyspace = %v", keyspace)
+	}
+}
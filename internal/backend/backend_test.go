@@ -0,0 +1,57 @@
+package backend
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLockContext(t *testing.T) {
+	tests := []struct {
+		name  string
+		write bool
+	}{
+		{name: "write", write: true},
+		{name: "read", write: false},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			var mu sync.RWMutex
+			mu.Lock()
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := LockContext(ctx, &mu, tt.write, nil); err != context.DeadlineExceeded {
+				t.Fatalf("LockContext() error = %v, want %v", err, context.DeadlineExceeded)
+			}
+
+			// Блокировка, полученная после отмены, освобождается
+			mu.Unlock()
+			if err := LockContext(context.Background(), &mu, true, nil); err != nil {
+				t.Fatalf("LockContext() error = %v", err)
+			}
+			mu.Unlock()
+		})
+	}
+}
+
+func TestJanitor(t *testing.T) {
+	var calls int32
+	j := RunJanitor(time.Hour, func() { atomic.AddInt32(&calls, 1) })
+	j.SetInterval(time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&calls) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("deleteExpired was not called after SetInterval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	j.Stop()
+	j.Stop()
+	j.SetInterval(time.Millisecond)
+
+	var nilJanitor *Janitor
+	nilJanitor.SetInterval(time.Second)
+	nilJanitor.Stop()
+}
@@ -0,0 +1,59 @@
+package backend
+
+import (
+	"sync"
+	"time"
+)
+
+// Janitor периодическое удаление просроченных ключей. Методы nil-значения ничего не делают
+type Janitor struct {
+	interval chan time.Duration
+	stop     chan struct{}
+	once     sync.Once
+}
+
+// RunJanitor запуск вызова deleteExpired с периодом d
+func RunJanitor(d time.Duration, deleteExpired func()) *Janitor {
+	j := &Janitor{
+		interval: make(chan time.Duration),
+		stop:     make(chan struct{}),
+	}
+	go j.run(d, deleteExpired)
+	return j
+}
+
+func (j *Janitor) run(d time.Duration, deleteExpired func()) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			deleteExpired()
+		case d := <-j.interval:
+			ticker.Reset(d)
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+// SetInterval изменение периода. Непозитивный период и вызов после Stop игнорируются
+func (j *Janitor) SetInterval(d time.Duration) {
+	if j == nil || d <= 0 {
+		return
+	}
+	select {
+	case j.interval <- d:
+	case <-j.stop:
+	}
+}
+
+// Stop остановка. Повторные вызовы ничего не делают
+func (j *Janitor) Stop() {
+	if j == nil {
+		return
+	}
+	j.once.Do(func() {
+		close(j.stop)
+	})
+}
@@ -0,0 +1,50 @@
+// Package backend общие части хранилищ mapbased, btree и lsm: блокировка с отменой по контексту
+// и фоновое удаление просроченных ключей
+package backend
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// LockContext блокировка mu на запись (write) или чтение, ожидание которой прерывается отменой ctx.
+// Время ожидания передаётся observer (nil - без событий) и получателю из контекста (structs.WithLockWait)
+func LockContext(ctx context.Context, mu *sync.RWMutex, write bool, observer structs.StorageObserver) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	lock, unlock, try := mu.RLock, mu.RUnlock, mu.TryRLock
+	if write {
+		lock, unlock, try = mu.Lock, mu.Unlock, mu.TryLock
+	}
+
+	start := time.Now()
+	if done := ctx.Done(); done == nil {
+		lock()
+	} else if !try() {
+		acquired := make(chan struct{})
+		go func() {
+			lock()
+			close(acquired)
+		}()
+		select {
+		case <-acquired:
+		case <-done:
+			// Блокировка, полученная после отмены, сразу освобождается
+			go func() {
+				<-acquired
+				unlock()
+			}()
+			return ctx.Err()
+		}
+	}
+	wait := time.Since(start)
+	if observer != nil {
+		observer.LockWait(wait, write)
+	}
+	structs.ReportLockWait(ctx, wait)
+	return nil
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Протоколы записей аудита
+const (
+	ProtoHTTP = "http"
+	ProtoTCP  = "tcp"
+)
+
+// Record запись аудита изменяющей операции
+type Record struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id,omitempty"`
+	Proto     string    `json:"proto"`
+	// User пользователь, Client адрес клиента
+	User   string `json:"user"`
+	Client string `json:"client"`
+	// Op команда TCP или метод и маршрут HTTP, Key ключ (имя пользователя, параметр настроек) операции
+	Op  string `json:"op"`
+	Key string `json:"key,omitempty"`
+	OK  bool   `json:"ok"`
+	// Error причина отказа или ошибки
+	Error string `json:"error,omitempty"`
+}
+
+// Audit журнал аудита: каждая запись - строка JSON. Записи в nil *Audit ничего не делают
+type Audit struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewAudit журнал аудита в out, например RotatingFile
+func NewAudit(out io.Writer) *Audit {
+	return &Audit{out: out}
+}
+
+// Log запись операции. Время по умолчанию - текущее
+func (a *Audit) Log(r Record) error {
+	if a == nil {
+		return nil
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.out.Write(data)
+	return err
+}
@@ -0,0 +1,85 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"sync/atomic"
+)
+
+// HeaderRequestID заголовок HTTP с идентификатором запроса
+const HeaderRequestID = "X-Request-ID"
+
+// maxRequestIDLen наибольшая длина идентификатора, принимаемого от клиента
+const maxRequestIDLen = 64
+
+var (
+	// requestPrefix случайная часть идентификаторов запросов процесса
+	requestPrefix = func() string {
+		b := make([]byte, 6)
+		rand.Read(b)
+		return hex.EncodeToString(b)
+	}()
+	requestCounter uint64
+)
+
+// NewRequestID уникальный идентификатор запроса: случайный префикс процесса и номер запроса
+func NewRequestID() string {
+	return requestPrefix + "-" + strconv.FormatUint(atomic.AddUint64(&requestCounter, 1), 36)
+}
+
+// ValidRequestID идентификатор, полученный от клиента, можно использовать: не длиннее 64 символов
+// из букв, цифр и знаков -_.:
+func ValidRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLen {
+		return false
+	}
+	for _, r := range id {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '-' || r == '_' || r == '.' || r == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+type requestIDKey struct{}
+
+// WithRequestID контекст запроса с идентификатором id
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestID идентификатор запроса из контекста, пустой - идентификатора нет
+func RequestID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+type auditKeyKey struct{}
+
+// WithAuditKey контекст изменяющей операции, в который проверка доступа записывает ключ операции
+func WithAuditKey(ctx context.Context) context.Context {
+	return context.WithValue(ctx, auditKeyKey{}, new(string))
+}
+
+// SetAuditKey запись ключа операции, если контекст создан WithAuditKey
+func SetAuditKey(ctx context.Context, key string) {
+	if p, ok := ctx.Value(auditKeyKey{}).(*string); ok {
+		*p = key
+	}
+}
+
+// AuditKey ключ операции, записанный SetAuditKey
+func AuditKey(ctx context.Context) string {
+	if p, ok := ctx.Value(auditKeyKey{}).(*string); ok {
+		return *p
+	}
+	return ""
+}
@@ -0,0 +1,189 @@
+// Package logging структурированный журнал сервера с уровнями и идентификаторами запросов,
+// а также журнал аудита изменяющих операций
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Level уровень записи журнала
+type Level int32
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int32(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel уровень по имени: debug, info, warn, error
+func ParseLevel(name string) (Level, error) {
+	for i, level := range levelNames {
+		if strings.ToLower(name) == level {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown level %q, want debug, info, warn or error", name)
+}
+
+// Форматы записей
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Logger журнал, каждая запись которого - одна строка JSON (или текста) со временем, уровнем,
+// сообщением, идентификатором запроса из контекста и полями. Методы безопасны для одновременного вызова
+type Logger struct {
+	level int32
+	text  int32
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// New журнал в out в формате JSON, записи ниже level отбрасываются
+func New(out io.Writer, level Level) *Logger {
+	return &Logger{out: out, level: int32(level)}
+}
+
+// SetOutput замена вывода. Может вызываться во время работы
+func (l *Logger) SetOutput(out io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = out
+}
+
+// SetLevel минимальный уровень записей. Может вызываться во время работы
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.level, int32(level))
+}
+
+// SetFormat формат записей FormatJSON или FormatText. Может вызываться во время работы
+func (l *Logger) SetFormat(format string) {
+	var text int32
+	if format == FormatText {
+		text = 1
+	}
+	atomic.StoreInt32(&l.text, text)
+}
+
+// Enabled записываются ли записи уровня level. Для nil *Logger - нет
+func (l *Logger) Enabled(level Level) bool {
+	return l != nil && int32(level) >= atomic.LoadInt32(&l.level)
+}
+
+// Log запись сообщения msg с полями kv - чередующимися именами и значениями
+func (l *Logger) Log(ctx context.Context, level Level, msg string, kv ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	id := RequestID(ctx)
+
+	var b bytes.Buffer
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	if atomic.LoadInt32(&l.text) == 1 {
+		fmt.Fprintf(&b, "%s %s %s", now, strings.ToUpper(level.String()), msg)
+		if id != "" {
+			fmt.Fprintf(&b, " request_id=%s", id)
+		}
+		for i := 0; i < len(kv); i += 2 {
+			fmt.Fprintf(&b, " %v=%v", kv[i], value(kv, i+1))
+		}
+	} else {
+		b.WriteString(`{"time":`)
+		writeJSON(&b, now)
+		b.WriteString(`,"level":`)
+		writeJSON(&b, level.String())
+		b.WriteString(`,"msg":`)
+		writeJSON(&b, msg)
+		if id != "" {
+			b.WriteString(`,"request_id":`)
+			writeJSON(&b, id)
+		}
+		for i := 0; i < len(kv); i += 2 {
+			b.WriteByte(',')
+			writeJSON(&b, fmt.Sprint(kv[i]))
+			b.WriteByte(':')
+			writeJSON(&b, value(kv, i+1))
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(b.Bytes())
+}
+
+// value значение поля с индексом i, для нечётного числа аргументов - пустое
+func value(kv []interface{}, i int) interface{} {
+	if i >= len(kv) {
+		return ""
+	}
+	if err, ok := kv[i].(error); ok {
+		return err.Error()
+	}
+	if d, ok := kv[i].(time.Duration); ok {
+		return d.String()
+	}
+	return kv[i]
+}
+
+// writeJSON значение в формате JSON, при ошибке кодирования - его строковое представление
+func writeJSON(b *bytes.Buffer, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
+func (l *Logger) Debug(ctx context.Context, msg string, kv ...interface{}) {
+	l.Log(ctx, LevelDebug, msg, kv...)
+}
+
+func (l *Logger) Info(ctx context.Context, msg string, kv ...interface{}) {
+	l.Log(ctx, LevelInfo, msg, kv...)
+}
+
+func (l *Logger) Warn(ctx context.Context, msg string, kv ...interface{}) {
+	l.Log(ctx, LevelWarn, msg, kv...)
+}
+
+func (l *Logger) Error(ctx context.Context, msg string, kv ...interface{}) {
+	l.Log(ctx, LevelError, msg, kv...)
+}
+
+// Writer вывод для стандартного пакета log: каждая запись становится сообщением уровня level.
+// Пакет log настраивается без префикса времени: время добавляет журнал
+func (l *Logger) Writer(level Level) io.Writer {
+	return stdWriter{l: l, level: level}
+}
+
+type stdWriter struct {
+	l     *Logger
+	level Level
+}
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	w.l.Log(context.Background(), w.level, strings.TrimRight(string(p), "\r\n"))
+	return len(p), nil
+}
@@ -0,0 +1,194 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := New(&buf, LevelInfo)
+	ctx := WithRequestID(context.Background(), "req-1")
+
+	l.Debug(ctx, "hidden")
+	l.Info(ctx, "request", "status", 200, "duration", 1500*time.Microsecond, "error", errors.New("boom"), "odd")
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%q is not a JSON line: %v", buf.String(), err)
+	}
+	want := map[string]interface{}{
+		"level":      "info",
+		"msg":        "request",
+		"request_id": "req-1",
+		"status":     float64(200),
+		"duration":   "1.5ms",
+		"error":      "boom",
+		"odd":        "",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v, want %v", k, got[k], v)
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, got["time"].(string)); err != nil {
+		t.Errorf("time: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		setup func()
+		log   func()
+		want  string
+	}{
+		{
+			name:  "level filtering",
+			setup: func() { l.SetLevel(LevelError) },
+			log:   func() { l.Warn(ctx, "hidden") },
+			want:  "",
+		},
+		{
+			name:  "text format",
+			setup: func() { l.SetLevel(LevelDebug); l.SetFormat(FormatText) },
+			log:   func() { l.Debug(context.Background(), "command", "command", "set") },
+			want:  " DEBUG command command=set\n",
+		},
+		{
+			name:  "std log bridge",
+			setup: func() { l.SetFormat(FormatJSON) },
+			log:   func() { log.New(l.Writer(LevelWarn), "", 0).Println("listening") },
+			want:  `,"level":"warn","msg":"listening"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			buf.Reset()
+			tt.setup()
+			tt.log()
+			if !strings.HasSuffix(buf.String(), tt.want) || (tt.want == "") != (buf.Len() == 0) {
+				t.Errorf("got %q, want suffix %q", buf.String(), tt.want)
+			}
+		})
+	}
+
+	var nilLogger *Logger
+	nilLogger.Error(ctx, "ignored")
+}
+
+func TestValidRequestID(t *testing.T) {
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{id: "5f2a9c01b3e4-1a", want: true},
+		{id: "trace:span_1.2", want: true},
+		{id: "", want: false},
+		{id: "with space", want: false},
+		{id: "line\nbreak", want: false},
+		{id: strings.Repeat("a", 65), want: false},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.id, func(t *testing.T) {
+			if got := ValidRequestID(tt.id); got != tt.want {
+				t.Errorf("ValidRequestID(%q) = %v, want %v", tt.id, got, tt.want)
+			}
+		})
+	}
+	if a, b := NewRequestID(), NewRequestID(); a == b || !ValidRequestID(a) {
+		t.Errorf("NewRequestID() = %q, %q", a, b)
+	}
+}
+
+func TestAuditKey(t *testing.T) {
+	SetAuditKey(context.Background(), "ignored")
+	if key := AuditKey(context.Background()); key != "" {
+		t.Errorf("AuditKey() without WithAuditKey = %q", key)
+	}
+	ctx := WithAuditKey(context.Background())
+	SetAuditKey(ctx, "a")
+	if key := AuditKey(ctx); key != "a" {
+		t.Errorf("AuditKey() = %q, want a", key)
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gokv-audit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "audit.log")
+
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: path, want: "fourth\n"},
+		{file: path + ".1", want: "third\n"},
+		{file: path + ".2", want: "second\n"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+filepath.Base(tt.file), func(t *testing.T) {
+			data, err := ioutil.ReadFile(tt.file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(data) != tt.want {
+				t.Errorf("content = %q, want %q", data, tt.want)
+			}
+		})
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("backup beyond max_backups exists: %v", err)
+	}
+	if _, err := f.Write([]byte("closed\n")); err == nil {
+		t.Error("write to closed file succeeded")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	var buf bytes.Buffer
+	a := NewAudit(&buf)
+	err := a.Log(Record{
+		Time:  time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC),
+		Proto: ProtoTCP,
+		User:  "admin",
+		Op:    "set",
+		Key:   "a",
+		OK:    true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"time":"2020-01-01T10:00:00Z","proto":"tcp","user":"admin","client":"","op":"set","key":"a","ok":true}` + "\n"
+	if buf.String() != want {
+		t.Errorf("got %s, want %s", buf.String(), want)
+	}
+
+	var nilAudit *Audit
+	if err := nilAudit.Log(Record{}); err != nil {
+		t.Errorf("nil audit: %v", err)
+	}
+}
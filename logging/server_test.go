@@ -0,0 +1,184 @@
+package logging_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// buffer вывод, безопасный для записи из обработчиков соединений
+type buffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// records записи аудита
+func (b *buffer) records(t *testing.T) []logging.Record {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var records []logging.Record
+	dec := json.NewDecoder(bytes.NewReader(b.buf.Bytes()))
+	for dec.More() {
+		var r logging.Record
+		if err := dec.Decode(&r); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+func TestAudit_TCP(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	users, err := auth.FromAccounts(map[string]string{"admin": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out buffer
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetUsers(users)
+	tcp.SetAudit(logging.NewAudit(&out))
+	go tcp.Serve(lis)
+	cn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	r := bufio.NewReader(cn)
+	for _, cmd := range []string{"auth admin secret", "set string a hello", "type a", "remove a", "remove"} {
+		fmt.Fprintf(cn, "%s\r\n", cmd)
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	records := out.records(t)
+	tests := []struct {
+		name string
+		op   string
+		key  string
+		ok   bool
+	}{
+		{name: "set", op: "set", key: "a", ok: true},
+		{name: "remove", op: "remove", key: "a", ok: true},
+		{name: "failed remove", op: "remove", key: "", ok: false},
+	}
+	if len(records) != len(tests) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(tests), records)
+	}
+	for i, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			got := records[i]
+			if got.Proto != logging.ProtoTCP || got.User != "admin" || got.Op != tt.op || got.Key != tt.key || got.OK != tt.ok {
+				t.Errorf("record = %+v, want op %q, key %q, ok %v", got, tt.op, tt.key, tt.ok)
+			}
+			if got.RequestID == "" || got.Client == "" || got.OK == (got.Error != "") {
+				t.Errorf("record = %+v", got)
+			}
+		})
+	}
+}
+
+func TestAudit_HTTP(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	users, err := auth.FromAccounts(map[string]string{"admin": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out, log buffer
+
+	srv := httpserver.NewServer("", nil, storage)
+	srv.SetUsers(users)
+	srv.SetLogger(logging.New(&log, logging.LevelInfo))
+	srv.SetAudit(logging.NewAudit(&out))
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	do := func(method, path, body, id string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, strings.NewReader(body))
+		req.SetBasicAuth("admin", "secret")
+		if id != "" {
+			req.Header.Set(logging.HeaderRequestID, id)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp
+	}
+
+	t.Run("Testing request id", func(t *testing.T) {
+		if id := do(http.MethodGet, "/cache/keys", "", "client-1").Header.Get(logging.HeaderRequestID); id != "client-1" {
+			t.Errorf("echoed request id = %q, want client-1", id)
+		}
+		if id := do(http.MethodGet, "/cache/keys", "", "bad id").Header.Get(logging.HeaderRequestID); id == "" || id == "bad id" {
+			t.Errorf("generated request id = %q", id)
+		}
+		if !strings.Contains(log.buf.String(), `"request_id":"client-1"`) {
+			t.Errorf("request log %q has no request id", log.buf.String())
+		}
+	})
+
+	do(http.MethodPut, "/cache/set/string/a", `{"value": "hello"}`, "put-1")
+	do(http.MethodPut, "/cache/set/string/b", `not json`, "")
+	do(http.MethodDelete, "/cache/remove/a", "", "")
+
+	records := out.records(t)
+	tests := []struct {
+		name  string
+		op    string
+		key   string
+		ok    bool
+		error string
+	}{
+		{name: "put", op: "PUT /cache/set/string/:key", key: "a", ok: true},
+		{name: "bad request", op: "PUT /cache/set/string/:key", key: "b", error: "400 Bad Request"},
+		{name: "delete", op: "DELETE /cache/remove/:key", key: "a", ok: true},
+	}
+	if len(records) != len(tests) {
+		t.Fatalf("got %d records, want %d: %+v", len(records), len(tests), records)
+	}
+	if records[0].RequestID != "put-1" {
+		t.Errorf("request id = %q, want put-1", records[0].RequestID)
+	}
+	for i, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			got := records[i]
+			if got.Proto != logging.ProtoHTTP || got.User != "admin" || got.Op != tt.op || got.Key != tt.key || got.OK != tt.ok || got.Error != tt.error {
+				t.Errorf("record = %+v, want op %q, key %q, ok %v, error %q", got, tt.op, tt.key, tt.ok, tt.error)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package lsm
+
+// Фильтр Блума сегмента: 10 бит на ключ и 7 хешей дают около 1% ложных срабатываний
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+)
+
+// bloom фильтр Блума: отвечает, что ключа точно нет в сегменте, без чтения диска
+type bloom []byte
+
+// newBloom фильтр для ключей с хешами hashes
+func newBloom(hashes []uint64) bloom {
+	bits := len(hashes) * bloomBitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+	b := make(bloom, (bits+7)/8)
+	for _, h := range hashes {
+		b.add(h)
+	}
+	return b
+}
+
+func (b bloom) add(h uint64) {
+	bits := uint32(len(b) * 8)
+	// Двойное хеширование: i-й хеш - h1 + i*h2
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % bits
+		b[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// mayContain может ли ключ с хешем h быть в сегменте
+func (b bloom) mayContain(h uint64) bool {
+	if len(b) == 0 {
+		return true
+	}
+	bits := uint32(len(b) * 8)
+	h1, h2 := uint32(h), uint32(h>>32)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % bits
+		if b[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// hashKey 64-битный FNV-1a ключа с перемешиванием, чтобы обе половины хеша были равномерными
+func hashKey(key string) uint64 {
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		h ^= uint64(key[i])
+		h *= 1099511628211
+	}
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	return h
+}
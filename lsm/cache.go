@@ -0,0 +1,101 @@
+package lsm
+
+import (
+	"container/list"
+	"sync"
+)
+
+// cache кеш записей, прочитанных из сегментов, с вытеснением давно не читанных (LRU).
+// Методы nil-кеша ничего не делают
+type cache struct {
+	mu       sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	// order записи от недавно прочитанных к давно прочитанным
+	order *list.List
+	bytes int
+}
+
+// newCache кеш на capacity ключей, nil при capacity 0
+func newCache(capacity int) *cache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &cache{
+		capacity: capacity,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *cache) get(key string) (*record, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(*record), true
+}
+
+// add запись в кеш. Записи кеша не изменяются: хранилище возвращает их значения копиями
+func (c *cache) add(rec *record) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[rec.key]; ok {
+		c.bytes += rec.size() - e.Value.(*record).size()
+		e.Value = rec
+		c.order.MoveToFront(e)
+		return
+	}
+	c.items[rec.key] = c.order.PushFront(rec)
+	c.bytes += rec.size()
+	for c.order.Len() > c.capacity {
+		c.removeElement(c.order.Back())
+	}
+}
+
+func (c *cache) remove(key string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *cache) removeElement(e *list.Element) {
+	rec := c.order.Remove(e).(*record)
+	delete(c.items, rec.key)
+	c.bytes -= rec.size()
+}
+
+func (c *cache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.items = make(map[string]*list.Element)
+	c.order.Init()
+	c.bytes = 0
+}
+
+// size оценка памяти записей кеша
+func (c *cache) size() int {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
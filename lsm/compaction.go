@@ -0,0 +1,202 @@
+package lsm
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Файлы каталога хранилища: журнал, список сегментов от старых к новым и сегменты
+const (
+	walFile       = "wal.log"
+	manifestFile  = "MANIFEST"
+	segmentSuffix = ".sst"
+)
+
+func segmentName(seq int) string {
+	return fmt.Sprintf("%06d%s", seq, segmentSuffix)
+}
+
+// openSegments открытие сегментов из MANIFEST. Файлы сегментов, которых нет в списке (остатки
+// прерванного сброса или слияния), удаляются
+func (s *Storage) openSegments() error {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, manifestFile))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	live := make(map[string]bool)
+	for _, name := range strings.Fields(string(data)) {
+		seq, err := strconv.Atoi(strings.TrimSuffix(name, segmentSuffix))
+		if err != nil || name != segmentName(seq) {
+			s.closeSegments()
+			return fmt.Errorf("lsm: %s: bad segment name %q", manifestFile, name)
+		}
+		seg, err := openSegment(filepath.Join(s.dir, name))
+		if err != nil {
+			s.closeSegments()
+			return fmt.Errorf("lsm: %s: %w", name, err)
+		}
+		s.segments = append(s.segments, seg)
+		live[name] = true
+		if seq > s.seq {
+			s.seq = seq
+		}
+	}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		s.closeSegments()
+		return err
+	}
+	for _, f := range files {
+		name := f.Name()
+		if (strings.HasSuffix(name, segmentSuffix) && !live[name]) || strings.HasSuffix(name, ".tmp") {
+			os.Remove(filepath.Join(s.dir, name))
+		}
+	}
+	return nil
+}
+
+func (s *Storage) closeSegments() {
+	for _, seg := range s.segments {
+		seg.close()
+	}
+	s.segments = nil
+}
+
+// writeManifest атомарная запись списка сегментов
+func (s *Storage) writeManifest(segments []*segment) error {
+	path := filepath.Join(s.dir, manifestFile)
+	tmp, err := ioutil.TempFile(s.dir, manifestFile+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	for _, seg := range segments {
+		fmt.Fprintln(w, filepath.Base(seg.name))
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// flush сброс записей памяти в новый сегмент и очистка журнала. Вызывается под блокировкой на запись
+func (s *Storage) flush() error {
+	if len(s.mem) == 0 {
+		return nil
+	}
+	// Без более старых сегментов записи удаления ничего не скрывают
+	var skip func(rec *record) bool
+	if len(s.segments) == 0 {
+		skip = func(rec *record) bool { return rec.value == nil }
+	}
+	s.seq++
+	seg, err := writeSegment(filepath.Join(s.dir, segmentName(s.seq)), newMemIterator(s.mem, ""), skip)
+	if err != nil {
+		return err
+	}
+	if seg != nil {
+		segments := append(s.segments[:len(s.segments):len(s.segments)], seg)
+		if err := s.writeManifest(segments); err != nil {
+			seg.remove()
+			return err
+		}
+		s.segments = segments
+	}
+	// Сегмент уже в MANIFEST: после сбоя до очистки журнала его записи применятся повторно
+	if err := s.wal.reset(); err != nil {
+		return err
+	}
+	s.mem = make(map[string]*record)
+	s.memSize = 0
+	if len(s.segments) >= s.opts.CompactionSegments {
+		s.scheduleCompaction()
+	}
+	return nil
+}
+
+func (s *Storage) scheduleCompaction() {
+	select {
+	case s.compact <- struct{}{}:
+	default:
+	}
+}
+
+func (s *Storage) runCompaction() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.compact:
+			if err := s.compactSegments(); err != nil {
+				log.Printf("lsm: compaction: %v", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// compactSegments слияние всех сегментов в один. Сегменты неизменяемы, поэтому сливаются без
+// блокировки хранилища; сегменты, сброшенные во время слияния, остаются новее результата.
+// В результат входит самый старый сегмент, поэтому записи удаления отбрасываются
+func (s *Storage) compactSegments() error {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+
+	s.mu.Lock()
+	inputs := append([]*segment(nil), s.segments...)
+	if s.closed || len(inputs) < 2 {
+		s.mu.Unlock()
+		return nil
+	}
+	s.seq++
+	path := filepath.Join(s.dir, segmentName(s.seq))
+	s.mu.Unlock()
+
+	sources := make([]iterator, 0, len(inputs))
+	for i := len(inputs) - 1; i >= 0; i-- {
+		sources = append(sources, inputs[i].iter(""))
+	}
+	merged, err := writeSegment(path, newMergeIterator(sources...), func(rec *record) bool {
+		return rec.value == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	segments := append([]*segment(nil), s.segments[len(inputs):]...)
+	if merged != nil {
+		segments = append([]*segment{merged}, segments...)
+	}
+	if err := s.writeManifest(segments); err != nil {
+		s.mu.Unlock()
+		if merged != nil {
+			merged.remove()
+		}
+		return err
+	}
+	s.segments = segments
+	s.mu.Unlock()
+
+	for _, seg := range inputs {
+		seg.remove()
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package lsm
+
+import (
+	"io"
+	"sort"
+)
+
+// iterator записи по возрастанию ключа. io.EOF - записи закончились
+type iterator interface {
+	next() (*record, error)
+}
+
+// memIterator обход записей памяти
+type memIterator struct {
+	records []*record
+}
+
+// newMemIterator обход копии записей mem с ключа from. Вызывается под блокировкой
+func newMemIterator(mem map[string]*record, from string) *memIterator {
+	records := make([]*record, 0, len(mem))
+	for key, rec := range mem {
+		if key >= from {
+			records = append(records, rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].key < records[j].key
+	})
+	return &memIterator{records: records}
+}
+
+func (it *memIterator) next() (*record, error) {
+	if len(it.records) == 0 {
+		return nil, io.EOF
+	}
+	rec := it.records[0]
+	it.records = it.records[1:]
+	return rec, nil
+}
+
+// mergeIterator слияние источников, упорядоченных от новых к старым. Из записей одного ключа
+// возвращается запись самого нового источника, в том числе запись удаления
+type mergeIterator struct {
+	sources []iterator
+	heads   []*record
+	started bool
+}
+
+func newMergeIterator(sources ...iterator) *mergeIterator {
+	return &mergeIterator{sources: sources, heads: make([]*record, len(sources))}
+}
+
+func (it *mergeIterator) next() (*record, error) {
+	if !it.started {
+		it.started = true
+		for i := range it.sources {
+			if err := it.advance(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	min := -1
+	for i, head := range it.heads {
+		if head != nil && (min < 0 || head.key < it.heads[min].key) {
+			min = i
+		}
+	}
+	if min < 0 {
+		return nil, io.EOF
+	}
+	rec := it.heads[min]
+	for i, head := range it.heads {
+		if head != nil && head.key == rec.key {
+			if err := it.advance(i); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return rec, nil
+}
+
+// advance следующая запись источника i
+func (it *mergeIterator) advance(i int) error {
+	rec, err := it.sources[i].next()
+	if err == io.EOF {
+		it.heads[i] = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	it.heads[i] = rec
+	return nil
+}
@@ -0,0 +1,190 @@
+package lsm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Виды записей
+const (
+	kindDelete byte = iota
+	kindString
+	kindList
+	kindDictionary
+)
+
+// maxFieldSize наибольшая длина строки записи: большая длина означает повреждённые данные
+const maxFieldSize = 1 << 31
+
+var errCorrupted = errors.New("lsm: corrupted record")
+
+// record запись ключа в журнале, памяти и сегментах
+type record struct {
+	key string
+	// value string, []string либо map[string]string, nil - ключ удалён
+	value interface{}
+	// expires момент истечения (UnixNano), 0 - без ограничения
+	expires uint64
+}
+
+// byteReader источник закодированных записей
+type byteReader interface {
+	io.Reader
+	io.ByteReader
+}
+
+// valueType тип значения записи
+func valueType(value interface{}) (structs.ValueType, bool) {
+	switch value.(type) {
+	case string:
+		return structs.String, true
+	case []string:
+		return structs.List, true
+	case map[string]string:
+		return structs.Dictionary, true
+	default:
+		return 0, false
+	}
+}
+
+// size оценка памяти записи
+func (r *record) size() int {
+	n := len(r.key) + 48
+	switch v := r.value.(type) {
+	case string:
+		n += len(v)
+	case []string:
+		for _, item := range v {
+			n += len(item) + 16
+		}
+	case map[string]string:
+		for k, item := range v {
+			n += len(k) + len(item) + 32
+		}
+	}
+	return n
+}
+
+// encode добавление закодированной записи к buf:
+// ключ, вид, для значения - момент истечения и само значение. Строки предваряются длиной
+func (r *record) encode(buf []byte) []byte {
+	buf = appendString(buf, r.key)
+	switch v := r.value.(type) {
+	case string:
+		buf = append(buf, kindString)
+		buf = binary.AppendUvarint(buf, r.expires)
+		buf = appendString(buf, v)
+	case []string:
+		buf = append(buf, kindList)
+		buf = binary.AppendUvarint(buf, r.expires)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		for _, item := range v {
+			buf = appendString(buf, item)
+		}
+	case map[string]string:
+		buf = append(buf, kindDictionary)
+		buf = binary.AppendUvarint(buf, r.expires)
+		buf = binary.AppendUvarint(buf, uint64(len(v)))
+		for k, item := range v {
+			buf = appendString(buf, k)
+			buf = appendString(buf, item)
+		}
+	default:
+		buf = append(buf, kindDelete)
+	}
+	return buf
+}
+
+// decodeRecord чтение записи, закодированной encode. io.EOF - записей больше нет
+func decodeRecord(r byteReader) (*record, error) {
+	key, err := readString(r)
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{key: key}
+	kind, err := r.ReadByte()
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	if kind == kindDelete {
+		return rec, nil
+	}
+	if rec.expires, err = binary.ReadUvarint(r); err != nil {
+		return nil, unexpected(err)
+	}
+	switch kind {
+	case kindString:
+		rec.value, err = readString(r)
+	case kindList:
+		var n uint64
+		if n, err = readLen(r); err != nil {
+			break
+		}
+		list := make([]string, n)
+		for i := range list {
+			if list[i], err = readString(r); err != nil {
+				break
+			}
+		}
+		rec.value = list
+	case kindDictionary:
+		var n uint64
+		if n, err = readLen(r); err != nil {
+			break
+		}
+		dict := make(map[string]string, n)
+		for i := uint64(0); i < n && err == nil; i++ {
+			var k, item string
+			if k, err = readString(r); err == nil {
+				item, err = readString(r)
+			}
+			dict[k] = item
+		}
+		rec.value = dict
+	default:
+		return nil, errCorrupted
+	}
+	if err != nil {
+		return nil, unexpected(err)
+	}
+	return rec, nil
+}
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func readLen(r byteReader) (uint64, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > maxFieldSize {
+		return 0, errCorrupted
+	}
+	return n, nil
+}
+
+func readString(r byteReader) (string, error) {
+	n, err := readLen(r)
+	if err != nil {
+		return "", err
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", unexpected(err)
+	}
+	return string(buf), nil
+}
+
+// unexpected конец данных внутри записи
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,265 @@
+package lsm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sort"
+)
+
+// Сегмент - неизменяемый файл записей, упорядоченных по ключу:
+//
+//	записи | индекс | фильтр Блума | footer
+//
+// Индекс хранит ключ и смещение каждой indexInterval-й записи, footer - смещения индекса
+// и фильтра, количество записей и segmentMagic
+const (
+	indexInterval = 16
+	footerSize    = 32
+	segmentMagic  = 0x676f6b766c736d31 // gokvlsm1
+)
+
+var errBadSegment = errors.New("lsm: bad segment file")
+
+type indexEntry struct {
+	key    string
+	offset int64
+}
+
+// segment открытый файл сегмента. Индекс и фильтр Блума хранятся в памяти
+type segment struct {
+	name  string
+	f     *os.File
+	index []indexEntry
+	bloom bloom
+	// dataSize размер записей, с него начинается индекс
+	dataSize int64
+	count    int
+}
+
+// writeSegment запись сегмента path из записей it по возрастанию ключа. Файл пишется во временный
+// и переименовывается. skip - записи, не попадающие в сегмент. Без записей сегмент не создаётся
+func writeSegment(path string, it iterator, skip func(rec *record) bool) (*segment, error) {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+
+	var (
+		w      = bufio.NewWriterSize(f, 64<<10)
+		offset int64
+		index  []indexEntry
+		hashes []uint64
+		buf    []byte
+	)
+	for {
+		rec, err := it.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if skip != nil && skip(rec) {
+			continue
+		}
+		if len(hashes)%indexInterval == 0 {
+			index = append(index, indexEntry{key: rec.key, offset: offset})
+		}
+		hashes = append(hashes, hashKey(rec.key))
+		buf = rec.encode(buf[:0])
+		if _, err := w.Write(buf); err != nil {
+			f.Close()
+			return nil, err
+		}
+		offset += int64(len(buf))
+	}
+	if len(hashes) == 0 {
+		f.Close()
+		return nil, nil
+	}
+
+	dataSize := offset
+	buf = binary.AppendUvarint(buf[:0], uint64(len(index)))
+	for _, e := range index {
+		buf = appendString(buf, e.key)
+		buf = binary.AppendUvarint(buf, uint64(e.offset))
+	}
+	bloomOffset := dataSize + int64(len(buf))
+	buf = append(buf, newBloom(hashes)...)
+	var footer [footerSize]byte
+	binary.LittleEndian.PutUint64(footer[0:], uint64(dataSize))
+	binary.LittleEndian.PutUint64(footer[8:], uint64(bloomOffset))
+	binary.LittleEndian.PutUint64(footer[16:], uint64(len(hashes)))
+	binary.LittleEndian.PutUint64(footer[24:], segmentMagic)
+	buf = append(buf, footer[:]...)
+	if _, err := w.Write(buf); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, err
+	}
+	return openSegment(path)
+}
+
+// openSegment открытие сегмента и чтение его индекса и фильтра Блума
+func openSegment(path string) (*segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := readSegment(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	s.name = path
+	return s, nil
+}
+
+func readSegment(f *os.File) (*segment, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < footerSize {
+		return nil, errBadSegment
+	}
+	var footer [footerSize]byte
+	if _, err := f.ReadAt(footer[:], size-footerSize); err != nil {
+		return nil, err
+	}
+	dataSize := int64(binary.LittleEndian.Uint64(footer[0:]))
+	bloomOffset := int64(binary.LittleEndian.Uint64(footer[8:]))
+	count := binary.LittleEndian.Uint64(footer[16:])
+	if binary.LittleEndian.Uint64(footer[24:]) != segmentMagic ||
+		dataSize < 0 || bloomOffset < dataSize || bloomOffset > size-footerSize {
+		return nil, errBadSegment
+	}
+
+	meta := make([]byte, size-footerSize-dataSize)
+	if _, err := f.ReadAt(meta, dataSize); err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(meta[:bloomOffset-dataSize])
+	n, err := readLen(r)
+	if err != nil {
+		return nil, errBadSegment
+	}
+	index := make([]indexEntry, n)
+	for i := range index {
+		if index[i].key, err = readString(r); err != nil {
+			return nil, errBadSegment
+		}
+		offset, err := binary.ReadUvarint(r)
+		if err != nil || int64(offset) > dataSize {
+			return nil, errBadSegment
+		}
+		index[i].offset = int64(offset)
+	}
+	return &segment{
+		f:        f,
+		index:    index,
+		bloom:    bloom(meta[bloomOffset-dataSize:]),
+		dataSize: dataSize,
+		count:    int(count),
+	}, nil
+}
+
+// block индекс части сегмента, в которой может быть ключ key. -1 - ключ меньше всех ключей сегмента
+func (s *segment) block(key string) int {
+	return sort.Search(len(s.index), func(i int) bool {
+		return s.index[i].key > key
+	}) - 1
+}
+
+// get запись ключа. nil - ключа нет в сегменте
+func (s *segment) get(key string) (*record, error) {
+	if !s.bloom.mayContain(hashKey(key)) {
+		return nil, nil
+	}
+	i := s.block(key)
+	if i < 0 {
+		return nil, nil
+	}
+	end := s.dataSize
+	if i+1 < len(s.index) {
+		end = s.index[i+1].offset
+	}
+	buf := make([]byte, end-s.index[i].offset)
+	if _, err := s.f.ReadAt(buf, s.index[i].offset); err != nil {
+		return nil, err
+	}
+	r := bytes.NewReader(buf)
+	for r.Len() > 0 {
+		rec, err := decodeRecord(r)
+		if err != nil {
+			return nil, unexpected(err)
+		}
+		if rec.key >= key {
+			if rec.key == key {
+				return rec, nil
+			}
+			break
+		}
+	}
+	return nil, nil
+}
+
+// iter обход записей сегмента с ключа from
+func (s *segment) iter(from string) iterator {
+	var offset int64
+	if i := s.block(from); i > 0 {
+		offset = s.index[i].offset
+	}
+	return &segmentIterator{
+		r:    bufio.NewReaderSize(io.NewSectionReader(s.f, offset, s.dataSize-offset), 32<<10),
+		from: from,
+	}
+}
+
+func (s *segment) close() error {
+	return s.f.Close()
+}
+
+// remove закрытие и удаление файла сегмента
+func (s *segment) remove() error {
+	s.f.Close()
+	return os.Remove(s.name)
+}
+
+type segmentIterator struct {
+	r    *bufio.Reader
+	from string
+}
+
+func (it *segmentIterator) next() (*record, error) {
+	for {
+		rec, err := decodeRecord(it.r)
+		if err != nil {
+			return nil, err
+		}
+		if rec.key >= it.from {
+			return rec, nil
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package lsm
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// records записи key:0000... со значениями всех типов и записями удаления
+func records(n int) []*record {
+	result := make([]*record, n)
+	for i := range result {
+		rec := &record{key: fmt.Sprintf("key:%04d", i)}
+		switch i % 4 {
+		case 0:
+			rec.value = fmt.Sprint(i)
+		case 1:
+			rec.value, rec.expires = []string{"a", "", fmt.Sprint(i)}, uint64(i)
+		case 2:
+			rec.value = map[string]string{"field": fmt.Sprint(i), "": "empty"}
+		}
+		result[i] = rec
+	}
+	return result
+}
+
+func collect(t *testing.T, it iterator) []*record {
+	t.Helper()
+	var result []*record
+	for {
+		rec, err := it.next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, rec)
+	}
+}
+
+func TestSegment(t *testing.T) {
+	want := records(1000)
+	path := filepath.Join(t.TempDir(), segmentName(1))
+	seg, err := writeSegment(path, &memIterator{records: want}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.close()
+
+	seg, err = openSegment(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer seg.close()
+	if seg.count != len(want) {
+		t.Errorf("count = %d, want %d", seg.count, len(want))
+	}
+	for _, rec := range want {
+		got, err := seg.get(rec.key)
+		if err != nil || !reflect.DeepEqual(got, rec) {
+			t.Fatalf("get(%q) = %+v, %v, want %+v", rec.key, got, err, rec)
+		}
+	}
+	for _, key := range []string{"", "key", "key:0500x", "key:9999"} {
+		if got, err := seg.get(key); got != nil || err != nil {
+			t.Errorf("get(%q) = %+v, %v, want nil", key, got, err)
+		}
+	}
+	if got := collect(t, seg.iter("")); !reflect.DeepEqual(got, want) {
+		t.Errorf("iter() returned %d records, want %d", len(got), len(want))
+	}
+	if got := collect(t, seg.iter("key:0500x")); !reflect.DeepEqual(got, want[501:]) {
+		t.Errorf("iter(key:0500x) returned %d records, want %d", len(got), len(want[501:]))
+	}
+}
+
+func TestSegment_Empty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), segmentName(1))
+	seg, err := writeSegment(path, &memIterator{records: records(4)}, func(*record) bool { return true })
+	if seg != nil || err != nil {
+		t.Fatalf("writeSegment() = %v, %v, want no segment", seg, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("segment file exists: %v", err)
+	}
+}
+
+func TestBloom(t *testing.T) {
+	hashes := make([]uint64, 10000)
+	for i := range hashes {
+		hashes[i] = hashKey(fmt.Sprintf("user:%d", i))
+	}
+	b := newBloom(hashes)
+	for i, h := range hashes {
+		if !b.mayContain(h) {
+			t.Fatalf("key user:%d is not in the filter", i)
+		}
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if b.mayContain(hashKey(fmt.Sprintf("order:%d", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 300 {
+		t.Errorf("%d false positives of 10000, want about 1%%", falsePositives)
+	}
+}
+
+// crash остановка хранилища без сброса памяти в сегмент, как при падении процесса
+func crash(s *Storage) {
+	s.janitor.Stop()
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+	s.wal.close()
+	s.closeSegments()
+}
+
+func TestStorage_WALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.PutOrUpdateString("a", "1")
+	s.PutOrUpdateList("b", []string{"x", "y"})
+	s.RemoveElement("a")
+	s.PutOrUpdateString("c", "3")
+	crash(s)
+
+	// Недописанная запись в конце журнала
+	f, err := os.OpenFile(filepath.Join(dir, walFile), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{10, 0, 0, 0, 1, 2})
+	f.Close()
+
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys := s.GetKeys(); !reflect.DeepEqual(keys, []string{"b", "c"}) {
+		t.Errorf("GetKeys() = %q, want [b c]", keys)
+	}
+	if val, err := s.GetElement("b"); err != nil || !reflect.DeepEqual(val, []string{"x", "y"}) {
+		t.Errorf("GetElement(b) = %v, %v, want [x y]", val, err)
+	}
+	// Журнал продолжается после отброшенного хвоста
+	s.PutOrUpdateString("d", "4")
+	crash(s)
+	s, err = Open(dir, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if n := s.Len(); n != 3 {
+		t.Errorf("Len() = %d, want 3", n)
+	}
+}
+
+func TestStorage_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(dir, Options{MemtableSize: 1 << 10, CompactionSegments: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for i := 0; i < 500; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("key:%04d", i), "value")
+	}
+	for i := 0; i < 500; i++ {
+		s.RemoveElement(fmt.Sprintf("key:%04d", i))
+	}
+	s.PutOrUpdateString("last", "value")
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.RLock()
+		n, count := len(s.segments), 0
+		if n == 1 {
+			count = s.segments[0].count
+		}
+		s.mu.RUnlock()
+		// Слияние отбрасывает удалённые ключи
+		if n == 1 && count < 500 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d segments with %d records after compaction", n, count)
+		}
+		s.scheduleCompaction()
+		time.Sleep(10 * time.Millisecond)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(files) != 1 {
+		t.Errorf("segment files %q, want one", files)
+	}
+	if keys := s.GetKeys(); !reflect.DeepEqual(keys, []string{"last"}) {
+		t.Errorf("GetKeys() = %q, want [last]", keys)
+	}
+}
@@ -0,0 +1,136 @@
+package lsm
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// loadChunk количество записей, загружаемых под одной блокировкой
+const loadChunk = 1024
+
+// Dump полный снимок хранилища, упорядоченный по ключам. Снимок читает все сегменты и целиком
+// находится в памяти
+func (s *Storage) Dump() []structs.Entry {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+
+	result := make([]structs.Entry, 0, s.length)
+	err := s.scan(context.Background(), "", func(rec *record) bool {
+		result = append(result, entry(rec))
+		return true
+	})
+	if err != nil {
+		log.Printf("lsm: dump: %v", err)
+	}
+	return result
+}
+
+// DumpKey снимок одного ключа с копией значения
+func (s *Storage) DumpKey(key string) (structs.Entry, error) {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+
+	rec, err := s.get(key)
+	if err != nil {
+		return structs.Entry{}, err
+	}
+	if rec == nil {
+		return structs.Entry{}, structs.ErrKeyNotFound
+	}
+	e := entry(rec)
+	switch v := e.Value.(type) {
+	case []string:
+		e.Value = copyList(v)
+	case map[string]string:
+		e.Value = copyDictionary(v)
+	}
+	return e, nil
+}
+
+// entry запись снимка. Значения записей не изменяются на месте и отдаются без копирования
+func entry(rec *record) structs.Entry {
+	typ, _ := valueType(rec.value)
+	return structs.Entry{Key: rec.key, Type: typ, Value: rec.value, Expired: rec.expires}
+}
+
+// Load загрузка записей снимка. Существующие ключи перезаписываются, значения записей переходят
+// хранилищу и не должны изменяться после загрузки
+func (s *Storage) Load(entries []structs.Entry) {
+	if err := s.LoadContext(context.Background(), entries); err != nil {
+		log.Printf("lsm: load: %v", err)
+	}
+}
+
+// LoadContext загрузка записей снимка частями по loadChunk. Между частями блокировка освобождается,
+// а загрузка прерывается отменой ctx; уже загруженные записи остаются в хранилище.
+// Потоки не поддерживаются и пропускаются с записью в журнал
+func (s *Storage) LoadContext(ctx context.Context, entries []structs.Entry) error {
+	for len(entries) > 0 {
+		n := loadChunk
+		if n > len(entries) {
+			n = len(entries)
+		}
+		if err := s.lockContext(ctx, true); err != nil {
+			return err
+		}
+		for _, e := range entries[:n] {
+			if _, ok := valueType(e.Value); !ok {
+				log.Printf("lsm: key %q of type %v skipped: not supported", e.Key, e.Type)
+				continue
+			}
+			if _, err := s.write(&record{key: e.Key, value: e.Value, expires: e.Expired}); err != nil {
+				s.mu.Unlock()
+				return err
+			}
+		}
+		s.mu.Unlock()
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// Flush удаление всех ключей вместе с сегментами и журналом
+func (s *Storage) Flush() {
+	s.compactMu.Lock()
+	defer s.compactMu.Unlock()
+	s.lockContext(context.Background(), true)
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+
+	if err := s.writeManifest(nil); err != nil {
+		log.Printf("lsm: flush: %v", err)
+		return
+	}
+	for _, seg := range s.segments {
+		if err := seg.remove(); err != nil {
+			log.Printf("lsm: flush: %s: %v", filepath.Base(seg.name), err)
+		}
+	}
+	s.segments = nil
+	if err := s.wal.reset(); err != nil {
+		log.Printf("lsm: flush: %v", err)
+	}
+	s.mem = make(map[string]*record)
+	s.memSize = 0
+	s.cache.clear()
+	s.expired = make(map[string]uint64)
+	s.keys = make(map[structs.ValueType]int)
+	s.length = 0
+}
+
+// ExpireAt установка момента истечения существующего ключа (UnixNano)
+func (s *Storage) ExpireAt(key string, deadline uint64) {
+	if deadline == 0 {
+		return
+	}
+	s.lockContext(context.Background(), true)
+	defer s.mu.Unlock()
+	if err := s.expireAt(key, deadline); err != nil {
+		log.Printf("lsm: expire %q: %v", key, err)
+	}
+}
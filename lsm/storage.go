@@ -0,0 +1,642 @@
+// Package lsm хранилище на диске для данных, не помещающихся в память: записи попадают в журнал
+// и память, заполненная память сбрасывается в упорядоченный файл-сегмент, сегменты в фоне сливаются
+// в один. Поиск ключа проверяет память, кеш прочитанных записей и сегменты от новых к старым,
+// пропуская сегменты по фильтру Блума. Потоки не поддерживаются
+package lsm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/geraev/gokvserver/internal/backend"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// ErrClosed операция с закрытым хранилищем
+var ErrClosed = errors.New("lsm: storage closed")
+
+// scanCheckInterval количество ключей между проверками отмены при обходе хранилища
+const scanCheckInterval = 1024
+
+// Options настройки хранилища. Нулевые значения заменяются значениями по умолчанию
+type Options struct {
+	// MemtableSize оценка памяти записей в байтах, после которой они сбрасываются в сегмент, по умолчанию 4 МБ
+	MemtableSize int
+	// CompactionSegments количество сегментов, при котором они сливаются в один, по умолчанию 4
+	CompactionSegments int
+	// CacheSize количество ключей в кеше записей, прочитанных из сегментов, 0 - без кеша
+	CacheSize int
+	// SyncWrites fsync журнала после каждой записи. Без него запись переживает падение процесса,
+	// но не сбой питания
+	SyncWrites bool
+}
+
+func (o *Options) init() {
+	if o.MemtableSize <= 0 {
+		o.MemtableSize = 4 << 20
+	}
+	if o.CompactionSegments < 2 {
+		o.CompactionSegments = 4
+	}
+}
+
+// Storage хранилище строк, списков и словарей в каталоге на диске
+type Storage struct {
+	dir  string
+	opts Options
+
+	mu sync.RWMutex
+	// mem записи, ещё не сброшенные в сегмент, memSize - оценка их памяти
+	mem     map[string]*record
+	memSize int
+	wal     *wal
+	// segments сегменты от старых к новым, seq - номер последнего сегмента
+	segments []*segment
+	seq      int
+	cache    *cache
+	closed   bool
+
+	// expired сроки жизни ключей, keys - количество ключей по типам, length - всего ключей.
+	// Хранятся в памяти, чтобы не обходить сегменты
+	expired map[string]uint64
+	keys    map[structs.ValueType]int
+	length  int
+
+	janitor *backend.Janitor
+	// compactMu исключает одновременное слияние сегментов и их удаление Flush и Close
+	compactMu sync.Mutex
+	compact   chan struct{}
+	stop      chan struct{}
+	stopOnce  sync.Once
+	wg        sync.WaitGroup
+
+	// observer observerBox, заменяется во время работы
+	observer atomic.Value
+	// expiredKeys ключи, удалённые по истечении срока жизни
+	expiredKeys uint64
+	// hits, misses чтения существующих и отсутствующих ключей
+	hits, misses uint64
+}
+
+// observerBox обёртка для atomic.Value, которому нужен один конкретный тип
+type observerBox struct {
+	structs.StorageObserver
+}
+
+// Open открытие хранилища в каталоге dir, каталог создаётся при необходимости. Записи журнала
+// восстанавливаются в память, сроки жизни и количество ключей - обходом всех сегментов
+func Open(dir string, opts Options) (*Storage, error) {
+	opts.init()
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Storage{
+		dir:     dir,
+		opts:    opts,
+		mem:     make(map[string]*record),
+		cache:   newCache(opts.CacheSize),
+		expired: make(map[string]uint64),
+		keys:    make(map[structs.ValueType]int),
+		compact: make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+	}
+	if err := s.openSegments(); err != nil {
+		return nil, err
+	}
+	w, records, err := openWAL(filepath.Join(dir, walFile), opts.SyncWrites)
+	if err != nil {
+		s.closeSegments()
+		return nil, err
+	}
+	s.wal = w
+	for _, rec := range records {
+		s.memSize += rec.size()
+		s.mem[rec.key] = rec
+	}
+	if err := s.count(); err != nil {
+		s.wal.close()
+		s.closeSegments()
+		return nil, err
+	}
+
+	s.janitor = backend.RunJanitor(20*time.Millisecond, s.DeleteExpired)
+	s.wg.Add(1)
+	go s.runCompaction()
+	if len(s.segments) >= opts.CompactionSegments {
+		s.scheduleCompaction()
+	}
+	return s, nil
+}
+
+// count подсчёт ключей и сроков жизни обходом всех записей
+func (s *Storage) count() error {
+	return s.scan(context.Background(), "", func(rec *record) bool {
+		typ, _ := valueType(rec.value)
+		s.keys[typ]++
+		s.length++
+		if rec.expires != 0 {
+			s.expired[rec.key] = rec.expires
+		}
+		return true
+	})
+}
+
+// SetObserver получатель событий хранилища (ожидание блокировки, работа janitor), nil - без событий.
+// Может вызываться во время работы
+func (s *Storage) SetObserver(o structs.StorageObserver) {
+	s.observer.Store(observerBox{o})
+}
+
+func (s *Storage) loadObserver() structs.StorageObserver {
+	box, _ := s.observer.Load().(observerBox)
+	return box.StorageObserver
+}
+
+// lockContext блокировка на запись (write) или чтение, ожидание которой прерывается отменой ctx
+func (s *Storage) lockContext(ctx context.Context, write bool) error {
+	return backend.LockContext(ctx, &s.mu, write, s.loadObserver())
+}
+
+// lookup учёт чтения ключа: found - ключ существует
+func (s *Storage) lookup(found bool) {
+	if found {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+}
+
+// get запись ключа из памяти, кеша или самого нового сегмента с ключом. nil - ключа нет.
+// Вызывается под блокировкой
+func (s *Storage) get(key string) (*record, error) {
+	if rec, ok := s.mem[key]; ok {
+		return live(rec), nil
+	}
+	if rec, ok := s.cache.get(key); ok {
+		return rec, nil
+	}
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		rec, err := s.segments[i].get(key)
+		if err != nil {
+			return nil, err
+		}
+		if rec != nil {
+			if rec = live(rec); rec != nil {
+				s.cache.add(rec)
+			}
+			return rec, nil
+		}
+	}
+	return nil, nil
+}
+
+// live запись существующего ключа либо nil для записи удаления
+func live(rec *record) *record {
+	if rec.value == nil {
+		return nil
+	}
+	return rec
+}
+
+// read запись ключа для чтения под блокировкой ctx с учётом попаданий
+func (s *Storage) read(ctx context.Context, key string) (*record, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	rec, err := s.get(key)
+	if err != nil {
+		return nil, err
+	}
+	s.lookup(rec != nil)
+	if rec == nil {
+		return nil, structs.ErrKeyNotFound
+	}
+	return rec, nil
+}
+
+// write запись в журнал и память. Возвращает предыдущую запись ключа (nil - ключа не было).
+// Вызывается под блокировкой на запись
+func (s *Storage) write(rec *record) (*record, error) {
+	if s.closed {
+		return nil, ErrClosed
+	}
+	old, err := s.get(rec.key)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.wal.append(rec); err != nil {
+		return nil, err
+	}
+	s.apply(rec, old)
+	if s.memSize >= s.opts.MemtableSize {
+		// Записи остаются в памяти и журнале, сброс повторится при следующей записи
+		if err := s.flush(); err != nil {
+			log.Printf("lsm: flush: %v", err)
+		}
+	}
+	return old, nil
+}
+
+// apply применение записи к памяти и счётчикам ключей
+func (s *Storage) apply(rec, old *record) {
+	if old != nil {
+		typ, _ := valueType(old.value)
+		s.keys[typ]--
+		s.length--
+	}
+	if rec.value != nil {
+		typ, _ := valueType(rec.value)
+		s.keys[typ]++
+		s.length++
+	}
+	if rec.value == nil || rec.expires == 0 {
+		delete(s.expired, rec.key)
+	} else {
+		s.expired[rec.key] = rec.expires
+	}
+	if prev, ok := s.mem[rec.key]; ok {
+		s.memSize -= prev.size()
+	}
+	s.mem[rec.key] = rec
+	s.memSize += rec.size()
+	s.cache.remove(rec.key)
+}
+
+// put запись значения ключа с сохранением срока жизни существующего ключа
+func (s *Storage) put(ctx context.Context, key string, value interface{}) (*record, error) {
+	if err := s.lockContext(ctx, true); err != nil {
+		return nil, err
+	}
+	defer s.mu.Unlock()
+
+	rec := &record{key: key, value: value}
+	if deadline, ok := s.expired[key]; ok {
+		rec.expires = deadline
+	}
+	return s.write(rec)
+}
+
+// scan обход существующих ключей по возрастанию с ключа from, пока fn возвращает true.
+// Вызывается под блокировкой, обход прерывается отменой ctx
+func (s *Storage) scan(ctx context.Context, from string, fn func(rec *record) bool) error {
+	sources := []iterator{newMemIterator(s.mem, from)}
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		sources = append(sources, s.segments[i].iter(from))
+	}
+	it := newMergeIterator(sources...)
+	for n := 0; ; n++ {
+		if n%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		rec, err := it.next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if rec.value != nil && !fn(rec) {
+			return nil
+		}
+	}
+}
+
+// Len количество ключей
+func (s *Storage) Len() int {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+	return s.length
+}
+
+// Stats статистика хранилища. Память - оценка записей, не сброшенных на диск, и кеша
+func (s *Storage) Stats() structs.Stats {
+	s.lockContext(context.Background(), false)
+	defer s.mu.RUnlock()
+
+	stats := structs.Stats{
+		Keys:           make(map[structs.ValueType]int, len(s.keys)),
+		KeysWithTTL:    len(s.expired),
+		ExpiredKeys:    atomic.LoadUint64(&s.expiredKeys),
+		KeyspaceHits:   atomic.LoadUint64(&s.hits),
+		KeyspaceMisses: atomic.LoadUint64(&s.misses),
+		MemoryBytes:    int64(s.memSize + s.cache.size()),
+	}
+	for typ, n := range s.keys {
+		if n > 0 {
+			stats.Keys[typ] = n
+		}
+	}
+	return stats
+}
+
+func (s *Storage) GetKeys() []string {
+	keys, _ := s.GetKeysContext(context.Background())
+	return keys
+}
+
+// GetKeysContext все ключи по возрастанию. Обход прерывается отменой ctx
+func (s *Storage) GetKeysContext(ctx context.Context) ([]string, error) {
+	return s.KeysRangeContext(ctx, structs.KeyRange{})
+}
+
+// KeysRangeContext ключи диапазона r по возрастанию. Сегменты читаются с начала диапазона
+func (s *Storage) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return nil, err
+	}
+	defer s.mu.RUnlock()
+
+	result := make([]string, 0)
+	err := s.scan(ctx, r.From(), func(rec *record) bool {
+		if r.Done(rec.key) {
+			return false
+		}
+		if r.Contains(rec.key) {
+			result = append(result, rec.key)
+		}
+		return r.Limit <= 0 || len(result) < r.Limit
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// GetElement получение элемента по ключу. Списки и словари возвращаются копиями
+func (s *Storage) GetElement(key string) (interface{}, error) {
+	return s.GetElementContext(context.Background(), key)
+}
+
+func (s *Storage) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	rec, err := s.read(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	switch v := rec.value.(type) {
+	case []string:
+		return copyList(v), nil
+	case map[string]string:
+		return copyDictionary(v), nil
+	default:
+		return v, nil
+	}
+}
+
+// GetListElement получение по индексу одного элемента из списка
+func (s *Storage) GetListElement(key string, index int) (string, error) {
+	return s.GetListElementContext(context.Background(), key, index)
+}
+
+func (s *Storage) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	if index < 0 {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+		return "", structs.ErrIndexOutOfRange
+	}
+	rec, err := s.read(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	v, ok := rec.value.([]string)
+	if !ok {
+		return "", structs.ErrType
+	}
+	if index >= len(v) {
+		return "", structs.ErrIndexOutOfRange
+	}
+	return v[index], nil
+}
+
+// GetDictionaryElement получение по ключу одного элемента из словаря
+func (s *Storage) GetDictionaryElement(key, internalKey string) (string, error) {
+	return s.GetDictionaryElementContext(context.Background(), key, internalKey)
+}
+
+func (s *Storage) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	rec, err := s.read(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	v, ok := rec.value.(map[string]string)
+	if !ok {
+		return "", structs.ErrType
+	}
+	item, ok := v[internalKey]
+	if !ok {
+		return "", structs.ErrKeyNotFound
+	}
+	return item, nil
+}
+
+// PutOrUpdateString добавление либо обновление значения ключа. Для существующего ключа возвращается
+// предыдущее значение и true
+func (s *Storage) PutOrUpdateString(key, value string) (string, bool) {
+	previousVal, isUpdated, err := s.PutOrUpdateStringContext(context.Background(), key, value)
+	if err != nil {
+		log.Printf("lsm: put %q: %v", key, err)
+	}
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	old, err := s.put(ctx, key, value)
+	if err != nil || old == nil {
+		return "", false, err
+	}
+	// Значение другого типа заменяется, предыдущим значением возвращается нулевое
+	previousVal, _ := old.value.(string)
+	return previousVal, true, nil
+}
+
+// PutOrUpdateList добавление либо обновление списка. Хранится копия value
+func (s *Storage) PutOrUpdateList(key string, value []string) ([]string, bool) {
+	previousVal, isUpdated, err := s.PutOrUpdateListContext(context.Background(), key, value)
+	if err != nil {
+		log.Printf("lsm: put %q: %v", key, err)
+	}
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	old, err := s.put(ctx, key, copyList(value))
+	if err != nil || old == nil {
+		return nil, false, err
+	}
+	previousVal, _ := old.value.([]string)
+	return copyList(previousVal), true, nil
+}
+
+// PutOrUpdateDictionary добавление либо обновление словаря. Хранится копия value
+func (s *Storage) PutOrUpdateDictionary(key string, value map[string]string) (map[string]string, bool) {
+	previousVal, isUpdated, err := s.PutOrUpdateDictionaryContext(context.Background(), key, value)
+	if err != nil {
+		log.Printf("lsm: put %q: %v", key, err)
+	}
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	old, err := s.put(ctx, key, copyDictionary(value))
+	if err != nil || old == nil {
+		return nil, false, err
+	}
+	previousVal, _ := old.value.(map[string]string)
+	return copyDictionary(previousVal), true, nil
+}
+
+// RemoveElement удаление элемента по ключу
+func (s *Storage) RemoveElement(key string) {
+	if err := s.RemoveElementContext(context.Background(), key); err != nil {
+		log.Printf("lsm: remove %q: %v", key, err)
+	}
+}
+
+func (s *Storage) RemoveElementContext(ctx context.Context, key string) error {
+	if err := s.lockContext(ctx, true); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	_, err := s.write(&record{key: key})
+	return err
+}
+
+// SetTTL установка TTL в миллисекундах
+// Deprecated: используйте SetExpired
+func (s *Storage) SetTTL(key string, keyTTL uint64) {
+	s.SetExpired(key, keyTTL)
+}
+
+// SetExpired установка TTL для ключа в миллисекундах. Для отсутствующего ключа ничего не делает
+func (s *Storage) SetExpired(key string, expired uint64) {
+	if err := s.SetExpiredContext(context.Background(), key, expired); err != nil {
+		log.Printf("lsm: expire %q: %v", key, err)
+	}
+}
+
+func (s *Storage) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	if expired == 0 {
+		return nil
+	}
+	if err := s.lockContext(ctx, true); err != nil {
+		return err
+	}
+	defer s.mu.Unlock()
+
+	return s.expireAt(key, uint64(time.Now().Add(time.Millisecond*time.Duration(expired)).UnixNano()))
+}
+
+// expireAt установка момента истечения существующего ключа: значение записывается заново вместе
+// со сроком жизни. Вызывается под блокировкой на запись
+func (s *Storage) expireAt(key string, deadline uint64) error {
+	old, err := s.get(key)
+	if err != nil || old == nil {
+		return err
+	}
+	_, err = s.write(&record{key: key, value: old.value, expires: deadline})
+	return err
+}
+
+func (s *Storage) GetType(key string) (structs.ValueType, error) {
+	return s.GetTypeContext(context.Background(), key)
+}
+
+func (s *Storage) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return 0, err
+	}
+	defer s.mu.RUnlock()
+
+	rec, err := s.get(key)
+	if err != nil {
+		return 0, err
+	}
+	if rec == nil {
+		return 0, structs.ErrKeyNotFound
+	}
+	typ, ok := valueType(rec.value)
+	if !ok {
+		return 0, structs.ErrType
+	}
+	return typ, nil
+}
+
+// DeleteExpired удаление просроченных ключей
+func (s *Storage) DeleteExpired() {
+	start := time.Now()
+	s.lockContext(context.Background(), true)
+	now := uint64(time.Now().UnixNano())
+	var n uint64
+	for key, deadline := range s.expired {
+		if now < deadline {
+			continue
+		}
+		if _, err := s.write(&record{key: key}); err != nil {
+			log.Printf("lsm: expire %q: %v", key, err)
+			break
+		}
+		n++
+	}
+	s.mu.Unlock()
+	atomic.AddUint64(&s.expiredKeys, n)
+	if o := s.loadObserver(); o != nil {
+		o.JanitorRun(time.Since(start))
+	}
+}
+
+// Close остановка фоновой работы, сброс памяти в сегмент и закрытие файлов.
+// После Close хранилище недоступно
+func (s *Storage) Close() error {
+	s.janitor.Stop()
+	// Начатое слияние сегментов завершается
+	s.stopOnce.Do(func() {
+		close(s.stop)
+	})
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+
+	err := s.flush()
+	if werr := s.wal.close(); err == nil {
+		err = werr
+	}
+	s.closeSegments()
+	return err
+}
+
+// copyList копия списка: хранилище не делит значения с вызывающим кодом
+func copyList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(list)), list...)
+}
+
+// copyDictionary копия словаря
+func copyDictionary(dict map[string]string) map[string]string {
+	if dict == nil {
+		return nil
+	}
+	result := make(map[string]string, len(dict))
+	for k, v := range dict {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,133 @@
+package lsm_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/geraev/gokvserver/lsm"
+	"github.com/geraev/gokvserver/storagetest"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// small настройки, при которых тесты сбрасывают записи в сегменты и сливают их
+var small = lsm.Options{MemtableSize: 4 << 10, CompactionSegments: 3, CacheSize: 64}
+
+func open(t *testing.T, dir string, opts lsm.Options) *lsm.Storage {
+	t.Helper()
+	s, err := lsm.Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	tests := []struct {
+		name string
+		opts lsm.Options
+	}{
+		{name: "memtable", opts: lsm.Options{}},
+		{name: "segments", opts: small},
+		{name: "segments without cache", opts: lsm.Options{MemtableSize: 4 << 10, CompactionSegments: 3}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			storagetest.Run(t, func(t *testing.T) structs.Storage {
+				return open(t, t.TempDir(), tt.opts)
+			})
+		})
+	}
+}
+
+func TestStorage_Stress(t *testing.T) {
+	storagetest.Stress(t, func(t *testing.T) structs.Storage {
+		return open(t, t.TempDir(), small)
+	})
+}
+
+func TestStorage_Reopen(t *testing.T) {
+	dir := t.TempDir()
+	s := open(t, dir, small)
+	for i := 0; i < 2000; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("key:%04d", i), fmt.Sprintf("value %d", i))
+	}
+	for i := 0; i < 2000; i += 2 {
+		s.RemoveElement(fmt.Sprintf("key:%04d", i))
+	}
+	s.PutOrUpdateList("list", []string{"a", "b"})
+	s.PutOrUpdateDictionary("dict", map[string]string{"a": "1"})
+	s.SetExpired("list", 3600000)
+	want := s.Dump()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = open(t, dir, small)
+	defer s.Close()
+	if got := s.Dump(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Dump() after reopen returned %d entries, want %d", len(got), len(want))
+	}
+	if n := s.Len(); n != 1002 {
+		t.Errorf("Len() = %d, want 1002", n)
+	}
+	stats := s.Stats()
+	wantKeys := map[structs.ValueType]int{structs.String: 1000, structs.List: 1, structs.Dictionary: 1}
+	if !reflect.DeepEqual(stats.Keys, wantKeys) || stats.KeysWithTTL != 1 {
+		t.Errorf("Stats() keys = %v with ttl %d, want %v with ttl 1", stats.Keys, stats.KeysWithTTL, wantKeys)
+	}
+	if _, err := s.GetElement("key:0000"); err != structs.ErrKeyNotFound {
+		t.Errorf("GetElement() of a removed key error = %v, want %v", err, structs.ErrKeyNotFound)
+	}
+	if val, err := s.GetElement("key:1999"); err != nil || val != "value 1999" {
+		t.Errorf("GetElement() = %v, %v, want value 1999", val, err)
+	}
+}
+
+func TestStorage_Snapshot(t *testing.T) {
+	s := open(t, t.TempDir(), small)
+	defer s.Close()
+	for i := 0; i < 3000; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("key:%04d", i), "value")
+	}
+	s.PutOrUpdateList("list", []string{"a", "b"})
+	s.SetExpired("list", 60000)
+
+	entries := s.Dump()
+	restored := open(t, t.TempDir(), small)
+	defer restored.Close()
+	restored.Load(append(entries, structs.Entry{Key: "stream", Type: structs.Stream, Value: []structs.StreamEntry{}}))
+
+	if !reflect.DeepEqual(restored.Dump(), entries) {
+		t.Errorf("Dump() after Load() differs from the source")
+	}
+	if got := restored.Stats().KeysWithTTL; got != 1 {
+		t.Errorf("KeysWithTTL = %d, want 1", got)
+	}
+	restored.Flush()
+	if n := restored.Len(); n != 0 {
+		t.Errorf("Len() after Flush() = %d, want 0", n)
+	}
+	if keys := restored.GetKeys(); len(keys) != 0 {
+		t.Errorf("GetKeys() after Flush() = %q, want none", keys)
+	}
+}
+
+func BenchmarkStorage_GetElement(b *testing.B) {
+	for _, cacheSize := range []int{0, 100000} {
+		b.Run(fmt.Sprintf("cache %d", cacheSize), func(b *testing.B) {
+			s, err := lsm.Open(b.TempDir(), lsm.Options{MemtableSize: 64 << 10, CacheSize: cacheSize})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer s.Close()
+			for i := 0; i < 100000; i++ {
+				s.PutOrUpdateString(fmt.Sprintf("user:%d:session", i), "value")
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.GetElement(fmt.Sprintf("user:%d:session", i%1000))
+			}
+		})
+	}
+}
@@ -0,0 +1,84 @@
+package lsm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// walHeaderSize длина и контрольная сумма записи журнала
+const walHeaderSize = 8
+
+// wal журнал записей, ещё не сброшенных в сегмент. Восстанавливает память после перезапуска
+type wal struct {
+	f    *os.File
+	sync bool
+	buf  []byte
+}
+
+// openWAL открытие журнала и чтение его записей. Недописанный хвост журнала (например, после
+// сбоя питания) отбрасывается
+func openWAL(path string, sync bool) (*wal, []*record, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	var (
+		records []*record
+		good    int
+	)
+	for len(data)-good >= walHeaderSize {
+		n := int(binary.LittleEndian.Uint32(data[good:]))
+		sum := binary.LittleEndian.Uint32(data[good+4:])
+		payload := data[good+walHeaderSize:]
+		if n > len(payload) || crc32.ChecksumIEEE(payload[:n]) != sum {
+			break
+		}
+		rec, err := decodeRecord(bytes.NewReader(payload[:n]))
+		if err != nil {
+			break
+		}
+		records = append(records, rec)
+		good += walHeaderSize + n
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+	if good < len(data) {
+		log.Printf("lsm: %s: %d bytes of a partial record discarded", path, len(data)-good)
+		if err := f.Truncate(int64(good)); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	return &wal{f: f, sync: sync}, records, nil
+}
+
+// append запись в журнал. Вызывается под блокировкой на запись
+func (w *wal) append(rec *record) error {
+	w.buf = rec.encode(append(w.buf[:0], 0, 0, 0, 0, 0, 0, 0, 0))
+	payload := w.buf[walHeaderSize:]
+	binary.LittleEndian.PutUint32(w.buf, uint32(len(payload)))
+	binary.LittleEndian.PutUint32(w.buf[4:], crc32.ChecksumIEEE(payload))
+	if _, err := w.f.Write(w.buf); err != nil {
+		return err
+	}
+	if w.sync {
+		return w.f.Sync()
+	}
+	return nil
+}
+
+// reset очистка журнала после сброса записей в сегмент
+func (w *wal) reset() error {
+	return w.f.Truncate(0)
+}
+
+func (w *wal) close() error {
+	return w.f.Close()
+}
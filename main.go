@@ -1,64 +1,529 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/cluster"
+	"github.com/geraev/gokvserver/config"
 	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/info"
+	"github.com/geraev/gokvserver/logging"
 	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/middleware"
+	"github.com/geraev/gokvserver/raft"
+	"github.com/geraev/gokvserver/replication"
+	"github.com/geraev/gokvserver/slowlog"
 	"github.com/geraev/gokvserver/structs"
 	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/geraev/gokvserver/tracing"
+	"github.com/gin-gonic/gin"
 	"log"
+	"net"
 	"net/http"
 	_ "net/http/pprof"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 var (
 	flags struct {
-		tcpAddr  string
-		httpAddr string
-	}
+		config    string
+		tcpAddr   string
+		httpAddr  string
+		pprofAddr string
+		snapshot  string
+
+		shutdownTimeout time.Duration
+		hashPassword    string
 
-	cache structs.Storage
+		replicaOf string
+		raftID    string
+		raftAddr  string
+		raftPeers string
+		raftDir   string
 
-	accounts = map[string]string{
-		"iqoption": "qwerty64",
-		"geraev":   "markus14",
+		clusterID    string
+		clusterNodes string
 	}
+
+	settings *config.Store
+	logger   *logging.Logger
+	users    *auth.Store
+	tokens   *auth.TokenVerifier
+	certs    *auth.Certificates
+	stats    *metrics.Metrics
+	about    *info.Info
+	slow     *slowlog.Log
+	storage  backend
+	cache    structs.Storage
+	guard    structs.Guard
+	leader   *replication.Leader
+	follower *replication.Follower
+	node     *raft.Storage
+	slots    *cluster.Cluster
+	tcp      *tcpserver.Server
+	web      *httpserver.Server
+
+	// Журналы аудита серверов и их файлы, закрываемые при остановке
+	tcpAudit  *logging.Audit
+	httpAudit *logging.Audit
+	audits    []*logging.RotatingFile
+
+	tracer *tracing.Tracer
 )
 
 func init() {
-	flag.StringVar(&flags.tcpAddr, "tcp-port", "9736", "The TCP port to bind to")
-	flag.StringVar(&flags.httpAddr, "http-port", "8081", "The HTTP port to bind to")
+	defaults := config.Default()
+	flag.StringVar(&flags.config, "config", "", "The YAML config file, reloaded on SIGHUP")
+	flag.StringVar(&flags.hashPassword, "hash-password", "", "Print the hash of the password read from stdin for the users file (bcrypt or argon2id) and exit")
+	flag.StringVar(&flags.tcpAddr, "tcp-port", defaults.Listeners.TCP, "The TCP port to bind to, empty to disable the TCP server")
+	flag.StringVar(&flags.httpAddr, "http-port", defaults.Listeners.HTTP, "The HTTP port to bind to, empty to disable the HTTP server")
+	flag.StringVar(&flags.pprofAddr, "pprof-addr", defaults.Listeners.Pprof, "The address to bind to for pprof, empty to disable")
+	flag.StringVar(&flags.snapshot, "snapshot", defaults.Persistence.Snapshot, "The file to load the data from on start and save it to on shutdown")
+	flag.DurationVar(&flags.shutdownTimeout, "shutdown-timeout", defaults.Listeners.ShutdownTimeout, "The time to wait for in-flight requests on shutdown")
+	flag.StringVar(&flags.replicaOf, "replicaof", "", "The leader TCP address (host:port) to replicate from")
+	flag.StringVar(&flags.raftID, "raft-id", "", "The Raft node ID, enables the Raft mode")
+	flag.StringVar(&flags.raftAddr, "raft-addr", ":9800", "The address to bind to for the Raft messages")
+	flag.StringVar(&flags.raftPeers, "raft-peers", "", "The initial Raft cluster: id=host:port,... including this node")
+	flag.StringVar(&flags.raftDir, "raft-dir", "", "The directory to keep the Raft term, vote, log and snapshots in, required in the Raft mode")
+	flag.StringVar(&flags.clusterID, "cluster-id", "", "The cluster node ID, enables the hash slot cluster mode")
+	flag.StringVar(&flags.clusterNodes, "cluster-nodes", "", "The cluster nodes: id=host:tcp_port/host:http_port,... including this node")
+}
+
+// server сервер, запускаемый вместе с остальными и останавливаемый по сигналу
+type server interface {
+	Run() error
+	Shutdown(ctx context.Context) error
 }
 
 func main() {
+	flag.Parse()
+	// Стандартный журнал пишется в структурированный с уровнем info
+	logger = logging.New(os.Stderr, logging.LevelInfo)
+	log.SetFlags(0)
+	log.SetOutput(logger.Writer(logging.LevelInfo))
+	if flags.hashPassword != "" {
+		if err := printPasswordHash(flags.hashPassword); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
+	cfg, err := config.Load(flags.config, overrideFlags)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if users, err = loadUsers(cfg.Auth); err != nil {
+		log.Fatalln(err)
+	}
+	if cfg.Auth.TokenAlgorithm != "" && cfg.Auth.TokenKeyFile != "" {
+		if tokens, err = auth.LoadTokenVerifier(cfg.Auth.TokenAlgorithm, cfg.Auth.TokenKeyFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if cfg.TLS.CertFile != "" {
+		if certs, err = auth.LoadCertificates(cfg.TLS.CertFile, cfg.TLS.KeyFile, cfg.TLS.ClientCAFile); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if cfg.Log.Level != config.LevelDebug {
+		gin.SetMode(gin.ReleaseMode)
+	}
+	if err := openAudits(cfg.Audit); err != nil {
+		log.Fatalln(err)
+	}
+	if tracer, err = newTracer(cfg.Tracing); err != nil {
+		log.Fatalln(err)
+	}
+
+	if storage, err = newBackend(cfg.Storage); err != nil {
+		log.Fatalln(err)
+	}
+	stats = metrics.New(storage)
+	storage.SetObserver(stats)
+	about = info.New()
+	slow = slowlog.New(cfg.Slowlog.Threshold, cfg.Slowlog.MaxLen)
+	about.SetStorage(storage)
+	loadedKeys := 0
+	if cfg.Persistence.Snapshot != "" {
+		if flags.raftID != "" {
+			log.Fatalln("the snapshot file can't be used in the Raft mode")
+		}
+		// Загрузка большого снимка прерывается сигналом остановки
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		err := structs.LoadSnapshotContext(ctx, cfg.Persistence.Snapshot, storage)
+		stop()
+		if err != nil {
+			log.Fatalln(err)
+		}
+		loadedKeys = storage.Len()
+	}
+
+	if flags.raftID != "" {
+		node = raftRun(storage)
+		cache = node
+		guard = node.Guard
+	} else if flags.replicaOf != "" {
+		// Реплика принимает данные только от ведущего узла
+		follower = replication.NewFollower(flags.replicaOf, storage)
+		if certs != nil {
+			follower.SetTLSConfig(peerTLSConfig(cfg.TLS))
+		}
+		go follower.Run()
+		cache = storage
+		guard = follower.Guard
+	} else if cfg.Replication.Backlog > 0 {
+		leader = replication.NewLeader(storage, cfg.Replication.Backlog)
+		cache = leader
+	} else {
+		cache = storage
+	}
+
+	if flags.clusterID != "" {
+		// Узел кластера обслуживает только ключи своих слотов
+		if node != nil {
+			log.Fatalln("the cluster mode can't be combined with the Raft mode")
+		}
+		if cfg.Listeners.TCP == "" {
+			log.Fatalln("the cluster mode requires the TCP server")
+		}
+		nodes, err := cluster.ParseNodes(flags.clusterNodes)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if slots, err = cluster.New(flags.clusterID, nodes, cache.(cluster.Storage)); err != nil {
+			log.Fatalln(err)
+		}
+		if certs != nil {
+			slots.SetTLSConfig(peerTLSConfig(cfg.TLS))
+		}
+		guard = structs.ChainGuards(slots.Guard, guard)
+	}
+
+	guard = structs.ChainGuards(guard, keyLimit)
+	if middlewares := storageMiddlewares(cfg.Storage); len(middlewares) > 0 {
+		// Ключи с префиксом попадают в другие слоты кластера
+		if slots != nil && hasMiddleware(cfg.Storage, config.MiddlewarePrefix) {
+			log.Fatalln("the prefix middleware can't be used in the cluster mode")
+		}
+		cache = middleware.Chain(cache, middlewares...)
+	}
+	registerInfo(cfg, loadedKeys)
+
+	var servers []server
+	if cfg.Listeners.Pprof != "" {
+		// Метрики доступны и при отключённом HTTP сервере
+		http.Handle("/metrics", stats.Handler())
+		servers = append(servers, &pprofServer{Server: &http.Server{Addr: cfg.Listeners.Pprof}})
+	}
+	// Реплики подключаются к TCP порту
+	if cfg.Listeners.TCP != "" {
+		tcp = tcpServer(cfg.Listeners.TCP, cfg.TLS)
+		servers = append(servers, tcp)
+	}
+	if cfg.Listeners.HTTP != "" {
+		web = httpServer(cfg.Listeners.HTTP, cfg.Auth, cfg.TLS)
+		servers = append(servers, web)
+	}
+
+	settings = config.NewStore(flags.config, cfg, overrideFlags, applyConfig)
+	if err := applyConfig(cfg); err != nil {
+		log.Fatalln(err)
+	}
+	if tcp != nil {
+		tcp.Handle("config", settings)
+	}
+
+	errs := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func(srv server) {
+			errs <- srv.Run()
+		}(srv)
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	code := 0
+wait:
+	for {
+		select {
+		case s := <-sig:
+			if s == syscall.SIGHUP {
+				reload()
+				continue
+			}
+			log.Printf("received %s, shutting down", s)
+		case err := <-errs:
+			log.Println(err)
+			code = 1
+		}
+		break wait
+	}
+	// Повторный сигнал завершает процесс немедленно
+	signal.Stop(sig)
+
+	if !shutdown(servers, settings.Config()) {
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// shutdown остановка серверов с ожиданием выполняемых запросов, затем фоновых задач хранилища
+// и сохранение снимка. Возвращает false, если что-то завершилось с ошибкой
+func shutdown(servers []server, cfg *config.Config) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.Listeners.ShutdownTimeout)
+	defer cancel()
+
+	if leader != nil {
+		// Потоки репликации не завершаются сами и задержали бы остановку TCP сервера
+		leader.Close()
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+		ok = true
+	)
+	for _, srv := range servers {
+		wg.Add(1)
+		go func(srv server) {
+			defer wg.Done()
+			if err := srv.Shutdown(ctx); err != nil {
+				mu.Lock()
+				log.Printf("shutdown: %v", err)
+				ok = false
+				mu.Unlock()
+			}
+		}(srv)
+	}
+	wg.Wait()
+
+	if follower != nil {
+		follower.Close()
+	}
+	if node != nil {
+		node.Node().Stop()
+	}
+	for _, f := range audits {
+		f.Close()
+	}
+	tracer.Close()
+
+	// Снимок сохраняется до закрытия: закрытое хранилище lsm недоступно
+	if path := cfg.Persistence.Snapshot; path != "" {
+		if err := structs.SaveSnapshot(path, storage); err != nil {
+			log.Printf("snapshot: %v", err)
+			ok = false
+		} else {
+			log.Printf("snapshot saved to %s", path)
+		}
+	}
+	if err := storage.Close(); err != nil {
+		log.Printf("storage: %v", err)
+		ok = false
+	}
+	return ok
+}
+
+// pprofServer сервер профилирования net/http/pprof
+type pprofServer struct {
+	*http.Server
+}
+
+func (s *pprofServer) Run() error {
+	if err := s.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// raftRun запуск узла Raft. Узел, добавляемый в работающий кластер, запускается без -raft-peers.
+// Перезапущенный узел продолжает работу с состояния из -raft-dir
+func raftRun(storage replication.Storage) *raft.Storage {
+	if flags.raftDir == "" {
+		log.Fatalln("the Raft mode requires -raft-dir")
+	}
+	peers := make(map[string]string)
+	for _, peer := range strings.Split(flags.raftPeers, ",") {
+		if peer == "" {
+			continue
+		}
+		kv := strings.SplitN(peer, "=", 2)
+		if len(kv) != 2 {
+			log.Fatalf("invalid raft peer %q, want id=host:port", peer)
+		}
+		peers[kv[0]] = kv[1]
+	}
+
+	lis, err := net.Listen("tcp", flags.raftAddr)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s, err := raft.NewStorage(storage, raft.Config{
+		ID:        flags.raftID,
+		Peers:     peers,
+		Transport: raft.NewRPCTransport(),
+		Dir:       flags.raftDir,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
 	go func() {
-		log.Println(http.ListenAndServe("localhost:6060", nil))
+		log.Fatalln(raft.ServeRPC(lis, s.Node()))
 	}()
-	cache = mapbased.NewStorage()
-	// tcpRun()
-	httpRun()
+	s.Node().Start()
+	return s
 }
 
-func httpRun() {
-	http := httpserver.NewServer(flags.httpAddr, accounts, cache)
-	if err := http.Run(); err != nil {
-		log.Fatalln(err)
+// registerInfo разделы сведений команды info, зависящие от режима работы и серверов
+func registerInfo(cfg *config.Config, loadedKeys int) {
+	mode := "standalone"
+	switch {
+	case slots != nil:
+		mode = "cluster"
+	case node != nil:
+		mode = "raft"
+	case follower != nil:
+		mode = "replica"
+	}
+	about.Register(info.SectionServer, func() []info.Field {
+		return []info.Field{
+			{Name: "mode", Value: mode},
+			{Name: "tcp_port", Value: cfg.Listeners.TCP},
+			{Name: "http_port", Value: cfg.Listeners.HTTP},
+			{Name: "tls_enabled", Value: certs != nil},
+		}
+	})
+	about.Register(info.SectionClients, func() []info.Field {
+		var fields []info.Field
+		if tcp != nil {
+			fields = append(fields, info.Field{Name: "tcp_clients", Value: tcp.Clients()})
+		}
+		if web != nil {
+			fields = append(fields, info.Field{Name: "http_clients", Value: web.Clients()})
+		}
+		return fields
+	})
+	about.Register(info.SectionPersistence, func() []info.Field {
+		return []info.Field{
+			{Name: "snapshot_enabled", Value: cfg.Persistence.Snapshot != ""},
+			{Name: "snapshot_file", Value: cfg.Persistence.Snapshot},
+			{Name: "snapshot_loaded_keys", Value: loadedKeys},
+		}
+	})
+	about.Register(info.SectionReplication, func() []info.Field {
+		switch {
+		case leader != nil:
+			return []info.Field{
+				{Name: "role", Value: "master"},
+				{Name: "connected_slaves", Value: leader.Replicas()},
+				{Name: "master_replid", Value: leader.ID()},
+				{Name: "master_repl_offset", Value: leader.Offset()},
+				{Name: "repl_backlog_size", Value: cfg.Replication.Backlog},
+			}
+		case follower != nil:
+			status := "down"
+			if follower.Connected() {
+				status = "up"
+			}
+			return []info.Field{
+				{Name: "role", Value: "slave"},
+				{Name: "master_host", Value: flags.replicaOf},
+				{Name: "master_link_status", Value: status},
+				{Name: "slave_repl_offset", Value: follower.Offset()},
+				{Name: "full_syncs", Value: follower.FullSyncs()},
+			}
+		case node != nil:
+			status := node.Node().Status()
+			return []info.Field{
+				{Name: "role", Value: "raft"},
+				{Name: "raft_id", Value: status.ID},
+				{Name: "raft_state", Value: status.State},
+				{Name: "raft_term", Value: status.Term},
+				{Name: "raft_leader", Value: status.Leader},
+				{Name: "raft_snapshot_index", Value: status.SnapshotIndex},
+				{Name: "raft_commit_index", Value: status.CommitIndex},
+				{Name: "raft_last_applied", Value: status.LastApplied},
+				{Name: "raft_peers", Value: len(status.Peers)},
+			}
+		}
+		return nil
+	})
+}
+
+func httpServer(port string, cfg config.Auth, tlsCfg config.TLS) *httpserver.Server {
+	http := httpserver.NewServer(port, nil, cache)
+	http.SetUsers(users)
+	http.SetGuard(guard)
+	http.SetMetrics(stats)
+	http.SetInfo(about)
+	http.SetSlowlog(slow)
+	http.SetTier(tiered())
+	http.SetInspector(inspector())
+	http.SetLogger(logger)
+	http.SetAudit(httpAudit)
+	http.SetTracer(tracer)
+	if certs != nil {
+		http.SetTLSConfig(serverTLSConfig(tlsCfg))
+		http.SetPeerTLSConfig(peerTLSConfig(tlsCfg))
 	}
+	groups := map[string]string{httpserver.GroupCache: cfg.CacheMethods, httpserver.GroupAdmin: cfg.AdminMethods}
+	for group, methods := range groups {
+		var authenticators []httpserver.Authenticator
+		for _, method := range config.Methods(methods) {
+			switch method {
+			case config.MethodBasic:
+				authenticators = append(authenticators, http.BasicAuth())
+			case config.MethodToken:
+				authenticators = append(authenticators, httpserver.TokenAuth(tokens))
+			case config.MethodCert:
+				authenticators = append(authenticators, http.CertAuth())
+			}
+		}
+		http.SetAuthenticators(group, authenticators...)
+	}
+	return http
 }
 
 func httpDevRun() {
 	ttt := mapbased.TestTestStorage()
-	http := httpserver.NewServer(flags.httpAddr, accounts, ttt)
+	http := httpserver.NewServer(flags.httpAddr, config.Default().Auth.Accounts, ttt)
 	if err := http.Run(); err != nil {
 		log.Fatalln(err)
 	}
 }
 
-func tcpRun() {
-	tcp := tcpserver.NewServer(flags.tcpAddr, cache)
-	if err := tcp.Run(); err != nil {
-		log.Fatalln(err)
+func tcpServer(port string, tlsCfg config.TLS) *tcpserver.Server {
+	tcp := tcpserver.NewServer(port, cache)
+	tcp.SetGuard(guard)
+	tcp.SetUsers(users)
+	tcp.SetMetrics(stats)
+	tcp.SetInfo(about)
+	tcp.SetSlowlog(slow)
+	tcp.SetTier(tiered())
+	tcp.SetInspector(inspector())
+	tcp.SetLogger(logger)
+	tcp.SetAudit(tcpAudit)
+	tcp.SetTracer(tracer)
+	if certs != nil {
+		tcp.SetTLSConfig(serverTLSConfig(tlsCfg))
+	}
+	if leader != nil {
+		tcp.Handle("sync", leader)
+	}
+	if node != nil {
+		tcp.Handle("raft", node)
+	}
+	if slots != nil {
+		tcp.Handle("cluster", slots)
 	}
+	return tcp
 }
 
 //TODO Заменить типы string, []string, map[string]string  на собственные алиасы этих типов
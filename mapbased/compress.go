@@ -0,0 +1,176 @@
+package mapbased
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"sync"
+	"sync/atomic"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// compressed строка или список, сжатые deflate. Не изменяется после создания
+type compressed struct {
+	typ structs.ValueType
+	// size размер несжатого значения
+	size int64
+	data []byte
+}
+
+var errCorrupted = errors.New("mapbased: corrupted compressed value")
+
+// writers сжатие требует заметной памяти на состояние, поэтому писатели переиспользуются
+var writers = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// SetCompression сжатие строк и списков размером не меньше threshold байт, 0 - без сжатия.
+// Значение хранится сжатым, только если оно стало меньше. Действует на последующие записи,
+// может вызываться во время работы
+func (s *Storage) SetCompression(threshold int64) {
+	atomic.StoreInt64(&s.compressThreshold, threshold)
+}
+
+// compress представление значения для хранения: сжатое, если значение не меньше порога сжатия.
+// Вызывается без блокировки
+func (s *Storage) compress(val interface{}) interface{} {
+	threshold := atomic.LoadInt64(&s.compressThreshold)
+	if threshold <= 0 {
+		return val
+	}
+	size := valueSize(val)
+	if size < threshold {
+		return val
+	}
+	typ, _ := valueType(val)
+	var raw []byte
+	switch v := val.(type) {
+	case string:
+		raw = []byte(v)
+	case []string:
+		raw = encodeList(v, size)
+	default:
+		return val
+	}
+
+	var buf bytes.Buffer
+	w := writers.Get().(*flate.Writer)
+	w.Reset(&buf)
+	w.Write(raw)
+	w.Close()
+	writers.Put(w)
+	if int64(buf.Len()) >= size {
+		return val
+	}
+	// Буфер растёт с запасом, хранится копия точного размера
+	return &compressed{typ: typ, size: size, data: append([]byte(nil), buf.Bytes()...)}
+}
+
+// value распакованное значение. Данные созданы хранилищем, ошибка распаковки означает повреждение
+// памяти: она записывается в журнал, возвращается нулевое значение
+func (c *compressed) value() interface{} {
+	val, err := c.decode()
+	if err != nil {
+		log.Printf("mapbased: %v", err)
+		if c.typ == structs.List {
+			return []string{}
+		}
+		return ""
+	}
+	return val
+}
+
+func (c *compressed) decode() (interface{}, error) {
+	r := flate.NewReader(bytes.NewReader(c.data))
+	defer r.Close()
+	raw, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if c.typ == structs.String {
+		return string(raw), nil
+	}
+	return decodeList(raw)
+}
+
+// expand значение в исходном виде: сжатое значение распаковывается
+func expand(val interface{}) interface{} {
+	if c, ok := val.(*compressed); ok {
+		return c.value()
+	}
+	return val
+}
+
+// encodeList список в виде количества элементов и элементов с длиной (uvarint)
+func encodeList(list []string, size int64) []byte {
+	buf := make([]byte, 0, int(size)+binary.MaxVarintLen64*(len(list)+1))
+	buf = binary.AppendUvarint(buf, uint64(len(list)))
+	for _, item := range list {
+		buf = binary.AppendUvarint(buf, uint64(len(item)))
+		buf = append(buf, item...)
+	}
+	return buf
+}
+
+func decodeList(buf []byte) ([]string, error) {
+	r := bytes.NewReader(buf)
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(len(buf)) {
+		return nil, errCorrupted
+	}
+	list := make([]string, n)
+	for i := range list {
+		l, err := binary.ReadUvarint(r)
+		if err != nil || l > uint64(r.Len()) {
+			return nil, errCorrupted
+		}
+		item := make([]byte, l)
+		if _, err := io.ReadFull(r, item); err != nil {
+			return nil, errCorrupted
+		}
+		list[i] = string(item)
+	}
+	if r.Len() != 0 {
+		return nil, errCorrupted
+	}
+	return list, nil
+}
+
+// Inspect сведения о представлении значения ключа: кодировка, размер и степень сжатия,
+// место хранения. Не учитывается в статистике чтений
+func (s *Storage) Inspect(key string) (structs.KeyInfo, error) {
+	s.rlock()
+	defer s.RUnlock()
+	val, ok := s.data[key]
+	if !ok {
+		return structs.KeyInfo{}, structs.ErrKeyNotFound
+	}
+
+	info := structs.KeyInfo{Encoding: structs.EncodingRaw, Pinned: s.tier.isPinned(key)}
+	switch v := val.(type) {
+	case *compressed:
+		info.Type, info.Encoding = v.typ, structs.EncodingDeflate
+		info.Size, info.StoredSize = v.size, int64(len(v.data))
+	case *spilled:
+		// Значения на диске хранятся без сжатия
+		info.Type, info.OnDisk = v.typ, true
+		info.Size, info.StoredSize = v.raw, v.raw
+	case *stream:
+		info.Type = structs.Stream
+		info.Size = valueSize(v)
+		info.StoredSize = info.Size
+	default:
+		info.Type, _ = valueType(v)
+		info.Size = valueSize(v)
+		info.StoredSize = info.Size
+	}
+	return info, nil
+}
@@ -0,0 +1,139 @@
+package mapbased_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// blob JSON размером около 4 КБ, хорошо поддающийся сжатию
+func blob(i int) string {
+	items := make([]string, 64)
+	for j := range items {
+		items[j] = fmt.Sprintf(`{"id":%d,"name":"user %d","active":true}`, j, i)
+	}
+	return "[" + strings.Join(items, ",") + "]"
+}
+
+func TestStorage_Compression(t *testing.T) {
+	s := mapbased.NewStorage()
+	defer s.Close()
+	s.SetCompression(1024)
+	list := make([]string, 200)
+	for i := range list {
+		list[i] = fmt.Sprintf("item %d of a long list", i%10)
+	}
+	s.PutOrUpdateString("blob", blob(1))
+	s.PutOrUpdateString("small", "value")
+	s.PutOrUpdateList("list", list)
+
+	tests := []struct {
+		name     string
+		key      string
+		want     interface{}
+		encoding string
+	}{
+		{name: "large string", key: "blob", want: blob(1), encoding: structs.EncodingDeflate},
+		{name: "small string", key: "small", want: "value", encoding: structs.EncodingRaw},
+		{name: "large list", key: "list", want: list, encoding: structs.EncodingDeflate},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			if val, err := s.GetElement(tt.key); err != nil || !reflect.DeepEqual(val, tt.want) {
+				t.Errorf("GetElement() = %.40v, %v, want %.40v", val, err, tt.want)
+			}
+			info, err := s.Inspect(tt.key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Encoding != tt.encoding {
+				t.Errorf("Inspect() encoding = %s, want %s", info.Encoding, tt.encoding)
+			}
+			if tt.encoding == structs.EncodingDeflate && (info.StoredSize >= info.Size || info.Ratio() <= 1) {
+				t.Errorf("Inspect() = %+v, ratio %.2f, want a smaller stored size", info, info.Ratio())
+			}
+		})
+	}
+
+	if item, err := s.GetListElement("list", 13); err != nil || item != list[13] {
+		t.Errorf("GetListElement() = %q, %v, want %q", item, err, list[13])
+	}
+	if typ, err := s.GetType("list"); err != nil || typ != structs.List {
+		t.Errorf("GetType() = %v, %v, want %v", typ, err, structs.List)
+	}
+	if got := s.Stats().MemoryBytes; got >= int64(len(blob(1))) {
+		t.Errorf("Stats() memory = %d, want less than the raw blob %d", got, len(blob(1)))
+	}
+	if prev, ok := s.PutOrUpdateString("blob", "new"); !ok || prev != blob(1) {
+		t.Errorf("PutOrUpdateString() previous = %.40q, %v, want the blob", prev, ok)
+	}
+	if _, err := s.Inspect("missing"); err != structs.ErrKeyNotFound {
+		t.Errorf("Inspect() error = %v, want %v", err, structs.ErrKeyNotFound)
+	}
+
+	// Снимок содержит значения в исходном виде
+	restored := mapbased.NewStorage()
+	defer restored.Close()
+	restored.SetCompression(1024)
+	restored.Load(s.Dump())
+	if !reflect.DeepEqual(restored.Dump(), s.Dump()) {
+		t.Errorf("Dump() after Load() differs from the source")
+	}
+	if info, _ := restored.Inspect("list"); info.Encoding != structs.EncodingDeflate {
+		t.Errorf("Inspect() after Load() encoding = %s, want %s", info.Encoding, structs.EncodingDeflate)
+	}
+
+	// Отключение сжатия не меняет сохранённые значения
+	s.SetCompression(0)
+	s.PutOrUpdateString("blob", blob(2))
+	if info, _ := s.Inspect("blob"); info.Encoding != structs.EncodingRaw {
+		t.Errorf("Inspect() without compression encoding = %s, want %s", info.Encoding, structs.EncodingRaw)
+	}
+	if info, _ := s.Inspect("list"); info.Encoding != structs.EncodingDeflate {
+		t.Errorf("Inspect() of a stored value encoding = %s, want %s", info.Encoding, structs.EncodingDeflate)
+	}
+}
+
+func TestStorage_CompressionTier(t *testing.T) {
+	s := tiered(t, 2048)
+	s.SetCompression(1024)
+	for i := 0; i < 20; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("blob:%02d", i), blob(i))
+	}
+	stats := tierStats(t, s)
+	// Сжатые значения занимают в памяти меньше, поэтому их помещается больше одного
+	if stats.MemoryKeys < 2 || stats.MemoryBytes > stats.MaxMemory {
+		t.Errorf("TierStats() = %+v, want several compressed values in memory", stats)
+	}
+
+	info, err := s.Inspect("blob:00")
+	if err != nil || !info.OnDisk || info.Size != int64(len(blob(0))) {
+		t.Errorf("Inspect() = %+v, %v, want a value of %d bytes on disk", info, err, len(blob(0)))
+	}
+	if val, err := s.GetElement("blob:00"); err != nil || val != blob(0) {
+		t.Errorf("GetElement() = %.40v, %v, want the blob", val, err)
+	}
+	if info, _ := s.Inspect("blob:00"); info.OnDisk || info.Encoding != structs.EncodingDeflate {
+		t.Errorf("Inspect() after read = %+v, want a compressed value in memory", info)
+	}
+}
+
+func BenchmarkStorage_Compression(b *testing.B) {
+	for _, threshold := range []int64{0, 1024} {
+		b.Run(fmt.Sprintf("threshold %d", threshold), func(b *testing.B) {
+			s := mapbased.NewStorage()
+			defer s.Close()
+			s.SetCompression(threshold)
+			value := blob(1)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				s.PutOrUpdateString("blob", value)
+				s.GetElement("blob")
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+package mapbased_test
+
+import (
+	"testing"
+
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/storagetest"
+	"github.com/geraev/gokvserver/structs"
+)
+
+func newStorage(*testing.T) structs.Storage {
+	return mapbased.NewStorage()
+}
+
+// newTieredStorage хранилище, выносящее на диск почти все значения
+func newTieredStorage(t *testing.T) structs.Storage {
+	s := mapbased.NewStorage()
+	if err := s.SetTier(t.TempDir(), 64); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// newCompressedStorage хранилище, сжимающее все строки и списки, и с выносом на диск
+func newCompressedStorage(t *testing.T) structs.Storage {
+	s := newTieredStorage(t).(*mapbased.Storage)
+	s.SetCompression(1)
+	return s
+}
+
+func TestStorage_Conformance(t *testing.T) {
+	tests := []struct {
+		name       string
+		newStorage storagetest.Factory
+	}{
+		{name: "memory", newStorage: newStorage},
+		{name: "tiered", newStorage: newTieredStorage},
+		{name: "compressed", newStorage: newCompressedStorage},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			storagetest.Run(t, tt.newStorage)
+		})
+	}
+}
+
+func TestStorage_Stress(t *testing.T) {
+	tests := []struct {
+		name       string
+		newStorage storagetest.Factory
+	}{
+		{name: "memory", newStorage: newStorage},
+		{name: "tiered", newStorage: newTieredStorage},
+		{name: "compressed", newStorage: newCompressedStorage},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			storagetest.Stress(t, tt.newStorage)
+		})
+	}
+}
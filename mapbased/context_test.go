@@ -0,0 +1,148 @@
+package mapbased
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+func TestStorage_Context(t *testing.T) {
+	s := NewStorage()
+	defer s.Close()
+	s.PutOrUpdateList("list", []string{"a"})
+
+	tests := []struct {
+		name string
+		call func(ctx context.Context) error
+	}{
+		{name: "GetKeysContext", call: func(ctx context.Context) error {
+			_, err := s.GetKeysContext(ctx)
+			return err
+		}},
+		{name: "GetElementContext", call: func(ctx context.Context) error {
+			_, err := s.GetElementContext(ctx, "list")
+			return err
+		}},
+		{name: "GetListElementContext", call: func(ctx context.Context) error {
+			_, err := s.GetListElementContext(ctx, "list", 0)
+			return err
+		}},
+		{name: "GetTypeContext", call: func(ctx context.Context) error {
+			_, err := s.GetTypeContext(ctx, "list")
+			return err
+		}},
+		{name: "PutOrUpdateStringContext", call: func(ctx context.Context) error {
+			_, _, err := s.PutOrUpdateStringContext(ctx, "str", "value")
+			return err
+		}},
+		{name: "RemoveElementContext", call: func(ctx context.Context) error {
+			return s.RemoveElementContext(ctx, "list")
+		}},
+		{name: "SetExpiredContext", call: func(ctx context.Context) error {
+			return s.SetExpiredContext(ctx, "list", 1)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name+": lock wait deadline", func(t *testing.T) {
+			s.Lock()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			err := tt.call(ctx)
+			s.Unlock()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s() error = %v, want %v", tt.name, err, context.DeadlineExceeded)
+			}
+		})
+		t.Run("Testing "+tt.name+": cancelled", func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if err := tt.call(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s() error = %v, want %v", tt.name, err, context.Canceled)
+			}
+		})
+	}
+
+	// Прерванные операции не меняют данные, а блокировка после них свободна
+	if got, err := s.GetListElement("list", 0); err != nil || got != "a" {
+		t.Errorf("GetListElement() = %q, %v, want a", got, err)
+	}
+	if _, err := s.GetElement("str"); !errors.Is(err, structs.ErrKeyNotFound) {
+		t.Errorf("GetElement() error = %v, want %v", err, structs.ErrKeyNotFound)
+	}
+
+	var waited time.Duration
+	s.Lock()
+	time.AfterFunc(10*time.Millisecond, s.Unlock)
+	ctx := structs.WithLockWait(context.Background(), func(wait time.Duration) { waited = wait })
+	if _, err := s.GetElementContext(ctx, "list"); err != nil {
+		t.Fatal(err)
+	}
+	if waited < 10*time.Millisecond {
+		t.Errorf("reported lock wait %s, want at least 10ms", waited)
+	}
+}
+
+func TestStorage_LoadContext(t *testing.T) {
+	entries := make([]structs.Entry, 3*loadChunk)
+	for i := range entries {
+		entries[i] = structs.Entry{Key: "key" + strconv.Itoa(i), Type: structs.String, Value: "v"}
+	}
+
+	s := NewStorage()
+	defer s.Close()
+	if err := s.LoadContext(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+	if s.Len() != len(entries) {
+		t.Errorf("Len() = %d, want %d", s.Len(), len(entries))
+	}
+
+	cancelled := NewStorage()
+	defer cancelled.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := cancelled.LoadContext(ctx, entries); !errors.Is(err, context.Canceled) {
+		t.Errorf("LoadContext() error = %v, want %v", err, context.Canceled)
+	}
+	if cancelled.Len() != 0 {
+		t.Errorf("cancelled load added %d keys", cancelled.Len())
+	}
+}
+
+func TestStorage_StreamReadContext(t *testing.T) {
+	s := newStreamStorage(t, "events", "1-0")
+	if err := s.StreamGroupCreate("events", "group", "$", false); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		read func(ctx context.Context) error
+	}{
+		{name: "StreamReadContext", read: func(ctx context.Context) error {
+			_, err := s.StreamReadContext(ctx, []string{"events"}, []string{"$"}, 0, time.Minute)
+			return err
+		}},
+		{name: "StreamReadGroupContext", read: func(ctx context.Context) error {
+			_, err := s.StreamReadGroupContext(ctx, "group", "consumer", []string{"events"}, []string{">"}, 0, time.Minute, false)
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(20*time.Millisecond, cancel)
+			start := time.Now()
+			if err := tt.read(ctx); !errors.Is(err, context.Canceled) {
+				t.Errorf("%s() error = %v, want %v", tt.name, err, context.Canceled)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("%s() returned after %s", tt.name, d)
+			}
+		})
+	}
+}
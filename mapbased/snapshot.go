@@ -0,0 +1,169 @@
+package mapbased
+
+import (
+	"context"
+	"log"
+	"sort"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Dump полный снимок хранилища, упорядоченный по ключам. Списки и словари снимка общие с хранилищем
+// и только читаются: хранилище не изменяет сохранённые значения на месте
+func (s *Storage) Dump() []structs.Entry {
+	s.rlock()
+	defer s.RUnlock()
+
+	result := make([]structs.Entry, 0, len(s.data))
+	for key, val := range s.data {
+		if entry, ok := s.entry(key, val); ok {
+			result = append(result, entry)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Key < result[j].Key
+	})
+	return result
+}
+
+// DumpKey снимок одного ключа с копией значения. Значения с диска читаются без возврата в память
+func (s *Storage) DumpKey(key string) (structs.Entry, error) {
+	s.rlock()
+	defer s.RUnlock()
+
+	val, ok := s.data[key]
+	if !ok {
+		return structs.Entry{}, structs.ErrKeyNotFound
+	}
+	entry, ok := s.entry(key, val)
+	if !ok {
+		return structs.Entry{}, structs.ErrType
+	}
+	switch v := entry.Value.(type) {
+	case []string:
+		entry.Value = copyList(v)
+	case map[string]string:
+		entry.Value = copyDictionary(v)
+	}
+	return entry, nil
+}
+
+// entry запись снимка для значения val. Вызывается под блокировкой
+func (s *Storage) entry(key string, val interface{}) (structs.Entry, bool) {
+	entry := structs.Entry{Key: key, Expired: s.expired[key]}
+	if _, ok := val.(*spilled); ok {
+		v, err := s.resolve(key, val)
+		if err != nil {
+			log.Printf("mapbased: dump: %v", err)
+			return entry, false
+		}
+		val = v
+	}
+	val = expand(val)
+	switch v := val.(type) {
+	case string:
+		entry.Type, entry.Value = structs.String, v
+	case []string:
+		entry.Type, entry.Value = structs.List, v
+	case map[string]string:
+		entry.Type, entry.Value = structs.Dictionary, v
+	case *stream:
+		entries := make([]structs.StreamEntry, len(v.entries))
+		copy(entries, v.entries)
+		entry.Type, entry.Value, entry.Groups = structs.Stream, entries, v.dumpGroups()
+	default:
+		return entry, false
+	}
+	return entry, true
+}
+
+// Load загрузка записей снимка. Существующие ключи перезаписываются, значения записей переходят
+// хранилищу и не должны изменяться после загрузки
+func (s *Storage) Load(entries []structs.Entry) {
+	s.LoadContext(context.Background(), entries)
+}
+
+// loadChunk количество записей, загружаемых под одной блокировкой
+const loadChunk = 1024
+
+// LoadContext загрузка записей снимка частями по loadChunk. Между частями блокировка освобождается,
+// а загрузка прерывается отменой ctx; уже загруженные записи остаются в хранилище
+func (s *Storage) LoadContext(ctx context.Context, entries []structs.Entry) error {
+	for len(entries) > 0 {
+		n := loadChunk
+		if n > len(entries) {
+			n = len(entries)
+		}
+		if err := s.lockContext(ctx, true); err != nil {
+			return err
+		}
+		s.load(entries[:n])
+		s.notifyStreams()
+		s.Unlock()
+		entries = entries[n:]
+	}
+	return nil
+}
+
+// load загрузка записей под блокировкой на запись
+func (s *Storage) load(entries []structs.Entry) {
+	if s.expired == nil {
+		s.expired = make(map[string]uint64)
+	}
+	for _, entry := range entries {
+		var val interface{}
+		switch v := entry.Value.(type) {
+		case string, []string:
+			val = s.compress(v)
+		case map[string]string:
+			val = v
+		case []structs.StreamEntry:
+			st := newStream()
+			st.entries = v
+			if len(v) > 0 {
+				st.lastID = v[len(v)-1].ID
+			}
+			st.loadGroups(entry.Groups)
+			val = st
+		default:
+			continue
+		}
+		if old, ok := s.data[entry.Key]; ok {
+			s.tier.forget(entry.Key, old)
+		}
+		s.data[entry.Key] = val
+		s.tier.track(entry.Key, val)
+		if entry.Expired != 0 {
+			s.expired[entry.Key] = entry.Expired
+		} else {
+			delete(s.expired, entry.Key)
+		}
+	}
+	s.spill()
+}
+
+// Flush удаление всех ключей
+func (s *Storage) Flush() {
+	s.lock()
+	s.data = make(map[string]interface{})
+	s.expired = make(map[string]uint64)
+	s.tier.clear()
+	s.Unlock()
+}
+
+// ExpireAt установка момента истечения ключа (UnixNano)
+func (s *Storage) ExpireAt(key string, deadline uint64) {
+	if deadline == 0 {
+		return
+	}
+	s.lock()
+	if _, ok := s.data[key]; !ok {
+		s.Unlock()
+		return
+	}
+	if s.expired == nil {
+		s.expired = make(map[string]uint64)
+	}
+	s.expired[key] = deadline
+	s.Unlock()
+}
@@ -0,0 +1,77 @@
+package mapbased
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+func TestSnapshotFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "dump.json")
+
+	s := NewStorage()
+	defer s.Close()
+	s.PutOrUpdateString("str", "value")
+	s.PutOrUpdateList("list", []string{"a", "b"})
+	s.PutOrUpdateDictionary("dict", map[string]string{"k": "v"})
+	s.ExpireAt("str", uint64(time.Now().Add(time.Hour).UnixNano()))
+	if _, err := s.StreamAdd("stream", "*", map[string]string{"f": "v"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		save bool
+		want []structs.Entry
+	}{
+		{name: "missing file", want: []structs.Entry{}},
+		{name: "saved snapshot", save: true, want: s.Dump()},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			if tt.save {
+				if err := structs.SaveSnapshot(path, s); err != nil {
+					t.Fatalf("SaveSnapshot() error = %v", err)
+				}
+			}
+			loaded := NewStorage()
+			defer loaded.Close()
+			if err := structs.LoadSnapshot(path, loaded); err != nil {
+				t.Fatalf("LoadSnapshot() error = %v", err)
+			}
+			if got := loaded.Dump(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Dump() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if files, _ := ioutil.ReadDir(dir); len(files) != 1 {
+		t.Errorf("temporary files are left: %d files", len(files))
+	}
+}
+
+func TestStorage_LoadClearsExpiry(t *testing.T) {
+	s := NewStorage()
+	defer s.Close()
+	s.PutOrUpdateString("str", "old")
+	s.ExpireAt("str", uint64(time.Now().Add(time.Hour).UnixNano()))
+
+	s.Load([]structs.Entry{{Key: "str", Type: structs.String, Value: "new"}})
+	entry, err := s.DumpKey("str")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry.Expired != 0 {
+		t.Errorf("DumpKey() after Load() expired = %d, want 0", entry.Expired)
+	}
+}
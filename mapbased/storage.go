@@ -1,18 +1,40 @@
 package mapbased
 
 import (
-	"errors"
+	"context"
+	"github.com/geraev/gokvserver/internal/backend"
 	"github.com/geraev/gokvserver/structs"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// scanCheckInterval количество ключей между проверками отмены при обходе хранилища
+const scanCheckInterval = 1024
+
 type Storage struct {
 	*sync.RWMutex
 	data    map[string]interface{}
 	expired map[string]uint64
-	janitor *janitor
+	signal  chan struct{}
+	janitor *backend.Janitor
+	// tier дисковый уровень, nil - все значения в памяти
+	tier *tier
+
+	// observer observerBox, заменяется во время работы
+	observer atomic.Value
+	// expiredKeys ключи, удалённые по истечении срока жизни
+	expiredKeys uint64
+	// hits, misses чтения существующих и отсутствующих ключей
+	hits, misses uint64
+	// compressThreshold размер строк и списков, с которого они хранятся сжатыми, 0 - без сжатия
+	compressThreshold int64
+}
+
+// observerBox обёртка для atomic.Value, которому нужен один конкретный тип
+type observerBox struct {
+	structs.StorageObserver
 }
 
 func NewStorage() *Storage {
@@ -24,7 +46,7 @@ func NewStorage() *Storage {
 	//S := &struct {
 	//	*Storage
 	//}{s}
-	runJanitor(s, time.Millisecond*20)
+	s.janitor = backend.RunJanitor(time.Millisecond*20, s.DeleteExpired)
 	//runtime.SetFinalizer(S, stopJanitor)
 	return s
 }
@@ -44,65 +66,186 @@ func TestTestStorage() *Storage {
 	}
 }
 
+// SetObserver получатель событий хранилища (ожидание блокировки, работа janitor), nil - без событий.
+// Может вызываться во время работы
+func (s *Storage) SetObserver(o structs.StorageObserver) {
+	s.observer.Store(observerBox{o})
+}
+
+func (s *Storage) loadObserver() structs.StorageObserver {
+	box, _ := s.observer.Load().(observerBox)
+	return box.StorageObserver
+}
+
+// lock блокировка на запись
+func (s *Storage) lock() {
+	s.lockContext(context.Background(), true)
+}
+
+// rlock блокировка на чтение
+func (s *Storage) rlock() {
+	s.lockContext(context.Background(), false)
+}
+
+// lockContext блокировка на запись (write) или чтение, ожидание которой прерывается отменой ctx.
+// Время ожидания передаётся получателю событий и получателю из контекста (structs.WithLockWait)
+func (s *Storage) lockContext(ctx context.Context, write bool) error {
+	return backend.LockContext(ctx, s.RWMutex, write, s.loadObserver())
+}
+
+// Stats статистика хранилища. Оценка памяти требует обхода всех значений, сжатые значения
+// учитываются по сжатому размеру, значения на диске в неё не входят
+func (s *Storage) Stats() structs.Stats {
+	s.rlock()
+	defer s.RUnlock()
+
+	stats := structs.Stats{
+		Keys:           make(map[structs.ValueType]int),
+		ExpiredKeys:    atomic.LoadUint64(&s.expiredKeys),
+		KeyspaceHits:   atomic.LoadUint64(&s.hits),
+		KeyspaceMisses: atomic.LoadUint64(&s.misses),
+	}
+	for key := range s.expired {
+		// Срок жизни удалённого ключа остаётся в expired до истечения
+		if _, ok := s.data[key]; ok {
+			stats.KeysWithTTL++
+		}
+	}
+	for key, val := range s.data {
+		switch v := val.(type) {
+		case string:
+			stats.Keys[structs.String]++
+		case []string:
+			stats.Keys[structs.List]++
+		case map[string]string:
+			stats.Keys[structs.Dictionary]++
+		case *stream:
+			stats.Keys[structs.Stream]++
+		case *compressed:
+			stats.Keys[v.typ]++
+		case *spilled:
+			stats.Keys[v.typ]++
+		}
+		stats.MemoryBytes += int64(len(key)) + valueSize(val)
+	}
+	return stats
+}
+
+// lookup учёт чтения ключа: found - ключ существует
+func (s *Storage) lookup(found bool) {
+	if found {
+		atomic.AddUint64(&s.hits, 1)
+	} else {
+		atomic.AddUint64(&s.misses, 1)
+	}
+}
+
+// Len количество ключей
+func (s *Storage) Len() int {
+	s.rlock()
+	defer s.RUnlock()
+	return len(s.data)
+}
+
+// copyList копия списка. Хранилище не делит списки и словари с вызывающим кодом и не изменяет
+// сохранённые значения на месте
+func copyList(list []string) []string {
+	if list == nil {
+		return nil
+	}
+	return append(make([]string, 0, len(list)), list...)
+}
+
+// copyDictionary копия словаря
+func copyDictionary(dict map[string]string) map[string]string {
+	if dict == nil {
+		return nil
+	}
+	result := make(map[string]string, len(dict))
+	for k, v := range dict {
+		result[k] = v
+	}
+	return result
+}
+
 // GetKeys получение списка ключей
 func (s *Storage) GetKeys() []string {
-	s.RLock()
+	keys, _ := s.GetKeysContext(context.Background())
+	return keys
+}
+
+// GetKeysContext получение списка ключей. Обход прерывается отменой ctx
+func (s *Storage) GetKeysContext(ctx context.Context) ([]string, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return nil, err
+	}
 	defer s.RUnlock()
 
 	if len(s.data) == 0 {
-		return []string{}
+		return []string{}, nil
 	}
 
 	result := make([]string, 0, len(s.data))
 	for key := range s.data {
+		if len(result)%scanCheckInterval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
 		result = append(result, key)
 	}
 	sort.Strings(result)
-	return result
+	return result, nil
 }
 
-// GetElement получение элемента по ключу
+// GetElement получение элемента по ключу. Списки и словари возвращаются копиями
 func (s *Storage) GetElement(key string) (interface{}, error) {
-	s.RLock()
-	//defer s.RUnlock()
+	return s.GetElementContext(context.Background(), key)
+}
 
-	val, ok := s.data[key]
-	if !ok {
-		s.RUnlock()
-		return nil, errors.New("key not found")
+func (s *Storage) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	val, err := s.get(ctx, key)
+	if err != nil {
+		return nil, err
 	}
 
 	switch v := val.(type) {
-	case string, []string, map[string]string:
-		s.RUnlock()
+	case string:
 		return v, nil
+	case []string:
+		return copyList(v), nil
+	case map[string]string:
+		return copyDictionary(v), nil
 	default:
-		s.RUnlock()
-		return "", errors.New("something wrong: type error")
+		return "", structs.ErrType
 	}
 }
 
 // GetListElement получение по индексу одного элемента из списка
 func (s *Storage) GetListElement(key string, index int) (string, error) {
-	s.RLock()
-	defer s.RUnlock()
+	return s.GetListElementContext(context.Background(), key, index)
+}
 
+func (s *Storage) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
 	if index < 0 {
-		return "", errors.New("index out of range")
+		return "", structs.ErrIndexOutOfRange
 	}
 
-	val, ok := s.data[key]
-	if !ok {
-		return "", errors.New("key not found")
+	val, err := s.get(ctx, key)
+	if err != nil {
+		return "", err
 	}
 
 	v, ok := val.([]string)
 	if !ok {
-		return "", errors.New("something wrong: type error")
+		return "", structs.ErrType
 	}
 
 	if index >= len(v) {
-		return "", errors.New("index out of range")
+		return "", structs.ErrIndexOutOfRange
 	}
 
 	return v[index], nil
@@ -110,22 +253,23 @@ func (s *Storage) GetListElement(key string, index int) (string, error) {
 
 // GetDictionaryElement получение по ключу одного элемента из словаря
 func (s *Storage) GetDictionaryElement(key, internalKey string) (string, error) {
-	s.RLock()
-	defer s.RUnlock()
+	return s.GetDictionaryElementContext(context.Background(), key, internalKey)
+}
 
-	val, ok := s.data[key]
-	if !ok {
-		return "", errors.New("key not found")
+func (s *Storage) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	val, err := s.get(ctx, key)
+	if err != nil {
+		return "", err
 	}
 
 	v, ok := val.(map[string]string)
 	if !ok {
-		return "", errors.New("something wrong: type error")
+		return "", structs.ErrType
 	}
 
 	item, ok := v[internalKey]
 	if !ok {
-		return "", errors.New("key not found")
+		return "", structs.ErrKeyNotFound
 	}
 
 	return item, nil
@@ -134,56 +278,103 @@ func (s *Storage) GetDictionaryElement(key, internalKey string) (string, error)
 // PutOrUpdateString добавление либо обновление значения ключа. Если ключь уже существовал, то перавым аргументом
 // возвращается предыдущее значение ключа, а вторым аргументом возвращается true
 func (s *Storage) PutOrUpdateString(key, value string) (previousVal string, isUpdated bool) {
-	s.Lock()
+	previousVal, isUpdated, _ = s.PutOrUpdateStringContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateStringContext(ctx context.Context, key, value string) (previousVal string, isUpdated bool, err error) {
+	// Сжатие до блокировки
+	stored := s.compress(value)
+	if err := s.lockContext(ctx, true); err != nil {
+		return "", false, err
+	}
 	//defer s.Unlock()
 
+	// Значение другого типа заменяется, предыдущим значением возвращается нулевое
 	if val, ok := s.data[key]; ok {
-		previousVal = val.(string)
+		previousVal, _ = s.previous(key, val).(string)
 		isUpdated = ok
+		s.tier.forget(key, val)
 	}
-	s.data[key] = value
+	s.data[key] = stored
+	s.tier.track(key, stored)
+	s.spill()
 	s.Unlock()
-	return previousVal, isUpdated
+	return previousVal, isUpdated, nil
 }
 
 // PutOrUpdateList добавление либо обновление значения ключа. Если ключь уже существовал, то перавым аргументом
-// возвращается предыдущее значение ключа, а вторым аргументом возвращается true
+// возвращается предыдущее значение ключа, а вторым аргументом возвращается true.
+// Хранится копия value, предыдущее значение больше не принадлежит хранилищу и возвращается без копирования
 func (s *Storage) PutOrUpdateList(key string, value []string) (previousVal []string, isUpdated bool) {
-	s.Lock()
+	previousVal, isUpdated, _ = s.PutOrUpdateListContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateListContext(ctx context.Context, key string, value []string) (previousVal []string, isUpdated bool, err error) {
+	stored := s.compress(copyList(value))
+	if err := s.lockContext(ctx, true); err != nil {
+		return nil, false, err
+	}
 	//defer s.Unlock()
 
+	// Значение другого типа заменяется, предыдущим значением возвращается нулевое
 	if val, ok := s.data[key]; ok {
-		previousVal = val.([]string)
+		previousVal, _ = s.previous(key, val).([]string)
 		isUpdated = ok
+		s.tier.forget(key, val)
 	}
-	s.data[key] = value
-	sort.Strings(previousVal)
+	s.data[key] = stored
+	s.tier.track(key, stored)
+	s.spill()
 	s.Unlock()
-	return previousVal, isUpdated
+	return previousVal, isUpdated, nil
 }
 
 // PutOrUpdateDictionary добавление либо обновление значения ключа. Если ключь уже существовал, то перавым аргументом
-// возвращается предыдущее значение ключа, а вторым аргументом возвращается true
+// возвращается предыдущее значение ключа, а вторым аргументом возвращается true.
+// Хранится копия value, предыдущее значение больше не принадлежит хранилищу и возвращается без копирования
 func (s *Storage) PutOrUpdateDictionary(key string, value map[string]string) (previousVal map[string]string, isUpdated bool) {
-	s.Lock()
+	previousVal, isUpdated, _ = s.PutOrUpdateDictionaryContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (s *Storage) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (previousVal map[string]string, isUpdated bool, err error) {
+	if err := s.lockContext(ctx, true); err != nil {
+		return nil, false, err
+	}
 	//defer s.Unlock()
 
+	// Значение другого типа заменяется, предыдущим значением возвращается нулевое
 	if val, ok := s.data[key]; ok {
-		previousVal = val.(map[string]string)
+		previousVal, _ = s.previous(key, val).(map[string]string)
 		isUpdated = ok
+		s.tier.forget(key, val)
 	}
-	s.data[key] = value
+	s.data[key] = copyDictionary(value)
+	s.tier.track(key, s.data[key])
+	s.spill()
 	s.Unlock()
-	return previousVal, isUpdated
+	return previousVal, isUpdated, nil
 }
 
 // RemoveElement удаление элемента по ключу
 func (s *Storage) RemoveElement(key string) {
-	s.Lock()
+	s.RemoveElementContext(context.Background(), key)
+}
+
+func (s *Storage) RemoveElementContext(ctx context.Context, key string) error {
+	if err := s.lockContext(ctx, true); err != nil {
+		return err
+	}
 	//defer s.Unlock()
+	if val, ok := s.data[key]; ok {
+		s.tier.remove(key, val)
+	}
 	delete(s.data, key)
+	delete(s.expired, key)
 	s.Unlock()
-	return
+	return nil
 }
 
 // SetTTL установка TTL для ключа и удаление элемента после по прошествии времени.
@@ -194,58 +385,104 @@ func (s *Storage) SetTTL(key string, keyTTL uint64) {
 		return
 	}
 	time.AfterFunc(time.Millisecond*time.Duration(keyTTL), func() {
-		s.Lock()
+		s.lock()
+		if val, ok := s.data[key]; ok {
+			s.tier.remove(key, val)
+		}
 		delete(s.data, key)
 		s.Unlock()
 	})
 	return
 }
 
-// SetExpired установка TTL для ключа
+// SetExpired установка TTL для ключа в миллисекундах. Для отсутствующего ключа ничего не делает
 func (s *Storage) SetExpired(key string, expired uint64) {
+	s.SetExpiredContext(context.Background(), key, expired)
+}
+
+func (s *Storage) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
 	if expired == 0 {
-		return
+		return nil
+	}
+	if err := s.lockContext(ctx, true); err != nil {
+		return err
 	}
-	s.Lock()
 	//defer s.Unlock()
+	// Срок жизни отсутствующего ключа не переходит к ключу, созданному позже
+	if _, ok := s.data[key]; !ok {
+		s.Unlock()
+		return nil
+	}
 	e := time.Now().Add(time.Millisecond * time.Duration(expired)).UnixNano()
 	s.expired[key] = uint64(e)
 	s.Unlock()
-	return
+	return nil
+}
+
+// Close остановка фонового удаления просроченных ключей и закрытие дискового уровня.
+// Без дискового уровня данные остаются доступными
+func (s *Storage) Close() error {
+	s.janitor.Stop()
+	if s.tier != nil {
+		return s.tier.disk.Close()
+	}
+	return nil
 }
 
 // DeleteExpired удаление просроченых кдючей
 func (s *Storage) DeleteExpired() {
-	s.Lock()
+	start := time.Now()
+	s.lock()
 	//defer s.Unlock()
 
 	now := time.Now().UnixNano()
+	var n uint64
 	for key, expired := range s.expired {
 		if uint64(now) >= expired {
+			if val, ok := s.data[key]; ok {
+				s.tier.remove(key, val)
+			}
 			delete(s.data, key)
 			delete(s.expired, key)
+			n++
 		}
 	}
 	s.Unlock()
+	atomic.AddUint64(&s.expiredKeys, n)
+	if o := s.loadObserver(); o != nil {
+		o.JanitorRun(time.Since(start))
+	}
 }
 
 func (s *Storage) GetType(key string) (structs.ValueType, error) {
-	s.RLock()
+	return s.GetTypeContext(context.Background(), key)
+}
+
+func (s *Storage) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return 0, err
+	}
 	defer s.RUnlock()
 
 	val, ok := s.data[key]
 	if !ok {
-		return 0, errors.New("key not found")
+		return 0, structs.ErrKeyNotFound
 	}
 
-	switch val.(type) {
+	switch v := val.(type) {
 	case string:
 		return structs.String, nil
 	case []string:
 		return structs.List, nil
 	case map[string]string:
 		return structs.Dictionary, nil
+	case *stream:
+		return structs.Stream, nil
+	case *compressed:
+		return v.typ, nil
+	case *spilled:
+		return v.typ, nil
 	default:
-		return 0, errors.New("something wrong: type error")
+		return 0, structs.ErrType
 	}
 }
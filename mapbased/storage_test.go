@@ -611,3 +611,97 @@ func BenchmarkStorage_GetElement(b *testing.B) {
 		}
 	}
 }
+
+// benchmarkSizes размеры списков и словарей в тестах стоимости копирования значений
+var benchmarkSizes = []int{1, 10, 100, 1000}
+
+func benchmarkList(size int) []string {
+	list := make([]string, size)
+	for i := range list {
+		list[i] = "element_" + strconv.Itoa(i)
+	}
+	return list
+}
+
+func benchmarkDictionary(size int) map[string]string {
+	dict := make(map[string]string, size)
+	for i := 0; i < size; i++ {
+		dict["key_"+strconv.Itoa(i)] = "value_" + strconv.Itoa(i)
+	}
+	return dict
+}
+
+func BenchmarkStorage_PutOrUpdateList(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s := NewStorage()
+			defer s.Close()
+			list := benchmarkList(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.PutOrUpdateList("list", list)
+			}
+		})
+	}
+}
+
+func BenchmarkStorage_GetElementList(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s := NewStorage()
+			defer s.Close()
+			s.PutOrUpdateList("list", benchmarkList(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = s.GetElement("list")
+			}
+		})
+	}
+}
+
+func BenchmarkStorage_PutOrUpdateDictionary(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s := NewStorage()
+			defer s.Close()
+			dict := benchmarkDictionary(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.PutOrUpdateDictionary("dict", dict)
+			}
+		})
+	}
+}
+
+func BenchmarkStorage_GetElementDictionary(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s := NewStorage()
+			defer s.Close()
+			s.PutOrUpdateDictionary("dict", benchmarkDictionary(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = s.GetElement("dict")
+			}
+		})
+	}
+}
+
+func BenchmarkStorage_GetListElement(b *testing.B) {
+	for _, size := range benchmarkSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			s := NewStorage()
+			defer s.Close()
+			s.PutOrUpdateList("list", benchmarkList(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				_, _ = s.GetListElement("list", size-1)
+			}
+		})
+	}
+}
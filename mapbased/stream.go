@@ -0,0 +1,660 @@
+package mapbased
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+type stream struct {
+	entries []structs.StreamEntry
+	lastID  structs.StreamID
+	groups  map[string]*consumerGroup
+}
+
+type consumerGroup struct {
+	lastDelivered structs.StreamID
+	pending       map[structs.StreamID]*structs.PendingEntry
+}
+
+func newStream() *stream {
+	return &stream{groups: make(map[string]*consumerGroup)}
+}
+
+// size оценка размера записей потока в байтах: идентификатор - два uint64
+func (st *stream) size() int {
+	size := 0
+	for _, entry := range st.entries {
+		size += 16
+		for field, value := range entry.Fields {
+			size += len(field) + len(value)
+		}
+	}
+	return size
+}
+
+// nextID вычисление идентификатора новой записи. "*" - автоматическая генерация,
+// "<ms>-*" - генерация по заданному времени (используется при репликации через журнал)
+func (st *stream) nextID(id string) (structs.StreamID, error) {
+	if id == "*" || strings.HasSuffix(id, "-*") {
+		ms := uint64(time.Now().UnixNano() / int64(time.Millisecond))
+		if id != "*" {
+			var err error
+			if ms, err = strconv.ParseUint(strings.TrimSuffix(id, "-*"), 10, 64); err != nil {
+				return structs.StreamID{}, structs.ErrInvalidStreamID
+			}
+		}
+		if ms > st.lastID.Ms {
+			return structs.StreamID{Ms: ms}, nil
+		}
+		return structs.StreamID{Ms: st.lastID.Ms, Seq: st.lastID.Seq + 1}, nil
+	}
+
+	newID, err := structs.ParseStreamID(id, 0)
+	if err != nil {
+		return newID, err
+	}
+	if newID == structs.MinStreamID || !st.lastID.Less(newID) {
+		return newID, structs.ErrStreamIDTooSmall
+	}
+	return newID, nil
+}
+
+// trim удаление самых старых записей сверх maxLen. Возвращает количество удалённых записей
+func (st *stream) trim(maxLen int) int {
+	removed := len(st.entries) - maxLen
+	if removed <= 0 {
+		return 0
+	}
+	entries := make([]structs.StreamEntry, maxLen)
+	copy(entries, st.entries[removed:])
+	st.entries = entries
+	return removed
+}
+
+// search индекс первой записи с идентификатором не меньше id
+func (st *stream) search(id structs.StreamID) int {
+	return sort.Search(len(st.entries), func(i int) bool {
+		return !st.entries[i].ID.Less(id)
+	})
+}
+
+// get копия записи с идентификатором id
+func (st *stream) get(id structs.StreamID) (structs.StreamEntry, bool) {
+	i := st.search(id)
+	if i < len(st.entries) && st.entries[i].ID == id {
+		return copyEntry(st.entries[i]), true
+	}
+	return structs.StreamEntry{}, false
+}
+
+// rangeOf копии записей в интервале [start, end]
+func (st *stream) rangeOf(start, end structs.StreamID, count int) []structs.StreamEntry {
+	result := make([]structs.StreamEntry, 0)
+	for i := st.search(start); i < len(st.entries); i++ {
+		if end.Less(st.entries[i].ID) || (count > 0 && len(result) == count) {
+			break
+		}
+		result = append(result, copyEntry(st.entries[i]))
+	}
+	return result
+}
+
+// copyEntry копия записи потока: поля записи не разделяются с вызывающим кодом
+func copyEntry(entry structs.StreamEntry) structs.StreamEntry {
+	return structs.StreamEntry{ID: entry.ID, Fields: copyDictionary(entry.Fields)}
+}
+
+// after записи с идентификатором строго больше id
+func (st *stream) after(id structs.StreamID, count int) []structs.StreamEntry {
+	if id == structs.MaxStreamID {
+		return nil
+	}
+	next := structs.StreamID{Ms: id.Ms, Seq: id.Seq + 1}
+	if id.Seq == structs.MaxStreamID.Seq {
+		next = structs.StreamID{Ms: id.Ms + 1}
+	}
+	return st.rangeOf(next, structs.MaxStreamID, count)
+}
+
+// getStream получение потока по ключу. Вызывается под блокировкой
+func (s *Storage) getStream(key string) (*stream, error) {
+	val, ok := s.data[key]
+	if !ok {
+		return nil, nil
+	}
+	st, ok := val.(*stream)
+	if !ok {
+		return nil, structs.ErrType
+	}
+	return st, nil
+}
+
+// getGroup получение группы потребителей потока. Вызывается под блокировкой
+func (s *Storage) getGroup(key, group string) (*stream, *consumerGroup, error) {
+	st, err := s.getStream(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if st == nil {
+		return nil, nil, structs.ErrNoGroup
+	}
+	g, ok := st.groups[group]
+	if !ok {
+		return nil, nil, structs.ErrNoGroup
+	}
+	return st, g, nil
+}
+
+// notifyStreams пробуждение клиентов, ожидающих новых записей. Вызывается под блокировкой
+func (s *Storage) notifyStreams() {
+	if s.signal != nil {
+		close(s.signal)
+		s.signal = nil
+	}
+}
+
+// waitStreams повторяет чтение read до появления записей, истечения времени block либо отмены ctx
+func (s *Storage) waitStreams(ctx context.Context, block time.Duration, read func() (map[string][]structs.StreamEntry, error)) (map[string][]structs.StreamEntry, error) {
+	var timeout <-chan time.Time
+	if block > 0 {
+		timer := time.NewTimer(block)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		if err := s.lockContext(ctx, true); err != nil {
+			return nil, err
+		}
+		result, err := read()
+		if err != nil || len(result) > 0 || block <= 0 {
+			s.Unlock()
+			return result, err
+		}
+		if s.signal == nil {
+			s.signal = make(chan struct{})
+		}
+		signal := s.signal
+		s.Unlock()
+
+		select {
+		case <-signal:
+		case <-timeout:
+			return result, nil
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// StreamAdd добавление записи в поток. Поток создаётся, если его не было. Поля записи копируются
+func (s *Storage) StreamAdd(key, id string, fields map[string]string) (structs.StreamID, error) {
+	return s.StreamAddTrim(key, id, fields, -1)
+}
+
+// StreamAddTrim добавление записи и удаление самых старых записей сверх maxLen под одной блокировкой.
+// Отрицательный maxLen - без удаления
+func (s *Storage) StreamAddTrim(key, id string, fields map[string]string, maxLen int) (structs.StreamID, error) {
+	s.lock()
+	defer s.Unlock()
+
+	st, err := s.getStream(key)
+	if err != nil {
+		return structs.StreamID{}, err
+	}
+	if st == nil {
+		st = newStream()
+	}
+
+	newID, err := st.nextID(id)
+	if err != nil {
+		return newID, err
+	}
+	st.entries = append(st.entries, structs.StreamEntry{ID: newID, Fields: copyDictionary(fields)})
+	st.lastID = newID
+	if maxLen >= 0 {
+		st.trim(maxLen)
+	}
+	s.data[key] = st
+	s.notifyStreams()
+	return newID, nil
+}
+
+// StreamRange получение записей потока в интервале идентификаторов [start, end]
+func (s *Storage) StreamRange(key, start, end string, count int) ([]structs.StreamEntry, error) {
+	from, err := parseRangeID(start, "-", structs.MinStreamID, 0)
+	if err != nil {
+		return nil, err
+	}
+	to, err := parseRangeID(end, "+", structs.MaxStreamID, structs.MaxStreamID.Seq)
+	if err != nil {
+		return nil, err
+	}
+
+	s.rlock()
+	defer s.RUnlock()
+
+	st, err := s.getStream(key)
+	if err != nil || st == nil {
+		return []structs.StreamEntry{}, err
+	}
+	return st.rangeOf(from, to, count), nil
+}
+
+// StreamRead чтение записей, добавленных после указанных идентификаторов, из одного или нескольких потоков.
+// При block > 0 ожидает появления новых записей не дольше block
+func (s *Storage) StreamRead(keys, ids []string, count int, block time.Duration) (map[string][]structs.StreamEntry, error) {
+	return s.StreamReadContext(context.Background(), keys, ids, count, block)
+}
+
+// StreamReadContext чтение записей потоков, ожидание которых прерывается отменой ctx
+func (s *Storage) StreamReadContext(ctx context.Context, keys, ids []string, count int, block time.Duration) (map[string][]structs.StreamEntry, error) {
+	if len(keys) != len(ids) {
+		return nil, structs.ErrStreamArgs
+	}
+
+	if err := s.lockContext(ctx, false); err != nil {
+		return nil, err
+	}
+	from := make([]structs.StreamID, len(ids))
+	for i, id := range ids {
+		st, err := s.getStream(keys[i])
+		if err != nil {
+			s.RUnlock()
+			return nil, err
+		}
+		if id == "$" {
+			if st != nil {
+				from[i] = st.lastID
+			}
+			continue
+		}
+		if from[i], err = structs.ParseStreamID(id, 0); err != nil {
+			s.RUnlock()
+			return nil, err
+		}
+	}
+	s.RUnlock()
+
+	return s.waitStreams(ctx, block, func() (map[string][]structs.StreamEntry, error) {
+		result := make(map[string][]structs.StreamEntry)
+		for i, key := range keys {
+			st, err := s.getStream(key)
+			if err != nil {
+				return nil, err
+			}
+			if st == nil {
+				continue
+			}
+			if entries := st.after(from[i], count); len(entries) > 0 {
+				result[key] = entries
+			}
+		}
+		return result, nil
+	})
+}
+
+// StreamLen количество записей в потоке
+func (s *Storage) StreamLen(key string) (int, error) {
+	s.rlock()
+	defer s.RUnlock()
+
+	st, err := s.getStream(key)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	return len(st.entries), nil
+}
+
+// StreamTrim удаление самых старых записей так, чтобы в потоке осталось не более maxLen записей.
+// Возвращает количество удалённых записей
+func (s *Storage) StreamTrim(key string, maxLen int) (int, error) {
+	s.lock()
+	defer s.Unlock()
+
+	st, err := s.getStream(key)
+	if err != nil || st == nil {
+		return 0, err
+	}
+	if maxLen < 0 {
+		maxLen = 0
+	}
+	return st.trim(maxLen), nil
+}
+
+// StreamGroupCreate создание группы потребителей. Группа начинает чтение после записи id
+func (s *Storage) StreamGroupCreate(key, group, id string, mkStream bool) error {
+	s.lock()
+	defer s.Unlock()
+
+	st, err := s.getStream(key)
+	if err != nil {
+		return err
+	}
+	if st == nil {
+		if !mkStream {
+			return structs.ErrKeyNotFound
+		}
+		st = newStream()
+		s.data[key] = st
+	}
+	if _, ok := st.groups[group]; ok {
+		return structs.ErrGroupExists
+	}
+
+	lastDelivered := st.lastID
+	if id != "$" {
+		if lastDelivered, err = structs.ParseStreamID(id, 0); err != nil {
+			return err
+		}
+	}
+	st.groups[group] = &consumerGroup{
+		lastDelivered: lastDelivered,
+		pending:       make(map[structs.StreamID]*structs.PendingEntry),
+	}
+	return nil
+}
+
+// StreamGroupDestroy удаление группы потребителей
+func (s *Storage) StreamGroupDestroy(key, group string) (bool, error) {
+	s.lock()
+	defer s.Unlock()
+
+	st, err := s.getStream(key)
+	if err != nil {
+		return false, err
+	}
+	if st == nil {
+		return false, structs.ErrKeyNotFound
+	}
+	if _, ok := st.groups[group]; !ok {
+		return false, nil
+	}
+	delete(st.groups, group)
+	return true, nil
+}
+
+// StreamReadGroup чтение записей от имени потребителя группы. Идентификатор ">" означает новые, ещё никому
+// не выданные записи: они попадают в список ожидающих подтверждения (если не задан noAck).
+// Любой другой идентификатор возвращает историю ожидающих подтверждения записей потребителя
+func (s *Storage) StreamReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	return s.StreamReadGroupContext(context.Background(), group, consumer, keys, ids, count, block, noAck)
+}
+
+// StreamReadGroupContext чтение записей потоков группой, ожидание которых прерывается отменой ctx
+func (s *Storage) StreamReadGroupContext(ctx context.Context, group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	from, err := parseGroupIDs(keys, ids)
+	if err != nil {
+		return nil, err
+	}
+	// История ожидающих записей возвращается сразу
+	for _, id := range ids {
+		if id != ">" {
+			block = 0
+		}
+	}
+
+	return s.waitStreams(ctx, block, func() (map[string][]structs.StreamEntry, error) {
+		return s.readGroup(time.Now(), group, consumer, keys, ids, from, count, noAck)
+	})
+}
+
+// StreamReadGroupAt чтение записей группой без ожидания. Выданные записи получают время выдачи at
+func (s *Storage) StreamReadGroupAt(at time.Time, group, consumer string, keys, ids []string, count int, noAck bool) (map[string][]structs.StreamEntry, error) {
+	from, err := parseGroupIDs(keys, ids)
+	if err != nil {
+		return nil, err
+	}
+	s.lock()
+	defer s.Unlock()
+	return s.readGroup(at, group, consumer, keys, ids, from, count, noAck)
+}
+
+// StreamWaitGroup ожидание записей, которые группа ещё не выдавала, не дольше block либо до отмены ctx
+func (s *Storage) StreamWaitGroup(ctx context.Context, group string, keys []string, block time.Duration) error {
+	_, err := s.waitStreams(ctx, block, func() (map[string][]structs.StreamEntry, error) {
+		result := make(map[string][]structs.StreamEntry)
+		for _, key := range keys {
+			st, g, err := s.getGroup(key, group)
+			if err != nil {
+				return nil, err
+			}
+			if entries := st.after(g.lastDelivered, 1); len(entries) > 0 {
+				result[key] = entries
+			}
+		}
+		return result, nil
+	})
+	return err
+}
+
+// parseGroupIDs разбор идентификаторов чтения группой, кроме ">"
+func parseGroupIDs(keys, ids []string) ([]structs.StreamID, error) {
+	if len(keys) != len(ids) {
+		return nil, structs.ErrStreamArgs
+	}
+	from := make([]structs.StreamID, len(ids))
+	for i, id := range ids {
+		if id == ">" {
+			continue
+		}
+		var err error
+		if from[i], err = structs.ParseStreamID(id, 0); err != nil {
+			return nil, err
+		}
+	}
+	return from, nil
+}
+
+// readGroup выдача записей потребителю группы в момент now. Группы всех потоков проверяются до выдачи,
+// поэтому при ошибке группы не изменяются. Вызывается под блокировкой
+func (s *Storage) readGroup(now time.Time, group, consumer string, keys, ids []string, from []structs.StreamID, count int, noAck bool) (map[string][]structs.StreamEntry, error) {
+	streams := make([]*stream, len(keys))
+	groups := make([]*consumerGroup, len(keys))
+	for i, key := range keys {
+		var err error
+		if streams[i], groups[i], err = s.getGroup(key, group); err != nil {
+			return nil, err
+		}
+	}
+
+	// Время выдачи хранится в UTC без показаний монотонных часов и не меняется при загрузке снимка
+	now = now.UTC()
+	result := make(map[string][]structs.StreamEntry)
+	for i, key := range keys {
+		st, g := streams[i], groups[i]
+		var entries []structs.StreamEntry
+		if ids[i] == ">" {
+			entries = st.after(g.lastDelivered, count)
+			if len(entries) > 0 {
+				g.lastDelivered = entries[len(entries)-1].ID
+			}
+			if !noAck {
+				for _, entry := range entries {
+					g.pending[entry.ID] = &structs.PendingEntry{
+						ID:            entry.ID,
+						Consumer:      consumer,
+						DeliveredAt:   now,
+						DeliveryCount: 1,
+					}
+				}
+			}
+		} else {
+			for _, p := range sortedPending(g, consumer) {
+				if count > 0 && len(entries) == count {
+					break
+				}
+				if !from[i].Less(p.ID) {
+					continue
+				}
+				entry, ok := st.get(p.ID)
+				if !ok {
+					continue
+				}
+				p.DeliveredAt = now
+				p.DeliveryCount++
+				entries = append(entries, entry)
+			}
+		}
+
+		if len(entries) > 0 {
+			result[key] = entries
+		}
+	}
+	return result, nil
+}
+
+// StreamAck подтверждение обработки записей. Возвращает количество подтверждённых записей
+func (s *Storage) StreamAck(key, group string, ids []string) (int, error) {
+	s.lock()
+	defer s.Unlock()
+
+	streamIDs, err := parseStreamIDs(ids)
+	if err != nil {
+		return 0, err
+	}
+	_, g, err := s.getGroup(key, group)
+	if err != nil {
+		return 0, err
+	}
+
+	acked := 0
+	for _, streamID := range streamIDs {
+		if _, ok := g.pending[streamID]; ok {
+			delete(g.pending, streamID)
+			acked++
+		}
+	}
+	return acked, nil
+}
+
+// StreamPending список записей группы, ожидающих подтверждения
+func (s *Storage) StreamPending(key, group string) ([]structs.PendingEntry, error) {
+	s.rlock()
+	defer s.RUnlock()
+
+	_, g, err := s.getGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := sortedPending(g, "")
+	result := make([]structs.PendingEntry, 0, len(pending))
+	for _, p := range pending {
+		result = append(result, *p)
+	}
+	return result, nil
+}
+
+// StreamClaim передача потребителю consumer записей, которые ожидают подтверждения не меньше minIdle
+func (s *Storage) StreamClaim(key, group, consumer string, minIdle time.Duration, ids []string) ([]structs.StreamEntry, error) {
+	return s.StreamClaimAt(time.Now(), key, group, consumer, minIdle, ids)
+}
+
+// StreamClaimAt передача записей с отсчётом времени ожидания от момента now
+func (s *Storage) StreamClaimAt(now time.Time, key, group, consumer string, minIdle time.Duration, ids []string) ([]structs.StreamEntry, error) {
+	s.lock()
+	defer s.Unlock()
+
+	streamIDs, err := parseStreamIDs(ids)
+	if err != nil {
+		return nil, err
+	}
+	st, g, err := s.getGroup(key, group)
+	if err != nil {
+		return nil, err
+	}
+
+	now = now.UTC()
+	result := make([]structs.StreamEntry, 0, len(ids))
+	for _, streamID := range streamIDs {
+		p, ok := g.pending[streamID]
+		if !ok || now.Sub(p.DeliveredAt) < minIdle {
+			continue
+		}
+		entry, ok := st.get(streamID)
+		if !ok {
+			// Запись удалена из потока, подтверждать больше нечего
+			delete(g.pending, streamID)
+			continue
+		}
+		p.Consumer = consumer
+		p.DeliveredAt = now
+		p.DeliveryCount++
+		result = append(result, entry)
+	}
+	return result, nil
+}
+
+// sortedPending ожидающие подтверждения записи группы (или одного потребителя), упорядоченные по идентификатору
+func sortedPending(g *consumerGroup, consumer string) []*structs.PendingEntry {
+	result := make([]*structs.PendingEntry, 0, len(g.pending))
+	for _, p := range g.pending {
+		if consumer == "" || p.Consumer == consumer {
+			result = append(result, p)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID.Less(result[j].ID)
+	})
+	return result
+}
+
+// parseStreamIDs разбор идентификаторов записей до изменения группы
+func parseStreamIDs(ids []string) ([]structs.StreamID, error) {
+	result := make([]structs.StreamID, len(ids))
+	for i, id := range ids {
+		var err error
+		if result[i], err = structs.ParseStreamID(id, 0); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// dumpGroups группы потребителей потока для снимка, упорядоченные по имени
+func (st *stream) dumpGroups() []structs.StreamGroup {
+	if len(st.groups) == 0 {
+		return nil
+	}
+	result := make([]structs.StreamGroup, 0, len(st.groups))
+	for name, g := range st.groups {
+		group := structs.StreamGroup{Name: name, LastDelivered: g.lastDelivered}
+		for _, p := range sortedPending(g, "") {
+			group.Pending = append(group.Pending, *p)
+		}
+		result = append(result, group)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// loadGroups восстановление групп потребителей из снимка
+func (st *stream) loadGroups(groups []structs.StreamGroup) {
+	for _, group := range groups {
+		g := &consumerGroup{
+			lastDelivered: group.LastDelivered,
+			pending:       make(map[structs.StreamID]*structs.PendingEntry, len(group.Pending)),
+		}
+		for i := range group.Pending {
+			p := group.Pending[i]
+			g.pending[p.ID] = &p
+		}
+		st.groups[group.Name] = g
+	}
+}
+
+// parseRangeID разбор границы интервала с учётом специального значения
+func parseRangeID(id, special string, specialID structs.StreamID, missingSeq uint64) (structs.StreamID, error) {
+	if id == special {
+		return specialID, nil
+	}
+	return structs.ParseStreamID(id, missingSeq)
+}
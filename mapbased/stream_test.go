@@ -0,0 +1,385 @@
+package mapbased
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+func newStreamStorage(t *testing.T, key string, ids ...string) *Storage {
+	s := &Storage{
+		RWMutex: &sync.RWMutex{},
+		data:    map[string]interface{}{"keyForStr1": "ValueString_1"},
+	}
+	for _, id := range ids {
+		if _, err := s.StreamAdd(key, id, map[string]string{"id": id}); err != nil {
+			t.Fatalf("StreamAdd() error = %v", err)
+		}
+	}
+	return s
+}
+
+func entryIDs(entries []structs.StreamEntry) []string {
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		result = append(result, entry.ID.String())
+	}
+	return result
+}
+
+func TestStorage_StreamAdd(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		id      string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "Testing StreamAdd: explicit ID",
+			key:  "events",
+			id:   "5-1",
+			want: "5-1",
+		},
+		{
+			name: "Testing StreamAdd: ID without sequence",
+			key:  "events",
+			id:   "6",
+			want: "6-0",
+		},
+		{
+			name:    "Testing StreamAdd: ID is equal to the top item",
+			key:     "events",
+			id:      "3-0",
+			wantErr: structs.ErrStreamIDTooSmall,
+		},
+		{
+			name:    "Testing StreamAdd: zero ID",
+			key:     "empty",
+			id:      "0-0",
+			wantErr: structs.ErrStreamIDTooSmall,
+		},
+		{
+			name:    "Testing StreamAdd: invalid ID",
+			key:     "events",
+			id:      "abc",
+			wantErr: structs.ErrInvalidStreamID,
+		},
+		{
+			name:    "Testing StreamAdd: type error",
+			key:     "keyForStr1",
+			id:      "*",
+			wantErr: structs.ErrType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStreamStorage(t, "events", "1-0", "3-0")
+			got, err := s.StreamAdd(tt.key, tt.id, map[string]string{"field": "value"})
+			if err != tt.wantErr {
+				t.Errorf("StreamAdd() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("StreamAdd() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_StreamAdd_AutoID(t *testing.T) {
+	s := newStreamStorage(t, "events")
+	var prev structs.StreamID
+	for i := 0; i < 1000; i++ {
+		id, err := s.StreamAdd("events", "*", map[string]string{"n": "v"})
+		if err != nil {
+			t.Fatalf("StreamAdd() error = %v", err)
+		}
+		if !prev.Less(id) {
+			t.Fatalf("StreamAdd() got = %v, want greater than %v", id, prev)
+		}
+		prev = id
+	}
+	if typ, _ := s.GetType("events"); typ != structs.Stream {
+		t.Errorf("GetType() got = %v, want %v", typ, structs.Stream)
+	}
+}
+
+func TestStorage_StreamRange(t *testing.T) {
+	tests := []struct {
+		name  string
+		start string
+		end   string
+		count int
+		want  []string
+	}{
+		{
+			name:  "Testing StreamRange: all entries",
+			start: "-",
+			end:   "+",
+			want:  []string{"1-0", "1-1", "2-0", "3-5"},
+		},
+		{
+			name:  "Testing StreamRange: with count",
+			start: "-",
+			end:   "+",
+			count: 2,
+			want:  []string{"1-0", "1-1"},
+		},
+		{
+			name:  "Testing StreamRange: milliseconds only",
+			start: "1",
+			end:   "1",
+			want:  []string{"1-0", "1-1"},
+		},
+		{
+			name:  "Testing StreamRange: empty range",
+			start: "4",
+			end:   "+",
+			want:  []string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStreamStorage(t, "events", "1-0", "1-1", "2-0", "3-5")
+			got, err := s.StreamRange("events", tt.start, tt.end, tt.count)
+			if err != nil {
+				t.Errorf("StreamRange() error = %v", err)
+				return
+			}
+			if ids := entryIDs(got); !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("StreamRange() got = %v, want %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestStorage_StreamTrim(t *testing.T) {
+	s := newStreamStorage(t, "events", "1-0", "2-0", "3-0", "4-0")
+	removed, err := s.StreamTrim("events", 1)
+	if err != nil || removed != 3 {
+		t.Errorf("StreamTrim() got = %v, %v, want %v", removed, err, 3)
+	}
+	if n, _ := s.StreamLen("events"); n != 1 {
+		t.Errorf("StreamLen() got = %v, want %v", n, 1)
+	}
+	if _, err := s.StreamAdd("events", "2-0", map[string]string{"n": "v"}); err != structs.ErrStreamIDTooSmall {
+		t.Errorf("StreamAdd() error = %v, wantErr %v", err, structs.ErrStreamIDTooSmall)
+	}
+}
+
+func TestStorage_StreamAddTrim(t *testing.T) {
+	tests := []struct {
+		name   string
+		maxLen int
+		want   []string
+	}{
+		{name: "Testing StreamAddTrim: trim", maxLen: 2, want: []string{"3-0", "4-0"}},
+		{name: "Testing StreamAddTrim: empty stream", maxLen: 0, want: []string{}},
+		{name: "Testing StreamAddTrim: no trim", maxLen: -1, want: []string{"1-0", "2-0", "3-0", "4-0"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStreamStorage(t, "events", "1-0", "2-0", "3-0")
+			id, err := s.StreamAddTrim("events", "4-0", map[string]string{"n": "v"}, tt.maxLen)
+			if err != nil || id.String() != "4-0" {
+				t.Fatalf("StreamAddTrim() got = %v, %v, want 4-0", id, err)
+			}
+			entries, _ := s.StreamRange("events", "-", "+", 0)
+			if got := entryIDs(entries); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("StreamRange() got = %v, want %v", got, tt.want)
+			}
+			if _, err := s.StreamAdd("events", "4-0", map[string]string{"n": "v"}); err != structs.ErrStreamIDTooSmall {
+				t.Errorf("StreamAdd() error = %v, wantErr %v", err, structs.ErrStreamIDTooSmall)
+			}
+		})
+	}
+}
+
+func TestStorage_StreamRead(t *testing.T) {
+	s := newStreamStorage(t, "events", "1-0", "2-0")
+
+	got, err := s.StreamRead([]string{"events", "missing"}, []string{"1-0", "0"}, 0, 0)
+	if err != nil {
+		t.Fatalf("StreamRead() error = %v", err)
+	}
+	if ids := entryIDs(got["events"]); !reflect.DeepEqual(ids, []string{"2-0"}) || len(got) != 1 {
+		t.Errorf("StreamRead() got = %v, want %v", got, []string{"2-0"})
+	}
+
+	got, err = s.StreamRead([]string{"events"}, []string{"$"}, 0, 0)
+	if err != nil || len(got) != 0 {
+		t.Errorf("StreamRead() got = %v, %v, want empty result", got, err)
+	}
+}
+
+func TestStorage_StreamRead_Block(t *testing.T) {
+	s := newStreamStorage(t, "events", "1-0")
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = s.StreamAdd("events", "2-0", map[string]string{"n": "v"})
+	}()
+	got, err := s.StreamRead([]string{"events"}, []string{"$"}, 0, time.Second)
+	if err != nil {
+		t.Fatalf("StreamRead() error = %v", err)
+	}
+	if ids := entryIDs(got["events"]); !reflect.DeepEqual(ids, []string{"2-0"}) {
+		t.Errorf("StreamRead() got = %v, want %v", ids, []string{"2-0"})
+	}
+
+	start := time.Now()
+	got, err = s.StreamRead([]string{"events"}, []string{"$"}, 0, 30*time.Millisecond)
+	if err != nil || len(got) != 0 {
+		t.Errorf("StreamRead() got = %v, %v, want empty result", got, err)
+	}
+	if time.Since(start) < 30*time.Millisecond {
+		t.Errorf("StreamRead() returned before timeout")
+	}
+}
+
+func TestStorage_StreamGroups(t *testing.T) {
+	s := newStreamStorage(t, "events", "1-0", "2-0", "3-0")
+
+	if err := s.StreamGroupCreate("events", "workers", "0", false); err != nil {
+		t.Fatalf("StreamGroupCreate() error = %v", err)
+	}
+	if err := s.StreamGroupCreate("events", "workers", "0", false); err != structs.ErrGroupExists {
+		t.Errorf("StreamGroupCreate() error = %v, wantErr %v", err, structs.ErrGroupExists)
+	}
+	if err := s.StreamGroupCreate("missing", "workers", "$", false); err != structs.ErrKeyNotFound {
+		t.Errorf("StreamGroupCreate() error = %v, wantErr %v", err, structs.ErrKeyNotFound)
+	}
+	if _, err := s.StreamReadGroup("unknown", "alice", []string{"events"}, []string{">"}, 0, 0, false); err != structs.ErrNoGroup {
+		t.Errorf("StreamReadGroup() error = %v, wantErr %v", err, structs.ErrNoGroup)
+	}
+
+	// Новые записи делятся между потребителями
+	got, err := s.StreamReadGroup("workers", "alice", []string{"events"}, []string{">"}, 2, 0, false)
+	if err != nil {
+		t.Fatalf("StreamReadGroup() error = %v", err)
+	}
+	if ids := entryIDs(got["events"]); !reflect.DeepEqual(ids, []string{"1-0", "2-0"}) {
+		t.Errorf("StreamReadGroup() got = %v, want %v", ids, []string{"1-0", "2-0"})
+	}
+	got, _ = s.StreamReadGroup("workers", "bob", []string{"events"}, []string{">"}, 0, 0, false)
+	if ids := entryIDs(got["events"]); !reflect.DeepEqual(ids, []string{"3-0"}) {
+		t.Errorf("StreamReadGroup() got = %v, want %v", ids, []string{"3-0"})
+	}
+
+	// История ожидающих подтверждения записей потребителя
+	got, _ = s.StreamReadGroup("workers", "alice", []string{"events"}, []string{"0"}, 0, 0, false)
+	if ids := entryIDs(got["events"]); !reflect.DeepEqual(ids, []string{"1-0", "2-0"}) {
+		t.Errorf("StreamReadGroup() history got = %v, want %v", ids, []string{"1-0", "2-0"})
+	}
+
+	acked, err := s.StreamAck("events", "workers", []string{"1-0", "1-0", "9-0"})
+	if err != nil || acked != 1 {
+		t.Errorf("StreamAck() got = %v, %v, want %v", acked, err, 1)
+	}
+
+	pending, err := s.StreamPending("events", "workers")
+	if err != nil {
+		t.Fatalf("StreamPending() error = %v", err)
+	}
+	if len(pending) != 2 || pending[0].ID.String() != "2-0" || pending[0].Consumer != "alice" || pending[0].DeliveryCount != 2 {
+		t.Errorf("StreamPending() got = %+v", pending)
+	}
+
+	// Передача зависшей записи другому потребителю
+	claimed, err := s.StreamClaim("events", "workers", "bob", time.Hour, []string{"2-0"})
+	if err != nil || len(claimed) != 0 {
+		t.Errorf("StreamClaim() got = %v, %v, want nothing claimed", claimed, err)
+	}
+	claimed, err = s.StreamClaim("events", "workers", "bob", 0, []string{"2-0"})
+	if ids := entryIDs(claimed); err != nil || !reflect.DeepEqual(ids, []string{"2-0"}) {
+		t.Errorf("StreamClaim() got = %v, %v, want %v", ids, err, []string{"2-0"})
+	}
+	pending, _ = s.StreamPending("events", "workers")
+	if pending[0].Consumer != "bob" || pending[0].DeliveryCount != 3 {
+		t.Errorf("StreamPending() after claim got = %+v", pending[0])
+	}
+
+	destroyed, err := s.StreamGroupDestroy("events", "workers")
+	if err != nil || !destroyed {
+		t.Errorf("StreamGroupDestroy() got = %v, %v, want %v", destroyed, err, true)
+	}
+}
+
+func TestStorage_StreamReadGroup_Block(t *testing.T) {
+	s := newStreamStorage(t, "events")
+	if err := s.StreamGroupCreate("events", "workers", "$", true); err != nil {
+		t.Fatalf("StreamGroupCreate() error = %v", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = s.StreamAdd("events", "*", map[string]string{"n": "v"})
+	}()
+	got, err := s.StreamReadGroup("workers", "alice", []string{"events"}, []string{">"}, 0, time.Second, true)
+	if err != nil || len(got["events"]) != 1 {
+		t.Errorf("StreamReadGroup() got = %v, %v, want one entry", got, err)
+	}
+	if pending, _ := s.StreamPending("events", "workers"); len(pending) != 0 {
+		t.Errorf("StreamPending() got = %v, want empty with noAck", pending)
+	}
+}
+
+func TestStorage_StreamGroupsSnapshot(t *testing.T) {
+	s := newStreamStorage(t, "events", "1-0", "2-0", "3-0")
+	if err := s.StreamGroupCreate("events", "workers", "0", false); err != nil {
+		t.Fatalf("StreamGroupCreate() error = %v", err)
+	}
+	at := time.Unix(0, 1000)
+	if _, err := s.StreamReadGroupAt(at, "workers", "alice", []string{"events"}, []string{">"}, 2, false); err != nil {
+		t.Fatalf("StreamReadGroupAt() error = %v", err)
+	}
+
+	restored := NewStorage()
+	defer restored.Close()
+	restored.Load(s.Dump())
+	if !reflect.DeepEqual(restored.Dump(), s.Dump()) {
+		t.Errorf("Dump() after Load() differs from the source")
+	}
+	pending, err := restored.StreamPending("events", "workers")
+	if err != nil {
+		t.Fatalf("StreamPending() error = %v", err)
+	}
+	if len(pending) != 2 || pending[1].ID.String() != "2-0" || !pending[1].DeliveredAt.Equal(at) {
+		t.Errorf("StreamPending() after Load() got = %+v", pending)
+	}
+	got, _ := restored.StreamReadGroup("workers", "bob", []string{"events"}, []string{">"}, 0, 0, false)
+	if ids := entryIDs(got["events"]); !reflect.DeepEqual(ids, []string{"3-0"}) {
+		t.Errorf("StreamReadGroup() after Load() got = %v, want %v", ids, []string{"3-0"})
+	}
+}
+
+func TestStorage_StreamWaitGroup(t *testing.T) {
+	s := newStreamStorage(t, "events", "1-0")
+	if err := s.StreamGroupCreate("events", "workers", "$", false); err != nil {
+		t.Fatalf("StreamGroupCreate() error = %v", err)
+	}
+	if err := s.StreamWaitGroup(context.Background(), "workers", []string{"events"}, 10*time.Millisecond); err != nil {
+		t.Errorf("StreamWaitGroup() error = %v", err)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = s.StreamAdd("events", "*", map[string]string{"n": "v"})
+	}()
+	start := time.Now()
+	if err := s.StreamWaitGroup(context.Background(), "workers", []string{"events"}, time.Second); err != nil {
+		t.Errorf("StreamWaitGroup() error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("StreamWaitGroup() waited %v, want to return after StreamAdd()", elapsed)
+	}
+	// Ожидание не выдаёт записи
+	if pending, _ := s.StreamPending("events", "workers"); len(pending) != 0 {
+		t.Errorf("StreamPending() got = %v, want empty", pending)
+	}
+}
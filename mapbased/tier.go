@@ -0,0 +1,458 @@
+package mapbased
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/geraev/gokvserver/lsm"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// tierMemtableSize размер памяти дискового уровня до сброса в сегмент
+const tierMemtableSize = 1 << 20
+
+// spilled значение, вынесенное на диск. Остаётся в data вместо значения: ключ, тип и срок жизни
+// по-прежнему в памяти
+type spilled struct {
+	typ structs.ValueType
+	// size учтённый размер ключа и значения в памяти, raw - размер значения на диске
+	size int64
+	raw  int64
+}
+
+// tierItem значение в памяти в очереди на вынесение
+type tierItem struct {
+	key  string
+	size int64
+}
+
+// tier дисковый уровень хранилища. Строки, списки и словари в памяти упорядочены по последнему
+// чтению, самые давние выносятся на диск при превышении maxMemory. Потоки изменяются на месте
+// и всегда остаются в памяти. Методы безопасны для nil (хранилище без диска)
+type tier struct {
+	disk      *lsm.Storage
+	maxMemory int64
+
+	// mu защищает очередь и счётчики: touch вызывается под блокировкой хранилища на чтение
+	mu     sync.Mutex
+	lru    *list.List
+	items  map[string]*list.Element
+	pinned map[string]int64
+	memory int64
+
+	diskKeys       int
+	diskBytes      int64
+	spills, faults uint64
+}
+
+func newTier(disk *lsm.Storage, maxMemory int64) *tier {
+	return &tier{
+		disk:      disk,
+		maxMemory: maxMemory,
+		lru:       list.New(),
+		items:     make(map[string]*list.Element),
+		pinned:    make(map[string]int64),
+	}
+}
+
+// valueSize оценка размера значения
+func valueSize(val interface{}) int64 {
+	var size int
+	switch v := val.(type) {
+	case string:
+		size = len(v)
+	case []string:
+		for _, item := range v {
+			size += len(item)
+		}
+	case map[string]string:
+		for k, item := range v {
+			size += len(k) + len(item)
+		}
+	case *stream:
+		size = v.size()
+	case *compressed:
+		size = len(v.data)
+	case *spilled:
+		return 0
+	}
+	return int64(size)
+}
+
+// valueType тип строки, списка или словаря в памяти
+func valueType(val interface{}) (structs.ValueType, bool) {
+	switch v := val.(type) {
+	case *compressed:
+		return v.typ, true
+	case string:
+		return structs.String, true
+	case []string:
+		return structs.List, true
+	case map[string]string:
+		return structs.Dictionary, true
+	}
+	return 0, false
+}
+
+// track учёт нового значения ключа. Значение становится самым свежим
+func (t *tier) track(key string, val interface{}) {
+	if t == nil {
+		return
+	}
+	if _, ok := valueType(val); !ok {
+		return
+	}
+	size := int64(len(key)) + valueSize(val)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.memory += size
+	if _, ok := t.pinned[key]; ok {
+		t.pinned[key] = size
+		return
+	}
+	t.items[key] = t.lru.PushFront(&tierItem{key: key, size: size})
+}
+
+// forget снятие с учёта значения ключа перед заменой или удалением. Значение на диске удаляется,
+// закрепление сохраняется
+func (t *tier) forget(key string, val interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if sp, ok := val.(*spilled); ok {
+		if err := t.disk.RemoveElementContext(context.Background(), key); err != nil {
+			log.Printf("mapbased: tier: remove %q: %v", key, err)
+		}
+		t.diskKeys--
+		t.diskBytes -= sp.size
+		return
+	}
+	if e, ok := t.items[key]; ok {
+		t.memory -= e.Value.(*tierItem).size
+		t.lru.Remove(e)
+		delete(t.items, key)
+	} else if size, ok := t.pinned[key]; ok {
+		t.memory -= size
+		t.pinned[key] = 0
+	}
+}
+
+// remove снятие с учёта удалённого ключа вместе с закреплением
+func (t *tier) remove(key string, val interface{}) {
+	if t == nil {
+		return
+	}
+	t.forget(key, val)
+	t.mu.Lock()
+	delete(t.pinned, key)
+	t.mu.Unlock()
+}
+
+// touch чтение ключа: значение становится самым свежим
+func (t *tier) touch(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	if e, ok := t.items[key]; ok {
+		t.lru.MoveToFront(e)
+	}
+	t.mu.Unlock()
+}
+
+// coldest ключ самого давно не читанного значения, если память превышена
+func (t *tier) coldest() (string, bool) {
+	if t == nil {
+		return "", false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.memory <= t.maxMemory || t.lru.Len() == 0 {
+		return "", false
+	}
+	return t.lru.Back().Value.(*tierItem).key, true
+}
+
+// write вынесение значения ключа на диск, сжатое значение передаётся распакованным
+func (t *tier) write(key string, val interface{}) (*spilled, error) {
+	typ, _ := valueType(val)
+	ctx := context.Background()
+	var err error
+	switch v := val.(type) {
+	case string:
+		_, _, err = t.disk.PutOrUpdateStringContext(ctx, key, v)
+	case []string:
+		_, _, err = t.disk.PutOrUpdateListContext(ctx, key, v)
+	case map[string]string:
+		_, _, err = t.disk.PutOrUpdateDictionaryContext(ctx, key, v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	e := t.items[key]
+	size := e.Value.(*tierItem).size
+	t.lru.Remove(e)
+	delete(t.items, key)
+	t.memory -= size
+	t.diskKeys++
+	t.diskBytes += size
+	t.spills++
+	return &spilled{typ: typ, size: size, raw: valueSize(val)}, nil
+}
+
+// read чтение значения с диска без возврата в память
+func (t *tier) read(key string) (interface{}, error) {
+	val, err := t.disk.GetElementContext(context.Background(), key)
+	if err == structs.ErrKeyNotFound {
+		// Значение пропало с диска, например, после сбоя записи
+		return nil, errors.New("mapbased: tier: value of " + key + " is lost")
+	}
+	return val, err
+}
+
+// isPinned значение ключа закреплено в памяти
+func (t *tier) isPinned(key string) bool {
+	if t == nil {
+		return false
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.pinned[key]
+	return ok
+}
+
+// pin закрепление значения в памяти. Вызывается для значения в памяти
+func (t *tier) pin(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.pinned[key]; ok {
+		return
+	}
+	var size int64
+	if e, ok := t.items[key]; ok {
+		size = e.Value.(*tierItem).size
+		t.lru.Remove(e)
+		delete(t.items, key)
+	}
+	t.pinned[key] = size
+}
+
+// unpin снятие закрепления, значение становится самым свежим
+func (t *tier) unpin(key string, val interface{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	size, ok := t.pinned[key]
+	if !ok {
+		return
+	}
+	delete(t.pinned, key)
+	if _, ok := valueType(val); ok {
+		t.items[key] = t.lru.PushFront(&tierItem{key: key, size: size})
+	}
+}
+
+// clear удаление всех значений с диска и учёта
+func (t *tier) clear() {
+	if t == nil {
+		return
+	}
+	t.disk.Flush()
+	t.mu.Lock()
+	t.lru.Init()
+	t.items = make(map[string]*list.Element)
+	t.pinned = make(map[string]int64)
+	t.memory, t.diskKeys, t.diskBytes = 0, 0, 0
+	t.mu.Unlock()
+}
+
+func (t *tier) stats() structs.TierStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return structs.TierStats{
+		MemoryKeys:  t.lru.Len() + len(t.pinned),
+		DiskKeys:    t.diskKeys,
+		MemoryBytes: t.memory,
+		DiskBytes:   t.diskBytes,
+		MaxMemory:   t.maxMemory,
+		PinnedKeys:  len(t.pinned),
+		Spills:      t.spills,
+		Faults:      t.faults,
+	}
+}
+
+// SetTier вынесение давно не читанных значений в каталог dir, когда размер строк, списков и словарей
+// в памяти превышает maxMemory байт. Ключи, типы и сроки жизни остаются в памяти, значение
+// возвращается в память при чтении. Содержимое каталога от прошлого запуска удаляется.
+// Вызывается один раз до работы
+func (s *Storage) SetTier(dir string, maxMemory int64) error {
+	if maxMemory <= 0 {
+		return errors.New("mapbased: tier: max memory must be positive")
+	}
+	disk, err := lsm.Open(dir, lsm.Options{MemtableSize: tierMemtableSize})
+	if err != nil {
+		return err
+	}
+	disk.Flush()
+
+	s.lock()
+	defer s.Unlock()
+	if s.tier != nil {
+		disk.Close()
+		return errors.New("mapbased: tier is already set")
+	}
+	s.tier = newTier(disk, maxMemory)
+	for key, val := range s.data {
+		s.tier.track(key, val)
+	}
+	s.spill()
+	return nil
+}
+
+// spill вынесение давно не читанных значений на диск до возврата в границу памяти.
+// Вызывается под блокировкой на запись
+func (s *Storage) spill() {
+	for {
+		key, ok := s.tier.coldest()
+		if !ok {
+			return
+		}
+		sp, err := s.tier.write(key, expand(s.data[key]))
+		if err != nil {
+			// Значение остаётся в памяти, следующая запись повторит попытку
+			log.Printf("mapbased: tier: spill %q: %v", key, err)
+			return
+		}
+		s.data[key] = sp
+	}
+}
+
+// restore возврат значения с диска в память. Вызывается под блокировкой на запись
+func (s *Storage) restore(key string, sp *spilled) (interface{}, error) {
+	val, err := s.tier.read(key)
+	if err != nil {
+		return nil, err
+	}
+	s.tier.forget(key, sp)
+	stored := s.compress(val)
+	s.data[key] = stored
+	s.tier.track(key, stored)
+	s.tier.mu.Lock()
+	s.tier.faults++
+	s.tier.mu.Unlock()
+	s.spill()
+	return val, nil
+}
+
+// resolve значение ключа, значение на диске читается без возврата в память. Вызывается под блокировкой
+func (s *Storage) resolve(key string, val interface{}) (interface{}, error) {
+	if _, ok := val.(*spilled); !ok {
+		return val, nil
+	}
+	return s.tier.read(key)
+}
+
+// previous предыдущее значение заменяемого ключа в исходном виде. Ошибка чтения с диска записывается в журнал,
+// предыдущим значением возвращается нулевое. Вызывается под блокировкой на запись
+func (s *Storage) previous(key string, val interface{}) interface{} {
+	val, err := s.resolve(key, val)
+	if err != nil {
+		log.Printf("mapbased: tier: %v", err)
+		return nil
+	}
+	return expand(val)
+}
+
+// get значение ключа в исходном виде для чтения, значение с диска возвращается в память. Значение
+// возвращается и распаковывается без блокировки: хранилище не изменяет строки, списки и словари на месте
+func (s *Storage) get(ctx context.Context, key string) (interface{}, error) {
+	if err := s.lockContext(ctx, false); err != nil {
+		return nil, err
+	}
+	val, ok := s.data[key]
+	s.lookup(ok)
+	if !ok {
+		s.RUnlock()
+		return nil, structs.ErrKeyNotFound
+	}
+	if _, ok := val.(*spilled); !ok {
+		s.tier.touch(key)
+		s.RUnlock()
+		return expand(val), nil
+	}
+	s.RUnlock()
+
+	if err := s.lockContext(ctx, true); err != nil {
+		return nil, err
+	}
+	defer s.Unlock()
+	// Ключ мог измениться, пока блокировка была свободна
+	val, ok = s.data[key]
+	if !ok {
+		return nil, structs.ErrKeyNotFound
+	}
+	sp, ok := val.(*spilled)
+	if !ok {
+		s.tier.touch(key)
+		return expand(val), nil
+	}
+	return s.restore(key, sp)
+}
+
+// Pin закрепление значения ключа в памяти, значение на диске возвращается в память
+func (s *Storage) Pin(key string) error {
+	s.lock()
+	defer s.Unlock()
+	if s.tier == nil {
+		return structs.ErrNotSupported
+	}
+	val, ok := s.data[key]
+	if !ok {
+		return structs.ErrKeyNotFound
+	}
+	if sp, ok := val.(*spilled); ok {
+		// Закреплённое значение не должно уйти на диск сразу после возврата
+		s.tier.pin(key)
+		if _, err := s.restore(key, sp); err != nil {
+			s.tier.unpin(key, sp)
+			return err
+		}
+		return nil
+	}
+	s.tier.pin(key)
+	return nil
+}
+
+// Unpin снятие закрепления ключа
+func (s *Storage) Unpin(key string) error {
+	s.lock()
+	defer s.Unlock()
+	if s.tier == nil {
+		return structs.ErrNotSupported
+	}
+	val, ok := s.data[key]
+	if !ok {
+		return structs.ErrKeyNotFound
+	}
+	s.tier.unpin(key, val)
+	s.spill()
+	return nil
+}
+
+// TierStats статистика дискового уровня, false - хранилище без диска
+func (s *Storage) TierStats() (structs.TierStats, bool) {
+	s.rlock()
+	t := s.tier
+	s.RUnlock()
+	if t == nil {
+		return structs.TierStats{}, false
+	}
+	return t.stats(), true
+}
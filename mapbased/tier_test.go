@@ -0,0 +1,208 @@
+package mapbased_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// tiered хранилище с дисковым уровнем на maxMemory байт
+func tiered(t *testing.T, maxMemory int64) *mapbased.Storage {
+	t.Helper()
+	s := mapbased.NewStorage()
+	if err := s.SetTier(t.TempDir(), maxMemory); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := s.Close(); err != nil {
+			t.Errorf("Close() error = %v", err)
+		}
+	})
+	return s
+}
+
+func tierStats(t *testing.T, s *mapbased.Storage) structs.TierStats {
+	t.Helper()
+	stats, ok := s.TierStats()
+	if !ok {
+		t.Fatal("TierStats() = false, want true")
+	}
+	return stats
+}
+
+func TestStorage_TierSpill(t *testing.T) {
+	// Ключ и значение по 10 байт, в памяти помещаются три
+	s := tiered(t, 60)
+	for i := 0; i < 10; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("key:%06d", i), fmt.Sprintf("value:%04d", i))
+	}
+	stats := tierStats(t, s)
+	want := structs.TierStats{MemoryKeys: 3, DiskKeys: 7, MemoryBytes: 60, DiskBytes: 140, MaxMemory: 60, Spills: 7}
+	if stats != want {
+		t.Fatalf("TierStats() = %+v, want %+v", stats, want)
+	}
+
+	// Ключи, типы и сроки жизни остаются в памяти
+	s.SetExpired("key:000000", 60000)
+	if n := s.Len(); n != 10 {
+		t.Errorf("Len() = %d, want 10", n)
+	}
+	if typ, err := s.GetType("key:000000"); err != nil || typ != structs.String {
+		t.Errorf("GetType() = %v, %v, want %v", typ, err, structs.String)
+	}
+	if got := s.Stats(); got.Keys[structs.String] != 10 || got.KeysWithTTL != 1 {
+		t.Errorf("Stats() = %+v, want 10 strings with 1 ttl", got)
+	}
+
+	// Чтение возвращает значение в память и выносит самое давнее
+	if val, err := s.GetElement("key:000000"); err != nil || val != "value:0000" {
+		t.Fatalf("GetElement() = %v, %v, want value:0000", val, err)
+	}
+	stats = tierStats(t, s)
+	if stats.Faults != 1 || stats.Spills != 8 || stats.MemoryKeys != 3 || stats.DiskKeys != 7 {
+		t.Errorf("TierStats() after read = %+v, want 1 fault, 8 spills", stats)
+	}
+	// Прочитанное значение свежее остальных и остаётся в памяти
+	if _, err := s.GetElement("key:000000"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tierStats(t, s).Faults; got != 1 {
+		t.Errorf("Faults after second read = %d, want 1", got)
+	}
+}
+
+func TestStorage_TierValues(t *testing.T) {
+	s := tiered(t, 1)
+	s.PutOrUpdateList("list", []string{"a", "b"})
+	s.PutOrUpdateDictionary("dict", map[string]string{"k": "v"})
+	s.PutOrUpdateString("str", "value")
+	s.PutOrUpdateString("old", "value")
+
+	tests := []struct {
+		name string
+		get  func() (interface{}, error)
+		want interface{}
+	}{
+		{
+			name: "Testing GetListElement",
+			get:  func() (interface{}, error) { return s.GetListElement("list", 1) },
+			want: "b",
+		},
+		{
+			name: "Testing GetDictionaryElement",
+			get:  func() (interface{}, error) { return s.GetDictionaryElement("dict", "k") },
+			want: "v",
+		},
+		{
+			name: "Testing GetElement",
+			get:  func() (interface{}, error) { return s.GetElement("list") },
+			want: []string{"a", "b"},
+		},
+		{
+			name: "Testing previous value",
+			get: func() (interface{}, error) {
+				val, _ := s.PutOrUpdateString("str", "new")
+				return val, nil
+			},
+			want: "value",
+		},
+		{
+			name: "Testing DumpKey",
+			get: func() (interface{}, error) {
+				entry, err := s.DumpKey("dict")
+				return entry.Value, err
+			},
+			want: map[string]string{"k": "v"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.get()
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, %v, want %v", got, err, tt.want)
+			}
+		})
+	}
+
+	s.RemoveElement("old")
+	if _, err := s.GetElement("old"); err != structs.ErrKeyNotFound {
+		t.Errorf("GetElement() of a removed key error = %v, want %v", err, structs.ErrKeyNotFound)
+	}
+	if got := tierStats(t, s); got.MemoryKeys+got.DiskKeys != 3 {
+		t.Errorf("TierStats() = %+v, want 3 keys", got)
+	}
+
+	restored := tiered(t, 1)
+	restored.Load(s.Dump())
+	if !reflect.DeepEqual(restored.Dump(), s.Dump()) {
+		t.Errorf("Dump() after Load() differs from the source")
+	}
+	restored.Flush()
+	if got := tierStats(t, restored); got.MemoryKeys != 0 || got.DiskKeys != 0 || got.DiskBytes != 0 {
+		t.Errorf("TierStats() after Flush() = %+v, want no keys", got)
+	}
+}
+
+func TestStorage_TierPin(t *testing.T) {
+	s := tiered(t, 20)
+	s.PutOrUpdateString("pinned:01", "value:0001")
+	for i := 0; i < 5; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("key:%06d", i), fmt.Sprintf("value:%04d", i))
+	}
+
+	// Значение с диска возвращается в память и больше не выносится
+	if err := s.Pin("pinned:01"); err != nil {
+		t.Fatal(err)
+	}
+	for i := 5; i < 10; i++ {
+		s.PutOrUpdateString(fmt.Sprintf("key:%06d", i), fmt.Sprintf("value:%04d", i))
+	}
+	stats := tierStats(t, s)
+	if stats.PinnedKeys != 1 || stats.Faults != 1 {
+		t.Fatalf("TierStats() = %+v, want 1 pinned key and 1 fault", stats)
+	}
+	if _, err := s.GetElement("pinned:01"); err != nil {
+		t.Fatal(err)
+	}
+	if got := tierStats(t, s).Faults; got != 1 {
+		t.Errorf("Faults after reading a pinned key = %d, want 1", got)
+	}
+
+	// Перезапись сохраняет закрепление, удаление снимает
+	s.PutOrUpdateString("pinned:01", "value:0002")
+	if got := tierStats(t, s).PinnedKeys; got != 1 {
+		t.Errorf("PinnedKeys after overwrite = %d, want 1", got)
+	}
+	if err := s.Unpin("pinned:01"); err != nil {
+		t.Fatal(err)
+	}
+	s.PutOrUpdateString("key:000000", "value")
+	if got := tierStats(t, s); got.PinnedKeys != 0 || got.MemoryBytes > got.MaxMemory {
+		t.Errorf("TierStats() after Unpin() = %+v, want no pinned keys", got)
+	}
+
+	tests := []struct {
+		name string
+		s    *mapbased.Storage
+		want error
+	}{
+		{name: "Testing missing key", s: s, want: structs.ErrKeyNotFound},
+		{name: "Testing storage without tier", s: mapbased.NewStorage(), want: structs.ErrNotSupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.s.Pin("missing"); err != tt.want {
+				t.Errorf("Pin() error = %v, want %v", err, tt.want)
+			}
+			if err := tt.s.Unpin("missing"); err != tt.want {
+				t.Errorf("Unpin() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+	if _, ok := mapbased.NewStorage().TierStats(); ok {
+		t.Errorf("TierStats() of a storage without tier = true, want false")
+	}
+}
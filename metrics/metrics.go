@@ -0,0 +1,190 @@
+// Package metrics метрики сервера в формате Prometheus
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Namespace префикс имён метрик
+const Namespace = "gokv"
+
+// Протоколы клиентов
+const (
+	ProtoHTTP = "http"
+	ProtoTCP  = "tcp"
+)
+
+// Metrics метрики сервера в собственном реестре. Методы nil *Metrics ничего не делают,
+// поэтому серверы без метрик не тратят на них время
+type Metrics struct {
+	registry *prometheus.Registry
+
+	httpRequests *prometheus.CounterVec
+	httpDuration *prometheus.HistogramVec
+	tcpCommands  *prometheus.CounterVec
+	tcpDuration  *prometheus.HistogramVec
+	clients      *prometheus.GaugeVec
+	lockWait     *prometheus.HistogramVec
+	janitor      prometheus.Histogram
+	storageOps   *prometheus.CounterVec
+	storageTime  *prometheus.HistogramVec
+}
+
+// New метрики сервера. Если storage реализует structs.StatsStorage, при каждом сборе
+// добавляется статистика хранилища
+func New(storage structs.Storage) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route and status code.",
+		}, []string{"method", "route", "code"}),
+		httpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"method", "route"}),
+		tcpCommands: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "tcp_commands_total",
+			Help:      "TCP commands by name and result.",
+		}, []string{"command", "result"}),
+		tcpDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "tcp_command_duration_seconds",
+			Help:      "TCP command latency by name.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}, []string{"command"}),
+		clients: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: Namespace,
+			Name:      "connected_clients",
+			Help:      "Open client connections by protocol.",
+		}, []string{"proto"}),
+		lockWait: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "storage_lock_wait_seconds",
+			Help:      "Time spent waiting for the storage lock.",
+			Buckets:   prometheus.ExponentialBuckets(0.000001, 4, 10),
+		}, []string{"mode"}),
+		janitor: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "janitor_run_duration_seconds",
+			Help:      "Duration of the expired keys cleanup runs.",
+			Buckets:   prometheus.ExponentialBuckets(0.00001, 4, 10),
+		}),
+		storageOps: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: Namespace,
+			Name:      "storage_operations_total",
+			Help:      "Storage operations by method and result.",
+		}, []string{"op", "result"}),
+		storageTime: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: Namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Storage operation latency by method.",
+			Buckets:   prometheus.ExponentialBuckets(0.000001, 4, 10),
+		}, []string{"op"}),
+	}
+	m.registry.MustRegister(
+		m.httpRequests, m.httpDuration, m.tcpCommands, m.tcpDuration, m.clients, m.lockWait, m.janitor,
+		m.storageOps, m.storageTime,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	if stats, ok := storage.(structs.StatsStorage); ok {
+		m.registry.MustRegister(newStorageCollector(stats))
+	}
+	return m
+}
+
+// Registry реестр метрик, например для регистрации собственных метрик
+func (m *Metrics) Registry() *prometheus.Registry {
+	return m.registry
+}
+
+// Handler ответ на запрос /metrics
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHTTP выполненный HTTP запрос. route - шаблон маршрута, а не путь запроса:
+// иначе каждый ключ создавал бы отдельный ряд
+func (m *Metrics) ObserveHTTP(method, route string, code int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.httpRequests.WithLabelValues(method, route, strconv.Itoa(code)).Inc()
+	m.httpDuration.WithLabelValues(method, route).Observe(d.Seconds())
+}
+
+// ObserveTCP выполненная команда TCP, failed - ответ с ошибкой
+func (m *Metrics) ObserveTCP(command string, failed bool, d time.Duration) {
+	if m == nil {
+		return
+	}
+	result := "ok"
+	if failed {
+		result = "error"
+	}
+	m.tcpCommands.WithLabelValues(command, result).Inc()
+	m.tcpDuration.WithLabelValues(command).Observe(d.Seconds())
+}
+
+// ClientConnected открытие соединения клиента протокола proto
+func (m *Metrics) ClientConnected(proto string) {
+	if m == nil {
+		return
+	}
+	m.clients.WithLabelValues(proto).Inc()
+}
+
+// ClientDisconnected закрытие соединения клиента протокола proto
+func (m *Metrics) ClientDisconnected(proto string) {
+	if m == nil {
+		return
+	}
+	m.clients.WithLabelValues(proto).Dec()
+}
+
+// LockWait реализация structs.StorageObserver
+func (m *Metrics) LockWait(d time.Duration, write bool) {
+	if m == nil {
+		return
+	}
+	mode := "read"
+	if write {
+		mode = "write"
+	}
+	m.lockWait.WithLabelValues(mode).Observe(d.Seconds())
+}
+
+// JanitorRun реализация structs.StorageObserver
+func (m *Metrics) JanitorRun(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.janitor.Observe(d.Seconds())
+}
+
+// Результаты операций хранилища
+const (
+	ResultOK    = "ok"
+	ResultMiss  = "miss"
+	ResultError = "error"
+)
+
+// ObserveStorage выполненная операция хранилища op с результатом ResultOK, ResultMiss или ResultError
+func (m *Metrics) ObserveStorage(op, result string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.storageOps.WithLabelValues(op, result).Inc()
+	m.storageTime.WithLabelValues(op).Observe(d.Seconds())
+}
@@ -0,0 +1,224 @@
+package metrics_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// value значение метрики name с метками labels (для гистограмм - количество наблюдений), -1 - метрики нет
+func value(t *testing.T, m *metrics.Metrics, name string, labels map[string]string) float64 {
+	families, err := m.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+	next:
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if want, ok := labels[label.GetName()]; ok && want != label.GetValue() {
+					continue next
+				}
+			}
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				return metric.GetCounter().GetValue()
+			case dto.MetricType_GAUGE:
+				return metric.GetGauge().GetValue()
+			case dto.MetricType_HISTOGRAM:
+				return float64(metric.GetHistogram().GetSampleCount())
+			}
+		}
+	}
+	return -1
+}
+
+func TestMetrics_Storage(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	m := metrics.New(storage)
+	storage.SetObserver(m)
+
+	storage.PutOrUpdateString("a", "12345")
+	storage.PutOrUpdateString("b", "1")
+	storage.PutOrUpdateList("list", []string{"x", "y"})
+	storage.PutOrUpdateDictionary("dict", map[string]string{"k": "v"})
+	storage.SetExpired("b", 1)
+	storage.SetExpired("list", 60000)
+	time.Sleep(5 * time.Millisecond)
+	storage.DeleteExpired()
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{name: "gokv_keys", labels: map[string]string{"type": "string"}, want: 1},
+		{name: "gokv_keys", labels: map[string]string{"type": "list"}, want: 1},
+		{name: "gokv_keys", labels: map[string]string{"type": "dictionary"}, want: 1},
+		{name: "gokv_keys", labels: map[string]string{"type": "stream"}, want: 0},
+		{name: "gokv_keys_with_ttl", want: 1},
+		{name: "gokv_expired_keys_total", want: 1},
+		{name: "gokv_evicted_keys_total", want: 0},
+		// a + 12345, list + x + y, dict + k + v
+		{name: "gokv_memory_estimated_bytes", want: 6 + 6 + 6},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("Testing %s%v", tt.name, tt.labels), func(t *testing.T) {
+			if got := value(t, m, tt.name, tt.labels); got != tt.want {
+				t.Errorf("value = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if n := value(t, m, "gokv_janitor_run_duration_seconds", nil); n < 1 {
+		t.Errorf("janitor runs = %v, want at least 1", n)
+	}
+	if n := value(t, m, "gokv_storage_lock_wait_seconds", map[string]string{"mode": "write"}); n < 5 {
+		t.Errorf("write lock waits = %v, want at least 5", n)
+	}
+}
+
+func TestMetrics_Tier(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	m := metrics.New(storage)
+	if got := value(t, m, "gokv_tier_keys", map[string]string{"tier": "disk"}); got != -1 {
+		t.Errorf("gokv_tier_keys without tier = %v, want no metric", got)
+	}
+
+	if err := storage.SetTier(t.TempDir(), 10); err != nil {
+		t.Fatal(err)
+	}
+	storage.PutOrUpdateString("a", "12345")
+	storage.PutOrUpdateString("b", "12345")
+	storage.PutOrUpdateString("c", "12345")
+	storage.Pin("c")
+	storage.GetElement("a")
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		{name: "gokv_tier_keys", labels: map[string]string{"tier": "memory"}, want: 1},
+		{name: "gokv_tier_keys", labels: map[string]string{"tier": "disk"}, want: 2},
+		{name: "gokv_tier_bytes", labels: map[string]string{"tier": "memory"}, want: 6},
+		{name: "gokv_tier_bytes", labels: map[string]string{"tier": "disk"}, want: 12},
+		{name: "gokv_tier_max_memory_bytes", want: 10},
+		{name: "gokv_tier_pinned_keys", want: 1},
+		{name: "gokv_tier_spills_total", want: 3},
+		{name: "gokv_tier_faults_total", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("Testing %s%v", tt.name, tt.labels), func(t *testing.T) {
+			if got := value(t, m, tt.name, tt.labels); got != tt.want {
+				t.Errorf("value = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMetrics_Servers(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	m := metrics.New(storage)
+
+	srv := httpserver.NewServer("", map[string]string{"user": "pass"}, storage)
+	srv.SetMetrics(m)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	for _, path := range []string{"/cache/key/a", "/cache/key/b", "/missing"} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+path, nil)
+		req.SetBasicAuth("user", "pass")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetMetrics(m)
+	go tcp.Serve(lis)
+	cn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	r := bufio.NewReader(cn)
+	for _, cmd := range []string{"ping", "key missing", "set string a \"1\""} {
+		fmt.Fprintf(cn, "%s\r\n", cmd)
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		labels map[string]string
+		want   float64
+	}{
+		// Отсутствующий ключ - ответ 400
+		{name: "gokv_http_requests_total", labels: map[string]string{"route": "/cache/key/:key", "code": "400"}, want: 2},
+		{name: "gokv_http_requests_total", labels: map[string]string{"route": "unmatched", "code": "404"}, want: 1},
+		{name: "gokv_http_request_duration_seconds", labels: map[string]string{"route": "/cache/key/:key"}, want: 2},
+		{name: "gokv_tcp_commands_total", labels: map[string]string{"command": "ping", "result": "ok"}, want: 1},
+		{name: "gokv_tcp_commands_total", labels: map[string]string{"command": "key", "result": "error"}, want: 1},
+		{name: "gokv_tcp_command_duration_seconds", labels: map[string]string{"command": "set"}, want: 1},
+		{name: "gokv_connected_clients", labels: map[string]string{"proto": "tcp"}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("Testing %s%v", tt.name, tt.labels), func(t *testing.T) {
+			if got := value(t, m, tt.name, tt.labels); got != tt.want {
+				t.Errorf("value = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/metrics", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := new(strings.Builder)
+	bufio.NewReader(resp.Body).WriteTo(body)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body.String(), "gokv_keys{type=\"string\"}") {
+		t.Errorf("GET /metrics = %d\n%s", resp.StatusCode, body)
+	}
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *metrics.Metrics
+	m.ObserveHTTP(http.MethodGet, "/", http.StatusOK, time.Millisecond)
+	m.ObserveTCP("ping", false, time.Millisecond)
+	m.ClientConnected(metrics.ProtoTCP)
+	m.ClientDisconnected(metrics.ProtoTCP)
+	m.LockWait(time.Millisecond, true)
+	m.JanitorRun(time.Millisecond)
+}
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"strings"
+
+	"github.com/geraev/gokvserver/structs"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// valueTypes типы значений, для которых ряд keys выводится и при отсутствии ключей
+var valueTypes = []structs.ValueType{structs.String, structs.List, structs.Dictionary, structs.Stream}
+
+// storageCollector статистика хранилища, собираемая в момент запроса метрик
+type storageCollector struct {
+	storage structs.StatsStorage
+
+	keys        *prometheus.Desc
+	keysWithTTL *prometheus.Desc
+	expired     *prometheus.Desc
+	evicted     *prometheus.Desc
+	hits        *prometheus.Desc
+	misses      *prometheus.Desc
+	memory      *prometheus.Desc
+
+	// Дисковый уровень structs.TieredStorage, ряды выводятся, пока уровень используется
+	tier          structs.TieredStorage
+	tierKeys      *prometheus.Desc
+	tierBytes     *prometheus.Desc
+	tierMaxMemory *prometheus.Desc
+	tierPinned    *prometheus.Desc
+	tierSpills    *prometheus.Desc
+	tierFaults    *prometheus.Desc
+}
+
+func newStorageCollector(storage structs.StatsStorage) *storageCollector {
+	name := func(name string) string {
+		return prometheus.BuildFQName(Namespace, "", name)
+	}
+	tier, _ := storage.(structs.TieredStorage)
+	return &storageCollector{
+		storage:     storage,
+		keys:        prometheus.NewDesc(name("keys"), "Keys by value type.", []string{"type"}, nil),
+		keysWithTTL: prometheus.NewDesc(name("keys_with_ttl"), "Keys with an expiry time.", nil, nil),
+		expired:     prometheus.NewDesc(name("expired_keys_total"), "Keys removed after their expiry time.", nil, nil),
+		evicted:     prometheus.NewDesc(name("evicted_keys_total"), "Keys evicted to free space.", nil, nil),
+		hits:        prometheus.NewDesc(name("keyspace_hits_total"), "Reads of existing keys.", nil, nil),
+		misses:      prometheus.NewDesc(name("keyspace_misses_total"), "Reads of missing keys.", nil, nil),
+		memory:      prometheus.NewDesc(name("memory_estimated_bytes"), "Estimated size of keys and values.", nil, nil),
+
+		tier:          tier,
+		tierKeys:      prometheus.NewDesc(name("tier_keys"), "Keys by the tier holding their value.", []string{"tier"}, nil),
+		tierBytes:     prometheus.NewDesc(name("tier_bytes"), "Estimated size of keys and values by tier.", []string{"tier"}, nil),
+		tierMaxMemory: prometheus.NewDesc(name("tier_max_memory_bytes"), "Size of values in memory above which values are spilled to disk.", nil, nil),
+		tierPinned:    prometheus.NewDesc(name("tier_pinned_keys"), "Keys pinned to memory.", nil, nil),
+		tierSpills:    prometheus.NewDesc(name("tier_spills_total"), "Values moved from memory to disk.", nil, nil),
+		tierFaults:    prometheus.NewDesc(name("tier_faults_total"), "Values read back from disk to memory.", nil, nil),
+	}
+}
+
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.keys
+	ch <- c.keysWithTTL
+	ch <- c.expired
+	ch <- c.evicted
+	ch <- c.hits
+	ch <- c.misses
+	ch <- c.memory
+	if c.tier != nil {
+		ch <- c.tierKeys
+		ch <- c.tierBytes
+		ch <- c.tierMaxMemory
+		ch <- c.tierPinned
+		ch <- c.tierSpills
+		ch <- c.tierFaults
+	}
+}
+
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.storage.Stats()
+	for _, t := range valueTypes {
+		ch <- prometheus.MustNewConstMetric(c.keys, prometheus.GaugeValue, float64(stats.Keys[t]), strings.ToLower(t.String()))
+	}
+	ch <- prometheus.MustNewConstMetric(c.keysWithTTL, prometheus.GaugeValue, float64(stats.KeysWithTTL))
+	ch <- prometheus.MustNewConstMetric(c.expired, prometheus.CounterValue, float64(stats.ExpiredKeys))
+	ch <- prometheus.MustNewConstMetric(c.evicted, prometheus.CounterValue, float64(stats.EvictedKeys))
+	ch <- prometheus.MustNewConstMetric(c.hits, prometheus.CounterValue, float64(stats.KeyspaceHits))
+	ch <- prometheus.MustNewConstMetric(c.misses, prometheus.CounterValue, float64(stats.KeyspaceMisses))
+	ch <- prometheus.MustNewConstMetric(c.memory, prometheus.GaugeValue, float64(stats.MemoryBytes))
+
+	if c.tier == nil {
+		return
+	}
+	tier, ok := c.tier.TierStats()
+	if !ok {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.tierKeys, prometheus.GaugeValue, float64(tier.MemoryKeys), "memory")
+	ch <- prometheus.MustNewConstMetric(c.tierKeys, prometheus.GaugeValue, float64(tier.DiskKeys), "disk")
+	ch <- prometheus.MustNewConstMetric(c.tierBytes, prometheus.GaugeValue, float64(tier.MemoryBytes), "memory")
+	ch <- prometheus.MustNewConstMetric(c.tierBytes, prometheus.GaugeValue, float64(tier.DiskBytes), "disk")
+	ch <- prometheus.MustNewConstMetric(c.tierMaxMemory, prometheus.GaugeValue, float64(tier.MaxMemory))
+	ch <- prometheus.MustNewConstMetric(c.tierPinned, prometheus.GaugeValue, float64(tier.PinnedKeys))
+	ch <- prometheus.MustNewConstMetric(c.tierSpills, prometheus.CounterValue, float64(tier.Spills))
+	ch <- prometheus.MustNewConstMetric(c.tierFaults, prometheus.CounterValue, float64(tier.Faults))
+}
@@ -0,0 +1,149 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// ACL проверка прав пользователя из контекста операции (auth.UserFromContext) на категорию read
+// или write и ключ. GetKeys возвращает только доступные пользователю ключи. Команды потоков
+// выполняются без контекста пользователя и проверяются серверами
+func ACL(users *auth.Store) Middleware {
+	return func(next structs.ContextStorage) structs.ContextStorage {
+		return acl{next: next, users: users}
+	}
+}
+
+type acl struct {
+	next  structs.ContextStorage
+	users *auth.Store
+}
+
+func (a acl) authorize(ctx context.Context, category auth.Category, key string) error {
+	return a.users.Authorize(auth.UserFromContext(ctx), category, key)
+}
+
+// MapKey проверка прав на команду ключа: изменяющие команды относятся к категории write
+func (a acl) MapKey(ctx context.Context, _, key string, write bool) (string, error) {
+	category := auth.Read
+	if write {
+		category = auth.Write
+	}
+	if err := a.authorize(ctx, category, key); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (a acl) GetKeysContext(ctx context.Context) ([]string, error) {
+	user := auth.UserFromContext(ctx)
+	if err := a.users.Can(user, auth.Read); err != nil {
+		return nil, err
+	}
+	keys, err := a.next.GetKeysContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	allowed := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if a.users.Authorize(user, auth.Read, key) == nil {
+			allowed = append(allowed, key)
+		}
+	}
+	return allowed, nil
+}
+
+// KeysRangeContext ключи диапазона, доступные пользователю. Недоступные ключи не учитываются
+// в Limit: диапазон выбирается частями, пока не наберётся Limit доступных ключей
+func (a acl) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	user := auth.UserFromContext(ctx)
+	if err := a.users.Can(user, auth.Read); err != nil {
+		return nil, err
+	}
+	allowed := make([]string, 0)
+	for {
+		keys, err := structs.KeysRange(ctx, a.next, r)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if a.users.Authorize(user, auth.Read, key) != nil {
+				continue
+			}
+			allowed = append(allowed, key)
+			if r.Limit > 0 && len(allowed) == r.Limit {
+				return allowed, nil
+			}
+		}
+		if r.Limit <= 0 || len(keys) < r.Limit {
+			return allowed, nil
+		}
+		// Следующая часть начинается после последнего полученного ключа
+		r.Start = keys[len(keys)-1] + "\x00"
+	}
+}
+
+func (a acl) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	if err := a.authorize(ctx, auth.Read, key); err != nil {
+		return nil, err
+	}
+	return a.next.GetElementContext(ctx, key)
+}
+
+func (a acl) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	if err := a.authorize(ctx, auth.Read, key); err != nil {
+		return "", err
+	}
+	return a.next.GetListElementContext(ctx, key, index)
+}
+
+func (a acl) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	if err := a.authorize(ctx, auth.Read, key); err != nil {
+		return "", err
+	}
+	return a.next.GetDictionaryElementContext(ctx, key, internalKey)
+}
+
+func (a acl) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	if err := a.authorize(ctx, auth.Write, key); err != nil {
+		return "", false, err
+	}
+	return a.next.PutOrUpdateStringContext(ctx, key, value)
+}
+
+func (a acl) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	if err := a.authorize(ctx, auth.Write, key); err != nil {
+		return nil, false, err
+	}
+	return a.next.PutOrUpdateListContext(ctx, key, value)
+}
+
+func (a acl) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	if err := a.authorize(ctx, auth.Write, key); err != nil {
+		return nil, false, err
+	}
+	return a.next.PutOrUpdateDictionaryContext(ctx, key, value)
+}
+
+func (a acl) RemoveElementContext(ctx context.Context, key string) error {
+	if err := a.authorize(ctx, auth.Write, key); err != nil {
+		return err
+	}
+	return a.next.RemoveElementContext(ctx, key)
+}
+
+func (a acl) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	if err := a.authorize(ctx, auth.Write, key); err != nil {
+		return err
+	}
+	return a.next.SetExpiredContext(ctx, key, expired)
+}
+
+func (a acl) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	if err := a.authorize(ctx, auth.Read, key); err != nil {
+		return 0, err
+	}
+	return a.next.GetTypeContext(ctx, key)
+}
@@ -0,0 +1,286 @@
+// Package middleware обёртки хранилища, добавляющие сквозное поведение (метрики, журнал, права доступа,
+// режим только для чтения, префикс ключей) без изменения самого хранилища
+package middleware
+
+import (
+	"context"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Middleware обёртка хранилища: возвращает хранилище, которое выполняет операции через next
+type Middleware func(next structs.ContextStorage) structs.ContextStorage
+
+// StreamKeyer обёртка, которая применяется и к командам потоков: проверяет ключ потока операции op
+// и возвращает ключ для следующего звена цепочки
+type StreamKeyer interface {
+	StreamKey(ctx context.Context, op, key string, write bool) (string, error)
+}
+
+// KeyMapper обёртка, которая применяется к командам ключа вне structs.ContextStorage (сведения
+// о значении, закрепление в памяти): проверяет ключ операции op с контекстом пользователя и возвращает ключ для следующего
+// звена цепочки
+type KeyMapper interface {
+	MapKey(ctx context.Context, op, key string, write bool) (string, error)
+}
+
+// Storage хранилище с операциями structs.Storage и structs.ContextStorage
+type Storage interface {
+	structs.Storage
+	structs.ContextStorage
+}
+
+// Chain цепочка обёрток вокруг storage: первая обёртка внешняя. Команды потоков передаются
+// хранилищу через обёртки, реализующие StreamKeyer; без потоков в storage они возвращают
+// structs.ErrNotSupported
+func Chain(storage structs.Storage, middlewares ...Middleware) Storage {
+	c := &chain{ContextStorage: structs.WithContext(storage)}
+	c.streams, _ = storage.(structs.StreamStorage)
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		c.ContextStorage = middlewares[i](c.ContextStorage)
+		if keyer, ok := c.ContextStorage.(StreamKeyer); ok {
+			c.keyers = append([]StreamKeyer{keyer}, c.keyers...)
+		}
+		if mapper, ok := c.ContextStorage.(KeyMapper); ok {
+			c.mappers = append([]KeyMapper{mapper}, c.mappers...)
+		}
+	}
+	return c
+}
+
+type chain struct {
+	structs.ContextStorage
+	streams structs.StreamStorage
+	// keyers обёртки для команд потоков, от внешней к внутренней
+	keyers []StreamKeyer
+	// mappers обёртки для команд ключа, от внешней к внутренней
+	mappers []KeyMapper
+}
+
+func (c *chain) GetKeys() []string {
+	keys, _ := c.GetKeysContext(context.Background())
+	return keys
+}
+
+// KeysRangeContext ключи диапазона r через все обёртки. Без RangeStorage в хранилище диапазон
+// выбирается из всех ключей
+func (c *chain) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	return structs.KeysRange(ctx, c.ContextStorage, r)
+}
+
+func (c *chain) GetElement(key string) (interface{}, error) {
+	return c.GetElementContext(context.Background(), key)
+}
+
+func (c *chain) GetListElement(key string, index int) (string, error) {
+	return c.GetListElementContext(context.Background(), key, index)
+}
+
+func (c *chain) GetDictionaryElement(key, internalKey string) (string, error) {
+	return c.GetDictionaryElementContext(context.Background(), key, internalKey)
+}
+
+func (c *chain) PutOrUpdateString(key, value string) (string, bool) {
+	previousVal, isUpdated, _ := c.PutOrUpdateStringContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (c *chain) PutOrUpdateList(key string, value []string) ([]string, bool) {
+	previousVal, isUpdated, _ := c.PutOrUpdateListContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (c *chain) PutOrUpdateDictionary(key string, value map[string]string) (map[string]string, bool) {
+	previousVal, isUpdated, _ := c.PutOrUpdateDictionaryContext(context.Background(), key, value)
+	return previousVal, isUpdated
+}
+
+func (c *chain) RemoveElement(key string) {
+	c.RemoveElementContext(context.Background(), key)
+}
+
+// SetTTL установка TTL
+// Deprecated
+func (c *chain) SetTTL(key string, keyTTL uint64) {
+	c.SetExpired(key, keyTTL)
+}
+
+func (c *chain) SetExpired(key string, expired uint64) {
+	c.SetExpiredContext(context.Background(), key, expired)
+}
+
+func (c *chain) GetType(key string) (structs.ValueType, error) {
+	return c.GetTypeContext(context.Background(), key)
+}
+
+// mapKey ключ команды после всех обёрток
+func (c *chain) mapKey(ctx context.Context, op, key string, write bool) (string, error) {
+	var err error
+	for _, mapper := range c.mappers {
+		if key, err = mapper.MapKey(ctx, op, key, write); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// streamKey ключ потока после всех обёрток
+func (c *chain) streamKey(ctx context.Context, op, key string, write bool) (string, error) {
+	if c.streams == nil {
+		return "", structs.ErrNotSupported
+	}
+	var err error
+	for _, keyer := range c.keyers {
+		if key, err = keyer.StreamKey(ctx, op, key, write); err != nil {
+			return "", err
+		}
+	}
+	return key, nil
+}
+
+// streamKeys ключи потоков после всех обёрток
+func (c *chain) streamKeys(ctx context.Context, op string, keys []string, write bool) ([]string, error) {
+	mapped := make([]string, len(keys))
+	for i, key := range keys {
+		var err error
+		if mapped[i], err = c.streamKey(ctx, op, key, write); err != nil {
+			return nil, err
+		}
+	}
+	if c.streams == nil {
+		return nil, structs.ErrNotSupported
+	}
+	return mapped, nil
+}
+
+// unmapStreams результат чтения потоков с исходными ключами
+func unmapStreams(keys, mapped []string, result map[string][]structs.StreamEntry) map[string][]structs.StreamEntry {
+	if result == nil {
+		return nil
+	}
+	unmapped := make(map[string][]structs.StreamEntry, len(result))
+	for i, key := range mapped {
+		if entries, ok := result[key]; ok {
+			unmapped[keys[i]] = entries
+		}
+	}
+	return unmapped
+}
+
+func (c *chain) StreamAdd(key, id string, fields map[string]string) (structs.StreamID, error) {
+	key, err := c.streamKey(context.Background(), "StreamAdd", key, true)
+	if err != nil {
+		return structs.StreamID{}, err
+	}
+	return c.streams.StreamAdd(key, id, fields)
+}
+
+func (c *chain) StreamAddTrim(key, id string, fields map[string]string, maxLen int) (structs.StreamID, error) {
+	key, err := c.streamKey(context.Background(), "StreamAdd", key, true)
+	if err != nil {
+		return structs.StreamID{}, err
+	}
+	return c.streams.StreamAddTrim(key, id, fields, maxLen)
+}
+
+func (c *chain) StreamRange(key, start, end string, count int) ([]structs.StreamEntry, error) {
+	key, err := c.streamKey(context.Background(), "StreamRange", key, false)
+	if err != nil {
+		return nil, err
+	}
+	return c.streams.StreamRange(key, start, end, count)
+}
+
+func (c *chain) StreamRead(keys, ids []string, count int, block time.Duration) (map[string][]structs.StreamEntry, error) {
+	return c.StreamReadContext(context.Background(), keys, ids, count, block)
+}
+
+func (c *chain) StreamReadContext(ctx context.Context, keys, ids []string, count int, block time.Duration) (map[string][]structs.StreamEntry, error) {
+	mapped, err := c.streamKeys(ctx, "StreamRead", keys, false)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string][]structs.StreamEntry
+	if reader, ok := c.streams.(structs.ContextStreamReader); ok {
+		result, err = reader.StreamReadContext(ctx, mapped, ids, count, block)
+	} else {
+		result, err = c.streams.StreamRead(mapped, ids, count, block)
+	}
+	return unmapStreams(keys, mapped, result), err
+}
+
+func (c *chain) StreamLen(key string) (int, error) {
+	key, err := c.streamKey(context.Background(), "StreamLen", key, false)
+	if err != nil {
+		return 0, err
+	}
+	return c.streams.StreamLen(key)
+}
+
+func (c *chain) StreamTrim(key string, maxLen int) (int, error) {
+	key, err := c.streamKey(context.Background(), "StreamTrim", key, true)
+	if err != nil {
+		return 0, err
+	}
+	return c.streams.StreamTrim(key, maxLen)
+}
+
+func (c *chain) StreamGroupCreate(key, group, id string, mkStream bool) error {
+	key, err := c.streamKey(context.Background(), "StreamGroupCreate", key, true)
+	if err != nil {
+		return err
+	}
+	return c.streams.StreamGroupCreate(key, group, id, mkStream)
+}
+
+func (c *chain) StreamGroupDestroy(key, group string) (bool, error) {
+	key, err := c.streamKey(context.Background(), "StreamGroupDestroy", key, true)
+	if err != nil {
+		return false, err
+	}
+	return c.streams.StreamGroupDestroy(key, group)
+}
+
+func (c *chain) StreamReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	return c.StreamReadGroupContext(context.Background(), group, consumer, keys, ids, count, block, noAck)
+}
+
+func (c *chain) StreamReadGroupContext(ctx context.Context, group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	// Чтение группой меняет список ожидающих записей
+	mapped, err := c.streamKeys(ctx, "StreamReadGroup", keys, true)
+	if err != nil {
+		return nil, err
+	}
+	var result map[string][]structs.StreamEntry
+	if reader, ok := c.streams.(structs.ContextStreamReader); ok {
+		result, err = reader.StreamReadGroupContext(ctx, group, consumer, mapped, ids, count, block, noAck)
+	} else {
+		result, err = c.streams.StreamReadGroup(group, consumer, mapped, ids, count, block, noAck)
+	}
+	return unmapStreams(keys, mapped, result), err
+}
+
+func (c *chain) StreamAck(key, group string, ids []string) (int, error) {
+	key, err := c.streamKey(context.Background(), "StreamAck", key, true)
+	if err != nil {
+		return 0, err
+	}
+	return c.streams.StreamAck(key, group, ids)
+}
+
+func (c *chain) StreamPending(key, group string) ([]structs.PendingEntry, error) {
+	key, err := c.streamKey(context.Background(), "StreamPending", key, false)
+	if err != nil {
+		return nil, err
+	}
+	return c.streams.StreamPending(key, group)
+}
+
+func (c *chain) StreamClaim(key, group, consumer string, minIdle time.Duration, ids []string) ([]structs.StreamEntry, error) {
+	key, err := c.streamKey(context.Background(), "StreamClaim", key, true)
+	if err != nil {
+		return nil, err
+	}
+	return c.streams.StreamClaim(key, group, consumer, minIdle, ids)
+}
@@ -0,0 +1,35 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Inspector сведения о значениях i хранилища, обёрнутого цепочкой storage: ключ проверяется
+// и преобразуется обёртками цепочки, реализующими KeyMapper, как ключ чтения. Если storage
+// не цепочка, i возвращается без изменений
+func Inspector(storage structs.Storage, i structs.InspectStorage) structs.InspectStorage {
+	c, ok := storage.(*chain)
+	if !ok || i == nil {
+		return i
+	}
+	return inspector{storage: i, chain: c}
+}
+
+type inspector struct {
+	storage structs.InspectStorage
+	chain   *chain
+}
+
+func (i inspector) Inspect(key string) (structs.KeyInfo, error) {
+	return i.InspectContext(context.Background(), key)
+}
+
+func (i inspector) InspectContext(ctx context.Context, key string) (structs.KeyInfo, error) {
+	key, err := i.chain.mapKey(ctx, "Inspect", key, false)
+	if err != nil {
+		return structs.KeyInfo{}, err
+	}
+	return structs.Inspect(ctx, i.storage, key)
+}
@@ -0,0 +1,424 @@
+package middleware_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/btree"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/middleware"
+	"github.com/geraev/gokvserver/storagetest"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// newUsers пользователи с паролем secret: reader (чтение ключей pub:*) и writer (чтение и запись всех ключей)
+func newUsers(t *testing.T) *auth.Store {
+	t.Helper()
+	hash, err := auth.HashPassword("secret", "bcrypt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, err := auth.NewStore([]auth.User{
+		{Name: "reader", Password: hash, Categories: []auth.Category{auth.Read}, Keys: []string{"pub:*"}},
+		{Name: "writer", Password: hash, Categories: []auth.Category{auth.Read, auth.Write}, Keys: []string{"*"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return users
+}
+
+func TestChain_Order(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+
+	var calls []string
+	trace := func(name string) middleware.Middleware {
+		return middleware.Observe(func(_ context.Context, op, key string, _ time.Duration, _ error) {
+			calls = append(calls, name+" "+op+" "+key)
+		})
+	}
+	chain := middleware.Chain(storage, trace("outer"), middleware.Prefix("app:"), trace("inner"))
+	chain.PutOrUpdateString("a", "1")
+
+	// Внутренняя обёртка видит ключ с префиксом и завершается первой
+	want := []string{"inner PutOrUpdateString app:a", "outer PutOrUpdateString a"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if _, err := storage.GetElement("app:a"); err != nil {
+		t.Errorf("base storage: %v", err)
+	}
+}
+
+func TestChain_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) structs.Storage {
+		storage := mapbased.NewStorage()
+		t.Cleanup(func() { storage.Close() })
+		return middleware.Chain(storage, middleware.Metrics(nil), middleware.Prefix("app:"))
+	})
+}
+
+func TestMiddleware(t *testing.T) {
+	users := newUsers(t)
+	reader := auth.WithUser(context.Background(), "reader")
+	writer := auth.WithUser(context.Background(), "writer")
+
+	tests := []struct {
+		name       string
+		middleware middleware.Middleware
+		ctx        context.Context
+		run        func(ctx context.Context, s middleware.Storage) error
+		wantErr    error
+		// wantKeys ключи хранилища после операции
+		wantKeys []string
+	}{
+		{
+			name: "acl read allowed", middleware: middleware.ACL(users), ctx: reader,
+			run: func(ctx context.Context, s middleware.Storage) error {
+				_, err := s.GetElementContext(ctx, "pub:a")
+				return err
+			},
+			wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "acl read denied by key", middleware: middleware.ACL(users), ctx: reader,
+			run: func(ctx context.Context, s middleware.Storage) error {
+				_, err := s.GetElementContext(ctx, "secret")
+				return err
+			},
+			wantErr: structs.ErrNoPerm, wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "acl write denied by category", middleware: middleware.ACL(users), ctx: reader,
+			run: func(ctx context.Context, s middleware.Storage) error {
+				return s.RemoveElementContext(ctx, "pub:a")
+			},
+			wantErr: structs.ErrNoPerm, wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "acl write allowed", middleware: middleware.ACL(users), ctx: writer,
+			run: func(ctx context.Context, s middleware.Storage) error {
+				return s.RemoveElementContext(ctx, "secret")
+			},
+			wantKeys: []string{"pub:a"},
+		},
+		{
+			name: "acl unknown user", middleware: middleware.ACL(users), ctx: context.Background(),
+			run: func(ctx context.Context, s middleware.Storage) error {
+				_, err := s.GetKeysContext(ctx)
+				return err
+			},
+			wantErr: structs.ErrNoAuth, wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "readonly read", middleware: middleware.ReadOnly(), ctx: context.Background(),
+			run: func(ctx context.Context, s middleware.Storage) error {
+				_, err := s.GetTypeContext(ctx, "secret")
+				return err
+			},
+			wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "readonly write", middleware: middleware.ReadOnly(), ctx: context.Background(),
+			run: func(ctx context.Context, s middleware.Storage) error {
+				_, _, err := s.PutOrUpdateListContext(ctx, "list", []string{"a"})
+				return err
+			},
+			wantErr: structs.ErrWriteDisabled, wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "readonly stream write", middleware: middleware.ReadOnly(), ctx: context.Background(),
+			run: func(_ context.Context, s middleware.Storage) error {
+				_, err := s.(structs.StreamStorage).StreamAdd("events", "*", map[string]string{"a": "1"})
+				return err
+			},
+			wantErr: structs.ErrWriteDisabled, wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "prefix write", middleware: middleware.Prefix("app:"), ctx: context.Background(),
+			run: func(ctx context.Context, s middleware.Storage) error {
+				_, _, err := s.PutOrUpdateDictionaryContext(ctx, "dict", map[string]string{"a": "1"})
+				return err
+			},
+			wantKeys: []string{"app:dict", "pub:a", "secret"},
+		},
+		{
+			name: "prefix hides other keys", middleware: middleware.Prefix("app:"), ctx: context.Background(),
+			run: func(ctx context.Context, s middleware.Storage) error {
+				_, err := s.GetElementContext(ctx, "secret")
+				return err
+			},
+			wantErr: structs.ErrKeyNotFound, wantKeys: []string{"pub:a", "secret"},
+		},
+		{
+			name: "prefix stream", middleware: middleware.Prefix("app:"), ctx: context.Background(),
+			run: func(_ context.Context, s middleware.Storage) error {
+				streams := s.(structs.StreamStorage)
+				if _, err := streams.StreamAdd("events", "1-1", map[string]string{"a": "1"}); err != nil {
+					return err
+				}
+				result, err := streams.StreamRead([]string{"events"}, []string{"0"}, 0, 0)
+				if err != nil {
+					return err
+				}
+				if len(result["events"]) != 1 {
+					return errors.New("stream read returned no entries for the original key")
+				}
+				return nil
+			},
+			wantKeys: []string{"app:events", "pub:a", "secret"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			storage := mapbased.NewStorage()
+			defer storage.Close()
+			storage.PutOrUpdateString("pub:a", "1")
+			storage.PutOrUpdateString("secret", "2")
+
+			err := tt.run(tt.ctx, middleware.Chain(storage, tt.middleware))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			keys := storage.GetKeys()
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.wantKeys) {
+				t.Errorf("keys = %q, want %q", keys, tt.wantKeys)
+			}
+		})
+	}
+}
+
+func TestMiddleware_GetKeys(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	for _, key := range []string{"pub:a", "secret", "app:pub:b", "app:c"} {
+		storage.PutOrUpdateString(key, "1")
+	}
+	users := newUsers(t)
+
+	tests := []struct {
+		name        string
+		middlewares []middleware.Middleware
+		want        []string
+	}{
+		{name: "acl", middlewares: []middleware.Middleware{middleware.ACL(users)}, want: []string{"pub:a"}},
+		{name: "prefix", middlewares: []middleware.Middleware{middleware.Prefix("app:")}, want: []string{"c", "pub:b"}},
+		{name: "acl over prefix", middlewares: []middleware.Middleware{middleware.ACL(users), middleware.Prefix("app:")}, want: []string{"pub:b"}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			keys, err := middleware.Chain(storage, tt.middlewares...).GetKeysContext(auth.WithUser(context.Background(), "reader"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(keys)
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys = %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
+
+func TestMiddleware_KeysRange(t *testing.T) {
+	users := newUsers(t)
+	bases := map[string]structs.Storage{"mapbased": mapbased.NewStorage(), "btree": btree.NewStorage()}
+	for name, base := range bases {
+		defer base.(io.Closer).Close()
+		for _, key := range []string{"pub:a", "pub:b", "secret:a", "app:pub:a", "app:pub:b", "app:pub:c", "app:x", "apq"} {
+			base.PutOrUpdateString(key, "1")
+		}
+
+		tests := []struct {
+			name        string
+			middlewares []middleware.Middleware
+			r           structs.KeyRange
+			want        []string
+		}{
+			{name: "acl", middlewares: []middleware.Middleware{middleware.ACL(users)}, want: []string{"pub:a", "pub:b"}},
+			{name: "acl limit skips hidden keys", middlewares: []middleware.Middleware{middleware.ACL(users)}, r: structs.KeyRange{Start: "p", Limit: 1}, want: []string{"pub:a"}},
+			{name: "acl limit over hidden keys", middlewares: []middleware.Middleware{middleware.ACL(users)}, r: structs.KeyRange{Limit: 2}, want: []string{"pub:a", "pub:b"}},
+			{name: "prefix", middlewares: []middleware.Middleware{middleware.Prefix("app:")}, want: []string{"pub:a", "pub:b", "pub:c", "x"}},
+			{name: "prefix range", middlewares: []middleware.Middleware{middleware.Prefix("app:")}, r: structs.KeyRange{Prefix: "pub:", Start: "pub:b", End: "pub:c"}, want: []string{"pub:b"}},
+			{name: "acl over prefix", middlewares: []middleware.Middleware{middleware.ACL(users), middleware.Prefix("app:")}, r: structs.KeyRange{Limit: 2}, want: []string{"pub:a", "pub:b"}},
+			{name: "read only", middlewares: []middleware.Middleware{middleware.ReadOnly()}, r: structs.KeyRange{Prefix: "app:", Limit: 1}, want: []string{"app:pub:a"}},
+		}
+		for _, tt := range tests {
+			t.Run("Testing "+name+" "+tt.name, func(t *testing.T) {
+				chain := middleware.Chain(base, tt.middlewares...)
+				keys, err := structs.KeysRange(auth.WithUser(context.Background(), "reader"), chain, tt.r)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(keys, tt.want) {
+					t.Errorf("keys = %q, want %q", keys, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestMiddleware_Tiered(t *testing.T) {
+	users := newUsers(t)
+	base := mapbased.NewStorage()
+	defer base.Close()
+	if err := base.SetTier(t.TempDir(), 1<<20); err != nil {
+		t.Fatal(err)
+	}
+	base.PutOrUpdateString("app:pub:a", "1")
+
+	if got := middleware.Tiered(base, base); got != structs.TieredStorage(base) {
+		t.Errorf("Tiered() without a chain = %T, want the storage", got)
+	}
+
+	tests := []struct {
+		name        string
+		middlewares []middleware.Middleware
+		user        string
+		key         string
+		want        error
+	}{
+		{name: "prefix", middlewares: []middleware.Middleware{middleware.Prefix("app:")}, key: "pub:a"},
+		{name: "prefix missing key", middlewares: []middleware.Middleware{middleware.Prefix("app:")}, key: "app:pub:a", want: structs.ErrKeyNotFound},
+		{name: "acl writer", middlewares: []middleware.Middleware{middleware.ACL(users), middleware.Prefix("app:")}, user: "writer", key: "pub:a"},
+		{name: "acl reader", middlewares: []middleware.Middleware{middleware.ACL(users), middleware.Prefix("app:")}, user: "reader", key: "pub:a", want: structs.ErrNoPerm},
+		{name: "acl without user", middlewares: []middleware.Middleware{middleware.ACL(users)}, key: "pub:a", want: structs.ErrNoAuth},
+		{name: "read only", middlewares: []middleware.Middleware{middleware.ReadOnly()}, key: "pub:a", want: structs.ErrWriteDisabled},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			tier := middleware.Tiered(middleware.Chain(base, tt.middlewares...), base)
+			ctx := context.Background()
+			if tt.user != "" {
+				ctx = auth.WithUser(ctx, tt.user)
+			}
+			if err := structs.Pin(ctx, tier, tt.key); !errors.Is(err, tt.want) {
+				t.Fatalf("Pin() error = %v, want %v", err, tt.want)
+			}
+			if tt.want != nil {
+				return
+			}
+			if stats, _ := tier.TierStats(); stats.PinnedKeys != 1 {
+				t.Errorf("PinnedKeys = %d, want 1", stats.PinnedKeys)
+			}
+			if err := structs.Unpin(ctx, tier, tt.key); err != nil {
+				t.Fatal(err)
+			}
+			if stats, _ := tier.TierStats(); stats.PinnedKeys != 0 {
+				t.Errorf("PinnedKeys after Unpin() = %d, want 0", stats.PinnedKeys)
+			}
+		})
+	}
+}
+
+func TestMiddleware_Inspector(t *testing.T) {
+	users := newUsers(t)
+	base := mapbased.NewStorage()
+	defer base.Close()
+	base.PutOrUpdateString("app:pub:a", "hello")
+
+	if got := middleware.Inspector(base, base); got != structs.InspectStorage(base) {
+		t.Errorf("Inspector() without a chain = %T, want the storage", got)
+	}
+
+	tests := []struct {
+		name        string
+		middlewares []middleware.Middleware
+		user        string
+		key         string
+		want        error
+	}{
+		{name: "prefix", middlewares: []middleware.Middleware{middleware.Prefix("app:")}, key: "pub:a"},
+		{name: "prefix missing key", middlewares: []middleware.Middleware{middleware.Prefix("app:")}, key: "app:pub:a", want: structs.ErrKeyNotFound},
+		{name: "acl reader", middlewares: []middleware.Middleware{middleware.ACL(users), middleware.Prefix("app:")}, user: "reader", key: "pub:a"},
+		{name: "acl hidden key", middlewares: []middleware.Middleware{middleware.ACL(users)}, user: "reader", key: "app:pub:a", want: structs.ErrNoPerm},
+		{name: "read only", middlewares: []middleware.Middleware{middleware.ReadOnly()}, key: "app:pub:a"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			i := middleware.Inspector(middleware.Chain(base, tt.middlewares...), base)
+			ctx := context.Background()
+			if tt.user != "" {
+				ctx = auth.WithUser(ctx, tt.user)
+			}
+			info, err := structs.Inspect(ctx, i, tt.key)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Inspect() error = %v, want %v", err, tt.want)
+			}
+			if tt.want == nil && (info.Type != structs.String || info.Size != 5) {
+				t.Errorf("Inspect() = %+v, want a string of 5 bytes", info)
+			}
+		})
+	}
+}
+
+func TestMiddleware_Observe(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	m := metrics.New(storage)
+	var out bytes.Buffer
+	logger := logging.New(&out, logging.LevelDebug)
+
+	chain := middleware.Chain(storage, middleware.Metrics(m), middleware.Logging(logger))
+	chain.PutOrUpdateString("a", "1")
+	chain.GetElement("a")
+	chain.GetElement("missing")
+	chain.GetListElement("a", 0)
+
+	tests := []struct {
+		op, result string
+		want       float64
+	}{
+		{op: "PutOrUpdateString", result: metrics.ResultOK, want: 1},
+		{op: "GetElement", result: metrics.ResultOK, want: 1},
+		{op: "GetElement", result: metrics.ResultMiss, want: 1},
+		{op: "GetListElement", result: metrics.ResultError, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.op+" "+tt.result, func(t *testing.T) {
+			if got := counter(t, m, tt.op, tt.result); got != tt.want {
+				t.Errorf("operations = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	for _, want := range []string{"PutOrUpdateString", "missing", metrics.ResultMiss, structs.ErrType.Error()} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("log %q doesn't contain %q", out.String(), want)
+		}
+	}
+}
+
+// counter значение gokv_storage_operations_total для операции op с результатом result
+func counter(t *testing.T, m *metrics.Metrics, op, result string) float64 {
+	t.Helper()
+	families, err := m.Registry().Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() != "gokv_storage_operations_total" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["op"] == op && labels["result"] == result {
+				return metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
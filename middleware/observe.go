@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Metrics учёт операций хранилища в метриках gokv_storage_operations_total
+// и gokv_storage_operation_duration_seconds
+func Metrics(m *metrics.Metrics) Middleware {
+	return Observe(func(_ context.Context, op, _ string, d time.Duration, err error) {
+		m.ObserveStorage(op, result(err), d)
+	})
+}
+
+// Logging запись операций хранилища в журнал с уровнем debug и идентификатором запроса из контекста
+func Logging(l *logging.Logger) Middleware {
+	return Observe(func(ctx context.Context, op, key string, d time.Duration, err error) {
+		if !l.Enabled(logging.LevelDebug) {
+			return
+		}
+		msg := ""
+		if err != nil {
+			msg = err.Error()
+		}
+		l.Debug(ctx, "storage", "op", op, "key", key, "duration", d, "result", result(err), "error", msg)
+	})
+}
+
+// result результат операции для метрик и журнала: отсутствующий ключ - промах, а не ошибка
+func result(err error) string {
+	switch {
+	case err == nil:
+		return metrics.ResultOK
+	case errors.Is(err, structs.ErrKeyNotFound):
+		return metrics.ResultMiss
+	default:
+		return metrics.ResultError
+	}
+}
+
+// Observe вызов observe после каждой операции с её именем (как у метода без Context), ключом,
+// длительностью и ошибкой
+func Observe(observe func(ctx context.Context, op, key string, d time.Duration, err error)) Middleware {
+	return func(next structs.ContextStorage) structs.ContextStorage {
+		return observed{next: next, observe: observe}
+	}
+}
+
+type observed struct {
+	next    structs.ContextStorage
+	observe func(ctx context.Context, op, key string, d time.Duration, err error)
+}
+
+func (o observed) GetKeysContext(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	keys, err := o.next.GetKeysContext(ctx)
+	o.observe(ctx, "GetKeys", "", time.Since(start), err)
+	return keys, err
+}
+
+func (o observed) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	start := time.Now()
+	keys, err := structs.KeysRange(ctx, o.next, r)
+	o.observe(ctx, "KeysRange", r.Prefix, time.Since(start), err)
+	return keys, err
+}
+
+func (o observed) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	start := time.Now()
+	val, err := o.next.GetElementContext(ctx, key)
+	o.observe(ctx, "GetElement", key, time.Since(start), err)
+	return val, err
+}
+
+func (o observed) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	start := time.Now()
+	val, err := o.next.GetListElementContext(ctx, key, index)
+	o.observe(ctx, "GetListElement", key, time.Since(start), err)
+	return val, err
+}
+
+func (o observed) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	start := time.Now()
+	val, err := o.next.GetDictionaryElementContext(ctx, key, internalKey)
+	o.observe(ctx, "GetDictionaryElement", key, time.Since(start), err)
+	return val, err
+}
+
+func (o observed) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	start := time.Now()
+	previousVal, isUpdated, err := o.next.PutOrUpdateStringContext(ctx, key, value)
+	o.observe(ctx, "PutOrUpdateString", key, time.Since(start), err)
+	return previousVal, isUpdated, err
+}
+
+func (o observed) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	start := time.Now()
+	previousVal, isUpdated, err := o.next.PutOrUpdateListContext(ctx, key, value)
+	o.observe(ctx, "PutOrUpdateList", key, time.Since(start), err)
+	return previousVal, isUpdated, err
+}
+
+func (o observed) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	start := time.Now()
+	previousVal, isUpdated, err := o.next.PutOrUpdateDictionaryContext(ctx, key, value)
+	o.observe(ctx, "PutOrUpdateDictionary", key, time.Since(start), err)
+	return previousVal, isUpdated, err
+}
+
+func (o observed) RemoveElementContext(ctx context.Context, key string) error {
+	start := time.Now()
+	err := o.next.RemoveElementContext(ctx, key)
+	o.observe(ctx, "RemoveElement", key, time.Since(start), err)
+	return err
+}
+
+func (o observed) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	start := time.Now()
+	err := o.next.SetExpiredContext(ctx, key, expired)
+	o.observe(ctx, "SetExpired", key, time.Since(start), err)
+	return err
+}
+
+func (o observed) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	start := time.Now()
+	t, err := o.next.GetTypeContext(ctx, key)
+	o.observe(ctx, "GetType", key, time.Since(start), err)
+	return t, err
+}
@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Prefix пространство ключей с префиксом prefix: ключи операций хранятся с префиксом,
+// GetKeys возвращает только ключи с префиксом и без него
+func Prefix(prefix string) Middleware {
+	return func(next structs.ContextStorage) structs.ContextStorage {
+		return prefixed{next: next, prefix: prefix}
+	}
+}
+
+type prefixed struct {
+	next   structs.ContextStorage
+	prefix string
+}
+
+func (p prefixed) StreamKey(_ context.Context, _, key string, _ bool) (string, error) {
+	return p.prefix + key, nil
+}
+
+func (p prefixed) MapKey(_ context.Context, _, key string, _ bool) (string, error) {
+	return p.prefix + key, nil
+}
+
+func (p prefixed) GetKeysContext(ctx context.Context) ([]string, error) {
+	keys, err := p.next.GetKeysContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasPrefix(key, p.prefix) {
+			result = append(result, key[len(p.prefix):])
+		}
+	}
+	return result, nil
+}
+
+// KeysRangeContext диапазон внутри пространства ключей с префиксом
+func (p prefixed) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	r.Prefix = p.prefix + r.Prefix
+	if r.Start != "" {
+		r.Start = p.prefix + r.Start
+	}
+	if r.End != "" {
+		r.End = p.prefix + r.End
+	}
+	keys, err := structs.KeysRange(ctx, p.next, r)
+	if err != nil {
+		return nil, err
+	}
+	for i, key := range keys {
+		keys[i] = key[len(p.prefix):]
+	}
+	return keys, nil
+}
+
+func (p prefixed) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	return p.next.GetElementContext(ctx, p.prefix+key)
+}
+
+func (p prefixed) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	return p.next.GetListElementContext(ctx, p.prefix+key, index)
+}
+
+func (p prefixed) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	return p.next.GetDictionaryElementContext(ctx, p.prefix+key, internalKey)
+}
+
+func (p prefixed) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	return p.next.PutOrUpdateStringContext(ctx, p.prefix+key, value)
+}
+
+func (p prefixed) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	return p.next.PutOrUpdateListContext(ctx, p.prefix+key, value)
+}
+
+func (p prefixed) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	return p.next.PutOrUpdateDictionaryContext(ctx, p.prefix+key, value)
+}
+
+func (p prefixed) RemoveElementContext(ctx context.Context, key string) error {
+	return p.next.RemoveElementContext(ctx, p.prefix+key)
+}
+
+func (p prefixed) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	return p.next.SetExpiredContext(ctx, p.prefix+key, expired)
+}
+
+func (p prefixed) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	return p.next.GetTypeContext(ctx, p.prefix+key)
+}
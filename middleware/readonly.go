@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// ReadOnly режим только для чтения: изменяющие операции, в том числе команды потоков и закрепление
+// ключей в памяти, возвращают structs.ErrWriteDisabled
+func ReadOnly() Middleware {
+	return func(next structs.ContextStorage) structs.ContextStorage {
+		return readOnly{next}
+	}
+}
+
+type readOnly struct {
+	structs.ContextStorage
+}
+
+func (readOnly) StreamKey(_ context.Context, _, key string, write bool) (string, error) {
+	if write {
+		return "", structs.ErrWriteDisabled
+	}
+	return key, nil
+}
+
+func (r readOnly) MapKey(ctx context.Context, op, key string, write bool) (string, error) {
+	return r.StreamKey(ctx, op, key, write)
+}
+
+func (r readOnly) KeysRangeContext(ctx context.Context, kr structs.KeyRange) ([]string, error) {
+	return structs.KeysRange(ctx, r.ContextStorage, kr)
+}
+
+func (readOnly) PutOrUpdateStringContext(context.Context, string, string) (string, bool, error) {
+	return "", false, structs.ErrWriteDisabled
+}
+
+func (readOnly) PutOrUpdateListContext(context.Context, string, []string) ([]string, bool, error) {
+	return nil, false, structs.ErrWriteDisabled
+}
+
+func (readOnly) PutOrUpdateDictionaryContext(context.Context, string, map[string]string) (map[string]string, bool, error) {
+	return nil, false, structs.ErrWriteDisabled
+}
+
+func (readOnly) RemoveElementContext(context.Context, string) error {
+	return structs.ErrWriteDisabled
+}
+
+func (readOnly) SetExpiredContext(context.Context, string, uint64) error {
+	return structs.ErrWriteDisabled
+}
@@ -0,0 +1,190 @@
+package middleware_test
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/middleware"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// command первая строка ответа TCP сервера на команду cmd, для bulk строки - её значение
+func command(t *testing.T, cn net.Conn, r *bufio.Reader, cmd string) string {
+	t.Helper()
+	fmt.Fprintf(cn, "%s\r\n", cmd)
+	return readLine(t, r)
+}
+
+// readLine строка ответа, у bulk строки - её значение, у массива - элементы через запятую
+func readLine(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	line, err := r.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	line = strings.TrimSpace(line)
+	switch {
+	case strings.HasPrefix(line, "$") && line != "$-1":
+		if line, err = r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+		line = strings.TrimSpace(line)
+	case strings.HasPrefix(line, "*") && line != "*-1":
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		items := make([]string, n)
+		for i := range items {
+			items[i] = readLine(t, r)
+		}
+		line = strings.Join(items, ",")
+	}
+	return line
+}
+
+// serve TCP сервер, подключённый пользователем writer, и HTTP обработчик над storage
+func serve(t *testing.T, storage structs.Storage) (cn net.Conn, r *bufio.Reader, url string, stop func()) {
+	t.Helper()
+	users := newUsers(t)
+
+	srv := httpserver.NewServer("", nil, storage)
+	srv.SetUsers(users)
+	ts := httptest.NewServer(srv.Handler())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetUsers(users)
+	go tcp.Serve(lis)
+	if cn, err = net.Dial("tcp", lis.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	r = bufio.NewReader(cn)
+	if reply := command(t, cn, r, "auth writer secret"); reply != "+OK" {
+		t.Fatalf("auth: %s", reply)
+	}
+	return cn, r, ts.URL, func() {
+		cn.Close()
+		lis.Close()
+		ts.Close()
+	}
+}
+
+// request ответ HTTP сервера пользователю writer
+func request(t *testing.T, method, url, body string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.SetBasicAuth("writer", "secret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(data))
+}
+
+func TestChain_Servers(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	m := metrics.New(storage)
+	users := newUsers(t)
+	chain := middleware.Chain(storage,
+		middleware.Metrics(m),
+		middleware.Logging(logging.New(ioutil.Discard, logging.LevelDebug)),
+		middleware.ACL(users),
+		middleware.Prefix("app:"),
+	)
+	cn, r, url, stop := serve(t, chain)
+	defer stop()
+
+	tests := []struct {
+		name string
+		run  func() string
+		want string
+	}{
+		{
+			name: "http set",
+			run: func() string {
+				code, _ := request(t, http.MethodPut, url+"/cache/set/string/a", `{"value": "hello"}`)
+				return fmt.Sprint(code)
+			},
+			want: "200",
+		},
+		{name: "tcp get", run: func() string { return command(t, cn, r, "key a") }, want: "hello"},
+		{name: "tcp set", run: func() string { return command(t, cn, r, "set string b world") }, want: "+OK"},
+		{
+			name: "http get",
+			run: func() string {
+				_, body := request(t, http.MethodGet, url+"/cache/key/b", "")
+				return body
+			},
+			want: `{"value":"world"}`,
+		},
+		{name: "tcp remove", run: func() string { return command(t, cn, r, "remove b") }, want: "+OK"},
+		{name: "tcp keys", run: func() string { return command(t, cn, r, "keys") }, want: "a"},
+		{name: "tcp stream", run: func() string { return command(t, cn, r, "xadd events 1-1 f v") }, want: "1-1"},
+		{name: "tcp stream len", run: func() string { return command(t, cn, r, "xlen events") }, want: ":1"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			if got := tt.run(); got != tt.want {
+				t.Errorf("reply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, key := range []string{"app:a", "app:events"} {
+		if _, err := storage.GetType(key); err != nil {
+			t.Errorf("base storage key %q: %v", key, err)
+		}
+	}
+	if got := counter(t, m, "PutOrUpdateString", metrics.ResultOK); got != 2 {
+		t.Errorf("string writes = %v, want 2", got)
+	}
+}
+
+func TestChain_ServersReadOnly(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	storage.PutOrUpdateString("a", "hello")
+	cn, r, url, stop := serve(t, middleware.Chain(storage, middleware.ReadOnly()))
+	defer stop()
+
+	if reply := command(t, cn, r, "key a"); reply != "hello" {
+		t.Errorf("tcp get = %q, want hello", reply)
+	}
+	if reply := command(t, cn, r, "set string a world"); !strings.HasPrefix(reply, "-READONLY") {
+		t.Errorf("tcp set = %q, want a READONLY error", reply)
+	}
+	if reply := command(t, cn, r, "xadd events * f v"); !strings.HasPrefix(reply, "-READONLY") {
+		t.Errorf("tcp xadd = %q, want a READONLY error", reply)
+	}
+	if code, _ := request(t, http.MethodDelete, url+"/cache/remove/a", ""); code != http.StatusForbidden {
+		t.Errorf("http remove = %d, want %d", code, http.StatusForbidden)
+	}
+	if val, _ := storage.GetElement("a"); val != "hello" {
+		t.Errorf("value = %v, want hello", val)
+	}
+}
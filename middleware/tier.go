@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"context"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Tiered дисковый уровень t хранилища, обёрнутого цепочкой storage: ключи команд закрепления
+// проверяются и преобразуются обёртками цепочки, реализующими KeyMapper, как ключи изменяющих
+// операций. Если storage не цепочка, t возвращается без изменений
+func Tiered(storage structs.Storage, t structs.TieredStorage) structs.TieredStorage {
+	c, ok := storage.(*chain)
+	if !ok || t == nil {
+		return t
+	}
+	return tiered{TieredStorage: t, chain: c}
+}
+
+type tiered struct {
+	structs.TieredStorage
+	chain *chain
+}
+
+func (t tiered) Pin(key string) error {
+	return t.PinContext(context.Background(), key)
+}
+
+func (t tiered) Unpin(key string) error {
+	return t.UnpinContext(context.Background(), key)
+}
+
+func (t tiered) PinContext(ctx context.Context, key string) error {
+	key, err := t.chain.mapKey(ctx, "Pin", key, true)
+	if err != nil {
+		return err
+	}
+	return structs.Pin(ctx, t.TieredStorage, key)
+}
+
+func (t tiered) UnpinContext(ctx context.Context, key string) error {
+	key, err := t.chain.mapKey(ctx, "Unpin", key, true)
+	if err != nil {
+		return err
+	}
+	return structs.Unpin(ctx, t.TieredStorage, key)
+}
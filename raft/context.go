@@ -0,0 +1,88 @@
+package raft
+
+import (
+	"context"
+	"time"
+
+	"github.com/geraev/gokvserver/replication"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Операции с контекстом. Запись возвращает ошибку, если операция не закоммичена до отмены ctx
+// или истечения proposeTimeout, например при потере ведущим узлом большинства
+
+func (s *Storage) GetKeysContext(ctx context.Context) ([]string, error) {
+	return structs.WithContext(s.storage).GetKeysContext(ctx)
+}
+
+func (s *Storage) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	return structs.WithContext(s.storage).GetElementContext(ctx, key)
+}
+
+func (s *Storage) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	return structs.WithContext(s.storage).GetListElementContext(ctx, key, index)
+}
+
+func (s *Storage) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	return structs.WithContext(s.storage).GetDictionaryElementContext(ctx, key, internalKey)
+}
+
+func (s *Storage) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	return structs.WithContext(s.storage).GetTypeContext(ctx, key)
+}
+
+func (s *Storage) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	r, err := s.propose(ctx, setOp(key, structs.String, value))
+	if err != nil {
+		return "", false, err
+	}
+	previousVal, _ := r.PreviousVal.(string)
+	return previousVal, r.IsUpdated, nil
+}
+
+func (s *Storage) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	r, err := s.propose(ctx, setOp(key, structs.List, value))
+	if err != nil {
+		return nil, false, err
+	}
+	previousVal, _ := r.PreviousVal.([]string)
+	return previousVal, r.IsUpdated, nil
+}
+
+func (s *Storage) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	r, err := s.propose(ctx, setOp(key, structs.Dictionary, value))
+	if err != nil {
+		return nil, false, err
+	}
+	previousVal, _ := r.PreviousVal.(map[string]string)
+	return previousVal, r.IsUpdated, nil
+}
+
+func (s *Storage) RemoveElementContext(ctx context.Context, key string) error {
+	_, err := s.propose(ctx, &replication.Op{Kind: replication.OpRemove, Key: key})
+	return err
+}
+
+func (s *Storage) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	if expired == 0 {
+		return ctx.Err()
+	}
+	_, err := s.propose(ctx, &replication.Op{Kind: replication.OpExpireAt, Key: key, Deadline: deadline(expired)})
+	return err
+}
+
+// StreamReadGroupContext новые записи ожидаются в локальном хранилище, затем чтение коммитится через журнал
+// с моментом выдачи по часам ведущего узла
+func (s *Storage) StreamReadGroupContext(ctx context.Context, group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	replayer, ok := s.streams.(structs.StreamGroupReplayer)
+	if !ok {
+		return nil, structs.ErrNotSupported
+	}
+	return structs.WaitReadGroup(ctx, replayer, group, keys, ids, block, func() (map[string][]structs.StreamEntry, error) {
+		r, err := s.propose(ctx, &replication.Op{
+			Kind: replication.OpStreamReadGroup, Group: group, Consumer: consumer, Keys: keys, IDs: ids,
+			Count: count, NoAck: noAck, At: time.Now().UnixNano(),
+		})
+		return r.Streams, err
+	})
+}
@@ -0,0 +1,273 @@
+package raft
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"hash/crc32"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Файлы каталога состояния узла
+const (
+	stateFile    = "state"
+	logFile      = "log"
+	snapshotFile = "snapshot"
+
+	// recordHeaderSize длина и контрольная сумма записи файла
+	recordHeaderSize = 8
+)
+
+var errCorrupted = errors.New("raft state file is corrupted")
+
+// hardState срок и голос узла, которые должны пережить перезапуск
+type hardState struct {
+	Term     uint64 `json:"term"`
+	VotedFor string `json:"voted_for"`
+}
+
+// snapshot снимок конечного автомата после применения записей журнала до Index включительно
+// и конфигурация кластера на этот момент
+type snapshot struct {
+	Index uint64
+	Term  uint64
+	Peers map[string]string
+	Data  []byte
+}
+
+// disk состояние узла на диске: срок и голос, журнал и снимок. Каждая запись завершается fsync.
+// Журнал только дописывается: запись с индексом, который уже есть в журнале, заменяет его хвост.
+// Методы nil-диска ничего не делают, состояние такого узла хранится только в памяти
+type disk struct {
+	dir string
+	log *os.File
+	buf []byte
+}
+
+// openDisk открытие каталога состояния и чтение сохранённого состояния. Пустой dir - состояние в памяти
+func openDisk(dir string) (*disk, hardState, *snapshot, []LogEntry, error) {
+	var state hardState
+	if dir == "" {
+		return nil, state, nil, nil, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, state, nil, nil, err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, stateFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, state, nil, nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &state); err != nil {
+			return nil, state, nil, nil, err
+		}
+	}
+
+	var snap *snapshot
+	data, err = ioutil.ReadFile(filepath.Join(dir, snapshotFile))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, state, nil, nil, err
+	}
+	if err == nil {
+		payload, n := readRecord(data)
+		if payload == nil || n != len(data) {
+			return nil, state, nil, nil, errCorrupted
+		}
+		snap = new(snapshot)
+		if err := json.Unmarshal(payload, snap); err != nil {
+			return nil, state, nil, nil, err
+		}
+	}
+
+	path := filepath.Join(dir, logFile)
+	data, err = ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, state, nil, nil, err
+	}
+	var (
+		entries []LogEntry
+		good    int
+	)
+	for {
+		payload, n := readRecord(data[good:])
+		if payload == nil {
+			break
+		}
+		var entry LogEntry
+		if err := json.Unmarshal(payload, &entry); err != nil {
+			break
+		}
+		entries = appendEntry(entries, entry)
+		good += n
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, state, nil, nil, err
+	}
+	if good < len(data) {
+		// Недописанный хвост журнала (например, после сбоя питания) отбрасывается
+		log.Printf("raft: %s: %d bytes of a partial record discarded", path, len(data)-good)
+		if err := f.Truncate(int64(good)); err != nil {
+			f.Close()
+			return nil, state, nil, nil, err
+		}
+	}
+	return &disk{dir: dir, log: f}, state, snap, afterSnapshot(entries, snap), nil
+}
+
+// appendEntry добавление записи журнала при чтении: запись заменяет записи с тем же и большими индексами
+func appendEntry(entries []LogEntry, entry LogEntry) []LogEntry {
+	if len(entries) > 0 && entry.Index <= entries[len(entries)-1].Index {
+		if entry.Index < entries[0].Index {
+			return append(entries[:0], entry)
+		}
+		entries = entries[:entry.Index-entries[0].Index]
+	}
+	return append(entries, entry)
+}
+
+// afterSnapshot записи журнала, следующие за снимком. Если журнал расходится со снимком
+// или не продолжает его, записи отбрасываются: узел получит их от ведущего узла
+func afterSnapshot(entries []LogEntry, snap *snapshot) []LogEntry {
+	if snap == nil || len(entries) == 0 {
+		return entries
+	}
+	first := entries[0].Index
+	switch {
+	case first > snap.Index+1:
+		return nil
+	case snap.Index < first:
+		return entries
+	case snap.Index-first >= uint64(len(entries)):
+		return nil
+	case entries[snap.Index-first].Term != snap.Term:
+		return nil
+	}
+	return entries[snap.Index-first+1:]
+}
+
+// saveState сохранение срока и голоса
+func (d *disk) saveState(state hardState) error {
+	if d == nil {
+		return nil
+	}
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(d.dir, stateFile), data)
+}
+
+// append дописывание записей в журнал
+func (d *disk) append(entries []LogEntry) error {
+	if d == nil || len(entries) == 0 {
+		return nil
+	}
+	buf, err := encodeEntries(d.buf[:0], entries)
+	if err != nil {
+		return err
+	}
+	d.buf = buf
+	if _, err := d.log.Write(buf); err != nil {
+		return err
+	}
+	return d.log.Sync()
+}
+
+// saveSnapshot сохранение снимка и замена журнала записями entries, следующими за ним.
+// Снимок сохраняется первым: после сбоя между шагами лишние записи журнала отбрасываются при чтении
+func (d *disk) saveSnapshot(snap *snapshot, entries []LogEntry) error {
+	if d == nil {
+		return nil
+	}
+	payload, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(d.dir, snapshotFile), appendRecord(nil, payload)); err != nil {
+		return err
+	}
+
+	data, err := encodeEntries(nil, entries)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(d.dir, logFile)
+	if err := writeFile(path, data); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	d.log.Close()
+	d.log = f
+	return nil
+}
+
+func (d *disk) close() error {
+	if d == nil {
+		return nil
+	}
+	return d.log.Close()
+}
+
+func encodeEntries(buf []byte, entries []LogEntry) ([]byte, error) {
+	for _, entry := range entries {
+		payload, err := json.Marshal(entry)
+		if err != nil {
+			return nil, err
+		}
+		buf = appendRecord(buf, payload)
+	}
+	return buf, nil
+}
+
+// appendRecord запись: длина, контрольная сумма, данные
+func appendRecord(buf, payload []byte) []byte {
+	var header [recordHeaderSize]byte
+	binary.LittleEndian.PutUint32(header[:], uint32(len(payload)))
+	binary.LittleEndian.PutUint32(header[4:], crc32.ChecksumIEEE(payload))
+	return append(append(buf, header[:]...), payload...)
+}
+
+// readRecord данные первой записи data и её полная длина. Неполная или повреждённая запись - nil
+func readRecord(data []byte) ([]byte, int) {
+	if len(data) < recordHeaderSize {
+		return nil, 0
+	}
+	n := int(binary.LittleEndian.Uint32(data))
+	sum := binary.LittleEndian.Uint32(data[4:])
+	payload := data[recordHeaderSize:]
+	if n > len(payload) || crc32.ChecksumIEEE(payload[:n]) != sum {
+		return nil, 0
+	}
+	return payload[:n], recordHeaderSize + n
+}
+
+// writeFile атомарная замена файла: данные пишутся во временный файл рядом и переименовываются
+func writeFile(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,1206 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultElectionTimeout   = 300 * time.Millisecond
+	DefaultHeartbeatInterval = 50 * time.Millisecond
+	DefaultSnapshotThreshold = 8192
+
+	maxBatch = 512
+	// installTimeout ожидание передачи снимка отстающему узлу
+	installTimeout = 10 * time.Second
+)
+
+var (
+	ErrStopped                = errors.New("raft node is stopped")
+	ErrLeadershipLost         = errors.New("leadership lost before the entry was committed")
+	ErrConfigChangeInProgress = errors.New("another membership change is in progress")
+	ErrUnknownNode            = errors.New("unknown node")
+)
+
+// NotLeaderError операция должна выполняться на ведущем узле
+type NotLeaderError struct {
+	Leader string
+}
+
+func (e *NotLeaderError) Error() string {
+	if e.Leader == "" {
+		return "not leader: leader is unknown"
+	}
+	return fmt.Sprintf("not leader: current leader is %s", e.Leader)
+}
+
+// State роль узла
+type State int
+
+const (
+	Follower State = iota
+	Candidate
+	Leader
+)
+
+func (s State) String() string {
+	return [...]string{"follower", "candidate", "leader"}[s]
+}
+
+// EntryType тип записи журнала
+type EntryType int
+
+const (
+	EntryCommand EntryType = iota
+	EntryNoop
+	EntryConfig
+)
+
+// LogEntry запись журнала
+type LogEntry struct {
+	Index uint64
+	Term  uint64
+	Type  EntryType
+	Data  []byte
+}
+
+// FSM применение закоммиченной команды к конечному автомату. Вызывается последовательно, в порядке журнала
+type FSM func(data []byte) interface{}
+
+// Config параметры узла
+type Config struct {
+	// ID идентификатор узла
+	ID string
+	// Peers начальная конфигурация кластера: идентификатор узла -> адрес транспорта, включая сам узел.
+	// Узел, добавляемый в работающий кластер, запускается с пустой конфигурацией и ждёт обращения ведущего узла
+	Peers map[string]string
+	// Transport транспорт для обмена сообщениями между узлами
+	Transport Transport
+	// Apply конечный автомат
+	Apply FSM
+	// Dir каталог, в котором узел хранит срок, голос, журнал и снимок. Перезапущенный узел продолжает
+	// работу с сохранённого состояния. Без каталога состояние хранится только в памяти
+	Dir string
+	// Snapshot снимок конечного автомата, Restore замена его состояния снимком. Restore с пустыми
+	// данными возвращает конечный автомат в начальное состояние. Без Snapshot журнал не сокращается
+	Snapshot func() ([]byte, error)
+	Restore  func(data []byte) error
+	// SnapshotThreshold количество применённых записей, после которого снимается снимок
+	// и журнал сокращается (по умолчанию DefaultSnapshotThreshold)
+	SnapshotThreshold uint64
+
+	ElectionTimeout   time.Duration
+	HeartbeatInterval time.Duration
+}
+
+// Status состояние узла
+type Status struct {
+	ID            string            `json:"id"`
+	State         string            `json:"state"`
+	Term          uint64            `json:"term"`
+	Leader        string            `json:"leader"`
+	SnapshotIndex uint64            `json:"snapshot_index"`
+	LastIndex     uint64            `json:"last_index"`
+	CommitIndex   uint64            `json:"commit_index"`
+	LastApplied   uint64            `json:"last_applied"`
+	Peers         map[string]string `json:"peers"`
+}
+
+type result struct {
+	value interface{}
+	err   error
+}
+
+type waiter struct {
+	term uint64
+	ch   chan result
+}
+
+// Node узел кластера Raft. Срок, голос и журнал сохраняются на диск до ответа другим узлам,
+// если задан каталог состояния. Применённые записи периодически заменяются снимком конечного автомата.
+// Первая запись журнала хранит индекс и срок последнего снимка
+type Node struct {
+	id                string
+	transport         Transport
+	apply             FSM
+	snapshotFn        func() ([]byte, error)
+	restoreFn         func(data []byte) error
+	snapshotThreshold uint64
+	electionTimeout   time.Duration
+	heartbeatInterval time.Duration
+	disk              *disk
+
+	mu            sync.Mutex
+	state         State
+	term          uint64
+	votedFor      string
+	votes         map[string]bool
+	preVotes      map[string]bool
+	log           []LogEntry
+	snapshot      *snapshot
+	restore       *snapshot
+	commitIndex   uint64
+	lastApplied   uint64
+	initialPeers  map[string]string
+	peers         map[string]string
+	configIndex   uint64
+	leaderID      string
+	leaderContact time.Time
+	deadline      time.Time
+	nextIndex     map[string]uint64
+	matchIndex    map[string]uint64
+	inflight      map[string]bool
+	lastAck       map[string]time.Time
+	waiters       map[uint64]*waiter
+	applied       chan struct{}
+	rnd           *rand.Rand
+
+	commitCh chan struct{}
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewNode создание узла. Сохранённое в config.Dir состояние загружается: снимок передаётся
+// конечному автомату при запуске, записи журнала применяются после подтверждения коммита ведущим узлом
+func NewNode(config Config) (*Node, error) {
+	if config.ElectionTimeout <= 0 {
+		config.ElectionTimeout = DefaultElectionTimeout
+	}
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = DefaultHeartbeatInterval
+	}
+	if config.SnapshotThreshold == 0 {
+		config.SnapshotThreshold = DefaultSnapshotThreshold
+	}
+	d, state, snap, entries, err := openDisk(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	n := &Node{
+		id:                config.ID,
+		transport:         config.Transport,
+		apply:             config.Apply,
+		snapshotFn:        config.Snapshot,
+		restoreFn:         config.Restore,
+		snapshotThreshold: config.SnapshotThreshold,
+		electionTimeout:   config.ElectionTimeout,
+		heartbeatInterval: config.HeartbeatInterval,
+		disk:              d,
+		term:              state.Term,
+		votedFor:          state.VotedFor,
+		log:               append([]LogEntry{{}}, entries...),
+		initialPeers:      copyPeers(config.Peers),
+		peers:             copyPeers(config.Peers),
+		waiters:           make(map[uint64]*waiter),
+		applied:           make(chan struct{}),
+		rnd:               rand.New(rand.NewSource(time.Now().UnixNano())),
+		commitCh:          make(chan struct{}, 1),
+		stop:              make(chan struct{}),
+	}
+	if snap != nil {
+		n.log[0] = LogEntry{Index: snap.Index, Term: snap.Term}
+		n.snapshot, n.restore = snap, snap
+		n.commitIndex = snap.Index
+	} else if len(entries) > 0 {
+		// Журнал применяется заново к конечному автомату в начальном состоянии
+		n.restore = &snapshot{}
+	}
+	n.applyConfig()
+	n.resetDeadline()
+	return n, nil
+}
+
+// Start запуск таймеров и применения журнала
+func (n *Node) Start() {
+	n.wg.Add(2)
+	go n.run()
+	go n.runApply()
+}
+
+// Stop остановка узла
+func (n *Node) Stop() {
+	n.mu.Lock()
+	select {
+	case <-n.stop:
+		n.mu.Unlock()
+		return
+	default:
+	}
+	close(n.stop)
+	for index, w := range n.waiters {
+		w.ch <- result{err: ErrStopped}
+		delete(n.waiters, index)
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+
+	n.mu.Lock()
+	if err := n.disk.close(); err != nil {
+		log.Printf("raft: %s: close: %v", n.id, err)
+	}
+	n.mu.Unlock()
+}
+
+// stopped остановлен ли узел. Остановленный узел не отвечает на сообщения
+func (n *Node) stopped() bool {
+	select {
+	case <-n.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// ID идентификатор узла
+func (n *Node) ID() string {
+	return n.id
+}
+
+// IsLeader является ли узел ведущим
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.state == Leader
+}
+
+// Leader идентификатор известного узлу ведущего узла
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leaderID
+}
+
+// Status текущее состояние узла
+func (n *Node) Status() Status {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return Status{
+		ID:            n.id,
+		State:         n.state.String(),
+		Term:          n.term,
+		Leader:        n.leaderID,
+		SnapshotIndex: n.firstIndex(),
+		LastIndex:     n.lastIndex(),
+		CommitIndex:   n.commitIndex,
+		LastApplied:   n.lastApplied,
+		Peers:         copyPeers(n.peers),
+	}
+}
+
+// Propose добавление команды в журнал. Возвращает результат применения команды к конечному автомату
+// после того, как запись закоммичена большинством узлов
+func (n *Node) Propose(ctx context.Context, data []byte) (interface{}, error) {
+	n.mu.Lock()
+	if n.state != Leader {
+		err := &NotLeaderError{Leader: n.leaderID}
+		n.mu.Unlock()
+		return nil, err
+	}
+	w, err := n.appendLocked(EntryCommand, data)
+	n.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	return n.wait(ctx, w)
+}
+
+// AddNode добавление узла в кластер
+func (n *Node) AddNode(ctx context.Context, id, addr string) error {
+	return n.changeConfig(ctx, func(peers map[string]string) error {
+		peers[id] = addr
+		return nil
+	})
+}
+
+// RemoveNode удаление узла из кластера
+func (n *Node) RemoveNode(ctx context.Context, id string) error {
+	return n.changeConfig(ctx, func(peers map[string]string) error {
+		if _, ok := peers[id]; !ok {
+			return ErrUnknownNode
+		}
+		delete(peers, id)
+		return nil
+	})
+}
+
+// changeConfig изменение состава кластера по одному узлу за раз. Новая конфигурация действует
+// с момента добавления в журнал
+func (n *Node) changeConfig(ctx context.Context, change func(peers map[string]string) error) error {
+	n.mu.Lock()
+	if n.state != Leader {
+		err := &NotLeaderError{Leader: n.leaderID}
+		n.mu.Unlock()
+		return err
+	}
+	if n.configIndex > n.commitIndex {
+		n.mu.Unlock()
+		return ErrConfigChangeInProgress
+	}
+	peers := copyPeers(n.peers)
+	if err := change(peers); err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	data, err := json.Marshal(peers)
+	if err != nil {
+		n.mu.Unlock()
+		return err
+	}
+	w, err := n.appendLocked(EntryConfig, data)
+	n.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, err = n.wait(ctx, w)
+	return err
+}
+
+// ReadIndex подтверждение того, что узел остаётся ведущим, и ожидание применения всех записей,
+// закоммиченных к моменту вызова. После успешного вызова чтение из конечного автомата линеаризуемо
+func (n *Node) ReadIndex(ctx context.Context) error {
+	// Ведущий узел должен закоммитить хотя бы одну запись своего срока
+	for {
+		n.mu.Lock()
+		if n.state != Leader {
+			err := &NotLeaderError{Leader: n.leaderID}
+			n.mu.Unlock()
+			return err
+		}
+		if n.termAt(n.commitIndex) == n.term {
+			break
+		}
+		applied := n.applied
+		n.mu.Unlock()
+		if err := n.waitSignal(ctx, applied); err != nil {
+			return err
+		}
+	}
+	readIndex, term := n.commitIndex, n.term
+	n.mu.Unlock()
+
+	if err := n.confirmLeadership(ctx, term); err != nil {
+		return err
+	}
+
+	for {
+		n.mu.Lock()
+		if n.lastApplied >= readIndex {
+			n.mu.Unlock()
+			return nil
+		}
+		applied := n.applied
+		n.mu.Unlock()
+		if err := n.waitSignal(ctx, applied); err != nil {
+			return err
+		}
+	}
+}
+
+// confirmLeadership раунд пустых AppendEntries: большинство узлов должно признать срок term
+func (n *Node) confirmLeadership(ctx context.Context, term uint64) error {
+	n.mu.Lock()
+	var (
+		args = &AppendEntriesArgs{Term: term, LeaderID: n.id}
+		acks = make(chan bool, len(n.peers))
+		need = n.quorum()
+		sent = 0
+	)
+	if _, ok := n.peers[n.id]; ok {
+		need--
+	}
+	for id, addr := range n.peers {
+		if id == n.id {
+			continue
+		}
+		sent++
+		go func(addr string) {
+			callCtx, cancel := context.WithTimeout(ctx, n.electionTimeout)
+			defer cancel()
+			reply, err := n.transport.AppendEntries(callCtx, addr, args)
+			if err == nil && reply.Term > term {
+				n.mu.Lock()
+				n.stepDown(reply.Term, "")
+				n.mu.Unlock()
+			}
+			acks <- err == nil && reply.Term == term
+		}(addr)
+	}
+	n.mu.Unlock()
+
+	for ; need > 0 && sent > 0; sent-- {
+		select {
+		case ok := <-acks:
+			if ok {
+				need--
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if need > 0 {
+		return &NotLeaderError{Leader: n.Leader()}
+	}
+	return nil
+}
+
+// appendLocked добавление записи в журнал ведущего узла. Вызывается под блокировкой
+func (n *Node) appendLocked(typ EntryType, data []byte) (*waiter, error) {
+	entry := LogEntry{Index: n.lastIndex() + 1, Term: n.term, Type: typ, Data: data}
+	if err := n.disk.append([]LogEntry{entry}); err != nil {
+		return nil, err
+	}
+	n.log = append(n.log, entry)
+	if typ == EntryConfig {
+		n.applyConfig()
+	}
+
+	w := &waiter{term: n.term, ch: make(chan result, 1)}
+	n.waiters[entry.Index] = w
+	n.advanceCommit()
+	n.broadcast()
+	return w, nil
+}
+
+func (n *Node) wait(ctx context.Context, w *waiter) (interface{}, error) {
+	select {
+	case r := <-w.ch:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.stop:
+		return nil, ErrStopped
+	}
+}
+
+func (n *Node) waitSignal(ctx context.Context, signal <-chan struct{}) error {
+	select {
+	case <-signal:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.stop:
+		return ErrStopped
+	}
+}
+
+// run таймеры выборов и heartbeat
+func (n *Node) run() {
+	defer n.wg.Done()
+
+	ticker := time.NewTicker(n.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-ticker.C:
+		}
+
+		n.mu.Lock()
+		if n.state == Leader && !n.hasQuorumContact() {
+			// Ведущий узел, потерявший связь с большинством, слагает полномочия
+			n.stepDown(n.term, "")
+		} else if n.state == Leader {
+			n.broadcast()
+		} else if time.Now().After(n.deadline) {
+			n.startElection()
+		}
+		n.mu.Unlock()
+	}
+}
+
+// runApply применение закоммиченных записей к конечному автомату
+func (n *Node) runApply() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.stop:
+			return
+		case <-n.commitCh:
+		}
+
+		for {
+			n.mu.Lock()
+			if snap := n.restore; snap != nil {
+				n.restore = nil
+				n.mu.Unlock()
+				n.restoreSnapshot(snap)
+				continue
+			}
+			if n.lastApplied >= n.commitIndex {
+				n.mu.Unlock()
+				break
+			}
+			entry := n.entry(n.lastApplied + 1)
+			w := n.waiters[entry.Index]
+			delete(n.waiters, entry.Index)
+			n.mu.Unlock()
+
+			var value interface{}
+			if entry.Type == EntryCommand && n.apply != nil {
+				value = n.apply(entry.Data)
+			}
+
+			n.mu.Lock()
+			n.lastApplied = entry.Index
+			n.notifyApplied()
+			compact := n.snapshotFn != nil && n.lastApplied-n.firstIndex() >= n.snapshotThreshold
+			n.mu.Unlock()
+
+			if w != nil {
+				if w.term == entry.Term {
+					w.ch <- result{value: value}
+				} else {
+					w.ch <- result{err: ErrLeadershipLost}
+				}
+			}
+			if compact {
+				n.takeSnapshot()
+			}
+		}
+	}
+}
+
+// restoreSnapshot загрузка снимка в конечный автомат. Ожидающие записей снимка операции завершаются
+// ошибкой: их результат неизвестен
+func (n *Node) restoreSnapshot(snap *snapshot) {
+	if n.restoreFn != nil {
+		if err := n.restoreFn(snap.Data); err != nil {
+			log.Printf("raft: %s: restore snapshot %d: %v", n.id, snap.Index, err)
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for index, w := range n.waiters {
+		if index <= snap.Index {
+			w.ch <- result{err: ErrLeadershipLost}
+			delete(n.waiters, index)
+		}
+	}
+	n.lastApplied = snap.Index
+	n.notifyApplied()
+}
+
+// takeSnapshot снимок конечного автомата и сокращение журнала. Вызывается из runApply между
+// применениями записей, поэтому снимок соответствует lastApplied
+func (n *Node) takeSnapshot() {
+	data, err := n.snapshotFn()
+	if err != nil {
+		log.Printf("raft: %s: snapshot: %v", n.id, err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	index := n.lastApplied
+	if n.restore != nil || index <= n.firstIndex() {
+		// Пока снимался снимок, узел получил более новый снимок от ведущего узла
+		return
+	}
+	snap := &snapshot{Index: index, Term: n.termAt(index), Peers: n.configAt(index), Data: data}
+	if err := n.compact(snap); err != nil {
+		log.Printf("raft: %s: snapshot: %v", n.id, err)
+	}
+}
+
+// compact сохранение снимка и удаление из журнала вошедших в него записей. Записи после снимка
+// сохраняются, если журнал с ним согласован. Вызывается под блокировкой
+func (n *Node) compact(snap *snapshot) error {
+	var rest []LogEntry
+	if snap.Index < n.lastIndex() && n.termAt(snap.Index) == snap.Term {
+		rest = append(rest, n.log[snap.Index-n.firstIndex()+1:]...)
+	}
+	if err := n.disk.saveSnapshot(snap, rest); err != nil {
+		return err
+	}
+	n.log = append([]LogEntry{{Index: snap.Index, Term: snap.Term}}, rest...)
+	n.snapshot = snap
+	return nil
+}
+
+// notifyApplied оповещение ожидающих применения записей. Вызывается под блокировкой
+func (n *Node) notifyApplied() {
+	close(n.applied)
+	n.applied = make(chan struct{})
+}
+
+// startElection предварительное голосование (PreVote): срок увеличивается, только если большинство
+// готово голосовать за узел. Отрезанный от кластера узел не наращивает срок и после восстановления
+// сети не смещает действующего ведущего узла. Вызывается под блокировкой
+func (n *Node) startElection() {
+	n.resetDeadline()
+	if _, ok := n.peers[n.id]; !ok {
+		// Узел не входит в конфигурацию кластера и не может быть избран
+		return
+	}
+
+	n.preVotes = map[string]bool{n.id: true}
+	if n.countVotes(n.preVotes) >= n.quorum() {
+		n.campaign()
+		return
+	}
+	args := &RequestVoteArgs{
+		Term:         n.term + 1,
+		CandidateID:  n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+		PreVote:      true,
+	}
+	for id, addr := range n.peers {
+		if id == n.id {
+			continue
+		}
+		go n.requestVote(id, addr, args)
+	}
+}
+
+// campaign переход в кандидаты и рассылка запросов голосов. Вызывается под блокировкой
+func (n *Node) campaign() {
+	n.resetDeadline()
+	n.preVotes = nil
+	if err := n.disk.saveState(hardState{Term: n.term + 1, VotedFor: n.id}); err != nil {
+		log.Printf("raft: %s: save state: %v", n.id, err)
+		return
+	}
+	n.state = Candidate
+	n.term++
+	n.votedFor = n.id
+	n.votes = map[string]bool{n.id: true}
+	n.leaderID = ""
+	if len(n.votes) >= n.quorum() {
+		n.becomeLeader()
+		return
+	}
+
+	args := &RequestVoteArgs{
+		Term:         n.term,
+		CandidateID:  n.id,
+		LastLogIndex: n.lastIndex(),
+		LastLogTerm:  n.termAt(n.lastIndex()),
+	}
+	for id, addr := range n.peers {
+		if id == n.id {
+			continue
+		}
+		go n.requestVote(id, addr, args)
+	}
+}
+
+func (n *Node) requestVote(id, addr string, args *RequestVoteArgs) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+	defer cancel()
+	reply, err := n.transport.RequestVote(ctx, addr, args)
+	if err != nil {
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if reply.Term > n.term {
+		n.stepDown(reply.Term, "")
+		return
+	}
+	if args.PreVote {
+		if n.preVotes == nil || n.state == Leader || n.term+1 != args.Term || !reply.VoteGranted {
+			return
+		}
+		n.preVotes[id] = true
+		if n.countVotes(n.preVotes) >= n.quorum() {
+			n.campaign()
+		}
+		return
+	}
+	if n.state != Candidate || n.term != args.Term || !reply.VoteGranted {
+		return
+	}
+	n.votes[id] = true
+	if n.countVotes(n.votes) >= n.quorum() {
+		n.becomeLeader()
+	}
+}
+
+// countVotes голоса узлов текущей конфигурации
+func (n *Node) countVotes(votes map[string]bool) int {
+	count := 0
+	for id := range n.peers {
+		if votes[id] {
+			count++
+		}
+	}
+	return count
+}
+
+// becomeLeader вызывается под блокировкой
+func (n *Node) becomeLeader() {
+	// Пустая запись текущего срока позволяет закоммитить записи предыдущих сроков
+	noop := LogEntry{Index: n.lastIndex() + 1, Term: n.term, Type: EntryNoop}
+	if err := n.disk.append([]LogEntry{noop}); err != nil {
+		log.Printf("raft: %s: append: %v", n.id, err)
+		n.stepDown(n.term, "")
+		return
+	}
+	n.log = append(n.log, noop)
+
+	n.state = Leader
+	n.leaderID = n.id
+	n.nextIndex = make(map[string]uint64)
+	n.matchIndex = make(map[string]uint64)
+	n.inflight = make(map[string]bool)
+	n.lastAck = make(map[string]time.Time)
+	for id := range n.peers {
+		n.nextIndex[id] = noop.Index
+		n.lastAck[id] = time.Now()
+	}
+	n.advanceCommit()
+	n.broadcast()
+}
+
+// stepDown переход в ведомые. Вызывается под блокировкой
+func (n *Node) stepDown(term uint64, leader string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		if err := n.disk.saveState(hardState{Term: term}); err != nil {
+			log.Printf("raft: %s: save state: %v", n.id, err)
+		}
+	}
+	n.state = Follower
+	n.preVotes = nil
+	n.leaderID = leader
+	if leader != "" {
+		n.leaderContact = time.Now()
+	}
+	n.resetDeadline()
+}
+
+// broadcast репликация журнала на все узлы. Вызывается под блокировкой
+func (n *Node) broadcast() {
+	for id := range n.peers {
+		if id == n.id || n.inflight[id] {
+			continue
+		}
+		if _, ok := n.nextIndex[id]; !ok {
+			n.nextIndex[id] = n.lastIndex() + 1
+		}
+		n.inflight[id] = true
+		go n.replicate(id)
+	}
+}
+
+// replicate отправка AppendEntries одному узлу. Узлу, которому нужны записи, уже замененные снимком,
+// отправляется снимок
+func (n *Node) replicate(id string) {
+	n.mu.Lock()
+	addr, ok := n.peers[id]
+	if n.state != Leader || !ok {
+		n.inflight[id] = false
+		n.mu.Unlock()
+		return
+	}
+	next := n.nextIndex[id]
+	if next <= n.firstIndex() {
+		n.mu.Unlock()
+		n.installSnapshot(id, addr)
+		return
+	}
+	last := n.lastIndex()
+	if last-next+1 > maxBatch {
+		last = next + maxBatch - 1
+	}
+	args := &AppendEntriesArgs{
+		Term:         n.term,
+		LeaderID:     n.id,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAt(next - 1),
+		Entries:      append([]LogEntry(nil), n.log[next-n.firstIndex():last-n.firstIndex()+1]...),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.electionTimeout)
+	reply, err := n.transport.AppendEntries(ctx, addr, args)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.inflight[id] = false
+	if err != nil {
+		return
+	}
+	if !n.acknowledged(id, args.Term, reply.Term) {
+		return
+	}
+
+	if reply.Success {
+		n.matched(id, args.PrevLogIndex+uint64(len(args.Entries)))
+	} else {
+		next := reply.ConflictIndex
+		if next < 1 || next >= args.PrevLogIndex+1 {
+			next = args.PrevLogIndex
+		}
+		if next < 1 {
+			next = 1
+		}
+		n.nextIndex[id] = next
+	}
+	n.continueReplication(id)
+}
+
+// installSnapshot отправка последнего снимка узлу id
+func (n *Node) installSnapshot(id, addr string) {
+	n.mu.Lock()
+	if n.state != Leader {
+		n.inflight[id] = false
+		n.mu.Unlock()
+		return
+	}
+	args := &InstallSnapshotArgs{
+		Term:              n.term,
+		LeaderID:          n.id,
+		LastIncludedIndex: n.snapshot.Index,
+		LastIncludedTerm:  n.snapshot.Term,
+		Peers:             n.snapshot.Peers,
+		Data:              n.snapshot.Data,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), installTimeout)
+	reply, err := n.transport.InstallSnapshot(ctx, addr, args)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.inflight[id] = false
+	if err != nil {
+		return
+	}
+	if !n.acknowledged(id, args.Term, reply.Term) {
+		return
+	}
+	n.matched(id, args.LastIncludedIndex)
+	n.continueReplication(id)
+}
+
+// acknowledged обработка срока ответа узла id на сообщение срока term. Возвращает false, если
+// узел больше не ведущий этого срока. Вызывается под блокировкой
+func (n *Node) acknowledged(id string, term, replyTerm uint64) bool {
+	if replyTerm > n.term {
+		n.stepDown(replyTerm, "")
+		return false
+	}
+	if n.state != Leader || n.term != term {
+		return false
+	}
+	n.lastAck[id] = time.Now()
+	return true
+}
+
+// matched узел id подтвердил совпадение журнала до индекса match. Вызывается под блокировкой
+func (n *Node) matched(id string, match uint64) {
+	if match > n.matchIndex[id] {
+		n.matchIndex[id] = match
+	}
+	n.nextIndex[id] = match + 1
+	n.advanceCommit()
+}
+
+// continueReplication отправка узлу id оставшихся записей. Вызывается под блокировкой
+func (n *Node) continueReplication(id string) {
+	if _, ok := n.peers[id]; ok && n.nextIndex[id] <= n.lastIndex() {
+		n.inflight[id] = true
+		go n.replicate(id)
+	}
+}
+
+// advanceCommit продвижение индекса коммита ведущего узла. Вызывается под блокировкой
+func (n *Node) advanceCommit() {
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		if n.termAt(index) != n.term {
+			// Записи предыдущих сроков коммитятся только вместе с записями текущего
+			break
+		}
+		count := 0
+		for id := range n.peers {
+			if id == n.id || n.matchIndex[id] >= index {
+				count++
+			}
+		}
+		if count >= n.quorum() {
+			n.setCommitIndex(index)
+			break
+		}
+	}
+
+	// Ведущий узел, удалённый из кластера, слагает полномочия после коммита новой конфигурации
+	if _, ok := n.peers[n.id]; !ok && n.state == Leader && n.commitIndex >= n.configIndex {
+		n.stepDown(n.term, "")
+	}
+}
+
+// hasQuorumContact получал ли ведущий узел ответы большинства за последний таймаут выборов.
+// Вызывается под блокировкой
+func (n *Node) hasQuorumContact() bool {
+	count := 0
+	for id := range n.peers {
+		if id == n.id {
+			count++
+			continue
+		}
+		if ack, ok := n.lastAck[id]; !ok || time.Since(ack) < n.electionTimeout {
+			// Только что добавленный узел считается доступным
+			count++
+		}
+	}
+	return count >= n.quorum()
+}
+
+func (n *Node) setCommitIndex(index uint64) {
+	n.commitIndex = index
+	select {
+	case n.commitCh <- struct{}{}:
+	default:
+	}
+}
+
+// HandleRequestVote обработка запроса голоса
+func (n *Node) HandleRequestVote(args *RequestVoteArgs) *RequestVoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply := &RequestVoteReply{Term: n.term}
+	if args.Term < n.term || n.stopped() {
+		return reply
+	}
+	// Пока действующий ведущий узел на связи, запросы голосов игнорируются: так удалённые
+	// и отрезанные узлы не срывают работу кластера
+	if n.state == Leader || (n.leaderID != "" && time.Since(n.leaderContact) < n.electionTimeout) {
+		return reply
+	}
+	lastIndex := n.lastIndex()
+	lastTerm := n.termAt(lastIndex)
+	upToDate := args.LastLogTerm > lastTerm || (args.LastLogTerm == lastTerm && args.LastLogIndex >= lastIndex)
+	if args.PreVote {
+		// Предварительный голос не меняет состояние получателя
+		reply.VoteGranted = args.Term > n.term && upToDate
+		return reply
+	}
+	if args.Term > n.term {
+		n.stepDown(args.Term, "")
+		reply.Term = n.term
+	}
+
+	if (n.votedFor == "" || n.votedFor == args.CandidateID) && upToDate {
+		// Голос сохраняется до ответа: после перезапуска узел не проголосует в этом сроке повторно
+		if err := n.disk.saveState(hardState{Term: n.term, VotedFor: args.CandidateID}); err != nil {
+			log.Printf("raft: %s: save state: %v", n.id, err)
+			return reply
+		}
+		n.votedFor = args.CandidateID
+		n.resetDeadline()
+		reply.VoteGranted = true
+	}
+	return reply
+}
+
+// HandleAppendEntries обработка репликации журнала и heartbeat. Записи сохраняются до ответа
+func (n *Node) HandleAppendEntries(args *AppendEntriesArgs) *AppendEntriesReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply := &AppendEntriesReply{Term: n.term}
+	if args.Term < n.term || n.stopped() {
+		return reply
+	}
+	n.stepDown(args.Term, args.LeaderID)
+	reply.Term = n.term
+
+	prevIndex, prevTerm, entries := args.PrevLogIndex, args.PrevLogTerm, args.Entries
+	if first := n.firstIndex(); prevIndex < first {
+		// Записи до снимка закоммичены и совпадают с записями ведущего узла
+		skip := first - prevIndex
+		if skip > uint64(len(entries)) {
+			skip = uint64(len(entries))
+		}
+		prevIndex, prevTerm, entries = first, n.log[0].Term, entries[skip:]
+	}
+
+	lastIndex := n.lastIndex()
+	if prevIndex > lastIndex {
+		reply.ConflictIndex = lastIndex + 1
+		return reply
+	}
+	if term := n.termAt(prevIndex); term != prevTerm {
+		// Пропускаем весь конфликтующий срок
+		index := prevIndex
+		for index > n.commitIndex+1 && n.termAt(index-1) == term {
+			index--
+		}
+		reply.ConflictIndex = index
+		return reply
+	}
+
+	// Записи, которые уже есть в журнале, пропускаются
+	for len(entries) > 0 {
+		index := prevIndex + 1
+		if index > n.lastIndex() || n.termAt(index) != entries[0].Term {
+			break
+		}
+		prevIndex, entries = index, entries[1:]
+	}
+	if len(entries) > 0 {
+		if err := n.disk.append(entries); err != nil {
+			log.Printf("raft: %s: append: %v", n.id, err)
+			reply.ConflictIndex = prevIndex + 1
+			return reply
+		}
+		configChanged := false
+		if prevIndex < n.lastIndex() {
+			n.truncate(prevIndex + 1)
+			configChanged = true
+		}
+		for _, entry := range entries {
+			n.log = append(n.log, entry)
+			if entry.Type == EntryConfig {
+				configChanged = true
+			}
+		}
+		if configChanged {
+			n.applyConfig()
+		}
+	}
+
+	if args.LeaderCommit > n.commitIndex {
+		last := args.PrevLogIndex + uint64(len(args.Entries))
+		if args.LeaderCommit < last {
+			last = args.LeaderCommit
+		}
+		if last > n.commitIndex {
+			n.setCommitIndex(last)
+		}
+	}
+	reply.Success = true
+	return reply
+}
+
+// HandleInstallSnapshot обработка снимка ведущего узла. Журнал, согласованный со снимком,
+// сохраняется после него, иначе отбрасывается целиком
+func (n *Node) HandleInstallSnapshot(args *InstallSnapshotArgs) *InstallSnapshotReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	reply := &InstallSnapshotReply{Term: n.term}
+	if args.Term < n.term || n.stopped() {
+		return reply
+	}
+	n.stepDown(args.Term, args.LeaderID)
+	reply.Term = n.term
+	if args.LastIncludedIndex <= n.commitIndex {
+		return reply
+	}
+
+	if args.LastIncludedIndex > n.lastIndex() || n.termAt(args.LastIncludedIndex) != args.LastIncludedTerm {
+		n.truncate(n.firstIndex() + 1)
+	}
+	snap := &snapshot{
+		Index: args.LastIncludedIndex,
+		Term:  args.LastIncludedTerm,
+		Peers: args.Peers,
+		Data:  args.Data,
+	}
+	if err := n.compact(snap); err != nil {
+		log.Printf("raft: %s: install snapshot: %v", n.id, err)
+		return reply
+	}
+	n.restore = snap
+	n.applyConfig()
+	n.setCommitIndex(snap.Index)
+	return reply
+}
+
+// truncate удаление незакоммиченных записей начиная с index. Вызывается под блокировкой
+func (n *Node) truncate(index uint64) {
+	for i := index; i <= n.lastIndex(); i++ {
+		if w, ok := n.waiters[i]; ok {
+			w.ch <- result{err: ErrLeadershipLost}
+			delete(n.waiters, i)
+		}
+	}
+	n.log = n.log[:index-n.firstIndex()]
+}
+
+// applyConfig конфигурация кластера определяется последней записью конфигурации в журнале
+func (n *Node) applyConfig() {
+	n.peers, n.configIndex = n.config(n.lastIndex())
+}
+
+// configAt конфигурация кластера после записи index
+func (n *Node) configAt(index uint64) map[string]string {
+	peers, _ := n.config(index)
+	return copyPeers(peers)
+}
+
+// config последняя запись конфигурации не позже index и её индекс. Конфигурация записей,
+// вошедших в снимок, хранится в снимке
+func (n *Node) config(index uint64) (map[string]string, uint64) {
+	for i := index; i > n.firstIndex(); i-- {
+		entry := n.entry(i)
+		if entry.Type != EntryConfig {
+			continue
+		}
+		var peers map[string]string
+		if err := json.Unmarshal(entry.Data, &peers); err == nil {
+			return peers, i
+		}
+	}
+	if n.snapshot != nil {
+		return copyPeers(n.snapshot.Peers), n.snapshot.Index
+	}
+	return copyPeers(n.initialPeers), 0
+}
+
+// firstIndex индекс последней записи, вошедшей в снимок
+func (n *Node) firstIndex() uint64 {
+	return n.log[0].Index
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.firstIndex() + uint64(len(n.log)-1)
+}
+
+// entry запись журнала с индексом index, который не меньше firstIndex
+func (n *Node) entry(index uint64) LogEntry {
+	return n.log[index-n.firstIndex()]
+}
+
+func (n *Node) termAt(index uint64) uint64 {
+	return n.entry(index).Term
+}
+
+func (n *Node) quorum() int {
+	return len(n.peers)/2 + 1
+}
+
+func (n *Node) resetDeadline() {
+	n.deadline = time.Now().Add(n.electionTimeout + time.Duration(n.rnd.Int63n(int64(n.electionTimeout))))
+}
+
+func copyPeers(peers map[string]string) map[string]string {
+	result := make(map[string]string, len(peers))
+	for id, addr := range peers {
+		result[id] = addr
+	}
+	return result
+}
@@ -0,0 +1,650 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+const (
+	testElectionTimeout   = 50 * time.Millisecond
+	testHeartbeatInterval = 10 * time.Millisecond
+)
+
+// cluster узлы, связанные сетью в памяти процесса
+type cluster struct {
+	t       *testing.T
+	network *MemNetwork
+	nodes   map[string]*Node
+	peers   map[string]string
+	// dir каталог состояния узлов, пустой - состояние в памяти
+	dir       string
+	threshold uint64
+
+	mu      sync.Mutex
+	applied map[string][]string
+}
+
+func newCluster(t *testing.T, size int) *cluster {
+	return newClusterDir(t, size, "", 0)
+}
+
+// newDurableCluster кластер, узлы которого хранят состояние на диске и снимают снимок
+// после threshold применённых записей
+func newDurableCluster(t *testing.T, size int, threshold uint64) *cluster {
+	return newClusterDir(t, size, t.TempDir(), threshold)
+}
+
+func newClusterDir(t *testing.T, size int, dir string, threshold uint64) *cluster {
+	c := &cluster{
+		t:         t,
+		network:   NewMemNetwork(),
+		nodes:     make(map[string]*Node),
+		peers:     make(map[string]string),
+		dir:       dir,
+		threshold: threshold,
+		applied:   make(map[string][]string),
+	}
+	for i := 1; i <= size; i++ {
+		c.peers[fmt.Sprint("n", i)] = fmt.Sprint("n", i)
+	}
+	for id := range c.peers {
+		c.start(id, c.peers)
+	}
+	return c
+}
+
+// start запуск узла id с начальной конфигурацией peers
+func (c *cluster) start(id string, peers map[string]string) *Node {
+	config := Config{
+		ID:                id,
+		Peers:             peers,
+		Transport:         c.network.Transport(id),
+		Apply:             c.fsm(id),
+		ElectionTimeout:   testElectionTimeout,
+		HeartbeatInterval: testHeartbeatInterval,
+	}
+	if c.dir != "" {
+		config.Dir = filepath.Join(c.dir, id)
+		config.Snapshot = c.snapshot(id)
+		config.Restore = c.restore(id)
+		config.SnapshotThreshold = c.threshold
+	}
+	node, err := NewNode(config)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.network.Register(id, node)
+	c.nodes[id] = node
+	node.Start()
+	c.t.Cleanup(node.Stop)
+	return node
+}
+
+func (c *cluster) fsm(id string) FSM {
+	return func(data []byte) interface{} {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.applied[id] = append(c.applied[id], string(data))
+		return len(c.applied[id])
+	}
+}
+
+// restart перезапуск узла id с сохранённым состоянием
+func (c *cluster) restart(id string) *Node {
+	c.nodes[id].Stop()
+	return c.start(id, c.peers)
+}
+
+func (c *cluster) snapshot(id string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return json.Marshal(c.applied[id])
+	}
+}
+
+func (c *cluster) restore(id string) func(data []byte) error {
+	return func(data []byte) error {
+		var applied []string
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &applied); err != nil {
+				return err
+			}
+		}
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.applied[id] = applied
+		return nil
+	}
+}
+
+func (c *cluster) appliedBy(id string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.applied[id]...)
+}
+
+// leader ожидание единственного ведущего узла среди ids (по умолчанию среди всех узлов)
+func (c *cluster) leader(ids ...string) *Node {
+	c.t.Helper()
+	if len(ids) == 0 {
+		for id := range c.nodes {
+			ids = append(ids, id)
+		}
+	}
+
+	var leader *Node
+	eventually(c.t, "leader election", func() bool {
+		leader = nil
+		for _, id := range ids {
+			if c.nodes[id].IsLeader() {
+				if leader != nil {
+					return false
+				}
+				leader = c.nodes[id]
+			}
+		}
+		return leader != nil
+	})
+	return leader
+}
+
+// propose запись команды через ведущий узел
+func (c *cluster) propose(leader *Node, data string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err := leader.Propose(ctx, []byte(data))
+	return err
+}
+
+// converged все узлы ids применили команды want
+func (c *cluster) converged(want []string, ids ...string) func() bool {
+	return func() bool {
+		for _, id := range ids {
+			if !reflect.DeepEqual(c.appliedBy(id), want) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func others(nodes map[string]*Node, exclude string) []string {
+	var ids []string
+	for id := range nodes {
+		if id != exclude {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRaft_Replication(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+	}{
+		{name: "Testing replication: single node", size: 1},
+		{name: "Testing replication: three nodes", size: 3},
+		{name: "Testing replication: five nodes", size: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newCluster(t, tt.size)
+			leader := c.leader()
+
+			var want []string
+			for i := 0; i < 10; i++ {
+				want = append(want, fmt.Sprint("cmd", i))
+				if err := c.propose(leader, want[i]); err != nil {
+					t.Fatalf("Propose() error = %v", err)
+				}
+			}
+			eventually(t, "replication", c.converged(want, others(c.nodes, "")...))
+
+			for id, node := range c.nodes {
+				if status := node.Status(); status.Leader != leader.ID() || status.CommitIndex != status.LastIndex {
+					t.Errorf("%s: Status() = %+v", id, status)
+				}
+			}
+		})
+	}
+}
+
+func TestRaft_ProposeOnFollower(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	follower := c.nodes[others(c.nodes, leader.ID())[0]]
+	eventually(t, "heartbeat", func() bool { return follower.Leader() == leader.ID() })
+
+	_, err := follower.Propose(context.Background(), []byte("cmd"))
+	notLeader, ok := err.(*NotLeaderError)
+	if !ok || notLeader.Leader != leader.ID() {
+		t.Errorf("Propose() error = %v, want NotLeaderError{%s}", err, leader.ID())
+	}
+}
+
+func TestRaft_LeaderPartition(t *testing.T) {
+	c := newCluster(t, 5)
+	old := c.leader()
+	if err := c.propose(old, "before"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ведущий узел оказывается в меньшинстве
+	majority := others(c.nodes, old.ID())
+	minority := []string{old.ID(), majority[0]}
+	majority = majority[1:]
+	c.network.Partition(minority, majority)
+
+	t.Run("Testing partition: minority write fails", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*testElectionTimeout)
+		defer cancel()
+		if _, err := old.Propose(ctx, []byte("lost")); err == nil {
+			t.Error("Propose() in minority succeeded")
+		}
+	})
+
+	t.Run("Testing partition: stale leader rejects linearizable reads", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*testElectionTimeout)
+		defer cancel()
+		if err := old.ReadIndex(ctx); err == nil {
+			t.Error("ReadIndex() in minority succeeded")
+		}
+	})
+
+	leader := c.leader(majority...)
+	if err := c.propose(leader, "after"); err != nil {
+		t.Fatalf("Propose() in majority error = %v", err)
+	}
+	eventually(t, "old leader step down", func() bool { return !old.IsLeader() })
+
+	// После восстановления сети старый ведущий узел отбрасывает незакоммиченную запись и догоняет кластер
+	c.network.Heal()
+	leader = c.leader()
+	if err := c.propose(leader, "healed"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "catch up", c.converged([]string{"before", "after", "healed"}, others(c.nodes, "")...))
+}
+
+func TestRaft_PreVote(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	follower := c.nodes[others(c.nodes, leader.ID())[0]]
+	eventually(t, "heartbeat", func() bool { return follower.Leader() == leader.ID() })
+	term := leader.Status().Term
+
+	// Отрезанный узел не может собрать предварительные голоса и не наращивает срок
+	c.network.Partition(others(c.nodes, follower.ID()))
+	time.Sleep(10 * testElectionTimeout)
+	if got := follower.Status().Term; got != term {
+		t.Errorf("isolated node term = %d, want %d", got, term)
+	}
+
+	// После восстановления сети ведущий узел сохраняет лидерство
+	c.network.Heal()
+	eventually(t, "rejoin", func() bool { return follower.Leader() == leader.ID() })
+	if !leader.IsLeader() || leader.Status().Term != term {
+		t.Errorf("leader status after heal = %+v, want leader of term %d", leader.Status(), term)
+	}
+}
+
+func TestRaft_ReadIndex(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	if err := c.propose(leader, "cmd"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := leader.ReadIndex(ctx); err != nil {
+		t.Fatalf("ReadIndex() error = %v", err)
+	}
+	if got := c.appliedBy(leader.ID()); !reflect.DeepEqual(got, []string{"cmd"}) {
+		t.Errorf("applied = %v, want [cmd]", got)
+	}
+
+	follower := c.nodes[others(c.nodes, leader.ID())[0]]
+	if err := follower.ReadIndex(ctx); err == nil {
+		t.Error("ReadIndex() on follower succeeded")
+	}
+}
+
+func TestRaft_Membership(t *testing.T) {
+	c := newCluster(t, 3)
+	leader := c.leader()
+	if err := c.propose(leader, "cmd1"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	// Новый узел запускается с пустой конфигурацией и получает журнал от ведущего
+	c.start("n4", nil)
+	if err := leader.AddNode(ctx, "n4", "n4"); err != nil {
+		t.Fatalf("AddNode() error = %v", err)
+	}
+	if err := c.propose(leader, "cmd2"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "new node catch up", c.converged([]string{"cmd1", "cmd2"}, "n4"))
+	if peers := c.nodes["n4"].Status().Peers; len(peers) != 4 {
+		t.Errorf("Peers = %v, want 4 nodes", peers)
+	}
+
+	// Удаление ведущего узла: кластер выбирает нового из оставшихся
+	if err := leader.RemoveNode(ctx, leader.ID()); err != nil {
+		t.Fatalf("RemoveNode() error = %v", err)
+	}
+	eventually(t, "removed leader step down", func() bool { return !leader.IsLeader() })
+	removed := leader.ID()
+	rest := others(c.nodes, removed)
+	leader = c.leader(rest...)
+	if err := c.propose(leader, "cmd3"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "replication", c.converged([]string{"cmd1", "cmd2", "cmd3"}, rest...))
+	if got := c.appliedBy(removed); len(got) != 2 {
+		t.Errorf("removed node applied %v", got)
+	}
+
+	if err := leader.RemoveNode(ctx, "unknown"); err != ErrUnknownNode {
+		t.Errorf("RemoveNode() error = %v, want %v", err, ErrUnknownNode)
+	}
+}
+
+func TestRaft_Restart(t *testing.T) {
+	c := newDurableCluster(t, 3, 1000)
+	leader := c.leader()
+	for _, cmd := range []string{"a", "b", "c"} {
+		if err := c.propose(leader, cmd); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids := others(c.nodes, "")
+	eventually(t, "replication", c.converged([]string{"a", "b", "c"}, ids...))
+	term := leader.Status().Term
+
+	// Все узлы перезапускаются одновременно: срок и журнал читаются с диска
+	for _, id := range ids {
+		c.nodes[id].Stop()
+	}
+	for _, id := range ids {
+		c.restart(id)
+		if got := c.nodes[id].Status(); got.Term < term || got.LastIndex < 4 {
+			t.Errorf("%s: restarted status = %+v, want term >= %d and last index >= 4", id, got, term)
+		}
+	}
+	leader = c.leader()
+	if err := c.propose(leader, "d"); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, "replay after restart", c.converged([]string{"a", "b", "c", "d"}, ids...))
+	if got := leader.Status().Term; got <= term {
+		t.Errorf("term after restart = %d, want > %d", got, term)
+	}
+}
+
+func TestRaft_Snapshot(t *testing.T) {
+	c := newDurableCluster(t, 3, 4)
+	leader := c.leader()
+	lagging := others(c.nodes, leader.ID())[0]
+	c.network.Partition(others(c.nodes, lagging))
+
+	var want []string
+	for i := 0; i < 20; i++ {
+		cmd := fmt.Sprint("cmd", i)
+		if err := c.propose(leader, cmd); err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, cmd)
+	}
+	eventually(t, "log compaction", func() bool {
+		status := leader.Status()
+		return status.SnapshotIndex > 0 && status.LastIndex-status.SnapshotIndex < 8
+	})
+
+	// Отстающий узел получает снимок: записи до снимка в журнале ведущего узла уже отсутствуют
+	c.network.Heal()
+	eventually(t, "snapshot install", c.converged(want, others(c.nodes, "")...))
+	if got := c.nodes[lagging].Status().SnapshotIndex; got == 0 {
+		t.Errorf("lagging node snapshot index = %d, want > 0", got)
+	}
+
+	// Перезапущенный узел восстанавливает конечный автомат из снимка и журнала
+	c.restart(lagging)
+	if err := c.propose(c.leader(), "last"); err != nil {
+		t.Fatal(err)
+	}
+	want = append(want, "last")
+	eventually(t, "restore after restart", c.converged(want, others(c.nodes, "")...))
+}
+
+// newStorages кластер из трёх хранилищ и его ведущий узел. Пустой dir - состояние узлов в памяти
+func newStorages(t *testing.T, network *MemNetwork, dir string) (map[string]*Storage, *Storage) {
+	peers := map[string]string{"n1": "n1", "n2": "n2", "n3": "n3"}
+	storages := make(map[string]*Storage)
+	for id := range peers {
+		config := Config{
+			ID:                id,
+			Peers:             peers,
+			Transport:         network.Transport(id),
+			SnapshotThreshold: 4,
+			ElectionTimeout:   testElectionTimeout,
+			HeartbeatInterval: testHeartbeatInterval,
+		}
+		if dir != "" {
+			config.Dir = filepath.Join(dir, id)
+		}
+		s, err := NewStorage(mapbased.NewStorage(), config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		network.Register(id, s.Node())
+		s.Node().Start()
+		t.Cleanup(s.Node().Stop)
+		storages[id] = s
+	}
+
+	var leader *Storage
+	eventually(t, "leader election", func() bool {
+		for _, s := range storages {
+			if s.Node().IsLeader() {
+				leader = s
+				return true
+			}
+		}
+		return false
+	})
+	return storages, leader
+}
+
+func TestStorage_Replication(t *testing.T) {
+	storages, leader := newStorages(t, NewMemNetwork(), "")
+
+	leader.PutOrUpdateString("str", "value")
+	if prev, updated := leader.PutOrUpdateString("str", "new"); prev != "value" || !updated {
+		t.Errorf("PutOrUpdateString() = %q, %v, want %q, true", prev, updated, "value")
+	}
+	leader.PutOrUpdateList("list", []string{"a", "b"})
+	leader.PutOrUpdateDictionary("dict", map[string]string{"k": "v"})
+	leader.SetExpired("list", 60000)
+	leader.PutOrUpdateString("removed", "value")
+	leader.RemoveElement("removed")
+	for i := 0; i < 3; i++ {
+		if _, err := leader.StreamAdd("events", "*", map[string]string{"n": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if removed, err := leader.StreamTrim("events", 2); err != nil || removed != 1 {
+		t.Errorf("StreamTrim() = %d, %v, want 1", removed, err)
+	}
+
+	for id, s := range storages {
+		if s == leader {
+			if err := s.Guard(context.Background(), "str", true); err != nil {
+				t.Errorf("%s: Guard(write) error = %v", id, err)
+			}
+			if err := s.Guard(context.Background(), "str", false); err != nil {
+				t.Errorf("%s: Guard(read) error = %v", id, err)
+			}
+			continue
+		}
+		if _, ok := s.Guard(context.Background(), "str", true).(*NotLeaderError); !ok {
+			t.Errorf("%s: Guard(write) on follower must return NotLeaderError", id)
+		}
+		s := s
+		eventually(t, "storage replication", func() bool {
+			return reflect.DeepEqual(s.Dump(), leader.Dump())
+		})
+	}
+}
+
+func TestStorage_StreamGroups(t *testing.T) {
+	storages, leader := newStorages(t, NewMemNetwork(), "")
+
+	for i := 0; i < 3; i++ {
+		if _, err := leader.StreamAdd("events", "*", map[string]string{"n": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := leader.StreamGroupCreate("events", "workers", "0", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := leader.StreamGroupCreate("events", "audit", "$", false); err != nil {
+		t.Fatal(err)
+	}
+	got, err := leader.StreamReadGroup("workers", "alice", []string{"events"}, []string{">"}, 0, 0, false)
+	if err != nil || len(got["events"]) != 3 {
+		t.Fatalf("StreamReadGroup() got = %v, %v, want three entries", got, err)
+	}
+	if acked, err := leader.StreamAck("events", "workers", []string{got["events"][0].ID.String()}); err != nil || acked != 1 {
+		t.Errorf("StreamAck() got = %v, %v, want 1", acked, err)
+	}
+	claimed, err := leader.StreamClaim("events", "workers", "bob", 0, []string{got["events"][1].ID.String()})
+	if err != nil || len(claimed) != 1 {
+		t.Errorf("StreamClaim() got = %v, %v, want one entry", claimed, err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = leader.StreamAdd("events", "*", map[string]string{"n": "3"})
+	}()
+	got, err = leader.StreamReadGroup("workers", "bob", []string{"events"}, []string{">"}, 0, time.Second, false)
+	if err != nil || len(got["events"]) != 1 {
+		t.Errorf("StreamReadGroup() got = %v, %v, want the new entry", got, err)
+	}
+	if destroyed, err := leader.StreamGroupDestroy("events", "audit"); err != nil || !destroyed {
+		t.Errorf("StreamGroupDestroy() got = %v, %v, want true", destroyed, err)
+	}
+
+	want, _ := leader.StreamPending("events", "workers")
+	if len(want) != 3 {
+		t.Errorf("StreamPending() got = %+v, want three entries", want)
+	}
+	for _, s := range storages {
+		s := s
+		eventually(t, "group replication", func() bool {
+			pending, _ := s.StreamPending("events", "workers")
+			return reflect.DeepEqual(s.Dump(), leader.Dump()) && reflect.DeepEqual(pending, want)
+		})
+	}
+}
+
+func TestStorage_UncommittedWrite(t *testing.T) {
+	network := NewMemNetwork()
+	_, leader := newStorages(t, network, "")
+	if _, _, err := leader.PutOrUpdateStringContext(context.Background(), "str", "value"); err != nil {
+		t.Fatalf("PutOrUpdateStringContext() error = %v", err)
+	}
+
+	// Ведущий узел в меньшинстве не может закоммитить запись
+	network.Partition([]string{leader.Node().id})
+	tests := []struct {
+		name  string
+		write func(ctx context.Context) error
+	}{
+		{name: "PutOrUpdateStringContext", write: func(ctx context.Context) error {
+			_, _, err := leader.PutOrUpdateStringContext(ctx, "str", "lost")
+			return err
+		}},
+		{name: "PutOrUpdateListContext", write: func(ctx context.Context) error {
+			_, _, err := leader.PutOrUpdateListContext(ctx, "list", []string{"lost"})
+			return err
+		}},
+		{name: "PutOrUpdateDictionaryContext", write: func(ctx context.Context) error {
+			_, _, err := leader.PutOrUpdateDictionaryContext(ctx, "dict", map[string]string{"k": "lost"})
+			return err
+		}},
+		{name: "RemoveElementContext", write: func(ctx context.Context) error {
+			return leader.RemoveElementContext(ctx, "str")
+		}},
+		{name: "SetExpiredContext", write: func(ctx context.Context) error {
+			return leader.SetExpiredContext(ctx, "str", 60000)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*testElectionTimeout)
+			defer cancel()
+			if err := tt.write(ctx); err == nil {
+				t.Errorf("%s() on minority leader must return error", tt.name)
+			}
+		})
+	}
+	if val, err := leader.GetElement("str"); err != nil || val != "value" {
+		t.Errorf("GetElement() = %v, %v, want %q", val, err, "value")
+	}
+}
+
+func TestStorage_Restart(t *testing.T) {
+	dir := t.TempDir()
+	network := NewMemNetwork()
+	storages, leader := newStorages(t, network, dir)
+	for i := 0; i < 10; i++ {
+		leader.PutOrUpdateString(fmt.Sprint("key", i), fmt.Sprint("value", i))
+	}
+	leader.PutOrUpdateList("list", []string{"a", "b"})
+	leader.RemoveElement("key0")
+	for _, s := range storages {
+		s := s
+		eventually(t, "storage replication", func() bool { return reflect.DeepEqual(s.Dump(), leader.Dump()) })
+	}
+	want := leader.Dump()
+	for _, s := range storages {
+		s.Node().Stop()
+	}
+
+	// Хранилища новых узлов восстанавливаются из снимков и журналов предыдущих
+	storages, leader = newStorages(t, network, dir)
+	for _, s := range storages {
+		s := s
+		eventually(t, "storage restore", func() bool { return reflect.DeepEqual(s.Dump(), want) })
+	}
+	leader.PutOrUpdateString("after", "restart")
+	for _, s := range storages {
+		s := s
+		eventually(t, "storage replication", func() bool { return reflect.DeepEqual(s.Dump(), leader.Dump()) })
+	}
+}
@@ -0,0 +1,363 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/replication"
+	"github.com/geraev/gokvserver/structs"
+)
+
+const proposeTimeout = 5 * time.Second
+
+type applyResult struct {
+	result replication.Result
+	err    error
+}
+
+// Storage хранилище, каждое изменение которого сначала коммитится через журнал Raft и только затем
+// применяется на всех узлах. Запись возможна только на ведущем узле, чтение линеаризуемо (read index).
+// Проверки выполняет Guard, который подключается к серверам
+type Storage struct {
+	node    *Node
+	storage replication.Storage
+	streams structs.StreamStorage
+}
+
+// NewStorage создание узла кластера над локальным хранилищем storage. Поля Apply, Snapshot и Restore
+// конфигурации заполняются. Перезапущенный узел восстанавливает storage из снимка и журнала Raft,
+// поэтому storage должно изменяться только через узел
+func NewStorage(storage replication.Storage, config Config) (*Storage, error) {
+	streams, _ := storage.(structs.StreamStorage)
+	s := &Storage{
+		storage: storage,
+		streams: streams,
+	}
+	config.Apply = s.apply
+	config.Snapshot = s.snapshot
+	config.Restore = s.restore
+	node, err := NewNode(config)
+	if err != nil {
+		return nil, err
+	}
+	s.node = node
+	return s, nil
+}
+
+// Node узел Raft
+func (s *Storage) Node() *Node {
+	return s.node
+}
+
+// Guard запись только на ведущем узле, чтение после подтверждения лидерства
+func (s *Storage) Guard(ctx context.Context, _ string, write bool) error {
+	if write {
+		if !s.node.IsLeader() {
+			return &NotLeaderError{Leader: s.node.Leader()}
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, proposeTimeout)
+	defer cancel()
+	return s.node.ReadIndex(ctx)
+}
+
+// apply применение закоммиченной операции к локальному хранилищу
+func (s *Storage) apply(data []byte) interface{} {
+	var op replication.Op
+	if err := json.Unmarshal(data, &op); err != nil {
+		return applyResult{err: err}
+	}
+	result, err := op.Apply(s.storage)
+	return applyResult{result: result, err: err}
+}
+
+// snapshot снимок локального хранилища в формате записей журнала
+func (s *Storage) snapshot() ([]byte, error) {
+	return json.Marshal(s.storage.Dump())
+}
+
+// restore замена содержимого локального хранилища снимком
+func (s *Storage) restore(data []byte) error {
+	var entries []structs.Entry
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+	}
+	s.storage.Flush()
+	s.storage.Load(entries)
+	return nil
+}
+
+// propose коммит операции через журнал. Ожидание коммита ограничено ctx и proposeTimeout
+func (s *Storage) propose(ctx context.Context, op *replication.Op) (replication.Result, error) {
+	data, err := json.Marshal(op)
+	if err != nil {
+		return replication.Result{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, proposeTimeout)
+	defer cancel()
+	value, err := s.node.Propose(ctx, data)
+	if err != nil {
+		return replication.Result{}, err
+	}
+	r := value.(applyResult)
+	return r.result, r.err
+}
+
+// mustPropose коммит операции, результат которой не может быть возвращён как ошибка через structs.Storage.
+// Серверы пишут через методы ContextStorage, которые возвращают ошибку коммита клиенту
+func (s *Storage) mustPropose(op *replication.Op) replication.Result {
+	result, err := s.propose(context.Background(), op)
+	if err != nil {
+		log.Printf("raft: %s %s failed: %v", op.Kind, op.Key, err)
+	}
+	return result
+}
+
+// ServeRedeo управление кластером:
+// raft status
+// raft add <id> <address>
+// raft remove <id>
+func (s *Storage) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Context(), proposeTimeout)
+	defer cancel()
+
+	var err error
+	switch sub := c.Arg(0).String(); {
+	case sub == "status" && c.ArgN() == 1:
+		status, err := json.Marshal(s.node.Status())
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendBulk(status)
+		return
+	case sub == "add" && c.ArgN() == 3:
+		err = s.node.AddNode(ctx, c.Arg(1).String(), c.Arg(2).String())
+	case sub == "remove" && c.ArgN() == 2:
+		err = s.node.RemoveNode(ctx, c.Arg(1).String())
+	default:
+		w.AppendError(redeo.UnknownCommand(fmt.Sprintf("%s %s", c.Name, sub)))
+		return
+	}
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendOK()
+}
+
+func (s *Storage) GetKeys() []string {
+	return s.storage.GetKeys()
+}
+
+// KeysRangeContext ключи диапазона r из локального хранилища
+func (s *Storage) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	return structs.KeysRange(ctx, structs.WithContext(s.storage), r)
+}
+
+func (s *Storage) GetElement(key string) (interface{}, error) {
+	return s.storage.GetElement(key)
+}
+
+func (s *Storage) GetListElement(key string, index int) (string, error) {
+	return s.storage.GetListElement(key, index)
+}
+
+func (s *Storage) GetDictionaryElement(key, internalKey string) (string, error) {
+	return s.storage.GetDictionaryElement(key, internalKey)
+}
+
+func (s *Storage) GetType(key string) (structs.ValueType, error) {
+	return s.storage.GetType(key)
+}
+
+func (s *Storage) PutOrUpdateString(key, value string) (string, bool) {
+	r := s.mustPropose(setOp(key, structs.String, value))
+	previousVal, _ := r.PreviousVal.(string)
+	return previousVal, r.IsUpdated
+}
+
+func (s *Storage) PutOrUpdateList(key string, value []string) ([]string, bool) {
+	r := s.mustPropose(setOp(key, structs.List, value))
+	previousVal, _ := r.PreviousVal.([]string)
+	return previousVal, r.IsUpdated
+}
+
+func (s *Storage) PutOrUpdateDictionary(key string, value map[string]string) (map[string]string, bool) {
+	r := s.mustPropose(setOp(key, structs.Dictionary, value))
+	previousVal, _ := r.PreviousVal.(map[string]string)
+	return previousVal, r.IsUpdated
+}
+
+// setOp операция записи значения ключа
+func setOp(key string, valueType structs.ValueType, value interface{}) *replication.Op {
+	return &replication.Op{Kind: replication.OpSet, Key: key, Entry: &structs.Entry{Key: key, Type: valueType, Value: value}}
+}
+
+func (s *Storage) RemoveElement(key string) {
+	s.mustPropose(&replication.Op{Kind: replication.OpRemove, Key: key})
+}
+
+// SetTTL коммитится как установка момента истечения ключа
+// Deprecated
+func (s *Storage) SetTTL(key string, keyTTL uint64) {
+	s.SetExpired(key, keyTTL)
+}
+
+// SetExpired момент истечения вычисляется на ведущем узле, чтобы все узлы удалили ключ одновременно
+func (s *Storage) SetExpired(key string, expired uint64) {
+	if expired == 0 {
+		return
+	}
+	s.ExpireAt(key, deadline(expired))
+}
+
+// deadline момент истечения через expired миллисекунд
+func deadline(expired uint64) uint64 {
+	return uint64(time.Now().Add(time.Millisecond * time.Duration(expired)).UnixNano())
+}
+
+func (s *Storage) ExpireAt(key string, deadline uint64) {
+	if deadline == 0 {
+		return
+	}
+	s.mustPropose(&replication.Op{Kind: replication.OpExpireAt, Key: key, Deadline: deadline})
+}
+
+func (s *Storage) Dump() []structs.Entry {
+	return s.storage.Dump()
+}
+
+func (s *Storage) DumpKey(key string) (structs.Entry, error) {
+	return s.storage.DumpKey(key)
+}
+
+// Load каждая запись коммитится отдельной операцией
+func (s *Storage) Load(entries []structs.Entry) {
+	for i := range entries {
+		entry := entries[i]
+		s.mustPropose(&replication.Op{Kind: replication.OpSet, Key: entry.Key, Entry: &entry})
+		s.ExpireAt(entry.Key, entry.Expired)
+	}
+}
+
+// Flush коммитится удалением каждого ключа
+func (s *Storage) Flush() {
+	for _, key := range s.storage.GetKeys() {
+		s.RemoveElement(key)
+	}
+}
+
+// StreamAdd автоматически сгенерированный идентификатор вычисляется по часам ведущего узла
+func (s *Storage) StreamAdd(key, id string, fields map[string]string) (structs.StreamID, error) {
+	return s.StreamAddTrim(key, id, fields, -1)
+}
+
+// StreamAddTrim добавление и удаление записей коммитятся одной операцией
+func (s *Storage) StreamAddTrim(key, id string, fields map[string]string, maxLen int) (structs.StreamID, error) {
+	if s.streams == nil {
+		return structs.StreamID{}, structs.ErrNotSupported
+	}
+	if id == "*" {
+		id = fmt.Sprintf("%d-*", time.Now().UnixNano()/int64(time.Millisecond))
+	}
+	op := &replication.Op{Kind: replication.OpStreamAdd, Key: key, ID: id, Fields: fields}
+	if maxLen >= 0 {
+		op.Kind, op.MaxLen = replication.OpStreamAddTrim, maxLen
+	}
+	r, err := s.propose(context.Background(), op)
+	return r.ID, err
+}
+
+func (s *Storage) StreamRange(key, start, end string, count int) ([]structs.StreamEntry, error) {
+	if s.streams == nil {
+		return nil, structs.ErrNotSupported
+	}
+	return s.streams.StreamRange(key, start, end, count)
+}
+
+func (s *Storage) StreamRead(keys, ids []string, count int, block time.Duration) (map[string][]structs.StreamEntry, error) {
+	if s.streams == nil {
+		return nil, structs.ErrNotSupported
+	}
+	return s.streams.StreamRead(keys, ids, count, block)
+}
+
+func (s *Storage) StreamLen(key string) (int, error) {
+	if s.streams == nil {
+		return 0, structs.ErrNotSupported
+	}
+	return s.streams.StreamLen(key)
+}
+
+func (s *Storage) StreamTrim(key string, maxLen int) (int, error) {
+	if s.streams == nil {
+		return 0, structs.ErrNotSupported
+	}
+	r, err := s.propose(context.Background(), &replication.Op{Kind: replication.OpStreamTrim, Key: key, MaxLen: maxLen})
+	return r.Removed, err
+}
+
+func (s *Storage) StreamGroupCreate(key, group, id string, mkStream bool) error {
+	if s.streams == nil {
+		return structs.ErrNotSupported
+	}
+	_, err := s.propose(context.Background(), &replication.Op{
+		Kind: replication.OpStreamGroupCreate, Key: key, Group: group, ID: id, MkStream: mkStream,
+	})
+	return err
+}
+
+func (s *Storage) StreamGroupDestroy(key, group string) (bool, error) {
+	if s.streams == nil {
+		return false, structs.ErrNotSupported
+	}
+	r, err := s.propose(context.Background(), &replication.Op{Kind: replication.OpStreamGroupDestroy, Key: key, Group: group})
+	return r.Destroyed, err
+}
+
+func (s *Storage) StreamReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	return s.StreamReadGroupContext(context.Background(), group, consumer, keys, ids, count, block, noAck)
+}
+
+func (s *Storage) StreamAck(key, group string, ids []string) (int, error) {
+	if s.streams == nil {
+		return 0, structs.ErrNotSupported
+	}
+	r, err := s.propose(context.Background(), &replication.Op{Kind: replication.OpStreamAck, Key: key, Group: group, IDs: ids})
+	return r.Acked, err
+}
+
+func (s *Storage) StreamPending(key, group string) ([]structs.PendingEntry, error) {
+	if s.streams == nil {
+		return nil, structs.ErrNotSupported
+	}
+	return s.streams.StreamPending(key, group)
+}
+
+// StreamClaim время ожидания записей отсчитывается от момента по часам ведущего узла
+func (s *Storage) StreamClaim(key, group, consumer string, minIdle time.Duration, ids []string) ([]structs.StreamEntry, error) {
+	if _, ok := s.streams.(structs.StreamGroupReplayer); !ok {
+		return nil, structs.ErrNotSupported
+	}
+	r, err := s.propose(context.Background(), &replication.Op{
+		Kind: replication.OpStreamClaim, Key: key, Group: group, Consumer: consumer, MinIdle: minIdle, IDs: ids,
+		At: time.Now().UnixNano(),
+	})
+	return r.Entries, err
+}
@@ -0,0 +1,280 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+var ErrUnreachable = errors.New("raft node is unreachable")
+
+type RequestVoteArgs struct {
+	Term         uint64
+	CandidateID  string
+	LastLogIndex uint64
+	LastLogTerm  uint64
+	// PreVote предварительное голосование: Term - срок, который кандидат получит после выборов.
+	// Голос не меняет срок и голос получателя
+	PreVote bool
+}
+
+type RequestVoteReply struct {
+	Term        uint64
+	VoteGranted bool
+}
+
+type AppendEntriesArgs struct {
+	Term         uint64
+	LeaderID     string
+	PrevLogIndex uint64
+	PrevLogTerm  uint64
+	Entries      []LogEntry
+	LeaderCommit uint64
+}
+
+type AppendEntriesReply struct {
+	Term    uint64
+	Success bool
+	// ConflictIndex индекс, с которого ведущему узлу следует повторить репликацию
+	ConflictIndex uint64
+}
+
+// InstallSnapshotArgs снимок конечного автомата, заменяющий записи журнала до LastIncludedIndex
+// включительно, и конфигурация кластера на этот момент
+type InstallSnapshotArgs struct {
+	Term              uint64
+	LeaderID          string
+	LastIncludedIndex uint64
+	LastIncludedTerm  uint64
+	Peers             map[string]string
+	Data              []byte
+}
+
+type InstallSnapshotReply struct {
+	Term uint64
+}
+
+// Transport доставка сообщений узлу с адресом target
+type Transport interface {
+	RequestVote(ctx context.Context, target string, args *RequestVoteArgs) (*RequestVoteReply, error)
+	AppendEntries(ctx context.Context, target string, args *AppendEntriesArgs) (*AppendEntriesReply, error)
+	InstallSnapshot(ctx context.Context, target string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error)
+}
+
+// MemNetwork сеть узлов внутри одного процесса с возможностью имитации сетевых разделений
+type MemNetwork struct {
+	mu    sync.RWMutex
+	nodes map[string]*Node
+	group map[string]int
+}
+
+func NewMemNetwork() *MemNetwork {
+	return &MemNetwork{
+		nodes: make(map[string]*Node),
+		group: make(map[string]int),
+	}
+}
+
+// Register подключение узла к сети по адресу addr
+func (m *MemNetwork) Register(addr string, node *Node) {
+	m.mu.Lock()
+	m.nodes[addr] = node
+	m.mu.Unlock()
+}
+
+// Partition разделение сети на группы: узлы из разных групп не видят друг друга.
+// Узлы, не попавшие ни в одну группу, изолированы
+func (m *MemNetwork) Partition(groups ...[]string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.group = make(map[string]int)
+	for addr := range m.nodes {
+		m.group[addr] = -1
+	}
+	for i, group := range groups {
+		for _, addr := range group {
+			m.group[addr] = i + 1
+		}
+	}
+}
+
+// Heal восстановление связности сети
+func (m *MemNetwork) Heal() {
+	m.mu.Lock()
+	m.group = make(map[string]int)
+	m.mu.Unlock()
+}
+
+// Transport транспорт узла с адресом addr
+func (m *MemNetwork) Transport(addr string) Transport {
+	return &memTransport{network: m, from: addr}
+}
+
+func (m *MemNetwork) route(from, to string) (*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, ok := m.nodes[to]
+	if !ok || m.group[from] != m.group[to] || m.group[from] < 0 {
+		return nil, ErrUnreachable
+	}
+	return node, nil
+}
+
+type memTransport struct {
+	network *MemNetwork
+	from    string
+}
+
+func (t *memTransport) RequestVote(ctx context.Context, target string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	node, err := t.network.route(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	reply := node.HandleRequestVote(args)
+	// Ответ тоже проходит через сеть, которая могла разделиться
+	if _, err := t.network.route(target, t.from); err != nil {
+		return nil, err
+	}
+	return reply, ctx.Err()
+}
+
+func (t *memTransport) AppendEntries(ctx context.Context, target string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	node, err := t.network.route(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	reply := node.HandleAppendEntries(args)
+	if _, err := t.network.route(target, t.from); err != nil {
+		return nil, err
+	}
+	return reply, ctx.Err()
+}
+
+func (t *memTransport) InstallSnapshot(ctx context.Context, target string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	node, err := t.network.route(t.from, target)
+	if err != nil {
+		return nil, err
+	}
+	reply := node.HandleInstallSnapshot(args)
+	if _, err := t.network.route(target, t.from); err != nil {
+		return nil, err
+	}
+	return reply, ctx.Err()
+}
+
+// ServeRPC обработка сообщений от других узлов, принимаемых lis (net/rpc)
+func ServeRPC(lis net.Listener, node *Node) error {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("Raft", &rpcHandler{node: node}); err != nil {
+		return err
+	}
+	for {
+		conn, err := lis.Accept()
+		if err != nil {
+			return err
+		}
+		go srv.ServeConn(conn)
+	}
+}
+
+type rpcHandler struct {
+	node *Node
+}
+
+func (h *rpcHandler) RequestVote(args *RequestVoteArgs, reply *RequestVoteReply) error {
+	*reply = *h.node.HandleRequestVote(args)
+	return nil
+}
+
+func (h *rpcHandler) AppendEntries(args *AppendEntriesArgs, reply *AppendEntriesReply) error {
+	*reply = *h.node.HandleAppendEntries(args)
+	return nil
+}
+
+func (h *rpcHandler) InstallSnapshot(args *InstallSnapshotArgs, reply *InstallSnapshotReply) error {
+	*reply = *h.node.HandleInstallSnapshot(args)
+	return nil
+}
+
+// RPCTransport транспорт поверх net/rpc. Соединения с узлами переиспользуются
+type RPCTransport struct {
+	mu      sync.Mutex
+	clients map[string]*rpc.Client
+}
+
+func NewRPCTransport() *RPCTransport {
+	return &RPCTransport{clients: make(map[string]*rpc.Client)}
+}
+
+func (t *RPCTransport) RequestVote(ctx context.Context, target string, args *RequestVoteArgs) (*RequestVoteReply, error) {
+	reply := new(RequestVoteReply)
+	return reply, t.call(ctx, target, "Raft.RequestVote", args, reply)
+}
+
+func (t *RPCTransport) AppendEntries(ctx context.Context, target string, args *AppendEntriesArgs) (*AppendEntriesReply, error) {
+	reply := new(AppendEntriesReply)
+	return reply, t.call(ctx, target, "Raft.AppendEntries", args, reply)
+}
+
+func (t *RPCTransport) InstallSnapshot(ctx context.Context, target string, args *InstallSnapshotArgs) (*InstallSnapshotReply, error) {
+	reply := new(InstallSnapshotReply)
+	return reply, t.call(ctx, target, "Raft.InstallSnapshot", args, reply)
+}
+
+func (t *RPCTransport) call(ctx context.Context, target, method string, args, reply interface{}) error {
+	client, err := t.client(ctx, target)
+	if err != nil {
+		return err
+	}
+
+	call := client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	select {
+	case <-call.Done:
+		if call.Error == rpc.ErrShutdown {
+			t.drop(target, client)
+		}
+		return call.Error
+	case <-ctx.Done():
+		// Соединение могло зависнуть: следующий вызов установит новое
+		t.drop(target, client)
+		return ctx.Err()
+	}
+}
+
+func (t *RPCTransport) client(ctx context.Context, target string) (*rpc.Client, error) {
+	t.mu.Lock()
+	client, ok := t.clients[target]
+	t.mu.Unlock()
+	if ok {
+		return client, nil
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return nil, err
+	}
+	client = rpc.NewClient(conn)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if existing, ok := t.clients[target]; ok {
+		client.Close()
+		return existing, nil
+	}
+	t.clients[target] = client
+	return client, nil
+}
+
+func (t *RPCTransport) drop(target string, client *rpc.Client) {
+	t.mu.Lock()
+	if t.clients[target] == client {
+		delete(t.clients, target)
+	}
+	t.mu.Unlock()
+	client.Close()
+}
@@ -0,0 +1,117 @@
+package replication
+
+import (
+	"context"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Операции ведущего узла с контекстом. Прерванная отменой запись не попадает в журнал
+
+func (l *Leader) GetKeysContext(ctx context.Context) ([]string, error) {
+	return structs.WithContext(l.storage).GetKeysContext(ctx)
+}
+
+func (l *Leader) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	return structs.WithContext(l.storage).GetElementContext(ctx, key)
+}
+
+func (l *Leader) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	return structs.WithContext(l.storage).GetListElementContext(ctx, key, index)
+}
+
+func (l *Leader) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	return structs.WithContext(l.storage).GetDictionaryElementContext(ctx, key, internalKey)
+}
+
+func (l *Leader) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	return structs.WithContext(l.storage).GetTypeContext(ctx, key)
+}
+
+func (l *Leader) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previousVal, isUpdated, err := structs.WithContext(l.storage).PutOrUpdateStringContext(ctx, key, value)
+	if err != nil {
+		return "", false, err
+	}
+	l.append(&Op{Kind: OpSet, Key: key, Entry: &structs.Entry{Key: key, Type: structs.String, Value: value}})
+	return previousVal, isUpdated, nil
+}
+
+func (l *Leader) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previousVal, isUpdated, err := structs.WithContext(l.storage).PutOrUpdateListContext(ctx, key, value)
+	if err != nil {
+		return nil, false, err
+	}
+	l.append(&Op{Kind: OpSet, Key: key, Entry: &structs.Entry{Key: key, Type: structs.List, Value: value}})
+	return previousVal, isUpdated, nil
+}
+
+func (l *Leader) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previousVal, isUpdated, err := structs.WithContext(l.storage).PutOrUpdateDictionaryContext(ctx, key, value)
+	if err != nil {
+		return nil, false, err
+	}
+	l.append(&Op{Kind: OpSet, Key: key, Entry: &structs.Entry{Key: key, Type: structs.Dictionary, Value: value}})
+	return previousVal, isUpdated, nil
+}
+
+func (l *Leader) RemoveElementContext(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := structs.WithContext(l.storage).RemoveElementContext(ctx, key); err != nil {
+		return err
+	}
+	l.append(&Op{Kind: OpRemove, Key: key})
+	return nil
+}
+
+func (l *Leader) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	l.SetExpired(key, expired)
+	return nil
+}
+
+// KeysRangeContext ключи диапазона r из хранилища ведущего узла
+func (l *Leader) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	return structs.KeysRange(ctx, structs.WithContext(l.storage), r)
+}
+
+func (l *Leader) StreamReadContext(ctx context.Context, keys, ids []string, count int, block time.Duration) (map[string][]structs.StreamEntry, error) {
+	if reader, ok := l.streams.(structs.ContextStreamReader); ok {
+		return reader.StreamReadContext(ctx, keys, ids, count, block)
+	}
+	return l.StreamRead(keys, ids, count, block)
+}
+
+// StreamReadGroupContext выдача записей реплицируется вместе с моментом выдачи. Новые записи ожидаются
+// без блокировки журнала, выдача и запись в журнал выполняются под ней
+func (l *Leader) StreamReadGroupContext(ctx context.Context, group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	replayer, ok := l.streams.(structs.StreamGroupReplayer)
+	if !ok {
+		return nil, structs.ErrNotSupported
+	}
+	return structs.WaitReadGroup(ctx, replayer, group, keys, ids, block, func() (map[string][]structs.StreamEntry, error) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		at := time.Now().UnixNano()
+		result, err := replayer.StreamReadGroupAt(time.Unix(0, at), group, consumer, keys, ids, count, noAck)
+		if len(result) > 0 {
+			l.append(&Op{Kind: OpStreamReadGroup, Group: group, Consumer: consumer, Keys: keys, IDs: ids, Count: count, NoAck: noAck, At: at})
+		}
+		return result, err
+	})
+}
@@ -0,0 +1,65 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+func TestLeader_Context(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	leader := NewLeader(storage, 0)
+	defer leader.Close()
+
+	if _, _, err := leader.PutOrUpdateStringContext(context.Background(), "str", "value"); err != nil {
+		t.Fatal(err)
+	}
+	offset := leader.Offset()
+	entries := storage.Dump()
+
+	tests := []struct {
+		name  string
+		write func(ctx context.Context) error
+	}{
+		{name: "PutOrUpdateStringContext", write: func(ctx context.Context) error {
+			_, _, err := leader.PutOrUpdateStringContext(ctx, "str", "new value")
+			return err
+		}},
+		{name: "PutOrUpdateListContext", write: func(ctx context.Context) error {
+			_, _, err := leader.PutOrUpdateListContext(ctx, "list", []string{"a"})
+			return err
+		}},
+		{name: "RemoveElementContext", write: func(ctx context.Context) error {
+			return leader.RemoveElementContext(ctx, "str")
+		}},
+		{name: "LoadContext", write: func(ctx context.Context) error {
+			return leader.LoadContext(ctx, entries)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			// Хранилище занято: ожидание блокировки прерывается, операция не попадает в журнал
+			storage.Lock()
+			ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+			defer cancel()
+			if tt.name == "LoadContext" {
+				<-ctx.Done()
+			}
+			err := tt.write(ctx)
+			storage.Unlock()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Errorf("%s() error = %v, want %v", tt.name, err, context.DeadlineExceeded)
+			}
+			if leader.Offset() != offset {
+				t.Errorf("Offset() = %d, want %d", leader.Offset(), offset)
+			}
+		})
+	}
+	if val, err := leader.GetElement("str"); err != nil || val != "value" {
+		t.Errorf("GetElement() = %v, %v, want value", val, err)
+	}
+}
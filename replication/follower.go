@@ -0,0 +1,277 @@
+package replication
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+const (
+	dialTimeout    = 5 * time.Second
+	readTimeout    = 3 * pingInterval
+	minReconnDelay = 100 * time.Millisecond
+	maxReconnDelay = 5 * time.Second
+)
+
+// Follower реплика: подключается к TCP порту ведущего узла, получает полный снимок данных
+// и затем поток изменяющих операций. После разрыва соединения переподключается и продолжает
+// с последней применённой операции, если она ещё есть в журнале ведущего узла
+type Follower struct {
+	addr    string
+	storage Storage
+	// tlsConfig подключение к ведущему узлу по TLS, nil - без шифрования
+	tlsConfig *tls.Config
+
+	mu        sync.Mutex
+	id        string
+	offset    uint64
+	fullSyncs int
+	conn      net.Conn
+	closed    bool
+	done      chan struct{}
+	// ctx прерывает загрузку полного снимка при Close
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewFollower(addr string, storage Storage) *Follower {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Follower{
+		addr:    addr,
+		storage: storage,
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// SetTLSConfig подключение к ведущему узлу по TLS. Вызывается до Run
+func (f *Follower) SetTLSConfig(cfg *tls.Config) {
+	f.tlsConfig = cfg
+}
+
+// Guard запрет записи на реплике
+func (f *Follower) Guard(_ context.Context, _ string, write bool) error {
+	if write {
+		return structs.ErrReadOnly
+	}
+	return nil
+}
+
+// Offset номер последней применённой операции
+func (f *Follower) Offset() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.offset
+}
+
+// Connected наличие соединения с ведущим узлом
+func (f *Follower) Connected() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.conn != nil
+}
+
+// FullSyncs количество выполненных полных синхронизаций
+func (f *Follower) FullSyncs() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.fullSyncs
+}
+
+// Run цикл репликации. Завершается только после Close
+func (f *Follower) Run() {
+	defer close(f.done)
+
+	delay := minReconnDelay
+	for !f.isClosed() {
+		applied, err := f.sync()
+		if f.isClosed() {
+			return
+		}
+		if applied {
+			delay = minReconnDelay
+		}
+		log.Printf("replication: connection to %s lost: %v", f.addr, err)
+
+		time.Sleep(delay)
+		if delay *= 2; delay > maxReconnDelay {
+			delay = maxReconnDelay
+		}
+	}
+}
+
+// Close остановка репликации
+func (f *Follower) Close() {
+	f.mu.Lock()
+	f.closed = true
+	f.cancel()
+	if f.conn != nil {
+		f.conn.Close()
+	}
+	f.mu.Unlock()
+	<-f.done
+}
+
+func (f *Follower) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// sync один сеанс репликации. applied - удалось ли синхронизироваться с ведущим узлом
+func (f *Follower) sync() (applied bool, err error) {
+	var conn net.Conn
+	if f.tlsConfig != nil {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: dialTimeout}, "tcp", f.addr, f.tlsConfig)
+	} else {
+		conn, err = net.DialTimeout("tcp", f.addr, dialTimeout)
+	}
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return false, nil
+	}
+	f.conn = conn
+	id, offset := f.id, f.offset
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		f.conn = nil
+		f.mu.Unlock()
+	}()
+
+	w := resp.NewRequestWriter(conn)
+	w.WriteCmdString("sync", id, strconv.FormatUint(offset, 10))
+	if err := w.Flush(); err != nil {
+		return false, err
+	}
+
+	r := resp.NewResponseReader(conn)
+	conn.SetReadDeadline(time.Now().Add(readTimeout))
+	line, err := readInline(r)
+	if err != nil {
+		return false, err
+	}
+	if err := f.handshake(r, line); err != nil {
+		return false, err
+	}
+	log.Printf("replication: synchronized with %s (%s)", f.addr, line)
+
+	for {
+		conn.SetReadDeadline(time.Now().Add(readTimeout))
+		t, err := r.PeekType()
+		if err != nil {
+			return true, err
+		}
+		switch t {
+		case resp.TypeInline:
+			if _, err := r.ReadInlineString(); err != nil {
+				return true, err
+			}
+		case resp.TypeBulk:
+			data, err := r.ReadBulk(nil)
+			if err != nil {
+				return true, err
+			}
+			if err := f.apply(data); err != nil {
+				return true, err
+			}
+		default:
+			return true, fmt.Errorf("unexpected response type %s", t)
+		}
+	}
+}
+
+// handshake обработка ответа ведущего узла на команду sync
+func (f *Follower) handshake(r resp.ResponseReader, line string) error {
+	fields := strings.Fields(line)
+	switch {
+	case len(fields) == 1 && fields[0] == "CONTINUE":
+		return nil
+	case len(fields) == 3 && fields[0] == "FULLSYNC":
+		offset, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			return err
+		}
+		data, err := r.ReadBulk(nil)
+		if err != nil {
+			return err
+		}
+		var entries []structs.Entry
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return err
+		}
+
+		f.storage.Flush()
+		if err := structs.LoadContext(f.ctx, f.storage, entries); err != nil {
+			return err
+		}
+
+		f.mu.Lock()
+		f.id, f.offset = fields[1], offset
+		f.fullSyncs++
+		f.mu.Unlock()
+		return nil
+	default:
+		return fmt.Errorf("unexpected sync response %q", line)
+	}
+}
+
+// apply применение очередной операции
+func (f *Follower) apply(data []byte) error {
+	var op Op
+	if err := json.Unmarshal(data, &op); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if op.Offset != f.offset+1 {
+		// Пропуск операций: сбрасываем историю, чтобы при переподключении получить полный снимок
+		f.id = ""
+		return fmt.Errorf("replication offset gap: got %d, want %d", op.Offset, f.offset+1)
+	}
+	if _, err := op.Apply(f.storage); err != nil {
+		log.Printf("replication: operation %d (%s %s) failed: %v", op.Offset, op.Kind, op.Key, err)
+	}
+	f.offset = op.Offset
+	return nil
+}
+
+// readInline чтение строки состояния либо ошибки
+func readInline(r resp.ResponseReader) (string, error) {
+	t, err := r.PeekType()
+	if err != nil {
+		return "", err
+	}
+	switch t {
+	case resp.TypeInline:
+		return r.ReadInlineString()
+	case resp.TypeError:
+		msg, err := r.ReadError()
+		if err != nil {
+			return "", err
+		}
+		return "", errors.New(msg)
+	default:
+		return "", fmt.Errorf("unexpected response type %s", t)
+	}
+}
@@ -0,0 +1,489 @@
+package replication
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+const (
+	// DefaultBacklogSize размер в байтах последних операций, хранимых для продолжения репликации после переподключения
+	DefaultBacklogSize = 1 << 20
+
+	pingInterval = time.Second
+)
+
+// Leader ведущий узел репликации. Оборачивает хранилище, записывая каждую изменяющую операцию
+// в журнал (backlog), и передаёт журнал подключённым репликам по команде sync
+type Leader struct {
+	storage Storage
+	streams structs.StreamStorage
+
+	mu      sync.Mutex
+	id      string
+	offset  uint64
+	backlog [][]byte
+	// bytes размер операций журнала, size - его ограничение
+	bytes  int
+	size   int
+	signal chan struct{}
+	done   chan struct{}
+	closed bool
+	// replicas количество реплик, получающих журнал
+	replicas int32
+}
+
+// NewLeader ведущий узел с журналом не больше backlogSize байт, 0 - DefaultBacklogSize
+func NewLeader(storage Storage, backlogSize int) *Leader {
+	if backlogSize <= 0 {
+		backlogSize = DefaultBacklogSize
+	}
+	streams, _ := storage.(structs.StreamStorage)
+	return &Leader{
+		storage: storage,
+		streams: streams,
+		id:      newReplicationID(),
+		size:    backlogSize,
+		done:    make(chan struct{}),
+	}
+}
+
+// Close завершение передачи журнала подключённым репликам. Хранилище остаётся доступным
+func (l *Leader) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.closed {
+		l.closed = true
+		close(l.done)
+	}
+}
+
+// ID идентификатор истории репликации
+func (l *Leader) ID() string {
+	return l.id
+}
+
+// Offset номер последней записанной в журнал операции
+func (l *Leader) Offset() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.offset
+}
+
+// Replicas количество подключённых реплик
+func (l *Leader) Replicas() int {
+	return int(atomic.LoadInt32(&l.replicas))
+}
+
+// append запись операции в журнал. Вызывается под блокировкой
+func (l *Leader) append(op *Op) {
+	l.offset++
+	op.Offset = l.offset
+	data, err := json.Marshal(op)
+	if err != nil {
+		// Все поля операции сериализуемы, ошибка означает повреждение данных
+		panic(err)
+	}
+
+	l.backlog = append(l.backlog, data)
+	l.bytes += len(data)
+	n := 0
+	for l.bytes > l.size && n < len(l.backlog) {
+		l.bytes -= len(l.backlog[n])
+		l.backlog[n] = nil
+		n++
+	}
+	l.backlog = l.backlog[n:]
+	if l.signal != nil {
+		close(l.signal)
+		l.signal = nil
+	}
+}
+
+// since операции журнала после offset и канал, закрывающийся при появлении новых операций.
+// ok == false, если нужные операции уже вытеснены из журнала
+func (l *Leader) since(offset uint64) (ops [][]byte, signal <-chan struct{}, ok bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if offset > l.offset || l.offset-offset > uint64(len(l.backlog)) {
+		return nil, nil, false
+	}
+	if l.signal == nil {
+		l.signal = make(chan struct{})
+	}
+	return l.backlog[len(l.backlog)-int(l.offset-offset):], l.signal, true
+}
+
+// snapshot полный снимок хранилища и номер операции, которой он соответствует
+func (l *Leader) snapshot() ([]byte, uint64, error) {
+	l.mu.Lock()
+	entries := l.storage.Dump()
+	offset := l.offset
+	l.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	return data, offset, err
+}
+
+// ServeRedeo обработка команды sync <replication id> <offset> от реплики.
+// Если реплика продолжает известную историю и нужные операции есть в журнале, отвечает CONTINUE,
+// иначе FULLSYNC <replication id> <offset> и снимком хранилища. Затем передаёт операции по мере их появления
+func (l *Leader) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	var (
+		ops    [][]byte
+		signal <-chan struct{}
+		ok     bool
+	)
+	offset, err := strconv.ParseUint(c.Arg(1).String(), 10, 64)
+	if err == nil && c.Arg(0).String() == l.id {
+		ops, signal, ok = l.since(offset)
+	}
+
+	if ok {
+		w.AppendInlineString("CONTINUE")
+	} else {
+		var snapshot []byte
+		snapshot, offset, err = l.snapshot()
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendInlineString(fmt.Sprintf("FULLSYNC %s %d", l.id, offset))
+		w.AppendBulk(snapshot)
+		ops, signal, ok = l.since(offset)
+	}
+
+	atomic.AddInt32(&l.replicas, 1)
+	defer atomic.AddInt32(&l.replicas, -1)
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for ok {
+		for _, op := range ops {
+			w.AppendBulk(op)
+		}
+		offset += uint64(len(ops))
+		if err := w.Flush(); err != nil {
+			return
+		}
+
+		select {
+		case <-signal:
+		case <-ticker.C:
+			w.AppendInlineString("PING")
+		case <-l.done:
+			ok = false
+			continue
+		}
+		ops, signal, ok = l.since(offset)
+	}
+
+	// Реплика отстала больше, чем на размер журнала, либо узел останавливается: разрываем соединение,
+	// реплика переподключится
+	if client := redeo.GetClient(c.Context()); client != nil {
+		client.Close()
+	}
+}
+
+func (l *Leader) GetKeys() []string {
+	return l.storage.GetKeys()
+}
+
+func (l *Leader) GetElement(key string) (interface{}, error) {
+	return l.storage.GetElement(key)
+}
+
+func (l *Leader) GetListElement(key string, index int) (string, error) {
+	return l.storage.GetListElement(key, index)
+}
+
+func (l *Leader) GetDictionaryElement(key, internalKey string) (string, error) {
+	return l.storage.GetDictionaryElement(key, internalKey)
+}
+
+func (l *Leader) GetType(key string) (structs.ValueType, error) {
+	return l.storage.GetType(key)
+}
+
+func (l *Leader) PutOrUpdateString(key, value string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previousVal, isUpdated := l.storage.PutOrUpdateString(key, value)
+	l.append(&Op{Kind: OpSet, Key: key, Entry: &structs.Entry{Key: key, Type: structs.String, Value: value}})
+	return previousVal, isUpdated
+}
+
+func (l *Leader) PutOrUpdateList(key string, value []string) ([]string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previousVal, isUpdated := l.storage.PutOrUpdateList(key, value)
+	l.append(&Op{Kind: OpSet, Key: key, Entry: &structs.Entry{Key: key, Type: structs.List, Value: value}})
+	return previousVal, isUpdated
+}
+
+func (l *Leader) PutOrUpdateDictionary(key string, value map[string]string) (map[string]string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	previousVal, isUpdated := l.storage.PutOrUpdateDictionary(key, value)
+	l.append(&Op{Kind: OpSet, Key: key, Entry: &structs.Entry{Key: key, Type: structs.Dictionary, Value: value}})
+	return previousVal, isUpdated
+}
+
+func (l *Leader) RemoveElement(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.storage.RemoveElement(key)
+	l.append(&Op{Kind: OpRemove, Key: key})
+}
+
+// SetTTL реплицируется как установка момента истечения ключа
+// Deprecated
+func (l *Leader) SetTTL(key string, keyTTL uint64) {
+	l.SetExpired(key, keyTTL)
+}
+
+func (l *Leader) SetExpired(key string, expired uint64) {
+	if expired == 0 {
+		return
+	}
+	l.ExpireAt(key, uint64(time.Now().Add(time.Millisecond*time.Duration(expired)).UnixNano()))
+}
+
+func (l *Leader) ExpireAt(key string, deadline uint64) {
+	if deadline == 0 {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.storage.ExpireAt(key, deadline)
+	l.append(&Op{Kind: OpExpireAt, Key: key, Deadline: deadline})
+}
+
+func (l *Leader) Dump() []structs.Entry {
+	return l.storage.Dump()
+}
+
+func (l *Leader) DumpKey(key string) (structs.Entry, error) {
+	return l.storage.DumpKey(key)
+}
+
+// Load загрузка записей снимка. Каждая запись реплицируется отдельной операцией
+func (l *Leader) Load(entries []structs.Entry) {
+	l.LoadContext(context.Background(), entries)
+}
+
+// loadChunk количество записей снимка, загружаемых и реплицируемых под одной блокировкой
+const loadChunk = 1024
+
+// LoadContext загрузка записей снимка частями по loadChunk, прерываемая отменой ctx между частями.
+// Уже загруженные части остаются в хранилище и журнале
+func (l *Leader) LoadContext(ctx context.Context, entries []structs.Entry) error {
+	for len(entries) > 0 {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		n := loadChunk
+		if n > len(entries) {
+			n = len(entries)
+		}
+		l.load(entries[:n])
+		entries = entries[n:]
+	}
+	return nil
+}
+
+func (l *Leader) load(entries []structs.Entry) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.storage.Load(entries)
+	for i := range entries {
+		entry := entries[i]
+		l.append(&Op{Kind: OpSet, Key: entry.Key, Entry: &entry})
+		if entry.Expired != 0 {
+			l.append(&Op{Kind: OpExpireAt, Key: entry.Key, Deadline: entry.Expired})
+		}
+	}
+}
+
+// Flush удаление всех ключей. Реплицируется удалением каждого ключа
+func (l *Leader) Flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	keys := l.storage.GetKeys()
+	l.storage.Flush()
+	for _, key := range keys {
+		l.append(&Op{Kind: OpRemove, Key: key})
+	}
+}
+
+func (l *Leader) StreamAdd(key, id string, fields map[string]string) (structs.StreamID, error) {
+	if l.streams == nil {
+		return structs.StreamID{}, structs.ErrNotSupported
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	newID, err := l.streams.StreamAdd(key, id, fields)
+	if err != nil {
+		return newID, err
+	}
+	l.append(&Op{Kind: OpStreamAdd, Key: key, ID: newID.String(), Fields: fields})
+	return newID, nil
+}
+
+// StreamAddTrim реплицируется одной операцией
+func (l *Leader) StreamAddTrim(key, id string, fields map[string]string, maxLen int) (structs.StreamID, error) {
+	if l.streams == nil {
+		return structs.StreamID{}, structs.ErrNotSupported
+	}
+	if maxLen < 0 {
+		return l.StreamAdd(key, id, fields)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	newID, err := l.streams.StreamAddTrim(key, id, fields, maxLen)
+	if err != nil {
+		return newID, err
+	}
+	l.append(&Op{Kind: OpStreamAddTrim, Key: key, ID: newID.String(), Fields: fields, MaxLen: maxLen})
+	return newID, nil
+}
+
+func (l *Leader) StreamRange(key, start, end string, count int) ([]structs.StreamEntry, error) {
+	if l.streams == nil {
+		return nil, structs.ErrNotSupported
+	}
+	return l.streams.StreamRange(key, start, end, count)
+}
+
+func (l *Leader) StreamRead(keys, ids []string, count int, block time.Duration) (map[string][]structs.StreamEntry, error) {
+	if l.streams == nil {
+		return nil, structs.ErrNotSupported
+	}
+	return l.streams.StreamRead(keys, ids, count, block)
+}
+
+func (l *Leader) StreamLen(key string) (int, error) {
+	if l.streams == nil {
+		return 0, structs.ErrNotSupported
+	}
+	return l.streams.StreamLen(key)
+}
+
+func (l *Leader) StreamTrim(key string, maxLen int) (int, error) {
+	if l.streams == nil {
+		return 0, structs.ErrNotSupported
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	removed, err := l.streams.StreamTrim(key, maxLen)
+	if err != nil {
+		return removed, err
+	}
+	l.append(&Op{Kind: OpStreamTrim, Key: key, MaxLen: maxLen})
+	return removed, nil
+}
+
+// StreamGroupCreate реплицируется с исходным идентификатором: "$" на реплике соответствует той же записи
+func (l *Leader) StreamGroupCreate(key, group, id string, mkStream bool) error {
+	if l.streams == nil {
+		return structs.ErrNotSupported
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := l.streams.StreamGroupCreate(key, group, id, mkStream); err != nil {
+		return err
+	}
+	l.append(&Op{Kind: OpStreamGroupCreate, Key: key, Group: group, ID: id, MkStream: mkStream})
+	return nil
+}
+
+func (l *Leader) StreamGroupDestroy(key, group string) (bool, error) {
+	if l.streams == nil {
+		return false, structs.ErrNotSupported
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	destroyed, err := l.streams.StreamGroupDestroy(key, group)
+	if destroyed {
+		l.append(&Op{Kind: OpStreamGroupDestroy, Key: key, Group: group})
+	}
+	return destroyed, err
+}
+
+func (l *Leader) StreamReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]structs.StreamEntry, error) {
+	return l.StreamReadGroupContext(context.Background(), group, consumer, keys, ids, count, block, noAck)
+}
+
+func (l *Leader) StreamAck(key, group string, ids []string) (int, error) {
+	if l.streams == nil {
+		return 0, structs.ErrNotSupported
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	acked, err := l.streams.StreamAck(key, group, ids)
+	if acked > 0 {
+		l.append(&Op{Kind: OpStreamAck, Key: key, Group: group, IDs: ids})
+	}
+	return acked, err
+}
+
+func (l *Leader) StreamPending(key, group string) ([]structs.PendingEntry, error) {
+	if l.streams == nil {
+		return nil, structs.ErrNotSupported
+	}
+	return l.streams.StreamPending(key, group)
+}
+
+// StreamClaim реплицируется вместе с моментом передачи записей
+func (l *Leader) StreamClaim(key, group, consumer string, minIdle time.Duration, ids []string) ([]structs.StreamEntry, error) {
+	replayer, ok := l.streams.(structs.StreamGroupReplayer)
+	if !ok {
+		return nil, structs.ErrNotSupported
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	at := time.Now().UnixNano()
+	result, err := replayer.StreamClaimAt(time.Unix(0, at), key, group, consumer, minIdle, ids)
+	if err != nil {
+		return nil, err
+	}
+	l.append(&Op{Kind: OpStreamClaim, Key: key, Group: group, Consumer: consumer, MinIdle: minIdle, IDs: ids, At: at})
+	return result, nil
+}
+
+func newReplicationID() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,133 @@
+package replication
+
+import (
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Виды изменяющих операций
+const (
+	OpSet           = "set"
+	OpRemove        = "remove"
+	OpExpireAt      = "expireat"
+	OpStreamAdd     = "xadd"
+	OpStreamAddTrim = "xaddtrim"
+	OpStreamTrim    = "xtrim"
+
+	OpStreamGroupCreate  = "xgroupcreate"
+	OpStreamGroupDestroy = "xgroupdestroy"
+	OpStreamReadGroup    = "xreadgroup"
+	OpStreamAck          = "xack"
+	OpStreamClaim        = "xclaim"
+)
+
+// Storage хранилище, которое может быть источником либо получателем репликации
+type Storage interface {
+	structs.Storage
+	structs.Snapshotter
+}
+
+// Op изменяющая операция, передаваемая от ведущего узла репликам.
+// At время выдачи записей группой потребителей (UnixNano), одинаковое на всех узлах
+type Op struct {
+	Offset   uint64            `json:"offset"`
+	Kind     string            `json:"kind"`
+	Key      string            `json:"key"`
+	Entry    *structs.Entry    `json:"entry,omitempty"`
+	Deadline uint64            `json:"deadline,omitempty"`
+	ID       string            `json:"id,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	MaxLen   int               `json:"maxlen,omitempty"`
+	Group    string            `json:"group,omitempty"`
+	Consumer string            `json:"consumer,omitempty"`
+	Keys     []string          `json:"keys,omitempty"`
+	IDs      []string          `json:"ids,omitempty"`
+	Count    int               `json:"count,omitempty"`
+	NoAck    bool              `json:"noack,omitempty"`
+	MkStream bool              `json:"mkstream,omitempty"`
+	MinIdle  time.Duration     `json:"minidle,omitempty"`
+	At       int64             `json:"at,omitempty"`
+}
+
+// Result результат применения операции
+type Result struct {
+	PreviousVal interface{}
+	IsUpdated   bool
+	ID          structs.StreamID
+	Removed     int
+	Acked       int
+	Destroyed   bool
+	Entries     []structs.StreamEntry
+	Streams     map[string][]structs.StreamEntry
+}
+
+// Apply применение операции к хранилищу. Значение потока загружается в хранилище вместе с группами
+func (op *Op) Apply(storage Storage) (Result, error) {
+	var result Result
+	switch op.Kind {
+	case OpSet:
+		if op.Entry == nil {
+			return result, structs.ErrType
+		}
+		switch v := op.Entry.Value.(type) {
+		case string:
+			result.PreviousVal, result.IsUpdated = storage.PutOrUpdateString(op.Key, v)
+		case []string:
+			result.PreviousVal, result.IsUpdated = storage.PutOrUpdateList(op.Key, v)
+		case map[string]string:
+			result.PreviousVal, result.IsUpdated = storage.PutOrUpdateDictionary(op.Key, v)
+		case []structs.StreamEntry:
+			storage.Load([]structs.Entry{*op.Entry})
+		default:
+			return result, structs.ErrType
+		}
+	case OpRemove:
+		storage.RemoveElement(op.Key)
+	case OpExpireAt:
+		storage.ExpireAt(op.Key, op.Deadline)
+	default:
+		return op.applyStream(storage)
+	}
+	return result, nil
+}
+
+// applyStream применение операции с потоком
+func (op *Op) applyStream(storage Storage) (Result, error) {
+	var (
+		result Result
+		err    error
+	)
+	streams, ok := storage.(structs.StreamStorage)
+	if !ok {
+		return result, structs.ErrNotSupported
+	}
+	switch op.Kind {
+	case OpStreamAdd:
+		result.ID, err = streams.StreamAdd(op.Key, op.ID, op.Fields)
+	case OpStreamAddTrim:
+		result.ID, err = streams.StreamAddTrim(op.Key, op.ID, op.Fields, op.MaxLen)
+	case OpStreamTrim:
+		result.Removed, err = streams.StreamTrim(op.Key, op.MaxLen)
+	case OpStreamGroupCreate:
+		err = streams.StreamGroupCreate(op.Key, op.Group, op.ID, op.MkStream)
+	case OpStreamGroupDestroy:
+		result.Destroyed, err = streams.StreamGroupDestroy(op.Key, op.Group)
+	case OpStreamAck:
+		result.Acked, err = streams.StreamAck(op.Key, op.Group, op.IDs)
+	case OpStreamReadGroup, OpStreamClaim:
+		replayer, ok := storage.(structs.StreamGroupReplayer)
+		if !ok {
+			return result, structs.ErrNotSupported
+		}
+		at := time.Unix(0, op.At)
+		if op.Kind == OpStreamReadGroup {
+			result.Streams, err = replayer.StreamReadGroupAt(at, op.Group, op.Consumer, op.Keys, op.IDs, op.Count, op.NoAck)
+		} else {
+			result.Entries, err = replayer.StreamClaimAt(at, op.Key, op.Group, op.Consumer, op.MinIdle, op.IDs)
+		}
+	default:
+		return result, structs.ErrNotSupported
+	}
+	return result, err
+}
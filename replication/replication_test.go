@@ -0,0 +1,310 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/storagetest"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tcpserver"
+)
+
+// startServer запуск TCP сервера на случайном порту localhost
+func startServer(t *testing.T, storage structs.Storage, guard structs.Guard, leader *Leader) string {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	srv := tcpserver.NewServer("", storage)
+	srv.SetGuard(guard)
+	if leader != nil {
+		srv.Handle("sync", leader)
+	}
+	go srv.Serve(lis)
+	return lis.Addr().String()
+}
+
+func startFollower(t *testing.T, f *Follower) {
+	go f.Run()
+	t.Cleanup(f.Close)
+}
+
+// eventually ожидание выполнения условия
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func sameData(a, b structs.Snapshotter) func() bool {
+	return func() bool {
+		return reflect.DeepEqual(a.Dump(), b.Dump())
+	}
+}
+
+func TestReplication_FullSync(t *testing.T) {
+	leader := NewLeader(mapbased.NewStorage(), 0)
+	leader.PutOrUpdateString("str", "value")
+	leader.PutOrUpdateList("list", []string{"a", "b"})
+	leader.PutOrUpdateDictionary("dict", map[string]string{"k": "v"})
+	leader.SetExpired("str", 60000)
+	if _, err := leader.StreamAdd("events", "1-1", map[string]string{"f": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, leader, nil, leader)
+
+	replica := mapbased.NewStorage()
+	f := NewFollower(addr, replica)
+	startFollower(t, f)
+
+	eventually(t, "full sync", sameData(leader, replica))
+	if f.FullSyncs() != 1 || f.Offset() != leader.Offset() {
+		t.Errorf("FullSyncs() = %d, Offset() = %d, want 1, %d", f.FullSyncs(), f.Offset(), leader.Offset())
+	}
+	if dump := replica.Dump(); dump[len(dump)-1].Key != "str" || dump[len(dump)-1].Expired == 0 {
+		t.Errorf("Dump() = %+v, want TTL deadline for str", dump)
+	}
+}
+
+func TestReplication_Mutations(t *testing.T) {
+	leader := NewLeader(mapbased.NewStorage(), 0)
+	addr := startServer(t, leader, nil, leader)
+
+	replica := mapbased.NewStorage()
+	f := NewFollower(addr, replica)
+	startFollower(t, f)
+	eventually(t, "connection", f.Connected)
+
+	leader.PutOrUpdateString("str", "value")
+	leader.PutOrUpdateString("str", "new value")
+	leader.PutOrUpdateList("list", []string{"a", "b"})
+	leader.PutOrUpdateDictionary("dict", map[string]string{"k": "v"})
+	leader.PutOrUpdateString("removed", "value")
+	leader.RemoveElement("removed")
+	leader.SetExpired("list", 60000)
+	for i := 0; i < 5; i++ {
+		if _, err := leader.StreamAdd("events", "*", map[string]string{"n": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := leader.StreamTrim("events", 2); err != nil {
+		t.Fatal(err)
+	}
+	// Добавление с удалением старых записей - одна операция журнала
+	offset := leader.Offset()
+	if _, err := leader.StreamAddTrim("events", "*", map[string]string{"n": "5"}, 2); err != nil {
+		t.Fatal(err)
+	}
+	if got := leader.Offset(); got != offset+1 {
+		t.Errorf("Offset() after StreamAddTrim() = %d, want %d", got, offset+1)
+	}
+
+	eventually(t, "mutations", sameData(leader, replica))
+	if f.FullSyncs() != 1 {
+		t.Errorf("FullSyncs() = %d, want 1", f.FullSyncs())
+	}
+	if n, _ := replica.StreamLen("events"); n != 2 {
+		t.Errorf("StreamLen() = %d, want 2", n)
+	}
+}
+
+func TestReplication_Reconnect(t *testing.T) {
+	leader := NewLeader(mapbased.NewStorage(), 0)
+	addr := startServer(t, leader, nil, leader)
+
+	replica := mapbased.NewStorage()
+	f := NewFollower(addr, replica)
+	startFollower(t, f)
+	leader.PutOrUpdateString("before", "value")
+	eventually(t, "first sync", sameData(leader, replica))
+
+	// Разрыв соединения: реплика должна продолжить с сохранённого смещения без полной синхронизации
+	f.mu.Lock()
+	f.conn.Close()
+	f.mu.Unlock()
+	leader.PutOrUpdateString("during", "value")
+	leader.PutOrUpdateString("after", "value")
+
+	eventually(t, "resync", sameData(leader, replica))
+	eventually(t, "offset", func() bool { return f.Offset() == leader.Offset() })
+	if f.FullSyncs() != 1 {
+		t.Errorf("FullSyncs() = %d, want 1", f.FullSyncs())
+	}
+}
+
+func TestReplication_BacklogOverflow(t *testing.T) {
+	// Журнал вмещает пять операций по 89 байт
+	leader := NewLeader(mapbased.NewStorage(), 450)
+	addr := startServer(t, leader, nil, leader)
+	for i := 0; i < 20; i++ {
+		leader.PutOrUpdateString(fmt.Sprint("key", i), "value")
+	}
+
+	tests := []struct {
+		name          string
+		offset        uint64
+		wantFullSyncs int
+	}{
+		{
+			name:          "Testing sync: offset is in the backlog",
+			offset:        leader.Offset() - 3,
+			wantFullSyncs: 0,
+		},
+		{
+			name:          "Testing sync: offset was evicted from the backlog",
+			offset:        leader.Offset() - 10,
+			wantFullSyncs: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Реплика с данными, соответствующими смещению offset
+			replica := mapbased.NewStorage()
+			replica.Load(leader.Dump())
+			for i := tt.offset; i < leader.Offset(); i++ {
+				replica.RemoveElement(fmt.Sprint("key", i))
+			}
+
+			f := NewFollower(addr, replica)
+			f.id, f.offset = leader.ID(), tt.offset
+			startFollower(t, f)
+
+			eventually(t, "sync", sameData(leader, replica))
+			if f.FullSyncs() != tt.wantFullSyncs {
+				t.Errorf("FullSyncs() = %d, want %d", f.FullSyncs(), tt.wantFullSyncs)
+			}
+		})
+	}
+}
+
+func TestFollower_RejectsWrites(t *testing.T) {
+	leader := NewLeader(mapbased.NewStorage(), 0)
+	leader.PutOrUpdateString("key", "value")
+	leaderAddr := startServer(t, leader, nil, leader)
+
+	replica := mapbased.NewStorage()
+	f := NewFollower(leaderAddr, replica)
+	startFollower(t, f)
+	eventually(t, "sync", sameData(leader, replica))
+
+	if err := f.Guard(context.Background(), "key", true); err != structs.ErrReadOnly {
+		t.Errorf("Guard() error = %v, wantErr %v", err, structs.ErrReadOnly)
+	}
+
+	conn, err := net.Dial("tcp", startServer(t, replica, f.Guard, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{command: "key key", want: "value"},
+		{command: "set string key other", want: "-" + structs.ErrReadOnly.Error()},
+		{command: "remove key", want: "-" + structs.ErrReadOnly.Error()},
+	}
+	for _, tt := range tests {
+		fmt.Fprintf(conn, "%s\r\n", tt.command)
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Значение ключа приходит bulk строкой
+		if strings.HasPrefix(line, "$") {
+			if line, err = r.ReadString('\n'); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := strings.TrimSpace(line); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestLeader_Conformance(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) structs.Storage {
+		storage := mapbased.NewStorage()
+		leader := NewLeader(storage, DefaultBacklogSize)
+		t.Cleanup(func() {
+			leader.Close()
+			storage.Close()
+		})
+		return leader
+	})
+}
+
+func TestReplication_StreamGroups(t *testing.T) {
+	leader := NewLeader(mapbased.NewStorage(), 0)
+	for i := 0; i < 4; i++ {
+		if _, err := leader.StreamAdd("events", "*", map[string]string{"n": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := leader.StreamGroupCreate("events", "workers", "0", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.StreamReadGroup("workers", "alice", []string{"events"}, []string{">"}, 1, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, leader, nil, leader)
+
+	// Группы передаются полной синхронизацией
+	replica := mapbased.NewStorage()
+	f := NewFollower(addr, replica)
+	startFollower(t, f)
+	eventually(t, "full sync", sameData(leader, replica))
+
+	if err := leader.StreamGroupCreate("events", "audit", "$", false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.StreamReadGroup("workers", "bob", []string{"events"}, []string{">"}, 3, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := leader.StreamReadGroup("workers", "alice", []string{"events"}, []string{"0"}, 0, 0, false); err != nil {
+		t.Fatal(err)
+	}
+	pending, _ := leader.StreamPending("events", "workers")
+	if acked, err := leader.StreamAck("events", "workers", []string{pending[0].ID.String()}); err != nil || acked != 1 {
+		t.Fatalf("StreamAck() got = %v, %v, want 1", acked, err)
+	}
+	if _, err := leader.StreamClaim("events", "workers", "carol", 0, []string{pending[1].ID.String()}); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = leader.StreamAdd("events", "*", map[string]string{"n": "4"})
+	}()
+	got, err := leader.StreamReadGroup("workers", "bob", []string{"events"}, []string{">"}, 0, time.Second, false)
+	if err != nil || len(got["events"]) != 1 {
+		t.Errorf("StreamReadGroup() got = %v, %v, want the new entry", got, err)
+	}
+	if _, err := leader.StreamGroupDestroy("events", "audit"); err != nil {
+		t.Fatal(err)
+	}
+
+	eventually(t, "group changes", sameData(leader, replica))
+	if f.FullSyncs() != 1 {
+		t.Errorf("FullSyncs() = %d, want 1", f.FullSyncs())
+	}
+	want, _ := leader.StreamPending("events", "workers")
+	if got, _ := replica.StreamPending("events", "workers"); !reflect.DeepEqual(got, want) {
+		t.Errorf("StreamPending() on the replica = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,113 @@
+package slowlog_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/slowlog"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestSlowlog_Servers(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	users, err := auth.FromAccounts(map[string]string{"admin": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Нулевой порог: в журнал попадают все команды
+	l := slowlog.New(0, 10)
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetUsers(users)
+	tcp.SetSlowlog(l)
+	go tcp.Serve(lis)
+	cn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	r := bufio.NewReader(cn)
+	for _, cmd := range []string{"auth admin secret", "key missing"} {
+		fmt.Fprintf(cn, "%s\r\n", cmd)
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httpserver.NewServer("", nil, storage)
+	srv.SetUsers(users)
+	srv.SetSlowlog(l)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	do := func(method, path string) *http.Response {
+		req, _ := http.NewRequest(method, ts.URL+path, nil)
+		req.SetBasicAuth("admin", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	do(http.MethodGet, "/cache/key/a").Body.Close()
+
+	resp := do(http.MethodGet, "/admin/slowlog?count=-1")
+	defer resp.Body.Close()
+	var got struct {
+		Len     int `json:"len"`
+		Entries []struct {
+			Client string   `json:"client"`
+			User   string   `json:"user"`
+			Args   []string `json:"args"`
+		} `json:"entries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Len != 3 || len(got.Entries) != 3 {
+		t.Fatalf("GET /admin/slowlog = %+v, want 3 entries", got)
+	}
+
+	tests := []struct {
+		name string
+		user string
+		args []string
+	}{
+		{name: "http request", user: "admin", args: []string{"GET", "/cache/key/a"}},
+		{name: "tcp command", user: "admin", args: []string{"key", "missing"}},
+		{name: "redacted password", user: auth.DefaultUser, args: []string{"auth", "(redacted)", "(redacted)"}},
+	}
+	for i, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			e := got.Entries[i]
+			if e.User != tt.user || !reflect.DeepEqual(e.Args, tt.args) || e.Client == "" {
+				t.Errorf("entry = %+v, want user %q and args %q", e, tt.user, tt.args)
+			}
+		})
+	}
+
+	do(http.MethodDelete, "/admin/slowlog").Body.Close()
+	// Сам запрос очистки записывается после неё
+	if n := l.Len(); n != 1 {
+		t.Errorf("Len() after DELETE /admin/slowlog = %d, want 1", n)
+	}
+}
@@ -0,0 +1,229 @@
+// Package slowlog журнал медленных команд TCP и запросов HTTP
+package slowlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+)
+
+const (
+	// DefaultThreshold длительность, начиная с которой команда попадает в журнал
+	DefaultThreshold = 10 * time.Millisecond
+	// DefaultMaxLen количество хранимых записей
+	DefaultMaxLen = 128
+
+	// maxArgs, maxArgLen ограничения аргументов записи, как в Redis
+	maxArgs   = 32
+	maxArgLen = 128
+)
+
+// Entry запись журнала
+type Entry struct {
+	ID       uint64        `json:"id"`
+	Time     time.Time     `json:"time"`
+	Duration time.Duration `json:"-"`
+	Client   string        `json:"client"`
+	User     string        `json:"user"`
+	Args     []string      `json:"args"`
+}
+
+// MarshalJSON запись с длительностью в микросекундах
+func (e Entry) MarshalJSON() ([]byte, error) {
+	type entry Entry
+	return json.Marshal(struct {
+		entry
+		Duration int64 `json:"duration_us"`
+	}{entry(e), int64(e.Duration / time.Microsecond)})
+}
+
+// Log журнал в кольцевом буфере: при заполнении новые записи вытесняют самые старые.
+// Запись в nil *Log и чтение из него ничего не делают
+type Log struct {
+	// threshold time.Duration, отрицательное значение отключает журнал, 0 - все команды
+	threshold int64
+
+	mu      sync.Mutex
+	entries []Entry
+	// next позиция следующей записи, count количество записей в буфере
+	next, count int
+	lastID      uint64
+}
+
+// New журнал на maxLen записей с порогом threshold
+func New(threshold time.Duration, maxLen int) *Log {
+	l := &Log{threshold: int64(threshold)}
+	l.SetMaxLen(maxLen)
+	return l
+}
+
+// SetThreshold порог длительности, отрицательное значение отключает журнал. Может вызываться во время работы
+func (l *Log) SetThreshold(d time.Duration) {
+	atomic.StoreInt64(&l.threshold, int64(d))
+}
+
+// SetMaxLen размер буфера. Самые новые записи, которые помещаются в новый буфер, сохраняются.
+// Может вызываться во время работы
+func (l *Log) SetMaxLen(n int) {
+	if n < 1 {
+		n = 1
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n == len(l.entries) {
+		return
+	}
+	entries := l.newest(n)
+	l.entries = make([]Entry, n)
+	// В буфере записи идут от старых к новым
+	for i, e := range entries {
+		l.entries[len(entries)-1-i] = e
+	}
+	l.count = len(entries)
+	l.next = l.count % n
+}
+
+// Slow проверка порога: true, если команда длительностью d должна попасть в журнал
+func (l *Log) Slow(d time.Duration) bool {
+	if l == nil {
+		return false
+	}
+	threshold := time.Duration(atomic.LoadInt64(&l.threshold))
+	return threshold >= 0 && d >= threshold
+}
+
+// Add запись команды длительностью d, если она не быстрее порога. args - имя команды и аргументы,
+// сохраняются не более 32 аргументов не длиннее 128 байт
+func (l *Log) Add(d time.Duration, client, user string, args []string) {
+	if !l.Slow(d) {
+		return
+	}
+	entry := Entry{Time: time.Now(), Duration: d, Client: client, User: user, Args: truncate(args)}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lastID++
+	entry.ID = l.lastID
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	if l.count < len(l.entries) {
+		l.count++
+	}
+}
+
+// truncate копия аргументов в пределах ограничений
+func truncate(args []string) []string {
+	n := len(args)
+	if n > maxArgs {
+		n = maxArgs
+	}
+	result := make([]string, n)
+	for i := 0; i < n; i++ {
+		arg := args[i]
+		if len(arg) > maxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:maxArgLen], len(arg)-maxArgLen)
+		}
+		result[i] = arg
+	}
+	if len(args) > maxArgs {
+		result[maxArgs-1] = fmt.Sprintf("... (%d more arguments)", len(args)-maxArgs+1)
+	}
+	return result
+}
+
+// Get не более n последних записей, начиная с самой новой. n < 0 - все записи
+func (l *Log) Get(n int) []Entry {
+	if l == nil {
+		return []Entry{}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if n < 0 || n > l.count {
+		n = l.count
+	}
+	return l.newest(n)
+}
+
+// newest n последних записей, начиная с самой новой. Вызывается под блокировкой
+func (l *Log) newest(n int) []Entry {
+	if n > l.count {
+		n = l.count
+	}
+	result := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		result = append(result, l.entries[(l.next-i+len(l.entries))%len(l.entries)])
+	}
+	return result
+}
+
+// Len количество записей
+func (l *Log) Len() int {
+	if l == nil {
+		return 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.count
+}
+
+// Reset удаление всех записей. Нумерация записей продолжается
+func (l *Log) Reset() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for i := range l.entries {
+		l.entries[i] = Entry{}
+	}
+	l.next, l.count = 0, 0
+}
+
+// ServeRedeo обработка команды slowlog get [count] | len | reset. Запись get - массив из номера,
+// времени в секундах Unix, длительности в микросекундах, аргументов, адреса клиента и пользователя
+func (l *Log) ServeRedeo(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	sub := strings.ToLower(c.Arg(0).String())
+	switch {
+	case sub == "get" && c.ArgN() <= 2:
+		n := 10
+		if c.ArgN() == 2 {
+			var err error
+			if n, err = strconv.Atoi(c.Arg(1).String()); err != nil {
+				w.AppendError("ERR value is not an integer or out of range")
+				return
+			}
+		}
+		entries := l.Get(n)
+		w.AppendArrayLen(len(entries))
+		for _, e := range entries {
+			w.AppendArrayLen(6)
+			w.AppendInt(int64(e.ID))
+			w.AppendInt(e.Time.Unix())
+			w.AppendInt(int64(e.Duration / time.Microsecond))
+			w.AppendArrayLen(len(e.Args))
+			for _, arg := range e.Args {
+				w.AppendBulkString(arg)
+			}
+			w.AppendBulkString(e.Client)
+			w.AppendBulkString(e.User)
+		}
+	case sub == "len" && c.ArgN() == 1:
+		w.AppendInt(int64(l.Len()))
+	case sub == "reset" && c.ArgN() == 1:
+		l.Reset()
+		w.AppendOK()
+	default:
+		w.AppendError(redeo.UnknownCommand(c.Name + " " + c.Arg(0).String()))
+	}
+}
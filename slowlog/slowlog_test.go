@@ -0,0 +1,163 @@
+package slowlog
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo/redeotest"
+	"github.com/bsm/redeo/resp"
+)
+
+// ids номера записей
+func ids(entries []Entry) []uint64 {
+	result := []uint64{}
+	for _, e := range entries {
+		result = append(result, e.ID)
+	}
+	return result
+}
+
+func TestLog(t *testing.T) {
+	l := New(time.Millisecond, 3)
+	l.Add(time.Microsecond, "client", "user", []string{"fast"})
+	for i := 1; i <= 5; i++ {
+		l.Add(time.Duration(i)*time.Millisecond, "127.0.0.1:1", "user", []string{"cmd", strconv.Itoa(i)})
+	}
+
+	tests := []struct {
+		name string
+		n    int
+		want []uint64
+	}{
+		{name: "all", n: -1, want: []uint64{5, 4, 3}},
+		{name: "newest", n: 1, want: []uint64{5}},
+		{name: "more than stored", n: 10, want: []uint64{5, 4, 3}},
+		{name: "none", n: 0, want: []uint64{}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing Get: "+tt.name, func(t *testing.T) {
+			if got := ids(l.Get(tt.n)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Get(%d) = %v, want %v", tt.n, got, tt.want)
+			}
+		})
+	}
+	if e := l.Get(1)[0]; e.Duration != 5*time.Millisecond || e.Client != "127.0.0.1:1" || e.User != "user" || !reflect.DeepEqual(e.Args, []string{"cmd", "5"}) {
+		t.Errorf("newest entry = %+v", e)
+	}
+
+	t.Run("Testing SetMaxLen", func(t *testing.T) {
+		l.SetMaxLen(2)
+		if got := ids(l.Get(-1)); !reflect.DeepEqual(got, []uint64{5, 4}) {
+			t.Errorf("after shrinking Get() = %v, want [5 4]", got)
+		}
+		l.SetMaxLen(4)
+		l.Add(time.Second, "", "", nil)
+		if got := ids(l.Get(-1)); !reflect.DeepEqual(got, []uint64{6, 5, 4}) {
+			t.Errorf("after growing Get() = %v, want [6 5 4]", got)
+		}
+	})
+
+	t.Run("Testing SetThreshold", func(t *testing.T) {
+		l.SetThreshold(-1)
+		l.Add(time.Hour, "", "", nil)
+		if n := l.Len(); n != 3 {
+			t.Errorf("disabled log recorded a command, Len() = %d", n)
+		}
+		l.SetThreshold(0)
+		l.Add(0, "", "", nil)
+		if n := l.Len(); n != 4 {
+			t.Errorf("zero threshold skipped a command, Len() = %d", n)
+		}
+	})
+
+	t.Run("Testing Reset", func(t *testing.T) {
+		l.Reset()
+		l.Add(time.Second, "", "", nil)
+		if got := ids(l.Get(-1)); !reflect.DeepEqual(got, []uint64{8}) {
+			t.Errorf("after reset Get() = %v, want [8]", got)
+		}
+	})
+}
+
+func TestLog_Truncate(t *testing.T) {
+	args := make([]string, 40)
+	for i := range args {
+		args[i] = strconv.Itoa(i)
+	}
+	args[1] = strings.Repeat("x", 200)
+
+	got := truncate(args)
+	if len(got) != maxArgs {
+		t.Fatalf("len = %d, want %d", len(got), maxArgs)
+	}
+	if want := strings.Repeat("x", 128) + "... (72 more bytes)"; got[1] != want {
+		t.Errorf("long argument = %q", got[1])
+	}
+	if want := "... (9 more arguments)"; got[maxArgs-1] != want {
+		t.Errorf("last argument = %q, want %q", got[maxArgs-1], want)
+	}
+}
+
+func TestLog_ServeRedeo(t *testing.T) {
+	l := New(0, 10)
+	l.Add(1500*time.Microsecond, "127.0.0.1:1", "admin", []string{"keys"})
+	l.Add(2*time.Millisecond, "127.0.0.1:2", "reader", []string{"key", "a"})
+	ts := l.Get(1)[0].Time.Unix()
+
+	tests := []struct {
+		name string
+		args []string
+		want interface{}
+	}{
+		{name: "get", args: []string{"get", "1"}, want: []interface{}{
+			[]interface{}{int64(2), ts, int64(2000), []interface{}{"key", "a"}, "127.0.0.1:2", "reader"},
+		}},
+		{name: "len", args: []string{"LEN"}, want: int64(2)},
+		{name: "invalid count", args: []string{"get", "many"}, want: redeotest.ErrorResponse("ERR value is not an integer or out of range")},
+		{name: "unknown subcommand", args: []string{"rewrite"}, want: redeotest.ErrorResponse("ERR unknown command 'slowlog rewrite'")},
+		{name: "reset", args: []string{"reset"}, want: "OK"},
+		{name: "get after reset", args: []string{"get"}, want: []interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing slowlog "+strings.Join(tt.args, " "), func(t *testing.T) {
+			args := make([]resp.CommandArgument, len(tt.args))
+			for i, arg := range tt.args {
+				args[i] = resp.CommandArgument(arg)
+			}
+			w := redeotest.NewRecorder()
+			l.ServeRedeo(w, resp.NewCommand("slowlog", args...))
+			got, err := w.Response()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntry_MarshalJSON(t *testing.T) {
+	e := Entry{ID: 1, Time: time.Unix(0, 0).UTC(), Duration: 1500 * time.Microsecond, Client: "c", User: "u", Args: []string{"keys"}}
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"id":1,"time":"1970-01-01T00:00:00Z","client":"c","user":"u","args":["keys"],"duration_us":1500}`
+	if string(data) != want {
+		t.Errorf("got %s, want %s", data, want)
+	}
+}
+
+func TestLog_Nil(t *testing.T) {
+	var l *Log
+	l.Add(time.Hour, "", "", nil)
+	l.Reset()
+	if l.Len() != 0 || len(l.Get(-1)) != 0 {
+		t.Error("nil log has entries")
+	}
+}
@@ -0,0 +1,529 @@
+// Package storagetest общие тесты поведения structs.Storage: типы значений, ошибки, срок жизни ключей,
+// отмена операций и конкурентный доступ. Реализация хранилища проверяется вызовом Run и Stress из своих тестов
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Factory новое пустое хранилище. После теста хранилище закрывается, если реализует io.Closer
+type Factory func(t *testing.T) structs.Storage
+
+// expiryTimeout время, за которое должен быть удалён ключ с истёкшим сроком жизни
+const expiryTimeout = 2 * time.Second
+
+// Run проверка хранилищ newStorage: каждый тест получает новое хранилище
+func Run(t *testing.T, newStorage Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s structs.Storage)
+	}{
+		{name: "empty storage", test: testEmpty},
+		{name: "string", test: testString},
+		{name: "list", test: testList},
+		{name: "dictionary", test: testDictionary},
+		{name: "empty values", test: testEmptyValues},
+		{name: "missing keys", test: testMissingKeys},
+		{name: "type errors", test: testTypeErrors},
+		{name: "overwrite with another type", test: testOverwrite},
+		{name: "remove", test: testRemove},
+		{name: "keys", test: testKeys},
+		{name: "expiry", test: testExpiry},
+		{name: "deprecated ttl", test: testDeprecatedTTL},
+		{name: "zero ttl", test: testZeroTTL},
+		{name: "ttl of missing key", test: testMissingKeyTTL},
+		{name: "remove clears ttl", test: testRemoveClearsTTL},
+		{name: "context", test: testContext},
+		{name: "concurrency", test: testConcurrency},
+		{name: "no aliasing", test: testNoAliasing},
+		{name: "streams", test: testStreams},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			tt.test(t, open(t, newStorage))
+		})
+	}
+}
+
+// open хранилище теста, закрываемое после его завершения
+func open(t *testing.T, newStorage Factory) structs.Storage {
+	t.Helper()
+	s := newStorage(t)
+	if closer, ok := s.(io.Closer); ok {
+		t.Cleanup(func() {
+			if err := closer.Close(); err != nil {
+				t.Errorf("Close() error = %v", err)
+			}
+		})
+	}
+	return s
+}
+
+// checkErr ошибка err соответствует want (nil - операция без ошибки)
+func checkErr(t *testing.T, op string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s error = %v, want %v", op, err, want)
+	}
+}
+
+// checkType тип значения ключа
+func checkType(t *testing.T, s structs.Storage, key string, want structs.ValueType) {
+	t.Helper()
+	got, err := s.GetType(key)
+	if err != nil {
+		t.Errorf("GetType(%q) error = %v", key, err)
+		return
+	}
+	if got != want {
+		t.Errorf("GetType(%q) = %v, want %v", key, got, want)
+	}
+}
+
+// checkKeys ключи хранилища без учёта порядка
+func checkKeys(t *testing.T, s structs.Storage, want ...string) {
+	t.Helper()
+	got := append([]string{}, s.GetKeys()...)
+	sort.Strings(got)
+	want = append([]string{}, want...)
+	sort.Strings(want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetKeys() = %q, want %q", got, want)
+	}
+}
+
+// checkMissing ключа нет в хранилище
+func checkMissing(t *testing.T, s structs.Storage, key string) {
+	t.Helper()
+	if val, err := s.GetElement(key); !errors.Is(err, structs.ErrKeyNotFound) {
+		t.Errorf("GetElement(%q) = %v, %v, want %v", key, val, err, structs.ErrKeyNotFound)
+	}
+	if _, err := s.GetType(key); !errors.Is(err, structs.ErrKeyNotFound) {
+		t.Errorf("GetType(%q) error = %v, want %v", key, err, structs.ErrKeyNotFound)
+	}
+}
+
+// checkValue значение ключа
+func checkValue(t *testing.T, s structs.Storage, key string, want interface{}) {
+	t.Helper()
+	got, err := s.GetElement(key)
+	if err != nil {
+		t.Errorf("GetElement(%q) error = %v", key, err)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetElement(%q) = %#v, want %#v", key, got, want)
+	}
+}
+
+// eventually ожидание выполнения условия не дольше timeout
+func eventually(t *testing.T, timeout time.Duration, msg string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Errorf("%s: not done in %v", msg, timeout)
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// expired ключ удалён по сроку жизни
+func expired(s structs.Storage, key string) func() bool {
+	return func() bool {
+		_, err := s.GetElement(key)
+		return errors.Is(err, structs.ErrKeyNotFound)
+	}
+}
+
+func testEmpty(t *testing.T, s structs.Storage) {
+	if keys := s.GetKeys(); len(keys) != 0 {
+		t.Errorf("GetKeys() = %q, want no keys", keys)
+	}
+	checkMissing(t, s, "missing")
+}
+
+func testString(t *testing.T, s structs.Storage) {
+	previousVal, isUpdated := s.PutOrUpdateString("str", "first")
+	if previousVal != "" || isUpdated {
+		t.Errorf("PutOrUpdateString() new key = %q, %v, want \"\", false", previousVal, isUpdated)
+	}
+	checkValue(t, s, "str", "first")
+	checkType(t, s, "str", structs.String)
+
+	previousVal, isUpdated = s.PutOrUpdateString("str", "second")
+	if previousVal != "first" || !isUpdated {
+		t.Errorf("PutOrUpdateString() existing key = %q, %v, want \"first\", true", previousVal, isUpdated)
+	}
+	checkValue(t, s, "str", "second")
+}
+
+func testList(t *testing.T, s structs.Storage) {
+	previousVal, isUpdated := s.PutOrUpdateList("list", []string{"b", "a", "c"})
+	if len(previousVal) != 0 || isUpdated {
+		t.Errorf("PutOrUpdateList() new key = %q, %v, want no value, false", previousVal, isUpdated)
+	}
+	checkValue(t, s, "list", []string{"b", "a", "c"})
+	checkType(t, s, "list", structs.List)
+
+	tests := []struct {
+		index   int
+		want    string
+		wantErr error
+	}{
+		{index: 0, want: "b"},
+		{index: 2, want: "c"},
+		{index: -1, wantErr: structs.ErrIndexOutOfRange},
+		{index: 3, wantErr: structs.ErrIndexOutOfRange},
+	}
+	for _, tt := range tests {
+		got, err := s.GetListElement("list", tt.index)
+		checkErr(t, fmt.Sprintf("GetListElement(%d)", tt.index), err, tt.wantErr)
+		if got != tt.want {
+			t.Errorf("GetListElement(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+	}
+
+	previousVal, isUpdated = s.PutOrUpdateList("list", []string{"d"})
+	if !reflect.DeepEqual(previousVal, []string{"b", "a", "c"}) || !isUpdated {
+		t.Errorf("PutOrUpdateList() existing key = %q, %v, want [b a c], true", previousVal, isUpdated)
+	}
+	checkValue(t, s, "list", []string{"d"})
+}
+
+func testDictionary(t *testing.T, s structs.Storage) {
+	first := map[string]string{"k1": "v1", "k2": "v2"}
+	previousVal, isUpdated := s.PutOrUpdateDictionary("dict", map[string]string{"k1": "v1", "k2": "v2"})
+	if len(previousVal) != 0 || isUpdated {
+		t.Errorf("PutOrUpdateDictionary() new key = %v, %v, want no value, false", previousVal, isUpdated)
+	}
+	checkValue(t, s, "dict", first)
+	checkType(t, s, "dict", structs.Dictionary)
+
+	got, err := s.GetDictionaryElement("dict", "k2")
+	if err != nil || got != "v2" {
+		t.Errorf("GetDictionaryElement(k2) = %q, %v, want \"v2\"", got, err)
+	}
+	_, err = s.GetDictionaryElement("dict", "missing")
+	checkErr(t, "GetDictionaryElement(missing)", err, structs.ErrKeyNotFound)
+
+	previousVal, isUpdated = s.PutOrUpdateDictionary("dict", map[string]string{"k3": "v3"})
+	if !reflect.DeepEqual(previousVal, first) || !isUpdated {
+		t.Errorf("PutOrUpdateDictionary() existing key = %v, %v, want %v, true", previousVal, isUpdated, first)
+	}
+	checkValue(t, s, "dict", map[string]string{"k3": "v3"})
+}
+
+func testEmptyValues(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("str", "")
+	s.PutOrUpdateList("list", []string{})
+	s.PutOrUpdateDictionary("dict", map[string]string{})
+
+	checkValue(t, s, "str", "")
+	checkType(t, s, "list", structs.List)
+	checkType(t, s, "dict", structs.Dictionary)
+	_, err := s.GetListElement("list", 0)
+	checkErr(t, "GetListElement(empty list)", err, structs.ErrIndexOutOfRange)
+	_, err = s.GetDictionaryElement("dict", "k")
+	checkErr(t, "GetDictionaryElement(empty dictionary)", err, structs.ErrKeyNotFound)
+	checkKeys(t, s, "str", "list", "dict")
+}
+
+func testMissingKeys(t *testing.T, s structs.Storage) {
+	_, err := s.GetListElement("missing", 0)
+	checkErr(t, "GetListElement()", err, structs.ErrKeyNotFound)
+	_, err = s.GetDictionaryElement("missing", "k")
+	checkErr(t, "GetDictionaryElement()", err, structs.ErrKeyNotFound)
+	checkMissing(t, s, "missing")
+}
+
+func testTypeErrors(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("str", "value")
+	s.PutOrUpdateList("list", []string{"a"})
+	s.PutOrUpdateDictionary("dict", map[string]string{"0": "a"})
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{name: "GetListElement(string)", run: func() error { _, err := s.GetListElement("str", 0); return err }},
+		{name: "GetListElement(dictionary)", run: func() error { _, err := s.GetListElement("dict", 0); return err }},
+		{name: "GetDictionaryElement(string)", run: func() error { _, err := s.GetDictionaryElement("str", "0"); return err }},
+		{name: "GetDictionaryElement(list)", run: func() error { _, err := s.GetDictionaryElement("list", "0"); return err }},
+	}
+	for _, tt := range tests {
+		checkErr(t, tt.name, tt.run(), structs.ErrType)
+	}
+}
+
+func testOverwrite(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("key", "value")
+
+	// Значение другого типа заменяется, предыдущее значение - нулевое значение нового типа
+	previousList, isUpdated := s.PutOrUpdateList("key", []string{"a"})
+	if len(previousList) != 0 || !isUpdated {
+		t.Errorf("PutOrUpdateList() over string = %q, %v, want no value, true", previousList, isUpdated)
+	}
+	checkType(t, s, "key", structs.List)
+
+	previousDict, isUpdated := s.PutOrUpdateDictionary("key", map[string]string{"k": "v"})
+	if len(previousDict) != 0 || !isUpdated {
+		t.Errorf("PutOrUpdateDictionary() over list = %v, %v, want no value, true", previousDict, isUpdated)
+	}
+	checkType(t, s, "key", structs.Dictionary)
+
+	previousStr, isUpdated := s.PutOrUpdateString("key", "value")
+	if previousStr != "" || !isUpdated {
+		t.Errorf("PutOrUpdateString() over dictionary = %q, %v, want \"\", true", previousStr, isUpdated)
+	}
+	checkValue(t, s, "key", "value")
+	checkKeys(t, s, "key")
+}
+
+func testRemove(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("str", "value")
+	s.PutOrUpdateList("list", []string{"a"})
+	s.RemoveElement("str")
+	s.RemoveElement("missing")
+
+	checkMissing(t, s, "str")
+	checkValue(t, s, "list", []string{"a"})
+	checkKeys(t, s, "list")
+
+	// Удалённый ключ создаётся заново
+	previousVal, isUpdated := s.PutOrUpdateString("str", "again")
+	if previousVal != "" || isUpdated {
+		t.Errorf("PutOrUpdateString() removed key = %q, %v, want \"\", false", previousVal, isUpdated)
+	}
+}
+
+func testKeys(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("a", "1")
+	s.PutOrUpdateList("b", []string{"1"})
+	s.PutOrUpdateDictionary("c", map[string]string{"1": "1"})
+	s.PutOrUpdateString("a", "2")
+	checkKeys(t, s, "a", "b", "c")
+
+	// Изменение полученного списка не меняет хранилище
+	keys := s.GetKeys()
+	keys[0] = "changed"
+	checkKeys(t, s, "a", "b", "c")
+}
+
+func testExpiry(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("short", "value")
+	s.PutOrUpdateList("list", []string{"a"})
+	s.PutOrUpdateString("long", "value")
+	s.SetExpired("short", 30)
+	s.SetExpired("list", 30)
+	s.SetExpired("long", uint64(time.Hour/time.Millisecond))
+
+	eventually(t, expiryTimeout, "string expiry", expired(s, "short"))
+	eventually(t, expiryTimeout, "list expiry", expired(s, "list"))
+	checkMissing(t, s, "short")
+	checkValue(t, s, "long", "value")
+	checkKeys(t, s, "long")
+}
+
+func testDeprecatedTTL(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("key", "value")
+	s.SetTTL("key", 30)
+	eventually(t, expiryTimeout, "ttl expiry", expired(s, "key"))
+}
+
+func testZeroTTL(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("key", "value")
+	s.SetExpired("key", 0)
+	time.Sleep(100 * time.Millisecond)
+	checkValue(t, s, "key", "value")
+}
+
+func testMissingKeyTTL(t *testing.T, s structs.Storage) {
+	s.SetExpired("key", 30)
+	s.PutOrUpdateString("key", "value")
+	time.Sleep(150 * time.Millisecond)
+	checkValue(t, s, "key", "value")
+}
+
+func testRemoveClearsTTL(t *testing.T, s structs.Storage) {
+	s.PutOrUpdateString("key", "value")
+	s.SetExpired("key", 30)
+	s.RemoveElement("key")
+	s.PutOrUpdateString("key", "again")
+	time.Sleep(150 * time.Millisecond)
+	checkValue(t, s, "key", "again")
+}
+
+func testContext(t *testing.T, s structs.Storage) {
+	cs := structs.WithContext(s)
+	s.PutOrUpdateString("key", "value")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		run  func() error
+	}{
+		{name: "GetKeysContext", run: func() error { _, err := cs.GetKeysContext(ctx); return err }},
+		{name: "GetElementContext", run: func() error { _, err := cs.GetElementContext(ctx, "key"); return err }},
+		{name: "GetTypeContext", run: func() error { _, err := cs.GetTypeContext(ctx, "key"); return err }},
+		{name: "PutOrUpdateStringContext", run: func() error {
+			_, _, err := cs.PutOrUpdateStringContext(ctx, "key", "changed")
+			return err
+		}},
+		{name: "PutOrUpdateListContext", run: func() error {
+			_, _, err := cs.PutOrUpdateListContext(ctx, "new", []string{"a"})
+			return err
+		}},
+		{name: "RemoveElementContext", run: func() error { return cs.RemoveElementContext(ctx, "key") }},
+		{name: "SetExpiredContext", run: func() error { return cs.SetExpiredContext(ctx, "key", 1) }},
+	}
+	for _, tt := range tests {
+		checkErr(t, tt.name+"(canceled)", tt.run(), context.Canceled)
+	}
+	// Отменённые операции не меняют данные
+	time.Sleep(50 * time.Millisecond)
+	checkValue(t, s, "key", "value")
+	checkKeys(t, s, "key")
+
+	val, err := cs.GetElementContext(context.Background(), "key")
+	if err != nil || val != "value" {
+		t.Errorf("GetElementContext() = %v, %v, want value", val, err)
+	}
+}
+
+func testConcurrency(t *testing.T, s structs.Storage) {
+	const (
+		writers = 8
+		keys    = 100
+	)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				s.PutOrUpdateString(fmt.Sprintf("w%d-%d", w, i), fmt.Sprint(i))
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				if val, err := s.GetElement(fmt.Sprintf("w%d-%d", w, i)); err == nil && val != fmt.Sprint(i) {
+					t.Errorf("GetElement() = %v during writes, want %d", val, i)
+				}
+				s.GetKeys()
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got := len(s.GetKeys()); got != writers*keys {
+		t.Errorf("len(GetKeys()) = %d, want %d", got, writers*keys)
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keys; i++ {
+			checkValue(t, s, fmt.Sprintf("w%d-%d", w, i), fmt.Sprint(i))
+		}
+	}
+}
+
+func testNoAliasing(t *testing.T, s structs.Storage) {
+	list := []string{"b", "a"}
+	dict := map[string]string{"k": "v"}
+	s.PutOrUpdateList("list", list)
+	s.PutOrUpdateDictionary("dict", dict)
+
+	// Изменение переданных значений не меняет хранилище
+	list[0] = "changed"
+	dict["k"] = "changed"
+	checkValue(t, s, "list", []string{"b", "a"})
+	checkValue(t, s, "dict", map[string]string{"k": "v"})
+
+	// Изменение полученных значений не меняет хранилище
+	if got, err := s.GetElement("list"); err == nil {
+		got.([]string)[0] = "changed"
+	}
+	if got, err := s.GetElement("dict"); err == nil {
+		got.(map[string]string)["k"] = "changed"
+		got.(map[string]string)["new"] = "added"
+	}
+	checkValue(t, s, "list", []string{"b", "a"})
+	checkValue(t, s, "dict", map[string]string{"k": "v"})
+
+	// Полученные значения изменяются одновременно с чтением: с -race общее значение - гонка
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got, err := s.GetElement("list"); err == nil {
+					list := got.([]string)
+					list[0], list[1] = list[1], list[0]
+				}
+				if got, err := s.GetElement("dict"); err == nil {
+					got.(map[string]string)["k"] = fmt.Sprint(j)
+				}
+				s.GetListElement("list", 0)
+				s.GetDictionaryElement("dict", "k")
+			}
+		}()
+	}
+	wg.Wait()
+	checkValue(t, s, "list", []string{"b", "a"})
+	checkValue(t, s, "dict", map[string]string{"k": "v"})
+}
+
+func testStreams(t *testing.T, s structs.Storage) {
+	streams, ok := s.(structs.StreamStorage)
+	if !ok {
+		t.Skip("the storage doesn't implement structs.StreamStorage")
+	}
+	if _, err := streams.StreamAdd("events", "1-1", map[string]string{"f": "v"}); err != nil {
+		t.Fatalf("StreamAdd() error = %v", err)
+	}
+	_, err := streams.StreamAdd("events", "1-1", map[string]string{"f": "v"})
+	checkErr(t, "StreamAdd(same id)", err, structs.ErrStreamIDTooSmall)
+	if n, err := streams.StreamLen("events"); err != nil || n != 1 {
+		t.Errorf("StreamLen() = %d, %v, want 1", n, err)
+	}
+	entries, err := streams.StreamRange("events", "-", "+", 0)
+	if err != nil || len(entries) != 1 || entries[0].Fields["f"] != "v" {
+		t.Errorf("StreamRange() = %v, %v, want one entry", entries, err)
+	}
+	checkType(t, s, "events", structs.Stream)
+
+	// Изменение переданных и полученных полей записи не меняет поток
+	fields := map[string]string{"f": "v"}
+	if _, err := streams.StreamAdd("events", "1-2", fields); err != nil {
+		t.Fatalf("StreamAdd() error = %v", err)
+	}
+	fields["f"] = "changed"
+	entries[0].Fields["f"] = "changed"
+	entries, err = streams.StreamRange("events", "-", "+", 0)
+	if err != nil || len(entries) != 2 || entries[0].Fields["f"] != "v" || entries[1].Fields["f"] != "v" {
+		t.Errorf("StreamRange() = %v, %v, want two unchanged entries", entries, err)
+	}
+
+	_, err = s.GetElement("events")
+	checkErr(t, "GetElement(stream)", err, structs.ErrType)
+
+	s.PutOrUpdateString("str", "value")
+	_, err = streams.StreamAdd("str", "*", map[string]string{"f": "v"})
+	checkErr(t, "StreamAdd(string)", err, structs.ErrType)
+
+	s.RemoveElement("events")
+	checkMissing(t, s, "events")
+}
@@ -0,0 +1,111 @@
+package storagetest
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// stressKeys количество общих ключей, над которыми одновременно работают горутины Stress
+const stressKeys = 16
+
+// Stress одновременные операции всех видов над общими ключами. Тест рассчитан на запуск с -race:
+// гонки данных находит детектор, а сам тест проверяет, что операции возвращают только допустимые
+// ошибки и значения, и что последняя запись каждой горутины в свой ключ не теряется
+func Stress(t *testing.T, newStorage Factory) {
+	s := open(t, newStorage)
+	workers, ops := 8, 5000
+	if testing.Short() {
+		ops = 500
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(int64(w)))
+			own := fmt.Sprintf("worker-%d", w)
+			for i := 0; i < ops; i++ {
+				if err := stressOp(s, rnd, fmt.Sprintf("key-%d", rnd.Intn(stressKeys))); err != nil {
+					t.Errorf("worker %d: %v", w, err)
+					return
+				}
+				s.PutOrUpdateString(own, fmt.Sprint(i))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < workers; w++ {
+		checkValue(t, s, fmt.Sprintf("worker-%d", w), fmt.Sprint(ops-1))
+	}
+	for _, key := range s.GetKeys() {
+		if _, err := s.GetType(key); err != nil && !errors.Is(err, structs.ErrKeyNotFound) {
+			t.Errorf("GetType(%q) error = %v", key, err)
+		}
+	}
+}
+
+// stressOp случайная операция над ключом key. Значение ключа могут в это время изменить другие
+// горутины, поэтому отсутствие ключа и другой тип значения - допустимые ошибки
+func stressOp(s structs.Storage, rnd *rand.Rand, key string) error {
+	var err error
+	switch rnd.Intn(11) {
+	case 0:
+		s.PutOrUpdateString(key, "value")
+	case 1:
+		s.PutOrUpdateList(key, []string{"c", "a", "b"})
+	case 2:
+		s.PutOrUpdateDictionary(key, map[string]string{"k": "v"})
+	case 3:
+		var val interface{}
+		if val, err = s.GetElement(key); err == nil {
+			// Полученное значение принадлежит вызывающему коду
+			switch v := val.(type) {
+			case string:
+			case []string:
+				for i := range v {
+					v[i] = "changed"
+				}
+			case map[string]string:
+				v["changed"] = "changed"
+			default:
+				return fmt.Errorf("GetElement(%q) = %#v", key, val)
+			}
+		}
+	case 4:
+		_, err = s.GetListElement(key, rnd.Intn(4))
+	case 5:
+		_, err = s.GetDictionaryElement(key, "k")
+	case 6:
+		_, err = s.GetType(key)
+	case 7:
+		s.RemoveElement(key)
+	case 8:
+		s.SetExpired(key, uint64(1+rnd.Intn(20)))
+	case 9:
+		for _, k := range s.GetKeys() {
+			if k == "" {
+				return errors.New("GetKeys() returned an empty key")
+			}
+		}
+	case 10:
+		if streams, ok := s.(structs.StreamStorage); ok {
+			if _, err = streams.StreamAdd(key, "*", map[string]string{"f": "v"}); err == nil {
+				_, err = streams.StreamRange(key, "-", "+", 10)
+			}
+		}
+	}
+	switch {
+	case err == nil, errors.Is(err, structs.ErrKeyNotFound), errors.Is(err, structs.ErrType),
+		errors.Is(err, structs.ErrIndexOutOfRange), errors.Is(err, structs.ErrNoGroup):
+		return nil
+	default:
+		return fmt.Errorf("unexpected error: %w", err)
+	}
+}
@@ -0,0 +1,159 @@
+package structs
+
+import (
+	"context"
+	"time"
+)
+
+// ContextStorage вариант Storage, операции которого прерываются отменой или истечением контекста:
+// ожидание блокировки, обход ключей. Прерванная операция возвращает ctx.Err() и не меняет данные
+type ContextStorage interface {
+	GetKeysContext(ctx context.Context) ([]string, error)
+	GetElementContext(ctx context.Context, key string) (interface{}, error)
+	GetListElementContext(ctx context.Context, key string, index int) (string, error)
+	GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error)
+
+	PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error)
+	PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error)
+	PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error)
+
+	RemoveElementContext(ctx context.Context, key string) error
+
+	SetExpiredContext(ctx context.Context, key string, expired uint64) error
+	GetTypeContext(ctx context.Context, key string) (ValueType, error)
+}
+
+// WithContext хранилище s с операциями ContextStorage. Если s не реализует ContextStorage,
+// контекст проверяется только перед вызовом операции. Выборка диапазона (RangeStorage) сохраняется
+func WithContext(s Storage) ContextStorage {
+	if cs, ok := s.(ContextStorage); ok {
+		return cs
+	}
+	if rs, ok := s.(RangeStorage); ok {
+		return rangeContextStorage{contextStorage{s}, rs}
+	}
+	return contextStorage{s}
+}
+
+type rangeContextStorage struct {
+	contextStorage
+	RangeStorage
+}
+
+type contextStorage struct {
+	s Storage
+}
+
+func (c contextStorage) GetKeysContext(ctx context.Context) ([]string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.s.GetKeys(), nil
+}
+
+func (c contextStorage) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.s.GetElement(key)
+}
+
+func (c contextStorage) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.s.GetListElement(key, index)
+}
+
+func (c contextStorage) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return c.s.GetDictionaryElement(key, internalKey)
+}
+
+func (c contextStorage) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+	previousVal, isUpdated := c.s.PutOrUpdateString(key, value)
+	return previousVal, isUpdated, nil
+}
+
+func (c contextStorage) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	previousVal, isUpdated := c.s.PutOrUpdateList(key, value)
+	return previousVal, isUpdated, nil
+}
+
+func (c contextStorage) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, false, err
+	}
+	previousVal, isUpdated := c.s.PutOrUpdateDictionary(key, value)
+	return previousVal, isUpdated, nil
+}
+
+func (c contextStorage) RemoveElementContext(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.s.RemoveElement(key)
+	return nil
+}
+
+func (c contextStorage) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.s.SetExpired(key, expired)
+	return nil
+}
+
+func (c contextStorage) GetTypeContext(ctx context.Context, key string) (ValueType, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.s.GetType(key)
+}
+
+// ContextLoader хранилище, загрузка снимка в которое прерывается отменой контекста.
+// Записи, загруженные до отмены, остаются в хранилище
+type ContextLoader interface {
+	LoadContext(ctx context.Context, entries []Entry) error
+}
+
+// LoadContext загрузка записей снимка в s с отменой по ctx, если s реализует ContextLoader
+func LoadContext(ctx context.Context, s Snapshotter, entries []Entry) error {
+	if l, ok := s.(ContextLoader); ok {
+		return l.LoadContext(ctx, entries)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.Load(entries)
+	return nil
+}
+
+// ContextStreamReader потоки, блокирующее чтение которых прерывается отменой контекста
+type ContextStreamReader interface {
+	StreamReadContext(ctx context.Context, keys, ids []string, count int, block time.Duration) (map[string][]StreamEntry, error)
+	StreamReadGroupContext(ctx context.Context, group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]StreamEntry, error)
+}
+
+type lockWaitKey struct{}
+
+// WithLockWait контекст операций, которым хранилище сообщает время ожидания блокировки,
+// например для трассировки
+func WithLockWait(ctx context.Context, report func(wait time.Duration)) context.Context {
+	return context.WithValue(ctx, lockWaitKey{}, report)
+}
+
+// ReportLockWait передача времени ожидания блокировки получателю из контекста, если он задан
+func ReportLockWait(ctx context.Context, wait time.Duration) {
+	if report, ok := ctx.Value(lockWaitKey{}).(func(time.Duration)); ok {
+		report(wait)
+	}
+}
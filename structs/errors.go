@@ -1 +1,28 @@
 package structs
+
+import "errors"
+
+var (
+	ErrKeyNotFound     = errors.New("key not found")
+	ErrIndexOutOfRange = errors.New("index out of range")
+	ErrType            = errors.New("something wrong: type error")
+	ErrNotSupported    = errors.New("operation is not supported by storage")
+	ErrReadOnly        = errors.New("you can't write against a read only replica")
+	ErrWriteDisabled   = errors.New("READONLY writes are disabled on this server")
+
+	ErrInvalidStreamID  = errors.New("invalid stream ID specified as stream command argument")
+	ErrStreamIDTooSmall = errors.New("the ID specified in XADD is equal or smaller than the target stream top item")
+	ErrNoGroup          = errors.New("no such key or consumer group")
+	ErrStreamArgs       = errors.New("unbalanced list of streams and IDs")
+	ErrGroupExists      = errors.New("consumer group name already exists")
+	ErrClusterDown      = errors.New("CLUSTERDOWN hash slot is not served")
+	ErrBusyKey          = errors.New("BUSYKEY target key name already exists")
+	ErrStorageFull      = errors.New("OOM maximum number of keys reached")
+	ErrValueTooLarge    = errors.New("value is too large")
+
+	ErrNoAuth    = errors.New("NOAUTH authentication required")
+	ErrWrongPass = errors.New("WRONGPASS invalid username-password pair")
+	ErrNoPerm    = errors.New("NOPERM this user has no permissions to run this command or access this key")
+	ErrNoUser    = errors.New("no such user")
+	ErrBadToken  = errors.New("invalid token")
+)
@@ -0,0 +1,58 @@
+package structs
+
+import (
+	"context"
+	"fmt"
+)
+
+// Guard проверка возможности выполнить операцию над ключом на этом узле (например, запрет записи на реплике).
+// Для операций над всем пространством ключей передаётся пустой ключ
+type Guard func(ctx context.Context, key string, write bool) error
+
+// ChainGuards проверка всеми guards по очереди до первой ошибки. Пустые проверки пропускаются
+func ChainGuards(guards ...Guard) Guard {
+	return func(ctx context.Context, key string, write bool) error {
+		for _, guard := range guards {
+			if guard == nil {
+				continue
+			}
+			if err := guard(ctx, key, write); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// RedirectError ключ обслуживается другим узлом кластера
+type RedirectError struct {
+	// Ask временное перенаправление на время миграции слота: клиент повторяет только этот запрос,
+	// предварив его командой ASKING (HTTP заголовком X-Asking)
+	Ask  bool
+	Slot int
+	// Addr TCP адрес узла
+	Addr string
+	// HTTPAddr HTTP адрес узла, может быть пустым
+	HTTPAddr string
+}
+
+func (e *RedirectError) Error() string {
+	kind := "MOVED"
+	if e.Ask {
+		kind = "ASK"
+	}
+	return fmt.Sprintf("%s %d %s", kind, e.Slot, e.Addr)
+}
+
+type askingKey struct{}
+
+// WithAsking контекст запроса, которому предшествовала команда ASKING
+func WithAsking(ctx context.Context) context.Context {
+	return context.WithValue(ctx, askingKey{}, true)
+}
+
+// IsAsking предшествовала ли запросу команда ASKING
+func IsAsking(ctx context.Context) bool {
+	asking, _ := ctx.Value(askingKey{}).(bool)
+	return asking
+}
@@ -0,0 +1,59 @@
+package structs
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+// KeyRange выборка ключей по возрастанию: ключи с префиксом Prefix не меньше Start и меньше End.
+// Пустые Start и End не ограничивают диапазон, Limit - наибольшее количество ключей (0 - без ограничения)
+type KeyRange struct {
+	Prefix, Start, End string
+	Limit              int
+}
+
+// Contains входит ли ключ в диапазон без учёта Limit
+func (r KeyRange) Contains(key string) bool {
+	return strings.HasPrefix(key, r.Prefix) && key >= r.Start && (r.End == "" || key < r.End)
+}
+
+// From первый ключ, с которого начинается обход упорядоченных ключей
+func (r KeyRange) From() string {
+	if r.Start > r.Prefix {
+		return r.Start
+	}
+	return r.Prefix
+}
+
+// Done завершён ли обход упорядоченных ключей на ключе key: следующие ключи не входят в диапазон
+func (r KeyRange) Done(key string) bool {
+	return (r.End != "" && key >= r.End) || (key > r.Prefix && !strings.HasPrefix(key, r.Prefix))
+}
+
+// RangeStorage хранилище с упорядоченными ключами, выбирающее диапазон без обхода всех ключей
+type RangeStorage interface {
+	KeysRangeContext(ctx context.Context, r KeyRange) ([]string, error)
+}
+
+// KeysRange ключи диапазона r. Для хранилища без RangeStorage диапазон выбирается из всех ключей
+func KeysRange(ctx context.Context, s ContextStorage, r KeyRange) ([]string, error) {
+	if rs, ok := s.(RangeStorage); ok {
+		return rs.KeysRangeContext(ctx, r)
+	}
+	keys, err := s.GetKeysContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0)
+	for _, key := range keys {
+		if r.Contains(key) {
+			result = append(result, key)
+		}
+	}
+	sort.Strings(result)
+	if r.Limit > 0 && len(result) > r.Limit {
+		result = result[:r.Limit]
+	}
+	return result, nil
+}
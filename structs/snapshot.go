@@ -0,0 +1,141 @@
+package structs
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Entry ключ хранилища вместе со значением и временем истечения (UnixNano, 0 - без ограничения).
+// Groups группы потребителей потока
+type Entry struct {
+	Key     string        `json:"key"`
+	Type    ValueType     `json:"type"`
+	Value   interface{}   `json:"value"`
+	Expired uint64        `json:"expired,omitempty"`
+	Groups  []StreamGroup `json:"groups,omitempty"`
+}
+
+// UnmarshalJSON восстановление значения в соответствии с его типом
+func (e *Entry) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Key     string          `json:"key"`
+		Type    ValueType       `json:"type"`
+		Value   json.RawMessage `json:"value"`
+		Expired uint64          `json:"expired,omitempty"`
+		Groups  []StreamGroup   `json:"groups,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	var err error
+	switch raw.Type {
+	case String:
+		var v string
+		err = json.Unmarshal(raw.Value, &v)
+		e.Value = v
+	case List:
+		var v []string
+		err = json.Unmarshal(raw.Value, &v)
+		e.Value = v
+	case Dictionary:
+		var v map[string]string
+		err = json.Unmarshal(raw.Value, &v)
+		e.Value = v
+	case Stream:
+		var v []StreamEntry
+		err = json.Unmarshal(raw.Value, &v)
+		e.Value = v
+	default:
+		return ErrType
+	}
+	if err != nil {
+		return err
+	}
+
+	e.Key, e.Type, e.Expired, e.Groups = raw.Key, raw.Type, raw.Expired, raw.Groups
+	return nil
+}
+
+// Snapshotter хранилище, позволяющее снять полный снимок данных и загрузить его обратно.
+// Значения потоков передаются как []StreamEntry вместе с группами потребителей
+type Snapshotter interface {
+	Dump() []Entry
+	DumpKey(key string) (Entry, error)
+	Load(entries []Entry)
+	Flush()
+	ExpireAt(key string, deadline uint64)
+}
+
+// SaveSnapshot запись снимка хранилища в файл. Файл заменяется атомарно:
+// снимок пишется во временный файл рядом и переименовывается
+func SaveSnapshot(path string, s Snapshotter) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	if err := json.NewEncoder(w).Encode(s.Dump()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// LoadSnapshot загрузка снимка из файла. Отсутствие файла не считается ошибкой
+func LoadSnapshot(path string, s Snapshotter) error {
+	return LoadSnapshotContext(context.Background(), path, s)
+}
+
+// LoadSnapshotContext загрузка снимка из файла, чтение и загрузка которого прерываются отменой ctx
+func LoadSnapshotContext(ctx context.Context, path string, s Snapshotter) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	if err := json.NewDecoder(bufio.NewReader(contextReader{ctx, f})).Decode(&entries); err != nil {
+		return fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	if err := LoadContext(ctx, s, entries); err != nil {
+		return fmt.Errorf("snapshot %s: %w", path, err)
+	}
+	return nil
+}
+
+// contextReader чтение, прерываемое отменой ctx
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
@@ -0,0 +1,134 @@
+package structs
+
+import (
+	"context"
+	"time"
+)
+
+// Stats статистика хранилища
+type Stats struct {
+	// Keys количество ключей по типам значений
+	Keys map[ValueType]int
+	// KeysWithTTL количество ключей со сроком жизни
+	KeysWithTTL int
+	// ExpiredKeys ключи, удалённые по истечении срока жизни, с момента запуска
+	ExpiredKeys uint64
+	// EvictedKeys ключи, вытесненные при нехватке места, с момента запуска
+	EvictedKeys uint64
+	// KeyspaceHits, KeyspaceMisses чтения существующих и отсутствующих ключей с момента запуска
+	KeyspaceHits, KeyspaceMisses uint64
+	// MemoryBytes оценка памяти, занятой ключами и значениями
+	MemoryBytes int64
+}
+
+// StatsStorage хранилище, сообщающее статистику
+type StatsStorage interface {
+	Stats() Stats
+}
+
+// StorageObserver получатель событий хранилища, например для метрик. Вызывается синхронно
+// в операциях хранилища и должен выполняться быстро
+type StorageObserver interface {
+	// LockWait ожидание блокировки хранилища, write - блокировка на запись
+	LockWait(d time.Duration, write bool)
+	// JanitorRun длительность фонового удаления просроченных ключей
+	JanitorRun(d time.Duration)
+}
+
+// TierStats статистика вынесения значений из памяти на диск
+type TierStats struct {
+	// MemoryKeys, DiskKeys ключи со значением в памяти и на диске. Потоки не учитываются
+	MemoryKeys int `json:"memory_keys"`
+	DiskKeys   int `json:"disk_keys"`
+	// MemoryBytes, DiskBytes оценка размера ключей и значений в памяти и на диске
+	MemoryBytes int64 `json:"memory_bytes"`
+	DiskBytes   int64 `json:"disk_bytes"`
+	// MaxMemory размер значений в памяти, сверх которого давно не читанные значения выносятся на диск
+	MaxMemory int64 `json:"max_memory"`
+	// PinnedKeys ключи, значения которых не выносятся на диск
+	PinnedKeys int `json:"pinned_keys"`
+	// Spills, Faults значения, вынесенные на диск и возвращённые в память, с момента запуска
+	Spills uint64 `json:"spills"`
+	Faults uint64 `json:"faults"`
+}
+
+// TieredStorage хранилище, выносящее давно не читанные значения на диск. Ключи, типы и сроки жизни
+// остаются в памяти, значение возвращается в память при чтении
+type TieredStorage interface {
+	// TierStats статистика, false - диск не используется
+	TierStats() (TierStats, bool)
+	// Pin закрепление значения ключа в памяти, значение на диске возвращается в память
+	Pin(key string) error
+	// Unpin снятие закрепления
+	Unpin(key string) error
+}
+
+// ContextTieredStorage закрепление ключей с контекстом операции: пользователь запроса проверяется
+// обёртками хранилища
+type ContextTieredStorage interface {
+	PinContext(ctx context.Context, key string) error
+	UnpinContext(ctx context.Context, key string) error
+}
+
+// Pin закрепление значения ключа в памяти, с контекстом операции для ContextTieredStorage
+func Pin(ctx context.Context, t TieredStorage, key string) error {
+	if ct, ok := t.(ContextTieredStorage); ok {
+		return ct.PinContext(ctx, key)
+	}
+	return t.Pin(key)
+}
+
+// Unpin снятие закрепления, с контекстом операции для ContextTieredStorage
+func Unpin(ctx context.Context, t TieredStorage, key string) error {
+	if ct, ok := t.(ContextTieredStorage); ok {
+		return ct.UnpinContext(ctx, key)
+	}
+	return t.Unpin(key)
+}
+
+// Кодировки значения в KeyInfo
+const (
+	EncodingRaw     = "raw"
+	EncodingDeflate = "deflate"
+)
+
+// KeyInfo представление значения ключа в хранилище
+type KeyInfo struct {
+	Type ValueType
+	// Encoding кодировка значения: EncodingRaw или EncodingDeflate
+	Encoding string
+	// Size, StoredSize оценка размера значения и размер хранимого (сжатого) представления
+	Size       int64
+	StoredSize int64
+	// OnDisk значение вынесено на диск, Pinned - закреплено в памяти (structs.TieredStorage)
+	OnDisk bool
+	Pinned bool
+}
+
+// Ratio степень сжатия: размер значения к размеру хранимого представления
+func (i KeyInfo) Ratio() float64 {
+	if i.StoredSize == 0 {
+		return 1
+	}
+	return float64(i.Size) / float64(i.StoredSize)
+}
+
+// InspectStorage хранилище со сведениями о представлении значения ключа
+type InspectStorage interface {
+	// Inspect сведения о значении key без его чтения и возврата с диска, structs.ErrKeyNotFound - ключа нет
+	Inspect(key string) (KeyInfo, error)
+}
+
+// ContextInspectStorage сведения о значении с контекстом операции: пользователь запроса проверяется
+// обёртками хранилища
+type ContextInspectStorage interface {
+	InspectContext(ctx context.Context, key string) (KeyInfo, error)
+}
+
+// Inspect сведения о значении key, с контекстом операции для ContextInspectStorage
+func Inspect(ctx context.Context, i InspectStorage, key string) (KeyInfo, error) {
+	if ci, ok := i.(ContextInspectStorage); ok {
+		return ci.InspectContext(ctx, key)
+	}
+	return i.Inspect(key)
+}
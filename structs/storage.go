@@ -6,10 +6,11 @@ const (
 	String ValueType = iota
 	List
 	Dictionary
+	Stream
 )
 
 func (t ValueType) String() string {
-	return [...]string{"String", "List", "Dictionary"}[t]
+	return [...]string{"String", "List", "Dictionary", "Stream"}[t]
 }
 
 type Storage interface {
@@ -0,0 +1,137 @@
+package structs
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BlockForever время ожидания для блокирующего чтения потока без таймаута
+const BlockForever = time.Duration(math.MaxInt64)
+
+// StreamID идентификатор записи потока: время добавления в миллисекундах и порядковый номер
+type StreamID struct {
+	Ms  uint64
+	Seq uint64
+}
+
+var (
+	MinStreamID = StreamID{}
+	MaxStreamID = StreamID{Ms: math.MaxUint64, Seq: math.MaxUint64}
+)
+
+func (id StreamID) String() string {
+	return fmt.Sprintf("%d-%d", id.Ms, id.Seq)
+}
+
+// Less сравнение идентификаторов
+func (id StreamID) Less(other StreamID) bool {
+	if id.Ms == other.Ms {
+		return id.Seq < other.Seq
+	}
+	return id.Ms < other.Ms
+}
+
+// ParseStreamID разбор идентификатора вида <ms>-<seq>. Если порядковый номер не указан,
+// то он заменяется на missingSeq
+func ParseStreamID(s string, missingSeq uint64) (StreamID, error) {
+	var (
+		id  StreamID
+		err error
+	)
+	parts := strings.SplitN(s, "-", 2)
+	if id.Ms, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return id, ErrInvalidStreamID
+	}
+	if len(parts) == 1 {
+		id.Seq = missingSeq
+		return id, nil
+	}
+	if id.Seq, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return id, ErrInvalidStreamID
+	}
+	return id, nil
+}
+
+// StreamEntry запись потока
+type StreamEntry struct {
+	ID     StreamID
+	Fields map[string]string
+}
+
+// PendingEntry запись, выданная потребителю группы и ещё не подтверждённая
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveredAt   time.Time
+	DeliveryCount int
+}
+
+// StreamStorage хранилище с поддержкой потоков (append-only журналов) и групп потребителей.
+// Специальные идентификаторы: "*" в StreamAdd, "-" и "+" в StreamRange,
+// "$" в StreamRead и StreamGroupCreate, ">" в StreamReadGroup. StreamAddTrim - StreamAdd и StreamTrim
+// одной операцией, отрицательный maxLen - без удаления записей
+type StreamStorage interface {
+	StreamAdd(key, id string, fields map[string]string) (StreamID, error)
+	StreamAddTrim(key, id string, fields map[string]string, maxLen int) (StreamID, error)
+	StreamRange(key, start, end string, count int) ([]StreamEntry, error)
+	StreamRead(keys, ids []string, count int, block time.Duration) (map[string][]StreamEntry, error)
+	StreamLen(key string) (int, error)
+	StreamTrim(key string, maxLen int) (int, error)
+
+	StreamGroupCreate(key, group, id string, mkStream bool) error
+	StreamGroupDestroy(key, group string) (bool, error)
+	StreamReadGroup(group, consumer string, keys, ids []string, count int, block time.Duration, noAck bool) (map[string][]StreamEntry, error)
+	StreamAck(key, group string, ids []string) (int, error)
+	StreamPending(key, group string) ([]PendingEntry, error)
+	StreamClaim(key, group, consumer string, minIdle time.Duration, ids []string) ([]StreamEntry, error)
+}
+
+// StreamGroupReplayer потоки, изменения групп потребителей которых воспроизводятся одинаково на репликах
+// и узлах Raft: время выдачи записей задаётся явно, а ожидание записей отделено от их выдачи.
+// StreamWaitGroup ожидает не дольше block записей, которые группа ещё не выдавала ни одному потребителю
+type StreamGroupReplayer interface {
+	StreamReadGroupAt(at time.Time, group, consumer string, keys, ids []string, count int, noAck bool) (map[string][]StreamEntry, error)
+	StreamClaimAt(at time.Time, key, group, consumer string, minIdle time.Duration, ids []string) ([]StreamEntry, error)
+	StreamWaitGroup(ctx context.Context, group string, keys []string, block time.Duration) error
+}
+
+// StreamGroup группа потребителей потока в снимке
+type StreamGroup struct {
+	Name          string         `json:"name"`
+	LastDelivered StreamID       `json:"last_delivered"`
+	Pending       []PendingEntry `json:"pending,omitempty"`
+}
+
+// WaitReadGroup блокирующее чтение группы: read выполняет чтение без ожидания и повторяется после
+// появления новых записей, пока не вернёт записи, не истечёт block или не будет отменён ctx.
+// История ожидающих записей (идентификатор, отличный от ">") читается без ожидания
+func WaitReadGroup(ctx context.Context, r StreamGroupReplayer, group string, keys, ids []string, block time.Duration, read func() (map[string][]StreamEntry, error)) (map[string][]StreamEntry, error) {
+	for _, id := range ids {
+		if id != ">" {
+			block = 0
+		}
+	}
+	var deadline time.Time
+	if block > 0 && block != BlockForever {
+		deadline = time.Now().Add(block)
+	}
+
+	for {
+		result, err := read()
+		if err != nil || len(result) > 0 || block <= 0 {
+			return result, err
+		}
+		if !deadline.IsZero() {
+			if block = time.Until(deadline); block <= 0 {
+				return result, nil
+			}
+		}
+		if err := r.StreamWaitGroup(ctx, group, keys, block); err != nil {
+			return nil, err
+		}
+	}
+}
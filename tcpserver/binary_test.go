@@ -0,0 +1,129 @@
+package tcpserver
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+func TestServer_BinaryValues(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	storage.PutOrUpdateList("list", []string{"a\r\nb"})
+	go NewServer("", storage).Serve(lis)
+
+	cn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	w, r := resp.NewRequestWriter(cn), resp.NewResponseReader(cn)
+
+	binary := " lead\x00\r\nbinary\xff trail "
+	tests := []struct {
+		name string
+		cmd  []string
+		// want ответ: строка или элементы массива, пары словаря разворачиваются
+		want []string
+		// wantErr ожидается ошибка
+		wantErr bool
+	}{
+		{name: "set string", cmd: []string{"set", "string", "blob", binary}, want: []string{"OK"}},
+		{name: "get string", cmd: []string{"key", "blob"}, want: []string{binary}},
+		{name: "set empty string", cmd: []string{"set", "string", "empty", ""}, want: []string{"OK"}},
+		{name: "get empty string", cmd: []string{"key", "empty"}, want: []string{""}},
+		{name: "get list element", cmd: []string{"ikey", "list", "0"}, want: []string{"a\r\nb"}},
+		{name: "get list", cmd: []string{"key", "list"}, want: []string{"a\r\nb"}},
+		{name: "split list value", cmd: []string{"set", "list", "words", `{"value":`, `["a", "b"]}`}, want: []string{"OK"}},
+		{name: "set base64 list", cmd: []string{"set", "list", "bytes", `{"value": ["AAE=", "/w=="], "encoding": "base64"}`}, want: []string{"OK"}},
+		{name: "get base64 list", cmd: []string{"key", "bytes"}, want: []string{"\x00\x01", "\xff"}},
+		{name: "set base64 dictionary", cmd: []string{"set", "dictionary", "dict", `{"value": {"/w==": "AAE=", "YQ==": "Yg=="}, "encoding": "base64"}`}, want: []string{"OK"}},
+		{name: "get dictionary", cmd: []string{"key", "dict"}, want: []string{"a", "b", "\xff", "\x00\x01"}},
+		{name: "unknown encoding", cmd: []string{"set", "list", "bytes", `{"value": ["a"], "encoding": "hex"}`}, wantErr: true},
+		{name: "set key with line break", cmd: []string{"set", "string", "new\r\nline", "x"}, want: []string{"OK"}},
+		{name: "keys", cmd: []string{"keys"}, want: []string{"blob", "bytes", "dict", "empty", "list", "new\r\nline", "words"}},
+		// Подсказка в ответе многострочная, ошибка проверяется последней
+		{name: "split string value", cmd: []string{"set", "string", "blob", "hello", "world"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			w.WriteCmdString(tt.cmd[0], tt.cmd[1:]...)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			cn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			typ, err := r.PeekType()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantErr {
+				if typ != resp.TypeError {
+					t.Fatalf("reply type = %v, want error", typ)
+				}
+				if _, err := r.ReadError(); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			got, err := readReply(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.cmd[0] == "keys" {
+				// Порядок ключей не определён
+				sort.Strings(got)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reply = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if val, err := storage.GetElement("blob"); err != nil || val != binary {
+		t.Errorf("GetElement() = %q, %v, want %q", val, err, binary)
+	}
+}
+
+// readReply строка ответа или элементы массива, вложенные массивы разворачиваются
+func readReply(r resp.ResponseReader) ([]string, error) {
+	typ, err := r.PeekType()
+	if err != nil {
+		return nil, err
+	}
+	switch typ {
+	case resp.TypeBulk:
+		s, err := r.ReadBulkString()
+		return []string{s}, err
+	case resp.TypeInline:
+		s, err := r.ReadInlineString()
+		return []string{s}, err
+	case resp.TypeArray:
+		n, err := r.ReadArrayLen()
+		if err != nil {
+			return nil, err
+		}
+		var items []string
+		for i := 0; i < n; i++ {
+			item, err := readReply(r)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item...)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("reply type = %v", typ)
+	}
+}
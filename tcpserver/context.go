@@ -0,0 +1,38 @@
+package tcpserver
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+)
+
+// SetRequestTimeout ограничение времени операций с хранилищем для команды, 0 - без ограничения.
+// Может вызываться во время работы сервера
+func (s *Server) SetRequestTimeout(d time.Duration) {
+	atomic.StoreInt64(&s.requestTimeout, int64(d))
+}
+
+// withContext контекст команды, который отменяется при закрытии соединения, в том числе
+// принудительном при остановке сервера, и ограничен временем SetRequestTimeout
+func (s *Server) withContext(h redeo.HandlerFunc) redeo.HandlerFunc {
+	return func(w resp.ResponseWriter, c *resp.Command) {
+		ctx, cancel := context.WithCancel(c.Context())
+		defer cancel()
+		if client := redeo.GetClient(c.Context()); client != nil {
+			if addr, ok := client.RemoteAddr().(connAddr); ok {
+				stop := context.AfterFunc(addr.c.ctx, cancel)
+				defer stop()
+			}
+		}
+		if timeout := time.Duration(atomic.LoadInt64(&s.requestTimeout)); timeout > 0 {
+			var cancelTimeout context.CancelFunc
+			ctx, cancelTimeout = context.WithTimeout(ctx, timeout)
+			defer cancelTimeout()
+		}
+		c.SetContext(ctx)
+		h(w, c)
+	}
+}
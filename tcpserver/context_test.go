@@ -0,0 +1,99 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+func TestServer_RequestTimeout(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	storage.PutOrUpdateString("a", "value")
+	s := NewServer("", storage)
+	s.SetRequestTimeout(20 * time.Millisecond)
+	go s.Serve(lis)
+
+	tests := []struct {
+		name string
+		cmd  []string
+	}{
+		{name: "keys", cmd: []string{"keys"}},
+		{name: "key", cmd: []string{"key", "a"}},
+		{name: "set", cmd: []string{"set", "string", "a", "new"}},
+		{name: "remove", cmd: []string{"remove", "a"}},
+		{name: "xread block", cmd: []string{"xread", "block", "60000", "streams", "events", "$"}},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			if tt.name != "xread block" {
+				// Хранилище занято дольше времени команды
+				storage.Lock()
+				defer storage.Unlock()
+			}
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cn.Close()
+			w := resp.NewRequestWriter(cn)
+			w.WriteCmdString(tt.cmd[0], tt.cmd[1:]...)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			cn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			r := resp.NewResponseReader(cn)
+			if typ, _ := r.PeekType(); typ != resp.TypeError {
+				t.Fatalf("reply type = %v, want error", typ)
+			}
+			if msg, _ := r.ReadError(); msg != context.DeadlineExceeded.Error() {
+				t.Errorf("error = %q, want %q", msg, context.DeadlineExceeded)
+			}
+		})
+	}
+	if val, err := storage.GetElement("a"); err != nil || val != "value" {
+		t.Errorf("GetElement() = %v, %v, want the value before the timed out commands", val, err)
+	}
+}
+
+func TestServer_ShutdownCancelsCommands(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", mapbased.NewStorage())
+	cancelled := make(chan error, 1)
+	s.Handle("wait", redeo.HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		<-c.Context().Done()
+		cancelled <- c.Context().Err()
+		w.AppendError(c.Context().Err().Error())
+	}))
+	go s.Serve(lis)
+	command(t, lis.Addr().String(), "wait")
+	time.Sleep(50 * time.Millisecond)
+
+	// Принудительное закрытие соединений по истечении ctx отменяет выполняемые команды
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case err := <-cancelled:
+		if err != context.Canceled {
+			t.Errorf("command context error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("command is not cancelled")
+	}
+}
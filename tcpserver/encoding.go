@@ -0,0 +1,64 @@
+package tcpserver
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// encodingBase64 строки списка и словаря в JSON закодированы base64. JSON передаёт только
+// корректный UTF-8, произвольные байты кодируются
+const encodingBase64 = "base64"
+
+// checkEncoding допустимая кодировка строк в JSON: пустая или base64
+func checkEncoding(encoding string) error {
+	if encoding != "" && encoding != encodingBase64 {
+		return fmt.Errorf("encoding: unknown encoding %q", encoding)
+	}
+	return nil
+}
+
+// decodeString строка значения в кодировке encoding
+func decodeString(encoding, s string) (string, error) {
+	if err := checkEncoding(encoding); err != nil || encoding == "" {
+		return s, err
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", fmt.Errorf("value: %v", err)
+	}
+	return string(data), nil
+}
+
+// decodeList элементы списка в кодировке encoding
+func decodeList(encoding string, list []string) ([]string, error) {
+	if err := checkEncoding(encoding); err != nil || encoding == "" {
+		return list, err
+	}
+	decoded := make([]string, len(list))
+	for i, item := range list {
+		val, err := decodeString(encoding, item)
+		if err != nil {
+			return nil, err
+		}
+		decoded[i] = val
+	}
+	return decoded, nil
+}
+
+// decodeDictionary ключи и значения словаря в кодировке encoding
+func decodeDictionary(encoding string, dict map[string]string) (map[string]string, error) {
+	if err := checkEncoding(encoding); err != nil || encoding == "" {
+		return dict, err
+	}
+	decoded := make(map[string]string, len(dict))
+	for k, item := range dict {
+		key, err := decodeString(encoding, k)
+		if err != nil {
+			return nil, err
+		}
+		if decoded[key], err = decodeString(encoding, item); err != nil {
+			return nil, err
+		}
+	}
+	return decoded, nil
+}
@@ -0,0 +1,51 @@
+package tcpserver
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// inspect представление значения ключа парами имя, значение: тип, кодировка, размер значения
+// и хранимого представления, степень сжатия, место хранения (memory, disk) и закрепление в памяти
+func (s *Server) inspect(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	key := c.Arg(0).String()
+	if !s.check(w, c, key, false) {
+		return
+	}
+
+	info, err := structs.Inspect(c.Context(), s.inspector, key)
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	tier, pinned := "memory", int64(0)
+	if info.OnDisk {
+		tier = "disk"
+	}
+	if info.Pinned {
+		pinned = 1
+	}
+	w.AppendArrayLen(14)
+	w.AppendBulkString("type")
+	w.AppendBulkString(strings.ToLower(info.Type.String()))
+	w.AppendBulkString("encoding")
+	w.AppendBulkString(info.Encoding)
+	w.AppendBulkString("size")
+	w.AppendInt(info.Size)
+	w.AppendBulkString("stored_size")
+	w.AppendInt(info.StoredSize)
+	w.AppendBulkString("ratio")
+	w.AppendBulkString(strconv.FormatFloat(info.Ratio(), 'f', 2, 64))
+	w.AppendBulkString("tier")
+	w.AppendBulkString(tier)
+	w.AppendBulkString("pinned")
+	w.AppendInt(pinned)
+}
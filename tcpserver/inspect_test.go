@@ -0,0 +1,95 @@
+package tcpserver
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+func TestServer_Inspect(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	storage.SetCompression(100)
+	storage.PutOrUpdateString("blob", strings.Repeat(`{"a":1}`, 100))
+	storage.PutOrUpdateString("small", "value")
+	srv := NewServer("", storage)
+	srv.SetInspector(storage)
+	go srv.Serve(lis)
+
+	tests := []struct {
+		name string
+		args []string
+		// want ожидаемые поля ответа, nil - ошибка
+		want map[string]string
+	}{
+		{
+			name: "compressed",
+			args: []string{"blob"},
+			want: map[string]string{"type": "string", "encoding": "deflate", "size": "700", "tier": "memory", "pinned": "0"},
+		},
+		{
+			name: "raw",
+			args: []string{"small"},
+			want: map[string]string{"type": "string", "encoding": "raw", "size": "5", "stored_size": "5", "ratio": "1.00"},
+		},
+		{name: "missing key", args: []string{"missing"}},
+		{name: "no key"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cn.Close()
+			w := resp.NewRequestWriter(cn)
+			w.WriteCmdString("inspect", tt.args...)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			cn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			r := resp.NewResponseReader(cn)
+			if tt.want == nil {
+				if typ, _ := r.PeekType(); typ != resp.TypeError {
+					t.Fatalf("reply type = %v, want error", typ)
+				}
+				return
+			}
+			n, err := r.ReadArrayLen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]string)
+			for i := 0; i < n/2; i++ {
+				name, err := r.ReadBulkString()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if typ, _ := r.PeekType(); typ == resp.TypeInt {
+					v, err := r.ReadInt()
+					if err != nil {
+						t.Fatal(err)
+					}
+					got[name] = strconv.FormatInt(v, 10)
+				} else if got[name], err = r.ReadBulkString(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for name, want := range tt.want {
+				if got[name] != want {
+					t.Errorf("%s = %q, want %q", name, got[name], want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package tcpserver
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/btree"
+)
+
+func TestServer_KeysRange(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	storage := btree.NewStorage()
+	defer storage.Close()
+	for _, key := range []string{"user:2", "user:1", "order:1", "user:3", "user:1:session"} {
+		storage.PutOrUpdateString(key, "1")
+	}
+	go NewServer("", storage).Serve(lis)
+
+	tests := []struct {
+		name    string
+		args    []string
+		want    []string
+		wantErr bool
+	}{
+		{name: "all keys", want: []string{"order:1", "user:1", "user:1:session", "user:2", "user:3"}},
+		{name: "prefix", args: []string{"prefix", "user:1"}, want: []string{"user:1", "user:1:session"}},
+		{name: "range", args: []string{"PREFIX", "user:", "start", "user:2", "end", "user:3"}, want: []string{"user:2"}},
+		{name: "limit", args: []string{"prefix", "user:", "limit", "2"}, want: []string{"user:1", "user:1:session"}},
+		{name: "empty range", args: []string{"prefix", "session:"}, want: []string{}},
+		{name: "missing value", args: []string{"prefix"}, wantErr: true},
+		{name: "unknown option", args: []string{"count", "2"}, wantErr: true},
+		{name: "bad limit", args: []string{"limit", "-1"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cn.Close()
+			w := resp.NewRequestWriter(cn)
+			w.WriteCmdString("keysrange", tt.args...)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			cn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			r := resp.NewResponseReader(cn)
+			if tt.wantErr {
+				if typ, _ := r.PeekType(); typ != resp.TypeError {
+					t.Fatalf("reply type = %v, want error", typ)
+				}
+				return
+			}
+			n, err := r.ReadArrayLen()
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys := make([]string, n)
+			for i := range keys {
+				if keys[i], err = r.ReadBulkString(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("keys = %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,152 @@
+package tcpserver
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/info"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tracing"
+)
+
+// sensitiveCommands команды, аргументы которых могут содержать пароли и не попадают в журнал медленных команд
+var sensitiveCommands = map[string]bool{"auth": true, "acl": true, "config": true}
+
+// adminMutations подкоманды команд администрирования, изменяющие пользователей и настройки
+var adminMutations = map[string]map[string]bool{
+	"acl":    {"setuser": true, "deluser": true},
+	"config": {"set": true},
+}
+
+// observe учёт команды в метриках, статистике команд, журнале медленных команд, журнале сервера,
+// журнале аудита и трассировке. Команда получает идентификатор в контексте
+func (s *Server) observe(name string, category auth.Category, h redeo.HandlerFunc) redeo.HandlerFunc {
+	if s.metrics == nil && s.info == nil && s.slowlog == nil && s.log == nil && s.audit == nil && s.tracer == nil {
+		return h
+	}
+	return func(w resp.ResponseWriter, c *resp.Command) {
+		audited := false
+		if s.log != nil || s.audit != nil {
+			ctx := logging.WithRequestID(c.Context(), logging.NewRequestID())
+			if s.audit != nil && mutating(name, category, c) {
+				ctx = logging.WithAuditKey(ctx)
+				if name == "acl" || name == "config" {
+					// Ключ операции администрирования - имя пользователя или параметра
+					logging.SetAuditKey(ctx, c.Arg(1).String())
+				}
+				audited = true
+			}
+			c.SetContext(ctx)
+		}
+		var span *tracing.Span
+		if s.tracer != nil {
+			var ctx context.Context
+			ctx, span = s.tracer.Start(c.Context(), name, tracing.KindServer)
+			c.SetContext(ctx)
+		}
+
+		start := time.Now()
+		ew := &errorWriter{ResponseWriter: w}
+		h(ew, c)
+		d := time.Since(start)
+		s.metrics.ObserveTCP(name, ew.failed, d)
+
+		result := info.ResultOK
+		if ew.missed {
+			result = info.ResultMiss
+		} else if ew.failed {
+			result = info.ResultError
+		}
+		s.info.Record(name, result, d)
+
+		if s.slowlog.Slow(d) {
+			args := make([]string, 0, c.ArgN()+1)
+			args = append(args, c.Name)
+			for _, arg := range c.Args {
+				if sensitiveCommands[name] {
+					args = append(args, "(redacted)")
+				} else {
+					args = append(args, arg.String())
+				}
+			}
+			s.slowlog.Add(d, clientAddr(c), auth.UserFromContext(c.Context()), args)
+		}
+
+		if s.log.Enabled(logging.LevelDebug) {
+			s.log.Debug(c.Context(), "command", "command", name, "client", clientAddr(c),
+				"user", auth.UserFromContext(c.Context()), "duration", d, "error", ew.msg)
+		}
+		if span != nil {
+			span.SetAttribute("command", name)
+			span.SetAttribute("net.peer", clientAddr(c))
+			span.SetAttribute("enduser.id", auth.UserFromContext(c.Context()))
+			if id := logging.RequestID(c.Context()); id != "" {
+				span.SetAttribute("request_id", id)
+			}
+			if ew.failed && !ew.missed {
+				span.SetError(errors.New(ew.msg))
+			}
+			span.End()
+		}
+		if audited {
+			err := s.audit.Log(logging.Record{
+				RequestID: logging.RequestID(c.Context()),
+				Proto:     logging.ProtoTCP,
+				User:      auth.UserFromContext(c.Context()),
+				Client:    clientAddr(c),
+				Op:        name,
+				Key:       logging.AuditKey(c.Context()),
+				OK:        !ew.failed,
+				Error:     ew.msg,
+			})
+			if err != nil {
+				s.log.Error(c.Context(), "audit log write failed", "error", err)
+			}
+		}
+	}
+}
+
+// mutating команда изменяет данные, пользователей или настройки
+func mutating(name string, category auth.Category, c *resp.Command) bool {
+	if category == auth.Write {
+		return true
+	}
+	if subcommands, ok := adminMutations[name]; ok && c.ArgN() > 1 {
+		return subcommands[strings.ToLower(c.Arg(0).String())]
+	}
+	return false
+}
+
+// clientAddr адрес клиента, выполняющего команду
+func clientAddr(c *resp.Command) string {
+	if client := redeo.GetClient(c.Context()); client != nil {
+		return client.RemoteAddr().String()
+	}
+	return ""
+}
+
+// errorWriter отмечает ответы с ошибкой и обращения к отсутствующему ключу
+type errorWriter struct {
+	resp.ResponseWriter
+	failed bool
+	missed bool
+	// msg первая ошибка ответа
+	msg string
+}
+
+func (w *errorWriter) AppendError(msg string) {
+	if !w.failed {
+		w.msg = msg
+	}
+	w.failed = true
+	if msg == structs.ErrKeyNotFound.Error() {
+		w.missed = true
+	}
+	w.ResponseWriter.AppendError(msg)
+}
@@ -1,15 +1,26 @@
 package tcpserver
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"github.com/bsm/redeo"
 	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/info"
+	"github.com/geraev/gokvserver/logging"
+	"github.com/geraev/gokvserver/metrics"
+	"github.com/geraev/gokvserver/slowlog"
 	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tracing"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 const (
@@ -18,6 +29,9 @@ Examples:
   set string new_key string_value
   set list planets '{"value": ["earth","jupiter","saturn"], "ttl": 10000}'
   set dictionary planets_map '{"value": ["earth":2220,"jupiter":3899,"saturn":23000], "ttl": 10000}'
+  set list bytes '{"value": ["AAE=", "/w=="], "encoding": "base64"}'
+Strings are stored byte for byte: values with spaces or binary data are sent as a single bulk string,
+list items and dictionary keys and values with binary data are sent in base64
 `
 
 	errKeysMsg = `Get all keys
@@ -25,6 +39,12 @@ Example:
   keys
 `
 
+	errKeysRangeMsg = `Get keys in ascending order by prefix and range [start, end)
+Examples:
+  keysrange prefix user: limit 100
+  keysrange start user:100 end user:200
+`
+
 	errKeyMsg = `Get value for key (and internal key)
 Example:
   key <key>
@@ -32,51 +52,340 @@ Example:
 )
 
 type BodyList struct {
-	Value []string `json:"value" binding:"required"`
+	Value    []string `json:"value" binding:"required"`
+	Encoding string   `json:"encoding"`
 }
 
 type BodyDictionary struct {
-	Value map[string]string `json:"value" binding:"required"`
+	Value    map[string]string `json:"value" binding:"required"`
+	Encoding string            `json:"encoding"`
 }
 
 type SetTTLBody struct {
 	Value uint64 `json:"value" binding:"required"`
 }
 
+var errServerClosed = errors.New("tcpserver: server closed")
+
 type Server struct {
-	port    string
-	storage structs.Storage
+	port     string
+	storage  structs.Storage
+	guard    structs.Guard
+	users    *auth.Store
+	handlers map[string]redeo.Handler
+	// tlsConfig приём соединений по TLS, nil - без шифрования
+	tlsConfig *tls.Config
+	metrics   *metrics.Metrics
+	info      *info.Info
+	slowlog   *slowlog.Log
+	tiered    structs.TieredStorage
+	inspector structs.InspectStorage
+	log       *logging.Logger
+	audit     *logging.Audit
+	tracer    *tracing.Tracer
+	// maxValueSize ограничение размера значения в байтах, 0 - без ограничения
+	maxValueSize int64
+	// requestTimeout ограничение времени операций с хранилищем для команды, 0 - без ограничения
+	requestTimeout int64
+
+	mu        sync.Mutex
+	closing   bool
+	listeners map[net.Listener]struct{}
+	// conns открытые соединения и количество чтений на момент последней проверки простоя
+	conns map[*conn]uint64
 }
 
 func NewServer(port string, storage structs.Storage) *Server {
 	return &Server{
-		port:    port,
-		storage: storage,
+		port:      port,
+		storage:   storage,
+		handlers:  make(map[string]redeo.Handler),
+		listeners: make(map[net.Listener]struct{}),
+		conns:     make(map[*conn]uint64),
 	}
 }
 
-func (s *Server) Run() error {
-	srv := redeo.NewServer(nil)
-	srv.Handle("ping", redeo.Ping())
-	srv.Handle("echo", redeo.Echo())
-	srv.Handle("info", redeo.Info(srv))
+// SetGuard установка проверки, выполняемой перед каждой операцией над ключами
+func (s *Server) SetGuard(guard structs.Guard) {
+	s.guard = guard
+}
 
-	srv.HandleFunc("set", s.set)
-	srv.HandleFunc("keys", s.getKeys)
-	srv.HandleFunc("key", s.getElement)
-	srv.HandleFunc("ikey", s.getInternalElement)
+// SetUsers включение проверки прав пользователей и команд auth и acl. Соединение до команды auth
+// работает от имени пользователя auth.DefaultUser. Вызывается до Run
+func (s *Server) SetUsers(users *auth.Store) {
+	s.users = users
+}
 
-	srv.HandleFunc("expire", s.expire)
-	srv.HandleFunc("remove", s.deleteKey)
+// SetTLSConfig приём соединений по TLS. Проверенный сертификат клиента, CommonName которого совпадает
+// с именем пользователя, заменяет команду auth. Вызывается до Run
+func (s *Server) SetTLSConfig(cfg *tls.Config) {
+	s.tlsConfig = cfg
+}
+
+// SetMetrics учёт команд и соединений в метриках. Вызывается до Run
+func (s *Server) SetMetrics(m *metrics.Metrics) {
+	s.metrics = m
+}
+
+// SetInfo команда info со сведениями info вместо сведений redeo и учёт выполненных команд.
+// Вызывается до Run
+func (s *Server) SetInfo(i *info.Info) {
+	s.info = i
+}
+
+// SetSlowlog запись медленных команд в журнал и команда slowlog. Вызывается до Run
+func (s *Server) SetSlowlog(l *slowlog.Log) {
+	s.slowlog = l
+}
+
+// SetTier команда tier для статистики и закрепления ключей дискового уровня хранилища. Вызывается до Run
+func (s *Server) SetTier(t structs.TieredStorage) {
+	s.tiered = t
+}
+
+// SetInspector команда inspect со сведениями о представлении значения ключа (кодировка, сжатие,
+// место хранения). Ключи передаются хранилищу как есть, без обёрток. Вызывается до Run
+func (s *Server) SetInspector(i structs.InspectStorage) {
+	s.inspector = i
+}
+
+// SetLogger запись выполненных команд уровня debug с идентификатором команды. Вызывается до Run
+func (s *Server) SetLogger(l *logging.Logger) {
+	s.log = l
+}
 
+// SetAudit запись изменяющих команд в журнал аудита. Вызывается до Run
+func (s *Server) SetAudit(a *logging.Audit) {
+	s.audit = a
+}
+
+// SetTracer спаны команд и вызовов хранилища. Вызывается до Run
+func (s *Server) SetTracer(t *tracing.Tracer) {
+	s.tracer = t
+}
+
+// store хранилище для вызовов обработчиков с контекстом команды
+func (s *Server) store() structs.ContextStorage {
+	storage := structs.WithContext(s.storage)
+	if s.tracer != nil {
+		storage = tracing.Storage(storage)
+	}
+	return storage
+}
+
+// Clients количество открытых соединений
+func (s *Server) Clients() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+// SetMaxValueSize ограничение размера записываемого значения в байтах, 0 - без ограничения.
+// Может вызываться во время работы сервера
+func (s *Server) SetMaxValueSize(n int64) {
+	atomic.StoreInt64(&s.maxValueSize, n)
+}
+
+// Handle регистрация дополнительной команды категории auth.Admin. Вызывается до Run
+func (s *Server) Handle(name string, h redeo.Handler) {
+	s.handlers[name] = h
+}
+
+func (s *Server) Run() error {
 	lis, err := net.Listen("tcp", ":"+s.port)
 	if err != nil {
 		return err
 	}
+	return s.Serve(lis)
+}
+
+// Serve обработка соединений, принимаемых lis. После Shutdown возвращает nil
+func (s *Server) Serve(lis net.Listener) error {
+	srv := redeo.NewServer(nil)
+	handle := func(name string, category auth.Category, h redeo.HandlerFunc) {
+		srv.Handle(name, s.withContext(s.observe(name, category, s.authorized(category, h))))
+	}
+	handle("ping", "", redeo.Ping().ServeRedeo)
+	handle("echo", "", redeo.Echo().ServeRedeo)
+	if s.info != nil {
+		handle("info", auth.Admin, s.info.ServeRedeo)
+	} else {
+		handle("info", auth.Admin, redeo.Info(srv).ServeRedeo)
+	}
+
+	handle("set", auth.Write, s.set)
+	handle("keys", auth.Read, s.getKeys)
+	handle("keysrange", auth.Read, s.getKeysRange)
+	handle("key", auth.Read, s.getElement)
+	handle("ikey", auth.Read, s.getInternalElement)
+	if s.inspector != nil {
+		handle("inspect", auth.Read, s.inspect)
+	}
+
+	handle("expire", auth.Write, s.expire)
+	handle("remove", auth.Write, s.deleteKey)
+	handle("asking", "", s.asking)
+	if s.slowlog != nil {
+		handle("slowlog", auth.Admin, s.slowlog.ServeRedeo)
+	}
+	if s.tiered != nil {
+		handle("tier", auth.Admin, s.tier)
+	}
+	if s.users != nil {
+		handle("auth", "", s.authenticate)
+		handle("acl", "", s.users.ServeRedeo)
+	}
+
+	if streams, ok := s.storage.(structs.StreamStorage); ok {
+		s.handleStreams(handle, streams)
+	}
+	for name, h := range s.handlers {
+		handle(name, auth.Admin, h.ServeRedeo)
+	}
+
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		lis.Close()
+		return nil
+	}
+	s.listeners[lis] = struct{}{}
+	s.mu.Unlock()
 	defer lis.Close()
 
+	accept := lis
+	if s.tlsConfig != nil {
+		accept = tls.NewListener(lis, s.tlsConfig)
+	}
 	log.Printf("waiting for connections on %s", lis.Addr().String())
-	return srv.Serve(lis)
+	err := srv.Serve(&trackedListener{Listener: accept, s: s})
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.listeners, lis)
+	if s.closing {
+		// Остановка через Shutdown
+		return nil
+	}
+	return err
+}
+
+// authorized передача пользователя соединения в контекст команды и проверка категории команды.
+// Команды без категории доступны всем
+func (s *Server) authorized(category auth.Category, h redeo.HandlerFunc) redeo.HandlerFunc {
+	if s.users == nil {
+		return h
+	}
+	return func(w resp.ResponseWriter, c *resp.Command) {
+		user := auth.DefaultUser
+		if client := redeo.GetClient(c.Context()); client != nil {
+			if s.tlsConfig != nil {
+				s.certificateLogin(client)
+			}
+			user = auth.UserFromContext(client.Context())
+		}
+		c.SetContext(auth.WithUser(c.Context(), user))
+		if category != "" {
+			if err := s.users.Can(user, category); err != nil {
+				w.AppendError(err.Error())
+				return
+			}
+		}
+		h(w, c)
+	}
+}
+
+type certificateCheckedKey struct{}
+
+// certificateLogin вход пользователя по сертификату клиента перед первой командой соединения.
+// Сертификат пользователя, которого нет на сервере, не меняет пользователя соединения
+func (s *Server) certificateLogin(client *redeo.Client) {
+	ctx := client.Context()
+	if ctx.Value(certificateCheckedKey{}) != nil {
+		return
+	}
+	ctx = context.WithValue(ctx, certificateCheckedKey{}, true)
+	if addr, ok := client.RemoteAddr().(connAddr); ok {
+		if name, ok := addr.c.certificateUser(); ok {
+			if _, ok := s.users.User(name); ok {
+				ctx = auth.WithUser(ctx, name)
+			}
+		}
+	}
+	client.SetContext(ctx)
+}
+
+// authenticate вход пользователя: auth <password> для пользователя auth.DefaultUser или auth <user> <password>
+func (s *Server) authenticate(w resp.ResponseWriter, c *resp.Command) {
+	var name, password string
+	switch c.ArgN() {
+	case 1:
+		name, password = auth.DefaultUser, c.Arg(0).String()
+	case 2:
+		name, password = c.Arg(0).String(), c.Arg(1).String()
+	default:
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	client := redeo.GetClient(c.Context())
+	if client == nil {
+		w.AppendError(structs.ErrNotSupported.Error())
+		return
+	}
+	if err := s.users.Authenticate(name, password); err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	client.SetContext(auth.WithUser(client.Context(), name))
+	w.AppendOK()
+}
+
+// check проверка возможности выполнить команду над ключом. При отказе записывает ошибку в ответ
+func (s *Server) check(w resp.ResponseWriter, c *resp.Command, key string, write bool) bool {
+	if write {
+		logging.SetAuditKey(c.Context(), key)
+	}
+	if s.users != nil {
+		category := auth.Read
+		if write {
+			category = auth.Write
+		}
+		if err := s.users.Authorize(auth.UserFromContext(c.Context()), category, key); err != nil {
+			w.AppendError(err.Error())
+			return false
+		}
+	}
+	if s.guard == nil {
+		return true
+	}
+	if client := redeo.GetClient(c.Context()); client != nil {
+		if parent, ok := client.Context().Value(askingParentKey{}).(context.Context); ok {
+			// Флаг ASKING действует на одну команду
+			client.SetContext(parent)
+			c.SetContext(structs.WithAsking(c.Context()))
+		}
+	}
+	if err := s.guard(c.Context(), key, write); err != nil {
+		w.AppendError(err.Error())
+		return false
+	}
+	return true
+}
+
+type askingParentKey struct{}
+
+// asking следующая команда клиента выполняется над ключом импортируемого слота кластера
+func (s *Server) asking(w resp.ResponseWriter, c *resp.Command) {
+	client := redeo.GetClient(c.Context())
+	if client == nil {
+		w.AppendError(structs.ErrNotSupported.Error())
+		return
+	}
+	if _, ok := client.Context().Value(askingParentKey{}).(context.Context); !ok {
+		parent := client.Context()
+		client.SetContext(context.WithValue(parent, askingParentKey{}, parent))
+	}
+	w.AppendOK()
 }
 
 // getKeys получение списка ключей из кеша
@@ -86,9 +395,65 @@ func (s *Server) getKeys(w resp.ResponseWriter, c *resp.Command) {
 		w.AppendError(errKeysMsg)
 		return
 	}
+	if !s.check(w, c, "", false) {
+		return
+	}
 
-	result := s.storage.GetKeys()
-	w.AppendInlineString(strings.Join(result, ", "))
+	result, err := s.store().GetKeysContext(c.Context())
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendArrayLen(len(result))
+	for _, key := range result {
+		w.AppendBulkString(key)
+	}
+}
+
+// getKeysRange ключи диапазона по возрастанию
+// keysrange [prefix <prefix>] [start <start>] [end <end>] [limit <limit>]
+func (s *Server) getKeysRange(w resp.ResponseWriter, c *resp.Command) {
+	var r structs.KeyRange
+	for i := 0; i < c.ArgN(); i += 2 {
+		if i+1 == c.ArgN() {
+			w.AppendError(errSyntaxMsg)
+			w.AppendError(errKeysRangeMsg)
+			return
+		}
+		val := c.Arg(i + 1).String()
+		switch strings.ToLower(c.Arg(i).String()) {
+		case "prefix":
+			r.Prefix = val
+		case "start":
+			r.Start = val
+		case "end":
+			r.End = val
+		case "limit":
+			limit, err := strconv.Atoi(val)
+			if err != nil || limit < 0 {
+				w.AppendError(errSyntaxMsg)
+				return
+			}
+			r.Limit = limit
+		default:
+			w.AppendError(errSyntaxMsg)
+			w.AppendError(errKeysRangeMsg)
+			return
+		}
+	}
+	if !s.check(w, c, "", false) {
+		return
+	}
+
+	result, err := structs.KeysRange(c.Context(), s.store(), r)
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendArrayLen(len(result))
+	for _, key := range result {
+		w.AppendBulkString(key)
+	}
 }
 
 // getKey получение элемента из кеша по ключу
@@ -99,30 +464,43 @@ func (s *Server) getElement(w resp.ResponseWriter, c *resp.Command) {
 		return
 	}
 	var (
-		key   = c.Arg(0).String()
-		value []byte
+		key = c.Arg(0).String()
 	)
+	if !s.check(w, c, key, false) {
+		return
+	}
 
-	val, err := s.storage.GetElement(key)
+	val, err := s.store().GetElementContext(c.Context(), key)
 	if err != nil {
 		w.AppendError(err.Error())
 		return
 	}
 
-	switch val.(type) {
+	// Элементы передаются bulk строками побайтово: список - массивом,
+	// словарь - массивом пар ключ, значение в порядке ключей
+	switch v := val.(type) {
 	case string:
-		value = []byte(fmt.Sprintf("%v", val))
-	case []string, map[string]string:
-		value, err = json.Marshal(val)
-		if err != nil {
-			w.AppendError(err.Error())
-			return
+		w.AppendBulkString(v)
+	case []string:
+		w.AppendArrayLen(len(v))
+		for _, item := range v {
+			w.AppendBulkString(item)
+		}
+	case map[string]string:
+		fields := make([]string, 0, len(v))
+		for field := range v {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		w.AppendArrayLen(len(fields))
+		for _, field := range fields {
+			w.AppendArrayLen(2)
+			w.AppendBulkString(field)
+			w.AppendBulkString(v[field])
 		}
 	default:
 		w.AppendError("something wrong: type error")
-		return
 	}
-	w.AppendInline(value)
 }
 
 // getInternalElement получение внутреннего элемента из списка (по индексу) или словаря (по ключу)
@@ -137,8 +515,11 @@ func (s *Server) getInternalElement(w resp.ResponseWriter, c *resp.Command) {
 		key         = c.Arg(0).String()
 		internalKey = c.Arg(1).String()
 	)
+	if !s.check(w, c, key, false) {
+		return
+	}
 
-	vartype, err := s.storage.GetType(key)
+	vartype, err := s.store().GetTypeContext(c.Context(), key)
 	if err != nil {
 		w.AppendError(err.Error())
 		return
@@ -151,13 +532,13 @@ func (s *Server) getInternalElement(w resp.ResponseWriter, c *resp.Command) {
 			w.AppendError(err.Error())
 			return
 		}
-		val, err = s.storage.GetListElement(key, int(index))
+		val, err = s.store().GetListElementContext(c.Context(), key, int(index))
 		if err != nil {
 			w.AppendError(err.Error())
 			return
 		}
 	case structs.Dictionary:
-		val, err = s.storage.GetDictionaryElement(key, internalKey)
+		val, err = s.store().GetDictionaryElementContext(c.Context(), key, internalKey)
 		if err != nil {
 			w.AppendError(err.Error())
 			return
@@ -167,7 +548,7 @@ func (s *Server) getInternalElement(w resp.ResponseWriter, c *resp.Command) {
 		return
 	}
 
-	w.AppendInlineString(val)
+	w.AppendBulkString(val)
 }
 
 // expire установка времени жизни ключа
@@ -179,13 +560,19 @@ func (s *Server) expire(w resp.ResponseWriter, c *resp.Command) {
 	var (
 		key = c.Arg(0).String()
 	)
+	if !s.check(w, c, key, true) {
+		return
+	}
 	val, err := c.Arg(1).Int()
 	if err != nil {
 		w.AppendError(err.Error())
 		return
 	}
 
-	s.storage.SetExpired(key, uint64(val))
+	if err := s.store().SetExpiredContext(c.Context(), key, uint64(val)); err != nil {
+		w.AppendError(err.Error())
+		return
+	}
 
 	w.AppendOK()
 }
@@ -199,46 +586,72 @@ func (s *Server) set(w resp.ResponseWriter, c *resp.Command) {
 	}
 
 	var (
-		vartype   = c.Arg(0).String()
-		key       = c.Arg(1).String()
-		val       []byte
-		isUpdated bool
+		vartype = c.Arg(0).String()
+		key     = c.Arg(1).String()
+		val     []byte
+		err     error
 	)
+	if !s.check(w, c, key, true) {
+		return
+	}
 
-	for _, item := range c.Args[2:] {
-		val = append(append(val, " "...), item.Bytes()...)
+	if vartype == "string" {
+		// Строка сохраняется побайтово, без склейки аргументов
+		if c.ArgN() != 3 {
+			w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+			w.AppendError(errSetMsg)
+			return
+		}
+		val = c.Arg(2).Bytes()
+	} else {
+		// Пробелы между токенами JSON не важны, аргументы inline команды склеиваются
+		for i, item := range c.Args[2:] {
+			if i > 0 {
+				val = append(val, ' ')
+			}
+			val = append(val, item.Bytes()...)
+		}
+	}
+	if max := atomic.LoadInt64(&s.maxValueSize); max > 0 && int64(len(val)) > max {
+		w.AppendError(structs.ErrValueTooLarge.Error())
+		return
 	}
 
 	switch vartype {
 	case "string":
-		_, isUpdated = s.storage.PutOrUpdateString(key, string(val))
+		_, _, err = s.store().PutOrUpdateStringContext(c.Context(), key, string(val))
 	case "list":
 		var value BodyList
-		err := json.Unmarshal(val, &value)
-		if err != nil {
+		if err := json.Unmarshal(val, &value); err != nil {
 			w.AppendError(err.Error())
 			return
 		}
-		_, isUpdated = s.storage.PutOrUpdateList(key, value.Value)
+		if value.Value, err = decodeList(value.Encoding, value.Value); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		_, _, err = s.store().PutOrUpdateListContext(c.Context(), key, value.Value)
 	case "dictionary":
 		var value BodyDictionary
-		err := json.Unmarshal(val, &value)
-		if err != nil {
+		if err := json.Unmarshal(val, &value); err != nil {
 			w.AppendError(err.Error())
 			return
 		}
-		_, isUpdated = s.storage.PutOrUpdateDictionary(key, value.Value)
+		if value.Value, err = decodeDictionary(value.Encoding, value.Value); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		_, _, err = s.store().PutOrUpdateDictionaryContext(c.Context(), key, value.Value)
 	default:
 		w.AppendError(redeo.UnknownCommand(c.Name))
 		w.AppendError(errSetMsg)
 		return
 	}
-
-	if isUpdated {
-		w.AppendError(fmt.Sprintf("key %s was updated", key))
-	} else {
-		w.AppendInlineString(fmt.Sprintf("key %s was set", key))
+	if err != nil {
+		w.AppendError(err.Error())
+		return
 	}
+	w.AppendOK()
 }
 
 // deleteKey удаление ключа из кеша
@@ -251,6 +664,13 @@ func (s *Server) deleteKey(w resp.ResponseWriter, c *resp.Command) {
 	var (
 		key = c.Arg(0).String()
 	)
+	if !s.check(w, c, key, true) {
+		return
+	}
 
-	s.storage.RemoveElement(key)
+	if err := s.store().RemoveElementContext(c.Context(), key); err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendOK()
 }
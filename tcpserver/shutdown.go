@@ -0,0 +1,165 @@
+package tcpserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/metrics"
+)
+
+// shutdownPollInterval период проверки простаивающих соединений при остановке сервера
+const shutdownPollInterval = 20 * time.Millisecond
+
+// trackedListener регистрирует принятые соединения на сервере
+type trackedListener struct {
+	net.Listener
+	s *Server
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	cn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &conn{Conn: cn, s: l.s, ctx: ctx, cancel: cancel}
+	if !l.s.addConn(c) {
+		cancel()
+		cn.Close()
+		return nil, errServerClosed
+	}
+	return c, nil
+}
+
+// conn соединение клиента. Соединение простаивает, пока ожидает данные следующей команды:
+// ответы на предыдущие команды к этому моменту уже отправлены
+type conn struct {
+	net.Conn
+	s *Server
+	// ctx отменяется при закрытии соединения и прерывает выполняемую команду
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	reading int32
+	reads   uint64
+	closed  int32
+}
+
+func (c *conn) Read(p []byte) (int, error) {
+	atomic.StoreInt32(&c.reading, 1)
+	n, err := c.Conn.Read(p)
+	atomic.AddUint64(&c.reads, 1)
+	atomic.StoreInt32(&c.reading, 0)
+	return n, err
+}
+
+// RemoteAddr адрес клиента, по которому обработчик команды находит соединение
+func (c *conn) RemoteAddr() net.Addr {
+	return connAddr{Addr: c.Conn.RemoteAddr(), c: c}
+}
+
+// certificateUser имя пользователя из проверенного сертификата клиента TLS. К первой команде
+// установка соединения уже завершена
+func (c *conn) certificateUser() (string, bool) {
+	tc, ok := c.Conn.(*tls.Conn)
+	if !ok {
+		return "", false
+	}
+	state := tc.ConnectionState()
+	return auth.CertificateUser(&state)
+}
+
+// connAddr адрес клиента вместе с соединением
+type connAddr struct {
+	net.Addr
+	c *conn
+}
+
+func (c *conn) Close() error {
+	c.cancel()
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		c.s.removeConn(c)
+		c.s.metrics.ClientDisconnected(metrics.ProtoTCP)
+	}
+	return c.Conn.Close()
+}
+
+func (s *Server) addConn(c *conn) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closing {
+		return false
+	}
+	s.conns[c] = ^uint64(0)
+	s.metrics.ClientConnected(metrics.ProtoTCP)
+	return true
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.mu.Lock()
+	delete(s.conns, c)
+	s.mu.Unlock()
+}
+
+// Shutdown остановка сервера: прекращается приём соединений, затем закрываются соединения
+// по мере завершения выполняемых команд. По истечении ctx оставшиеся соединения закрываются принудительно
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	s.mu.Unlock()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for !s.closeIdle() {
+		select {
+		case <-ctx.Done():
+			s.closeAll()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// closeIdle закрытие соединений, простаивающих с предыдущей проверки. Соединение, на котором
+// за это время не прочитано ни одного байта, не ждёт продолжения конвейера команд
+func (s *Server) closeIdle() bool {
+	var idle []*conn
+	s.mu.Lock()
+	for c, seen := range s.conns {
+		reads := atomic.LoadUint64(&c.reads)
+		if atomic.LoadInt32(&c.reading) == 1 && reads == seen {
+			idle = append(idle, c)
+			delete(s.conns, c)
+			continue
+		}
+		s.conns[c] = reads
+	}
+	done := len(s.conns) == 0
+	s.mu.Unlock()
+
+	for _, c := range idle {
+		c.cancel()
+		c.Conn.Close()
+	}
+	return done
+}
+
+func (s *Server) closeAll() {
+	s.mu.Lock()
+	conns := s.conns
+	s.conns = make(map[*conn]uint64)
+	s.mu.Unlock()
+
+	for c := range conns {
+		c.cancel()
+		c.Conn.Close()
+	}
+}
@@ -0,0 +1,101 @@
+package tcpserver
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+// newTestServer сервер с командой slow, отвечающей после закрытия release
+func newTestServer(t *testing.T, release <-chan struct{}) (*Server, string, <-chan error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer("", mapbased.NewStorage())
+	s.Handle("slow", redeo.HandlerFunc(func(w resp.ResponseWriter, c *resp.Command) {
+		<-release
+		w.AppendInlineString("done")
+	}))
+	served := make(chan error, 1)
+	go func() { served <- s.Serve(lis) }()
+	return s, lis.Addr().String(), served
+}
+
+// command отправка команды без ожидания ответа
+func command(t *testing.T, addr, name string) (net.Conn, resp.ResponseReader) {
+	cn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { cn.Close() })
+	w := resp.NewRequestWriter(cn)
+	w.WriteCmdString(name)
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return cn, resp.NewResponseReader(cn)
+}
+
+func TestServer_Shutdown(t *testing.T) {
+	release := make(chan struct{})
+	s, addr, served := newTestServer(t, release)
+
+	// Простаивающее соединение: ответ на ping получен, следующей команды нет
+	_, idle := command(t, addr, "ping")
+	if got, err := idle.ReadBulkString(); err != nil || got != "PONG" {
+		t.Fatalf("ping = %q, %v", got, err)
+	}
+	_, busy := command(t, addr, "slow")
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- s.Shutdown(context.Background()) }()
+
+	// Простаивающее соединение закрывается сразу, выполняемая команда продолжается
+	if _, err := idle.PeekType(); err == nil {
+		t.Error("idle connection is still open")
+	}
+	select {
+	case err := <-stopped:
+		t.Fatalf("Shutdown() = %v before the slow command finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := net.Dial("tcp", addr); err == nil {
+		t.Error("new connection is accepted after Shutdown")
+	}
+
+	close(release)
+	if got, err := busy.ReadInlineString(); err != nil || got != "done" {
+		t.Errorf("slow = %q, %v, want the reply of the in-flight command", got, err)
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("Shutdown() = %v", err)
+	}
+	if err := <-served; err != nil {
+		t.Errorf("Serve() = %v, want nil after Shutdown", err)
+	}
+}
+
+func TestServer_ShutdownTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	s, addr, _ := newTestServer(t, release)
+
+	_, busy := command(t, addr, "slow")
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if _, err := busy.PeekType(); err == nil {
+		t.Error("connection is still open after the shutdown timeout")
+	}
+}
@@ -0,0 +1,486 @@
+package tcpserver
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/structs"
+)
+
+const (
+	errSyntaxMsg = "syntax error"
+
+	errStreamsMsg = `Streams
+Examples:
+  xadd events [maxlen 1000] * user 42 action login
+  xrange events - + [count 10]
+  xread [count 10] [block 5000] streams events 0
+  xlen events
+  xtrim events maxlen 1000
+  xgroup create events workers $ [mkstream]
+  xgroup destroy events workers
+  xreadgroup group workers alice [count 10] [block 5000] [noack] streams events >
+  xack events workers 1526569495631-0
+  xpending events workers
+  xclaim events workers bob 60000 1526569495631-0
+`
+)
+
+// handleStreams регистрация команд для работы с потоками
+func (s *Server) handleStreams(handle func(name string, category auth.Category, h redeo.HandlerFunc), streams structs.StreamStorage) {
+	h := &streamHandlers{Server: s, streams: streams}
+
+	handle("xadd", auth.Write, h.add)
+	handle("xrange", auth.Read, h.rangeOf)
+	handle("xread", auth.Read, h.read)
+	handle("xlen", auth.Read, h.len)
+	handle("xtrim", auth.Write, h.trim)
+	handle("xgroup", auth.Write, h.group)
+	handle("xreadgroup", auth.Write, h.readGroup)
+	handle("xack", auth.Write, h.ack)
+	handle("xpending", auth.Read, h.pending)
+	handle("xclaim", auth.Write, h.claim)
+}
+
+type streamHandlers struct {
+	*Server
+	streams structs.StreamStorage
+}
+
+// add добавление записи в поток
+// xadd <key> [maxlen [~] <count>] <id|*> <field> <value> [<field> <value> ...]
+func (h *streamHandlers) add(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 4 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		w.AppendError(errStreamsMsg)
+		return
+	}
+
+	var (
+		key    = c.Arg(0).String()
+		args   = c.Args[1:]
+		maxLen = -1
+	)
+	if strings.EqualFold(args[0].String(), "maxlen") {
+		args = args[1:]
+		if len(args) > 0 && args[0].String() == "~" {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			w.AppendError(errSyntaxMsg)
+			return
+		}
+		n, err := args[0].Int()
+		if err != nil || n < 0 {
+			w.AppendError(errSyntaxMsg)
+			return
+		}
+		maxLen = int(n)
+		args = args[1:]
+	}
+	if len(args) < 3 || len(args)%2 != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if !h.check(w, c, key, true) {
+		return
+	}
+
+	fields := make(map[string]string, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields[args[i].String()] = args[i+1].String()
+	}
+
+	id, err := h.streams.StreamAddTrim(key, args[0].String(), fields, maxLen)
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendBulkString(id.String())
+}
+
+// rangeOf получение записей потока в интервале идентификаторов
+// xrange <key> <start> <end> [count <count>]
+func (h *streamHandlers) rangeOf(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 3 && c.ArgN() != 5 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		w.AppendError(errStreamsMsg)
+		return
+	}
+
+	count := 0
+	if c.ArgN() == 5 {
+		if !strings.EqualFold(c.Arg(3).String(), "count") {
+			w.AppendError(errSyntaxMsg)
+			return
+		}
+		n, err := c.Arg(4).Int()
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		count = int(n)
+	}
+
+	if !h.check(w, c, c.Arg(0).String(), false) {
+		return
+	}
+
+	entries, err := h.streams.StreamRange(c.Arg(0).String(), c.Arg(1).String(), c.Arg(2).String(), count)
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	appendEntries(w, entries)
+}
+
+// read чтение новых записей из одного или нескольких потоков
+// xread [count <count>] [block <milliseconds>] streams <key> [<key> ...] <id> [<id> ...]
+func (h *streamHandlers) read(w resp.ResponseWriter, c *resp.Command) {
+	opts, err := parseReadOptions(c.Args)
+	if err != nil {
+		w.AppendError(err.Error())
+		w.AppendError(errStreamsMsg)
+		return
+	}
+
+	for _, key := range opts.keys {
+		if !h.check(w, c, key, false) {
+			return
+		}
+	}
+
+	var result map[string][]structs.StreamEntry
+	if reader, ok := h.streams.(structs.ContextStreamReader); ok {
+		result, err = reader.StreamReadContext(c.Context(), opts.keys, opts.ids, opts.count, opts.block)
+	} else {
+		result, err = h.streams.StreamRead(opts.keys, opts.ids, opts.count, opts.block)
+	}
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	appendStreams(w, opts.keys, result)
+}
+
+// len количество записей в потоке
+// xlen <key>
+func (h *streamHandlers) len(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 1 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if !h.check(w, c, c.Arg(0).String(), false) {
+		return
+	}
+
+	n, err := h.streams.StreamLen(c.Arg(0).String())
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+// trim ограничение длины потока
+// xtrim <key> maxlen [~] <count>
+func (h *streamHandlers) trim(w resp.ResponseWriter, c *resp.Command) {
+	args := c.Args
+	if len(args) == 4 && args[2].String() == "~" {
+		args = append(args[:2:2], args[3])
+	}
+	if len(args) != 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		w.AppendError(errStreamsMsg)
+		return
+	}
+	if !strings.EqualFold(args[1].String(), "maxlen") {
+		w.AppendError(errSyntaxMsg)
+		return
+	}
+	maxLen, err := args[2].Int()
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	if !h.check(w, c, args[0].String(), true) {
+		return
+	}
+
+	n, err := h.streams.StreamTrim(args[0].String(), int(maxLen))
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+// group управление группами потребителей
+// xgroup create <key> <group> <id|$> [mkstream]
+// xgroup destroy <key> <group>
+func (h *streamHandlers) group(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		w.AppendError(errStreamsMsg)
+		return
+	}
+
+	var (
+		key   = c.Arg(1).String()
+		group = c.Arg(2).String()
+	)
+	if !h.check(w, c, key, true) {
+		return
+	}
+	switch strings.ToLower(c.Arg(0).String()) {
+	case "create":
+		if c.ArgN() != 4 && c.ArgN() != 5 {
+			w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+			return
+		}
+		mkStream := c.ArgN() == 5 && strings.EqualFold(c.Arg(4).String(), "mkstream")
+		if c.ArgN() == 5 && !mkStream {
+			w.AppendError(errSyntaxMsg)
+			return
+		}
+		if err := h.streams.StreamGroupCreate(key, group, c.Arg(3).String(), mkStream); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	case "destroy":
+		destroyed, err := h.streams.StreamGroupDestroy(key, group)
+		if err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		if destroyed {
+			w.AppendInt(1)
+		} else {
+			w.AppendInt(0)
+		}
+	default:
+		w.AppendError(redeo.UnknownCommand(c.Name + " " + c.Arg(0).String()))
+		w.AppendError(errStreamsMsg)
+	}
+}
+
+// readGroup чтение записей от имени потребителя группы
+// xreadgroup group <group> <consumer> [count <count>] [block <milliseconds>] [noack] streams <key> ... <id> ...
+func (h *streamHandlers) readGroup(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 6 || !strings.EqualFold(c.Arg(0).String(), "group") {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		w.AppendError(errStreamsMsg)
+		return
+	}
+
+	opts, err := parseReadOptions(c.Args[3:])
+	if err != nil {
+		w.AppendError(err.Error())
+		w.AppendError(errStreamsMsg)
+		return
+	}
+
+	for _, key := range opts.keys {
+		if !h.check(w, c, key, true) {
+			return
+		}
+	}
+
+	var result map[string][]structs.StreamEntry
+	group, consumer := c.Arg(1).String(), c.Arg(2).String()
+	if reader, ok := h.streams.(structs.ContextStreamReader); ok {
+		result, err = reader.StreamReadGroupContext(c.Context(), group, consumer, opts.keys, opts.ids, opts.count, opts.block, opts.noAck)
+	} else {
+		result, err = h.streams.StreamReadGroup(group, consumer, opts.keys, opts.ids, opts.count, opts.block, opts.noAck)
+	}
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	appendStreams(w, opts.keys, result)
+}
+
+// ack подтверждение обработки записей
+// xack <key> <group> <id> [<id> ...]
+func (h *streamHandlers) ack(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 3 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if !h.check(w, c, c.Arg(0).String(), true) {
+		return
+	}
+
+	n, err := h.streams.StreamAck(c.Arg(0).String(), c.Arg(1).String(), argStrings(c.Args[2:]))
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	w.AppendInt(int64(n))
+}
+
+// pending список записей, ожидающих подтверждения: идентификатор, потребитель,
+// время с момента выдачи в миллисекундах и количество выдач
+// xpending <key> <group>
+func (h *streamHandlers) pending(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() != 2 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+
+	if !h.check(w, c, c.Arg(0).String(), false) {
+		return
+	}
+
+	pending, err := h.streams.StreamPending(c.Arg(0).String(), c.Arg(1).String())
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+
+	now := time.Now()
+	w.AppendArrayLen(len(pending))
+	for _, p := range pending {
+		w.AppendArrayLen(4)
+		w.AppendBulkString(p.ID.String())
+		w.AppendBulkString(p.Consumer)
+		w.AppendInt(int64(now.Sub(p.DeliveredAt) / time.Millisecond))
+		w.AppendInt(int64(p.DeliveryCount))
+	}
+}
+
+// claim передача зависших записей другому потребителю
+// xclaim <key> <group> <consumer> <min-idle-time> <id> [<id> ...]
+func (h *streamHandlers) claim(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() < 5 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		w.AppendError(errStreamsMsg)
+		return
+	}
+
+	minIdle, err := c.Arg(3).Int()
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+
+	if !h.check(w, c, c.Arg(0).String(), true) {
+		return
+	}
+
+	entries, err := h.streams.StreamClaim(
+		c.Arg(0).String(), c.Arg(1).String(), c.Arg(2).String(),
+		time.Duration(minIdle)*time.Millisecond, argStrings(c.Args[4:]),
+	)
+	if err != nil {
+		w.AppendError(err.Error())
+		return
+	}
+	appendEntries(w, entries)
+}
+
+var errSyntax = errors.New(errSyntaxMsg)
+
+type readOptions struct {
+	count int
+	block time.Duration
+	noAck bool
+	keys  []string
+	ids   []string
+}
+
+// parseReadOptions разбор аргументов xread и xreadgroup
+func parseReadOptions(args []resp.CommandArgument) (*readOptions, error) {
+	opts := new(readOptions)
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i].String()) {
+		case "count", "block":
+			if i+1 == len(args) {
+				return nil, errSyntax
+			}
+			n, err := args[i+1].Int()
+			if err != nil || n < 0 {
+				return nil, errSyntax
+			}
+			if strings.EqualFold(args[i].String(), "count") {
+				opts.count = int(n)
+			} else if n == 0 {
+				opts.block = structs.BlockForever
+			} else {
+				opts.block = time.Duration(n) * time.Millisecond
+			}
+			i++
+		case "noack":
+			opts.noAck = true
+		case "streams":
+			rest := argStrings(args[i+1:])
+			if len(rest) == 0 || len(rest)%2 != 0 {
+				return nil, structs.ErrStreamArgs
+			}
+			opts.keys, opts.ids = rest[:len(rest)/2], rest[len(rest)/2:]
+			return opts, nil
+		default:
+			return nil, errSyntax
+		}
+	}
+	return nil, errSyntax
+}
+
+// appendStreams ответ на чтение нескольких потоков: пары <ключ, записи> в порядке запроса
+// либо nil, если новых записей нет
+func appendStreams(w resp.ResponseWriter, keys []string, result map[string][]structs.StreamEntry) {
+	if len(result) == 0 {
+		w.AppendNil()
+		return
+	}
+
+	w.AppendArrayLen(len(result))
+	for _, key := range keys {
+		entries, ok := result[key]
+		if !ok {
+			continue
+		}
+		w.AppendArrayLen(2)
+		w.AppendBulkString(key)
+		appendEntries(w, entries)
+		// Повторно указанный ключ выводится один раз
+		delete(result, key)
+	}
+}
+
+// appendEntries ответ со списком записей: пары <идентификатор, [поле, значение, ...]>
+func appendEntries(w resp.ResponseWriter, entries []structs.StreamEntry) {
+	w.AppendArrayLen(len(entries))
+	for _, entry := range entries {
+		fields := make([]string, 0, len(entry.Fields))
+		for field := range entry.Fields {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		w.AppendArrayLen(2)
+		w.AppendBulkString(entry.ID.String())
+		w.AppendArrayLen(len(fields) * 2)
+		for _, field := range fields {
+			w.AppendBulkString(field)
+			w.AppendBulkString(entry.Fields[field])
+		}
+	}
+}
+
+func argStrings(args []resp.CommandArgument) []string {
+	result := make([]string, 0, len(args))
+	for _, arg := range args {
+		result = append(result, arg.String())
+	}
+	return result
+}
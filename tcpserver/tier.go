@@ -0,0 +1,53 @@
+package tcpserver
+
+import (
+	"strings"
+
+	"github.com/bsm/redeo"
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/structs"
+)
+
+// tier команда дискового уровня хранилища: tier stats - статистика парами имя, значение,
+// tier pin <key> и tier unpin <key> - закрепление значения ключа в памяти и его снятие
+func (s *Server) tier(w resp.ResponseWriter, c *resp.Command) {
+	if c.ArgN() == 0 {
+		w.AppendError(redeo.WrongNumberOfArgs(c.Name))
+		return
+	}
+	sub := strings.ToLower(c.Arg(0).String())
+	switch {
+	case sub == "stats" && c.ArgN() == 1:
+		stats, _ := s.tiered.TierStats()
+		fields := []struct {
+			name  string
+			value int64
+		}{
+			{"memory_keys", int64(stats.MemoryKeys)},
+			{"memory_bytes", stats.MemoryBytes},
+			{"max_memory", stats.MaxMemory},
+			{"disk_keys", int64(stats.DiskKeys)},
+			{"disk_bytes", stats.DiskBytes},
+			{"pinned_keys", int64(stats.PinnedKeys)},
+			{"spills", int64(stats.Spills)},
+			{"faults", int64(stats.Faults)},
+		}
+		w.AppendArrayLen(len(fields) * 2)
+		for _, f := range fields {
+			w.AppendBulkString(f.name)
+			w.AppendInt(f.value)
+		}
+	case (sub == "pin" || sub == "unpin") && c.ArgN() == 2:
+		pin := structs.Pin
+		if sub == "unpin" {
+			pin = structs.Unpin
+		}
+		if err := pin(c.Context(), s.tiered, c.Arg(1).String()); err != nil {
+			w.AppendError(err.Error())
+			return
+		}
+		w.AppendOK()
+	default:
+		w.AppendError(redeo.UnknownCommand(c.Name + " " + c.Arg(0).String()))
+	}
+}
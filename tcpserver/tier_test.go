@@ -0,0 +1,99 @@
+package tcpserver
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bsm/redeo/resp"
+	"github.com/geraev/gokvserver/mapbased"
+)
+
+func TestServer_Tier(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	if err := storage.SetTier(t.TempDir(), 10); err != nil {
+		t.Fatal(err)
+	}
+	storage.PutOrUpdateString("a", "12345")
+	storage.PutOrUpdateString("b", "12345")
+	srv := NewServer("", storage)
+	srv.SetTier(storage)
+	go srv.Serve(lis)
+
+	tests := []struct {
+		name string
+		args []string
+		// want простой ответ, пустой - ошибка
+		want string
+	}{
+		{name: "pin", args: []string{"pin", "a"}, want: "OK"},
+		{name: "unpin", args: []string{"UNPIN", "a"}, want: "OK"},
+		{name: "pin missing key", args: []string{"pin", "missing"}},
+		{name: "pin without key", args: []string{"pin"}},
+		{name: "unknown subcommand", args: []string{"evict", "a"}},
+		{name: "no subcommand"},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			cn, err := net.Dial("tcp", lis.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer cn.Close()
+			w := resp.NewRequestWriter(cn)
+			w.WriteCmdString("tier", tt.args...)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			cn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			r := resp.NewResponseReader(cn)
+			if tt.want == "" {
+				if typ, _ := r.PeekType(); typ != resp.TypeError {
+					t.Fatalf("reply type = %v, want error", typ)
+				}
+				return
+			}
+			if got, err := r.ReadInlineString(); err != nil || got != tt.want {
+				t.Errorf("reply = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+
+	t.Run("Testing stats", func(t *testing.T) {
+		cn, err := net.Dial("tcp", lis.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer cn.Close()
+		w := resp.NewRequestWriter(cn)
+		w.WriteCmdString("tier", "stats")
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		cn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		r := resp.NewResponseReader(cn)
+		n, err := r.ReadArrayLen()
+		if err != nil {
+			t.Fatal(err)
+		}
+		stats := make(map[string]int64)
+		for i := 0; i < n/2; i++ {
+			name, err := r.ReadBulkString()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if stats[name], err = r.ReadInt(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if stats["memory_keys"] != 1 || stats["disk_keys"] != 1 || stats["max_memory"] != 10 || stats["faults"] != 1 {
+			t.Errorf("stats = %v, want 1 key in memory, 1 on disk and 1 fault", stats)
+		}
+	})
+}
@@ -0,0 +1,191 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// scopeName имя библиотеки инструментирования в OTLP
+const scopeName = "github.com/geraev/gokvserver/tracing"
+
+// Запрос ExportTraceServiceRequest OTLP в представлении JSON
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              Kind           `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            otlpStatus     `json:"status"`
+	}
+	otlpStatus struct {
+		// Code 0 - не задан, 2 - ошибка
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	// otlpAnyValue значение атрибута. Целые числа в JSON OTLP - строки
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// encodeOTLP спаны сервиса service в формате OTLP JSON
+func encodeOTLP(service string, spans []SpanData) ([]byte, error) {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: scopeName}, Spans: make([]otlpSpan, 0, len(spans))}
+	for _, d := range spans {
+		span := otlpSpan{
+			TraceID:           d.TraceID.String(),
+			SpanID:            d.SpanID.String(),
+			Name:              d.Name,
+			Kind:              d.Kind,
+			StartTimeUnixNano: strconv.FormatInt(d.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(d.End.UnixNano(), 10),
+		}
+		if d.ParentID.IsValid() {
+			span.ParentSpanID = d.ParentID.String()
+		}
+		for _, a := range d.Attributes {
+			span.Attributes = append(span.Attributes, otlpKeyValue{Key: a.Key, Value: anyValue(a.Value)})
+		}
+		if d.Error != "" {
+			span.Status = otlpStatus{Code: 2, Message: d.Error}
+		}
+		scope.Spans = append(scope.Spans, span)
+	}
+	return json.Marshal(otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource: otlpResource{Attributes: []otlpKeyValue{
+			{Key: "service.name", Value: anyValue(service)},
+		}},
+		ScopeSpans: []otlpScopeSpans{scope},
+	}}})
+}
+
+func anyValue(v interface{}) otlpAnyValue {
+	integer := func(n int64) otlpAnyValue {
+		s := strconv.FormatInt(n, 10)
+		return otlpAnyValue{IntValue: &s}
+	}
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		return integer(int64(v))
+	case int32:
+		return integer(int64(v))
+	case int64:
+		return integer(v)
+	case uint64:
+		return integer(int64(v))
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case time.Duration:
+		return integer(int64(v))
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+// FileExporter запись спанов в файл: каждый пакет - строка JSON в формате OTLP, которую читает,
+// например, приёмник otlpjsonfile OpenTelemetry Collector
+type FileExporter struct {
+	service string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewFileExporter экспорт спанов сервиса service в out. Если out реализует io.Closer, он закрывается в Close
+func NewFileExporter(out io.Writer, service string) *FileExporter {
+	return &FileExporter{out: out, service: service}
+}
+
+// Export реализация Exporter
+func (e *FileExporter) Export(spans []SpanData) error {
+	data, err := encodeOTLP(e.service, spans)
+	if err != nil {
+		return err
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	_, err = e.out.Write(append(data, '\n'))
+	return err
+}
+
+// Close закрытие файла
+func (e *FileExporter) Close() error {
+	if c, ok := e.out.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// OTLPExporter отправка спанов приёмнику OTLP/HTTP в кодировке JSON, например OpenTelemetry Collector
+type OTLPExporter struct {
+	endpoint string
+	service  string
+	client   *http.Client
+}
+
+// NewOTLPExporter экспорт спанов сервиса service на endpoint, например http://localhost:4318/v1/traces
+func NewOTLPExporter(endpoint, service string) *OTLPExporter {
+	return &OTLPExporter{
+		endpoint: endpoint,
+		service:  service,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Export реализация Exporter
+func (e *OTLPExporter) Export(spans []SpanData) error {
+	data, err := encodeOTLP(e.service, spans)
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", e.endpoint, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package tracing_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/geraev/gokvserver/auth"
+	"github.com/geraev/gokvserver/httpserver"
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/tcpserver"
+	"github.com/geraev/gokvserver/tracing"
+	"github.com/gin-gonic/gin"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func TestTracing_Servers(t *testing.T) {
+	storage := mapbased.NewStorage()
+	defer storage.Close()
+	users, err := auth.FromAccounts(map[string]string{"admin": "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := &recorder{}
+	tracer := tracing.New(exporter, 1)
+
+	srv := httpserver.NewServer("", nil, storage)
+	srv.SetUsers(users)
+	srv.SetTracer(tracer)
+	ts := httptest.NewServer(srv.Handler())
+	defer ts.Close()
+	req, _ := http.NewRequest(http.MethodPut, ts.URL+"/cache/set/string/a", strings.NewReader(`{"value": "hello"}`))
+	req.SetBasicAuth("admin", "secret")
+	req.Header.Set(tracing.HeaderTraceparent, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	tcp := tcpserver.NewServer("", storage)
+	tcp.SetUsers(users)
+	tcp.SetTracer(tracer)
+	go tcp.Serve(lis)
+	cn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cn.Close()
+	r := bufio.NewReader(cn)
+	for _, cmd := range []string{"auth admin secret", "key missing"} {
+		fmt.Fprintf(cn, "%s\r\n", cmd)
+		if _, err := r.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tracer.Close()
+
+	request := exporter.find(t, "PUT /cache/set/string/:key")
+	command := exporter.find(t, "key")
+	tests := []struct {
+		name   string
+		span   tracing.SpanData
+		parent tracing.SpanData
+		attrs  map[string]interface{}
+	}{
+		{
+			name: "http request", span: request,
+			parent: tracing.SpanData{SpanID: tracing.SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}},
+			attrs:  map[string]interface{}{"http.status_code": http.StatusOK, "http.route": "/cache/set/string/:key", "enduser.id": "admin"},
+		},
+		{
+			name: "http storage call", span: exporter.find(t, "storage.PutOrUpdateString"), parent: request,
+			attrs: map[string]interface{}{tracing.AttrKey: "a", tracing.AttrType: "String"},
+		},
+		{
+			name: "tcp command", span: command,
+			attrs: map[string]interface{}{"command": "key", "enduser.id": "admin"},
+		},
+		{
+			name: "tcp storage call", span: exporter.find(t, "storage.GetElement"), parent: command,
+			attrs: map[string]interface{}{tracing.AttrKey: "missing", tracing.AttrHit: false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			if tt.span.ParentID != tt.parent.SpanID {
+				t.Errorf("parent = %s, want %s", tt.span.ParentID, tt.parent.SpanID)
+			}
+			for key, want := range tt.attrs {
+				if got, _ := tt.span.Attribute(key); got != want {
+					t.Errorf("%s = %v, want %v", key, got, want)
+				}
+			}
+			if tt.span.Error != "" {
+				t.Errorf("error = %q", tt.span.Error)
+			}
+		})
+	}
+	if request.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("request trace = %s, want the traceparent trace", request.TraceID)
+	}
+}
@@ -0,0 +1,122 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+)
+
+// Kind вид спана, значения совпадают с SpanKind OTLP
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+	KindClient   Kind = 3
+)
+
+func (k Kind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+// Attribute атрибут спана. Значения - строки, целые и дробные числа, bool, остальное записывается строкой
+type Attribute struct {
+	Key   string
+	Value interface{}
+}
+
+// SpanData завершённый спан, передаваемый экспортёру
+type SpanData struct {
+	Name     string
+	Kind     Kind
+	TraceID  TraceID
+	SpanID   SpanID
+	ParentID SpanID
+	Start    time.Time
+	End      time.Time
+	// Attributes атрибуты в порядке установки, повторная установка заменяет значение
+	Attributes []Attribute
+	// Error ошибка операции, пустая - успешна
+	Error string
+}
+
+// Attribute значение атрибута key
+func (d SpanData) Attribute(key string) (interface{}, bool) {
+	for _, a := range d.Attributes {
+		if a.Key == key {
+			return a.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Span записываемая операция. Методы nil *Span ничего не делают, изменения после End отбрасываются
+type Span struct {
+	tracer *Tracer
+
+	mu    sync.Mutex
+	data  SpanData
+	ended bool
+}
+
+// SpanContext контекст спана для дочерних спанов и других сервисов
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return SpanContext{TraceID: s.data.TraceID, SpanID: s.data.SpanID, Sampled: true}
+}
+
+// SetAttribute установка атрибута
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ended {
+		return
+	}
+	for i := range s.data.Attributes {
+		if s.data.Attributes[i].Key == key {
+			s.data.Attributes[i].Value = value
+			return
+		}
+	}
+	s.data.Attributes = append(s.data.Attributes, Attribute{Key: key, Value: value})
+}
+
+// SetError отметка операции ошибкой err, nil не меняет спан
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.ended {
+		s.data.Error = err.Error()
+	}
+}
+
+// End завершение спана и передача его экспортёру. Повторные вызовы ничего не делают
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	data := s.data
+	s.mu.Unlock()
+	s.tracer.export(data)
+}
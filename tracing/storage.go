@@ -0,0 +1,165 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/geraev/gokvserver/structs"
+)
+
+// Атрибуты спанов хранилища
+const (
+	AttrKey      = "storage.key"
+	AttrType     = "storage.type"
+	AttrHit      = "storage.hit"
+	AttrUpdated  = "storage.updated"
+	AttrLockWait = "storage.lock_wait_us"
+)
+
+// Storage хранилище, вызовы которого записываются спанами, дочерними для спана из контекста вызова.
+// Время ожидания блокировки записывается, если хранилище сообщает его через structs.ReportLockWait.
+// Вызовы без записываемого спана передаются хранилищу как есть
+func Storage(storage structs.ContextStorage) structs.ContextStorage {
+	return traced{storage}
+}
+
+type traced struct {
+	storage structs.ContextStorage
+}
+
+// start спан операции op с ключом key и контекст для её вызова. Без родительского спана
+// возвращаются nil и исходный контекст
+func start(ctx context.Context, op, key string) (*Span, context.Context) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return nil, ctx
+	}
+	_, span := parent.tracer.Start(ctx, "storage."+op, KindInternal)
+	if span == nil {
+		return nil, ctx
+	}
+	if key != "" {
+		span.SetAttribute(AttrKey, key)
+	}
+	return span, structs.WithLockWait(ctx, func(wait time.Duration) {
+		span.SetAttribute(AttrLockWait, wait.Microseconds())
+	})
+}
+
+// read завершение спана чтения: отсутствующий ключ - промах, а не ошибка
+func read(span *Span, t structs.ValueType, err error) {
+	span.SetAttribute(AttrHit, !errors.Is(err, structs.ErrKeyNotFound))
+	if err == nil {
+		span.SetAttribute(AttrType, t.String())
+	} else if !errors.Is(err, structs.ErrKeyNotFound) {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// write завершение спана записи значения типа t
+func write(span *Span, t structs.ValueType, updated bool, err error) {
+	if err != nil {
+		span.SetError(err)
+	} else {
+		span.SetAttribute(AttrType, t.String())
+		span.SetAttribute(AttrUpdated, updated)
+	}
+	span.End()
+}
+
+// end завершение спана операции без результата
+func end(span *Span, err error) {
+	if err != nil {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+func (s traced) GetKeysContext(ctx context.Context) ([]string, error) {
+	span, ctx := start(ctx, "GetKeys", "")
+	keys, err := s.storage.GetKeysContext(ctx)
+	span.SetAttribute("storage.keys", len(keys))
+	end(span, err)
+	return keys, err
+}
+
+func (s traced) KeysRangeContext(ctx context.Context, r structs.KeyRange) ([]string, error) {
+	span, ctx := start(ctx, "KeysRange", r.Prefix)
+	keys, err := structs.KeysRange(ctx, s.storage, r)
+	span.SetAttribute("storage.keys", len(keys))
+	end(span, err)
+	return keys, err
+}
+
+func (s traced) GetElementContext(ctx context.Context, key string) (interface{}, error) {
+	span, ctx := start(ctx, "GetElement", key)
+	val, err := s.storage.GetElementContext(ctx, key)
+	var t structs.ValueType
+	switch val.(type) {
+	case []string:
+		t = structs.List
+	case map[string]string:
+		t = structs.Dictionary
+	}
+	read(span, t, err)
+	return val, err
+}
+
+func (s traced) GetListElementContext(ctx context.Context, key string, index int) (string, error) {
+	span, ctx := start(ctx, "GetListElement", key)
+	val, err := s.storage.GetListElementContext(ctx, key, index)
+	read(span, structs.List, err)
+	return val, err
+}
+
+func (s traced) GetDictionaryElementContext(ctx context.Context, key, internalKey string) (string, error) {
+	span, ctx := start(ctx, "GetDictionaryElement", key)
+	val, err := s.storage.GetDictionaryElementContext(ctx, key, internalKey)
+	read(span, structs.Dictionary, err)
+	return val, err
+}
+
+func (s traced) PutOrUpdateStringContext(ctx context.Context, key, value string) (string, bool, error) {
+	span, ctx := start(ctx, "PutOrUpdateString", key)
+	previous, updated, err := s.storage.PutOrUpdateStringContext(ctx, key, value)
+	write(span, structs.String, updated, err)
+	return previous, updated, err
+}
+
+func (s traced) PutOrUpdateListContext(ctx context.Context, key string, value []string) ([]string, bool, error) {
+	span, ctx := start(ctx, "PutOrUpdateList", key)
+	previous, updated, err := s.storage.PutOrUpdateListContext(ctx, key, value)
+	write(span, structs.List, updated, err)
+	return previous, updated, err
+}
+
+func (s traced) PutOrUpdateDictionaryContext(ctx context.Context, key string, value map[string]string) (map[string]string, bool, error) {
+	span, ctx := start(ctx, "PutOrUpdateDictionary", key)
+	previous, updated, err := s.storage.PutOrUpdateDictionaryContext(ctx, key, value)
+	write(span, structs.Dictionary, updated, err)
+	return previous, updated, err
+}
+
+func (s traced) RemoveElementContext(ctx context.Context, key string) error {
+	span, ctx := start(ctx, "RemoveElement", key)
+	err := s.storage.RemoveElementContext(ctx, key)
+	end(span, err)
+	return err
+}
+
+func (s traced) SetExpiredContext(ctx context.Context, key string, expired uint64) error {
+	span, ctx := start(ctx, "SetExpired", key)
+	err := s.storage.SetExpiredContext(ctx, key, expired)
+	span.SetAttribute("storage.ttl_ms", int64(expired))
+	end(span, err)
+	return err
+}
+
+func (s traced) GetTypeContext(ctx context.Context, key string) (structs.ValueType, error) {
+	span, ctx := start(ctx, "GetType", key)
+	t, err := s.storage.GetTypeContext(ctx, key)
+	read(span, t, err)
+	return t, err
+}
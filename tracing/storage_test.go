@@ -0,0 +1,116 @@
+package tracing_test
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/geraev/gokvserver/mapbased"
+	"github.com/geraev/gokvserver/replication"
+	"github.com/geraev/gokvserver/structs"
+	"github.com/geraev/gokvserver/tracing"
+)
+
+// recorder экспортёр, запоминающий спаны
+type recorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *recorder) Export(spans []tracing.SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// find первый спан с именем name
+func (r *recorder) find(t *testing.T, name string) tracing.SpanData {
+	t.Helper()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, span := range r.spans {
+		if span.Name == name {
+			return span
+		}
+	}
+	t.Fatalf("no span %q among %d spans", name, len(r.spans))
+	return tracing.SpanData{}
+}
+
+func TestStorage(t *testing.T) {
+	mapStorage := mapbased.NewStorage()
+	defer mapStorage.Close()
+	leader := replication.NewLeader(mapStorage, 0)
+	defer leader.Close()
+
+	for _, backend := range []struct {
+		name    string
+		storage structs.Storage
+	}{
+		{name: "mapbased", storage: mapStorage},
+		{name: "replication leader", storage: leader},
+	} {
+		exporter := &recorder{}
+		tracer := tracing.New(exporter, 1)
+		ctx, root := tracer.Start(context.Background(), "request", tracing.KindServer)
+
+		storage := tracing.Storage(structs.WithContext(backend.storage))
+		// Вызов без спана в контексте не записывается
+		storage.GetElementContext(context.Background(), "missing")
+		storage.PutOrUpdateListContext(ctx, "list", []string{"a"})
+		storage.GetElementContext(ctx, "list")
+		storage.GetElementContext(ctx, "missing")
+		storage.GetListElementContext(ctx, "list", 5)
+		root.End()
+		tracer.Close()
+		backend.storage.RemoveElement("list")
+
+		tests := []struct {
+			name  string
+			span  string
+			attrs map[string]interface{}
+			err   string
+		}{
+			{name: "write", span: "storage.PutOrUpdateList", attrs: map[string]interface{}{tracing.AttrKey: "list", tracing.AttrType: "List", tracing.AttrUpdated: false}},
+			{name: "hit", span: "storage.GetElement", attrs: map[string]interface{}{tracing.AttrKey: "list", tracing.AttrType: "List", tracing.AttrHit: true}},
+			{name: "error", span: "storage.GetListElement", attrs: map[string]interface{}{tracing.AttrHit: true}, err: structs.ErrIndexOutOfRange.Error()},
+		}
+		for _, tt := range tests {
+			t.Run("Testing "+backend.name+": "+tt.name, func(t *testing.T) {
+				span := exporter.find(t, tt.span)
+				if span.ParentID != root.SpanContext().SpanID || span.Kind != tracing.KindInternal {
+					t.Errorf("span %+v is not a child of the request span", span)
+				}
+				for key, want := range tt.attrs {
+					if got, _ := span.Attribute(key); got != want {
+						t.Errorf("%s = %v, want %v", key, got, want)
+					}
+				}
+				if _, ok := span.Attribute(tracing.AttrLockWait); !ok {
+					t.Errorf("span %+v has no lock wait", span)
+				}
+				if span.Error != tt.err {
+					t.Errorf("error = %q, want %q", span.Error, tt.err)
+				}
+			})
+		}
+
+		t.Run("Testing "+backend.name+": miss", func(t *testing.T) {
+			exporter.mu.Lock()
+			defer exporter.mu.Unlock()
+			misses := 0
+			for _, span := range exporter.spans {
+				if hit, ok := span.Attribute(tracing.AttrHit); ok && hit == false {
+					misses++
+					if span.Error != "" {
+						t.Errorf("miss recorded as error %q", span.Error)
+					}
+				}
+			}
+			if misses != 1 {
+				t.Errorf("got %d misses, want 1", misses)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+// Package tracing распределённая трассировка запросов: контекст W3C Trace Context (заголовок traceparent),
+// спаны обработчиков и вызовов хранилища, экспорт в формате OTLP/HTTP JSON или в файл.
+// Без трассировщика (nil *Tracer) спаны не создаются
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// HeaderTraceparent заголовок HTTP с контекстом трассировки
+const HeaderTraceparent = "traceparent"
+
+// ErrTraceparent неверный заголовок traceparent
+var ErrTraceparent = errors.New("invalid traceparent")
+
+// TraceID идентификатор трассировки
+type TraceID [16]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid идентификатор не нулевой
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+// SpanID идентификатор спана
+type SpanID [8]byte
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// IsValid идентификатор не нулевой
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// SpanContext контекст спана, передаваемый между сервисами
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	// Sampled трассировка записывается
+	Sampled bool
+}
+
+// IsValid заданы идентификаторы трассировки и спана
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+// Traceparent значение заголовка traceparent версии 00
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+// ParseTraceparent разбор заголовка traceparent: версия-trace_id-parent_id-флаги. Поля после флагов
+// допускаются только для версий новее 00
+func ParseTraceparent(h string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 {
+		return sc, ErrTraceparent
+	}
+	version, err := decodeHex(parts[0], 1)
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(parts) != 4) {
+		return sc, ErrTraceparent
+	}
+	traceID, err := decodeHex(parts[1], len(sc.TraceID))
+	if err != nil {
+		return sc, ErrTraceparent
+	}
+	spanID, err := decodeHex(parts[2], len(sc.SpanID))
+	if err != nil {
+		return sc, ErrTraceparent
+	}
+	flags, err := decodeHex(parts[3], 1)
+	if err != nil {
+		return sc, ErrTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+	if !sc.IsValid() {
+		return SpanContext{}, ErrTraceparent
+	}
+	return sc, nil
+}
+
+// decodeHex n байт из строчных шестнадцатеричных цифр
+func decodeHex(s string, n int) ([]byte, error) {
+	if len(s) != 2*n || strings.ToLower(s) != s {
+		return nil, ErrTraceparent
+	}
+	return hex.DecodeString(s)
+}
+
+type (
+	spanKey   struct{}
+	remoteKey struct{}
+)
+
+// ContextWithRemote контекст с родителем из другого сервиса, например из заголовка traceparent
+func ContextWithRemote(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteKey{}, sc)
+}
+
+// SpanFromContext записываемый спан контекста, nil - спана нет
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SpanContextFromContext контекст текущего спана: записываемого, незаписываемого или удалённого родителя
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if ctx == nil {
+		return SpanContext{}
+	}
+	if span := SpanFromContext(ctx); span != nil {
+		return span.SpanContext()
+	}
+	sc, _ := ctx.Value(remoteKey{}).(SpanContext)
+	return sc
+}
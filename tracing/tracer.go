@@ -0,0 +1,197 @@
+package tracing
+
+import (
+	"context"
+	crand "crypto/rand"
+	"encoding/binary"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// queueSize завершённые спаны, ожидающие экспорта. При переполнении спаны отбрасываются
+	queueSize = 4096
+	// batchSize наибольшее количество спанов в одном экспорте
+	batchSize = 512
+	// flushInterval период экспорта неполных пакетов
+	flushInterval = time.Second
+)
+
+// Exporter получатель завершённых спанов. Вызывается из одной горутины трассировщика
+type Exporter interface {
+	Export(spans []SpanData) error
+}
+
+// Tracer трассировщик: создаёт спаны и пакетами передаёт завершённые экспортёру в фоне
+type Tracer struct {
+	exporter Exporter
+	// threshold трассировки без родителя записываются, если младшие 8 байт идентификатора меньше порога
+	threshold uint64
+	all       bool
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+
+	queue   chan SpanData
+	flush   chan chan struct{}
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+	dropped uint64
+}
+
+// New трассировщик, записывающий долю ratio (от 0 до 1) трассировок без родителя. Для трассировок
+// с родителем решение принимает родитель
+func New(exporter Exporter, ratio float64) *Tracer {
+	var seed [8]byte
+	crand.Read(seed[:])
+	t := &Tracer{
+		exporter: exporter,
+		all:      ratio >= 1,
+		rnd:      rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(seed[:])))),
+		queue:    make(chan SpanData, queueSize),
+		flush:    make(chan chan struct{}),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	if ratio > 0 && ratio < 1 {
+		t.threshold = uint64(ratio * math.MaxUint64)
+	}
+	go t.run()
+	return t
+}
+
+// Start спан name, дочерний для спана или удалённого родителя из ctx. Для незаписываемой трассировки
+// спан nil, а контекст передаёт решение дочерним спанам. Nil *Tracer спаны не создаёт
+func (t *Tracer) Start(ctx context.Context, name string, kind Kind) (context.Context, *Span) {
+	if t == nil {
+		return ctx, nil
+	}
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{TraceID: parent.TraceID, Sampled: parent.Sampled}
+
+	t.mu.Lock()
+	if !parent.IsValid() {
+		binary.BigEndian.PutUint64(sc.TraceID[:8], t.rnd.Uint64())
+		binary.BigEndian.PutUint64(sc.TraceID[8:], t.rnd.Uint64())
+	}
+	binary.BigEndian.PutUint64(sc.SpanID[:], t.rnd.Uint64()|1)
+	t.mu.Unlock()
+
+	if !parent.IsValid() {
+		sc.Sampled = t.all || binary.BigEndian.Uint64(sc.TraceID[8:]) < t.threshold
+	}
+	if !sc.Sampled {
+		return context.WithValue(ctx, remoteKey{}, sc), nil
+	}
+
+	span := &Span{tracer: t, data: SpanData{
+		Name:    name,
+		Kind:    kind,
+		TraceID: sc.TraceID,
+		SpanID:  sc.SpanID,
+		Start:   time.Now(),
+	}}
+	if parent.IsValid() {
+		span.data.ParentID = parent.SpanID
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// export постановка завершённого спана в очередь экспорта
+func (t *Tracer) export(data SpanData) {
+	select {
+	case t.queue <- data:
+	default:
+		atomic.AddUint64(&t.dropped, 1)
+	}
+}
+
+// Dropped количество спанов, отброшенных из-за переполнения очереди
+func (t *Tracer) Dropped() uint64 {
+	if t == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&t.dropped)
+}
+
+func (t *Tracer) run() {
+	defer close(t.stopped)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	batch := make([]SpanData, 0, batchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.exporter.Export(batch); err != nil {
+			log.Printf("tracing: export of %d spans failed: %v", len(batch), err)
+		}
+		batch = make([]SpanData, 0, batchSize)
+	}
+	// drain экспорт всех спанов очереди
+	drain := func() {
+		for {
+			select {
+			case data := <-t.queue:
+				if batch = append(batch, data); len(batch) == batchSize {
+					send()
+				}
+			default:
+				send()
+				return
+			}
+		}
+	}
+	for {
+		select {
+		case data := <-t.queue:
+			if batch = append(batch, data); len(batch) == batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case reply := <-t.flush:
+			drain()
+			close(reply)
+		case <-t.done:
+			drain()
+			return
+		}
+	}
+}
+
+// Flush экспорт всех завершённых спанов
+func (t *Tracer) Flush() {
+	if t == nil {
+		return
+	}
+	reply := make(chan struct{})
+	select {
+	case t.flush <- reply:
+		<-reply
+	case <-t.stopped:
+	}
+}
+
+// Close экспорт завершённых спанов и остановка трассировщика. Экспортёр, реализующий io.Closer, закрывается
+func (t *Tracer) Close() error {
+	if t == nil {
+		return nil
+	}
+	var err error
+	t.once.Do(func() {
+		close(t.done)
+		<-t.stopped
+		if c, ok := t.exporter.(io.Closer); ok {
+			err = c.Close()
+		}
+	})
+	return err
+}
@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder экспортёр, запоминающий спаны
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) Export(spans []SpanData) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.spans = append(r.spans, spans...)
+	return nil
+}
+
+// get спаны по имени
+func (r *recorder) get(name string) []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var result []SpanData
+	for _, span := range r.spans {
+		if span.Name == name {
+			result = append(result, span)
+		}
+	}
+	return result
+}
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		sampled bool
+		wantErr bool
+	}{
+		{name: "sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", sampled: true},
+		{name: "not sampled", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"},
+		{name: "future version with extra fields", header: "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-09-extra", sampled: true},
+		{name: "extra fields in version 00", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra", wantErr: true},
+		{name: "forbidden version", header: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero trace id", header: "00-00000000000000000000000000000000-00f067aa0ba902b7-01", wantErr: true},
+		{name: "zero span id", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", wantErr: true},
+		{name: "uppercase", header: "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", wantErr: true},
+		{name: "short span id", header: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902-01", wantErr: true},
+		{name: "empty", header: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(tt.header)
+			if tt.wantErr {
+				if !errors.Is(err, ErrTraceparent) {
+					t.Errorf("error = %v, want %v", err, ErrTraceparent)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" || sc.Sampled != tt.sampled {
+				t.Errorf("ParseTraceparent() = %+v", sc)
+			}
+		})
+	}
+
+	sc, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	if got := sc.Traceparent(); got != "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01" {
+		t.Errorf("Traceparent() = %q", got)
+	}
+}
+
+func TestTracer(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter, 1)
+
+	ctx, root := tracer.Start(context.Background(), "root", KindServer)
+	_, child := tracer.Start(ctx, "child", KindInternal)
+	child.SetAttribute("a", 1)
+	child.SetAttribute("a", 2)
+	child.SetError(errors.New("boom"))
+	child.End()
+	child.SetAttribute("after end", true)
+	child.End()
+	root.End()
+	tracer.Flush()
+
+	roots, children := exporter.get("root"), exporter.get("child")
+	if len(roots) != 1 || len(children) != 1 {
+		t.Fatalf("exported %d root and %d child spans, want 1 and 1", len(roots), len(children))
+	}
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{name: "same trace", ok: children[0].TraceID == roots[0].TraceID},
+		{name: "parent", ok: children[0].ParentID == roots[0].SpanID && !roots[0].ParentID.IsValid()},
+		{name: "replaced attribute", ok: len(children[0].Attributes) == 1 && children[0].Attributes[0].Value == 2},
+		{name: "error", ok: children[0].Error == "boom" && roots[0].Error == ""},
+		{name: "kind", ok: roots[0].Kind == KindServer && children[0].Kind == KindInternal},
+		{name: "times", ok: !children[0].End.Before(children[0].Start) && !roots[0].End.Before(children[0].End)},
+	}
+	for _, tt := range tests {
+		t.Run("Testing "+tt.name, func(t *testing.T) {
+			if !tt.ok {
+				t.Errorf("root = %+v, child = %+v", roots[0], children[0])
+			}
+		})
+	}
+	if err := tracer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTracer_Sampling(t *testing.T) {
+	exporter := &recorder{}
+	tracer := New(exporter, 0)
+	defer tracer.Close()
+
+	ctx, span := tracer.Start(context.Background(), "dropped", KindServer)
+	if span != nil {
+		t.Fatal("span recorded with zero ratio")
+	}
+	if sc := SpanContextFromContext(ctx); !sc.IsValid() || sc.Sampled {
+		t.Errorf("unsampled context = %+v, want valid and not sampled", sc)
+	}
+	if _, child := tracer.Start(ctx, "dropped child", KindInternal); child != nil {
+		t.Error("child of unsampled span recorded")
+	}
+
+	remote, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	_, span = tracer.Start(ContextWithRemote(context.Background(), remote), "remote", KindServer)
+	if span == nil {
+		t.Fatal("span with sampled remote parent not recorded")
+	}
+	span.End()
+	tracer.Flush()
+	if spans := exporter.get("remote"); len(spans) != 1 || spans[0].TraceID != remote.TraceID || spans[0].ParentID != remote.SpanID {
+		t.Errorf("remote child = %+v", spans)
+	}
+
+	var nilTracer *Tracer
+	if _, span := nilTracer.Start(context.Background(), "nil", KindServer); span != nil {
+		t.Error("nil tracer created a span")
+	}
+	span = nil
+	span.SetAttribute("a", 1)
+	span.End()
+}
+
+// otlpSpans спаны запроса OTLP JSON
+func otlpSpans(t *testing.T, data []byte) []otlpSpan {
+	var req otlpRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		t.Fatal(err)
+	}
+	if len(req.ResourceSpans) != 1 || len(req.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("request = %s", data)
+	}
+	return req.ResourceSpans[0].ScopeSpans[0].Spans
+}
+
+func TestExporters(t *testing.T) {
+	start := time.Unix(1, 0)
+	spans := []SpanData{{
+		Name:       "GET /cache/keys",
+		Kind:       KindServer,
+		TraceID:    TraceID{1},
+		SpanID:     SpanID{2},
+		Start:      start,
+		End:        start.Add(time.Millisecond),
+		Attributes: []Attribute{{Key: "http.status_code", Value: 500}, {Key: "ok", Value: false}},
+		Error:      "Internal Server Error",
+	}}
+
+	var buf bytes.Buffer
+	if err := NewFileExporter(&buf, "gokv").Export(spans); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"gokv"}}]}`) {
+		t.Errorf("file exporter output %s has no service name", buf.String())
+	}
+	got := otlpSpans(t, buf.Bytes())
+	want := `{"traceId":"01000000000000000000000000000000","spanId":"0200000000000000","name":"GET /cache/keys","kind":2,` +
+		`"startTimeUnixNano":"1000000000","endTimeUnixNano":"1001000000",` +
+		`"attributes":[{"key":"http.status_code","value":{"intValue":"500"}},{"key":"ok","value":{"boolValue":false}}],` +
+		`"status":{"code":2,"message":"Internal Server Error"}}`
+	if data, _ := json.Marshal(got[0]); string(data) != want {
+		t.Errorf("span = %s, want %s", data, want)
+	}
+
+	status := http.StatusOK
+	var received []byte
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request %s %s with content type %q", r.Method, r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		received, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+	exporter := NewOTLPExporter(ts.URL+"/v1/traces", "gokv")
+	if err := exporter.Export(spans); err != nil {
+		t.Fatal(err)
+	}
+	if got := otlpSpans(t, received); len(got) != 1 || got[0].Name != "GET /cache/keys" {
+		t.Errorf("received %s", received)
+	}
+	status = http.StatusServiceUnavailable
+	if err := exporter.Export(spans); err == nil {
+		t.Error("export to failing collector succeeded")
+	}
+}